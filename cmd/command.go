@@ -14,20 +14,20 @@ limitations under the License.
 package cmd
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/Telefonica/kube-graffiti/pkg/config"
-	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/Telefonica/kube-graffiti/pkg/engine"
 	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
 	"github.com/Telefonica/kube-graffiti/pkg/healthcheck"
 	"github.com/Telefonica/kube-graffiti/pkg/log"
-	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -49,7 +49,7 @@ var (
 		Short:   "Automatically add labels and/or annotations to kubernetes objects",
 		Long:    `Write rules that match labels and object fields and add labels/annotations to kubernetes objects as they are created via a mutating webhook.`,
 		Example: `kube-graffiti --config ./config.yaml`,
-		PreRun:  initRootCmd,
+		PreRunE: initRootCmd,
 		Run:     runRootCmd,
 	}
 )
@@ -63,6 +63,12 @@ func init() {
 	// viper.BindEnv("log-level", "GRAFFITI_LOG_LEVEL")
 	rootCmd.PersistentFlags().Bool("check-existing", false, "[GRAFFITI_CHECK_EXISTING] run rules against existing objects")
 	viper.BindPFlag("check-existing", rootCmd.PersistentFlags().Lookup("check-existing"))
+	rootCmd.PersistentFlags().String("shard", "", "[GRAFFITI_SHARD] only serve the rules assigned to this shard")
+	viper.BindPFlag("shard", rootCmd.PersistentFlags().Lookup("shard"))
+	rootCmd.PersistentFlags().String("profile", "", "[GRAFFITI_PROFILE] use the config, kubeconfig and context of this profile")
+	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
+	rootCmd.PersistentFlags().String("profiles-file", "", "[GRAFFITI_PROFILES_FILE] the file of profiles (default is ~/"+defaultProfilesFile+")")
+	viper.BindPFlag("profiles-file", rootCmd.PersistentFlags().Lookup("profiles-file"))
 
 	// set up Viper environment variable binding...
 	replacer := strings.NewReplacer("-", "_", ".", "_")
@@ -74,55 +80,77 @@ func init() {
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
-func initRootCmd(_ *cobra.Command, _ []string) {
+func initRootCmd(cmd *cobra.Command, _ []string) error {
 	log.InitLogger(viper.GetString("log-level"))
+	return applyProfile(cmd)
 }
 
 // runRootCmd is the main program which starts up our services and waits for them to complete
 func runRootCmd(_ *cobra.Command, _ []string) {
 	mylog := log.ComponentLogger(componentName, "runRootCmd")
 
+	mylog.Debug().Msg("getting kubernetes client")
+	kubeClient, restConfig := getKubeClients()
+
 	mylog.Info().Str("file", viper.GetString("config")).Msg("reading configuration file")
-	config, err := loadConfig(viper.GetString("config"))
+	config, err := loadConfigOrLastKnownGood(viper.GetString("config"), kubeClient)
 	if err != nil {
-		mylog.Fatal().Err(err).Msg("failed to load config")
+		mylog.Error().Err(err).Msg("failed to load config")
+		os.Exit(exitConfigInvalid)
+	}
+	// the config may route the apiserver through a proxy or trust extra CAs, so the clients are made again
+	if err := egress.Set(config.Egress); err != nil {
+		mylog.Error().Err(err).Msg("invalid egress configuration")
+		os.Exit(exitConfigInvalid)
 	}
+	kubeClient, restConfig = getKubeClients()
 
 	mylog.Info().Str("level", viper.GetString("log-level")).Msg("Setting log-level to configured level")
+	if err := log.Configure(config.Logging); err != nil {
+		mylog.Error().Err(err).Msg("failed to configure the log sinks")
+		os.Exit(exitConfigInvalid)
+	}
 	log.ChangeLogLevel(viper.GetString("log-level"))
 	mylog = log.ComponentLogger(componentName, "runRootCmd")
 	mylog.Info().Str("log-level", viper.GetString("log-level")).Msg("This is the log level")
 
 	mylog.Info().Msg("configuration read ok")
-	mylog.Debug().Msg("validating config")
-	if err := config.ValidateConfig(); err != nil {
-		mylog.Fatal().Err(err).Msg("failed to validate config")
-	}
-
-	mylog.Debug().Msg("getting kubernetes client")
-	kubeClient, restConfig := getKubeClients()
 	// Setup and start the health-checker
-	healthChecker := healthcheck.NewHealthChecker(healthcheck.NewCutDownNamespaceClient(kubeClient), viper.GetInt("health-checker.port"), viper.GetString("health-checker.path"))
-	healthChecker.StartHealthChecker()
-
-	// Setup and start the mutating webhook server
-	if err := initWebhookServer(config, kubeClient); err != nil {
-		mylog.Fatal().Err(err).Msg("webhook server failed to start")
+	healthChecker := healthcheck.NewHealthChecker(viper.GetInt("health-checker.port"), viper.GetString("health-checker.path"), healthcheck.NewNamespaceChecker(kubeClient)).
+		WithReadinessChecks(
+			healthcheck.NewCertificateChecker(viper.GetString("server.cert-path"), viper.GetString("server.key-path")),
+			healthcheck.NewRulesRegisteredChecker(metrics.Rules),
+		).
+		WithTLS(config.HealthChecker.TLS)
+	if config.Server.SelfCheck.Enabled {
+		selfCheck := config.Server.SelfCheckConfig()
+		mylog.Info().Str("address", selfCheck.Address).Str("server-name", selfCheck.ServerName).Bool("review", selfCheck.Review).Msg("checking that our webhook can be called before we are ready")
+		healthChecker = healthChecker.WithReadinessChecks(healthcheck.NewSelfChecker(selfCheck, config.Server.CACertPath))
 	}
-
-	if err := initExistingCheck(config, restConfig); err != nil {
-		mylog.Fatal().Err(err).Msg("failed to check existing namespaces")
+	if config.Server.DebugToken != "" {
+		mylog.Info().Str("path", log.LevelsPath).Msg("log levels can be overridden with the debug token")
+		healthChecker.Handle(log.LevelsPath, log.NewLevelsHandler(config.Server.DebugToken))
 	}
+	healthChecker.StartHealthChecker()
 
-	// wait for an interrupt
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, os.Kill)
-	<-signalChan
-	os.Exit(0)
+	// run the webhook engine until an interrupt or termination signal
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+		sig := <-signalChan
+		mylog.Info().Str("signal", sig.String()).Msg("received signal, shutting down")
+		cancel()
+	}()
+	if err := engine.Run(ctx, engine.Options{Config: config, RestConfig: restConfig}); err != nil {
+		mylog.Error().Err(err).Msg("failed to run the webhook engine")
+		os.Exit(exitCode(err))
+	}
+	os.Exit(exitOK)
 }
 
 // getKubeClients returns client-go clientset and a dynamic client
@@ -130,7 +158,12 @@ func getKubeClients() (*kubernetes.Clientset, *rest.Config) {
 	mylog := log.ComponentLogger(componentName, "getKubeClients")
 	// creates the in-cluster config
 	mylog.Info().Msg("creating kubeconfig")
-	config, err := rest.InClusterConfig()
+	config, ok, err := profileRestConfig()
+	if !ok {
+		if config, err = rest.InClusterConfig(); err == nil {
+			config, err = egress.RestConfig(config)
+		}
+	}
 	if err != nil {
 		panic(err.Error())
 	}
@@ -144,74 +177,6 @@ func getKubeClients() (*kubernetes.Clientset, *rest.Config) {
 	return client, config
 }
 
-func initWebhookServer(c config.Configuration, k *kubernetes.Clientset) error {
-	mylog := log.ComponentLogger(componentName, "initWebhookServer")
-	port := viper.GetInt("server.port")
-
-	mylog.Debug().Int("port", port).Msg("creating a new webhook server")
-	caPath := viper.GetString("server.ca-cert-path")
-	ca, err := ioutil.ReadFile(caPath)
-	if err != nil {
-		mylog.Error().Err(err).Str("path", caPath).Msg("Failed to load ca from file")
-		return errors.New("failed to load ca from file")
-	}
-	mylog.Debug().Str("ca-cert-path", caPath).Msg("loaded ca cert ok")
-	server := webhook.NewServer(
-		viper.GetString("server.company-domain"),
-		viper.GetString("server.namespace"),
-		viper.GetString("server.service"),
-		ca, k,
-		viper.GetInt("server.port"),
-	)
-
-	// add each of the graffiti rules into the mux
-	mylog.Info().Int("count", len(c.Rules)).Msg("loading graffiti rules")
-	for _, rule := range c.Rules {
-		mylog.Info().Str("rule-name", rule.Registration.Name).Msg("adding graffiti rule")
-		server.AddGraffitiRule(graffiti.Rule{
-			Name:     rule.Registration.Name,
-			Matchers: rule.Matchers,
-			Payload:  rule.Payload,
-		})
-	}
-
-	mylog.Info().Int("port", port).Str("server.cert-path", viper.GetString("server.cert-path")).Str("server.key-path", viper.GetString("server.key-path")).Msg("starting webhook secure webserver")
-	server.StartWebhookServer(viper.GetString("server.cert-path"), viper.GetString("server.key-path"))
-
-	mylog.Debug().Msg("waiting 2 seconds")
-	time.Sleep(2 * time.Second)
-
-	// register all rules with the kubernetes apiserver
-	for _, rule := range c.Rules {
-		mylog.Info().Str("name", rule.Registration.Name).Msg("registering rule with api server")
-		err = server.RegisterHook(rule.Registration, k)
-		if err != nil {
-			mylog.Error().Err(err).Str("name", rule.Registration.Name).Msg("failed to register rule with apiserver")
-			return err
-		}
-	}
-
-	return nil
-}
-
-func initExistingCheck(config config.Configuration, r *rest.Config) error {
-	mylog := log.ComponentLogger(componentName, "initExistingCheck")
-
-	var err error
-	if !viper.IsSet("check-existing") || viper.GetString("check-existing") != "true" {
-		mylog.Info().Msg("checking of existing objects is disabled")
-		return nil
-	}
-	if err = existing.InitKubeClients(r); err != nil {
-		return err
-	}
-	existing.ApplyRulesAgainstExistingObjects(config.Rules)
-
-	mylog.Info().Msg("check of existing objects completed successfully")
-
-	return nil
-}
-
 // LoadConfig is reponsible for loading the viper configuration file.
 func loadConfig(file string) (config.Configuration, error) {
 	setDefaults()
@@ -224,25 +189,87 @@ func loadConfig(file string) (config.Configuration, error) {
 		viper.SetConfigName(defaultConfigPath)
 	}
 
-	if err := viper.ReadInConfig(); err != nil {
-		fmt.Println("Can't read config:", err)
-		os.Exit(1)
+	files, many, err := configFiles(file)
+	if err != nil {
+		return config.Configuration{}, err
+	}
+	if many {
+		if err := readConfigFiles(files); err != nil {
+			return config.Configuration{}, err
+		}
+	} else if err := viper.ReadInConfig(); err != nil {
+		return config.Configuration{}, fmt.Errorf("can't read config: %v", err)
+	}
+	if !many && viper.IsSet("include") {
+		if err := setIncludeEgress(viper.GetViper()); err != nil {
+			return config.Configuration{}, err
+		}
+		rules, err := rulesWithIncludes(viper.GetViper(), viper.ConfigFileUsed(), nil)
+		if err != nil {
+			return config.Configuration{}, err
+		}
+		viper.Set("apiVersion", config.CurrentAPIVersion)
+		viper.Set("rules", rules)
 	}
 
-    viper.Debug()
 	return unmarshalFromViperStrict()
 }
 
 func setDefaults() {
+	d := config.Default()
 	viper.SetDefault("log-level", DefaultLogLevel)
 	viper.SetDefault("check-existing", false)
-	viper.SetDefault("server.port", 8443)
-	viper.SetDefault("health-checker.port", 8080)
-	viper.SetDefault("health-checker.path", "/healthz")
-	viper.SetDefault("server.company-domain", "acme.com")
-	viper.SetDefault("server.ca-cert-path", "/ca-cert")
-	viper.SetDefault("server.cert-path", "/server-cert")
-	viper.SetDefault("server.key-path", "/server-key")
+	viper.SetDefault("rule-conflicts", d.RuleConflicts)
+	viper.SetDefault("scope", d.Scope)
+	viper.SetDefault("server.port", d.Server.WebhookPort)
+	viper.SetDefault("health-checker.port", d.HealthChecker.Port)
+	viper.SetDefault("health-checker.path", d.HealthChecker.Path)
+	viper.SetDefault("health-checker.tls.cert-path", d.HealthChecker.TLS.CertPath)
+	viper.SetDefault("health-checker.tls.key-path", d.HealthChecker.TLS.KeyPath)
+	viper.SetDefault("health-checker.tls.client-ca-path", d.HealthChecker.TLS.ClientCAPath)
+	viper.SetDefault("server.company-domain", d.Server.CompanyDomain)
+	viper.SetDefault("server.ca-cert-path", d.Server.CACertPath)
+	viper.SetDefault("server.cert-path", d.Server.ServerCertPath)
+	viper.SetDefault("server.key-path", d.Server.ServerKeyPath)
+	viper.SetDefault("server.shutdown-action", d.Server.ShutdownAction)
+	viper.SetDefault("server.admission-version", d.Server.AdmissionVersion)
+	viper.SetDefault("server.shutdown-timeout", d.Server.ShutdownTimeout)
+	viper.SetDefault("server.registration-check-interval", d.Server.RegistrationCheckInterval)
+	viper.SetDefault("server.max-concurrent-requests", d.Server.MaxConcurrentRequests)
+	viper.SetDefault("server.request-timeout", d.Server.RequestTimeout)
+	viper.SetDefault("server.warmup-budget", d.Server.WarmupBudget)
+	viper.SetDefault("server.max-request-size", d.Server.MaxRequestSize)
+	viper.SetDefault("server.oversized-requests", d.Server.OversizedRequests)
+	viper.SetDefault("server.rule-errors", d.Server.RuleErrors)
+	viper.SetDefault("server.self-check.enabled", d.Server.SelfCheck.Enabled)
+	viper.SetDefault("server.self-check.timeout", d.Server.SelfCheck.Timeout)
+	viper.SetDefault("server.rule-status.configmap", d.Server.RuleStatus.ConfigMap)
+	viper.SetDefault("server.rule-status.interval", d.Server.RuleStatus.Interval)
+	viper.SetDefault("server.source-limits.rate", d.Server.SourceLimits.Rate)
+	viper.SetDefault("server.source-limits.burst", d.Server.SourceLimits.Burst)
+	viper.SetDefault("server.source-limits.action", d.Server.SourceLimits.Action)
+	viper.SetDefault("server.decision-cache.size", d.Server.DecisionCache.Size)
+	viper.SetDefault("server.decision-cache.ttl", d.Server.DecisionCache.TTL)
+	viper.SetDefault("audit.sink", d.Audit.Sink)
+	viper.SetDefault("events.enabled", d.Events.Enabled)
+	viper.SetDefault("events.pod-name", d.Events.PodName)
+	viper.SetDefault("events.pod-namespace", d.Events.PodNamespace)
+	viper.SetDefault("evaluation.port", d.Evaluation.Port)
+	viper.SetDefault("evaluation.cert-path", d.Evaluation.CertPath)
+	viper.SetDefault("evaluation.key-path", d.Evaluation.KeyPath)
+	viper.SetDefault("expiry.interval", d.Expiry.Interval)
+	viper.SetDefault("existing.interval", d.Existing.Interval)
+	viper.SetDefault("existing.page-size", d.Existing.PageSize)
+	viper.SetDefault("existing.workers", d.Existing.Workers)
+	viper.SetDefault("existing.qps", d.Existing.QPS)
+	viper.SetDefault("existing.burst", d.Existing.Burst)
+	viper.SetDefault("existing.progress-interval", d.Existing.ProgressInterval)
+	viper.SetDefault("server.debug-token", d.Server.DebugToken)
+	viper.SetDefault("last-known-good.path", d.LastKnownGood.Path)
+	viper.SetDefault("last-known-good.configmap", d.LastKnownGood.ConfigMap)
+	viper.SetDefault("egress.http-proxy", d.Egress.HTTPProxy)
+	viper.SetDefault("egress.https-proxy", d.Egress.HTTPSProxy)
+	viper.SetDefault("ignore.namespaces", d.Ignore.Namespaces)
 }
 
 func unmarshalFromViperStrict() (config.Configuration, error) {
@@ -260,13 +287,64 @@ func unmarshalFromViperStrict() (config.Configuration, error) {
 	if err := viper.UnmarshalKey("server", &c.Server, opts); err != nil {
 		return c, fmt.Errorf("failed to unmarshal server: %v", err)
 	}
-	if err := viper.UnmarshalKey("health-check", &c.HealthChecker, opts); err != nil {
-		return c, fmt.Errorf("failed to unmarshal health-check: %v", err)
+	if err := viper.UnmarshalKey("health-checker", &c.HealthChecker, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal health-checker: %v", err)
+	}
+	if err := viper.UnmarshalKey("existing", &c.Existing, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal existing: %v", err)
+	}
+	if err := viper.UnmarshalKey("audit", &c.Audit, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal audit: %v", err)
+	}
+	if err := viper.UnmarshalKey("events", &c.Events, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal events: %v", err)
+	}
+	if err := viper.UnmarshalKey("evaluation", &c.Evaluation, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal evaluation: %v", err)
+	}
+	if err := viper.UnmarshalKey("actions", &c.Actions, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal actions: %v", err)
+	}
+	if err := viper.UnmarshalKey("expiry", &c.Expiry, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal expiry: %v", err)
+	}
+	if err := viper.UnmarshalKey("configmaps", &c.ConfigMaps, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal configmaps: %v", err)
+	}
+	if err := viper.UnmarshalKey("last-known-good", &c.LastKnownGood, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal last-known-good: %v", err)
+	}
+	if err := viper.UnmarshalKey("ignore", &c.Ignore, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal ignore: %v", err)
+	}
+	if err := viper.UnmarshalKey("key-prefixes", &c.KeyPrefixes, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal key-prefixes: %v", err)
+	}
+	rules, err := config.MigrateRules(viper.GetString("apiVersion"), viper.Get("rules"))
+	if err != nil {
+		return c, fmt.Errorf("failed to migrate rules: %v", err)
+	}
+	if rules != nil {
+		viper.Set("rules", rules)
+	}
+	c.APIVersion = config.CurrentAPIVersion
+	if err := viper.UnmarshalKey("rule-defaults", &c.RuleDefaults, opts); err != nil {
+		return c, fmt.Errorf("failed to unmarshal rule-defaults: %v", err)
 	}
 	if err := viper.UnmarshalKey("rules", &c.Rules, opts); err != nil {
 		return c, fmt.Errorf("failed to unmarshal rules: %v", err)
 	}
+	c.ApplyRuleDefaults()
+	if err := c.ExpandEnv(os.LookupEnv); err != nil {
+		return c, fmt.Errorf("failed to expand environment variables in rules: %v", err)
+	}
+	c.ApplyKeyPrefixes()
     c.LogLevel = viper.GetString("log-level")
+    c.RuleConflicts = viper.GetString("rule-conflicts")
+	c.Shard = viper.GetString("shard")
+	c.Shards = viper.GetStringSlice("shards")
+	c.Scope = viper.GetString("scope")
+	c.WatchNamespaces = viper.GetStringSlice("watch-namespaces")
     if !viper.IsSet("check-existing") || viper.GetString("check-existing") != "true" {
         c.CheckExisting = false
     } else {
@@ -0,0 +1,49 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRule(name string, selectors ...string) config.Rule {
+	return config.Rule{
+		Registration: webhook.Registration{Name: name, Resources: []string{"pods"}, FailurePolicy: "Ignore"},
+		Matchers:     graffiti.Matchers{LabelSelectors: selectors},
+		Payload:      graffiti.Payload{Block: true},
+	}
+}
+
+func TestSelectRulesByName(t *testing.T) {
+	rules := []config.Rule{testRule("label-pods"), testRule("label-deployments"), testRule("label-services")}
+
+	selected, err := selectRules(rules, nil)
+	require.NoError(t, err)
+	assert.Len(t, selected, 3, "all of the rules are selected by default")
+
+	selected, err = selectRules(rules, []string{"label-services", "label-pods"})
+	require.NoError(t, err)
+	require.Len(t, selected, 2)
+	assert.Equal(t, "label-services", selected[0].Registration.Name)
+	assert.Equal(t, "label-pods", selected[1].Registration.Name)
+
+	_, err = selectRules(rules, []string{"label-nodes"})
+	assert.EqualError(t, err, "there is no valid rule called 'label-nodes'")
+}
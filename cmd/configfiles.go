@@ -0,0 +1,146 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// configExtensions are the extensions of the files that are read from a configuration directory.
+var configExtensions = []string{".yaml", ".yml", ".json", ".toml", ".hcl"}
+
+// configFiles returns the configuration files that --config names when it is a directory, or a glob, in lexical order
+// so that rules are always loaded in the same order.  It returns false when the path is a single file.
+func configFiles(path string) ([]string, bool, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, true, fmt.Errorf("can't read config directory %s: %v", path, err)
+		}
+		var files []string
+		for _, e := range entries {
+			if !e.IsDir() && isConfigFile(e.Name()) {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+		if len(files) == 0 {
+			return nil, true, fmt.Errorf("config directory %s has no yaml, json, toml or hcl files", path)
+		}
+		return files, true, nil
+	}
+	if !strings.ContainsAny(path, "*?[") {
+		return nil, false, nil
+	}
+	files, err := filepath.Glob(path)
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid config glob %s: %v", path, err)
+	}
+	if len(files) == 0 {
+		return nil, true, fmt.Errorf("config glob %s matches no files", path)
+	}
+	sort.Strings(files)
+	return files, true, nil
+}
+
+func isConfigFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range configExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// readConfigFiles reads several configuration files into viper.  Each section of settings, such as 'server', can only
+// be given by one of the files, while the rules of all of the files are listed together in the order of the files.
+// Each file's rules, and those that it includes, are migrated according to their own apiVersion, and a rule name can't be
+// used in more than one file.
+func readConfigFiles(files []string) error {
+	mylog := log.ComponentLogger(componentName, "readConfigFiles")
+	var rules []interface{}
+	sections := map[string]string{}
+	names := map[string]string{}
+	vipers := make([]*viper.Viper, len(files))
+	for i, file := range files {
+		mylog.Debug().Str("file", file).Msg("reading configuration file")
+		v := viper.New()
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("can't read config file %s: %v", file, err)
+		}
+		vipers[i] = v
+
+		settings := v.AllSettings()
+		delete(settings, "rules")
+		delete(settings, "apiversion")
+		delete(settings, "include")
+		for section := range settings {
+			if other, ok := sections[section]; ok {
+				mylog.Error().Str("section", section).Str("file", file).Str("other", other).Msg("section is set in more than one file")
+				return fmt.Errorf("'%s' is set in both config files %s and %s, it can only be set in one", section, other, file)
+			}
+			sections[section] = file
+		}
+		if err := viper.MergeConfigMap(settings); err != nil {
+			return fmt.Errorf("can't merge config file %s: %v", file, err)
+		}
+	}
+
+	// the egress section can be in any of the files, so includes are only fetched once they have all been read
+	for _, v := range vipers {
+		if v.IsSet("include") {
+			if err := setIncludeEgress(viper.GetViper()); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	for i, file := range files {
+		list, err := rulesWithIncludes(vipers[i], file, nil)
+		if err != nil {
+			return err
+		}
+		if list != nil {
+			var named []struct {
+				Registration struct {
+					Name string `mapstructure:"name"`
+				} `mapstructure:"registration"`
+			}
+			if err := mapstructure.Decode(list, &named); err != nil {
+				return fmt.Errorf("the rules of config file %s are invalid: %v", file, err)
+			}
+			for _, r := range named {
+				if other, ok := names[r.Registration.Name]; ok && r.Registration.Name != "" {
+					mylog.Error().Str("rule", r.Registration.Name).Str("file", file).Str("other", other).Msg("rule name is used in more than one file")
+					return fmt.Errorf("rule '%s' in config file %s has the same name as a rule in %s", r.Registration.Name, file, other)
+				}
+				names[r.Registration.Name] = file
+			}
+			rules = append(rules, list...)
+		}
+	}
+	viper.Set("apiVersion", config.CurrentAPIVersion)
+	viper.Set("rules", rules)
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testServerFile = `server:
+  namespace: kube-graffiti
+  service: kube-graffiti
+`
+	testTeamAFile = `apiVersion: v2
+rules:
+- registration:
+    name: label-team-a
+    resources: ["pods"]
+  payload:
+    additions:
+      labels:
+        team: a
+`
+	testTeamBFile = `rules:
+- registration:
+    name: label-team-b
+    resources: ["pods"]
+  matcher:
+    label-selectors: ["team = b"]
+  additions:
+    labels:
+      owner: b
+`
+)
+
+func writeConfigDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	return dir
+}
+
+func TestConfigIsLoadedFromADirectory(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"00-server.yaml": testServerFile,
+		"team-b.yml":     testTeamBFile,
+		"team-a.yaml":    testTeamAFile,
+		"README.md":      "not configuration",
+	})
+
+	viper.Reset()
+	c, err := loadConfig(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "kube-graffiti", c.Server.Namespace)
+	require.Len(t, c.Rules, 2)
+	assert.Equal(t, "label-team-a", c.Rules[0].Registration.Name, "files are read in lexical order")
+	assert.Equal(t, "label-team-b", c.Rules[1].Registration.Name)
+	assert.Equal(t, []string{"team = b"}, c.Rules[1].Matchers.LabelSelectors, "each file's rules are migrated by its own apiVersion")
+
+	viper.Reset()
+	c, err = loadConfig(filepath.Join(dir, "team-*"))
+	require.NoError(t, err)
+	assert.Len(t, c.Rules, 2)
+	assert.Empty(t, c.Server.Namespace)
+}
+
+func TestConfigFilesCanNotRepeatSectionsOrRuleNames(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"a.yaml": testServerFile,
+		"b.yaml": testServerFile,
+	})
+	viper.Reset()
+	_, err := loadConfig(dir)
+	assert.EqualError(t, err, "'server' is set in both config files "+filepath.Join(dir, "a.yaml")+" and "+filepath.Join(dir, "b.yaml")+", it can only be set in one")
+
+	dir = writeConfigDir(t, map[string]string{
+		"a.yaml": testTeamAFile,
+		"b.yaml": testTeamAFile,
+	})
+	viper.Reset()
+	_, err = loadConfig(dir)
+	assert.EqualError(t, err, "rule 'label-team-a' in config file "+filepath.Join(dir, "b.yaml")+" has the same name as a rule in "+filepath.Join(dir, "a.yaml"))
+
+	viper.Reset()
+	_, err = loadConfig(filepath.Join(dir, "*.json"))
+	assert.EqualError(t, err, "config glob "+filepath.Join(dir, "*.json")+" matches no files")
+}
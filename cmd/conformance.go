@@ -0,0 +1,96 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/conformance"
+	"github.com/spf13/cobra"
+)
+
+// conformanceOptions are the endpoint that the conformance cases are sent to, and how to trust it.
+type conformanceOptions struct {
+	url                string
+	caCertPath         string
+	insecureSkipVerify bool
+	timeout            time.Duration
+}
+
+var (
+	conformanceOpts conformanceOptions
+	conformanceCmd  = &cobra.Command{
+		Use:   "conformance",
+		Short: "Check that a webhook endpoint answers admission requests like kube-graffiti",
+		Long: `Send the matrix of AdmissionReview versions, operations and content types, along with malformed requests, to a webhook
+endpoint and check that its responses conform, so that a modified build can be verified before it is rolled out.`,
+		Example:      `kube-graffiti conformance --url https://localhost:8443/graffiti/label-pods --ca-cert ./ca-cert`,
+		PreRunE:      initRootCmd,
+		RunE:         runConformanceCmd,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	f := conformanceCmd.Flags()
+	f.StringVar(&conformanceOpts.url, "url", "", "the url of the webhook endpoint, usually the path of one of its rules")
+	f.StringVar(&conformanceOpts.caCertPath, "ca-cert", "", "the ca certificate that signed the endpoint's certificate")
+	f.BoolVar(&conformanceOpts.insecureSkipVerify, "insecure-skip-verify", false, "don't verify the endpoint's certificate")
+	f.DurationVar(&conformanceOpts.timeout, "timeout", 10*time.Second, "how long to wait for each response")
+	rootCmd.AddCommand(conformanceCmd)
+}
+
+func runConformanceCmd(cmd *cobra.Command, _ []string) error {
+	if conformanceOpts.url == "" {
+		return errors.New("the --url of the webhook endpoint is required")
+	}
+	client, err := conformanceClient(conformanceOpts)
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, result := range conformance.Run(client, conformanceOpts.url) {
+		if result.Err != nil {
+			failed++
+			fmt.Fprintf(cmd.OutOrStdout(), "FAIL %s: %v\n", result.Case, result.Err)
+			continue
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "PASS %s\n", result.Case)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d conformance cases failed", failed)
+	}
+	return nil
+}
+
+func conformanceClient(opts conformanceOptions) (*http.Client, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.insecureSkipVerify}
+	if opts.caCertPath != "" {
+		pem, err := ioutil.ReadFile(opts.caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the ca certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.caCertPath)
+		}
+	}
+	return &http.Client{Timeout: opts.timeout, Transport: &http.Transport{TLSClientConfig: config}}, nil
+}
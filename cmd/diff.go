@@ -0,0 +1,114 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Telefonica/kube-graffiti/pkg/engine"
+	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+// errChangesFound is returned by the diff command when the rules would change existing objects, so that scripts can
+// tell that there are changes from the exit code, like with kubectl diff.
+var errChangesFound = errors.New("the rules would change existing objects")
+
+var (
+	diffColour string
+	diffCmd    = &cobra.Command{
+		Use:   "diff",
+		Short: "Show how the rules would change existing objects, without changing them",
+		Long: `List the existing objects in kubernetes, evaluate the configured rules against them and print a diff of each object that
+would be changed, without changing anything, so that rule changes can be reviewed before they are rolled out.`,
+		Example:      `kube-graffiti diff --config ./config.yaml --rule label-pods --namespace team-a`,
+		PreRunE:      initRootCmd,
+		RunE:         runDiffCmd,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	f := diffCmd.Flags()
+	f.StringSliceVar(&existingOpts.rules, "rule", nil, "the name of a rule to evaluate, all of the rules are evaluated when not set")
+	f.StringSliceVar(&existingOpts.namespaces, "namespace", nil, "only diff objects in this namespace, and the namespace itself")
+	f.StringSliceVar(&existingOpts.kinds, "kinds", nil, "only diff objects of these kinds or resources, e.g. pods,deployments")
+	f.StringVar(&diffColour, "color", "auto", "colour the diff, one of auto, always or never")
+	rootCmd.AddCommand(diffCmd)
+}
+
+func runDiffCmd(cmd *cobra.Command, _ []string) error {
+	mylog := log.ComponentLogger(componentName, "runDiffCmd")
+
+	colour, err := useColour(diffColour, os.Stdout)
+	if err != nil {
+		return err
+	}
+	c, err := loadExistingConfig()
+	if err != nil {
+		return err
+	}
+	if len(c.Existing.Contexts) > 0 {
+		return errors.New("diff compares the objects of a single cluster, choose it with --profile instead of existing contexts")
+	}
+	r, err := existingRestConfig(c.Existing.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to load a kubernetes config: %v", err)
+	}
+
+	var changes int
+	existing.SetFilter(existing.Filter{Namespaces: existingOpts.namespaces, Kinds: existingOpts.kinds})
+	existing.SetDryRun(func(change existing.Change) {
+		changes++
+		if err := printChange(cmd.OutOrStdout(), change, colour); err != nil {
+			mylog.Error().Err(err).Str("object", change.Name()).Msg("failed to diff object")
+		}
+	})
+	mylog.Info().Int("rules", len(c.Rules)).Strs("namespaces", existingOpts.namespaces).Strs("kinds", existingOpts.kinds).Msg("diffing existing objects")
+	if err := engine.CheckExisting(c, r); err != nil {
+		return err
+	}
+	if changes > 0 {
+		return fmt.Errorf("%w: %d changes", errChangesFound, changes)
+	}
+	return nil
+}
+
+// printChange prints the rule and the diff of the object that it would change.
+func printChange(w io.Writer, change existing.Change, colour bool) error {
+	diff, err := change.Diff(colour)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "# rule %s\n%s", change.Rule, diff)
+	return err
+}
+
+// useColour decides whether to colour the diff, 'auto' colours it when it is written to a terminal.
+func useColour(setting string, out *os.File) (bool, error) {
+	switch setting {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		info, err := out.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("invalid color '%s', must be one of auto, always or never", setting)
+}
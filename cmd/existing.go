@@ -0,0 +1,206 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/Telefonica/kube-graffiti/pkg/engine"
+	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/rest"
+)
+
+// errTooManyChanges is returned by the existing command when the rules would change more objects than it is allowed
+// to, and so it changed none of them.
+var errTooManyChanges = errors.New("the rules would change too many existing objects")
+
+// existingOptions selects the rules and objects of a one-shot check of existing objects, and whether the changes are
+// previewed before they are made.
+type existingOptions struct {
+	rules      []string
+	namespaces []string
+	kinds      []string
+	confirm    bool
+	maxChanges int
+}
+
+var (
+	existingOpts existingOptions
+	existingCmd  = &cobra.Command{
+		Use:   "existing",
+		Short: "Apply rules to existing objects and exit",
+		Long: `Apply the configured rules, or a subset of them, to the existing objects in kubernetes and then exit.
+The objects can be narrowed down to some namespaces and kinds, so that it can be run as a Job for a targeted backfill.`,
+		Example: `kube-graffiti existing --config ./config.yaml --rule label-pods --namespace team-a --kinds pods,deployments
+kube-graffiti existing --config ./config.yaml --max-changes 100 --confirm`,
+		PreRunE: initRootCmd,
+		RunE:    runExistingCmd,
+	}
+)
+
+func init() {
+	f := existingCmd.Flags()
+	f.StringSliceVar(&existingOpts.rules, "rule", nil, "the name of a rule to apply, all of the rules are applied when not set")
+	f.StringSliceVar(&existingOpts.namespaces, "namespace", nil, "only apply to objects in this namespace, and the namespace itself")
+	f.StringSliceVar(&existingOpts.kinds, "kinds", nil, "only apply to objects of these kinds or resources, e.g. pods,deployments")
+	f.BoolVar(&existingOpts.confirm, "confirm", false, "list the objects that would be changed and ask before changing them")
+	f.IntVar(&existingOpts.maxChanges, "max-changes", 0, "change nothing if more than this many objects would be changed, 0 is no limit")
+	rootCmd.AddCommand(existingCmd)
+}
+
+func runExistingCmd(cmd *cobra.Command, _ []string) error {
+	mylog := log.ComponentLogger(componentName, "runExistingCmd")
+
+	if existingOpts.maxChanges < 0 {
+		return fmt.Errorf("--max-changes can not be negative")
+	}
+	c, err := loadExistingConfig()
+	if err != nil {
+		return err
+	}
+	var r *rest.Config
+	if len(c.Existing.Contexts) == 0 {
+		if r, err = existingRestConfig(c.Existing.Kubeconfig); err != nil {
+			return fmt.Errorf("failed to load a kubernetes config: %v", err)
+		}
+	}
+	existing.SetFilter(existing.Filter{Namespaces: existingOpts.namespaces, Kinds: existingOpts.kinds})
+	existing.SetWindows(c.Existing.Windows, nil)
+	if existingOpts.confirm || existingOpts.maxChanges > 0 {
+		// too many changes isn't a usage error
+		cmd.SilenceUsage = true
+		mylog.Info().Int("rules", len(c.Rules)).Strs("namespaces", existingOpts.namespaces).Strs("kinds", existingOpts.kinds).Msg("previewing the changes to existing objects")
+		changes, err := previewExisting(c, r)
+		if err != nil {
+			return err
+		}
+		if ok, err := confirmChanges(cmd.InOrStdin(), cmd.OutOrStdout(), changes, existingOpts.confirm, existingOpts.maxChanges); !ok || err != nil {
+			return err
+		}
+	}
+	// the objects can change after the preview, so the check is held to the limit too
+	existing.SetMaxPatches(existingOpts.maxChanges)
+	mylog.Info().Int("rules", len(c.Rules)).Strs("namespaces", existingOpts.namespaces).Strs("kinds", existingOpts.kinds).Msg("checking existing objects")
+	return engine.CheckExisting(c, r)
+}
+
+// previewExisting checks the existing objects without changing them, and returns the rule and name of each object
+// that would be changed.
+func previewExisting(c config.Configuration, r *rest.Config) ([]string, error) {
+	var changes []string
+	existing.SetDryRun(func(change existing.Change) {
+		changes = append(changes, fmt.Sprintf("rule %s: %s", change.Rule, change.Name()))
+	})
+	defer existing.SetDryRun(nil)
+	if err := engine.CheckExisting(c, r); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// confirmChanges decides whether the previewed changes are made.  They aren't when there are more than maxChanges of
+// them, or none, and when confirm is set they are listed and only made if the answer read from in is yes.
+func confirmChanges(in io.Reader, out io.Writer, changes []string, confirm bool, maxChanges int) (bool, error) {
+	if maxChanges > 0 && len(changes) > maxChanges {
+		return false, fmt.Errorf("%w: %d objects would be changed, more than --max-changes %d, so none were", errTooManyChanges, len(changes), maxChanges)
+	}
+	if len(changes) == 0 {
+		_, err := fmt.Fprintln(out, "no objects would be changed")
+		return false, err
+	}
+	if !confirm {
+		return true, nil
+	}
+	for _, change := range changes {
+		fmt.Fprintln(out, change)
+	}
+	fmt.Fprintf(out, "change %d objects? [y/N] ", len(changes))
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read the answer: %v", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	_, err = fmt.Fprintln(out, "no objects were changed")
+	return false, err
+}
+
+// loadExistingConfig loads and validates the configuration, and returns it with only the rules that were chosen.
+func loadExistingConfig() (config.Configuration, error) {
+	c, err := loadConfig(viper.GetString("config"))
+	if err != nil {
+		return c, fmt.Errorf("%w: failed to load config: %v", config.ErrConfigInvalid, err)
+	}
+	log.ChangeLogLevel(viper.GetString("log-level"))
+	if err := c.ValidateConfig(); err != nil {
+		return c, fmt.Errorf("failed to validate config: %w", err)
+	}
+	if err := log.Configure(c.Logging); err != nil {
+		return c, fmt.Errorf("%w: %v", config.ErrConfigInvalid, err)
+	}
+	if err := egress.Set(c.Egress); err != nil {
+		return c, fmt.Errorf("%w: %v", config.ErrConfigInvalid, err)
+	}
+	if err := c.SelectShard(); err != nil {
+		return c, err
+	}
+	if err := engine.ActivateRules(&c); err != nil {
+		return c, err
+	}
+	c.Rules, err = selectRules(c.Rules, existingOpts.rules)
+	return c, err
+}
+
+// selectRules returns the named rules, or all of the rules when no names are given.
+func selectRules(rules []config.Rule, names []string) ([]config.Rule, error) {
+	if len(names) == 0 {
+		return rules, nil
+	}
+	byName := make(map[string]config.Rule)
+	for _, rule := range rules {
+		byName[rule.Registration.Name] = rule
+	}
+	var selected []config.Rule
+	for _, name := range names {
+		rule, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("there is no valid rule called '%s'", name)
+		}
+		selected = append(selected, rule)
+	}
+	return selected, nil
+}
+
+// existingRestConfig uses the cluster of the active profile, the in-cluster config when running as a pod, or else the
+// current context of the kubeconfig.
+func existingRestConfig(kubeconfig string) (*rest.Config, error) {
+	if r, ok, err := profileRestConfig(); ok {
+		return r, err
+	}
+	if r, err := rest.InClusterConfig(); err == nil {
+		return egress.RestConfig(r)
+	}
+	return existing.RestConfigForContext(kubeconfig, "")
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var previewedChanges = []string{
+	"rule label-pods: v1.Pod.team-a.web-1",
+	"rule label-pods: v1.Pod.team-a.web-2",
+	"rule label-namespaces: v1.Namespace.team-a",
+}
+
+func TestConfirmChangesAsksBeforeChangingObjects(t *testing.T) {
+	for answer, ok := range map[string]bool{"y\n": true, "YES\n": true, "n\n": false, "\n": false, "": false} {
+		var out bytes.Buffer
+		proceed, err := confirmChanges(strings.NewReader(answer), &out, previewedChanges, true, 0)
+		require.NoError(t, err)
+		assert.Equal(t, ok, proceed, "answer %q", answer)
+		assert.Contains(t, out.String(), "rule label-pods: v1.Pod.team-a.web-2\n")
+		assert.Contains(t, out.String(), "change 3 objects? [y/N] ")
+		if !ok {
+			assert.Contains(t, out.String(), "no objects were changed")
+		}
+	}
+}
+
+func TestConfirmChangesStopsAtMaxChanges(t *testing.T) {
+	var out bytes.Buffer
+	proceed, err := confirmChanges(strings.NewReader("y\n"), &out, previewedChanges, true, 2)
+	assert.False(t, proceed)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, errTooManyChanges))
+	assert.Contains(t, err.Error(), "3 objects would be changed, more than --max-changes 2, so none were")
+	assert.Empty(t, out.String(), "there is nothing to confirm")
+
+	proceed, err = confirmChanges(strings.NewReader(""), &out, previewedChanges, false, 3)
+	assert.NoError(t, err)
+	assert.True(t, proceed, "changes within the limit are made without asking unless confirming")
+}
+
+func TestConfirmChangesWithNothingToChange(t *testing.T) {
+	var out bytes.Buffer
+	proceed, err := confirmChanges(strings.NewReader("y\n"), &out, nil, true, 5)
+	assert.NoError(t, err)
+	assert.False(t, proceed)
+	assert.Equal(t, "no objects would be changed\n", out.String())
+}
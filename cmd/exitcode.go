@@ -0,0 +1,45 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+)
+
+// The exit codes of kube-graffiti, so that whatever runs it can tell a bad configuration from any other failure.  3 and
+// 4 are no longer used, registration and patch failures are retried or reported per rule rather than stopping us.
+const (
+	exitOK             = 0
+	exitFailure        = 1
+	exitConfigInvalid  = 2
+	exitChangesFound   = 5
+	exitTooManyChanges = 6
+)
+
+// exitCode maps the error that a command failed with to the exit code of the process.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, config.ErrConfigInvalid):
+		return exitConfigInvalid
+	case errors.Is(err, errChangesFound):
+		return exitChangesFound
+	case errors.Is(err, errTooManyChanges):
+		return exitTooManyChanges
+	}
+	return exitFailure
+}
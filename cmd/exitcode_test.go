@@ -0,0 +1,35 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodes(t *testing.T) {
+	assert.Equal(t, exitOK, exitCode(nil))
+	assert.Equal(t, exitFailure, exitCode(errors.New("boom")))
+	assert.Equal(t, exitConfigInvalid, exitCode(fmt.Errorf("invalid configuration: %w", config.Default().ValidateConfig())))
+	assert.Equal(t, exitFailure, exitCode(fmt.Errorf("failed to register webhook after 3 attempts: %w", webhook.ErrRegistrationConflict)))
+	assert.Equal(t, exitFailure, exitCode(fmt.Errorf("%w: failed to apply patch", graffiti.ErrPatchBuild)))
+	assert.Equal(t, exitChangesFound, exitCode(fmt.Errorf("%w: 3 changes", errChangesFound)))
+	assert.Equal(t, exitTooManyChanges, exitCode(fmt.Errorf("%w: 12 objects would be changed", errTooManyChanges)))
+}
@@ -0,0 +1,242 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// explainOptions chooses the object that the rules are explained against.
+type explainOptions struct {
+	file        string
+	fromCluster string
+	namespace   string
+	rules       []string
+}
+
+var (
+	explainOpts explainOptions
+	explainCmd  = &cobra.Command{
+		Use:   "explain",
+		Short: "Explain which rules match an object, and why, and how they would patch it",
+		Long: `Evaluate the configured rules, in the order that they are applied, against a single object read from a yaml or json file
+or fetched from the cluster, and print whether each rule matched, which of its selectors passed or failed, and the patch that
+it would make or whether it would deny the object.  The object is evaluated as if it were being created.`,
+		Example: `kube-graffiti explain --config ./config.yaml --file ./pod.yaml
+kube-graffiti explain --config ./config.yaml --from-cluster pod/nginx -n default`,
+		PreRunE:      initRootCmd,
+		RunE:         runExplainCmd,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	f := explainCmd.Flags()
+	f.StringVar(&explainOpts.file, "file", "", "a yaml or json file of the object")
+	f.StringVar(&explainOpts.fromCluster, "from-cluster", "", "the kind and name of an object in the cluster, e.g. pod/nginx")
+	f.StringVarP(&explainOpts.namespace, "namespace", "n", "default", "the namespace of the object in the cluster")
+	f.StringSliceVar(&explainOpts.rules, "rule", nil, "the name of a rule to explain, all of the rules are explained when not set")
+	rootCmd.AddCommand(explainCmd)
+}
+
+func runExplainCmd(cmd *cobra.Command, _ []string) error {
+	mylog := log.ComponentLogger(componentName, "runExplainCmd")
+
+	if (explainOpts.file == "") == (explainOpts.fromCluster == "") {
+		return errors.New("either --file or --from-cluster must be given")
+	}
+	c, err := loadConfig(viper.GetString("config"))
+	if err != nil {
+		return fmt.Errorf("%w: failed to load config: %v", config.ErrConfigInvalid, err)
+	}
+	log.ChangeLogLevel(viper.GetString("log-level"))
+	if err := c.ValidateConfig(); err != nil {
+		return fmt.Errorf("failed to validate config: %w", err)
+	}
+	graffiti.SetKeyPrefixes(c.KeyPrefixes)
+
+	valid, invalid := c.ValidRules()
+	for name, err := range invalid {
+		mylog.Warn().Str("rule", name).Err(err).Msg("the rule is invalid and is not explained")
+	}
+	rules, err := selectRules(valid, explainOpts.rules)
+	if err != nil {
+		return err
+	}
+	config.SortRules(rules)
+
+	var object []byte
+	if explainOpts.file != "" {
+		object, err = readObjectFile(explainOpts.file)
+	} else {
+		object, err = getClusterObject(c, explainOpts.fromCluster, explainOpts.namespace)
+	}
+	if err != nil {
+		return err
+	}
+	req, err := explainRequest(object)
+	if err != nil {
+		return err
+	}
+	return explainRules(cmd.OutOrStdout(), rules, req)
+}
+
+// readObjectFile reads an object from a yaml or json file and returns it as json.
+func readObjectFile(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the object: %v", err)
+	}
+	var object interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("failed to parse the object in %s: %v", file, err)
+	}
+	if _, ok := stringKeys(object).(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%s does not contain an object", file)
+	}
+	return json.Marshal(stringKeys(object))
+}
+
+// getClusterObject fetches an object named as kind/name from the cluster of the active profile or kubeconfig.
+func getClusterObject(c config.Configuration, kindName, namespace string) ([]byte, error) {
+	parts := strings.SplitN(kindName, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid object '%s', it must be kind/name, e.g. pod/nginx", kindName)
+	}
+	r, err := existingRestConfig(c.Existing.Kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load a kubernetes config: %v", err)
+	}
+	if err := existing.InitKubeClients(r); err != nil {
+		return nil, err
+	}
+	object, err := existing.GetObject(parts[0], namespace, parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %v", kindName, err)
+	}
+	return object.MarshalJSON()
+}
+
+// explainRequest builds the admission request that creates the object.
+func explainRequest(object []byte) (*admission.AdmissionRequest, error) {
+	var meta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &meta); err != nil {
+		return nil, fmt.Errorf("failed to read the object's metadata: %v", err)
+	}
+	gvk := metav1.GroupVersionKind{Kind: meta.Kind, Version: meta.APIVersion}
+	if i := strings.Index(meta.APIVersion, "/"); i >= 0 {
+		gvk.Group, gvk.Version = meta.APIVersion[:i], meta.APIVersion[i+1:]
+	}
+	return &admission.AdmissionRequest{
+		UID:       "kube-graffiti-explain",
+		Kind:      gvk,
+		Name:      meta.Metadata.Name,
+		Namespace: meta.Metadata.Namespace,
+		Operation: admission.Create,
+		Object:    runtime.RawExtension{Raw: object},
+	}, nil
+}
+
+// explainRules prints, for each of the sorted rules, whether it matched the object of the request, how each of its
+// selectors fared, and what it would do to the object.  The rules after a stop-on-match rule that matched are skipped,
+// as they are by the webhook.
+func explainRules(w io.Writer, rules []config.Rule, req *admission.AdmissionRequest) error {
+	var matched int
+	var stoppedBy string
+	for _, rule := range rules {
+		r := rule.GraffitiRule().Compiled()
+		if stoppedBy != "" {
+			fmt.Fprintf(w, "rule %s: skipped, rule %s matched first\n\n", r.Name, stoppedBy)
+			continue
+		}
+		e := r.ExplainAdmission(req)
+		switch {
+		case e.Error != "":
+			fmt.Fprintf(w, "rule %s: error: %s\n", r.Name, e.Error)
+		case e.Matched:
+			fmt.Fprintf(w, "rule %s: matched\n", r.Name)
+		default:
+			fmt.Fprintf(w, "rule %s: not matched\n", r.Name)
+		}
+		for _, s := range e.Selectors {
+			switch {
+			case s.Error != "":
+				fmt.Fprintf(w, "  error %s %s: %s\n", s.Type, s.Selector, s.Error)
+			case s.Matched:
+				fmt.Fprintf(w, "  pass  %s %s\n", s.Type, s.Selector)
+			default:
+				fmt.Fprintf(w, "  fail  %s %s\n", s.Type, s.Selector)
+			}
+		}
+		if e.Matched && e.Error == "" {
+			matched++
+			if r.StopOnMatch {
+				stoppedBy = r.Name
+			}
+			printOutcome(w, r, req)
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "%d of %d rules matched\n", matched, len(rules))
+	return err
+}
+
+// printOutcome prints the patch that a matching rule makes to the object, or why it denies it.
+func printOutcome(w io.Writer, r graffiti.Rule, req *admission.AdmissionRequest) {
+	var resp *admission.AdmissionResponse
+	if r.IsValidating() {
+		resp = r.ValidateAdmission(req)
+	} else {
+		resp = r.MutateAdmission(req)
+	}
+	switch {
+	case resp.Result != nil && resp.Result.Status == metav1.StatusFailure:
+		fmt.Fprintf(w, "  error: %s\n", resp.Result.Message)
+	case !resp.Allowed:
+		fmt.Fprintf(w, "  denied: %s\n", resp.Result.Message)
+	case len(resp.Patch) == 0:
+		fmt.Fprintln(w, "  patch: none, the object already has the rule's payload")
+	default:
+		var patch bytes.Buffer
+		if err := json.Indent(&patch, resp.Patch, "  ", "  "); err != nil {
+			patch.Reset()
+			patch.Write(resp.Patch)
+		}
+		fmt.Fprintf(w, "  patch: %s\n", patch.String())
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+const explainRulesYaml = `---
+- registration:
+    name: deny-privileged
+    targets:
+    - api-groups: [""]
+      api-versions: ["v1"]
+      resources: ["pods"]
+  matchers:
+    label-selectors:
+    - privileged=true
+  payload:
+    block: true
+  priority: 10
+- registration:
+    name: label-web-pods
+    targets:
+    - api-groups: [""]
+      api-versions: ["v1"]
+      resources: ["pods"]
+  matchers:
+    label-selectors:
+    - app=web
+    field-selectors:
+    - metadata.namespace=team-a
+  payload:
+    additions:
+      labels:
+        team: web
+`
+
+func explainTestRules(t *testing.T) []config.Rule {
+	var rules []config.Rule
+	require.NoError(t, yaml.Unmarshal([]byte(explainRulesYaml), &rules))
+	config.SortRules(rules)
+	return rules
+}
+
+func TestReadObjectFileReadsYamlAsJSON(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pod.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: nginx\n  namespace: team-a\n"), 0644))
+
+	object, err := readObjectFile(file)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","namespace":"team-a"}}`, string(object))
+
+	req, err := explainRequest(object)
+	require.NoError(t, err)
+	assert.Equal(t, "Pod", req.Kind.Kind)
+	assert.Equal(t, "v1", req.Kind.Version)
+	assert.Equal(t, "nginx", req.Name)
+	assert.Equal(t, "team-a", req.Namespace)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("- not an object\n"), 0644))
+	_, err = readObjectFile(file)
+	assert.Error(t, err)
+}
+
+func TestExplainRulesPrintsSelectorsAndPatches(t *testing.T) {
+	req, err := explainRequest([]byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","namespace":"team-a","labels":{"app":"web"}}}`))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, explainRules(&out, explainTestRules(t), req))
+	assert.Contains(t, out.String(), "rule deny-privileged: not matched\n  fail  label privileged=true\n")
+	assert.Contains(t, out.String(), "rule label-web-pods: matched\n  pass  label app=web\n  pass  field metadata.namespace=team-a\n  patch: [")
+	assert.Contains(t, out.String(), `"team": "web"`)
+	assert.Contains(t, out.String(), "1 of 2 rules matched\n")
+}
+
+func TestExplainRulesSkipsTheRulesAfterAStopOnMatchRule(t *testing.T) {
+	rules := explainTestRules(t)
+	rules[0].StopOnMatch = true
+	req, err := explainRequest([]byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx","namespace":"team-a","labels":{"app":"web","privileged":"true"}}}`))
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, explainRules(&out, rules, req))
+	assert.Contains(t, out.String(), "rule deny-privileged: matched\n  pass  label privileged=true\n  denied: ")
+	assert.Contains(t, out.String(), "rule label-web-pods: skipped, rule deny-privileged matched first\n")
+	assert.Contains(t, out.String(), "1 of 2 rules matched\n")
+}
@@ -0,0 +1,243 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/spf13/viper"
+)
+
+// maxIncludeSize is the largest fragment of rules that is downloaded from a url, and includeTimeout is how long it
+// may take.
+const (
+	maxIncludeSize = 10 << 20
+	includeTimeout = 30 * time.Second
+)
+
+// include pulls the rules of a fragment into a configuration, from a file or a url, so that shared baseline rules can
+// be distributed centrally and layered with local rules.  Fragments from urls must be pinned to their sha256 checksum,
+// which is optional for files.
+type include struct {
+	// Path is a file, relative to the file that includes it, or to the url when that was itself included from one, in
+	// which case it must be pinned to its sha256 too.
+	Path   string `mapstructure:"path"`
+	URL    string `mapstructure:"url"`
+	SHA256 string `mapstructure:"sha256"`
+}
+
+// validate checks that the include names one fragment and that its checksum is a sha256.
+func (i include) validate() error {
+	if (i.Path == "") == (i.URL == "") {
+		return errors.New("an include must have either a path or a url")
+	}
+	if i.URL != "" {
+		u, err := url.Parse(i.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("include url '%s' must be an http or https url", i.URL)
+		}
+		if i.SHA256 == "" {
+			return fmt.Errorf("include url '%s' must be pinned with its sha256", i.URL)
+		}
+	}
+	if i.SHA256 != "" {
+		if sum, err := hex.DecodeString(i.SHA256); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("the sha256 of include '%s' must be 64 hex characters", i.source())
+		}
+	}
+	return nil
+}
+
+func (i include) source() string {
+	if i.URL != "" {
+		return i.URL
+	}
+	return i.Path
+}
+
+// location is where the fragment of an include is read from, relative to the file or url that includes it.
+func (i include) location(parent string) (string, error) {
+	if i.URL != "" {
+		return i.URL, nil
+	}
+	if isURL(parent) {
+		base, err := url.Parse(parent)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(filepath.ToSlash(i.Path))
+		if err != nil {
+			return "", fmt.Errorf("invalid include path '%s': %v", i.Path, err)
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	if filepath.IsAbs(i.Path) {
+		return i.Path, nil
+	}
+	return filepath.Join(filepath.Dir(parent), i.Path), nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// rulesWithIncludes returns the rules of the includes of a configuration file, or fragment, that has been read into v,
+// in order and each resolved recursively, followed by its own rules, all migrated to the current apiVersion by the
+// apiVersion of the file that they are in.  Including is the chain of files that include this one, to catch cycles.
+func rulesWithIncludes(v *viper.Viper, file string, including []string) ([]interface{}, error) {
+	var includes []include
+	if err := v.UnmarshalKey("include", &includes, decodeHookWithErrorUnused(nil)); err != nil {
+		return nil, fmt.Errorf("the includes of %s are invalid: %v", file, err)
+	}
+	var rules []interface{}
+	for _, inc := range includes {
+		if err := inc.validate(); err != nil {
+			return nil, fmt.Errorf("invalid include in %s: %v", file, err)
+		}
+		location, err := inc.location(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range append(including, file) {
+			if f == location {
+				return nil, fmt.Errorf("include cycle: %s includes %s, which includes it", file, location)
+			}
+		}
+		included, err := readInclude(inc, location, append(including, file))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, included...)
+	}
+
+	migrated, err := config.MigrateRules(v.GetString("apiVersion"), v.Get("rules"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate the rules of %s: %v", file, err)
+	}
+	if migrated != nil {
+		list, ok := migrated.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("the rules of %s must be a list", file)
+		}
+		rules = append(rules, list...)
+	}
+	return rules, nil
+}
+
+// readInclude reads the fragment of an include, checks its checksum and returns its rules and those that it includes.
+// Every fragment that is downloaded from a url must be pinned, including those that a url includes by path.
+// Fragments can only contain rules, includes and their apiVersion, in any of the formats of a configuration file.
+func readInclude(inc include, location string, including []string) ([]interface{}, error) {
+	mylog := log.ComponentLogger(componentName, "readInclude")
+	mylog.Debug().Str("include", location).Msg("reading included rules")
+
+	if isURL(location) && inc.SHA256 == "" {
+		return nil, fmt.Errorf("include %s is downloaded from a url, so it must be pinned with its sha256", location)
+	}
+	data, err := fetchInclude(location)
+	if err != nil {
+		return nil, fmt.Errorf("can't read include %s: %v", location, err)
+	}
+	if inc.SHA256 != "" {
+		sum := sha256.Sum256(data)
+		if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, inc.SHA256) {
+			mylog.Error().Str("include", location).Str("expected", inc.SHA256).Str("actual", actual).Msg("included rules don't match their checksum")
+			return nil, fmt.Errorf("include %s has sha256 %s, not the pinned %s", location, actual, inc.SHA256)
+		}
+	}
+
+	v := viper.New()
+	v.SetConfigType(includeFormat(location))
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("can't read include %s: %v", location, err)
+	}
+	for _, key := range v.AllKeys() {
+		section := strings.SplitN(key, ".", 2)[0]
+		if section != "apiversion" && section != "rules" && section != "include" {
+			return nil, fmt.Errorf("include %s can only contain rules and includes, not '%s'", location, section)
+		}
+	}
+	return rulesWithIncludes(v, location, including)
+}
+
+// fetchInclude reads a fragment from a file or downloads it from a url.
+func fetchInclude(location string) ([]byte, error) {
+	if !isURL(location) {
+		return ioutil.ReadFile(location)
+	}
+	resp, err := egress.HTTPClient(includeTimeout).Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxIncludeSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxIncludeSize {
+		return nil, fmt.Errorf("it is larger than %d bytes", maxIncludeSize)
+	}
+	return data, nil
+}
+
+// setIncludeEgress makes the fragments included from urls go through the egress configuration in v, which is set
+// before the includes are fetched as they are part of loading the configuration.
+func setIncludeEgress(v *viper.Viper) error {
+	err := egress.Set(egress.Config{
+		HTTPProxy:  v.GetString("egress.http-proxy"),
+		HTTPSProxy: v.GetString("egress.https-proxy"),
+		NoProxy:    v.GetStringSlice("egress.no-proxy"),
+		CABundles:  v.GetStringSlice("egress.ca-bundles"),
+	})
+	if err != nil {
+		return fmt.Errorf("can't fetch includes: %v", err)
+	}
+	return nil
+}
+
+// includeFormat is the format of a fragment, from the extension of its file or url path, yaml when it has none.
+func includeFormat(location string) string {
+	p := location
+	if isURL(location) {
+		if u, err := url.Parse(location); err == nil {
+			p = u.Path
+		}
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(p), "."))
+	if ext == "" || !isConfigFile(p) {
+		return "yaml"
+	}
+	if ext == "yml" {
+		return "yaml"
+	}
+	return ext
+}
@@ -0,0 +1,200 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBaselineFile = `apiVersion: v2
+include:
+- path: security/deny.toml
+rules:
+- registration:
+    name: label-owner
+    resources: ["pods"]
+  payload:
+    additions:
+      labels:
+        owner: platform
+`
+	testPinnedBaselineFile = `apiVersion: v2
+include:
+- path: security/deny.toml
+  sha256: DENY_SHA256
+`
+	testDenyFile = `
+[[rules]]
+[rules.registration]
+name = "deny-privileged"
+resources = ["pods"]
+[rules.matchers]
+label-selectors = ["privileged=true"]
+[rules.payload]
+block = true
+`
+)
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRulesAreIncludedBeforeTheLocalRules(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"config.yaml": "include:\n- path: shared/baseline.yaml\n  sha256: " + checksum(testBaselineFile) + "\n" + testTeamAFile,
+	})
+	writeConfigFile(t, filepath.Join(dir, "shared", "baseline.yaml"), testBaselineFile)
+	writeConfigFile(t, filepath.Join(dir, "shared", "security", "deny.toml"), testDenyFile)
+
+	viper.Reset()
+	c, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+	require.Len(t, c.Rules, 3)
+	assert.Equal(t, "deny-privileged", c.Rules[0].Registration.Name, "nested includes are relative to the file that includes them")
+	assert.Equal(t, "label-owner", c.Rules[1].Registration.Name)
+	assert.Equal(t, "label-team-a", c.Rules[2].Registration.Name)
+	assert.Equal(t, []string{"privileged=true"}, c.Rules[0].Matchers.LabelSelectors)
+}
+
+func TestIncludesMustMatchTheirChecksum(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"config.yaml":   "include:\n- path: baseline.yaml\n  sha256: " + checksum("something else") + "\n",
+		"baseline.yaml": testTeamAFile,
+	})
+
+	viper.Reset()
+	_, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not the pinned "+checksum("something else"))
+}
+
+func TestRulesAreIncludedFromPinnedURLs(t *testing.T) {
+	pinnedBaseline := strings.Replace(testPinnedBaselineFile, "DENY_SHA256", checksum(testDenyFile), 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/baseline/rules.yaml":
+			w.Write([]byte(testBaselineFile))
+		case "/baseline/pinned.yaml":
+			w.Write([]byte(pinnedBaseline))
+		case "/baseline/security/deny.toml":
+			w.Write([]byte(testDenyFile))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	dir := writeConfigDir(t, map[string]string{
+		"config.yaml":   "include:\n- url: " + server.URL + "/baseline/pinned.yaml\n  sha256: " + checksum(pinnedBaseline) + "\n",
+		"nested.yaml":   "include:\n- url: " + server.URL + "/baseline/rules.yaml\n  sha256: " + checksum(testBaselineFile) + "\n",
+		"unpinned.yaml": "include:\n- url: " + server.URL + "/baseline/rules.yaml\n",
+	})
+
+	viper.Reset()
+	c, err := loadConfig(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+	require.Len(t, c.Rules, 1)
+	assert.Equal(t, "deny-privileged", c.Rules[0].Registration.Name, "paths included by a url are relative to it")
+
+	viper.Reset()
+	_, err = loadConfig(filepath.Join(dir, "nested.yaml"))
+	require.Error(t, err, "a path included by a url is downloaded, so it must be pinned too")
+	assert.Contains(t, err.Error(), "include "+server.URL+"/baseline/security/deny.toml is downloaded from a url, so it must be pinned with its sha256")
+
+	viper.Reset()
+	_, err = loadConfig(filepath.Join(dir, "unpinned.yaml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be pinned with its sha256")
+}
+
+func TestIncludesAreRejectedWhenTheyAreInvalid(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"cycle.yaml":    "include:\n- path: loop.yaml\n",
+		"loop.yaml":     "include:\n- path: cycle.yaml\n",
+		"server.yaml":   "include:\n- path: settings.yaml\n",
+		"settings.yaml": testServerFile,
+		"both.yaml":     "include:\n- path: loop.yaml\n  url: https://example.com/rules.yaml\n",
+	})
+
+	for file, expected := range map[string]string{
+		"cycle.yaml":  "include cycle",
+		"server.yaml": "can only contain rules and includes, not 'server'",
+		"both.yaml":   "an include must have either a path or a url",
+	} {
+		viper.Reset()
+		_, err := loadConfig(filepath.Join(dir, file))
+		require.Error(t, err, file)
+		assert.Contains(t, err.Error(), expected, file)
+	}
+}
+
+func TestIncludesAreResolvedForEachFileOfADirectory(t *testing.T) {
+	dir := writeConfigDir(t, nil)
+	writeConfigFile(t, filepath.Join(dir, "baseline", "rules.toml"), testDenyFile)
+	writeConfigFile(t, filepath.Join(dir, "config", "00-server.yaml"), testServerFile)
+	writeConfigFile(t, filepath.Join(dir, "config", "team-a.yaml"), "include:\n- path: ../baseline/rules.toml\n"+testTeamAFile)
+
+	viper.Reset()
+	c, err := loadConfig(filepath.Join(dir, "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "kube-graffiti", c.Server.Namespace)
+	require.Len(t, c.Rules, 2)
+	assert.Equal(t, "deny-privileged", c.Rules[0].Registration.Name)
+	assert.Equal(t, "label-team-a", c.Rules[1].Registration.Name)
+}
+
+func TestIncludesAreFetchedThroughTheEgressConfiguration(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testDenyFile))
+	}))
+	defer server.Close()
+	defer egress.Set(egress.Config{})
+	dir := writeConfigDir(t, nil)
+	bundle := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	writeConfigFile(t, filepath.Join(dir, "config", "00-server.yaml"), testServerFile)
+	writeConfigFile(t, filepath.Join(dir, "config", "team-a.yaml"), "include:\n- url: "+server.URL+"/rules.toml\n  sha256: "+checksum(testDenyFile)+"\n")
+
+	viper.Reset()
+	_, err := loadConfig(filepath.Join(dir, "config"))
+	require.Error(t, err, "the server's certificate isn't trusted without the egress ca bundle")
+	assert.Contains(t, err.Error(), "certificate")
+
+	writeConfigFile(t, filepath.Join(dir, "config", "99-egress.yaml"), "egress:\n  ca-bundles:\n  - "+bundle+"\n")
+	viper.Reset()
+	c, err := loadConfig(filepath.Join(dir, "config"))
+	require.NoError(t, err, "the egress section of a later file is used to fetch the includes")
+	require.Len(t, c.Rules, 1)
+	assert.Equal(t, "deny-privileged", c.Rules[0].Registration.Name)
+}
+
+func writeConfigFile(t *testing.T, file, content string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0700))
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+}
@@ -0,0 +1,272 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// initRuleOptions holds the answers used to scaffold a new rule, either from flags or interactive prompts.
+type initRuleOptions struct {
+	name              string
+	ruleType          string
+	group             string
+	version           string
+	resource          string
+	namespaceSelector string
+	failurePolicy     string
+	labelSelector     string
+	labels            map[string]string
+	annotations       map[string]string
+	block             bool
+	interactive       bool
+	output            string
+}
+
+var (
+	initRule    initRuleOptions
+	initRuleCmd = &cobra.Command{
+		Use:   "init-rule",
+		Short: "Scaffold a new graffiti rule",
+		Long: `Scaffold a new rule with valid registration, matcher and payload sections for a chosen group, version and resource.
+The rule is validated and then printed, written to a new file or appended to the rules of an existing configuration file.`,
+		Example: `kube-graffiti init-rule --name label-pods --resource pods --add-label team=platform --output ./config.yaml
+kube-graffiti init-rule --interactive`,
+		RunE: runInitRuleCmd,
+	}
+)
+
+func init() {
+	f := initRuleCmd.Flags()
+	f.StringVar(&initRule.name, "name", "", "the name of the rule, which must be unique")
+	f.StringVar(&initRule.ruleType, "type", graffiti.RuleTypeMutating, "the type of rule, either mutating or validating")
+	f.StringVar(&initRule.group, "group", "", "the api group of the objects, empty for the core group")
+	f.StringVar(&initRule.version, "version", "v1", "the api version of the objects")
+	f.StringVar(&initRule.resource, "resource", "", "the (plural) resource name of the objects, e.g. pods")
+	f.StringVar(&initRule.namespaceSelector, "namespace-selector", "", "only register for objects in namespaces matching this label selector")
+	f.StringVar(&initRule.failurePolicy, "failure-policy", "Ignore", "the webhook failure policy, either Ignore or Fail")
+	f.StringVar(&initRule.labelSelector, "label-selector", "", "only match objects with labels matching this selector")
+	f.StringToStringVar(&initRule.labels, "add-label", nil, "a label to add to matching objects, as key=value")
+	f.StringToStringVar(&initRule.annotations, "add-annotation", nil, "an annotation to add to matching objects, as key=value")
+	f.BoolVar(&initRule.block, "block", false, "block matching objects instead of adding labels or annotations")
+	f.BoolVarP(&initRule.interactive, "interactive", "i", false, "prompt for the rule's settings")
+	f.StringVarP(&initRule.output, "output", "o", "", "a file to write or append the rule to, the rule is printed when not set")
+	rootCmd.AddCommand(initRuleCmd)
+}
+
+func runInitRuleCmd(cmd *cobra.Command, _ []string) error {
+	log.InitLogger("warn")
+	opts := initRule
+	if opts.interactive {
+		if err := opts.prompt(cmd.InOrStdin(), cmd.OutOrStdout()); err != nil {
+			return err
+		}
+	}
+	rule, err := opts.rule()
+	if err != nil {
+		return err
+	}
+
+	if opts.output == "" {
+		data, err := appendRule(nil, rule)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(data)
+		return err
+	}
+
+	existing, err := ioutil.ReadFile(opts.output)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %v", opts.output, err)
+	}
+	data, err := appendRule(existing, rule)
+	if err != nil {
+		return fmt.Errorf("failed to add rule to %s: %v", opts.output, err)
+	}
+	if err := ioutil.WriteFile(opts.output, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", opts.output, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "rule %s written to %s\n", rule.Registration.Name, opts.output)
+	return nil
+}
+
+// rule builds and validates the scaffolded rule.  A mutating rule without a payload gets an example label so that it is valid.
+func (o initRuleOptions) rule() (config.Rule, error) {
+	if o.name == "" {
+		return config.Rule{}, fmt.Errorf("a rule needs a name")
+	}
+	if o.resource == "" {
+		return config.Rule{}, fmt.Errorf("a rule needs a resource to register for")
+	}
+
+	rule := config.Rule{
+		Registration: webhook.Registration{
+			Name: o.name,
+			Targets: []webhook.Target{{
+				APIGroups:   []string{o.group},
+				APIVersions: []string{o.version},
+				Resources:   []string{o.resource},
+			}},
+			NamespaceSelector: o.namespaceSelector,
+			FailurePolicy:     o.failurePolicy,
+		},
+	}
+	if o.ruleType != graffiti.RuleTypeMutating {
+		rule.Registration.Type = o.ruleType
+	}
+	if o.labelSelector != "" {
+		rule.Matchers.LabelSelectors = []string{o.labelSelector}
+	}
+	if !rule.Registration.IsValidating() {
+		rule.Payload.Block = o.block
+		rule.Payload.Additions.Labels = o.labels
+		rule.Payload.Additions.Annotations = o.annotations
+		if !o.block && len(o.labels) == 0 && len(o.annotations) == 0 {
+			rule.Payload.Additions.Labels = map[string]string{"example-label": "example-value"}
+		}
+	}
+
+	switch o.failurePolicy {
+	case "Ignore", "Fail":
+	default:
+		return rule, fmt.Errorf("invalid failure-policy '%s', must be either Ignore or Fail", o.failurePolicy)
+	}
+	if err := rule.GraffitiRule().Validate(log.ComponentLogger(componentName, "initRule")); err != nil {
+		return rule, err
+	}
+	return rule, nil
+}
+
+// prompt asks for each of the rule's settings, keeping the current value when the answer is empty.
+func (o *initRuleOptions) prompt(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	ask := func(question string, value *string) error {
+		fmt.Fprintf(out, "%s [%s]: ", question, *value)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("no answer for %s", question)
+		}
+		if answer := strings.TrimSpace(scanner.Text()); answer != "" {
+			*value = answer
+		}
+		return nil
+	}
+
+	questions := []struct {
+		question string
+		value    *string
+	}{
+		{"rule name", &o.name},
+		{"rule type (mutating or validating)", &o.ruleType},
+		{"api group (empty for core)", &o.group},
+		{"api version", &o.version},
+		{"resource (plural, e.g. pods)", &o.resource},
+		{"namespace selector", &o.namespaceSelector},
+		{"failure policy (Ignore or Fail)", &o.failurePolicy},
+		{"label selector", &o.labelSelector},
+	}
+	for _, q := range questions {
+		if err := ask(q.question, q.value); err != nil {
+			return err
+		}
+	}
+	if o.ruleType == graffiti.RuleTypeValidating {
+		return nil
+	}
+
+	var labels string
+	if err := ask("labels to add (key=value,...)", &labels); err != nil {
+		return err
+	}
+	if labels == "" {
+		return nil
+	}
+	o.labels = make(map[string]string)
+	for _, pair := range strings.Split(labels, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid label '%s', must be key=value", pair)
+		}
+		o.labels[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// appendRule adds the rule to the end of the rules of an existing yaml configuration, or creates a new configuration
+// containing only the rule.  The order of the existing configuration is kept but its comments are lost.
+func appendRule(existing []byte, rule config.Rule) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(existing, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse existing configuration: %v", err)
+	}
+
+	for i, item := range doc {
+		if item.Key != "rules" {
+			continue
+		}
+		rules, ok := item.Value.([]interface{})
+		if item.Value != nil && !ok {
+			return nil, fmt.Errorf("rules is not a list")
+		}
+		for _, r := range rules {
+			if existingRuleName(r) == rule.Registration.Name {
+				return nil, fmt.Errorf("a rule named %s already exists", rule.Registration.Name)
+			}
+		}
+		doc[i].Value = append(rules, rule)
+		return yaml.Marshal(doc)
+	}
+
+	doc = append(doc, yaml.MapItem{Key: "rules", Value: []interface{}{rule}})
+	return yaml.Marshal(doc)
+}
+
+// existingRuleName pulls the registration name out of an unmarshalled rule.
+func existingRuleName(r interface{}) string {
+	rule, ok := r.(yaml.MapSlice)
+	if !ok {
+		return ""
+	}
+	for _, item := range rule {
+		if item.Key != "registration" {
+			continue
+		}
+		registration, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return ""
+		}
+		for _, field := range registration {
+			if field.Key == "name" {
+				name, _ := field.Value.(string)
+				return name
+			}
+		}
+	}
+	return ""
+}
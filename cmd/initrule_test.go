@@ -0,0 +1,77 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestInitRuleScaffoldsAValidRule(t *testing.T) {
+	opts := initRuleOptions{name: "label-deployments", ruleType: "mutating", group: "apps", version: "v1", resource: "deployments", failurePolicy: "Ignore"}
+	rule, err := opts.rule()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apps"}, rule.Registration.Targets[0].APIGroups)
+	assert.Equal(t, "", rule.Registration.Type, "mutating is the default type")
+	assert.Equal(t, map[string]string{"example-label": "example-value"}, rule.Payload.Additions.Labels, "a skeleton payload should be added")
+
+	opts.labelSelector = "app in (web"
+	_, err = opts.rule()
+	assert.Error(t, err, "the scaffolded rule should be validated")
+}
+
+func TestInitRulePromptsForSettings(t *testing.T) {
+	opts := initRuleOptions{ruleType: "mutating", version: "v1", failurePolicy: "Ignore"}
+	answers := "label-pods\n\n\n\npods\n\n\napp=web\nteam=platform,tier=frontend\n"
+	var out bytes.Buffer
+	require.NoError(t, opts.prompt(strings.NewReader(answers), &out))
+	assert.Contains(t, out.String(), "resource (plural, e.g. pods) []: ")
+
+	rule, err := opts.rule()
+	require.NoError(t, err)
+	assert.Equal(t, "label-pods", rule.Registration.Name)
+	assert.Equal(t, []string{"v1"}, rule.Registration.Targets[0].APIVersions)
+	assert.Equal(t, []string{"app=web"}, rule.Matchers.LabelSelectors)
+	assert.Equal(t, map[string]string{"team": "platform", "tier": "frontend"}, rule.Payload.Additions.Labels)
+}
+
+func TestAppendRuleToExistingConfiguration(t *testing.T) {
+	existing := `server:
+  namespace: kube-graffiti
+rules:
+- registration:
+    name: label-pods
+`
+	opts := initRuleOptions{name: "label-namespaces", ruleType: "mutating", version: "v1", resource: "namespaces", failurePolicy: "Ignore"}
+	rule, err := opts.rule()
+	require.NoError(t, err)
+
+	data, err := appendRule([]byte(existing), rule)
+	require.NoError(t, err)
+	var c config.Configuration
+	require.NoError(t, yaml.Unmarshal(data, &c))
+	assert.Equal(t, "kube-graffiti", c.Server.Namespace)
+	require.Len(t, c.Rules, 2)
+	assert.Equal(t, "label-pods", c.Rules[0].Registration.Name)
+	assert.Equal(t, "label-namespaces", c.Rules[1].Registration.Name)
+
+	_, err = appendRule(data, rule)
+	assert.EqualError(t, err, "a rule named label-namespaces already exists")
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/kubernetes"
+)
+
+// loadConfigOrLastKnownGood loads and validates the configuration file, which is saved as the last-known-good
+// configuration when it and all of its rules are good.  When it is broken the last-known-good configuration is used
+// instead, if there is one and it is still valid.  Where the snapshot is kept is read from viper, so that it can also be given in the environment of a
+// configuration that can't be read at all.
+func loadConfigOrLastKnownGood(file string, k kubernetes.Interface) (config.Configuration, error) {
+	mylog := log.ComponentLogger(componentName, "loadConfigOrLastKnownGood")
+	lastKnownGood := config.LastKnownGood{
+		Path:      viper.GetString("last-known-good.path"),
+		ConfigMap: viper.GetString("last-known-good.configmap"),
+	}
+
+	c, err := loadConfig(file)
+	if err == nil {
+		err = c.ValidateConfig()
+	}
+	if err == nil {
+		if lastKnownGood.Enabled() {
+			// a configuration with broken rules still runs its good ones, but isn't good enough to fall back to
+			if _, invalid := c.ValidRules(); len(invalid) > 0 {
+				mylog.Warn().Int("invalid-rules", len(invalid)).Msg("not saving the configuration as the last-known-good configuration as some of its rules are invalid")
+			} else if err := lastKnownGood.Save(c, k); err != nil {
+				mylog.Error().Err(err).Msg("failed to save the last-known-good configuration")
+			}
+		}
+		return c, nil
+	}
+	if !lastKnownGood.Enabled() {
+		return c, err
+	}
+
+	mylog.Error().Err(err).Msg("the configuration is broken, falling back to the last-known-good configuration")
+	good, found, lerr := lastKnownGood.Load(k)
+	if lerr != nil {
+		mylog.Error().Err(lerr).Msg("failed to load the last-known-good configuration")
+		return c, err
+	}
+	if !found {
+		mylog.Error().Msg("there is no last-known-good configuration to fall back to")
+		return c, err
+	}
+	if verr := good.ValidateConfig(); verr != nil {
+		mylog.Error().Err(verr).Msg("the last-known-good configuration is no longer valid")
+		return c, err
+	}
+	metrics.LastKnownGoodConfig.Set(1)
+	return good, nil
+}
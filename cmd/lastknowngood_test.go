@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testBrokenRuleFile = `rules:
+- registration:
+    name: broken
+    resources: ["pods"]
+  matchers:
+    label-selectors: ["app in ("]
+  payload:
+    additions:
+      labels:
+        team: a
+`
+
+func TestOnlyConfigurationsWithoutInvalidRulesAreLastKnownGood(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{})
+	for file, content := range map[string]string{
+		"good/server.yaml":   testServerFile,
+		"good/rules.yaml":    testTeamAFile,
+		"broken/server.yaml": testServerFile,
+		"broken/rules.yaml":  testTeamAFile,
+		"broken/broken.yaml": testBrokenRuleFile,
+	} {
+		writeConfigFile(t, filepath.Join(dir, file), content)
+	}
+	snapshot := filepath.Join(dir, "last-known-good.yaml")
+
+	viper.Reset()
+	viper.Set("last-known-good.path", snapshot)
+	_, err := loadConfigOrLastKnownGood(filepath.Join(dir, "broken"), nil)
+	require.NoError(t, err, "the good rules of the configuration are still run")
+	_, err = os.Stat(snapshot)
+	assert.True(t, os.IsNotExist(err), "a configuration with invalid rules isn't saved")
+
+	viper.Reset()
+	viper.Set("last-known-good.path", snapshot)
+	_, err = loadConfigOrLastKnownGood(filepath.Join(dir, "good"), nil)
+	require.NoError(t, err)
+	assert.FileExists(t, snapshot)
+}
+
+func TestAnInvalidLastKnownGoodConfigurationIsNotUsed(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{"broken.yaml": "server: [\n"})
+	lastKnownGood := config.LastKnownGood{Path: filepath.Join(dir, "last-known-good.yaml")}
+	good := config.Default()
+	good.Server.Namespace = "kube-graffiti"
+	good.Server.Service = "kube-graffiti"
+	good.Rules = []config.Rule{config.NewRule(webhook.Registration{Name: "label-team-a", Resources: []string{"pods"}}, graffiti.NewRule("label-team-a").AddLabels(map[string]string{"team": "a"}))}
+	require.NoError(t, lastKnownGood.Save(good, nil))
+
+	viper.Reset()
+	viper.Set("last-known-good.path", lastKnownGood.Path)
+	c, err := loadConfigOrLastKnownGood(filepath.Join(dir, "broken.yaml"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "label-team-a", c.Rules[0].Registration.Name, "the last-known-good configuration is used")
+
+	good.Server.Service = ""
+	require.NoError(t, lastKnownGood.Save(good, nil))
+	_, err = loadConfigOrLastKnownGood(filepath.Join(dir, "broken.yaml"), nil)
+	assert.Error(t, err, "a snapshot that is no longer valid can't be fallen back to")
+}
@@ -0,0 +1,117 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/client-go/rest"
+)
+
+// defaultProfilesFile is where the profiles are read from, relative to the user's home directory.
+const defaultProfilesFile = ".kube-graffiti/profiles.yaml"
+
+// profile is a named cluster and configuration, so that someone who runs kube-graffiti against many clusters doesn't
+// have to give the same config, kubeconfig and context every time.
+type profile struct {
+	Config     string `yaml:"config,omitempty"`
+	Kubeconfig string `yaml:"kubeconfig,omitempty"`
+	Context    string `yaml:"context,omitempty"`
+}
+
+// profilesFile is the user's file of profiles.
+type profilesFile struct {
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// activeProfile is the profile chosen with --profile, it is empty when there isn't one.
+var activeProfile profile
+
+// loadProfile reads the named profile from the profiles file, with any '~' in its paths expanded to the home directory.
+func loadProfile(file, name string) (profile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return profile{}, fmt.Errorf("can't read profiles: %v", err)
+	}
+	var pf profilesFile
+	if err := yaml.UnmarshalStrict(data, &pf); err != nil {
+		return profile{}, fmt.Errorf("can't parse profiles file %s: %v", file, err)
+	}
+	p, ok := pf.Profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("there is no profile called '%s' in %s", name, file)
+	}
+	if p.Config, err = expandHome(p.Config); err != nil {
+		return profile{}, err
+	}
+	if p.Kubeconfig, err = expandHome(p.Kubeconfig); err != nil {
+		return profile{}, err
+	}
+	return p, nil
+}
+
+// applyProfile loads the profile given with --profile and uses its config file, unless --config was also given.
+func applyProfile(cmd *cobra.Command) error {
+	name := viper.GetString("profile")
+	if name == "" {
+		return nil
+	}
+	file := viper.GetString("profiles-file")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("%w: can't find the profiles file: %v", config.ErrConfigInvalid, err)
+		}
+		file = filepath.Join(home, defaultProfilesFile)
+	}
+	p, err := loadProfile(file, name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", config.ErrConfigInvalid, err)
+	}
+	if p.Config != "" && !cmd.Flags().Changed("config") {
+		viper.Set("config", p.Config)
+	}
+	activeProfile = p
+	return nil
+}
+
+// profileRestConfig returns the kubernetes config of the active profile's kubeconfig and context, and false when the
+// active profile doesn't name a cluster.
+func profileRestConfig() (*rest.Config, bool, error) {
+	if activeProfile.Kubeconfig == "" && activeProfile.Context == "" {
+		return nil, false, nil
+	}
+	r, err := existing.RestConfigForContext(activeProfile.Kubeconfig, activeProfile.Context)
+	return r, true, err
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
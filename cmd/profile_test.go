@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeProfiles(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "profiles.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`profiles:
+  prod-eu:
+    config: ~/graffiti/prod.yaml
+    kubeconfig: /etc/fleet/kubeconfig
+    context: prod-eu
+  dev:
+    config: ./dev.yaml
+`), 0600))
+	return file
+}
+
+func TestLoadProfile(t *testing.T) {
+	file := writeProfiles(t)
+	home, err := os.UserHomeDir()
+	require.NoError(t, err)
+
+	p, err := loadProfile(file, "prod-eu")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "graffiti/prod.yaml"), p.Config, "~ is expanded to the home directory")
+	assert.Equal(t, "/etc/fleet/kubeconfig", p.Kubeconfig)
+	assert.Equal(t, "prod-eu", p.Context)
+
+	_, err = loadProfile(file, "prod-us")
+	assert.EqualError(t, err, "there is no profile called 'prod-us' in "+file)
+}
+
+func TestApplyProfileSetsTheConfigUnlessItWasGiven(t *testing.T) {
+	file := writeProfiles(t)
+	t.Cleanup(func() {
+		viper.Reset()
+		activeProfile = profile{}
+	})
+	newCmd := func(args ...string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().String("config", "/config", "")
+		require.NoError(t, cmd.ParseFlags(args))
+		return cmd
+	}
+
+	viper.Reset()
+	viper.Set("profile", "dev")
+	viper.Set("profiles-file", file)
+	require.NoError(t, applyProfile(newCmd()))
+	assert.Equal(t, "./dev.yaml", viper.GetString("config"))
+	_, ok, err := profileRestConfig()
+	require.NoError(t, err)
+	assert.False(t, ok, "a profile without a kubeconfig or context uses the usual cluster")
+
+	viper.Reset()
+	viper.Set("profile", "dev")
+	viper.Set("profiles-file", file)
+	viper.Set("config", "./mine.yaml")
+	require.NoError(t, applyProfile(newCmd("--config", "./mine.yaml")))
+	assert.Equal(t, "./mine.yaml", viper.GetString("config"), "--config wins over the profile")
+
+	viper.Set("profile", "staging")
+	err = applyProfile(newCmd())
+	assert.True(t, errors.Is(err, config.ErrConfigInvalid))
+}
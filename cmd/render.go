@@ -0,0 +1,197 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	renderValues []string
+	renderOutput string
+	renderCmd    = &cobra.Command{
+		Use:   "render",
+		Short: "Render a templated configuration with values files and validate it",
+		Long: `Render the configuration file named by --config as a go template, with sprig's functions along with toYaml and
+required, and the merged values files as .Values.  Later values files override the earlier ones, so one template can
+be shared by every environment with a values file for each.  The rendered configuration is validated in the same way
+as the validate command, and is only written when it is valid.`,
+		Example:      `kube-graffiti render --config ./template.yaml --values ./values.yaml --values ./prod.yaml --output ./config.yaml`,
+		PreRunE:      initRootCmd,
+		RunE:         runRenderCmd,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	renderCmd.Flags().StringSliceVarP(&renderValues, "values", "f", nil, "a yaml file of values for the template, which may be given more than once")
+	renderCmd.Flags().StringVarP(&renderOutput, "output", "o", "", "the file to write the rendered configuration to, it is printed when not set")
+	rootCmd.AddCommand(renderCmd)
+}
+
+func runRenderCmd(cmd *cobra.Command, _ []string) error {
+	mylog := log.ComponentLogger(componentName, "runRenderCmd")
+
+	file := viper.GetString("config")
+	if _, many, err := configFiles(file); err != nil || many {
+		return fmt.Errorf("render needs a single template file, not %s", file)
+	}
+	text, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("can't read template: %v", err)
+	}
+	values, err := readValues(renderValues)
+	if err != nil {
+		return err
+	}
+	rendered, err := renderConfig(filepath.Base(file), string(text), values)
+	if err != nil {
+		return fmt.Errorf("%w: %v", config.ErrConfigInvalid, err)
+	}
+	mylog.Debug().Str("template", file).Strs("values", renderValues).Msg("rendered the configuration")
+
+	if err := validateRendered(rendered, filepath.Ext(file)); err != nil {
+		return err
+	}
+	if renderOutput == "" {
+		_, err := cmd.OutOrStdout().Write(rendered)
+		return err
+	}
+	if err := ioutil.WriteFile(renderOutput, rendered, 0644); err != nil {
+		return fmt.Errorf("can't write the rendered configuration: %v", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "rendered %s to %s\n", file, renderOutput)
+	return nil
+}
+
+// renderConfig executes a configuration template with the values available as .Values.  Referring to a value that
+// isn't in the values files is an error, rather than quietly rendering "<no value>".
+func renderConfig(name, text string, values map[string]interface{}) ([]byte, error) {
+	t, err := template.New(name).Option("missingkey=error").Funcs(renderFuncs()).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse template %s: %v", name, err)
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, map[string]interface{}{"Values": values}); err != nil {
+		return nil, fmt.Errorf("can't render template %s: %v", name, err)
+	}
+	return b.Bytes(), nil
+}
+
+// renderFuncs are sprig's functions along with helm's toYaml and required, which are the ones most used to template
+// whole blocks of configuration.
+func renderFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["toYaml"] = func(v interface{}) (string, error) {
+		data, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(data), "\n"), err
+	}
+	funcs["required"] = func(message string, v interface{}) (interface{}, error) {
+		if v == nil {
+			return nil, errors.New(message)
+		}
+		if s, ok := v.(string); ok && s == "" {
+			return nil, errors.New(message)
+		}
+		return v, nil
+	}
+	return funcs
+}
+
+// readValues reads and merges the values files in order, so that each file overrides the values of those before it.
+func readValues(files []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("can't read values: %v", err)
+		}
+		var v map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("%w: invalid values file %s: %v", config.ErrConfigInvalid, file, err)
+		}
+		mergeValues(values, stringKeys(v).(map[string]interface{}))
+	}
+	return values, nil
+}
+
+// mergeValues merges src into dst, merging maps that are in both and otherwise replacing dst's values with src's.
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				mergeValues(dm, sm)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
+
+// stringKeys converts the map[interface{}]interface{} maps produced by yaml into map[string]interface{}, so that values
+// can be merged and used with sprig's dictionary functions.
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = stringKeys(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = stringKeys(v)
+		}
+		return l
+	}
+	return v
+}
+
+// validateRendered loads the rendered configuration, from a file with the template's extension so that viper reads it
+// in the same format, and validates it and its rules.
+func validateRendered(rendered []byte, ext string) error {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		return fmt.Errorf("can't validate the rendered configuration: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config"+ext)
+	if err := ioutil.WriteFile(file, rendered, 0600); err != nil {
+		return fmt.Errorf("can't validate the rendered configuration: %v", err)
+	}
+
+	c, err := loadConfig(file)
+	if err != nil {
+		return fmt.Errorf("%w: failed to load the rendered config: %v", config.ErrConfigInvalid, err)
+	}
+	if err := c.ValidateConfig(); err != nil {
+		return fmt.Errorf("failed to validate the rendered config: %w", err)
+	}
+	return validRules(c)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRenderTemplate = `server:
+  namespace: {{ .Values.namespace }}
+  service: kube-graffiti
+rules:
+{{- range .Values.teams }}
+- registration:
+    name: label-team-{{ . }}
+    resources: ["pods"]
+  payload:
+    additions:
+      labels:
+        team: {{ . }}
+        {{- if hasKey $.Values "labels" }}
+        {{- toYaml $.Values.labels | nindent 8 }}
+        {{- end }}
+{{- end }}
+`
+
+func TestValuesFilesAreMergedInOrder(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"values.yaml": "namespace: kube-graffiti\nteams: [a, b]\nlabels:\n  env: dev\n  owner: platform\n",
+		"prod.yaml":   "labels:\n  env: prod\n",
+	})
+	values, err := readValues([]string{dir + "/values.yaml", dir + "/prod.yaml"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"env": "prod", "owner": "platform"}, values["labels"], "later files override values of the earlier ones")
+	assert.Equal(t, []interface{}{"a", "b"}, values["teams"])
+}
+
+func TestRenderedConfigIsValidated(t *testing.T) {
+	values := map[string]interface{}{
+		"namespace": "kube-graffiti",
+		"teams":     []interface{}{"a", "b"},
+		"labels":    map[string]interface{}{"env": "prod"},
+	}
+	rendered, err := renderConfig("template.yaml", testRenderTemplate, values)
+	require.NoError(t, err)
+	assert.Contains(t, string(rendered), "        team: b\n        env: prod\n")
+
+	viper.Reset()
+	defer viper.Reset()
+	require.NoError(t, validateRendered(rendered, ".yaml"))
+
+	viper.Reset()
+	rendered, err = renderConfig("template.yaml", testRenderTemplate, map[string]interface{}{"namespace": "kube-graffiti", "teams": []interface{}{"a", "a"}})
+	require.NoError(t, err)
+	err = validateRendered(rendered, ".yaml")
+	assert.Error(t, err, "rule names must still be unique")
+	assert.Equal(t, exitConfigInvalid, exitCode(err))
+}
+
+func TestRenderFailsOnMissingValues(t *testing.T) {
+	_, err := renderConfig("template.yaml", testRenderTemplate, map[string]interface{}{"teams": []interface{}{"a"}})
+	assert.Error(t, err, "a value that isn't given should fail rather than render <no value>")
+
+	_, err = renderConfig("template.yaml", `namespace: {{ required "namespace is required" .Values.namespace }}`, map[string]interface{}{"namespace": ""})
+	assert.Contains(t, err.Error(), "namespace is required")
+}
+
+func TestRuleTemplatesCanBeEscaped(t *testing.T) {
+	rendered, err := renderConfig("template.yaml", "owner: {{`{{ .Labels.owner }}`}}\nteam: {{ .Values.team }}\n", map[string]interface{}{"team": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "owner: {{ .Labels.owner }}\nteam: a\n", string(rendered))
+}
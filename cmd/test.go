@@ -0,0 +1,116 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/ruletest"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	testFixtures string
+	testCmd      = &cobra.Command{
+		Use:   "test",
+		Short: "Run test cases against the rules of the configuration",
+		Long: `Load the configuration and evaluate the yaml test cases in the fixtures against its rules in-process, checking that
+each rule matches, patches and admits the objects of its cases as they expect, without a cluster.`,
+		Example:      `kube-graffiti test --config ./config.yaml --fixtures ./fixtures/`,
+		PreRunE:      initRootCmd,
+		RunE:         runTestCmd,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	testCmd.Flags().StringVar(&testFixtures, "fixtures", "", "a directory of yaml test case files, or a single file")
+	rootCmd.AddCommand(testCmd)
+}
+
+func runTestCmd(cmd *cobra.Command, _ []string) error {
+	mylog := log.ComponentLogger(componentName, "runTestCmd")
+
+	if testFixtures == "" {
+		return errors.New("the --fixtures to test the rules with are required")
+	}
+	c, err := loadConfig(viper.GetString("config"))
+	if err != nil {
+		return fmt.Errorf("%w: failed to load config: %v", config.ErrConfigInvalid, err)
+	}
+	log.ChangeLogLevel(viper.GetString("log-level"))
+	if err := c.ValidateConfig(); err != nil {
+		return fmt.Errorf("failed to validate config: %w", err)
+	}
+	cases, err := ruletest.LoadFixtures(testFixtures)
+	if err != nil {
+		return err
+	}
+
+	valid, invalid := c.ValidRules()
+	for name, err := range invalid {
+		mylog.Warn().Str("rule", name).Err(err).Msg("the rule is invalid and its cases will fail")
+	}
+	var rules []graffiti.Rule
+	for _, rule := range valid {
+		rules = append(rules, rule.GraffitiRule())
+	}
+	return printTestResults(cmd, ruletest.Run(rules, cases))
+}
+
+// printTestResults prints whether each case passed, followed by the number of cases that passed and failed for each rule.
+func printTestResults(cmd *cobra.Command, results []ruletest.Result) error {
+	type tally struct{ passed, failed int }
+	tallies := make(map[string]*tally)
+	var failed int
+	for _, result := range results {
+		t, ok := tallies[result.Rule]
+		if !ok {
+			t = &tally{}
+			tallies[result.Rule] = t
+		}
+		if result.Err != nil {
+			failed++
+			t.failed++
+			fmt.Fprintf(cmd.OutOrStdout(), "FAIL %s %s (%s): %v\n", result.Rule, result.Case, result.File, result.Err)
+			continue
+		}
+		t.passed++
+		fmt.Fprintf(cmd.OutOrStdout(), "PASS %s %s\n", result.Rule, result.Case)
+	}
+
+	var names []string
+	for name := range tallies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(cmd.OutOrStdout())
+	for _, name := range names {
+		status := "ok"
+		if tallies[name].failed > 0 {
+			status = "FAIL"
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%-4s %s: %d passed, %d failed\n", status, name, tallies[name].passed, tallies[name].failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d test cases failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	undoRule   string
+	undoDryRun bool
+	undoCmd    = &cobra.Command{
+		Use:   "undo",
+		Short: "Remove the labels and annotations that a rule painted onto existing objects",
+		Long: `Find the existing objects that carry the stamp of a rule and remove the labels and annotations that it added, restoring
+any values that it backed up, so that a bad rule can be rolled back.  The rule must use the stamp payload, and is read from the
+configuration given here, so roll the webhook back without it first, so that it doesn't paint the objects again.`,
+		Example:      `kube-graffiti undo --config ./config.yaml --rule label-pods --namespace team-a --dry-run`,
+		PreRunE:      initRootCmd,
+		RunE:         runUndoCmd,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	f := undoCmd.Flags()
+	f.StringVar(&undoRule, "rule", "", "the name of the rule to undo")
+	f.StringSliceVar(&existingOpts.namespaces, "namespace", nil, "only undo objects in this namespace, and the namespace itself")
+	f.StringSliceVar(&existingOpts.kinds, "kinds", nil, "only undo objects of these kinds or resources, e.g. pods,deployments")
+	f.BoolVar(&undoDryRun, "dry-run", false, "print a diff of each object that would be changed, without changing it")
+	_ = undoCmd.MarkFlagRequired("rule")
+	rootCmd.AddCommand(undoCmd)
+}
+
+func runUndoCmd(cmd *cobra.Command, _ []string) error {
+	mylog := log.ComponentLogger(componentName, "runUndoCmd")
+
+	existingOpts.rules = []string{undoRule}
+	c, err := loadExistingConfig()
+	if err != nil {
+		return err
+	}
+	if len(c.Existing.Contexts) > 0 {
+		return errors.New("undo changes the objects of a single cluster, choose it with --profile instead of existing contexts")
+	}
+	r, err := existingRestConfig(c.Existing.Kubeconfig)
+	if err != nil {
+		return fmt.Errorf("failed to load a kubernetes config: %v", err)
+	}
+
+	existing.SetFilter(existing.Filter{Namespaces: existingOpts.namespaces, Kinds: existingOpts.kinds})
+	existing.SetLimits(c.Existing)
+	existing.SetWatchNamespaces(c.WatchedNamespaces())
+	if undoDryRun {
+		colour, err := useColour("auto", os.Stdout)
+		if err != nil {
+			return err
+		}
+		existing.SetDryRun(func(change existing.Change) {
+			if err := printChange(cmd.OutOrStdout(), change, colour); err != nil {
+				mylog.Error().Err(err).Str("object", change.Name()).Msg("failed to diff object")
+			}
+		})
+	}
+	if err := existing.InitKubeClients(r); err != nil {
+		return err
+	}
+	mylog.Info().Str("rule", undoRule).Strs("namespaces", existingOpts.namespaces).Strs("kinds", existingOpts.kinds).Bool("dry-run", undoDryRun).Msg("undoing rule")
+	return existing.UndoRule(c.Rules[0])
+}
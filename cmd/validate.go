@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/engine"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/discovery"
+)
+
+var (
+	validateCluster bool
+	validateCmd     = &cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration and its rules and exit",
+		Long: `Load and validate the configuration, failing when any of its rules are invalid rather than leaving them out as the
+webhook server does.  With --cluster the rules' registrations are also checked against the kinds that the cluster serves.`,
+		Example:      `kube-graffiti validate --config ./config.yaml --cluster`,
+		PreRunE:      initRootCmd,
+		RunE:         runValidateCmd,
+		SilenceUsage: true,
+	}
+)
+
+func init() {
+	validateCmd.Flags().BoolVar(&validateCluster, "cluster", false, "check that the cluster serves the api groups, versions and resources that the rules target")
+	rootCmd.AddCommand(validateCmd)
+}
+
+func runValidateCmd(cmd *cobra.Command, _ []string) error {
+	mylog := log.ComponentLogger(componentName, "runValidateCmd")
+
+	c, err := loadConfig(viper.GetString("config"))
+	if err != nil {
+		return fmt.Errorf("%w: failed to load config: %v", config.ErrConfigInvalid, err)
+	}
+	log.ChangeLogLevel(viper.GetString("log-level"))
+	if err := c.ValidateConfig(); err != nil {
+		return fmt.Errorf("failed to validate config: %w", err)
+	}
+	if err := validRules(c); err != nil {
+		return err
+	}
+
+	if validateCluster {
+		r, err := existingRestConfig(c.Existing.Kubeconfig)
+		if err != nil {
+			return fmt.Errorf("failed to load a kubernetes config: %v", err)
+		}
+		d, err := discovery.NewDiscoveryClientForConfig(r)
+		if err != nil {
+			return fmt.Errorf("can't get a kubernetes discovery client: %v", err)
+		}
+		mylog.Info().Str("host", r.Host).Msg("checking the rules' registrations against the cluster")
+		if err := engine.CheckTargets(c, d); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "configuration is valid, with %d rules\n", len(c.Rules))
+	return nil
+}
+
+// validRules fails when any of the configuration's rules are invalid, listing the problems of each by rule name.
+func validRules(c config.Configuration) error {
+	_, invalid := c.ValidRules()
+	if len(invalid) == 0 {
+		return nil
+	}
+	var problems []string
+	for name, err := range invalid {
+		problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("%w: invalid rules: %s", config.ErrConfigInvalid, strings.Join(problems, "; "))
+}
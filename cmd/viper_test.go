@@ -15,7 +15,9 @@ package cmd
 
 import (
 	"bytes"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
@@ -36,3 +38,144 @@ server:
 	_, err = unmarshalFromViperStrict()
 	require.Error(t, err, "when unmarshaling into a strict Configuration it is, however, not ok to have unknown fields in viper")
 }
+
+func TestLegacyRulesAreMigratedWhenLoaded(t *testing.T) {
+	var source = `---
+server:
+  namespace: kube-graffiti
+  service: kube-graffiti
+rules:
+- registration:
+    name: label-pods
+    resources: ["pods"]
+  matcher:
+    label-selectors: ["app = web"]
+  additions:
+    labels:
+      team: web
+`
+	viper.Reset()
+	setDefaults()
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(bytes.NewBuffer([]byte(source))))
+
+	c, err := unmarshalFromViperStrict()
+	require.NoError(t, err, "a configuration without an apiVersion should have its legacy rule keys migrated")
+	require.Len(t, c.Rules, 1)
+	require.Equal(t, []string{"app = web"}, c.Rules[0].Matchers.LabelSelectors)
+	require.Equal(t, "web", c.Rules[0].Payload.Additions.Labels["team"])
+
+	viper.Reset()
+	setDefaults()
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(bytes.NewBuffer([]byte(strings.Replace(source, "---", "---\napiVersion: v2", 1)))))
+	_, err = unmarshalFromViperStrict()
+	require.Error(t, err, "v2 configurations must use matchers and payload")
+}
+
+func TestShardsAreLoaded(t *testing.T) {
+	var source = `---
+shards: [team-a, team-b]
+server:
+  namespace: kube-graffiti
+  service: kube-graffiti
+rules:
+- registration:
+    name: label-pods
+    resources: ["pods"]
+  shard: team-a
+  payload:
+    additions:
+      labels:
+        team: a
+`
+	viper.Reset()
+	setDefaults()
+	viper.Set("shard", "team-a")
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(bytes.NewBuffer([]byte(source))))
+
+	c, err := unmarshalFromViperStrict()
+	require.NoError(t, err)
+	require.Equal(t, "team-a", c.Shard)
+	require.Equal(t, []string{"team-a", "team-b"}, c.Shards)
+	require.Equal(t, "team-a", c.Rules[0].Shard)
+}
+
+func TestExistingWindowsAreLoaded(t *testing.T) {
+	var source = `---
+check-existing: true
+server:
+  namespace: kube-graffiti
+  service: kube-graffiti
+existing:
+  interval: 6h
+  windows:
+  - days: [sat, sun]
+    start: "22:00"
+    end: "04:00"
+    timezone: Europe/London
+`
+	viper.Reset()
+	setDefaults()
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(bytes.NewBuffer([]byte(source))))
+
+	c, err := unmarshalFromViperStrict()
+	require.NoError(t, err)
+	require.Equal(t, 6*time.Hour, c.Existing.Interval)
+	require.Len(t, c.Existing.Windows, 1)
+	require.Equal(t, "22:00", c.Existing.Windows[0].Start)
+	require.Equal(t, "Europe/London", c.Existing.Windows[0].Timezone)
+}
+
+func TestRuleDefaultsAreMergedIntoRules(t *testing.T) {
+	var source = `---
+server:
+  namespace: kube-graffiti
+  service: kube-graffiti
+rule-defaults:
+  additions:
+    labels:
+      managed-by: kube-graffiti
+    annotations:
+      acme.com/support: platform-team
+  matchers:
+    label-selectors:
+    - "!acme.com/skip"
+rules:
+- registration:
+    name: label-pods
+    resources: ["pods"]
+    failure-policy: Ignore
+  payload:
+    additions:
+      annotations:
+        acme.com/support: web-team
+- registration:
+    name: label-deployments
+    resources: ["deployments.apps"]
+    failure-policy: Ignore
+  matchers:
+    label-selectors:
+    - "app=web"
+  payload:
+    delete-labels: ["managed-by"]
+`
+	viper.Reset()
+	setDefaults()
+	viper.SetConfigType("yaml")
+	require.NoError(t, viper.ReadConfig(bytes.NewBuffer([]byte(source))))
+
+	c, err := unmarshalFromViperStrict()
+	require.NoError(t, err)
+	require.Len(t, c.Rules, 2)
+	pods, deployments := c.Rules[0], c.Rules[1]
+	require.Equal(t, map[string]string{"managed-by": "kube-graffiti"}, pods.Payload.Additions.Labels)
+	require.Equal(t, map[string]string{"acme.com/support": "web-team"}, pods.Payload.Additions.Annotations, "a rule's own additions win")
+	require.Equal(t, []string{"!acme.com/skip"}, pods.Matchers.LabelSelectors)
+	require.Equal(t, []string{"app=web"}, deployments.Matchers.LabelSelectors, "a rule's own matchers win")
+	require.Empty(t, deployments.Payload.Additions.Labels, "a default that the rule deletes isn't added")
+	_, invalid := c.ValidRules()
+	require.Empty(t, invalid)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records the decisions that kube-graffiti makes about objects and delivers them to a configured sink.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	componentName = "audit"
+	// SinkNone disables auditing
+	SinkNone = "none"
+	// SinkStdout writes each record as a line of json to stdout.
+	SinkStdout = "stdout"
+	// SinkFile writes each record as a line of json to a file which is rotated when it gets too big.
+	SinkFile = "file"
+	// SinkEvent records each record as a kubernetes Event against the object.
+	SinkEvent = "event"
+	// SinkCloudEvents sends each record as a CloudEvent using the HTTP binding.
+	SinkCloudEvents = "cloudevents"
+
+	// DecisionPatched means that a rule matched and the object was patched.
+	DecisionPatched = "patched"
+	// DecisionBlocked means that a rule matched and the object was blocked.
+	DecisionBlocked = "blocked"
+
+	defaultBufferSize = 1000
+)
+
+// Config models the audit section of our configuration and so has mapstructure tags.
+type Config struct {
+	Sink        string            `mapstructure:"sink" yaml:"sink,omitempty"`
+	BufferSize  int               `mapstructure:"buffer-size" yaml:"buffer-size,omitempty"`
+	File        FileConfig        `mapstructure:"file" yaml:"file,omitempty"`
+	CloudEvents CloudEventsConfig `mapstructure:"cloudevents" yaml:"cloudevents,omitempty"`
+}
+
+// Record is a single audited decision about an object.
+type Record struct {
+	Time      time.Time                 `json:"time"`
+	Rule      string                    `json:"rule"`
+	Decision  string                    `json:"decision"`
+	UID       string                    `json:"uid,omitempty"`
+	Operation string                    `json:"operation,omitempty"`
+	Kind      metav1.GroupVersionKind   `json:"kind"`
+	Namespace string                    `json:"namespace,omitempty"`
+	Name      string                    `json:"name,omitempty"`
+	UserInfo  authenticationv1.UserInfo `json:"userInfo"`
+	Patch     json.RawMessage           `json:"patch,omitempty"`
+	Message   string                    `json:"message,omitempty"`
+}
+
+// Sink is something that we can deliver audit records to.
+type Sink interface {
+	Send(r Record) error
+}
+
+// NewRecord creates a record of the decision a rule made about an admission request.
+// It returns false when the rule did not change or block the object and so there is nothing to audit.
+func NewRecord(rule string, req *admission.AdmissionRequest, resp *admission.AdmissionResponse) (Record, bool) {
+	if req == nil || resp == nil {
+		return Record{}, false
+	}
+	r := Record{
+		Time:      time.Now().UTC(),
+		Rule:      rule,
+		UID:       string(req.UID),
+		Operation: string(req.Operation),
+		Kind:      req.Kind,
+		Namespace: req.Namespace,
+		Name:      req.Name,
+		UserInfo:  req.UserInfo,
+	}
+	if resp.Result != nil {
+		r.Message = resp.Result.Message
+	}
+	switch {
+	case !resp.Allowed:
+		r.Decision = DecisionBlocked
+	case len(resp.Patch) > 0:
+		r.Decision = DecisionPatched
+		r.Patch = json.RawMessage(resp.Patch)
+	default:
+		return Record{}, false
+	}
+	return r, true
+}
+
+// Validate checks that the audit configuration is usable.
+func (c Config) Validate() error {
+	switch c.Sink {
+	case "", SinkNone, SinkStdout, SinkEvent:
+		return nil
+	case SinkFile:
+		return c.File.validate()
+	case SinkCloudEvents:
+		return c.CloudEvents.validate()
+	default:
+		return fmt.Errorf("invalid audit.sink '%s'", c.Sink)
+	}
+}
+
+// NewSink creates the sink selected in the configuration, it returns nil when auditing is disabled.
+// The kubernetes client is only used by the event sink.
+func NewSink(c Config, k kubernetes.Interface) (Sink, error) {
+	switch c.Sink {
+	case "", SinkNone:
+		return nil, nil
+	case SinkStdout:
+		return NewWriterSink(os.Stdout), nil
+	case SinkFile:
+		return NewFileSink(c.File), nil
+	case SinkEvent:
+		return NewEventSink(k), nil
+	case SinkCloudEvents:
+		return NewCloudEventsSink(c.CloudEvents), nil
+	default:
+		return nil, fmt.Errorf("invalid audit.sink '%s'", c.Sink)
+	}
+}
+
+// Auditor delivers records to a sink from a background go-routine so that a slow sink can not delay admission requests.
+// Records are dropped, with a warning, if the sink can not keep up.
+type Auditor struct {
+	sink    Sink
+	records chan Record
+	done    sync.WaitGroup
+}
+
+// NewAuditor creates an Auditor and starts delivering records to the sink.
+func NewAuditor(sink Sink, bufferSize int) *Auditor {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	a := &Auditor{
+		sink:    sink,
+		records: make(chan Record, bufferSize),
+	}
+	a.done.Add(1)
+	go a.deliver()
+	return a
+}
+
+// Record queues a record for delivery, it is safe to call on a nil Auditor.
+func (a *Auditor) Record(r Record) {
+	if a == nil {
+		return
+	}
+	select {
+	case a.records <- r:
+	default:
+		mylog := log.ComponentLogger(componentName, "Record")
+		mylog.Warn().Str("rule", r.Rule).Str("uid", r.UID).Msg("audit buffer is full, dropping record")
+	}
+}
+
+// Stop delivers any queued records and then stops the auditor.
+func (a *Auditor) Stop() {
+	if a == nil {
+		return
+	}
+	close(a.records)
+	a.done.Wait()
+}
+
+func (a *Auditor) deliver() {
+	mylog := log.ComponentLogger(componentName, "deliver")
+	defer a.done.Done()
+	for r := range a.records {
+		if err := a.sink.Send(r); err != nil {
+			mylog.Error().Err(err).Str("rule", r.Rule).Str("uid", r.UID).Msg("failed to deliver audit record")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordingSink keeps every record it is sent so that tests can inspect them.
+type recordingSink struct {
+	sync.Mutex
+	records []Record
+}
+
+func (s *recordingSink) Send(r Record) error {
+	s.Lock()
+	defer s.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func testRequest() *admission.AdmissionRequest {
+	return &admission.AdmissionRequest{
+		UID:       "69f7d25a-963e-11e8-a77c-08002753edac",
+		Kind:      metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Namespace"},
+		Name:      "test-namespace",
+		Operation: admission.Create,
+		UserInfo: authenticationv1.UserInfo{
+			Username: "minikube-user",
+			Groups:   []string{"system:masters", "system:authenticated"},
+		},
+	}
+}
+
+func TestNewRecordForAPatchedObject(t *testing.T) {
+	resp := &admission.AdmissionResponse{
+		Allowed: true,
+		Patch:   []byte(`[ { "op": "add", "path": "/metadata/labels", "value": { "a": "b" }} ]`),
+		Result:  &metav1.Status{Message: "object painted by kube-graffiti"},
+	}
+	r, ok := NewRecord("my-rule", testRequest(), resp)
+	require.True(t, ok, "a patched object should be audited")
+	assert.Equal(t, DecisionPatched, r.Decision)
+	assert.Equal(t, "my-rule", r.Rule)
+	assert.Equal(t, "test-namespace", r.Name)
+	assert.Equal(t, "CREATE", r.Operation)
+	assert.Equal(t, "Namespace", r.Kind.Kind)
+	assert.Equal(t, "minikube-user", r.UserInfo.Username)
+	assert.Equal(t, []string{"system:masters", "system:authenticated"}, r.UserInfo.Groups)
+	assert.JSONEq(t, string(resp.Patch), string(r.Patch))
+}
+
+func TestNewRecordForABlockedObject(t *testing.T) {
+	resp := &admission.AdmissionResponse{
+		Allowed: false,
+		Result:  &metav1.Status{Message: "blocked by kube-graffiti rule: my-rule"},
+	}
+	r, ok := NewRecord("my-rule", testRequest(), resp)
+	require.True(t, ok, "a blocked object should be audited")
+	assert.Equal(t, DecisionBlocked, r.Decision)
+	assert.Equal(t, "blocked by kube-graffiti rule: my-rule", r.Message)
+}
+
+func TestNoRecordWhenObjectIsUnchanged(t *testing.T) {
+	resp := &admission.AdmissionResponse{
+		Allowed: true,
+		Result:  &metav1.Status{Message: "rule didn't match"},
+	}
+	_, ok := NewRecord("my-rule", testRequest(), resp)
+	assert.False(t, ok, "there is nothing to audit when a rule doesn't change an object")
+}
+
+func TestAuditorDeliversRecordsBeforeStopping(t *testing.T) {
+	sink := &recordingSink{}
+	a := NewAuditor(sink, 10)
+	a.Record(Record{Rule: "one"})
+	a.Record(Record{Rule: "two"})
+	a.Stop()
+
+	require.Len(t, sink.records, 2)
+	assert.Equal(t, "one", sink.records[0].Rule)
+	assert.Equal(t, "two", sink.records[1].Rule)
+}
+
+func TestNilAuditorIsSafe(t *testing.T) {
+	var a *Auditor
+	a.Record(Record{Rule: "one"})
+	a.Stop()
+}
+
+func TestConfigValidation(t *testing.T) {
+	assert.NoError(t, Config{}.Validate(), "auditing is disabled by default")
+	assert.NoError(t, Config{Sink: SinkNone}.Validate())
+	assert.EqualError(t, Config{Sink: "carrier-pigeon"}.Validate(), "invalid audit.sink 'carrier-pigeon'")
+	assert.EqualError(t, Config{Sink: SinkCloudEvents}.Validate(), "audit.cloudevents.url is required when audit.sink is cloudevents")
+	assert.NoError(t, Config{Sink: SinkCloudEvents, CloudEvents: CloudEventsConfig{URL: "http://broker.default.svc/"}}.Validate())
+}
@@ -0,0 +1,123 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/google/uuid"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	defaultEventSource     = "kube-graffiti"
+	defaultEventType       = "io.kube-graffiti.decision"
+	defaultEventTimeout    = 5 * time.Second
+)
+
+// CloudEventsConfig configures the delivery of audit records as CloudEvents.
+type CloudEventsConfig struct {
+	URL     string        `mapstructure:"url" yaml:"url"`
+	Source  string        `mapstructure:"source" yaml:"source,omitempty"`
+	Type    string        `mapstructure:"type" yaml:"type,omitempty"`
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout,omitempty"`
+}
+
+func (c CloudEventsConfig) validate() error {
+	if c.URL == "" {
+		return errors.New("audit.cloudevents.url is required when audit.sink is cloudevents")
+	}
+	if _, err := url.ParseRequestURI(c.URL); err != nil {
+		return fmt.Errorf("invalid audit.cloudevents.url: %v", err)
+	}
+	return nil
+}
+
+// CloudEventsSink posts each record to a url as a CloudEvent using the binary content mode of the HTTP protocol binding,
+// i.e. the event attributes are sent as ce- headers and the record is the json body.
+type CloudEventsSink struct {
+	url       string
+	source    string
+	eventType string
+	client    *http.Client
+}
+
+// NewCloudEventsSink creates a CloudEventsSink filling in defaults for any unset configuration.
+func NewCloudEventsSink(c CloudEventsConfig) *CloudEventsSink {
+	sink := &CloudEventsSink{
+		url:       c.URL,
+		source:    c.Source,
+		eventType: c.Type,
+		client:    egress.HTTPClient(c.Timeout),
+	}
+	if sink.source == "" {
+		sink.source = defaultEventSource
+	}
+	if sink.eventType == "" {
+		sink.eventType = defaultEventType
+	}
+	if c.Timeout == 0 {
+		sink.client.Timeout = defaultEventTimeout
+	}
+	return sink
+}
+
+// Send delivers a single record as a CloudEvent.
+func (s *CloudEventsSink) Send(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %v", err)
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create cloudevent request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", cloudEventsSpecVersion)
+	req.Header.Set("ce-id", uuid.New().String())
+	req.Header.Set("ce-source", s.source)
+	req.Header.Set("ce-type", s.eventType)
+	req.Header.Set("ce-time", r.Time.Format(time.RFC3339Nano))
+	req.Header.Set("ce-subject", subject(r))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send cloudevent: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("cloudevent sink returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// subject identifies the object that a record is about, e.g. Deployment/my-namespace/nginx
+func subject(r Record) string {
+	parts := []string{r.Kind.Kind}
+	if r.Namespace != "" {
+		parts = append(parts, r.Namespace)
+	}
+	if r.Name != "" {
+		parts = append(parts, r.Name)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCloudEventsSinkUsesBinaryHTTPBinding(t *testing.T) {
+	var headers http.Header
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	sink := NewCloudEventsSink(CloudEventsConfig{URL: ts.URL, Source: "/clusters/prod"})
+	record := Record{
+		Time:      time.Date(2018, 9, 10, 9, 34, 31, 0, time.UTC),
+		Rule:      "my-rule",
+		Decision:  DecisionPatched,
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace: "default",
+		Name:      "nginx",
+	}
+	err := sink.Send(record)
+	require.NoError(t, err)
+
+	assert.Equal(t, "1.0", headers.Get("ce-specversion"))
+	assert.Equal(t, "/clusters/prod", headers.Get("ce-source"))
+	assert.Equal(t, defaultEventType, headers.Get("ce-type"))
+	assert.Equal(t, "2018-09-10T09:34:31Z", headers.Get("ce-time"))
+	assert.Equal(t, "Pod/default/nginx", headers.Get("ce-subject"))
+	assert.NotEmpty(t, headers.Get("ce-id"))
+	assert.Equal(t, "application/json", headers.Get("Content-Type"))
+
+	var sent Record
+	require.NoError(t, json.Unmarshal(body, &sent))
+	assert.Equal(t, record, sent)
+}
+
+func TestCloudEventsSinkReportsFailedDelivery(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	sink := NewCloudEventsSink(CloudEventsConfig{URL: ts.URL})
+	err := sink.Send(Record{Rule: "my-rule"})
+	assert.EqualError(t, err, "cloudevent sink returned status 503")
+}
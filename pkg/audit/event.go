@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	eventComponent = "kube-graffiti"
+	// events about cluster scoped objects have to live in a namespace somewhere
+	clusterEventNamespace = "default"
+)
+
+// EventSink records each audit record as a kubernetes Event against the object that it is about.
+type EventSink struct {
+	clientset kubernetes.Interface
+}
+
+// NewEventSink creates an EventSink using the given kubernetes client.
+func NewEventSink(k kubernetes.Interface) *EventSink {
+	return &EventSink{clientset: k}
+}
+
+// Send creates an Event for a single record.
+func (s *EventSink) Send(r Record) error {
+	if s.clientset == nil {
+		return fmt.Errorf("no kubernetes client available to create events")
+	}
+	event := newEvent(r)
+	if _, err := s.clientset.CoreV1().Events(event.Namespace).Create(event); err != nil {
+		return fmt.Errorf("failed to create audit event: %v", err)
+	}
+	return nil
+}
+
+func newEvent(r Record) *corev1.Event {
+	namespace := r.Namespace
+	if namespace == "" {
+		namespace = clusterEventNamespace
+	}
+	eventType := corev1.EventTypeNormal
+	reason := "Patched"
+	message := fmt.Sprintf("rule %s patched object for %s: %s", r.Rule, r.UserInfo.Username, string(r.Patch))
+	if r.Decision == DecisionBlocked {
+		eventType = corev1.EventTypeWarning
+		reason = "Blocked"
+		message = fmt.Sprintf("rule %s blocked %s by %s: %s", r.Rule, strings.ToLower(r.Operation), r.UserInfo.Username, r.Message)
+	}
+	apiVersion := r.Kind.Version
+	if r.Kind.Group != "" {
+		apiVersion = r.Kind.Group + "/" + r.Kind.Version
+	}
+	timestamp := metav1.NewTime(r.Time)
+
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "kube-graffiti-",
+			Namespace:    namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       r.Kind.Kind,
+			APIVersion: apiVersion,
+			Namespace:  r.Namespace,
+			Name:       r.Name,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: eventComponent},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEventSinkCreatesEventAgainstObject(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	req := testRequest()
+	req.Kind = metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	req.Namespace = "my-namespace"
+	req.Name = "nginx"
+	r, ok := NewRecord("my-rule", req, &admission.AdmissionResponse{
+		Allowed: true,
+		Patch:   []byte(`[{"op":"add","path":"/metadata/labels","value":{"a":"b"}}]`),
+	})
+	require.True(t, ok)
+
+	require.NoError(t, NewEventSink(clientset).Send(r))
+
+	events, err := clientset.CoreV1().Events("my-namespace").List(metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	event := events.Items[0]
+	assert.Equal(t, "Deployment", event.InvolvedObject.Kind)
+	assert.Equal(t, "apps/v1", event.InvolvedObject.APIVersion)
+	assert.Equal(t, "nginx", event.InvolvedObject.Name)
+	assert.Equal(t, corev1.EventTypeNormal, event.Type)
+	assert.Equal(t, "Patched", event.Reason)
+	assert.Contains(t, event.Message, "my-rule")
+	assert.Contains(t, event.Message, "minikube-user")
+}
+
+func TestEventSinkPutsClusterScopedEventsInDefaultNamespace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	r, ok := NewRecord("my-rule", testRequest(), &admission.AdmissionResponse{Allowed: false})
+	require.True(t, ok)
+
+	require.NoError(t, NewEventSink(clientset).Send(r))
+
+	events, err := clientset.CoreV1().Events("default").List(metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+	assert.Equal(t, "Namespace", events.Items[0].InvolvedObject.Kind)
+	assert.Equal(t, corev1.EventTypeWarning, events.Items[0].Type)
+	assert.Equal(t, "Blocked", events.Items[0].Reason)
+}
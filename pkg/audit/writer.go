@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const defaultFileMaxSize = 100
+
+// FileConfig configures writing audit records to a file which is rotated once it reaches max-size megabytes.
+type FileConfig struct {
+	Path       string `mapstructure:"path" yaml:"path"`
+	MaxSize    int    `mapstructure:"max-size" yaml:"max-size,omitempty"`
+	MaxBackups int    `mapstructure:"max-backups" yaml:"max-backups,omitempty"`
+	MaxAge     int    `mapstructure:"max-age" yaml:"max-age,omitempty"`
+}
+
+func (c FileConfig) validate() error {
+	if c.Path == "" {
+		return errors.New("audit.file.path is required when audit.sink is file")
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 || c.MaxAge < 0 {
+		return errors.New("audit.file max-size, max-backups and max-age can not be negative")
+	}
+	return nil
+}
+
+// WriterSink writes each record as a single line of json.
+// It is only ever called from the Auditor's delivery go-routine so needs no locking of its own.
+type WriterSink struct {
+	encoder *json.Encoder
+}
+
+// NewWriterSink creates a WriterSink which writes to w.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+// NewFileSink creates a WriterSink which writes to a file, rotating it when it reaches the maximum size.
+func NewFileSink(c FileConfig) *WriterSink {
+	maxSize := c.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultFileMaxSize
+	}
+	return NewWriterSink(&lumberjack.Logger{
+		Filename:   c.Path,
+		MaxSize:    maxSize,
+		MaxBackups: c.MaxBackups,
+		MaxAge:     c.MaxAge,
+	})
+}
+
+// Send writes a single record.
+func (s *WriterSink) Send(r Record) error {
+	if err := s.encoder.Encode(r); err != nil {
+		return fmt.Errorf("failed to write audit record: %v", err)
+	}
+	return nil
+}
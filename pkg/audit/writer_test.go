@@ -0,0 +1,74 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+)
+
+func testPatchedRecord(t *testing.T) Record {
+	r, ok := NewRecord("my-rule", testRequest(), &admission.AdmissionResponse{
+		Allowed: true,
+		Patch:   []byte(`[{"op":"add","path":"/metadata/labels","value":{"a":"b"}}]`),
+	})
+	require.True(t, ok)
+	return r
+}
+
+func TestWriterSinkWritesOneJSONRecordPerLine(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink(&buf)
+	require.NoError(t, sink.Send(testPatchedRecord(t)))
+	require.NoError(t, sink.Send(testPatchedRecord(t)))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var got map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+	assert.Equal(t, "my-rule", got["rule"])
+	assert.Equal(t, "patched", got["decision"])
+	assert.Equal(t, "Namespace", got["kind"].(map[string]interface{})["kind"])
+	assert.Equal(t, "minikube-user", got["userInfo"].(map[string]interface{})["username"])
+	assert.NotNil(t, got["patch"])
+}
+
+func TestFileSinkWritesToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	sink := NewFileSink(FileConfig{Path: path})
+	require.NoError(t, sink.Send(testPatchedRecord(t)))
+
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"rule":"my-rule"`)
+}
+
+func TestFileSinkRequiresAPath(t *testing.T) {
+	c := Config{Sink: SinkFile}
+	assert.EqualError(t, c.Validate(), "audit.file.path is required when audit.sink is file")
+}
@@ -16,11 +16,23 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Telefonica/kube-graffiti/pkg/audit"
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/Telefonica/kube-graffiti/pkg/evaluation"
+	"github.com/Telefonica/kube-graffiti/pkg/events"
 	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
 	"github.com/Telefonica/kube-graffiti/pkg/healthcheck"
 	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/queue"
 	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/rs/zerolog"
 )
 
 const (
@@ -32,11 +44,33 @@ const (
 // Configuration models the structre of our configuration values loaded through viper.
 type Configuration struct {
 	_             string                    `mapstructure:"config" yaml:"config"`
+	APIVersion    string                    `mapstructure:"apiVersion" yaml:"apiVersion,omitempty"`
 	LogLevel      string                    `mapstructure:"log-level" yaml:"log-level"`
+	Logging       log.Config                `mapstructure:"logging" yaml:"logging,omitempty"`
 	CheckExisting bool                      `mapstructure:"check-existing" yaml:"check-existing,omitempty"`
+	RuleConflicts string                    `mapstructure:"rule-conflicts" yaml:"rule-conflicts,omitempty"`
+	Existing      Existing                  `mapstructure:"existing" yaml:"existing,omitempty"`
 	HealthChecker healthcheck.HealthChecker `mapstructure:"health-checker" yaml:"health-checker,omitempty"`
 	Server        Server                    `mapstructure:"server" yaml:"server"`
+	Audit         audit.Config              `mapstructure:"audit" yaml:"audit,omitempty"`
+	Events        events.Config             `mapstructure:"events" yaml:"events,omitempty"`
+	Actions       queue.Config              `mapstructure:"actions" yaml:"actions,omitempty"`
+	Expiry        Expiry                    `mapstructure:"expiry" yaml:"expiry,omitempty"`
+	ConfigMaps    ConfigMaps                `mapstructure:"configmaps" yaml:"configmaps,omitempty"`
+	LastKnownGood LastKnownGood             `mapstructure:"last-known-good" yaml:"last-known-good,omitempty"`
+	Egress        egress.Config             `mapstructure:"egress" yaml:"egress,omitempty"`
+	Evaluation    evaluation.Config         `mapstructure:"evaluation" yaml:"evaluation,omitempty"`
+	Ignore        graffiti.Ignore           `mapstructure:"ignore" yaml:"ignore"`
+	KeyPrefixes   graffiti.KeyPrefixes      `mapstructure:"key-prefixes" yaml:"key-prefixes,omitempty"`
+	RuleDefaults  RuleDefaults              `mapstructure:"rule-defaults" yaml:"rule-defaults,omitempty"`
 	Rules         []Rule                    `mapstructure:"rules" yaml:"rules"`
+	// Shard is the shard of rules that this deployment serves, all of the rules when it is empty, and Shards lists
+	// the shards that are deployed.
+	Shard  string   `mapstructure:"shard" yaml:"shard,omitempty"`
+	Shards []string `mapstructure:"shards" yaml:"shards,omitempty"`
+	// Scope is cluster, or namespaced to only change the objects in the WatchNamespaces.
+	Scope           string   `mapstructure:"scope" yaml:"scope,omitempty"`
+	WatchNamespaces []string `mapstructure:"watch-namespaces" yaml:"watch-namespaces,omitempty"`
 }
 
 // Server contains all the settings for the webhook https server and access from the kubernetes api.
@@ -48,6 +82,100 @@ type Server struct {
 	CACertPath     string `mapstructure:"ca-cert-path" yaml:"ca-cert-path"`
 	ServerCertPath string `mapstructure:"cert-path" yaml:"cert-path"`
 	ServerKeyPath  string `mapstructure:"key-path" yaml:"key-path"`
+	// ShutdownAction controls what happens to our webhook registrations on shutdown, one of none, delete or ignore.
+	ShutdownAction  string        `mapstructure:"shutdown-action" yaml:"shutdown-action,omitempty"`
+	ShutdownTimeout time.Duration `mapstructure:"shutdown-timeout" yaml:"shutdown-timeout,omitempty"`
+	// AdmissionVersion is the version of the admissionregistration api that rules are registered with, v1 or, for
+	// clusters older than kubernetes 1.16, v1beta1.
+	AdmissionVersion string `mapstructure:"admission-version" yaml:"admission-version,omitempty"`
+	// RegistrationCheckInterval is how often our webhook registrations are checked and healed, 0 disables the checks.
+	RegistrationCheckInterval time.Duration `mapstructure:"registration-check-interval" yaml:"registration-check-interval,omitempty"`
+	// SourceLimits limits the rate of admission requests accepted from each source ip address.
+	SourceLimits webhook.SourceLimits `mapstructure:"source-limits" yaml:"source-limits,omitempty"`
+	// DecisionCache caches the responses of the rules to admission requests that they have already reviewed.
+	DecisionCache webhook.DecisionCache `mapstructure:"decision-cache" yaml:"decision-cache,omitempty"`
+	// MaxConcurrentRequests limits the admission requests served at once, 0 is unlimited.
+	MaxConcurrentRequests int `mapstructure:"max-concurrent-requests" yaml:"max-concurrent-requests,omitempty"`
+	// RequestTimeout is how long an admission request can take, including waiting for a free slot, 0 is no limit.
+	RequestTimeout time.Duration `mapstructure:"request-timeout" yaml:"request-timeout,omitempty"`
+	// MaxRequestSize limits the size in bytes of the admission requests that are read, 0 is unlimited, and
+	// OversizedRequests is whether the objects in larger requests are allowed unchanged or denied.
+	MaxRequestSize    int64  `mapstructure:"max-request-size" yaml:"max-request-size,omitempty"`
+	OversizedRequests string `mapstructure:"oversized-requests" yaml:"oversized-requests,omitempty"`
+	// RuleErrors is what happens to the objects of the admission requests that a rule fails to evaluate or patch,
+	// one of warn, deny or ignore.
+	RuleErrors string `mapstructure:"rule-errors" yaml:"rule-errors,omitempty"`
+	// WarmupBudget limits how long is spent compiling the rules' selectors, cel expressions and templates before
+	// serving, 0 is no limit.
+	WarmupBudget time.Duration `mapstructure:"warmup-budget" yaml:"warmup-budget,omitempty"`
+	// SelfCheck is a readiness check that dials our webhook over tls.
+	SelfCheck healthcheck.SelfCheck `mapstructure:"self-check" yaml:"self-check,omitempty"`
+	// RuleStatus shares the status of the rules between replicas, so that the status served by each covers them all.
+	RuleStatus metrics.RuleStatusSharing `mapstructure:"rule-status" yaml:"rule-status,omitempty"`
+	// DebugToken enables explaining how the rules evaluate an admission request, in the response headers, to
+	// requests that carry it in their debug header.  It is a secret and so is left out of snapshots.
+	DebugToken string `mapstructure:"debug-token" yaml:"-"`
+	// Listeners are extra https listeners, each with its own port and certificate, which serve the rules whose
+	// registrations name them.
+	Listeners []webhook.Listener `mapstructure:"listeners" yaml:"listeners,omitempty"`
+	// ClientAuth requires the apiserver to present a client certificate when it calls our webhooks.
+	ClientAuth webhook.ClientAuth `mapstructure:"client-auth" yaml:"client-auth,omitempty"`
+}
+
+// SelfCheckConfig is the server's self-check with its address defaulted to our webhook port through the self-check's
+// headless service, and its server name defaulted to our service.  Our service has no endpoints until we are ready, so
+// dialing it from a readiness check could never succeed, the self-check's service publishes not ready addresses.
+func (s Server) SelfCheckConfig() healthcheck.SelfCheck {
+	check := s.SelfCheck
+	if check.ServerName == "" {
+		check.ServerName = webhook.ServiceDNSName(s.Service, s.Namespace)
+	}
+	if check.Service == "" {
+		check.Service = s.Service + "-self-check"
+	}
+	if check.Address == "" {
+		check.Address = net.JoinHostPort(webhook.ServiceDNSName(check.Service, s.Namespace), strconv.Itoa(s.WebhookPort))
+	}
+	return check
+}
+
+// Existing controls which clusters the check of existing objects runs against.  By default it is only the cluster
+// that we are running in, but listing kubeconfig contexts runs it against each of their clusters instead.
+// Interval repeats the check of our own cluster, 0 only checks once at startup, and Windows restrict the check to
+// maintenance windows, pausing it outside of them.
+type Existing struct {
+	Kubeconfig string        `mapstructure:"kubeconfig" yaml:"kubeconfig,omitempty"`
+	Contexts   []string      `mapstructure:"contexts" yaml:"contexts,omitempty"`
+	Interval   time.Duration `mapstructure:"interval" yaml:"interval,omitempty"`
+	Windows    Windows       `mapstructure:"windows" yaml:"windows,omitempty"`
+	// PageSize is the number of objects fetched by each list call, which Workers then check in parallel.  QPS and
+	// Burst rate limit the calls that the check makes to the apiserver, and its progress is logged every
+	// ProgressInterval, 0 only logs when each resource is done.
+	PageSize         int64         `mapstructure:"page-size" yaml:"page-size,omitempty"`
+	Workers          int           `mapstructure:"workers" yaml:"workers,omitempty"`
+	QPS              float32       `mapstructure:"qps" yaml:"qps,omitempty"`
+	Burst            int           `mapstructure:"burst" yaml:"burst,omitempty"`
+	ProgressInterval time.Duration `mapstructure:"progress-interval" yaml:"progress-interval,omitempty"`
+}
+
+// Validate checks the interval, windows and limits, and that only our own cluster is checked repeatedly.
+func (e Existing) Validate() error {
+	if e.Interval < 0 {
+		return fmt.Errorf("existing.interval can not be negative")
+	}
+	if e.Interval > 0 && len(e.Contexts) > 0 {
+		return fmt.Errorf("existing.interval can not be used with existing.contexts, only our own cluster is checked repeatedly")
+	}
+	if e.PageSize < 0 || e.Workers < 0 || e.QPS < 0 || e.Burst < 0 || e.ProgressInterval < 0 {
+		return fmt.Errorf("existing page-size, workers, qps, burst and progress-interval can not be negative")
+	}
+	return e.Windows.Validate()
+}
+
+// Expiry controls the expiry controller, which deletes or labels the objects of rules with an expire payload once
+// they have expired.  It checks for expired objects every Interval, 0 disables it.
+type Expiry struct {
+	Interval time.Duration `mapstructure:"interval" yaml:"interval,omitempty"`
 }
 
 // Rule models a single graffiti rule with three sections for managing registration, matching and the payload to graffiti on the object.
@@ -55,10 +183,138 @@ type Rule struct {
 	Registration webhook.Registration `mapstructure:"registration" yaml:"registration"`
 	Matchers     graffiti.Matchers    `mapstructure:"matchers" yaml:"matchers,omitempty"`
 	Payload      graffiti.Payload     `mapstructure:"payload" yaml:"payload"`
+	// Impersonate is a '<namespace>/<name>' service account which check-existing impersonates when patching objects.
+	Impersonate string `mapstructure:"impersonate" yaml:"impersonate,omitempty"`
+	// Priority orders rules that match the same object, higher priorities first, including the webhook
+	// configurations that the apiserver calls, and StopOnMatch skips the rules after this one for the objects that
+	// it matches.
+	Priority    int  `mapstructure:"priority" yaml:"priority,omitempty"`
+	StopOnMatch bool `mapstructure:"stop-on-match" yaml:"stop-on-match,omitempty"`
+	// Shard assigns the rule to the kube-graffiti deployment that serves the shard.
+	Shard string `mapstructure:"shard" yaml:"shard,omitempty"`
+	// Rego is a policy which must also decide that an object matches, and can emit the labels and annotations to add.
+	Rego graffiti.Rego `mapstructure:"rego" yaml:"rego,omitempty"`
+	// ActiveFrom and ActiveUntil are RFC3339 times that the rule is only served and registered from and until, and
+	// ActiveWindows restricts it to recurring windows, so that temporary rules turn themselves on and off.
+	ActiveFrom    string  `mapstructure:"active-from" yaml:"active-from,omitempty"`
+	ActiveUntil   string  `mapstructure:"active-until" yaml:"active-until,omitempty"`
+	ActiveWindows Windows `mapstructure:"active-windows" yaml:"active-windows,omitempty"`
+}
+
+// ImpersonatedUser is the username of the service account that the rule impersonates, or empty when it doesn't.
+func (r Rule) ImpersonatedUser() string {
+	parts := strings.Split(r.Impersonate, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ""
+	}
+	return "system:serviceaccount:" + parts[0] + ":" + parts[1]
+}
+
+// NewRule combines a webhook registration with a graffiti rule, which is usually built with graffiti.NewRule, into a
+// configured rule.  The registration is given the rule's name when it doesn't have one.
+func NewRule(registration webhook.Registration, rule graffiti.Rule) Rule {
+	if registration.Name == "" {
+		registration.Name = rule.Name
+	}
+	registration.Type = rule.Type
+	return Rule{Registration: registration, Matchers: rule.Matchers, Payload: rule.Payload, Priority: rule.Priority, StopOnMatch: rule.StopOnMatch, Rego: rule.Rego}
+}
+
+// Default returns the configuration that a configuration file gets when it doesn't specify a setting, for building a
+// configuration in Go.  It has no rules and the server's namespace and service still have to be set.
+func Default() Configuration {
+	return Configuration{
+		APIVersion:    CurrentAPIVersion,
+		LogLevel:      "info",
+		RuleConflicts: RuleConflictsWarn,
+		Scope:         ScopeCluster,
+		HealthChecker: healthcheck.HealthChecker{Port: 8080, Path: "/healthz"},
+		Server: Server{
+			WebhookPort:               8443,
+			CompanyDomain:             "acme.com",
+			CACertPath:                "/ca-cert",
+			ServerCertPath:            "/server-cert",
+			ServerKeyPath:             "/server-key",
+			ShutdownAction:            webhook.ShutdownActionNone,
+			AdmissionVersion:          webhook.AdmissionVersionV1,
+			ShutdownTimeout:           20 * time.Second,
+			RegistrationCheckInterval: time.Minute,
+			MaxConcurrentRequests:     100,
+			RequestTimeout:            10 * time.Second,
+			WarmupBudget:              5 * time.Second,
+			MaxRequestSize:            8 << 20,
+			OversizedRequests:         webhook.OversizedAllow,
+			RuleErrors:                webhook.RuleErrorsWarn,
+			SourceLimits:              webhook.SourceLimits{Burst: 50, Action: webhook.SourceLimitAlert},
+			DecisionCache:             webhook.DecisionCache{TTL: webhook.DefaultDecisionCacheTTL},
+			SelfCheck:                 healthcheck.SelfCheck{Timeout: 2 * time.Second},
+		},
+		Existing: Existing{PageSize: 100, Workers: 4, QPS: 10, Burst: 20, ProgressInterval: 30 * time.Second},
+		Audit:    audit.Config{Sink: audit.SinkNone},
+		Ignore:   graffiti.Ignore{Namespaces: graffiti.DefaultIgnoredNamespaces},
+	}
+}
+
+// GraffitiRule converts the configured rule into the graffiti rule that is evaluated against objects.
+func (r Rule) GraffitiRule() graffiti.Rule {
+	return graffiti.Rule{
+		Name:        r.Registration.Name,
+		Type:        r.Registration.Type,
+		Matchers:    r.Matchers,
+		Payload:     r.Payload,
+		Priority:    r.Priority,
+		StopOnMatch: r.StopOnMatch,
+		Rego:        r.Rego,
+	}
+}
+
+// WebhookRegistration is the rule's registration with the rule's priority, which orders its webhook configuration.
+func (r Rule) WebhookRegistration() webhook.Registration {
+	registration := r.Registration
+	registration.Priority = r.Priority
+	return registration
+}
+
+// SortRules sorts rules into the order that they are evaluated in, by descending priority and then by name.
+func SortRules(rules []Rule) {
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].GraffitiRule().Precedes(rules[j].GraffitiRule()) })
+}
+
+// ApplyKeyPrefixes prefixes the keys added by rules which have prefix-keys set with "<server.company-domain>/", and
+// tells every payload the company domain for the annotations that they record.
+func (c *Configuration) ApplyKeyPrefixes() {
+	for i := range c.Rules {
+		c.Rules[i].Payload = c.Rules[i].Payload.WithKeyPrefix(c.Server.CompanyDomain).WithCompanyDomain(c.Server.CompanyDomain)
+	}
+}
+
+// ExpandEnv substitutes ${ENV_VAR} references in the label and annotation values added by rules, and by the
+// rule-defaults, so that the same rules can be reused across clusters.
+func (c *Configuration) ExpandEnv(lookup func(string) (string, bool)) error {
+	defaults, err := graffiti.Payload{Additions: c.RuleDefaults.Additions}.WithEnv(lookup)
+	if err != nil {
+		return fmt.Errorf("rule-defaults: %v", err)
+	}
+	c.RuleDefaults.Additions = defaults.Additions
+	for i := range c.Rules {
+		payload, err := c.Rules[i].Payload.WithEnv(lookup)
+		if err != nil {
+			return fmt.Errorf("rule '%s': %v", c.Rules[i].Registration.Name, err)
+		}
+		c.Rules[i].Payload = payload
+	}
+	return nil
 }
 
 // ValidateConfig is responsible for throwing errors when the configuration is bad.
+// The contents of each rule are not validated here but by ValidRules, so that one broken rule doesn't prevent the
+// rest from being loaded.
+// The errors that it returns are ErrConfigInvalid.
 func (c Configuration) ValidateConfig() error {
+	return invalid(c.validate())
+}
+
+func (c Configuration) validate() error {
 	mylog := log.ComponentLogger(componentName, "ValidateConfig")
 	mylog.Debug().Msg("validating configuration")
 
@@ -68,9 +324,81 @@ func (c Configuration) ValidateConfig() error {
 	if err := c.validateWebhookArgs(); err != nil {
 		return err
 	}
+	if err := c.HealthChecker.TLS.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid health-checker configuration")
+		return err
+	}
+	if err := c.Audit.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid audit configuration")
+		return err
+	}
+	if err := c.Events.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid events configuration")
+		return err
+	}
+	if err := c.Evaluation.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid evaluation configuration")
+		return err
+	}
+	if err := c.Actions.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid actions configuration")
+		return err
+	}
+	if err := c.ConfigMaps.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid configmaps configuration")
+		return err
+	}
+	if err := c.LastKnownGood.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid last-known-good configuration")
+		return err
+	}
+	if err := c.Existing.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid existing configuration")
+		return err
+	}
+	if err := c.Egress.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid egress configuration")
+		return err
+	}
+	if err := c.Logging.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid logging configuration")
+		return err
+	}
+	if err := c.Ignore.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid ignore configuration")
+		return err
+	}
+	if err := c.KeyPrefixes.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid key-prefixes configuration")
+		return err
+	}
+	if err := c.RuleDefaults.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid rule-defaults")
+		return err
+	}
+	if c.Expiry.Interval < 0 {
+		mylog.Error().Dur("interval", c.Expiry.Interval).Msg("invalid expiry.interval")
+		return fmt.Errorf("expiry.interval can not be negative")
+	}
 	if err := c.validateRules(); err != nil {
 		return err
 	}
+	if c.Expiry.Interval == 0 {
+		for _, rule := range c.Rules {
+			if rule.Payload.Expire.TTL > 0 {
+				mylog.Warn().Str("rule", rule.Registration.Name).Msg("rule stamps objects with an expiry time but the expiry controller is disabled, set expiry.interval to expire them")
+			}
+		}
+	}
+	if err := c.validateRuleConflicts(); err != nil {
+		return err
+	}
+	if err := c.validateShards(); err != nil {
+		return err
+	}
+	if err := c.validateScope(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -97,6 +425,85 @@ func (c Configuration) validateWebhookArgs() error {
 		mylog.Error().Str("parameter", "server.service").Msg("missing required parameter server.service")
 		return fmt.Errorf("missing required parameter server.service")
 	}
+	switch c.Server.ShutdownAction {
+	case "", webhook.ShutdownActionNone, webhook.ShutdownActionDelete, webhook.ShutdownActionIgnore:
+	default:
+		mylog.Error().Str("parameter", "server.shutdown-action").Str("value", c.Server.ShutdownAction).Msg("invalid server.shutdown-action")
+		return fmt.Errorf("invalid server.shutdown-action '%s', must be one of none, delete or ignore", c.Server.ShutdownAction)
+	}
+	if err := webhook.ValidateAdmissionVersion(c.Server.AdmissionVersion); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.admission-version")
+		return err
+	}
+	if c.Server.MaxConcurrentRequests < 0 || c.Server.RequestTimeout < 0 {
+		mylog.Error().Int("max-concurrent-requests", c.Server.MaxConcurrentRequests).Dur("request-timeout", c.Server.RequestTimeout).Msg("invalid request limits")
+		return fmt.Errorf("server.max-concurrent-requests and server.request-timeout can not be negative")
+	}
+	if c.Server.MaxRequestSize < 0 {
+		mylog.Error().Int64("max-request-size", c.Server.MaxRequestSize).Msg("invalid server.max-request-size")
+		return fmt.Errorf("server.max-request-size can not be negative")
+	}
+	if err := webhook.ValidateOversizedAction(c.Server.OversizedRequests); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.oversized-requests")
+		return err
+	}
+	if err := webhook.ValidateRuleErrorsAction(c.Server.RuleErrors); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.rule-errors")
+		return err
+	}
+	if c.Server.WarmupBudget < 0 {
+		mylog.Error().Dur("warmup-budget", c.Server.WarmupBudget).Msg("invalid server.warmup-budget")
+		return fmt.Errorf("server.warmup-budget can not be negative")
+	}
+	if err := c.Server.SourceLimits.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.source-limits")
+		return err
+	}
+	if err := c.Server.DecisionCache.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.decision-cache")
+		return err
+	}
+	if err := c.Server.SelfCheckConfig().Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.self-check")
+		return err
+	}
+	if err := c.Server.ClientAuth.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.client-auth")
+		return err
+	}
+	if err := c.Server.RuleStatus.Validate(); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.rule-status")
+		return err
+	}
+	if c.Server.ClientAuth.Enabled() && c.Server.SelfCheck.Review && c.Server.SelfCheck.ClientCertPath == "" {
+		err := errors.New("server.self-check.review needs server.self-check.client-cert-path when server.client-auth is enabled")
+		mylog.Error().Err(err).Msg("invalid server.self-check")
+		return err
+	}
+	if err := c.validateListeners(); err != nil {
+		mylog.Error().Err(err).Msg("invalid server.listeners")
+		return err
+	}
+	return nil
+}
+
+// validateListeners checks each of the extra listeners and that none of them share a name or a port.
+func (c Configuration) validateListeners() error {
+	names := make(map[string]bool)
+	ports := map[int]string{c.Server.WebhookPort: "server.port", c.HealthChecker.Port: "health-checker.port"}
+	for _, l := range c.Server.Listeners {
+		if err := l.Validate(); err != nil {
+			return err
+		}
+		if names[l.Name] {
+			return fmt.Errorf("there is more than one server listener named '%s'", l.Name)
+		}
+		names[l.Name] = true
+		if used, ok := ports[l.Port]; ok {
+			return fmt.Errorf("server listener '%s' can't use port %d, it is used by %s", l.Name, l.Port, used)
+		}
+		ports[l.Port] = "listener " + l.Name
+	}
 	return nil
 }
 
@@ -104,7 +511,8 @@ func (c Configuration) validateRules() error {
 	mylog := log.ComponentLogger(componentName, "validateRules")
 	mylog.Debug().Msg("validating graffiti rules")
 
-	if len(c.Rules) == 0 {
+	// all of the rules can come from configmaps
+	if len(c.Rules) == 0 && !c.ConfigMaps.Enabled() {
 		mylog.Error().Msg("configuration does not contain any rules")
 		return errors.New("no rules found")
 	}
@@ -118,14 +526,80 @@ func (c Configuration) validateRules() error {
 		}
 		existingRuleNames[rule.Registration.Name] = true
 
-		gr := graffiti.Rule{
-			Name:     rule.Registration.Name,
-			Matchers: rule.Matchers,
-			Payload:  rule.Payload,
-		}
-		if err := gr.Validate(mylog); err != nil {
+		// and must be allowed by the version of the admissionregistration api that they are registered with
+		if err := rule.Registration.ValidateForAdmissionVersion(c.Server.AdmissionVersion); err != nil {
+			mylog.Error().Err(err).Str("rule", rule.Registration.Name).Msg("rule can not be registered with the admission version")
 			return err
 		}
+		if rule.Registration.Listener != "" && !c.hasListener(rule.Registration.Listener) {
+			mylog.Error().Str("rule", rule.Registration.Name).Str("listener", rule.Registration.Listener).Msg("rule names a listener that isn't configured")
+			return fmt.Errorf("rule %s is served by listener '%s', which isn't one of server.listeners", rule.Registration.Name, rule.Registration.Listener)
+		}
 	}
 	return nil
 }
+
+// ValidRules validates each rule on its own, including that it only adds keys with the allowed key-prefixes, and
+// returns the rules which are valid, along with the errors of those which are not keyed by rule name.
+func (c Configuration) ValidRules() ([]Rule, map[string]error) {
+	mylog := log.ComponentLogger(componentName, "ValidRules")
+	var valid []Rule
+	invalid := make(map[string]error)
+	for _, rule := range c.Rules {
+		if err := c.validateRule(rule, mylog); err != nil {
+			mylog.Error().Err(err).Str("rule", rule.Registration.Name).Msg("rule is invalid and will not be loaded")
+			invalid[rule.Registration.Name] = err
+			continue
+		}
+		valid = append(valid, rule)
+	}
+	return valid, invalid
+}
+
+func (r Rule) validate(mylog zerolog.Logger) error {
+	if err := r.Registration.Validate(); err != nil {
+		mylog.Error().Err(err).Str("rule", r.Registration.Name).Msg("invalid rule registration")
+		return err
+	}
+	if r.Priority < webhook.MinPriority || r.Priority > webhook.MaxPriority {
+		mylog.Error().Str("rule", r.Registration.Name).Int("priority", r.Priority).Msg("invalid rule priority")
+		return fmt.Errorf("rule '%s' has a priority of %d, it must be between %d and %d", r.Registration.Name, r.Priority, webhook.MinPriority, webhook.MaxPriority)
+	}
+	if r.Impersonate != "" && r.ImpersonatedUser() == "" {
+		mylog.Error().Str("rule", r.Registration.Name).Str("impersonate", r.Impersonate).Msg("invalid service account to impersonate")
+		return fmt.Errorf("rule '%s' has an invalid impersonate '%s', must be '<namespace>/<name>'", r.Registration.Name, r.Impersonate)
+	}
+	if r.Shard != "" {
+		if err := validateShardName(r.Shard); err != nil {
+			mylog.Error().Err(err).Str("rule", r.Registration.Name).Msg("invalid rule shard")
+			return fmt.Errorf("rule '%s' has an %v", r.Registration.Name, err)
+		}
+	}
+	if err := r.validateSchedule(); err != nil {
+		mylog.Error().Err(err).Str("rule", r.Registration.Name).Msg("invalid rule schedule")
+		return err
+	}
+	return r.GraffitiRule().Validate(mylog)
+}
+
+// validateRule validates a rule and checks that it only adds keys with the allowed key-prefixes.
+func (c Configuration) validateRule(r Rule, mylog zerolog.Logger) error {
+	if err := r.validate(mylog); err != nil {
+		return err
+	}
+	if err := c.KeyPrefixes.Check(r.Payload); err != nil {
+		mylog.Error().Err(err).Str("rule", r.Registration.Name).Msg("rule adds a key without an allowed prefix")
+		return fmt.Errorf("rule '%s' is not allowed to add its keys: %v", r.Registration.Name, err)
+	}
+	return nil
+}
+
+// hasListener is true when the extra listener is configured.
+func (c Configuration) hasListener(name string) bool {
+	for _, l := range c.Server.Listeners {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -14,9 +14,13 @@ limitations under the License.
 package config
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
 	yaml "gopkg.in/yaml.v2"
 
 	"github.com/stretchr/testify/assert"
@@ -194,3 +198,234 @@ rules:
 	err = config.ValidateConfig()
 	assert.EqualError(t, err, "rule my-rule is invalid - found duplicate rules with the same name, they must be unique", "two rules with the same name should cause a validation error")
 }
+
+func TestInvalidShutdownActionThrowsAnError(t *testing.T) {
+	var source = `---
+log-level: debug
+server:
+  namespace: test-namespace
+  service: graffiti-service
+  shutdown-action: explode
+rules:
+- registration:
+    name: my-rule
+  payload:
+    additions:
+      labels:
+        graffiti: "painted-this-object"
+`
+	var config Configuration
+	err := yaml.Unmarshal([]byte(source), &config)
+	require.NoError(t, err, "the test configuration should unmarshal")
+	err = config.ValidateConfig()
+	assert.EqualError(t, err, "invalid server.shutdown-action 'explode', must be one of none, delete or ignore")
+}
+
+func TestAdmissionVersionIsValidated(t *testing.T) {
+	var source = `---
+log-level: info
+server:
+  namespace: test-namespace
+  service: graffiti-service
+  admission-version: %s
+rules:
+- registration:
+    name: my-rule
+    resources: ["pods"]
+    failure-policy: Ignore
+    side-effects: Some
+  payload:
+    additions:
+      labels:
+        graffiti: "painted-this-object"
+`
+	for version, expected := range map[string]string{
+		"v2":      "invalid server.admission-version 'v2', must be either v1 or v1beta1",
+		"v1":      "rule 'my-rule' has side-effects 'Some', which is only allowed with server.admission-version v1beta1, must be either None or NoneOnDryRun",
+		"v1beta1": "",
+	} {
+		var config Configuration
+		require.NoError(t, yaml.Unmarshal([]byte(fmt.Sprintf(source, version)), &config))
+		err := config.ValidateConfig()
+		if expected == "" {
+			assert.NoError(t, err, version)
+		} else {
+			assert.EqualError(t, err, expected, version)
+		}
+	}
+}
+
+func TestListenersAreValidated(t *testing.T) {
+	listener := webhook.Listener{Name: "critical", Port: 8444, CACertPath: "ca", CertPath: "cert", KeyPath: "key"}
+	newConfig := func(listeners ...webhook.Listener) Configuration {
+		config := Default()
+		config.Server.Namespace = "test-namespace"
+		config.Server.Service = "graffiti-service"
+		config.Server.Listeners = listeners
+		config.Rules = []Rule{NewRule(webhook.Registration{Name: "my-rule", Listener: "critical"}, graffiti.NewRule("my-rule"))}
+		return config
+	}
+	assert.NoError(t, newConfig(listener).ValidateConfig())
+	assert.EqualError(t, newConfig().ValidateConfig(), "rule my-rule is served by listener 'critical', which isn't one of server.listeners")
+	assert.EqualError(t, newConfig(listener, listener).ValidateConfig(), "there is more than one server listener named 'critical'")
+
+	clash := listener
+	clash.Port = 8443
+	assert.EqualError(t, newConfig(clash).ValidateConfig(), "server listener 'critical' can't use port 8443, it is used by server.port")
+}
+
+func TestNegativeWarmupBudgetThrowsAnError(t *testing.T) {
+	config := Default()
+	config.Server.Namespace = "test-namespace"
+	config.Server.Service = "graffiti-service"
+	config.Server.WarmupBudget = -time.Second
+	config.Rules = []Rule{NewRule(webhook.Registration{}, graffiti.NewRule("my-rule"))}
+	assert.EqualError(t, config.ValidateConfig(), "server.warmup-budget can not be negative")
+}
+
+func TestNegativeExpiryIntervalThrowsAnError(t *testing.T) {
+	config := Default()
+	config.Server.Namespace = "test-namespace"
+	config.Server.Service = "graffiti-service"
+	config.Expiry.Interval = -time.Minute
+	config.Rules = []Rule{NewRule(webhook.Registration{}, graffiti.NewRule("my-rule"))}
+	assert.EqualError(t, config.ValidateConfig(), "expiry.interval can not be negative")
+}
+
+func TestValidationErrorsAreErrConfigInvalid(t *testing.T) {
+	config := Default()
+	assert.True(t, errors.Is(config.ValidateConfig(), ErrConfigInvalid))
+
+	config.Server.Namespace = "test-namespace"
+	config.Server.Service = "graffiti-service"
+	config.Rules = []Rule{NewRule(webhook.Registration{}, graffiti.NewRule("my-rule"))}
+	assert.NoError(t, config.ValidateConfig())
+
+	config.Shard = "team-a"
+	assert.True(t, errors.Is(config.SelectShard(), ErrConfigInvalid))
+}
+
+func TestSelfCheckDefaultsToOurWebhookPortThroughItsService(t *testing.T) {
+	config := Default()
+	config.Server.Namespace = "test-namespace"
+	config.Server.Service = "graffiti-service"
+	config.Server.SelfCheck.Enabled = true
+	config.Rules = []Rule{NewRule(webhook.Registration{}, graffiti.NewRule("my-rule"))}
+	require.NoError(t, config.ValidateConfig())
+	check := config.Server.SelfCheckConfig()
+	assert.Equal(t, "graffiti-service-self-check.test-namespace.svc:8443", check.Address, "our service has no endpoints until we are ready")
+	assert.Equal(t, "graffiti-service.test-namespace.svc", check.ServerName)
+
+	config.Server.SelfCheck.Service = "graffiti-not-ready"
+	assert.Equal(t, "graffiti-not-ready.test-namespace.svc:8443", config.Server.SelfCheckConfig().Address)
+	config.Server.SelfCheck.Service = ""
+
+	config.Server.SelfCheck.InsecureSkipVerifyLocalhost = true
+	assert.Error(t, config.ValidateConfig(), "the default address isn't localhost")
+
+	config.Server.SelfCheck.Address = "[::1]:8443"
+	config.Server.SelfCheck.InsecureSkipVerifyLocalhost = true
+	require.NoError(t, config.ValidateConfig())
+	check = config.Server.SelfCheckConfig()
+	assert.Equal(t, "[::1]:8443", check.Address)
+	assert.Equal(t, "graffiti-service.test-namespace.svc", check.ServerName, "the certificate is still verified against our service")
+
+	config.Server.SelfCheck.Address = "graffiti-service.test-namespace.svc:443"
+	assert.EqualError(t, config.ValidateConfig(), "server.self-check.insecure-skip-verify-localhost can only be used with a localhost address, not 'graffiti-service.test-namespace.svc:443'")
+}
+
+func TestSelfCheckReviewNeedsAClientCertificateWithClientAuth(t *testing.T) {
+	config := Default()
+	config.Server.Namespace = "test-namespace"
+	config.Server.Service = "graffiti-service"
+	config.Server.SelfCheck.Enabled = true
+	config.Server.SelfCheck.Review = true
+	config.Rules = []Rule{NewRule(webhook.Registration{}, graffiti.NewRule("my-rule"))}
+	require.NoError(t, config.ValidateConfig())
+
+	config.Server.ClientAuth.CAPath = "/certs/apiserver-ca.pem"
+	assert.EqualError(t, config.ValidateConfig(), "server.self-check.review needs server.self-check.client-cert-path when server.client-auth is enabled")
+
+	config.Server.SelfCheck.ClientCertPath = "/certs/self-check.pem"
+	config.Server.SelfCheck.ClientKeyPath = "/certs/self-check-key.pem"
+	assert.NoError(t, config.ValidateConfig())
+}
+
+func TestApplyKeyPrefixesUsesCompanyDomain(t *testing.T) {
+	var config Configuration
+	err := yaml.Unmarshal([]byte(testConfig), &config)
+	require.NoError(t, err, "the test configuration should unmarshal")
+
+	config.Rules[0].Payload.PrefixKeys = true
+	config.Rules[0].Payload.PrefixExempt = []string{"team"}
+	config.Rules[0].Payload.Additions.Labels["team"] = "platform"
+	config.Rules[0].Payload.Additions.Labels["example.com/owner"] = "dave"
+	config.ApplyKeyPrefixes()
+
+	assert.Equal(t, map[string]string{
+		"acme.com/result":   "this_is_indeed_daveish",
+		"team":              "platform",
+		"example.com/owner": "dave",
+	}, config.Rules[0].Payload.Additions.Labels)
+	assert.Equal(t, map[string]string{"graffiti": "woz_'ere_2018"}, config.Rules[1].Payload.Additions.Annotations, "rules without prefix-keys should not be changed")
+	assert.NoError(t, config.ValidateConfig())
+}
+
+func TestInvalidRulesAreIsolated(t *testing.T) {
+	var config Configuration
+	require.NoError(t, yaml.Unmarshal([]byte(testConfig), &config))
+	config.Rules[0].Matchers.LabelSelectors = []string{"name in ("}
+	assert.NoError(t, config.ValidateConfig(), "a broken rule should not fail the whole configuration")
+
+	valid, invalid := config.ValidRules()
+	require.Len(t, valid, 1)
+	assert.Equal(t, "annotate-everything-except-kube-system", valid[0].Registration.Name)
+	require.Len(t, invalid, 1)
+	assert.Contains(t, invalid["label-namespaces-called-dave"].Error(), "matcher contains an invalid label selector 'name in ('")
+}
+
+func TestRulesImpersonateServiceAccounts(t *testing.T) {
+	var config Configuration
+	require.NoError(t, yaml.Unmarshal([]byte(testConfig), &config))
+	config.Rules[0].Impersonate = "team-a/graffiti"
+	config.Rules[1].Impersonate = "graffiti"
+	assert.Equal(t, "system:serviceaccount:team-a:graffiti", config.Rules[0].ImpersonatedUser())
+
+	valid, invalid := config.ValidRules()
+	require.Len(t, valid, 1)
+	assert.Equal(t, "team-a/graffiti", valid[0].Impersonate)
+	require.Len(t, invalid, 1)
+	assert.EqualError(t, invalid["annotate-everything-except-kube-system"], "rule 'annotate-everything-except-kube-system' has an invalid impersonate 'graffiti', must be '<namespace>/<name>'")
+}
+
+func TestRulePrioritiesOrderTheirWebhookConfigurations(t *testing.T) {
+	var config Configuration
+	require.NoError(t, yaml.Unmarshal([]byte(testConfig), &config))
+	config.Rules[0].Priority = 10
+	config.Rules[1].Priority = webhook.MaxPriority + 1
+	assert.Equal(t, 10, config.Rules[0].WebhookRegistration().Priority)
+	assert.Equal(t, "09989-label-namespaces-called-dave", config.Rules[0].WebhookRegistration().ConfigurationName())
+
+	valid, invalid := config.ValidRules()
+	require.Len(t, valid, 1)
+	require.Len(t, invalid, 1)
+	assert.EqualError(t, invalid["annotate-everything-except-kube-system"], "rule 'annotate-everything-except-kube-system' has a priority of 10000, it must be between -9999 and 9999")
+}
+
+func TestRulesCanOnlyAddKeysWithTheAllowedPrefixes(t *testing.T) {
+	var config Configuration
+	require.NoError(t, yaml.Unmarshal([]byte(testConfig), &config))
+	config.KeyPrefixes = graffiti.KeyPrefixes{Allowed: []string{"*.acme.com/"}}
+	config.Rules[0].Payload.Additions.Labels["ops.acme.com/tier"] = "1"
+	config.Rules[1].Payload.Additions.Annotations["sidecar.istio.io/inject"] = "false"
+	require.NoError(t, config.ValidateConfig())
+
+	valid, invalid := config.ValidRules()
+	require.Len(t, valid, 1)
+	assert.Equal(t, "label-namespaces-called-dave", valid[0].Registration.Name)
+	require.Len(t, invalid, 1)
+	assert.EqualError(t, invalid["annotate-everything-except-kube-system"], "rule 'annotate-everything-except-kube-system' is not allowed to add its keys: annotation key 'sidecar.istio.io/inject' does not have one of the allowed key-prefixes: *.acme.com/")
+
+	config.KeyPrefixes.Allowed = append(config.KeyPrefixes.Allowed, "")
+	assert.EqualError(t, config.ValidateConfig(), "key-prefixes.allowed contains an empty prefix")
+}
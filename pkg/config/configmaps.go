@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/mitchellh/mapstructure"
+	yaml "gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ConfigMaps selects the ConfigMaps that contribute more rules, alongside those of the configuration file, by label
+// selector and optionally only in some namespaces.  Loading rules from ConfigMaps is disabled when Selector is empty.
+type ConfigMaps struct {
+	Selector   string   `mapstructure:"selector" yaml:"selector,omitempty"`
+	Namespaces []string `mapstructure:"namespaces" yaml:"namespaces,omitempty"`
+}
+
+// Enabled is true when rules are loaded from ConfigMaps.
+func (c ConfigMaps) Enabled() bool {
+	return c.Selector != ""
+}
+
+// Validate checks that the selector is a valid label selector, and that namespaces are only given with a selector.
+func (c ConfigMaps) Validate() error {
+	if !c.Enabled() {
+		if len(c.Namespaces) > 0 {
+			return fmt.Errorf("configmaps.namespaces can only be used with a configmaps.selector")
+		}
+		return nil
+	}
+	if err := graffiti.ValidateLabelSelector(c.Selector); err != nil {
+		return fmt.Errorf("invalid configmaps.selector '%s': %v", c.Selector, err)
+	}
+	return nil
+}
+
+// configMapRules is the document in each key of a rules ConfigMap.
+type configMapRules struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Rules      interface{} `yaml:"rules"`
+}
+
+// RulesFromConfigMap decodes the rules in a ConfigMap.  Each of its keys holds a yaml document with the same
+// 'apiVersion' and 'rules' as the configuration file, the keys are read in order.  Environment variables are not
+// expanded in the rules of ConfigMaps.
+func RulesFromConfigMap(cm *corev1.ConfigMap) ([]Rule, error) {
+	var keys []string
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var rules []Rule
+	for _, key := range keys {
+		var doc configMapRules
+		if err := yaml.UnmarshalStrict([]byte(cm.Data[key]), &doc); err != nil {
+			return nil, fmt.Errorf("configmap %s/%s key '%s' is not a rules document: %v", cm.Namespace, cm.Name, key, err)
+		}
+		raw, err := MigrateRules(doc.APIVersion, doc.Rules)
+		if err != nil {
+			return nil, fmt.Errorf("configmap %s/%s key '%s': %v", cm.Namespace, cm.Name, key, err)
+		}
+		var decoded []Rule
+		if err := decodeRules(raw, &decoded); err != nil {
+			return nil, fmt.Errorf("configmap %s/%s key '%s' has invalid rules: %v", cm.Namespace, cm.Name, key, err)
+		}
+		rules = append(rules, decoded...)
+	}
+	return rules, nil
+}
+
+// decodeRules decodes raw rules in the same way as viper decodes the rules of the configuration file.
+func decodeRules(raw interface{}, rules *[]Rule) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+			graffiti.StringToBooleanOperatorFunc(),
+		),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           rules,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(raw)
+}
+
+// MergeRules adds rules from ConfigMaps to the configuration's rules, which always win.  A rule from a ConfigMap is
+// rejected when it is invalid or has the name of a rule that is already loaded, and with rule-conflicts set to error
+// when it sets a label or annotation to a different value than a loaded rule.  The rules of other shards are left
+// out.  It returns the rules that were added, with their keys prefixed, and why the others were rejected.
+func (c Configuration) MergeRules(extra []Rule) ([]Rule, []error) {
+	mylog := log.ComponentLogger(componentName, "MergeRules")
+	loaded := make(map[string]bool)
+	for _, rule := range c.Rules {
+		loaded[rule.Registration.Name] = true
+	}
+
+	merged := Configuration{Server: c.Server, Rules: append([]Rule(nil), c.Rules...)}
+	var added []Rule
+	var rejected []error
+	for _, rule := range extra {
+		name := rule.Registration.Name
+		if c.Shard != "" && rule.Shard != c.Shard {
+			mylog.Debug().Str("rule", name).Str("shard", c.Shard).Msg("rule is not assigned to our shard")
+			continue
+		}
+		rule = c.RuleDefaults.apply(rule)
+		if err := c.validateRule(rule, mylog); err != nil {
+			rejected = append(rejected, err)
+			continue
+		}
+		if loaded[name] {
+			rejected = append(rejected, fmt.Errorf("rule '%s' has the same name as a rule that is already loaded", name))
+			continue
+		}
+		var report []string
+		candidate := Configuration{Server: c.Server, Rules: append(merged.Rules, rule)}
+		for _, conflict := range candidate.Conflicts() {
+			if conflict.Rules[1] != name {
+				continue
+			}
+			mylog.Warn().Strs("rules", conflict.Rules[:]).Str("kind", conflict.Kind).Str("key", conflict.Key).Strs("values", conflict.Values[:]).Msg("rules set the same key to different values")
+			report = append(report, conflict.String())
+		}
+		if len(report) > 0 && c.RuleConflicts == RuleConflictsError {
+			rejected = append(rejected, fmt.Errorf("rule '%s' conflicts with a loaded rule: %s", name, strings.Join(report, "; ")))
+			continue
+		}
+		loaded[name] = true
+		merged.Rules = candidate.Rules
+		rule.Payload = rule.Payload.WithKeyPrefix(c.Server.CompanyDomain).WithCompanyDomain(c.Server.CompanyDomain)
+		added = append(added, rule)
+	}
+	return added, rejected
+}
@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testConfigMapRules = `apiVersion: v2
+rules:
+- registration:
+    name: label-team-a-pods
+    failure-policy: Ignore
+    resources: pods
+  matchers:
+    label-selectors:
+    - "app = web"
+  payload:
+    prefix-keys: true
+    additions:
+      labels:
+        team: a
+`
+
+func testConfigMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "graffiti-rules"}, Data: data}
+}
+
+func TestRulesFromConfigMap(t *testing.T) {
+	rules, err := RulesFromConfigMap(testConfigMap(map[string]string{"rules.yaml": testConfigMapRules}))
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, "label-team-a-pods", rules[0].Registration.Name)
+	assert.Equal(t, []string{"pods"}, rules[0].Registration.Resources)
+	assert.Equal(t, []string{"app = web"}, rules[0].Matchers.LabelSelectors)
+	assert.Equal(t, map[string]string{"team": "a"}, rules[0].Payload.Additions.Labels)
+
+	_, err = RulesFromConfigMap(testConfigMap(map[string]string{"rules.yaml": "rules:\n- registration:\n    name: x\n  payload:\n    colour: red\n"}))
+	assert.Contains(t, err.Error(), "configmap team-a/graffiti-rules key 'rules.yaml' has invalid rules")
+
+	_, err = RulesFromConfigMap(testConfigMap(map[string]string{"rules.yaml": "settings: {}\n"}))
+	assert.Contains(t, err.Error(), "configmap team-a/graffiti-rules key 'rules.yaml' is not a rules document")
+}
+
+func TestMergeRulesFromConfigMaps(t *testing.T) {
+	pods := webhook.Registration{Resources: []string{"pods"}, FailurePolicy: "Ignore"}
+	c := conflictTestConfig(NewRule(pods, graffiti.NewRule("platform").AddLabels(map[string]string{"acme.com/team": "platform"})))
+
+	rules, err := RulesFromConfigMap(testConfigMap(map[string]string{"rules.yaml": testConfigMapRules}))
+	require.NoError(t, err)
+	extra := append(rules,
+		NewRule(pods, graffiti.NewRule("platform").AddLabels(map[string]string{"owner": "someone"})),
+		NewRule(pods, graffiti.NewRule("broken").MatchLabels("app in (").AddLabels(map[string]string{"owner": "someone"})),
+	)
+
+	added, rejected := c.MergeRules(extra)
+	require.Len(t, added, 1)
+	assert.Equal(t, map[string]string{"acme.com/team": "a"}, added[0].Payload.Additions.Labels, "the keys of rules from configmaps are prefixed")
+	require.Len(t, rejected, 2)
+	assert.EqualError(t, rejected[0], "rule 'platform' has the same name as a rule that is already loaded")
+	assert.Contains(t, rejected[1].Error(), "rule 'broken' failed validation")
+
+	c.RuleConflicts = RuleConflictsError
+	added, rejected = c.MergeRules(rules)
+	assert.Empty(t, added)
+	require.Len(t, rejected, 1)
+	assert.EqualError(t, rejected[0], "rule 'label-team-a-pods' conflicts with a loaded rule: rules 'platform' and 'label-team-a-pods' both set label 'acme.com/team', to 'platform' and 'a'")
+
+	c.Shard = "team-b"
+	added, rejected = c.MergeRules(rules)
+	assert.Empty(t, added, "the rules of other shards are left out")
+	assert.Empty(t, rejected)
+}
+
+func TestValidateConfigMaps(t *testing.T) {
+	assert.NoError(t, ConfigMaps{}.Validate())
+	assert.NoError(t, ConfigMaps{Selector: "kube-graffiti/rules=true", Namespaces: []string{"team-a"}}.Validate())
+	assert.EqualError(t, ConfigMaps{Namespaces: []string{"team-a"}}.Validate(), "configmaps.namespaces can only be used with a configmaps.selector")
+	assert.Contains(t, ConfigMaps{Selector: "app in ("}.Validate().Error(), "invalid configmaps.selector 'app in ('")
+
+	c := conflictTestConfig()
+	c.ConfigMaps.Selector = "kube-graffiti/rules=true"
+	assert.NoError(t, c.ValidateConfig(), "all of the rules can come from configmaps")
+}
@@ -0,0 +1,141 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+)
+
+const (
+	// RuleConflictsWarn logs the rules which set the same label or annotation to different values, it is the default.
+	RuleConflictsWarn = "warn"
+	// RuleConflictsError fails validation when rules set the same label or annotation to different values.
+	RuleConflictsError = "error"
+)
+
+// Conflict is a label or annotation that two rules, which can match the same objects, set to different values.
+// Whichever rule the apiserver calls last wins.
+type Conflict struct {
+	Rules  [2]string
+	Kind   string
+	Key    string
+	Values [2]string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("rules '%s' and '%s' both set %s '%s', to '%s' and '%s'", c.Rules[0], c.Rules[1], c.Kind, c.Key, c.Values[0], c.Values[1])
+}
+
+// Conflicts finds the labels and annotations which are added with different values by rules that can match the same
+// objects.  Rules are assumed to overlap when they are registered for the same resources, unless they match
+// different names, or one of them stops on match before the other.
+func (c Configuration) Conflicts() []Conflict {
+	var conflicts []Conflict
+	for i := range c.Rules {
+		for j := i + 1; j < len(c.Rules); j++ {
+			a, b := c.Rules[i], c.Rules[j]
+			if !a.mayOverlap(b) {
+				continue
+			}
+			pa := a.Payload.WithKeyPrefix(c.Server.CompanyDomain)
+			pb := b.Payload.WithKeyPrefix(c.Server.CompanyDomain)
+			conflicts = append(conflicts, conflictingValues(a, b, "label", pa.Additions.Labels, pb.Additions.Labels)...)
+			conflicts = append(conflicts, conflictingValues(a, b, "annotation", pa.Additions.Annotations, pb.Additions.Annotations)...)
+		}
+	}
+	return conflicts
+}
+
+func conflictingValues(a, b Rule, kind string, av, bv map[string]string) []Conflict {
+	var keys []string
+	for k, v := range av {
+		if other, ok := bv[k]; ok && other != v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var conflicts []Conflict
+	for _, k := range keys {
+		conflicts = append(conflicts, Conflict{
+			Rules:  [2]string{a.Registration.Name, b.Registration.Name},
+			Kind:   kind,
+			Key:    k,
+			Values: [2]string{av[k], bv[k]},
+		})
+	}
+	return conflicts
+}
+
+// mayOverlap is true unless the two rules can never both paint the same object.
+func (r Rule) mayOverlap(other Rule) bool {
+	if r.Registration.IsValidating() || other.Registration.IsValidating() {
+		return false
+	}
+	if !r.Registration.Overlaps(other.Registration) {
+		return false
+	}
+	// a stop-on-match rule skips the rules after it for every object that it matches
+	ra, rb := r.GraffitiRule(), other.GraffitiRule()
+	if (ra.StopOnMatch && ra.Precedes(rb)) || (rb.StopOnMatch && rb.Precedes(ra)) {
+		return false
+	}
+	return namesMayOverlap(r.Matchers.Names, other.Matchers.Names)
+}
+
+// namesMayOverlap is only false when both rules match exact names and have none in common.
+func namesMayOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return true
+	}
+	exact := make(map[string]bool)
+	for _, name := range a {
+		if strings.Contains(name, "*") {
+			return true
+		}
+		exact[name] = true
+	}
+	for _, name := range b {
+		if strings.Contains(name, "*") || exact[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRuleConflicts reports the conflicting rules, failing validation with rule-conflicts set to error.
+func (c Configuration) validateRuleConflicts() error {
+	mylog := log.ComponentLogger(componentName, "validateRuleConflicts")
+	switch c.RuleConflicts {
+	case "", RuleConflictsWarn, RuleConflictsError:
+	default:
+		mylog.Error().Str("parameter", "rule-conflicts").Str("value", c.RuleConflicts).Msg("invalid rule-conflicts")
+		return fmt.Errorf("invalid rule-conflicts '%s', must be either warn or error", c.RuleConflicts)
+	}
+
+	conflicts := c.Conflicts()
+	var report []string
+	for _, conflict := range conflicts {
+		mylog.Warn().Strs("rules", conflict.Rules[:]).Str("kind", conflict.Kind).Str("key", conflict.Key).Strs("values", conflict.Values[:]).Msg("rules set the same key to different values")
+		report = append(report, conflict.String())
+	}
+	if len(conflicts) > 0 && c.RuleConflicts == RuleConflictsError {
+		return fmt.Errorf("found %d conflicting rules: %s", len(conflicts), strings.Join(report, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func conflictTestConfig(rules ...Rule) Configuration {
+	c := Default()
+	c.Server.Namespace = "test-namespace"
+	c.Server.Service = "graffiti-service"
+	c.Rules = rules
+	return c
+}
+
+func TestConflictingRulesAreReported(t *testing.T) {
+	pods := webhook.Registration{Resources: []string{"pods"}}
+	c := conflictTestConfig(
+		NewRule(pods, graffiti.NewRule("team-a").AddLabels(map[string]string{"team": "a", "env": "prod"})),
+		NewRule(webhook.Registration{Resources: []string{"*"}}, graffiti.NewRule("team-b").AddLabels(map[string]string{"team": "b", "env": "prod"})),
+		NewRule(webhook.Registration{Resources: []string{"services"}}, graffiti.NewRule("services").AddLabels(map[string]string{"team": "c"})),
+	)
+
+	conflicts := c.Conflicts()
+	require.Len(t, conflicts, 2, "only the rules registered for the same resources should conflict")
+	assert.Equal(t, "rules 'team-a' and 'team-b' both set label 'team', to 'a' and 'b'", conflicts[0].String())
+	assert.Equal(t, [2]string{"team-b", "services"}, conflicts[1].Rules)
+
+	assert.NoError(t, c.ValidateConfig(), "conflicts are only logged by default")
+	c.RuleConflicts = RuleConflictsError
+	assert.EqualError(t, c.ValidateConfig(), "found 2 conflicting rules: rules 'team-a' and 'team-b' both set label 'team', to 'a' and 'b'; rules 'team-b' and 'services' both set label 'team', to 'b' and 'c'")
+	c.RuleConflicts = "maybe"
+	assert.EqualError(t, c.ValidateConfig(), "invalid rule-conflicts 'maybe', must be either warn or error")
+}
+
+func TestRulesThatCanNotOverlapDoNotConflict(t *testing.T) {
+	pods := webhook.Registration{Resources: []string{"pods"}}
+	c := conflictTestConfig(
+		NewRule(pods, graffiti.NewRule("web").MatchNames("web").AddAnnotations(map[string]string{"owner": "web"})),
+		NewRule(pods, graffiti.NewRule("api").MatchNames("api", "api-v2").AddAnnotations(map[string]string{"owner": "api"})),
+		NewRule(pods, graffiti.NewRule("first").WithPriority(10).StoppingOnMatch().AddLabels(map[string]string{"team": "a"})),
+		NewRule(pods, graffiti.NewRule("second").AddLabels(map[string]string{"team": "b"})),
+		NewRule(pods, graffiti.NewRule("deny").Validating()),
+	)
+	c.RuleConflicts = RuleConflictsError
+	assert.Empty(t, c.Conflicts())
+	assert.NoError(t, c.ValidateConfig())
+}
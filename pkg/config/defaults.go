@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+)
+
+// RuleDefaults are merged into every rule, so that many similar rules don't have to repeat the same labels,
+// annotations and matchers.  A rule's own values win: its additions replace the default additions with the same keys,
+// and each kind of matcher that it sets replaces the default of that kind.  Validating rules only take the matchers.
+type RuleDefaults struct {
+	Additions graffiti.Additions `mapstructure:"additions" yaml:"additions,omitempty"`
+	Matchers  graffiti.Matchers  `mapstructure:"matchers" yaml:"matchers,omitempty"`
+}
+
+// Validate checks that the defaults could be merged into rules.
+func (d RuleDefaults) Validate() error {
+	if err := d.Matchers.ValidateDefaults(); err != nil {
+		return fmt.Errorf("invalid rule-defaults: %v", err)
+	}
+	return nil
+}
+
+// apply returns a copy of the rule with the defaults merged into it.
+func (d RuleDefaults) apply(r Rule) Rule {
+	r.Matchers = r.Matchers.WithDefaults(d.Matchers)
+	if !r.Registration.IsValidating() {
+		r.Payload = r.Payload.WithDefaultAdditions(d.Additions)
+	}
+	return r
+}
+
+// ApplyRuleDefaults merges the rule-defaults into each of the rules, it is called as the configuration is loaded,
+// before environment variables are expanded and keys are prefixed.
+func (c *Configuration) ApplyRuleDefaults() {
+	for i := range c.Rules {
+		c.Rules[i] = c.RuleDefaults.apply(c.Rules[i])
+	}
+}
@@ -0,0 +1,46 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleDefaultsApply(t *testing.T) {
+	d := RuleDefaults{
+		Additions: graffiti.Additions{Labels: map[string]string{"team": "platform", "tier": "web"}},
+		Matchers:  graffiti.Matchers{LabelSelectors: []string{"app"}, BooleanOperator: graffiti.AND},
+	}
+	require.NoError(t, d.Validate())
+	pods := webhook.Registration{Resources: []string{"pods"}}
+
+	r := d.apply(NewRule(pods, graffiti.NewRule("own").AddLabels(map[string]string{"tier": "db"}).DeleteLabels("team")))
+	assert.Equal(t, map[string]string{"tier": "db"}, r.Payload.Additions.Labels, "the rule's own and deleted keys win")
+	assert.Equal(t, []string{"app"}, r.Matchers.LabelSelectors)
+
+	r = d.apply(NewRule(pods, graffiti.NewRule("block").Block()))
+	assert.Empty(t, r.Payload.Additions.Labels, "blocking payloads are left alone")
+
+	validating := webhook.Registration{Resources: []string{"pods"}, Type: graffiti.RuleTypeValidating}
+	r = d.apply(NewRule(validating, graffiti.NewRule("deny").MatchLabels("app = db").Block()))
+	assert.Empty(t, r.Payload.Additions.Labels)
+	assert.Equal(t, []string{"app = db"}, r.Matchers.LabelSelectors, "a rule's own matchers win")
+
+	d.Matchers.Negate = true
+	assert.EqualError(t, d.Validate(), "invalid rule-defaults: default matchers can not set negate or boolean-operator, they must be set by each rule")
+}
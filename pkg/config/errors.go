@@ -0,0 +1,44 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "errors"
+
+// ErrConfigInvalid is what every configuration validation error is, check for it with errors.Is.
+var ErrConfigInvalid = errors.New("invalid configuration")
+
+// invalidError marks an error as ErrConfigInvalid without changing its message.
+type invalidError struct {
+	err error
+}
+
+func (e invalidError) Error() string {
+	return e.err.Error()
+}
+
+func (e invalidError) Unwrap() error {
+	return e.err
+}
+
+func (e invalidError) Is(target error) bool {
+	return target == ErrConfigInvalid
+}
+
+// invalid marks err as ErrConfigInvalid, it returns nil when err is nil.
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return invalidError{err: err}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	yaml "gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// lastKnownGoodKey is the key of the snapshot in the last-known-good ConfigMap.
+const lastKnownGoodKey = "config.yaml"
+
+// LastKnownGood is where a snapshot of the last configuration that loaded and validated is kept, in a file at Path
+// and/or in a ConfigMap given as '<namespace>/<name>'.  When the configuration is broken on a restart the snapshot is
+// used instead, so that a bad push of the configuration can never take admission down.
+type LastKnownGood struct {
+	Path      string `mapstructure:"path" yaml:"path,omitempty"`
+	ConfigMap string `mapstructure:"configmap" yaml:"configmap,omitempty"`
+}
+
+// Enabled is true when snapshots are kept somewhere.
+func (l LastKnownGood) Enabled() bool {
+	return l.Path != "" || l.ConfigMap != ""
+}
+
+// Validate checks that the ConfigMap is given as '<namespace>/<name>'.
+func (l LastKnownGood) Validate() error {
+	if l.ConfigMap == "" {
+		return nil
+	}
+	if _, _, ok := l.configMap(); !ok {
+		return fmt.Errorf("invalid last-known-good.configmap '%s', must be '<namespace>/<name>'", l.ConfigMap)
+	}
+	return nil
+}
+
+func (l LastKnownGood) configMap() (string, string, bool) {
+	parts := strings.Split(l.ConfigMap, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// Save writes a snapshot of the configuration to the file and to the ConfigMap, whichever are set.  The file is
+// replaced atomically so that a crash can't leave a partial snapshot behind.
+func (l LastKnownGood) Save(c Configuration, k kubernetes.Interface) error {
+	snapshot, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot the configuration: %v", err)
+	}
+	if l.Path != "" {
+		if err := writeFileAtomically(l.Path, snapshot); err != nil {
+			return fmt.Errorf("failed to save the last-known-good configuration to %s: %v", l.Path, err)
+		}
+	}
+	if namespace, name, ok := l.configMap(); ok {
+		configMaps := k.CoreV1().ConfigMaps(namespace)
+		data := map[string]string{lastKnownGoodKey: string(snapshot)}
+		cm, err := configMaps.Get(name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			_, err = configMaps.Create(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Data: data})
+		case err == nil:
+			cm.Data = data
+			_, err = configMaps.Update(cm)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save the last-known-good configuration to configmap %s: %v", l.ConfigMap, err)
+		}
+	}
+	return nil
+}
+
+// Load reads the snapshot from the file or, when there isn't one, from the ConfigMap.  It returns false when no
+// snapshot has been saved yet.
+func (l LastKnownGood) Load(k kubernetes.Interface) (Configuration, bool, error) {
+	mylog := log.ComponentLogger(componentName, "LastKnownGood.Load")
+	var c Configuration
+	if l.Path != "" {
+		snapshot, err := ioutil.ReadFile(l.Path)
+		switch {
+		case err == nil:
+			mylog.Info().Str("path", l.Path).Msg("loading the last-known-good configuration from file")
+			return l.decode(snapshot)
+		case !os.IsNotExist(err):
+			return c, false, fmt.Errorf("failed to read the last-known-good configuration from %s: %v", l.Path, err)
+		}
+	}
+	if namespace, name, ok := l.configMap(); ok {
+		cm, err := k.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+		case err != nil:
+			return c, false, fmt.Errorf("failed to read the last-known-good configuration from configmap %s: %v", l.ConfigMap, err)
+		default:
+			mylog.Info().Str("configmap", l.ConfigMap).Msg("loading the last-known-good configuration from configmap")
+			return l.decode([]byte(cm.Data[lastKnownGoodKey]))
+		}
+	}
+	return c, false, nil
+}
+
+func (l LastKnownGood) decode(snapshot []byte) (Configuration, bool, error) {
+	var c Configuration
+	if err := yaml.Unmarshal(snapshot, &c); err != nil {
+		return c, false, fmt.Errorf("invalid last-known-good configuration: %v", err)
+	}
+	return c, true, nil
+}
+
+// writeFileAtomically writes the file alongside its destination and then renames it into place.
+func writeFileAtomically(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func lastKnownGoodConfig() Configuration {
+	c := Default()
+	c.Server.Namespace = "kube-graffiti"
+	c.Server.Service = "kube-graffiti"
+	c.Rules = []Rule{NewRule(
+		webhook.Registration{Name: "label-pods", FailurePolicy: "Ignore", Resources: []string{"pods"}},
+		graffiti.Rule{Payload: graffiti.Payload{Additions: graffiti.Additions{Labels: map[string]string{"team": "a"}}}},
+	)}
+	return c
+}
+
+func TestLastKnownGoodValidate(t *testing.T) {
+	assert.NoError(t, LastKnownGood{}.Validate())
+	assert.NoError(t, LastKnownGood{ConfigMap: "kube-graffiti/last-known-good"}.Validate())
+	assert.EqualError(t, LastKnownGood{ConfigMap: "last-known-good"}.Validate(), "invalid last-known-good.configmap 'last-known-good', must be '<namespace>/<name>'")
+}
+
+func TestLastKnownGoodRoundTripsThroughAFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "last-known-good")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	l := LastKnownGood{Path: filepath.Join(dir, "config.yaml")}
+
+	_, found, err := l.Load(nil)
+	require.NoError(t, err)
+	assert.False(t, found, "there is no snapshot before one is saved")
+
+	require.NoError(t, l.Save(lastKnownGoodConfig(), nil))
+	c, found, err := l.Load(nil)
+	require.NoError(t, err)
+	assert.True(t, found)
+	require.Len(t, c.Rules, 1)
+	assert.Equal(t, "label-pods", c.Rules[0].Registration.Name)
+	assert.Equal(t, map[string]string{"team": "a"}, c.Rules[0].Payload.Additions.Labels)
+	assert.NoError(t, c.ValidateConfig(), "the snapshot is a configuration that validates")
+}
+
+func TestLastKnownGoodRoundTripsThroughAConfigMap(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	l := LastKnownGood{ConfigMap: "kube-graffiti/last-known-good"}
+
+	_, found, err := l.Load(clientset)
+	require.NoError(t, err)
+	assert.False(t, found, "there is no snapshot before one is saved")
+
+	first := lastKnownGoodConfig()
+	require.NoError(t, l.Save(first, clientset))
+	second := lastKnownGoodConfig()
+	second.Rules[0].Payload.Additions.Labels["team"] = "b"
+	require.NoError(t, l.Save(second, clientset), "an existing snapshot is updated")
+
+	c, found, err := l.Load(clientset)
+	require.NoError(t, err)
+	assert.True(t, found)
+	require.Len(t, c.Rules, 1)
+	assert.Equal(t, map[string]string{"team": "b"}, c.Rules[0].Payload.Additions.Labels)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+)
+
+// The versions of the configuration file schema that we can read.
+const (
+	// APIVersionV1 is any configuration without an apiVersion, its rules may still use the legacy 'matcher' key and
+	// carry their additions, deletions, json-patch and block at the top level of the rule rather than in a payload.
+	APIVersionV1 = "v1"
+	// APIVersionV2 only accepts the 'matchers' and 'payload' rule sections.
+	APIVersionV2 = "v2"
+	// CurrentAPIVersion is the version that all configurations are migrated to before they are loaded.
+	CurrentAPIVersion = APIVersionV2
+)
+
+// legacyPayloadKeys are the payload settings that v1 rules could specify outside of a payload section.
+var legacyPayloadKeys = []string{"additions", "deletions", "json-patch", "block"}
+
+// MigrateRules converts the raw rules read from a configuration file of the given apiVersion into the shape of the
+// current version.  It refuses to guess when a rule specifies both the legacy and current form of a setting, so that
+// an upgrade never silently drops part of a rule.
+func MigrateRules(apiVersion string, rules interface{}) (interface{}, error) {
+	mylog := log.ComponentLogger(componentName, "MigrateRules")
+	switch apiVersion {
+	case "", APIVersionV1:
+	case APIVersionV2:
+		return rules, nil
+	default:
+		mylog.Error().Str("apiVersion", apiVersion).Msg("unsupported configuration apiVersion")
+		return nil, fmt.Errorf("unsupported configuration apiVersion '%s', must be one of %s or %s", apiVersion, APIVersionV1, APIVersionV2)
+	}
+	if rules == nil {
+		return nil, nil
+	}
+
+	list, ok := rules.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("rules must be a list")
+	}
+	migrated := make([]interface{}, 0, len(list))
+	for i, raw := range list {
+		rule, ok := stringMap(raw)
+		if !ok {
+			return nil, fmt.Errorf("rule %d is not a map", i)
+		}
+		name := ruleName(rule, i)
+
+		if matcher, ok := rule["matcher"]; ok {
+			if _, ok := rule["matchers"]; ok {
+				mylog.Error().Str("rule", name).Msg("rule has both matcher and matchers")
+				return nil, fmt.Errorf("rule '%s' can not specify both the legacy 'matcher' and 'matchers'", name)
+			}
+			mylog.Warn().Str("rule", name).Msg("converted legacy rule key 'matcher' to 'matchers'")
+			rule["matchers"] = matcher
+			delete(rule, "matcher")
+		}
+
+		payload := map[string]interface{}{}
+		if raw, ok := rule["payload"]; ok && raw != nil {
+			if payload, ok = stringMap(raw); !ok {
+				return nil, fmt.Errorf("rule '%s' payload is not a map", name)
+			}
+		}
+		for _, key := range legacyPayloadKeys {
+			value, ok := rule[key]
+			if !ok {
+				continue
+			}
+			if _, ok := payload[key]; ok {
+				mylog.Error().Str("rule", name).Str("key", key).Msg("rule has the same setting in and outside of its payload")
+				return nil, fmt.Errorf("rule '%s' can not specify '%s' both in its payload and as a legacy rule key", name, key)
+			}
+			mylog.Warn().Str("rule", name).Str("key", key).Msg("moved legacy rule key into the payload")
+			payload[key] = value
+			delete(rule, key)
+			rule["payload"] = payload
+		}
+		migrated = append(migrated, rule)
+	}
+	return migrated, nil
+}
+
+// stringMap copies a map decoded from yaml, whose keys may be of type interface{}, into a map[string]interface{}.
+func stringMap(raw interface{}) (map[string]interface{}, bool) {
+	result := make(map[string]interface{})
+	switch m := raw.(type) {
+	case map[string]interface{}:
+		for k, v := range m {
+			result[k] = v
+		}
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			result[fmt.Sprintf("%v", k)] = v
+		}
+	default:
+		return nil, false
+	}
+	return result, true
+}
+
+// ruleName returns the registration name of a raw rule for error messages, or its position if it doesn't have one.
+func ruleName(rule map[string]interface{}, i int) string {
+	if registration, ok := stringMap(rule["registration"]); ok {
+		if name, ok := registration["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+	return fmt.Sprintf("#%d", i)
+}
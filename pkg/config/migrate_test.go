@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var legacyRules = `---
+- registration:
+    name: label-pods
+    resources: ["pods"]
+  matcher:
+    label-selectors:
+    - "app = web"
+  additions:
+    labels:
+      team: web
+- registration:
+    name: block-pods
+    resources: ["pods"]
+  block: true
+`
+
+func migratedRules(t *testing.T, apiVersion, source string) ([]Rule, error) {
+	var raw interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(source), &raw))
+	migrated, err := MigrateRules(apiVersion, raw)
+	if err != nil {
+		return nil, err
+	}
+	data, err := yaml.Marshal(migrated)
+	require.NoError(t, err)
+	var rules []Rule
+	require.NoError(t, yaml.UnmarshalStrict(data, &rules))
+	return rules, nil
+}
+
+func TestMigrateRulesConvertsLegacyKeys(t *testing.T) {
+	for _, version := range []string{"", APIVersionV1} {
+		rules, err := migratedRules(t, version, legacyRules)
+		require.NoError(t, err)
+		require.Len(t, rules, 2)
+		assert.Equal(t, []string{"app = web"}, rules[0].Matchers.LabelSelectors)
+		assert.Equal(t, map[string]string{"team": "web"}, rules[0].Payload.Additions.Labels)
+		assert.True(t, rules[1].Payload.Block)
+	}
+}
+
+func TestMigrateRulesLeavesV2Alone(t *testing.T) {
+	source := "- registration:\n    name: label-pods\n  matchers:\n    label-selectors: [\"app = web\"]\n  payload:\n    block: true\n"
+	rules, err := migratedRules(t, APIVersionV2, source)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app = web"}, rules[0].Matchers.LabelSelectors)
+
+	var raw interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(legacyRules), &raw))
+	migrated, err := MigrateRules(APIVersionV2, raw)
+	require.NoError(t, err)
+	data, err := yaml.Marshal(migrated)
+	require.NoError(t, err)
+	var legacy []Rule
+	assert.Error(t, yaml.UnmarshalStrict(data, &legacy), "v2 configurations should not accept the legacy keys")
+}
+
+func TestMigrateRulesRejectsConflictsAndUnknownVersions(t *testing.T) {
+	_, err := migratedRules(t, APIVersionV1, "- registration:\n    name: label-pods\n  matcher: {}\n  matchers: {}\n")
+	assert.EqualError(t, err, "rule 'label-pods' can not specify both the legacy 'matcher' and 'matchers'")
+
+	_, err = migratedRules(t, APIVersionV1, "- additions: {}\n  payload:\n    additions: {}\n")
+	assert.EqualError(t, err, "rule '#0' can not specify 'additions' both in its payload and as a legacy rule key")
+
+	_, err = MigrateRules("v3", nil)
+	assert.EqualError(t, err, "unsupported configuration apiVersion 'v3', must be one of v1 or v2")
+}
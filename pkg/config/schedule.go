@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Scheduled is true when the rule is only active from or until a time, or within windows, and so has to be
+// enabled and disabled while we are running.
+func (r Rule) Scheduled() bool {
+	return r.ActiveFrom != "" || r.ActiveUntil != "" || len(r.ActiveWindows) > 0
+}
+
+// Active is true when the time is within the rule's schedule, which a rule without one always is.  A rule with a
+// schedule that can't be understood is never active, but those are rejected when the rule is validated.
+func (r Rule) Active(t time.Time) bool {
+	from, until, err := r.activePeriod()
+	if err != nil {
+		return false
+	}
+	if !from.IsZero() && t.Before(from) {
+		return false
+	}
+	if !until.IsZero() && !t.Before(until) {
+		return false
+	}
+	return r.ActiveWindows.Open(t)
+}
+
+// Expired is true when the rule's active-until has passed, so that it will never be active again.
+func (r Rule) Expired(t time.Time) bool {
+	_, until, err := r.activePeriod()
+	return err == nil && !until.IsZero() && !t.Before(until)
+}
+
+// ActiveRules returns the rules which are active at the time.
+func ActiveRules(rules []Rule, t time.Time) []Rule {
+	var active []Rule
+	for _, rule := range rules {
+		if rule.Active(t) {
+			active = append(active, rule)
+		}
+	}
+	return active
+}
+
+// validateSchedule checks that the active-from and active-until times and the active-windows can be understood.
+func (r Rule) validateSchedule() error {
+	from, until, err := r.activePeriod()
+	if err != nil {
+		return fmt.Errorf("rule '%s' has an %v", r.Registration.Name, err)
+	}
+	if !from.IsZero() && !until.IsZero() && !from.Before(until) {
+		return fmt.Errorf("rule '%s' has an active-until which is not after its active-from", r.Registration.Name)
+	}
+	if err := r.ActiveWindows.validate("active-windows"); err != nil {
+		return fmt.Errorf("rule '%s' has an %v", r.Registration.Name, err)
+	}
+	return nil
+}
+
+// activePeriod parses the active-from and active-until times, either of which is zero when it isn't set.
+func (r Rule) activePeriod() (from, until time.Time, err error) {
+	if r.ActiveFrom != "" {
+		if from, err = time.Parse(time.RFC3339, r.ActiveFrom); err != nil {
+			return from, until, fmt.Errorf("invalid active-from '%s', must be an RFC3339 time such as 2006-01-02T15:04:05Z", r.ActiveFrom)
+		}
+	}
+	if r.ActiveUntil != "" {
+		if until, err = time.Parse(time.RFC3339, r.ActiveUntil); err != nil {
+			return from, until, fmt.Errorf("invalid active-until '%s', must be an RFC3339 time such as 2006-01-02T15:04:05Z", r.ActiveUntil)
+		}
+	}
+	return from, until, nil
+}
@@ -0,0 +1,82 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scheduledRule(from, until string, windows ...Window) Rule {
+	return Rule{Registration: webhook.Registration{Name: "freeze"}, ActiveFrom: from, ActiveUntil: until, ActiveWindows: windows}
+}
+
+func TestRuleActive(t *testing.T) {
+	at := func(day, hour int) time.Time { return time.Date(2020, 3, day, hour, 0, 0, 0, time.UTC) }
+
+	always := Rule{}
+	assert.False(t, always.Scheduled())
+	assert.True(t, always.Active(at(2, 0)))
+
+	period := scheduledRule("2020-03-02T09:00:00Z", "2020-03-04T09:00:00+00:00")
+	assert.True(t, period.Scheduled())
+	assert.False(t, period.Active(at(2, 8)), "not active before active-from")
+	assert.True(t, period.Active(at(2, 9)))
+	assert.True(t, period.Active(at(4, 8)))
+	assert.False(t, period.Active(at(4, 9)), "not active from active-until")
+	assert.False(t, period.Expired(at(4, 8)))
+	assert.True(t, period.Expired(at(4, 9)))
+
+	// 2020-03-02 is a monday
+	nights := scheduledRule("", "2020-03-06T00:00:00Z", Window{Days: []string{"mon", "tue"}, Start: "22:00", End: "02:00"})
+	assert.True(t, nights.Active(at(2, 23)))
+	assert.True(t, nights.Active(at(3, 1)))
+	assert.False(t, nights.Active(at(3, 12)))
+	assert.False(t, nights.Active(at(4, 23)), "not active on wednesday nights")
+	assert.False(t, nights.Active(at(9, 23)), "not active once expired")
+
+	rules := ActiveRules([]Rule{always, period, nights}, at(2, 23))
+	assert.Equal(t, []Rule{always, period, nights}, rules)
+	assert.Equal(t, []Rule{always}, ActiveRules([]Rule{always, period, nights}, at(5, 12)))
+}
+
+func TestRuleScheduleValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		err  string
+	}{
+		{name: "period", rule: scheduledRule("2020-03-02T09:00:00Z", "2020-03-04T09:00:00Z")},
+		{name: "bad active-from", rule: scheduledRule("2020-03-02", ""), err: "rule 'freeze' has an invalid active-from '2020-03-02'"},
+		{name: "bad active-until", rule: scheduledRule("", "tomorrow"), err: "rule 'freeze' has an invalid active-until 'tomorrow'"},
+		{name: "until before from", rule: scheduledRule("2020-03-04T09:00:00Z", "2020-03-02T09:00:00Z"), err: "active-until which is not after its active-from"},
+		{name: "bad window", rule: scheduledRule("", "", Window{Start: "25:00", End: "02:00"}), err: "rule 'freeze' has an invalid active-windows[0]: invalid start"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.rule.validateSchedule()
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+			assert.False(t, tc.rule.Active(time.Date(2020, 3, 3, 0, 0, 0, 0, time.UTC)))
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ScopeCluster lets the rules change objects in every namespace of the cluster, it is the default.
+	ScopeCluster = "cluster"
+	// ScopeNamespaced only lets the rules change the objects in the watch-namespaces, and those namespaces, for
+	// multi-tenant clusters where we must not touch the objects of other tenants.
+	ScopeNamespaced = "namespaced"
+)
+
+// WatchedNamespaces are the only namespaces whose objects the rules may change, or nil when they may change the
+// objects of any namespace.
+func (c Configuration) WatchedNamespaces() []string {
+	if c.Scope != ScopeNamespaced {
+		return nil
+	}
+	return c.WatchNamespaces
+}
+
+// validateScope checks the scope, and that the namespaces to watch are given when, and only when, it is namespaced.
+func (c Configuration) validateScope() error {
+	mylog := log.ComponentLogger(componentName, "validateScope")
+	switch c.Scope {
+	case "", ScopeCluster:
+		if len(c.WatchNamespaces) > 0 {
+			mylog.Error().Strs("watch-namespaces", c.WatchNamespaces).Msg("watch-namespaces is set but the scope isn't namespaced")
+			return fmt.Errorf("watch-namespaces can only be set when the scope is %s", ScopeNamespaced)
+		}
+	case ScopeNamespaced:
+		if len(c.WatchNamespaces) == 0 {
+			mylog.Error().Msg("the scope is namespaced but there are no watch-namespaces")
+			return fmt.Errorf("watch-namespaces must list the namespaces to watch when the scope is %s", ScopeNamespaced)
+		}
+		for _, ns := range c.WatchNamespaces {
+			if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+				mylog.Error().Str("namespace", ns).Strs("errors", errs).Msg("invalid namespace in watch-namespaces")
+				return fmt.Errorf("invalid namespace '%s' in watch-namespaces: %s", ns, strings.Join(errs, ", "))
+			}
+		}
+	default:
+		mylog.Error().Str("scope", c.Scope).Msg("invalid scope")
+		return fmt.Errorf("invalid scope '%s', must be one of %s or %s", c.Scope, ScopeCluster, ScopeNamespaced)
+	}
+	return nil
+}
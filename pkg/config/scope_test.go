@@ -0,0 +1,44 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopeIsValidated(t *testing.T) {
+	c := conflictTestConfig(NewRule(webhook.Registration{Resources: []string{"pods"}}, graffiti.NewRule("team-a").AddLabels(map[string]string{"team": "a"})))
+	assert.NoError(t, c.ValidateConfig())
+	assert.Nil(t, c.WatchedNamespaces(), "the cluster scope doesn't limit the namespaces")
+
+	c.WatchNamespaces = []string{"team-a"}
+	assert.EqualError(t, c.ValidateConfig(), "watch-namespaces can only be set when the scope is namespaced")
+
+	c.Scope = ScopeNamespaced
+	assert.NoError(t, c.ValidateConfig())
+	assert.Equal(t, []string{"team-a"}, c.WatchedNamespaces())
+
+	c.WatchNamespaces = nil
+	assert.EqualError(t, c.ValidateConfig(), "watch-namespaces must list the namespaces to watch when the scope is namespaced")
+
+	c.WatchNamespaces = []string{"Team_A"}
+	assert.Contains(t, c.ValidateConfig().Error(), "invalid namespace 'Team_A' in watch-namespaces")
+
+	c.Scope = "tenant"
+	assert.EqualError(t, c.ValidateConfig(), "invalid scope 'tenant', must be one of cluster or namespaced")
+}
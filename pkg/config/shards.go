@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// validateShardName checks that a shard is a dns label, like the names of the deployments that serve them.
+func validateShardName(shard string) error {
+	if errs := validation.IsDNS1123Label(shard); len(errs) != 0 {
+		return fmt.Errorf("invalid shard '%s': %s", shard, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// validateShards checks the shard that we serve and the list of deployed shards, and warns about the rules that no
+// deployed shard serves.
+func (c Configuration) validateShards() error {
+	mylog := log.ComponentLogger(componentName, "validateShards")
+	deployed := make(map[string]bool)
+	for _, shard := range c.Shards {
+		if err := validateShardName(shard); err != nil {
+			mylog.Error().Err(err).Str("parameter", "shards").Msg("invalid shard")
+			return fmt.Errorf("shards: %v", err)
+		}
+		deployed[shard] = true
+	}
+	if c.Shard != "" {
+		if err := validateShardName(c.Shard); err != nil {
+			mylog.Error().Err(err).Str("parameter", "shard").Msg("invalid shard")
+			return err
+		}
+	}
+	if len(c.Shards) > 0 {
+		// every deployment has to serve a shard, otherwise it would also serve the other shards' rules
+		if !deployed[c.Shard] {
+			mylog.Error().Str("shard", c.Shard).Strs("shards", c.Shards).Msg("shard is not one of the deployed shards")
+			return fmt.Errorf("shard '%s' is not one of the deployed shards: %s", c.Shard, strings.Join(c.Shards, ", "))
+		}
+	}
+
+	for _, rule := range c.Rules {
+		switch {
+		case len(c.Shards) > 0 && !deployed[rule.Shard]:
+			mylog.Warn().Str("rule", rule.Registration.Name).Str("rule-shard", rule.Shard).Strs("shards", c.Shards).Msg("rule is not assigned to any deployed shard and will not be served")
+		case len(c.Shards) == 0 && c.Shard != "" && rule.Shard == "":
+			mylog.Warn().Str("rule", rule.Registration.Name).Str("shard", c.Shard).Msg("rule is not assigned to a shard and is only served by deployments without one")
+		}
+	}
+	return nil
+}
+
+// SelectShard drops the rules of other shards from the configuration when it serves a shard, and leaves all of the
+// rules when it doesn't.  It is an error when no rules are assigned to the shard.
+func (c *Configuration) SelectShard() error {
+	if c.Shard == "" {
+		return nil
+	}
+	mylog := log.ComponentLogger(componentName, "SelectShard")
+	var selected []Rule
+	for _, rule := range c.Rules {
+		if rule.Shard == c.Shard {
+			selected = append(selected, rule)
+		}
+	}
+	if len(selected) == 0 && !c.ConfigMaps.Enabled() {
+		return invalid(fmt.Errorf("none of the %d rules are assigned to shard '%s'", len(c.Rules), c.Shard))
+	}
+	mylog.Info().Str("shard", c.Shard).Int("rules", len(selected)).Int("other-shards", len(c.Rules)-len(selected)).Msg("serving the rules of shard")
+	c.Rules = selected
+	return nil
+}
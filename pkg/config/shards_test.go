@@ -0,0 +1,63 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func shardedRule(name, shard string) Rule {
+	rule := NewRule(webhook.Registration{}, graffiti.NewRule(name).AddLabels(map[string]string{"painted": "true"}))
+	rule.Shard = shard
+	return rule
+}
+
+func TestSelectShardServesOnlyItsRules(t *testing.T) {
+	c := conflictTestConfig(shardedRule("team-a-pods", "team-a"), shardedRule("team-b-pods", "team-b"), shardedRule("unsharded", ""))
+	c.Shards = []string{"team-a", "team-b"}
+	c.Shard = "team-a"
+	require.NoError(t, c.ValidateConfig(), "unassigned rules are only warned about")
+	require.NoError(t, c.SelectShard())
+	require.Len(t, c.Rules, 1)
+	assert.Equal(t, "team-a-pods", c.Rules[0].Registration.Name)
+
+	c = conflictTestConfig(shardedRule("team-a-pods", "team-a"), shardedRule("unsharded", ""))
+	require.NoError(t, c.SelectShard())
+	assert.Len(t, c.Rules, 2, "a deployment without a shard serves all of the rules")
+
+	c.Shard = "team-c"
+	assert.EqualError(t, c.SelectShard(), "none of the 2 rules are assigned to shard 'team-c'")
+}
+
+func TestShardsAreValidated(t *testing.T) {
+	c := conflictTestConfig(shardedRule("team-a-pods", "team-a"))
+	c.Shards = []string{"team-a", "team-b"}
+	assert.EqualError(t, c.ValidateConfig(), "shard '' is not one of the deployed shards: team-a, team-b", "a deployment must serve one of the deployed shards")
+
+	c.Shard = "team-c"
+	assert.EqualError(t, c.ValidateConfig(), "shard 'team-c' is not one of the deployed shards: team-a, team-b")
+
+	c.Shard = "Team_A"
+	c.Shards = nil
+	assert.Contains(t, c.ValidateConfig().Error(), "invalid shard 'Team_A'")
+
+	err := shardedRule("team-a-pods", "Team A").validate(log.Logger)
+	assert.Contains(t, err.Error(), "rule 'team-a-pods' has an invalid shard 'Team A'")
+}
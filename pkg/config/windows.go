@@ -0,0 +1,127 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a recurring maintenance window from Start until End, given as 'HH:MM' in the Timezone (UTC when it is
+// empty), on the Days given as 'mon' to 'sun' (every day when there are none).  A window whose End is before its Start
+// runs past midnight into the next day, and one whose End is its Start lasts all day.
+type Window struct {
+	Days     []string `mapstructure:"days" yaml:"days,omitempty"`
+	Start    string   `mapstructure:"start" yaml:"start"`
+	End      string   `mapstructure:"end" yaml:"end"`
+	Timezone string   `mapstructure:"timezone" yaml:"timezone,omitempty"`
+}
+
+// Windows are recurring windows, such as the maintenance windows that the check of existing objects is restricted
+// to, they are always open when there are none.
+type Windows []Window
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks that the days, times and timezone of each of the existing.windows can be understood.
+func (ws Windows) Validate() error {
+	return ws.validate("existing.windows")
+}
+
+// validate checks each window, naming it after the field that the windows are configured in.
+func (ws Windows) validate(field string) error {
+	for i, w := range ws {
+		if _, _, _, err := w.parse(); err != nil {
+			return fmt.Errorf("invalid %s[%d]: %v", field, i, err)
+		}
+	}
+	return nil
+}
+
+// Open is true when the time is within any of the windows, or when there are no windows.
+func (ws Windows) Open(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains is true when the time is within the window.  A window that runs past midnight belongs to the day that it
+// starts on.
+func (w Window) contains(t time.Time) bool {
+	start, end, loc, err := w.parse()
+	if err != nil {
+		return false
+	}
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	switch {
+	case start == end:
+		return w.on(t.Weekday())
+	case start < end:
+		return w.on(t.Weekday()) && minute >= start && minute < end
+	default:
+		return (w.on(t.Weekday()) && minute >= start) || (w.on(t.AddDate(0, 0, -1).Weekday()) && minute < end)
+	}
+}
+
+// on is true when the window opens on the day.
+func (w Window) on(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parse returns the start and end of the window in minutes after midnight, and its location.
+func (w Window) parse() (start, end int, loc *time.Location, err error) {
+	for _, d := range w.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return 0, 0, nil, fmt.Errorf("invalid day '%s', must be one of mon, tue, wed, thu, fri, sat or sun", d)
+		}
+	}
+	if start, err = minuteOfDay(w.Start); err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid start: %v", err)
+	}
+	if end, err = minuteOfDay(w.End); err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid end: %v", err)
+	}
+	if loc, err = time.LoadLocation(w.Timezone); err != nil {
+		return 0, 0, nil, fmt.Errorf("invalid timezone: %v", err)
+	}
+	return start, end, loc, nil
+}
+
+// minuteOfDay converts 'HH:MM' to the minutes after midnight.
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a time of day as 'HH:MM'", hhmm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
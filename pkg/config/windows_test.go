@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindowsOpen(t *testing.T) {
+	// 2020-03-02 is a monday
+	at := func(day, hour, minute int) time.Time { return time.Date(2020, 3, day, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		window Window
+		open   []time.Time
+		closed []time.Time
+	}{
+		{
+			name:   "every night",
+			window: Window{Start: "01:00", End: "05:00"},
+			open:   []time.Time{at(2, 1, 0), at(7, 4, 59)},
+			closed: []time.Time{at(2, 0, 59), at(2, 5, 0), at(2, 13, 0)},
+		},
+		{
+			name:   "past midnight on weekdays",
+			window: Window{Days: []string{"mon", "Tue", "wed", "thu", "fri"}, Start: "22:00", End: "02:00"},
+			open:   []time.Time{at(2, 22, 0), at(3, 1, 59), at(7, 1, 0)},
+			closed: []time.Time{at(2, 1, 0), at(7, 22, 0), at(2, 2, 0)},
+		},
+		{
+			name:   "weekends all day",
+			window: Window{Days: []string{"sat", "sun"}, Start: "00:00", End: "00:00"},
+			open:   []time.Time{at(7, 0, 0), at(8, 23, 59)},
+			closed: []time.Time{at(6, 23, 59), at(9, 0, 0)},
+		},
+		{
+			name:   "in a timezone",
+			window: Window{Start: "01:00", End: "05:00", Timezone: "America/New_York"},
+			open:   []time.Time{at(2, 6, 0)},
+			closed: []time.Time{at(2, 1, 0)},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			windows := Windows{tc.window}
+			assert.NoError(t, windows.Validate())
+			for _, open := range tc.open {
+				assert.True(t, windows.Open(open), "should be open at %s", open)
+			}
+			for _, closed := range tc.closed {
+				assert.False(t, windows.Open(closed), "should be closed at %s", closed)
+			}
+		})
+	}
+	assert.True(t, Windows(nil).Open(at(2, 13, 0)), "no windows is always open")
+}
+
+func TestWindowsValidate(t *testing.T) {
+	assert.EqualError(t, Windows{{Start: "1am", End: "05:00"}}.Validate(), "invalid existing.windows[0]: invalid start: '1am' is not a time of day as 'HH:MM'")
+	assert.EqualError(t, Windows{{Start: "01:00", End: "24:00"}}.Validate(), "invalid existing.windows[0]: invalid end: '24:00' is not a time of day as 'HH:MM'")
+	assert.EqualError(t, Windows{{Days: []string{"monday"}, Start: "01:00", End: "05:00"}}.Validate(), "invalid existing.windows[0]: invalid day 'monday', must be one of mon, tue, wed, thu, fri, sat or sun")
+	assert.Error(t, Windows{{Start: "01:00", End: "05:00", Timezone: "Nowhere/Special"}}.Validate())
+}
+
+func TestExistingValidate(t *testing.T) {
+	assert.NoError(t, Existing{Interval: time.Hour}.Validate())
+	assert.EqualError(t, Existing{Interval: -time.Hour}.Validate(), "existing.interval can not be negative")
+	assert.EqualError(t, Existing{Interval: time.Hour, Contexts: []string{"prod"}}.Validate(), "existing.interval can not be used with existing.contexts, only our own cluster is checked repeatedly")
+	assert.NoError(t, Existing{Contexts: []string{"prod"}, Windows: Windows{{Start: "01:00", End: "05:00"}}}.Validate(), "windows apply to checks of other clusters")
+	assert.NoError(t, Existing{PageSize: 500, Workers: 8, QPS: 50, Burst: 100, ProgressInterval: time.Minute}.Validate())
+	assert.EqualError(t, Existing{Workers: -1}.Validate(), "existing page-size, workers, qps, burst and progress-interval can not be negative")
+}
@@ -0,0 +1,293 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance checks that a webhook endpoint answers admission requests the way that kube-graffiti does, so
+// that forks and packagers can verify that their builds still behave compatibly.  It sends the matrix of
+// AdmissionReview versions, operations and content types, along with malformed requests, to an endpoint and checks
+// the responses against the AdmissionReview api rather than against any rule, so that any rule's path can be tested.
+package conformance
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// The AdmissionReview versions that are sent.
+const (
+	AdmissionV1beta1 = "admission.k8s.io/v1beta1"
+	AdmissionV1      = "admission.k8s.io/v1"
+)
+
+var (
+	// Versions are the AdmissionReview versions that a webhook must understand.
+	Versions = []string{AdmissionV1beta1, AdmissionV1}
+	// Operations are the admission operations that requests are sent for.
+	Operations = []string{"CREATE", "UPDATE", "DELETE", "CONNECT"}
+	// ContentTypes are the content types that an AdmissionReview can be sent with.
+	ContentTypes = []string{"application/json", "application/json; charset=utf-8"}
+)
+
+// Case is a request sent to the webhook endpoint and the check of its response.
+type Case struct {
+	Name        string
+	Method      string
+	ContentType string
+	Body        []byte
+	// Check returns why the response doesn't conform, or nil when it does.
+	Check func(resp *http.Response, body []byte) error
+}
+
+// Result is the outcome of a case, Err is nil when the endpoint conformed.
+type Result struct {
+	Case string
+	Err  error
+}
+
+// Cases returns the full matrix of cases, each with a new request uid.
+func Cases() []Case {
+	var cases []Case
+	for _, version := range Versions {
+		for _, operation := range Operations {
+			for _, contentType := range ContentTypes {
+				uid := uuid.New().String()
+				cases = append(cases, Case{
+					Name:        fmt.Sprintf("%s %s as %s", version, operation, contentType),
+					Method:      http.MethodPost,
+					ContentType: contentType,
+					Body:        review(version, request(uid, operation)),
+					Check:       validReview(version, uid, operation),
+				})
+			}
+		}
+		uid := uuid.New().String()
+		noMetadata := request(uid, "CREATE")
+		noMetadata["object"] = map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "data": map[string]string{"key": "value"}}
+		cases = append(cases, Case{
+			Name:        version + " object without metadata",
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Body:        review(version, noMetadata),
+			Check:       validReview(version, uid, "CREATE"),
+		})
+		uid = uuid.New().String()
+		dryRun := request(uid, "CREATE")
+		dryRun["dryRun"] = true
+		cases = append(cases, Case{
+			Name:        version + " dry run",
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Body:        review(version, dryRun),
+			Check:       validReview(version, uid, "CREATE"),
+		})
+		cases = append(cases, Case{
+			Name:        version + " without a request",
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Body:        review(version, nil),
+			Check:       rejected,
+		})
+	}
+
+	uid := uuid.New().String()
+	return append(cases,
+		Case{
+			Name:        "unsupported AdmissionReview version",
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Body:        review("admission.k8s.io/v2", request(uid, "CREATE")),
+			Check:       rejected,
+		},
+		Case{
+			Name:        "not a POST",
+			Method:      http.MethodGet,
+			ContentType: "application/json",
+			Check:       rejected,
+		},
+		Case{
+			Name:        "not json",
+			Method:      http.MethodPost,
+			ContentType: "text/plain",
+			Body:        []byte("this is not json"),
+			Check:       rejected,
+		},
+		Case{
+			Name:        "malformed json",
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Body:        []byte(`{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview", "request": {`),
+			Check:       rejected,
+		},
+	)
+}
+
+// Run sends each of the cases to the url with the client, and returns their results.
+func Run(client *http.Client, url string) []Result {
+	var results []Result
+	for _, c := range Cases() {
+		results = append(results, Result{Case: c.Name, Err: c.run(client, url)})
+	}
+	return results
+}
+
+// Test runs each of the cases against the url as a subtest, for use in the tests of a fork or package.
+func Test(t *testing.T, client *http.Client, url string) {
+	for _, c := range Cases() {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			if err := c.run(client, url); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func (c Case) run(client *http.Client, url string) error {
+	req, err := http.NewRequest(c.Method, url, bytes.NewReader(c.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", c.ContentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read the response: %v", err)
+	}
+	return c.Check(resp, body)
+}
+
+// review is an AdmissionReview of the version, without a request when it is nil.
+func review(version string, request map[string]interface{}) []byte {
+	r := map[string]interface{}{"apiVersion": version, "kind": "AdmissionReview"}
+	if request != nil {
+		r["request"] = request
+	}
+	body, _ := json.Marshal(r)
+	return body
+}
+
+// request is an admission request of the operation on a ConfigMap, or a pod's exec subresource for CONNECT.  Creates
+// only have the object, deletes only have the old object and updates have both.
+func request(uid, operation string) map[string]interface{} {
+	object := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "conformance", "namespace": "default", "labels": map[string]string{"app": "conformance"}},
+		"data":       map[string]string{"key": "value"},
+	}
+	r := map[string]interface{}{
+		"uid":       uid,
+		"kind":      map[string]string{"group": "", "version": "v1", "kind": "ConfigMap"},
+		"resource":  map[string]string{"group": "", "version": "v1", "resource": "configmaps"},
+		"name":      "conformance",
+		"namespace": "default",
+		"operation": operation,
+		"userInfo":  map[string]interface{}{"username": "conformance", "groups": []string{"system:authenticated"}},
+	}
+	switch operation {
+	case "CREATE":
+		r["object"] = object
+	case "UPDATE":
+		r["object"] = object
+		r["oldObject"] = object
+	case "DELETE":
+		r["oldObject"] = object
+	case "CONNECT":
+		r["kind"] = map[string]string{"group": "", "version": "v1", "kind": "PodExecOptions"}
+		r["resource"] = map[string]string{"group": "", "version": "v1", "resource": "pods"}
+		r["subResource"] = "exec"
+		r["object"] = map[string]interface{}{"apiVersion": "v1", "kind": "PodExecOptions", "command": []string{"ls"}}
+	}
+	return r
+}
+
+// reviewResponse is the part of an AdmissionReview response that is checked.
+type reviewResponse struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Response   *struct {
+		UID       string  `json:"uid"`
+		Allowed   *bool   `json:"allowed"`
+		PatchType *string `json:"patchType"`
+		Patch     []byte  `json:"patch"`
+	} `json:"response"`
+}
+
+// validReview checks that the response is an AdmissionReview of the same version answering the request, whose patch,
+// if it has one, is a json patch and only for the operations that can change an object.
+func validReview(version, uid, operation string) func(*http.Response, []byte) error {
+	return func(resp *http.Response, body []byte) error {
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("expected status 200, got %d: %s", resp.StatusCode, body)
+		}
+		if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			return fmt.Errorf("expected an application/json response, got '%s'", resp.Header.Get("Content-Type"))
+		}
+		var r reviewResponse
+		if err := json.Unmarshal(body, &r); err != nil {
+			return fmt.Errorf("the response is not an AdmissionReview: %v", err)
+		}
+		if r.APIVersion != version || r.Kind != "AdmissionReview" {
+			return fmt.Errorf("expected an AdmissionReview of %s, got kind '%s' of '%s'", version, r.Kind, r.APIVersion)
+		}
+		if r.Response == nil {
+			return fmt.Errorf("the AdmissionReview has no response")
+		}
+		if r.Response.UID != uid {
+			return fmt.Errorf("expected the response to have the request's uid %s, got '%s'", uid, r.Response.UID)
+		}
+		if r.Response.Allowed == nil {
+			return fmt.Errorf("the response doesn't say whether the request is allowed")
+		}
+		if len(r.Response.Patch) == 0 {
+			return nil
+		}
+		if operation != "CREATE" && operation != "UPDATE" {
+			return fmt.Errorf("the response to a %s has a patch, only creates and updates can be patched", operation)
+		}
+		if r.Response.PatchType == nil || *r.Response.PatchType != "JSONPatch" {
+			return fmt.Errorf("the response has a patch without the JSONPatch patchType")
+		}
+		var ops []struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}
+		if err := json.Unmarshal(r.Response.Patch, &ops); err != nil {
+			return fmt.Errorf("the response's patch is not a json patch: %v", err)
+		}
+		for _, op := range ops {
+			if op.Op == "" || op.Path == "" {
+				return fmt.Errorf("the response's patch has an operation without an op or path: %s", r.Response.Patch)
+			}
+		}
+		return nil
+	}
+}
+
+// rejected checks that a request which isn't a valid AdmissionReview is rejected as a bad request.
+func rejected(resp *http.Response, body []byte) error {
+	if resp.StatusCode < 400 || resp.StatusCode >= 500 {
+		return fmt.Errorf("expected the request to be rejected with a 4xx status, got %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
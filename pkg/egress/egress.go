@@ -0,0 +1,163 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package egress configures how kube-graffiti reaches the apiserver and external endpoints, through a proxy and
+// trusting extra certificate authorities, for locked-down clusters where there is no direct egress.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"k8s.io/client-go/rest"
+)
+
+// Config models the egress section of our configuration and so has mapstructure tags.  When a proxy isn't set the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used, as they would be without any configuration.
+type Config struct {
+	HTTPProxy  string `mapstructure:"http-proxy" yaml:"http-proxy,omitempty"`
+	HTTPSProxy string `mapstructure:"https-proxy" yaml:"https-proxy,omitempty"`
+	// NoProxy lists the hosts, domains (e.g. '.svc') and cidrs that are reached directly.
+	NoProxy []string `mapstructure:"no-proxy" yaml:"no-proxy,omitempty"`
+	// CABundles are files of pem certificates that are trusted along with the usual ones.
+	CABundles []string `mapstructure:"ca-bundles" yaml:"ca-bundles,omitempty"`
+}
+
+var (
+	mu sync.RWMutex
+	// proxy is the configured proxy for each request, nil when the environment decides as it usually does, and
+	// bundles are the pem certificates read from the CABundles.
+	proxy   func(*http.Request) (*url.URL, error)
+	bundles []byte
+)
+
+// Validate checks that the proxies are http(s) urls and that the CA bundles contain certificates.
+func (c Config) Validate() error {
+	for name, p := range map[string]string{"http-proxy": c.HTTPProxy, "https-proxy": c.HTTPSProxy} {
+		if p == "" {
+			continue
+		}
+		u, err := url.Parse(p)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid egress.%s '%s', must be an http or https url", name, p)
+		}
+	}
+	_, err := c.readBundles()
+	return err
+}
+
+// readBundles reads the CA bundles, it is an error for one not to contain any certificates.
+func (c Config) readBundles() ([]byte, error) {
+	var pems []byte
+	for _, file := range c.CABundles {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress.ca-bundles: %v", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid egress.ca-bundles: no certificates found in %s", file)
+		}
+		pems = append(append(pems, pem...), '\n')
+	}
+	return pems, nil
+}
+
+// Set makes the kubernetes clients and http clients created after it go through the proxy and trust the CA bundles.
+func Set(c Config) error {
+	pems, err := c.readBundles()
+	if err != nil {
+		return err
+	}
+	var p func(*http.Request) (*url.URL, error)
+	if c.HTTPProxy != "" || c.HTTPSProxy != "" || len(c.NoProxy) > 0 {
+		pc := httpproxy.Config{HTTPProxy: c.HTTPProxy, HTTPSProxy: c.HTTPSProxy, NoProxy: strings.Join(c.NoProxy, ",")}
+		proxyFunc := pc.ProxyFunc()
+		p = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	proxy = p
+	bundles = pems
+	return nil
+}
+
+func current() (func(*http.Request) (*url.URL, error), []byte) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return proxy, bundles
+}
+
+// HTTPClient returns an http client, for calling external endpoints, that goes through the proxy and trusts the CA
+// bundles as well as the system's certificate authorities.
+func HTTPClient(timeout time.Duration) *http.Client {
+	p, pems := current()
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if p != nil {
+		t.Proxy = p
+	}
+	if len(pems) > 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		roots.AppendCertsFromPEM(pems)
+		t.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return &http.Client{Timeout: timeout, Transport: t}
+}
+
+// RestConfig returns a copy of a kubernetes rest config that goes through the proxy and also trusts the CA bundles.
+func RestConfig(r *rest.Config) (*rest.Config, error) {
+	p, pems := current()
+	c := rest.CopyConfig(r)
+	if len(pems) > 0 && !c.Insecure {
+		ca := c.CAData
+		if len(ca) == 0 && c.CAFile != "" {
+			var err error
+			if ca, err = ioutil.ReadFile(c.CAFile); err != nil {
+				return nil, fmt.Errorf("failed to read the apiserver's ca: %v", err)
+			}
+		}
+		if len(ca) > 0 {
+			ca = append(ca, '\n')
+		}
+		c.CAData = append(ca, pems...)
+	}
+	if p == nil {
+		return c, nil
+	}
+	wrap := c.WrapTransport
+	c.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		// the base transport is shared between clients, so a copy of it is given the proxy
+		if t, ok := rt.(*http.Transport); ok {
+			t = t.Clone()
+			t.Proxy = p
+			rt = t
+		}
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return rt
+	}
+	return c, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package egress
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/rest"
+)
+
+func writeBundle(t *testing.T, srv *httptest.Server) string {
+	dir, err := ioutil.TempDir("", "egress")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+	return file
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Config{HTTPSProxy: "http://proxy.corp:3128", NoProxy: []string{".svc", "10.0.0.0/8"}}.Validate())
+	assert.Error(t, Config{HTTPProxy: "proxy.corp:3128"}.Validate(), "a proxy must be a url")
+	assert.Error(t, Config{HTTPSProxy: "ftp://proxy.corp"}.Validate(), "a proxy must be http(s)")
+	assert.Error(t, Config{CABundles: []string{"/does/not/exist.pem"}}.Validate())
+
+	dir, err := ioutil.TempDir("", "egress")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, ioutil.WriteFile(empty, []byte("not a certificate"), 0600))
+	assert.Error(t, Config{CABundles: []string{empty}}.Validate(), "a bundle must contain certificates")
+}
+
+func TestHTTPClientGoesThroughTheProxy(t *testing.T) {
+	var proxied string
+	proxySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxySrv.Close()
+	require.NoError(t, Set(Config{HTTPProxy: proxySrv.URL, NoProxy: []string{".direct.example"}}))
+	defer Set(Config{})
+
+	resp, err := HTTPClient(time.Second).Get("http://matcher.example/allow")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "http://matcher.example/allow", proxied)
+
+	req, err := http.NewRequest(http.MethodGet, "http://api.direct.example/", nil)
+	require.NoError(t, err)
+	u, err := HTTPClient(time.Second).Transport.(*http.Transport).Proxy(req)
+	require.NoError(t, err)
+	assert.Nil(t, u, "no-proxy hosts are reached directly")
+}
+
+func TestHTTPClientTrustsTheCABundles(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := HTTPClient(time.Second).Get(srv.URL)
+	require.Error(t, err, "the test server's certificate isn't trusted without the bundle")
+
+	require.NoError(t, Set(Config{CABundles: []string{writeBundle(t, srv)}}))
+	defer Set(Config{})
+	resp, err := HTTPClient(time.Second).Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRestConfig(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	bundle := writeBundle(t, srv)
+
+	original := &rest.Config{Host: "https://10.0.0.1", TLSClientConfig: rest.TLSClientConfig{CAData: []byte("apiserver-ca")}}
+	r, err := RestConfig(original)
+	require.NoError(t, err)
+	assert.Equal(t, original, r, "nothing changes without any egress configuration")
+	assert.Nil(t, r.WrapTransport)
+
+	require.NoError(t, Set(Config{HTTPSProxy: "http://proxy.corp:3128", CABundles: []string{bundle}}))
+	defer Set(Config{})
+	r, err = RestConfig(original)
+	require.NoError(t, err)
+	pems, err := ioutil.ReadFile(bundle)
+	require.NoError(t, err)
+	assert.Equal(t, "apiserver-ca\n"+string(pems)+"\n", string(r.CAData))
+	assert.Equal(t, "apiserver-ca", string(original.CAData), "the original config is left alone")
+
+	base := &http.Transport{}
+	rt := r.WrapTransport(base)
+	require.IsType(t, &http.Transport{}, rt)
+	assert.Nil(t, base.Proxy, "the shared transport is left alone")
+	req, err := http.NewRequest(http.MethodGet, "https://10.0.0.1/api", nil)
+	require.NoError(t, err)
+	u, err := rt.(*http.Transport).Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.corp:3128", u.String())
+}
@@ -0,0 +1,267 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// configMapSyncTimeout is how long we wait at startup for the rules of the existing ConfigMaps to be listed.
+const configMapSyncTimeout = 30 * time.Second
+
+// ruleServer is the part of the webhook server that serves the rules of ConfigMaps.
+type ruleServer interface {
+	ReplaceRule(r webhook.Registration, rule graffiti.Rule)
+	RemoveRule(name string)
+	RegisterHookWithRetry(r webhook.Registration, clientset kubernetes.Interface) error
+	DeregisterHook(r webhook.Registration, action string, clientset kubernetes.Interface) error
+	KeepRegistration(r webhook.Registration)
+	ForgetRegistration(name string)
+}
+
+// configMapRules serves the rules of the selected ConfigMaps alongside the rules of the configuration file, adding,
+// replacing and removing them as the ConfigMaps change.
+type configMapRules struct {
+	c      config.Configuration
+	server ruleServer
+	k      kubernetes.Interface
+	done   chan struct{}
+	// clock is the time that the schedules of the rules are checked against.
+	clock func() time.Time
+
+	sync.Mutex
+	configMaps map[string]*corev1.ConfigMap
+	good       map[string][]config.Rule
+	served     map[string]config.Rule
+}
+
+func newConfigMapRules(c config.Configuration, server ruleServer, k kubernetes.Interface) *configMapRules {
+	return &configMapRules{
+		c:          c,
+		server:     server,
+		k:          k,
+		done:       make(chan struct{}),
+		clock:      time.Now,
+		configMaps: make(map[string]*corev1.ConfigMap),
+		good:       make(map[string][]config.Rule),
+		served:     make(map[string]config.Rule),
+	}
+}
+
+// start watches the ConfigMaps, in each of the configured namespaces or in all of them, and waits until the rules of
+// the existing ConfigMaps are being served.
+func (r *configMapRules) start() error {
+	mylog := log.ComponentLogger(componentName, "configMapRules.start")
+	namespaces := r.c.ConfigMaps.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var synced []cache.InformerSynced
+	for _, ns := range namespaces {
+		configMaps := r.k.CoreV1().ConfigMaps(ns)
+		lw := &cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				opts.LabelSelector = r.c.ConfigMaps.Selector
+				return configMaps.List(opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				opts.LabelSelector = r.c.ConfigMaps.Selector
+				return configMaps.Watch(opts)
+			},
+		}
+		_, controller := cache.NewInformer(lw, &corev1.ConfigMap{}, 0, cache.ResourceEventHandlerFuncs{
+			AddFunc:    r.set,
+			UpdateFunc: func(_, obj interface{}) { r.set(obj) },
+			DeleteFunc: r.remove,
+		})
+		go controller.Run(r.done)
+		synced = append(synced, controller.HasSynced)
+	}
+
+	mylog.Info().Str("selector", r.c.ConfigMaps.Selector).Strs("namespaces", r.c.ConfigMaps.Namespaces).Msg("watching configmaps for rules")
+	ctx, cancel := context.WithTimeout(context.Background(), configMapSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("timed out listing the configmaps with selector '%s'", r.c.ConfigMaps.Selector)
+	}
+	return nil
+}
+
+// stop stops watching the ConfigMaps and returns the rules that are still being served, so that their registrations
+// can be dealt with on shutdown.  It is safe to call on a nil configMapRules.
+func (r *configMapRules) stop() []config.Rule {
+	if r == nil {
+		return nil
+	}
+	close(r.done)
+	return r.servedRules()
+}
+
+// servedRules returns the rules of the ConfigMaps that are being served.  It is safe to call on a nil configMapRules.
+func (r *configMapRules) servedRules() []config.Rule {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	var rules []config.Rule
+	for _, rule := range r.served {
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (r *configMapRules) set(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.configMaps[cm.Namespace+"/"+cm.Name] = cm
+	r.sync()
+}
+
+func (r *configMapRules) remove(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	delete(r.configMaps, key)
+	delete(r.good, key)
+	r.sync()
+}
+
+// resync serves or stops serving the rules of the ConfigMaps whose schedules have started or ended.  It is safe to
+// call on a nil configMapRules.
+func (r *configMapRules) resync() {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.sync()
+}
+
+// sync merges the rules of all of the ConfigMaps with the rules of the configuration file, and then serves the rules
+// that are new or have changed and are active, and stops serving those that have gone or are outside of their
+// schedules.  A ConfigMap that can't be decoded, or that
+// has an invalid rule, keeps contributing the rules it had when it was last good, so that a bad update can't take
+// away rules that are being served.
+func (r *configMapRules) sync() {
+	mylog := log.ComponentLogger(componentName, "configMapRules.sync")
+	var keys []string
+	for key := range r.configMaps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var extra []config.Rule
+	for _, key := range keys {
+		rules, err := config.RulesFromConfigMap(r.configMaps[key])
+		if err == nil {
+			_, invalid := config.Configuration{Rules: rules}.ValidRules()
+			if len(invalid) > 0 {
+				err = fmt.Errorf("%d of the %d rules are invalid", len(invalid), len(rules))
+			}
+		}
+		if err != nil {
+			good, ok := r.good[key]
+			mylog.Error().Err(err).Str("configmap", key).Bool("last-known-good", ok).Msg("ignoring the new rules of configmap")
+			extra = append(extra, good...)
+			continue
+		}
+		r.good[key] = rules
+		extra = append(extra, rules...)
+	}
+	added, rejected := r.c.MergeRules(extra)
+	for _, err := range rejected {
+		mylog.Error().Err(err).Msg("rule from a configmap was rejected")
+	}
+
+	added = config.ActiveRules(added, r.clock())
+	wanted := make(map[string]bool)
+	for _, rule := range added {
+		wanted[rule.Registration.Name] = true
+	}
+	for name, rule := range r.served {
+		if !wanted[name] {
+			r.unserve(rule)
+		}
+	}
+	for _, rule := range added {
+		served, ok := r.served[rule.Registration.Name]
+		if ok && reflect.DeepEqual(served, rule) {
+			continue
+		}
+		if ok && served.Registration.IsValidating() != rule.Registration.IsValidating() {
+			// the old registration is of the other kind of webhook and would otherwise be left behind
+			r.unserve(served)
+		}
+		r.serve(rule)
+	}
+}
+
+// serve adds or replaces a rule in the webhook server and registers it with the apiserver.
+func (r *configMapRules) serve(rule config.Rule) {
+	mylog := log.ComponentLogger(componentName, "configMapRules.serve")
+	name := rule.Registration.Name
+	mylog.Info().Str("rule", name).Msg("serving rule from a configmap")
+
+	graffiti.WarmRules([]graffiti.Rule{rule.GraffitiRule()}, r.c.Server.WarmupBudget)
+	r.server.ReplaceRule(rule.WebhookRegistration(), rule.GraffitiRule())
+	metrics.Rules.Add(name, rule.GraffitiRule().Type)
+	metrics.Rules.SetActive(name, nil)
+	err := r.server.RegisterHookWithRetry(rule.WebhookRegistration(), r.k)
+	metrics.Rules.SetRegistered(name, err)
+	if err != nil {
+		mylog.Error().Err(err).Str("rule", name).Msg("failed to register rule with apiserver")
+	}
+	r.server.KeepRegistration(rule.WebhookRegistration())
+	r.served[name] = rule
+}
+
+// unserve removes a rule from the webhook server and deletes its registration.
+func (r *configMapRules) unserve(rule config.Rule) {
+	mylog := log.ComponentLogger(componentName, "configMapRules.unserve")
+	name := rule.Registration.Name
+	mylog.Info().Str("rule", name).Msg("no longer serving rule from a configmap")
+
+	r.server.ForgetRegistration(name)
+	if err := r.server.DeregisterHook(rule.WebhookRegistration(), webhook.ShutdownActionDelete, r.k); err != nil {
+		mylog.Error().Err(err).Str("rule", name).Msg("failed to deregister rule with apiserver")
+	}
+	r.server.RemoveRule(name)
+	metrics.Rules.Remove(name)
+	delete(r.served, name)
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeRuleServer records the rules that it serves and registers.
+type fakeRuleServer struct {
+	sync.Mutex
+	rules        map[string]graffiti.Rule
+	registered   map[string]bool
+	deregistered []string
+}
+
+func newFakeRuleServer() *fakeRuleServer {
+	return &fakeRuleServer{rules: make(map[string]graffiti.Rule), registered: make(map[string]bool)}
+}
+
+func (s *fakeRuleServer) ReplaceRule(_ webhook.Registration, rule graffiti.Rule) {
+	s.Lock()
+	defer s.Unlock()
+	s.rules[rule.Name] = rule
+}
+
+func (s *fakeRuleServer) RemoveRule(name string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.rules, name)
+}
+
+func (s *fakeRuleServer) RegisterHookWithRetry(r webhook.Registration, _ kubernetes.Interface) error {
+	s.Lock()
+	defer s.Unlock()
+	s.registered[r.Name] = true
+	return nil
+}
+
+func (s *fakeRuleServer) DeregisterHook(r webhook.Registration, _ string, _ kubernetes.Interface) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.registered, r.Name)
+	s.deregistered = append(s.deregistered, r.Name)
+	return nil
+}
+
+func (s *fakeRuleServer) KeepRegistration(webhook.Registration) {}
+
+func (s *fakeRuleServer) ForgetRegistration(string) {}
+
+func (s *fakeRuleServer) served() map[string]graffiti.Rule {
+	s.Lock()
+	defer s.Unlock()
+	served := make(map[string]graffiti.Rule)
+	for name, rule := range s.rules {
+		served[name] = rule
+	}
+	return served
+}
+
+func rulesConfigMap(namespace, name, label string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"kube-graffiti/rules": "true"}},
+		Data: map[string]string{"rules.yaml": `rules:
+- registration:
+    name: ` + name + `
+    failure-policy: Ignore
+    resources: pods
+  payload:
+    additions:
+      labels:
+        team: ` + label + `
+`},
+	}
+}
+
+func TestConfigMapRulesAreServedAndFollowChanges(t *testing.T) {
+	unselected := rulesConfigMap("team-b", "not-selected", "b")
+	unselected.Labels = nil
+	clientset := fake.NewSimpleClientset(rulesConfigMap("team-a", "team-a-pods", "a"), unselected)
+
+	c := config.Default()
+	c.Rules = []config.Rule{testRule("platform", "app=web")}
+	c.ConfigMaps = config.ConfigMaps{Selector: "kube-graffiti/rules=true"}
+	server := newFakeRuleServer()
+	cmRules := newConfigMapRules(c, server, clientset)
+	require.NoError(t, cmRules.start())
+
+	served := server.served()
+	require.Len(t, served, 1, "only the rules of selected configmaps are served")
+	assert.Equal(t, map[string]string{"team": "a"}, served["team-a-pods"].Payload.Additions.Labels)
+	assert.True(t, server.registered["team-a-pods"])
+
+	updated := rulesConfigMap("team-a", "team-a-pods", "alpha")
+	_, err := clientset.CoreV1().ConfigMaps("team-a").Update(updated)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return server.served()["team-a-pods"].Payload.Additions.Labels["team"] == "alpha"
+	}, 5*time.Second, 10*time.Millisecond, "a changed rule is replaced")
+
+	_, err = clientset.CoreV1().ConfigMaps("team-b").Create(rulesConfigMap("team-b", "platform", "b"))
+	require.NoError(t, err)
+	require.NoError(t, clientset.CoreV1().ConfigMaps("team-a").Delete("team-a-pods", &metav1.DeleteOptions{}))
+	assert.Eventually(t, func() bool { return len(server.served()) == 0 }, 5*time.Second, 10*time.Millisecond, "the rules of deleted configmaps are removed")
+	server.Lock()
+	assert.Equal(t, []string{"team-a-pods"}, server.deregistered)
+	server.Unlock()
+	assert.Empty(t, cmRules.stop(), "a configmap rule can't replace a rule of the configuration file")
+}
+
+func TestBrokenConfigMapKeepsItsLastKnownGoodRules(t *testing.T) {
+	c := config.Default()
+	c.ConfigMaps = config.ConfigMaps{Selector: "kube-graffiti/rules=true"}
+	server := newFakeRuleServer()
+	cmRules := newConfigMapRules(c, server, fake.NewSimpleClientset())
+	cmRules.set(rulesConfigMap("team-a", "team-a-pods", "a"))
+
+	undecodable := rulesConfigMap("team-a", "team-a-pods", "a")
+	undecodable.Data["rules.yaml"] = "rules: [this is not a rule"
+	cmRules.set(undecodable)
+	assert.Equal(t, "a", server.served()["team-a-pods"].Payload.Additions.Labels["team"], "an undecodable update keeps the previous rules")
+
+	invalid := rulesConfigMap("team-a", "team-a-pods", "b")
+	invalid.Data["rules.yaml"] = strings.Replace(invalid.Data["rules.yaml"], "resources: pods", "resources: pods\n    operations: [SOMETIMES]", 1)
+	cmRules.set(invalid)
+	assert.Equal(t, "a", server.served()["team-a-pods"].Payload.Additions.Labels["team"], "an invalid update keeps the previous rules")
+
+	cmRules.remove(invalid)
+	assert.Empty(t, server.served(), "deleting the configmap forgets its last-known-good rules")
+}
@@ -0,0 +1,410 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package engine runs kube-graffiti's webhook server, registrations and check of existing objects, so that they can
+// be embedded in other programs, such as an operator's own controller binary, instead of running kube-graffiti.
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/audit"
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/Telefonica/kube-graffiti/pkg/evaluation"
+	"github.com/Telefonica/kube-graffiti/pkg/events"
+	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/queue"
+	"github.com/Telefonica/kube-graffiti/pkg/related"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const componentName = "engine"
+
+// Options are what Run needs to run the webhook engine.
+type Options struct {
+	// Config is the configuration, usually built from config.Default() with rules from config.NewRule.
+	Config config.Configuration
+	// RestConfig is used to talk to the apiserver, the in-cluster config (through the configured egress) is used when
+	// it is nil.
+	RestConfig *rest.Config
+}
+
+// Run validates the configuration, starts the webhook server and registers the rules with the apiserver, optionally
+// checks existing objects, and then serves admission requests until the context is done, when it shuts down according
+// to the server's shutdown-action.  The rules that are invalid are left out, it is only an error when none are valid.
+func Run(ctx context.Context, opts Options) error {
+	mylog := log.ComponentLogger(componentName, "Run")
+
+	c := opts.Config
+	if err := c.ValidateConfig(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := c.SelectShard(); err != nil {
+		return err
+	}
+	c.ApplyKeyPrefixes()
+	if err := ActivateRules(&c); err != nil {
+		return err
+	}
+
+	if err := egress.Set(c.Egress); err != nil {
+		return fmt.Errorf("%w: %v", config.ErrConfigInvalid, err)
+	}
+	r := opts.RestConfig
+	if r == nil {
+		var err error
+		if r, err = rest.InClusterConfig(); err != nil {
+			return fmt.Errorf("can't get the in-cluster kubernetes config: %w", err)
+		}
+		if r, err = egress.RestConfig(r); err != nil {
+			return err
+		}
+	}
+	k, err := kubernetes.NewForConfig(r)
+	if err != nil {
+		return fmt.Errorf("can't get a kubernetes clientset: %w", err)
+	}
+
+	if err := CheckTargets(c, k.Discovery()); err != nil {
+		return err
+	}
+
+	graffiti.SetKeyPrefixes(c.KeyPrefixes)
+	graffiti.SetNamespaceLookup(newNamespaceLookup(r, ctx.Done()))
+	graffiti.SetParentLookup(newParentLookup(r))
+	graffiti.SetQuotaLookup(newQuotaLookup(r))
+	// only the rules which are within their schedules are served and registered to begin with
+	serving := c
+	serving.Rules = config.ActiveRules(c.Rules, time.Now())
+	server, err := startWebhookServer(serving, needsActionQueue(c.Rules), k)
+	if err != nil {
+		return fmt.Errorf("webhook server failed to start: %w", err)
+	}
+	schedule := newRuleSchedule(c, serving.Rules, &server, k)
+	var cmRules *configMapRules
+	if c.ConfigMaps.Enabled() {
+		cmRules = newConfigMapRules(c, &server, k)
+		schedule.cmRules = cmRules
+		if err := cmRules.start(); err != nil {
+			shutdown(c, server, k, schedule, cmRules)
+			return fmt.Errorf("failed to load rules from configmaps: %w", err)
+		}
+	}
+	existing.SetWindows(c.Existing.Windows, ctx.Done())
+	// the periodic checks and the expiry controller leave the ignored objects alone, just as the webhooks do
+	existing.SetIgnore(c.Ignore)
+	existing.SetLimits(c.Existing)
+	existing.SetWatchNamespaces(c.WatchedNamespaces())
+	periodic := c.CheckExisting && c.Existing.Interval > 0
+	if periodic {
+		// periodic checks run in the background, once the clients are set up, so they can't hold up startup
+		if err := existing.InitKubeClients(r); err != nil {
+			shutdown(c, server, k, schedule, cmRules)
+			return fmt.Errorf("failed to start checking existing objects: %w", err)
+		}
+	} else if c.CheckExisting {
+		if err := CheckExisting(serving, r); err != nil {
+			shutdown(c, server, k, schedule, cmRules)
+			return fmt.Errorf("failed to check existing objects: %w", err)
+		}
+	}
+	if c.Expiry.Interval > 0 {
+		// checking existing objects may have left the clients pointing at another cluster
+		if err := existing.InitKubeClients(r); err != nil {
+			shutdown(c, server, k, schedule, cmRules)
+			return fmt.Errorf("failed to start the expiry controller: %w", err)
+		}
+		go existing.RunExpiryController(c.Rules, c.Expiry.Interval, ctx.Done())
+	}
+	if periodic {
+		go existing.RunPeriodicChecks(c.Rules, c.Existing.Interval, ctx.Done())
+	}
+	if c.ConfigMaps.Enabled() || hasScheduledRules(c.Rules) {
+		go schedule.run(scheduleInterval, ctx.Done())
+	}
+	if c.Server.RuleStatus.Enabled() {
+		// our pod's name is our hostname, each replica shares its status under its own name
+		replica, _ := os.Hostname()
+		mylog.Info().Str("configmap", c.Server.RuleStatus.ConfigMap).Str("replica", replica).Msg("sharing the status of the rules with the other replicas")
+		go metrics.Rules.Share(k, c.Server.Namespace, replica, c.Server.RuleStatus, ctx.Done())
+	}
+	if c.Evaluation.Enabled() {
+		served := func() []graffiti.Rule { return servedRules(schedule, cmRules) }
+		evaluator, err := evaluation.Serve(c.Evaluation, evaluation.NewServedEvaluator(served))
+		if err != nil {
+			shutdown(c, server, k, schedule, cmRules)
+			return fmt.Errorf("failed to serve the evaluation api: %w", err)
+		}
+		defer evaluator.GracefulStop()
+	}
+
+	<-ctx.Done()
+	mylog.Info().Msg("shutting down the webhook engine")
+	shutdown(c, server, k, schedule, cmRules)
+	return nil
+}
+
+// servedRules are the rules that the webhook is serving, those of the configuration that are within their schedules
+// and those of the ConfigMaps.
+func servedRules(schedule *ruleSchedule, cmRules *configMapRules) []graffiti.Rule {
+	var rules []graffiti.Rule
+	for _, rule := range append(schedule.servedRules(), cmRules.servedRules()...) {
+		rules = append(rules, rule.GraffitiRule())
+	}
+	return rules
+}
+
+// needsActionQueue is true when any of the rules has side effects that are done by the action queue, which is only
+// labelling related services.  Rules that are loaded from ConfigMaps don't start the queue, and label straight away.
+func needsActionQueue(rules []config.Rule) bool {
+	for _, rule := range rules {
+		if rule.Payload.LabelRelatedServices {
+			return true
+		}
+	}
+	return false
+}
+
+// startWebhookServer starts serving the rules and registers them with the apiserver, and keeps them registered.  The
+// action queue is only started when queueActions is set.
+func startWebhookServer(c config.Configuration, queueActions bool, k *kubernetes.Clientset) (webhook.Server, error) {
+	mylog := log.ComponentLogger(componentName, "startWebhookServer")
+	port := c.Server.WebhookPort
+
+	mylog.Debug().Int("port", port).Msg("creating a new webhook server")
+	caPath := c.Server.CACertPath
+	ca, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		mylog.Error().Err(err).Str("path", caPath).Msg("Failed to load ca from file")
+		return webhook.Server{}, fmt.Errorf("failed to load ca from file: %w", err)
+	}
+	if ca, err = webhook.LoadCABundle(ca); err != nil {
+		mylog.Error().Err(err).Str("path", caPath).Msg("Failed to parse ca bundle")
+		return webhook.Server{}, err
+	}
+	mylog.Debug().Str("ca-cert-path", caPath).Msg("loaded ca cert ok")
+	serviceName := webhook.ServiceDNSName(c.Server.Service, c.Server.Namespace)
+	if err = webhook.VerifyServingCert(ca, c.Server.ServerCertPath, serviceName); err != nil {
+		return webhook.Server{}, err
+	}
+	server := webhook.NewServer(
+		c.Server.CompanyDomain,
+		c.Server.Namespace,
+		c.Server.Service,
+		ca, k,
+		port,
+	)
+	if err := addListeners(c, &server); err != nil {
+		return server, err
+	}
+	if c.Server.ClientAuth.Enabled() {
+		mylog.Info().Str("ca-path", c.Server.ClientAuth.CAPath).Strs("allowed-names", c.Server.ClientAuth.AllowedNames).Msg("requiring client certificates from the callers of our webhooks")
+	}
+	if err := server.SetClientAuth(c.Server.ClientAuth); err != nil {
+		return server, fmt.Errorf("%w: %v", config.ErrConfigInvalid, err)
+	}
+
+	// set up auditing of rule decisions before any rules are added
+	sink, err := audit.NewSink(c.Audit, k)
+	if err != nil {
+		return server, err
+	}
+	if sink != nil {
+		mylog.Info().Str("sink", c.Audit.Sink).Msg("auditing rule decisions")
+		server.SetAuditor(audit.NewAuditor(sink, c.Audit.BufferSize))
+	}
+	if c.Events.Enabled {
+		mylog.Info().Str("pod", c.Events.PodName).Msg("recording events on the objects that rules change")
+		recorder := events.NewRecorder(c.Events, k)
+		server.SetEventRecorder(recorder)
+		// existing objects in the clusters of other contexts can't be given events in this one
+		if len(c.Existing.Contexts) == 0 {
+			existing.SetEventRecorder(recorder)
+		}
+	}
+
+	// rules can label the services related to the objects that they paint, which is queued so that it is retried,
+	// otherwise the labeller labels them straight away
+	labeller := related.NewServiceLabeller(k)
+	if queueActions {
+		actions := queue.New(k, c.Server.Namespace, c.Actions)
+		labeller.UseQueue(actions)
+		if err := actions.Start(); err != nil {
+			return server, err
+		}
+		server.SetActionQueue(actions)
+	}
+	server.SetSourceLimits(c.Server.SourceLimits)
+	server.SetDecisionCache(c.Server.DecisionCache)
+	server.SetRequestLimits(c.Server.MaxConcurrentRequests, c.Server.RequestTimeout)
+	server.SetRequestSizeLimit(c.Server.MaxRequestSize, c.Server.OversizedRequests)
+	server.SetRuleErrors(c.Server.RuleErrors)
+	server.SetIgnore(c.Ignore)
+	server.SetWatchNamespaces(c.WatchedNamespaces())
+	server.SetAdmissionVersion(c.Server.AdmissionVersion)
+	server.SetDebugToken(c.Server.DebugToken)
+	server.SetServiceLabeller(labeller)
+
+	// add each of the graffiti rules into the mux
+	mylog.Info().Int("count", len(c.Rules)).Msg("loading graffiti rules")
+	var rules []graffiti.Rule
+	for _, rule := range c.Rules {
+		mylog.Info().Str("rule-name", rule.Registration.Name).Msg("adding graffiti rule")
+		server.AddRegisteredRule(rule.WebhookRegistration(), rule.GraffitiRule())
+		rules = append(rules, rule.GraffitiRule())
+	}
+	// compile the rules before serving so that the first admission requests after a rollout aren't slowed down
+	graffiti.WarmRules(rules, c.Server.WarmupBudget)
+
+	mylog.Info().Int("port", port).Str("server.cert-path", c.Server.ServerCertPath).Str("server.key-path", c.Server.ServerKeyPath).Msg("starting webhook secure webserver")
+	server.StartWebhookServer(c.Server.ServerCertPath, c.Server.ServerKeyPath)
+
+	mylog.Debug().Msg("waiting 2 seconds")
+	time.Sleep(2 * time.Second)
+
+	// register all rules with the kubernetes apiserver, a rule that fails to register doesn't stop the others and
+	// is retried by the registration checks below.
+	for _, rule := range c.Rules {
+		mylog.Info().Str("name", rule.Registration.Name).Msg("registering rule with api server")
+		err = server.RegisterHookWithRetry(rule.WebhookRegistration(), k)
+		metrics.Rules.SetRegistered(rule.Registration.Name, err)
+		if err != nil {
+			mylog.Error().Err(err).Str("name", rule.Registration.Name).Msg("failed to register rule with apiserver")
+		}
+	}
+
+	// and keep them registered, healing any that are deleted or changed
+	var registrations []webhook.Registration
+	for _, rule := range c.Rules {
+		registrations = append(registrations, rule.WebhookRegistration())
+	}
+	server.KeepRegistered(registrations, k, c.Server.RegistrationCheckInterval)
+
+	return server, nil
+}
+
+// addListeners adds the extra https listeners to the server, after checking that their certificates are signed by
+// their ca bundles and are valid for the services that the apiserver calls them through.
+func addListeners(c config.Configuration, server *webhook.Server) error {
+	for _, l := range c.Server.Listeners {
+		data, err := ioutil.ReadFile(l.CACertPath)
+		if err != nil {
+			return fmt.Errorf("failed to load the ca of listener %s from file: %w", l.Name, err)
+		}
+		ca, err := webhook.LoadCABundle(data)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", l.Name, err)
+		}
+		service := l.Service
+		if service == "" {
+			service = c.Server.Service
+		}
+		if err := webhook.VerifyServingCert(ca, l.CertPath, webhook.ServiceDNSName(service, c.Server.Namespace)); err != nil {
+			return fmt.Errorf("listener %s: %w", l.Name, err)
+		}
+		server.AddListener(l, ca)
+	}
+	return nil
+}
+
+// shutdown stops the webhook server, draining any in-flight admission requests, and then deals with the webhook
+// registrations of the rules being served, including those from configmaps, according to the configured
+// server.shutdown-action.
+func shutdown(c config.Configuration, server webhook.Server, k *kubernetes.Clientset, schedule *ruleSchedule, cmRules *configMapRules) {
+	mylog := log.ComponentLogger(componentName, "shutdown")
+	rules := append(schedule.servedRules(), cmRules.stop()...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Server.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		mylog.Error().Err(err).Msg("failed to drain the webhook server")
+	}
+
+	action := c.Server.ShutdownAction
+	for _, rule := range rules {
+		if err := server.DeregisterHook(rule.WebhookRegistration(), action, k); err != nil {
+			mylog.Error().Err(err).Str("name", rule.Registration.Name).Msg("failed to deregister rule with apiserver")
+		}
+	}
+}
+
+// ActivateRules drops any invalid rules from the configuration, so that one broken rule doesn't prevent the rest from
+// loading, and records which rules are active.  It is only an error when none of the rules are valid, unless all of
+// them come from configmaps.
+func ActivateRules(c *config.Configuration) error {
+	mylog := log.ComponentLogger(componentName, "ActivateRules")
+	valid, invalid := c.ValidRules()
+	for _, rule := range c.Rules {
+		metrics.Rules.Add(rule.Registration.Name, rule.GraffitiRule().Type)
+		metrics.Rules.SetActive(rule.Registration.Name, invalid[rule.Registration.Name])
+	}
+	if len(valid) == 0 && (len(c.Rules) > 0 || !c.ConfigMaps.Enabled()) {
+		return fmt.Errorf("%w: none of the %d rules are valid", config.ErrConfigInvalid, len(c.Rules))
+	}
+	if len(invalid) > 0 {
+		mylog.Warn().Int("active", len(valid)).Int("invalid", len(invalid)).Msg("some rules are invalid and have not been loaded")
+	}
+	c.Rules = valid
+	return nil
+}
+
+// CheckTargets checks that the apiserver serves everything that the rules' registrations target, it is a configuration
+// error when it doesn't, rather than registering webhooks that are never called.
+func CheckTargets(c config.Configuration, d webhook.ResourceDiscoverer) error {
+	registrations := make([]webhook.Registration, 0, len(c.Rules))
+	for _, rule := range c.Rules {
+		registrations = append(registrations, rule.Registration)
+	}
+	err := webhook.CheckTargetsServed(d, registrations)
+	if errors.Is(err, webhook.ErrTargetsNotServed) {
+		return fmt.Errorf("%w: %v", config.ErrConfigInvalid, err)
+	}
+	return err
+}
+
+// CheckExisting applies the rules to the existing objects in the clusters of the configured kubeconfig contexts or,
+// when there are none, in the cluster of the rest config.
+func CheckExisting(c config.Configuration, r *rest.Config) error {
+	mylog := log.ComponentLogger(componentName, "CheckExisting")
+	existing.SetIgnore(c.Ignore)
+	existing.SetLimits(c.Existing)
+	existing.SetWatchNamespaces(c.WatchedNamespaces())
+	graffiti.SetKeyPrefixes(c.KeyPrefixes)
+
+	if len(c.Existing.Contexts) > 0 {
+		mylog.Info().Strs("contexts", c.Existing.Contexts).Msg("checking existing objects in the clusters of kubeconfig contexts")
+		if err := existing.ApplyRulesAgainstContexts(c.Existing.Kubeconfig, c.Existing.Contexts, c.Rules); err != nil {
+			return err
+		}
+		mylog.Info().Msg("check of existing objects completed successfully")
+		return nil
+	}
+	if err := existing.InitKubeClients(r); err != nil {
+		return err
+	}
+	existing.ApplyRulesAgainstExistingObjects(c.Rules)
+
+	mylog.Info().Msg("check of existing objects completed successfully")
+	return nil
+}
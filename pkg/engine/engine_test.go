@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testRule(name string, selectors ...string) config.Rule {
+	registration := webhook.Registration{Resources: []string{"pods"}, FailurePolicy: "Ignore"}
+	return config.NewRule(registration, graffiti.NewRule(name).MatchLabels(selectors...).Block())
+}
+
+func TestActivateRulesDropsInvalidRules(t *testing.T) {
+	c := config.Configuration{Rules: []config.Rule{testRule("good-rule", "app=web"), testRule("bad-rule", "app in (")}}
+	require.NoError(t, ActivateRules(&c))
+	require.Len(t, c.Rules, 1)
+	assert.Equal(t, "good-rule", c.Rules[0].Registration.Name)
+
+	for _, status := range metrics.Rules.Statuses() {
+		switch status.Name {
+		case "good-rule":
+			assert.True(t, status.Active)
+		case "bad-rule":
+			assert.False(t, status.Active)
+			assert.Contains(t, status.ActivationError, "invalid label selector")
+		}
+	}
+
+	c = config.Configuration{Rules: []config.Rule{testRule("bad-rule", "app in (")}}
+	err := ActivateRules(&c)
+	assert.EqualError(t, err, "invalid configuration: none of the 1 rules are valid")
+	assert.True(t, errors.Is(err, config.ErrConfigInvalid))
+}
+
+func TestRunRefusesAnInvalidConfiguration(t *testing.T) {
+	c := config.Default()
+	c.Server.Namespace = "kube-graffiti"
+	err := Run(context.Background(), Options{Config: c})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid configuration")
+	assert.True(t, errors.Is(err, config.ErrConfigInvalid))
+}
+
+func TestCheckTargetsIsAConfigurationError(t *testing.T) {
+	d := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	d.Resources = []*metav1.APIResourceList{{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods"}}}}
+
+	c := config.Configuration{Rules: []config.Rule{testRule("label-pods", "app=web")}}
+	assert.NoError(t, CheckTargets(c, d))
+
+	missing := testRule("label-widgets", "app=web")
+	missing.Registration.Resources = []string{"widgets.example.com"}
+	c.Rules = append(c.Rules, missing)
+	err := CheckTargets(c, d)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, config.ErrConfigInvalid))
+	assert.Contains(t, err.Error(), "rule 'label-widgets' targets api group 'example.com'")
+}
+
+func TestActionQueueIsOnlyNeededToLabelRelatedServices(t *testing.T) {
+	rules := []config.Rule{testRule("deny-privileged", "privileged=true")}
+	assert.False(t, needsActionQueue(rules))
+
+	labelling := config.NewRule(webhook.Registration{Resources: []string{"deployments"}}, graffiti.NewRule("label-services").AddLabels(map[string]string{"team": "a"}))
+	labelling.Payload.LabelRelatedServices = true
+	assert.True(t, needsActionQueue(append(rules, labelling)))
+}
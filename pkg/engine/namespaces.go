@@ -0,0 +1,56 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"sync"
+
+	"github.com/Telefonica/kube-graffiti/pkg/existing"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// namespaceLookup starts caching the cluster's namespaces the first time that a rule inherits from one, so that only
+// the configurations which use inherit-from-namespace need to be allowed to list and watch namespaces.
+type namespaceLookup struct {
+	r    *rest.Config
+	stop <-chan struct{}
+
+	once   sync.Once
+	lookup graffiti.NamespaceLookup
+	err    error
+}
+
+func newNamespaceLookup(r *rest.Config, stop <-chan struct{}) *namespaceLookup {
+	return &namespaceLookup{r: r, stop: stop}
+}
+
+// LookupNamespace finds the namespace in the cache, which falls back to getting it from the apiserver until the
+// cache has caught up.
+func (n *namespaceLookup) LookupNamespace(name string) (*corev1.Namespace, error) {
+	n.once.Do(func() {
+		cache, err := existing.NewNamespaceCache(n.r)
+		if err != nil {
+			n.err = err
+			return
+		}
+		cache.StartNamespaceReflector(n.stop)
+		n.lookup = cache
+	})
+	if n.err != nil {
+		return nil, n.err
+	}
+	return n.lookup.LookupNamespace(name)
+}
@@ -0,0 +1,55 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"encoding/json"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// parentLookup gets the parent objects of subresources from the apiserver, so that rules registered for
+// subresources such as 'deployments/scale' can match the deployment.  The client is only made the first time that
+// a parent is looked up.
+type parentLookup struct {
+	r *rest.Config
+
+	once   sync.Once
+	client dynamic.Interface
+	err    error
+}
+
+func newParentLookup(r *rest.Config) *parentLookup {
+	return &parentLookup{r: r}
+}
+
+// LookupParent gets the object of the resource, it is always read from the apiserver so that it is up to date.
+func (p *parentLookup) LookupParent(resource metav1.GroupVersionResource, namespace, name string) ([]byte, error) {
+	p.once.Do(func() {
+		p.client, p.err = dynamic.NewForConfig(p.r)
+	})
+	if p.err != nil {
+		return nil, p.err
+	}
+	gvr := schema.GroupVersionResource{Group: resource.Group, Version: resource.Version, Resource: resource.Resource}
+	object, err := p.client.Resource(gvr).Namespace(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(object.Object)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// quotaLookup lists the resource quotas of the namespaces that rules enrich, the client is only made the first time
+// that they are looked up so that only the configurations which enrich with quotas need to be allowed to list them.
+type quotaLookup struct {
+	r *rest.Config
+
+	once   sync.Once
+	client kubernetes.Interface
+	err    error
+}
+
+func newQuotaLookup(r *rest.Config) *quotaLookup {
+	return &quotaLookup{r: r}
+}
+
+// LookupQuotas lists the namespace's resource quotas from the apiserver, so that their usage is up to date.
+func (q *quotaLookup) LookupQuotas(namespace string) ([]corev1.ResourceQuota, error) {
+	q.once.Do(func() {
+		q.client, q.err = kubernetes.NewForConfig(q.r)
+	})
+	if q.err != nil {
+		return nil, q.err
+	}
+	list, err := q.client.CoreV1().ResourceQuotas(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
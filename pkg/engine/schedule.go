@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// scheduleInterval is how often the schedules of the rules are checked, which is as fine as their windows go.
+const scheduleInterval = time.Minute
+
+// errOutsideSchedule is the activation error of a rule which is valid but outside of its schedule.
+var errOutsideSchedule = errors.New("rule is outside of its active schedule")
+
+// ruleSchedule serves the rules of the configuration file only while they are within their schedules, registering
+// them with the apiserver when they become active and deleting their registrations when they stop being active.  It
+// also has the rules of the ConfigMaps checked against their schedules.
+type ruleSchedule struct {
+	c       config.Configuration
+	server  ruleServer
+	k       kubernetes.Interface
+	cmRules *configMapRules
+	clock   func() time.Time
+
+	sync.Mutex
+	served map[string]bool
+}
+
+// newRuleSchedule creates the schedule of the configuration's rules, of which those that are served have already been
+// added to the server and registered.
+func newRuleSchedule(c config.Configuration, served []config.Rule, server ruleServer, k kubernetes.Interface) *ruleSchedule {
+	s := &ruleSchedule{c: c, server: server, k: k, clock: time.Now, served: make(map[string]bool)}
+	for _, rule := range served {
+		s.served[rule.Registration.Name] = true
+	}
+	for _, rule := range c.Rules {
+		if !s.served[rule.Registration.Name] {
+			metrics.Rules.SetActive(rule.Registration.Name, errOutsideSchedule)
+			metrics.Rules.SetRegistered(rule.Registration.Name, errOutsideSchedule)
+		}
+	}
+	return s
+}
+
+// hasScheduledRules is true when any of the rules have a schedule.
+func hasScheduledRules(rules []config.Rule) bool {
+	for _, rule := range rules {
+		if rule.Scheduled() {
+			return true
+		}
+	}
+	return false
+}
+
+// run checks the schedules every interval until stop is closed.
+func (s *ruleSchedule) run(interval time.Duration, stop <-chan struct{}) {
+	mylog := log.ComponentLogger(componentName, "ruleSchedule.run")
+	mylog.Info().Dur("interval", interval).Msg("checking the schedules of the rules")
+	wait.Until(s.check, interval, stop)
+}
+
+// check serves the rules whose schedules have started and stops serving those whose schedules have ended.
+func (s *ruleSchedule) check() {
+	s.Lock()
+	now := s.clock()
+	for _, rule := range s.c.Rules {
+		if !rule.Scheduled() {
+			continue
+		}
+		active := rule.Active(now)
+		switch name := rule.Registration.Name; {
+		case active && !s.served[name]:
+			s.serve(rule)
+		case !active && s.served[name]:
+			s.unserve(rule, rule.Expired(now))
+		}
+	}
+	s.Unlock()
+	s.cmRules.resync()
+}
+
+// serve adds a rule to the webhook server and registers it with the apiserver.
+func (s *ruleSchedule) serve(rule config.Rule) {
+	mylog := log.ComponentLogger(componentName, "ruleSchedule.serve")
+	name := rule.Registration.Name
+	mylog.Info().Str("rule", name).Msg("rule has become active")
+
+	graffiti.WarmRules([]graffiti.Rule{rule.GraffitiRule()}, s.c.Server.WarmupBudget)
+	s.server.ReplaceRule(rule.WebhookRegistration(), rule.GraffitiRule())
+	metrics.Rules.SetActive(name, nil)
+	err := s.server.RegisterHookWithRetry(rule.WebhookRegistration(), s.k)
+	metrics.Rules.SetRegistered(name, err)
+	if err != nil {
+		mylog.Error().Err(err).Str("rule", name).Msg("failed to register rule with apiserver")
+	}
+	s.server.KeepRegistration(rule.WebhookRegistration())
+	s.served[name] = true
+}
+
+// unserve removes a rule from the webhook server and deletes its registration, so that the apiserver stops calling
+// us for it.
+func (s *ruleSchedule) unserve(rule config.Rule, expired bool) {
+	mylog := log.ComponentLogger(componentName, "ruleSchedule.unserve")
+	name := rule.Registration.Name
+	mylog.Info().Str("rule", name).Bool("expired", expired).Msg("rule is no longer active")
+
+	s.server.ForgetRegistration(name)
+	if err := s.server.DeregisterHook(rule.WebhookRegistration(), webhook.ShutdownActionDelete, s.k); err != nil {
+		mylog.Error().Err(err).Str("rule", name).Msg("failed to deregister rule with apiserver")
+	}
+	s.server.RemoveRule(name)
+	metrics.Rules.SetActive(name, errOutsideSchedule)
+	metrics.Rules.SetRegistered(name, errOutsideSchedule)
+	delete(s.served, name)
+}
+
+// servedRules returns the rules of the configuration file that are being served, so that their registrations can be
+// dealt with on shutdown.
+func (s *ruleSchedule) servedRules() []config.Rule {
+	s.Lock()
+	defer s.Unlock()
+	var rules []config.Rule
+	for _, rule := range s.c.Rules {
+		if s.served[rule.Registration.Name] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
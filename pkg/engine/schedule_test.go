@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRuleScheduleServesRulesWithinTheirSchedules(t *testing.T) {
+	start := time.Date(2020, 3, 2, 12, 0, 0, 0, time.UTC)
+	always := testRule("always", "app=web")
+	freeze := testRule("freeze", "app=web")
+	freeze.ActiveFrom = "2020-03-02T18:00:00Z"
+	freeze.ActiveUntil = "2020-03-03T06:00:00Z"
+
+	c := config.Default()
+	c.Rules = []config.Rule{always, freeze}
+	served := config.ActiveRules(c.Rules, start)
+	require.Equal(t, []config.Rule{always}, served)
+	server := newFakeRuleServer()
+	schedule := newRuleSchedule(c, served, server, fake.NewSimpleClientset())
+	now := start
+	schedule.clock = func() time.Time { return now }
+	assert.True(t, hasScheduledRules(c.Rules))
+
+	schedule.check()
+	assert.Empty(t, server.served(), "rules are not served before they become active")
+	assert.Equal(t, []config.Rule{always}, schedule.servedRules())
+	assert.False(t, ruleStatus(t, "freeze").Active)
+
+	now = time.Date(2020, 3, 2, 18, 0, 0, 0, time.UTC)
+	schedule.check()
+	assert.Contains(t, server.served(), "freeze")
+	assert.True(t, server.registered["freeze"])
+	assert.True(t, ruleStatus(t, "freeze").Active)
+	assert.Equal(t, []config.Rule{always, freeze}, schedule.servedRules())
+
+	now = time.Date(2020, 3, 3, 6, 0, 0, 0, time.UTC)
+	schedule.check()
+	assert.NotContains(t, server.served(), "freeze", "expired rules are no longer served")
+	assert.False(t, server.registered["freeze"])
+	assert.Equal(t, []string{"freeze"}, server.deregistered, "expired rules are deregistered")
+	assert.Equal(t, errOutsideSchedule.Error(), ruleStatus(t, "freeze").ActivationError)
+	assert.Equal(t, []config.Rule{always}, schedule.servedRules())
+}
+
+func TestConfigMapRulesFollowTheirSchedules(t *testing.T) {
+	cm := rulesConfigMap("team-a", "team-a-pods", "a")
+	cm.Data["rules.yaml"] += "  active-until: 2020-03-03T00:00:00Z\n"
+	clientset := fake.NewSimpleClientset(cm)
+
+	c := config.Default()
+	c.ConfigMaps = config.ConfigMaps{Selector: "kube-graffiti/rules=true"}
+	server := newFakeRuleServer()
+	cmRules := newConfigMapRules(c, server, clientset)
+	now := time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)
+	cmRules.clock = func() time.Time { return now }
+	require.NoError(t, cmRules.start())
+	assert.Contains(t, server.served(), "team-a-pods")
+
+	schedule := newRuleSchedule(c, nil, server, clientset)
+	schedule.cmRules = cmRules
+	now = time.Date(2020, 3, 3, 0, 0, 0, 0, time.UTC)
+	schedule.check()
+	assert.Empty(t, server.served())
+	assert.Equal(t, []string{"team-a-pods"}, server.deregistered)
+}
+
+func ruleStatus(t *testing.T, name string) metrics.RuleStatus {
+	for _, status := range metrics.Rules.Statuses() {
+		if status.Name == name {
+			return status
+		}
+	}
+	t.Fatalf("rule %s is not tracked", name)
+	return metrics.RuleStatus{}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evaluation
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	protoPackage = "kubegraffiti.evaluation.v1"
+	serviceName  = protoPackage + ".Evaluator"
+	// EvaluateMethod is the full name of the grpc method, for clients that call it without generated code.
+	EvaluateMethod = "/" + serviceName + "/Evaluate"
+)
+
+// messages are the descriptors of the messages in evaluation.proto.  They are built here, rather than generated,
+// and must be kept in step with the proto file that clients generate their code from.
+var messages = buildMessages()
+
+type messageDescriptors struct {
+	request, result, response protoreflect.MessageDescriptor
+}
+
+func buildMessages() messageDescriptors {
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("evaluation.proto"),
+		Package: proto.String(protoPackage),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("EvaluateRequest",
+				field("rule", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("object", 2, descriptorpb.FieldDescriptorProto_TYPE_BYTES),
+				field("old_object", 3, descriptorpb.FieldDescriptorProto_TYPE_BYTES),
+				field("operation", 4, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("namespace", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("username", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				repeated(field("groups", 7, descriptorpb.FieldDescriptorProto_TYPE_STRING)),
+			),
+			message("RuleResult",
+				field("rule", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("matched", 2, descriptorpb.FieldDescriptorProto_TYPE_BOOL),
+				field("allowed", 3, descriptorpb.FieldDescriptorProto_TYPE_BOOL),
+				field("patch", 4, descriptorpb.FieldDescriptorProto_TYPE_BYTES),
+				field("message", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+				field("error", 6, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+			),
+			message("EvaluateResponse", repeated(messageField("results", 1, "RuleResult"))),
+		},
+	}
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		panic("invalid evaluation.proto descriptor: " + err.Error())
+	}
+	types := fd.Messages()
+	return messageDescriptors{
+		request:  types.ByName("EvaluateRequest"),
+		result:   types.ByName("RuleResult"),
+		response: types.ByName("EvaluateResponse"),
+	}
+}
+
+func message(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+}
+
+func field(name string, number int32, t descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(jsonName(name)),
+		Number:   proto.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     t.Enum(),
+	}
+}
+
+func messageField(name string, number int32, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := field(name, number, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE)
+	f.TypeName = proto.String("." + protoPackage + "." + typeName)
+	return f
+}
+
+func repeated(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return f
+}
+
+// jsonName is the lowerCamelCase json name that protoc gives a field.
+func jsonName(name string) string {
+	var b []byte
+	upper := false
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			b = append(b, c-'a'+'A')
+			upper = false
+		default:
+			b = append(b, c)
+			upper = false
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package evaluation evaluates kube-graffiti's rules against objects outside of the admission path, so that other
+// tools, such as CI validation services, can ask what the rules would do to an object.  It is served as a grpc api.
+package evaluation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const componentName = "evaluation"
+
+var (
+	// ErrUnknownRule is returned when the request names a rule that isn't being evaluated.
+	ErrUnknownRule = errors.New("unknown rule")
+	// ErrInvalidRequest is returned when the request's object or operation is invalid.
+	ErrInvalidRequest = errors.New("invalid evaluation request")
+)
+
+// Request is an object to evaluate, as json, with the old object that it replaces for updates.  The operation is
+// CREATE when it is empty, and the username and groups are those of the user making the request.
+type Request struct {
+	// Rule is the name of the rule to evaluate, all of the rules are evaluated in order when it is empty.
+	Rule      string
+	Object    []byte
+	OldObject []byte
+	Operation string
+	Namespace string
+	Username  string
+	Groups    []string
+}
+
+// Result is what a rule would do to the object: whether its matchers match it, whether it would be allowed and the
+// json patch that would paint it.  Error is set when the rule failed to evaluate or patch the object.
+type Result struct {
+	Rule    string
+	Matched bool
+	Allowed bool
+	Patch   []byte
+	Message string
+	Error   string
+}
+
+// Evaluator evaluates rules against objects in the same way as the admission webhook does, but without any side
+// effects: nothing is audited, recorded or counted.
+type Evaluator struct {
+	rules func() []graffiti.Rule
+}
+
+// NewEvaluator returns an evaluator of the rules, which are evaluated in their order of precedence.
+func NewEvaluator(rules []graffiti.Rule) *Evaluator {
+	sorted := append([]graffiti.Rule{}, rules...)
+	graffiti.SortRules(sorted)
+	return &Evaluator{rules: func() []graffiti.Rule { return sorted }}
+}
+
+// NewServedEvaluator returns an evaluator of the rules that served returns at the time of each evaluation, so that it
+// follows the rules that the webhook is serving as they change.
+func NewServedEvaluator(served func() []graffiti.Rule) *Evaluator {
+	return &Evaluator{rules: func() []graffiti.Rule {
+		rules := served()
+		graffiti.SortRules(rules)
+		return rules
+	}}
+}
+
+// Evaluate evaluates the named rule, or all of the rules, against the request's object.
+func (e *Evaluator) Evaluate(req Request) ([]Result, error) {
+	ar, err := admissionRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, rule := range e.rules() {
+		if req.Rule != "" && rule.Name != req.Rule {
+			continue
+		}
+		results = append(results, evaluate(rule, ar))
+	}
+	if req.Rule != "" && len(results) == 0 {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownRule, req.Rule)
+	}
+	return results, nil
+}
+
+func evaluate(rule graffiti.Rule, req *admission.AdmissionRequest) Result {
+	result := Result{Rule: rule.Name}
+	var resp *admission.AdmissionResponse
+	if rule.IsValidating() {
+		resp = rule.ValidateAdmission(req)
+	} else {
+		resp = rule.MutateAdmission(req)
+	}
+	result.Allowed = resp.Allowed
+	result.Patch = resp.Patch
+	if resp.Result != nil {
+		result.Message = resp.Result.Message
+		if resp.Result.Reason == metav1.StatusReasonInternalError || resp.Result.Reason == graffiti.StatusReasonPatchBuild {
+			result.Error = resp.Result.Message
+			return result
+		}
+	}
+	matched, err := rule.MatchesAdmission(req)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Matched = matched
+	return result
+}
+
+// admissionRequest is the dry-run admission request that the webhook would have been sent for the object.
+func admissionRequest(req Request) (*admission.AdmissionRequest, error) {
+	if len(req.Object) == 0 && len(req.OldObject) == 0 {
+		return nil, fmt.Errorf("%w: it has no object", ErrInvalidRequest)
+	}
+	operation := admission.Operation(req.Operation)
+	switch operation {
+	case "":
+		operation = admission.Create
+	case admission.Create, admission.Update, admission.Delete, admission.Connect:
+	default:
+		return nil, fmt.Errorf("%w: invalid operation '%s', must be one of CREATE, UPDATE, DELETE or CONNECT", ErrInvalidRequest, req.Operation)
+	}
+	var object struct {
+		metav1.TypeMeta
+		Metadata metav1.ObjectMeta `json:"metadata"`
+	}
+	raw := req.Object
+	if len(raw) == 0 {
+		raw = req.OldObject
+	}
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, fmt.Errorf("%w: the object isn't json: %v", ErrInvalidRequest, err)
+	}
+	gvk := schema.FromAPIVersionAndKind(object.APIVersion, object.Kind)
+	namespace := req.Namespace
+	if namespace == "" {
+		namespace = object.Metadata.Namespace
+	}
+	dryRun := true
+	return &admission.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind},
+		Name:      object.Metadata.Name,
+		Namespace: namespace,
+		Operation: operation,
+		UserInfo:  authenticationv1.UserInfo{Username: req.Username, Groups: req.Groups},
+		Object:    runtime.RawExtension{Raw: req.Object},
+		OldObject: runtime.RawExtension{Raw: req.OldObject},
+		DryRun:    &dryRun,
+	}, nil
+}
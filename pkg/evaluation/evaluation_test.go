@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evaluation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testPod = `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"web-1","namespace":"team-a","labels":{"app":"web"}}}`
+
+func testEvaluator() *Evaluator {
+	return NewEvaluator([]graffiti.Rule{
+		{Name: "label-web", Matchers: graffiti.Matchers{LabelSelectors: []string{"app = web"}}, Payload: graffiti.Payload{Additions: graffiti.Additions{Labels: map[string]string{"team": "a"}}}},
+		{Name: "block-api", Matchers: graffiti.Matchers{LabelSelectors: []string{"app = api"}}, Payload: graffiti.Payload{Block: true}},
+	})
+}
+
+func TestEvaluateReturnsWhatEachRuleWouldDo(t *testing.T) {
+	results, err := testEvaluator().Evaluate(Request{Object: []byte(testPod)})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "block-api", results[0].Rule, "rules are evaluated in their order of precedence")
+	assert.False(t, results[0].Matched)
+	assert.True(t, results[0].Allowed)
+	assert.Empty(t, results[0].Patch)
+	assert.Equal(t, "label-web", results[1].Rule)
+	assert.True(t, results[1].Matched)
+	assert.True(t, results[1].Allowed)
+	assert.Contains(t, string(results[1].Patch), `"team": "a"`)
+
+	results, err = testEvaluator().Evaluate(Request{Rule: "block-api", Object: []byte(`{"kind":"Pod","metadata":{"name":"api-1","labels":{"app":"api"}}}`)})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Matched)
+	assert.False(t, results[0].Allowed, "the blocking rule should deny the object")
+}
+
+func TestEvaluateRejectsInvalidRequests(t *testing.T) {
+	e := testEvaluator()
+	_, err := e.Evaluate(Request{Rule: "missing", Object: []byte(testPod)})
+	assert.True(t, errors.Is(err, ErrUnknownRule))
+	_, err = e.Evaluate(Request{Object: []byte(testPod), Operation: "PATCH"})
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	_, err = e.Evaluate(Request{Object: []byte("not json")})
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	_, err = e.Evaluate(Request{})
+	assert.EqualError(t, err, "invalid evaluation request: it has no object")
+}
+
+func TestConfigValidation(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.False(t, Config{}.Enabled(), "the evaluation api isn't served by default")
+	assert.NoError(t, Config{Port: 9443, CertPath: "/tls/cert", KeyPath: "/tls/key"}.Validate())
+	assert.Error(t, Config{Port: 70000}.Validate())
+	assert.EqualError(t, Config{Port: 9443, CertPath: "/tls/cert"}.Validate(), "evaluation.cert-path and evaluation.key-path must be set together")
+	assert.EqualError(t, Config{Port: 9443}.Validate(), "evaluation.cert-path and evaluation.key-path are needed to serve the evaluation api over tls")
+	assert.EqualError(t, Config{Port: 9443, CertPath: "/tls/cert", KeyPath: "/tls/key", ClientAuth: webhook.ClientAuth{AllowedNames: []string{"ci"}}}.Validate(), "evaluation.client-auth.allowed-names needs a ca-path to verify client certificates against")
+	_, err := NewServer(Config{}, testEvaluator())
+	assert.Error(t, err, "the api is never served without tls")
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1, which is also used as its own ca and client certificate.
+func writeTestCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ci"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	require.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
+
+func TestEvaluateOverGrpc(t *testing.T) {
+	certPath, keyPath := writeTestCert(t)
+	s, err := NewServer(Config{CertPath: certPath, KeyPath: keyPath, ClientAuth: webhook.ClientAuth{CAPath: certPath}}, testEvaluator())
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	pemBytes, err := ioutil.ReadFile(certPath)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(pemBytes))
+	clientCert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	anonymous, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots})))
+	require.NoError(t, err)
+	defer anonymous.Close()
+	probe := dynamicpb.NewMessage(messages.request)
+	probe.Set(messages.request.Fields().ByName("object"), protoreflect.ValueOfBytes([]byte(testPod)))
+	assert.Error(t, anonymous.Invoke(ctx, EvaluateMethod, probe, dynamicpb.NewMessage(messages.response)), "callers without a client certificate are rejected")
+
+	conn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{clientCert}})), grpc.WithBlock())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := dynamicpb.NewMessage(messages.request)
+	req.Set(messages.request.Fields().ByName("rule"), protoreflect.ValueOfString("label-web"))
+	req.Set(messages.request.Fields().ByName("object"), protoreflect.ValueOfBytes([]byte(testPod)))
+	resp := dynamicpb.NewMessage(messages.response)
+	require.NoError(t, conn.Invoke(ctx, EvaluateMethod, req, resp))
+
+	results := resp.Get(messages.response.Fields().ByName("results")).List()
+	require.Equal(t, 1, results.Len())
+	result := results.Get(0).Message()
+	fields := messages.result.Fields()
+	assert.Equal(t, "label-web", result.Get(fields.ByName("rule")).String())
+	assert.True(t, result.Get(fields.ByName("matched")).Bool())
+	assert.Contains(t, string(result.Get(fields.ByName("patch")).Bytes()), `"team": "a"`)
+
+	req.Set(messages.request.Fields().ByName("rule"), protoreflect.ValueOfString("missing"))
+	err = conn.Invoke(ctx, EvaluateMethod, req, dynamicpb.NewMessage(messages.response))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServedEvaluatorFollowsTheServedRules(t *testing.T) {
+	served := []graffiti.Rule{{Name: "label-web", Matchers: graffiti.Matchers{LabelSelectors: []string{"app = web"}}, Payload: graffiti.Payload{Additions: graffiti.Additions{Labels: map[string]string{"team": "a"}}}}}
+	e := NewServedEvaluator(func() []graffiti.Rule { return append([]graffiti.Rule{}, served...) })
+	results, err := e.Evaluate(Request{Object: []byte(testPod)})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	served = append(served, graffiti.Rule{Name: "block-api", Priority: 10, Matchers: graffiti.Matchers{LabelSelectors: []string{"app = api"}}, Payload: graffiti.Payload{Block: true}})
+	results, err = e.Evaluate(Request{Object: []byte(testPod)})
+	require.NoError(t, err)
+	require.Len(t, results, 2, "rules that start being served are evaluated")
+	assert.Equal(t, "block-api", results[0].Rule, "rules are evaluated in their order of precedence")
+
+	served = nil
+	_, err = e.Evaluate(Request{Rule: "label-web", Object: []byte(testPod)})
+	assert.True(t, errors.Is(err, ErrUnknownRule), "rules that are no longer served aren't evaluated")
+}
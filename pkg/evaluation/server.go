@@ -0,0 +1,184 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package evaluation
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Config models the evaluation section of our configuration and so has mapstructure tags.  The grpc api is only
+// served when it has a port, and always over tls with its certificate and key.
+type Config struct {
+	Port     int    `mapstructure:"port" yaml:"port,omitempty"`
+	CertPath string `mapstructure:"cert-path" yaml:"cert-path,omitempty"`
+	KeyPath  string `mapstructure:"key-path" yaml:"key-path,omitempty"`
+	// ClientAuth requires the callers of the api to present a client certificate, in the same way as the webhooks.
+	ClientAuth webhook.ClientAuth `mapstructure:"client-auth" yaml:"client-auth,omitempty"`
+}
+
+// Enabled is true when the grpc api is served.
+func (c Config) Enabled() bool {
+	return c.Port != 0
+}
+
+// Validate checks the port, that the certificate and key are given together, and that they are given when the api is
+// served, as the objects sent to it can hold secrets.
+func (c Config) Validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("invalid evaluation.port %d, must be between 1 and 65535, or 0 to not serve the evaluation api", c.Port)
+	}
+	if (c.CertPath == "") != (c.KeyPath == "") {
+		return errors.New("evaluation.cert-path and evaluation.key-path must be set together")
+	}
+	if c.Enabled() && c.CertPath == "" {
+		return errors.New("evaluation.cert-path and evaluation.key-path are needed to serve the evaluation api over tls")
+	}
+	if len(c.ClientAuth.AllowedNames) > 0 && !c.ClientAuth.Enabled() {
+		return errors.New("evaluation.client-auth.allowed-names needs a ca-path to verify client certificates against")
+	}
+	return nil
+}
+
+// evaluatorServer is the handler type of the grpc service.
+type evaluatorServer interface {
+	evaluate(ctx context.Context, in *dynamicpb.Message) (*dynamicpb.Message, error)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*evaluatorServer)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Evaluate",
+		Handler:    evaluateHandler,
+	}},
+	Metadata: "evaluation.proto",
+}
+
+func evaluateHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := dynamicpb.NewMessage(messages.request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(evaluatorServer).evaluate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: EvaluateMethod}
+	return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(evaluatorServer).evaluate(ctx, req.(*dynamicpb.Message))
+	})
+}
+
+// evaluate answers an EvaluateRequest with an EvaluateResponse.
+func (e *Evaluator) evaluate(_ context.Context, in *dynamicpb.Message) (*dynamicpb.Message, error) {
+	mylog := log.ComponentLogger(componentName, "evaluate")
+	req := requestFromMessage(in)
+	results, err := e.Evaluate(req)
+	switch {
+	case errors.Is(err, ErrUnknownRule):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrInvalidRequest):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	mylog.Debug().Str("rule", req.Rule).Int("results", len(results)).Msg("evaluated the rules against an object")
+	return resultsMessage(results), nil
+}
+
+// NewServer returns a grpc server of the evaluation api over tls, which requires client certificates when the
+// config's client-auth is enabled.
+func NewServer(c Config, e *Evaluator) (*grpc.Server, error) {
+	if c.CertPath == "" || c.KeyPath == "" {
+		return nil, errors.New("the evaluation api is only served over tls, it needs a certificate and key")
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertPath, c.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("can't load the evaluation api's certificate: %v", err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if err := c.ClientAuth.Apply(config); err != nil {
+		return nil, fmt.Errorf("can't set up the evaluation api's client-auth: %v", err)
+	}
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(config)))
+	s.RegisterService(&serviceDesc, e)
+	return s, nil
+}
+
+// Serve starts serving the evaluation api on the config's port in a go-routine, it is stopped with the server's
+// GracefulStop.
+func Serve(c Config, e *Evaluator) (*grpc.Server, error) {
+	mylog := log.ComponentLogger(componentName, "Serve")
+	s, err := NewServer(c, e)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", c.Port))
+	if err != nil {
+		return nil, fmt.Errorf("can't listen for the evaluation api: %v", err)
+	}
+	mylog.Info().Int("port", c.Port).Bool("client-auth", c.ClientAuth.Enabled()).Msg("serving the grpc evaluation api")
+	go func() {
+		if err := s.Serve(l); err != nil {
+			mylog.Error().Err(err).Msg("the evaluation api stopped serving")
+		}
+	}()
+	return s, nil
+}
+
+func requestFromMessage(m *dynamicpb.Message) Request {
+	fields := messages.request.Fields()
+	req := Request{
+		Rule:      m.Get(fields.ByName("rule")).String(),
+		Object:    m.Get(fields.ByName("object")).Bytes(),
+		OldObject: m.Get(fields.ByName("old_object")).Bytes(),
+		Operation: m.Get(fields.ByName("operation")).String(),
+		Namespace: m.Get(fields.ByName("namespace")).String(),
+		Username:  m.Get(fields.ByName("username")).String(),
+	}
+	groups := m.Get(fields.ByName("groups")).List()
+	for i := 0; i < groups.Len(); i++ {
+		req.Groups = append(req.Groups, groups.Get(i).String())
+	}
+	return req
+}
+
+func resultsMessage(results []Result) *dynamicpb.Message {
+	m := dynamicpb.NewMessage(messages.response)
+	list := m.Mutable(messages.response.Fields().ByName("results")).List()
+	fields := messages.result.Fields()
+	for _, r := range results {
+		rm := dynamicpb.NewMessage(messages.result)
+		rm.Set(fields.ByName("rule"), protoreflect.ValueOfString(r.Rule))
+		rm.Set(fields.ByName("matched"), protoreflect.ValueOfBool(r.Matched))
+		rm.Set(fields.ByName("allowed"), protoreflect.ValueOfBool(r.Allowed))
+		rm.Set(fields.ByName("patch"), protoreflect.ValueOfBytes(r.Patch))
+		rm.Set(fields.ByName("message"), protoreflect.ValueOfString(r.Message))
+		rm.Set(fields.ByName("error"), protoreflect.ValueOfString(r.Error))
+		list.Append(protoreflect.ValueOfMessage(rm))
+	}
+	return m
+}
@@ -0,0 +1,207 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package events records what kube-graffiti does to objects as kubernetes Events, so that its activity shows up in
+// 'kubectl describe'.  Events are recorded on the objects that rules patch, block or fail on, or on the kube-graffiti
+// pod for objects that don't exist yet, such as those being created.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	admission "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	componentName  = "events"
+	eventComponent = "kube-graffiti"
+	// maxPatchLength limits how much of a patch is included in an event's message.
+	maxPatchLength = 512
+)
+
+// The reasons of the events that are recorded.
+const (
+	// ReasonPatched is a rule patching an object, when it is admitted or when existing objects are checked.
+	ReasonPatched = "Patched"
+	// ReasonBlocked is a rule blocking or denying an admission request.
+	ReasonBlocked = "Blocked"
+	// ReasonRuleFailed is a rule failing to evaluate or patch the object of an admission request.
+	ReasonRuleFailed = "RuleFailed"
+	// ReasonPatchFailed is a failure to patch an existing object.
+	ReasonPatchFailed = "PatchFailed"
+)
+
+// Config models the events section of our configuration and so has mapstructure tags.
+type Config struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled,omitempty"`
+	// PodName and PodNamespace identify the kube-graffiti pod, which is given the events about objects that don't
+	// exist yet.  They are usually set from the downward api, without them those events are recorded on the object
+	// by name and aren't shown when it is described.
+	PodName      string `mapstructure:"pod-name" yaml:"pod-name,omitempty"`
+	PodNamespace string `mapstructure:"pod-namespace" yaml:"pod-namespace,omitempty"`
+}
+
+// Validate checks that the pod is either fully identified or not at all.
+func (c Config) Validate() error {
+	if (c.PodName == "") != (c.PodNamespace == "") {
+		return errors.New("events.pod-name and events.pod-namespace must be set together")
+	}
+	return nil
+}
+
+// Recorder records kubernetes Events about the objects that rules change, its methods are safe to call on a nil
+// Recorder, which records nothing.
+type Recorder struct {
+	// sending is the delivery of events to the apiserver, nil when they aren't being sent.
+	sending  watch.Interface
+	recorder record.EventRecorder
+	// pod is given the events about objects that don't have a uid, nil when the pod isn't known.
+	pod *corev1.ObjectReference
+}
+
+// NewRecorder creates a Recorder which sends its events to the apiserver from a background go-routine.
+func NewRecorder(c Config, k kubernetes.Interface) *Recorder {
+	broadcaster := record.NewBroadcaster()
+	r := newRecorder(c, broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent}))
+	r.sending = broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k.CoreV1().Events("")})
+	return r
+}
+
+func newRecorder(c Config, recorder record.EventRecorder) *Recorder {
+	r := &Recorder{recorder: recorder}
+	if c.PodName != "" {
+		r.pod = &corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: c.PodNamespace, Name: c.PodName}
+	}
+	return r
+}
+
+// Stop stops sending events to the apiserver.
+func (r *Recorder) Stop() {
+	if r == nil || r.sending == nil {
+		return
+	}
+	r.sending.Stop()
+}
+
+// Admission records the response of a rule to an admission request when the rule patched, blocked or failed on its
+// object.  Nothing is recorded for dry runs, which mustn't have side effects.
+func (r *Recorder) Admission(rule string, req *admission.AdmissionRequest, resp *admission.AdmissionResponse) {
+	if r == nil || req == nil || resp == nil || (req.DryRun != nil && *req.DryRun) {
+		return
+	}
+	var message string
+	if resp.Result != nil {
+		message = resp.Result.Message
+	}
+	ref := admissionReference(req)
+	switch {
+	case resp.Result != nil && resp.Result.Status == metav1.StatusFailure:
+		r.record(ref, corev1.EventTypeWarning, ReasonRuleFailed, fmt.Sprintf("rule %s failed: %s", rule, message))
+	case !resp.Allowed:
+		r.record(ref, corev1.EventTypeWarning, ReasonBlocked, fmt.Sprintf("rule %s blocked %s: %s", rule, string(req.Operation), message))
+	case len(resp.Patch) > 0:
+		r.record(ref, corev1.EventTypeNormal, ReasonPatched, fmt.Sprintf("rule %s patched the object: %s", rule, truncate(resp.Patch)))
+	}
+}
+
+// Existing records a rule patching an existing object, or failing to when err is not nil.
+func (r *Recorder) Existing(rule string, object unstructured.Unstructured, patch []byte, err error) {
+	if r == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		Kind:            object.GetKind(),
+		APIVersion:      object.GetAPIVersion(),
+		Namespace:       object.GetNamespace(),
+		Name:            object.GetName(),
+		UID:             object.GetUID(),
+		ResourceVersion: object.GetResourceVersion(),
+	}
+	if err != nil {
+		r.record(ref, corev1.EventTypeWarning, ReasonPatchFailed, fmt.Sprintf("rule %s failed to patch the existing object: %v", rule, err))
+		return
+	}
+	r.record(ref, corev1.EventTypeNormal, ReasonPatched, fmt.Sprintf("rule %s patched the existing object: %s", rule, truncate(patch)))
+}
+
+// record records an event on the object, or on our pod when the object doesn't exist yet.
+func (r *Recorder) record(ref *corev1.ObjectReference, eventType, reason, message string) {
+	mylog := log.ComponentLogger(componentName, "record")
+	if ref.UID == "" && r.pod != nil {
+		message = fmt.Sprintf("%s %s: %s", ref.Kind, objectName(ref), message)
+		ref = r.pod
+	}
+	mylog.Debug().Str("kind", ref.Kind).Str("namespace", ref.Namespace).Str("name", ref.Name).Str("reason", reason).Msg("recording event")
+	r.recorder.Event(ref, eventType, reason, message)
+}
+
+// admissionReference refers to the object of an admission request, its uid is only known once it exists.
+func admissionReference(req *admission.AdmissionRequest) *corev1.ObjectReference {
+	apiVersion := req.Kind.Version
+	if req.Kind.Group != "" {
+		apiVersion = req.Kind.Group + "/" + req.Kind.Version
+	}
+	raw := req.Object.Raw
+	if len(raw) == 0 {
+		raw = req.OldObject.Raw
+	}
+	var object struct {
+		Metadata struct {
+			Name         string    `json:"name"`
+			GenerateName string    `json:"generateName"`
+			UID          types.UID `json:"uid"`
+		} `json:"metadata"`
+	}
+	// the reference is still useful without the object's metadata
+	_ = json.Unmarshal(raw, &object)
+	name := req.Name
+	if name == "" {
+		name = object.Metadata.Name
+	}
+	if name == "" {
+		name = object.Metadata.GenerateName
+	}
+	return &corev1.ObjectReference{
+		Kind:       req.Kind.Kind,
+		APIVersion: apiVersion,
+		Namespace:  req.Namespace,
+		Name:       name,
+		UID:        object.Metadata.UID,
+	}
+}
+
+func objectName(ref *corev1.ObjectReference) string {
+	if ref.Namespace == "" {
+		return ref.Name
+	}
+	return ref.Namespace + "/" + ref.Name
+}
+
+func truncate(patch []byte) string {
+	if len(patch) <= maxPatchLength {
+		return string(patch)
+	}
+	return string(patch[:maxPatchLength]) + "..."
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// event is an event recorded by testRecorder.
+type event struct {
+	ref       *corev1.ObjectReference
+	eventType string
+	reason    string
+	message   string
+}
+
+// testRecorder keeps the events that it is given along with the objects that they are about.
+type testRecorder struct {
+	record.FakeRecorder
+	events []event
+}
+
+func (t *testRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	t.events = append(t.events, event{ref: object.(*corev1.ObjectReference), eventType: eventType, reason: reason, message: message})
+}
+
+func testRequest(object string) *admission.AdmissionRequest {
+	return &admission.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Namespace: "web",
+		Name:      "frontend",
+		Operation: admission.Update,
+		Object:    runtime.RawExtension{Raw: []byte(object)},
+	}
+}
+
+func TestAdmissionEventsAreRecordedOnTheObject(t *testing.T) {
+	recorder := &testRecorder{}
+	r := newRecorder(Config{PodName: "graffiti-0", PodNamespace: "kube-graffiti"}, recorder)
+
+	req := testRequest(`{"metadata":{"name":"frontend","uid":"1234"}}`)
+	r.Admission("label-deployments", req, &admission.AdmissionResponse{Allowed: true, Patch: []byte(`[{"op":"add"}]`)})
+	r.Admission("deny-deployments", req, &admission.AdmissionResponse{Allowed: false, Result: &metav1.Status{Message: "denied"}})
+	r.Admission("broken", req, &admission.AdmissionResponse{Allowed: true, Result: &metav1.Status{Status: metav1.StatusFailure, Message: "boom"}})
+	r.Admission("unmatched", req, &admission.AdmissionResponse{Allowed: true})
+
+	require.Len(t, recorder.events, 3)
+	assert.Equal(t, &corev1.ObjectReference{Kind: "Deployment", APIVersion: "apps/v1", Namespace: "web", Name: "frontend", UID: "1234"}, recorder.events[0].ref)
+	assert.Equal(t, event{ref: recorder.events[0].ref, eventType: corev1.EventTypeNormal, reason: ReasonPatched, message: `rule label-deployments patched the object: [{"op":"add"}]`}, recorder.events[0])
+	assert.Equal(t, ReasonBlocked, recorder.events[1].reason)
+	assert.Equal(t, corev1.EventTypeWarning, recorder.events[1].eventType)
+	assert.Equal(t, "rule broken failed: boom", recorder.events[2].message)
+}
+
+func TestEventsAboutNewObjectsAreRecordedOnThePod(t *testing.T) {
+	recorder := &testRecorder{}
+	r := newRecorder(Config{PodName: "graffiti-0", PodNamespace: "kube-graffiti"}, recorder)
+
+	req := testRequest(`{"metadata":{"name":"frontend"}}`)
+	req.Operation = admission.Create
+	r.Admission("label-deployments", req, &admission.AdmissionResponse{Allowed: true, Patch: []byte(`[]`)})
+
+	require.Len(t, recorder.events, 1)
+	assert.Equal(t, &corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: "kube-graffiti", Name: "graffiti-0"}, recorder.events[0].ref)
+	assert.Equal(t, "Deployment web/frontend: rule label-deployments patched the object: []", recorder.events[0].message)
+}
+
+func TestDryRunsAreNotRecorded(t *testing.T) {
+	recorder := &testRecorder{}
+	r := newRecorder(Config{}, recorder)
+
+	dryRun := true
+	req := testRequest(`{"metadata":{"name":"frontend","uid":"1234"}}`)
+	req.DryRun = &dryRun
+	r.Admission("label-deployments", req, &admission.AdmissionResponse{Allowed: true, Patch: []byte(`[]`)})
+	assert.Empty(t, recorder.events)
+}
+
+func TestExistingObjectEvents(t *testing.T) {
+	recorder := &testRecorder{}
+	r := newRecorder(Config{}, recorder)
+	object := unstructured.Unstructured{}
+	object.SetAPIVersion("v1")
+	object.SetKind("Namespace")
+	object.SetName("web")
+	object.SetUID("5678")
+
+	r.Existing("label-namespaces", object, []byte(`[]`), nil)
+	r.Existing("label-namespaces", object, []byte(`[]`), assert.AnError)
+
+	require.Len(t, recorder.events, 2)
+	assert.Equal(t, ReasonPatched, recorder.events[0].reason)
+	assert.Equal(t, ReasonPatchFailed, recorder.events[1].reason)
+	assert.Equal(t, corev1.EventTypeWarning, recorder.events[1].eventType)
+	assert.Equal(t, "Namespace", recorder.events[1].ref.Kind)
+}
+
+func TestNilRecorderRecordsNothing(t *testing.T) {
+	var r *Recorder
+	r.Admission("rule", testRequest(`{}`), &admission.AdmissionResponse{Allowed: false})
+	r.Existing("rule", unstructured.Unstructured{}, nil, nil)
+	r.Stop()
+}
+
+func TestConfigNeedsTheWholePod(t *testing.T) {
+	assert.NoError(t, Config{Enabled: true}.Validate())
+	assert.NoError(t, Config{Enabled: true, PodName: "graffiti-0", PodNamespace: "kube-graffiti"}.Validate())
+	assert.Error(t, Config{Enabled: true, PodName: "graffiti-0"}.Validate())
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	jsonpatch "github.com/cameront/go-jsonpatch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// fieldManager is the field manager that owns the labels and annotations that kube-graffiti applies to existing objects.
+const fieldManager = "kube-graffiti"
+
+// metadataMaps are the parts of an object that can be server-side applied, everything else is patched.
+var metadataMaps = []string{"labels", "annotations"}
+
+// applyConfiguration turns a graffiti patch into a server-side apply configuration which only contains the object's
+// identity and the labels and annotations that kube-graffiti owns, so that the apiserver tracks their ownership.
+// All of the rules share the same field manager and anything that it previously applied but leaves out of the
+// configuration would be removed, so the labels and annotations that it already owns are kept.
+// It returns false when the patch does anything else, such as removing labels or changing the spec, which can only be
+// done with the patch itself.
+func applyConfiguration(object unstructured.Unstructured, patch []byte) ([]byte, bool, error) {
+	var ops jsonpatch.Patch
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, false, fmt.Errorf("%w: failed to unmarshal patch: %v", graffiti.ErrPatchBuild, err)
+	}
+	for _, op := range ops.Operations {
+		// the jsonpatch package doesn't unescape '~0' and '~1' in paths, leave them to the apiserver.
+		if strings.Contains(op.Path, "~") || strings.Contains(op.From, "~") {
+			return nil, false, nil
+		}
+	}
+	patched := object.DeepCopy().Object
+	if err := ops.Apply(&patched); err != nil {
+		return nil, false, fmt.Errorf("%w: failed to apply patch: %v", graffiti.ErrPatchBuild, err)
+	}
+	if !onlyMetadataMapsDiffer(object.Object, patched) {
+		return nil, false, nil
+	}
+
+	owned := ownedMetadataKeys(object.GetManagedFields())
+	metadata := map[string]interface{}{"name": object.GetName()}
+	if object.GetNamespace() != "" {
+		metadata["namespace"] = object.GetNamespace()
+	}
+	for _, field := range metadataMaps {
+		before, _, _ := unstructured.NestedStringMap(object.Object, "metadata", field)
+		after, _, _ := unstructured.NestedStringMap(patched, "metadata", field)
+		applied := make(map[string]interface{})
+		for k, v := range after {
+			if old, ok := before[k]; !ok || old != v || owned[field][k] {
+				applied[k] = v
+			}
+		}
+		for k := range before {
+			if _, ok := after[k]; !ok {
+				// removing a label or annotation that we might not own.
+				return nil, false, nil
+			}
+		}
+		if len(applied) > 0 {
+			metadata[field] = applied
+		}
+	}
+
+	config, err := json.Marshal(map[string]interface{}{
+		"apiVersion": object.GetAPIVersion(),
+		"kind":       object.GetKind(),
+		"metadata":   metadata,
+	})
+	return config, err == nil, err
+}
+
+// onlyMetadataMapsDiffer is true when the objects are the same apart from their labels and annotations.
+func onlyMetadataMapsDiffer(object, patched map[string]interface{}) bool {
+	return reflect.DeepEqual(copyWithoutMetadataMaps(object), copyWithoutMetadataMaps(patched))
+}
+
+func copyWithoutMetadataMaps(object map[string]interface{}) map[string]interface{} {
+	c := runtime.DeepCopyJSON(object)
+	for _, field := range metadataMaps {
+		unstructured.RemoveNestedField(c, "metadata", field)
+	}
+	return c
+}
+
+// ownedMetadataKeys returns the labels and annotations which our field manager has applied, from the object's
+// managed fields, which list them as e.g. {"f:metadata":{"f:labels":{"f:team":{}}}}.
+func ownedMetadataKeys(entries []metav1.ManagedFieldsEntry) map[string]map[string]bool {
+	owned := make(map[string]map[string]bool)
+	for _, entry := range entries {
+		if entry.Manager != fieldManager || entry.Operation != metav1.ManagedFieldsOperationApply || entry.FieldsV1 == nil {
+			continue
+		}
+		var fields map[string]map[string]map[string]interface{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		for _, field := range metadataMaps {
+			for k := range fields["f:metadata"]["f:"+field] {
+				if !strings.HasPrefix(k, "f:") {
+					continue
+				}
+				if owned[field] == nil {
+					owned[field] = make(map[string]bool)
+				}
+				owned[field][strings.TrimPrefix(k, "f:")] = true
+			}
+		}
+	}
+	return owned
+}
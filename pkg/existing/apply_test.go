@@ -0,0 +1,158 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const appliedConfigMap = `{
+	"apiVersion": "v1",
+	"kind": "ConfigMap",
+	"metadata": {
+		"name": "settings",
+		"namespace": "default",
+		"labels": {"team": "web", "cost-centre": "1234"},
+		"annotations": {"owner": "web@acme.com"},
+		"managedFields": [
+			{"manager": "kube-graffiti", "operation": "Apply", "apiVersion": "v1", "fieldsType": "FieldsV1",
+			 "fieldsV1": {"f:metadata": {"f:labels": {".": {}, "f:cost-centre": {}}}}},
+			{"manager": "kubectl", "operation": "Update", "apiVersion": "v1", "fieldsType": "FieldsV1",
+			 "fieldsV1": {"f:metadata": {"f:labels": {"f:team": {}}, "f:annotations": {"f:owner": {}}}}}
+		]
+	},
+	"data": {"colour": "green"}
+}`
+
+func appliedObject(t *testing.T) unstructured.Unstructured {
+	var object unstructured.Unstructured
+	require.NoError(t, json.Unmarshal([]byte(appliedConfigMap), &object.Object))
+	return object
+}
+
+func TestApplyConfigurationKeepsOwnedLabels(t *testing.T) {
+	patch := []byte(`[{"op":"replace","path":"/metadata/labels","value":{"team":"web","cost-centre":"1234","added":"by-graffiti"}},{"op":"replace","path":"/metadata/annotations","value":{"owner":"web@acme.com","acme.com/checked":"true"}}]`)
+	config, apply, err := applyConfiguration(appliedObject(t), patch)
+	require.NoError(t, err)
+	require.True(t, apply)
+	assert.JSONEq(t, `{
+		"apiVersion": "v1",
+		"kind": "ConfigMap",
+		"metadata": {
+			"name": "settings",
+			"namespace": "default",
+			"labels": {"added": "by-graffiti", "cost-centre": "1234"},
+			"annotations": {"acme.com/checked": "true"}
+		}
+	}`, string(config), "only new labels and annotations, and those that were previously applied, are applied")
+}
+
+func TestApplyConfigurationFallsBackToPatching(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{"removed label", `[{"op":"replace","path":"/metadata/labels","value":{"cost-centre":"1234"}}]`},
+		{"removed annotations", `[{"op":"remove","path":"/metadata/annotations"}]`},
+		{"escaped path", `[{"op":"add","path":"/metadata/annotations/acme.com~1checked","value":"true"}]`},
+		{"changed data", `[{"op":"replace","path":"/data/colour","value":"red"}]`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, apply, err := applyConfiguration(appliedObject(t), []byte(tc.patch))
+			require.NoError(t, err)
+			assert.False(t, apply)
+		})
+	}
+}
+
+func TestApplyConflictsAreReported(t *testing.T) {
+	var rule config.Rule
+	rule.Registration.Name = "add-a-label"
+	rule.Payload.Additions.Labels = map[string]string{"team": "platform"}
+
+	ri := mockDynamicResourceInterface{}
+	conflict := errors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "settings", nil)
+	ri.On("Patch", "settings", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, conflict)
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.On("Namespace", "default").Return(&ri)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}).Return(&nri)
+	dynamicClient = &dc
+
+	assert.False(t, applyToObject(&rule, "v1", "configmaps", appliedObject(t)), "the team label belongs to kubectl")
+	ri.AssertExpectations(t)
+}
+
+func TestAggregatedResourcesWithoutApplyArePatched(t *testing.T) {
+	var rule config.Rule
+	rule.Registration.Name = "add-a-label"
+	rule.Payload.Additions.Labels = map[string]string{"team": "platform"}
+
+	var flunder unstructured.Unstructured
+	err := json.Unmarshal([]byte(`{
+		"apiVersion": "wardle.example.com/v1alpha1",
+		"kind": "Flunder",
+		"metadata": {"name": "my-flunder", "namespace": "default"},
+		"spec": {"reference": "my-fischer"}
+	}`), &flunder.Object)
+	require.NoError(t, err)
+
+	// sample-apiserver style aggregated api servers without openapi schemas can't server-side apply
+	unsupported := &errors.StatusError{ErrStatus: metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusUnsupportedMediaType,
+		Reason: metav1.StatusReasonUnsupportedMediaType,
+	}}
+	ri := mockDynamicResourceInterface{}
+	ri.On("Patch", "my-flunder", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, unsupported)
+	ri.On("Patch", "my-flunder", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.On("Namespace", "default").Return(&ri)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "wardle.example.com", Version: "v1alpha1", Resource: "flunders"}).Return(&nri)
+	dynamicClient = &dc
+
+	assert.True(t, applyToObject(&rule, "wardle.example.com/v1alpha1", "flunders", flunder), "the flunder should be json patched")
+	ri.AssertExpectations(t)
+}
+
+func TestResourcesThatCantBeListedAndPatchedAreSkipped(t *testing.T) {
+	var rule config.Rule
+	rule.Registration.Name = "add-a-label"
+	rule.Payload.Additions.Labels = map[string]string{"team": "platform"}
+
+	// the dynamic client has no expectations and so would fail the test if we tried to list pod metrics
+	dc := mockDynamicInterface{}
+	dynamicClient = &dc
+	podMetrics := metav1.APIResource{Name: "pods", Namespaced: true, Kind: "PodMetrics", Verbs: metav1.Verbs{"get", "list"}}
+	applyToAllResourcesOfType(&rule, "metrics.k8s.io/v1beta1", podMetrics)
+	dc.AssertExpectations(t)
+
+	assert.False(t, supportsVerbs(podMetrics, "list", "patch"), "pod metrics can't be patched")
+	assert.True(t, supportsVerbs(metav1.APIResource{Name: "flunders"}, "list", "patch"), "resources without verbs are assumed to support them")
+	assert.True(t, supportsVerbs(metav1.APIResource{Name: "flunders", Verbs: metav1.Verbs{"get", "list", "patch"}}, "list", "patch"))
+}
@@ -0,0 +1,127 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	jsonpatch "github.com/cameront/go-jsonpatch"
+	"github.com/pmezard/go-difflib/difflib"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// The ANSI colours of a coloured diff.
+const (
+	colourRemoved = "\x1b[31m"
+	colourAdded   = "\x1b[32m"
+	colourHunk    = "\x1b[36m"
+	colourReset   = "\x1b[0m"
+)
+
+// Change is what a rule would do to an existing object, reported instead of patching the object in a dry run.
+type Change struct {
+	Rule         string
+	GroupVersion string
+	Resource     string
+	Before       unstructured.Unstructured
+	After        unstructured.Unstructured
+}
+
+// dryRun receives the changes that rules would make when set, and the objects are then left alone.  dryRunMu
+// reports the changes found by the workers one at a time.
+var (
+	dryRun   func(Change)
+	dryRunMu sync.Mutex
+)
+
+// SetDryRun reports the changes that ApplyRulesAgainstExistingObjects would make to report, without changing the
+// objects, or patches them again when report is nil.  report is called with one change at a time.
+func SetDryRun(report func(Change)) {
+	dryRun = report
+}
+
+// reportChange applies a rule's patch to a copy of the object and reports the change.
+func reportChange(rule, gv, resource string, object unstructured.Unstructured, patch []byte) error {
+	var ops jsonpatch.Patch
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return fmt.Errorf("%w: failed to unmarshal patch: %v", graffiti.ErrPatchBuild, err)
+	}
+	after := object.DeepCopy()
+	if err := ops.Apply(&after.Object); err != nil {
+		return fmt.Errorf("%w: failed to apply patch: %v", graffiti.ErrPatchBuild, err)
+	}
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+	dryRun(Change{Rule: rule, GroupVersion: gv, Resource: resource, Before: object, After: *after})
+	return nil
+}
+
+// Name identifies the changed object like kubectl diff does, as <group-version>.<kind>.<namespace>.<name>.
+func (c Change) Name() string {
+	parts := []string{strings.Replace(c.GroupVersion, "/", ".", 1), c.Before.GetKind()}
+	if c.Before.GetNamespace() != "" {
+		parts = append(parts, c.Before.GetNamespace())
+	}
+	return strings.Join(append(parts, c.Before.GetName()), ".")
+}
+
+// Diff returns a unified diff of the object as yaml before and after the change, optionally coloured for a terminal.
+// The object's managed fields are left out as they would only be noise.
+func (c Change) Diff(colour bool) (string, error) {
+	before, err := diffableYAML(c.Before)
+	if err != nil {
+		return "", err
+	}
+	after, err := diffableYAML(c.After)
+	if err != nil {
+		return "", err
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(before),
+		B:        difflib.SplitLines(after),
+		FromFile: "a/" + c.Name(),
+		ToFile:   "b/" + c.Name(),
+		Context:  3,
+	})
+	if err != nil || !colour {
+		return diff, err
+	}
+	lines := strings.SplitAfter(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = colourHunk + strings.TrimSuffix(line, "\n") + colourReset + "\n"
+		case strings.HasPrefix(line, "-"):
+			lines[i] = colourRemoved + strings.TrimSuffix(line, "\n") + colourReset + "\n"
+		case strings.HasPrefix(line, "+"):
+			lines[i] = colourAdded + strings.TrimSuffix(line, "\n") + colourReset + "\n"
+		}
+	}
+	return strings.Join(lines, ""), nil
+}
+
+func diffableYAML(object unstructured.Unstructured) (string, error) {
+	o := object.DeepCopy()
+	unstructured.RemoveNestedField(o.Object, "metadata", "managedFields")
+	out, err := yaml.Marshal(o.Object)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal %s to yaml: %v", o.GetName(), err)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDryRunReportsTheChangeWithoutPatching(t *testing.T) {
+	var rule config.Rule
+	rule.Registration.Name = "add-a-label"
+	rule.Payload.Additions.Labels = map[string]string{"added": "by-graffiti"}
+	var object unstructured.Unstructured
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"apiVersion": "apps/v1",
+		"kind": "Deployment",
+		"metadata": {"name": "web", "namespace": "team-a", "labels": {"app": "web"},
+			"managedFields": [{"manager": "kubectl", "operation": "Update"}]}
+	}`), &object.Object))
+
+	var changes []Change
+	SetDryRun(func(c Change) { changes = append(changes, c) })
+	defer SetDryRun(nil)
+	// the dynamic client has no expectations and so would fail the test if we tried to patch
+	dc := mockDynamicInterface{}
+	dynamicClient = &dc
+
+	assert.False(t, applyToObject(&rule, "apps/v1", "deployments", object), "nothing is patched in a dry run")
+	dc.AssertExpectations(t)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "add-a-label", changes[0].Rule)
+	assert.Equal(t, "apps.v1.Deployment.team-a.web", changes[0].Name())
+	assert.Equal(t, "by-graffiti", changes[0].After.GetLabels()["added"])
+	assert.NotContains(t, object.GetLabels(), "added", "the listed object is left as it was")
+
+	diff, err := changes[0].Diff(false)
+	require.NoError(t, err)
+	assert.Equal(t, `--- a/apps.v1.Deployment.team-a.web
++++ b/apps.v1.Deployment.team-a.web
+@@ -2,6 +2,7 @@
+ kind: Deployment
+ metadata:
+   labels:
++    added: by-graffiti
+     app: web
+   name: web
+   namespace: team-a
+`, diff, "managed fields are left out of the diff")
+
+	coloured, err := changes[0].Diff(true)
+	require.NoError(t, err)
+	assert.Contains(t, coloured, colourAdded+"+    added: by-graffiti"+colourReset+"\n")
+}
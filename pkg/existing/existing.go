@@ -18,11 +18,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Telefonica/kube-graffiti/pkg/config"
-	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/Telefonica/kube-graffiti/pkg/events"
 	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
 	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -30,11 +38,12 @@ import (
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
 	componentName = "existing"
-	// itemLimit is used to constrain the number of items returned in a kubernetes List call.
+	// itemLimit is the default number of items returned in a kubernetes List call.
 	itemLimit = 100
 )
 
@@ -45,8 +54,23 @@ var (
 	discoveredResources = make(map[string][]metav1.APIResource)
 	dynamicClient       dynamic.Interface
 	nsCache             namespaceCache
+	// restConfig is kept for creating the clients that impersonate the service accounts of rules.
+	restConfig *rest.Config
+	// impersonatingClients are shared by periodic checks and the expiry controller, which can run at the same time.
+	impersonatingClients   = make(map[string]dynamic.Interface)
+	impersonatingClientsMu sync.Mutex
+	// stoppedObjects are the objects matched by stop-on-match rules, with the name of the rule that matched them.
+	stoppedObjects = make(map[types.UID]string)
+	// recorder records Events on the objects that are patched, or fail to be, nil records nothing.
+	recorder *events.Recorder
 )
 
+// SetEventRecorder sets the recorder of Events about the existing objects that rules patch.  It should only be set
+// when the objects are in the cluster that the recorder sends its events to.
+func SetEventRecorder(r *events.Recorder) {
+	recorder = r
+}
+
 // interface used to mock out the client-go discovery client for testing...
 type apiDiscoverer interface {
 	ServerGroups() (apiGroupList *metav1.APIGroupList, err error)
@@ -64,10 +88,13 @@ func InitKubeClients(rest *rest.Config) error {
 	if err != nil {
 		return fmt.Errorf("can't get a kubernetes discovery client: %v", err)
 	}
+	rest = rateLimited(rest)
 	dynamicClient, err = dynamic.NewForConfig(rest)
 	if err != nil {
 		return fmt.Errorf("can't get a kubernetes dynamic client: %v", err)
 	}
+	restConfig = rest
+	impersonatingClients = make(map[string]dynamic.Interface)
 	nsCache, err = NewNamespaceCache(rest)
 	if err != nil {
 		return fmt.Errorf("could not create the namespace cache: %v", err)
@@ -81,6 +108,10 @@ func InitKubeClients(rest *rest.Config) error {
 func discoverAPIsAndResources() error {
 	mylog := log.ComponentLogger(componentName, "discoverAPIsAndResources")
 
+	// forget anything that we discovered about another cluster
+	discoveredAPIGroups = make(map[string]metav1.APIGroup)
+	discoveredResources = make(map[string][]metav1.APIResource)
+
 	mylog.Debug().Msg("discovering kubernetes api groups")
 	sg, err := discoveryClient.ServerGroups()
 	if err != nil {
@@ -103,6 +134,81 @@ func discoverAPIsAndResources() error {
 	return nil
 }
 
+// patchingClient returns the dynamic client that patches objects for a rule.  It impersonates the rule's service
+// account when the rule has one, so that the apiserver's audit log and rbac apply to the rule's owner rather than to
+// kube-graffiti.
+func patchingClient(rule *config.Rule) (dynamic.Interface, error) {
+	user := rule.ImpersonatedUser()
+	if user == "" {
+		return dynamicClient, nil
+	}
+	impersonatingClientsMu.Lock()
+	defer impersonatingClientsMu.Unlock()
+	if client, ok := impersonatingClients[user]; ok {
+		return client, nil
+	}
+	client, err := newImpersonatingClient(user)
+	if err != nil {
+		return nil, fmt.Errorf("can't get a kubernetes dynamic client impersonating %s: %v", user, err)
+	}
+	impersonatingClients[user] = client
+	return client, nil
+}
+
+// newImpersonatingClient creates a dynamic client that impersonates a user, it is a variable so that tests can mock it.
+var newImpersonatingClient = func(user string) (dynamic.Interface, error) {
+	if restConfig == nil {
+		return nil, fmt.Errorf("the kubernetes clients have not been initialised")
+	}
+	rc := rest.CopyConfig(restConfig)
+	rc.Impersonate = rest.ImpersonationConfig{UserName: user}
+	return dynamic.NewForConfig(rc)
+}
+
+// RestConfigForContext loads the rest config for a context from a kubeconfig file.
+// When kubeconfig is empty the usual KUBECONFIG environment variable and ~/.kube/config locations are used.
+func RestConfigForContext(kubeconfig, context string) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		loadingRules.ExplicitPath = kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	r, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return egress.RestConfig(r)
+}
+
+// ApplyRulesAgainstContexts applies the rules to the existing objects in the cluster of each kubeconfig context in turn,
+// allowing a central kube-graffiti to backfill a fleet of clusters.  A failure to reach one cluster does not stop the
+// others from being checked, but is reported in the returned error.
+func ApplyRulesAgainstContexts(kubeconfig string, contexts []string, rules []config.Rule) error {
+	mylog := log.ComponentLogger(componentName, "ApplyRulesAgainstContexts")
+
+	var failed []string
+	for _, context := range contexts {
+		clog := mylog.With().Str("context", context).Logger()
+		clog.Info().Msg("checking existing objects in cluster")
+		rc, err := RestConfigForContext(kubeconfig, context)
+		if err != nil {
+			clog.Error().Err(err).Msg("failed to load kubeconfig context")
+			failed = append(failed, context)
+			continue
+		}
+		if err := InitKubeClients(rc); err != nil {
+			clog.Error().Err(err).Msg("failed to create kubernetes clients for context")
+			failed = append(failed, context)
+			continue
+		}
+		ApplyRulesAgainstExistingObjects(rules)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to check existing objects in contexts: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
 // ApplyRulesAgainstExistingObjects interates over the graffiti rules and targets, apply each rule to existing kubernetes objects.
 func ApplyRulesAgainstExistingObjects(rules []config.Rule) {
 	mylog := log.ComponentLogger(componentName, "ApplyRulesAgainstExistingObjects")
@@ -112,25 +218,55 @@ func ApplyRulesAgainstExistingObjects(rules []config.Rule) {
 	defer close(stop)
 	nsCache.StartNamespaceReflector(stop)
 	mylog.Info().Msg("checking existing objects against graffiti rules")
-	for _, rule := range rules {
+	// rules are applied in priority order so that stop-on-match rules skip the rules after them
+	ordered := append([]config.Rule{}, rules...)
+	config.SortRules(ordered)
+	stoppedObjects = make(map[types.UID]string)
+	started := time.Now()
+	for _, rule := range ordered {
+		if !waitForWindow(mylog) {
+			mylog.Info().Msg("the check of existing objects was stopped before it finished")
+			metrics.ExistingSweeps.WithLabelValues(metrics.ResultStopped).Inc()
+			return
+		}
 		ApplyRuleAgainstExistingObjects(rule)
 	}
+	metrics.ExistingSweeps.WithLabelValues(metrics.ResultCompleted).Inc()
+	metrics.ExistingSweepDuration.Set(time.Since(started).Seconds())
+	metrics.ExistingSweepLastCompleted.SetToCurrentTime()
 }
 
 // ApplyRuleAgainstExistingObjects checks a single graffiti rule against existing kubernetes objects
 func ApplyRuleAgainstExistingObjects(rule config.Rule) {
 	mylog := log.ComponentLogger(componentName, "ApplyRuleAgainstExistingObjects")
+	if rule.Registration.IsValidating() {
+		mylog.Debug().Str("rule", rule.Registration.Name).Msg("validating rules can not be applied to existing objects, skipping")
+		return
+	}
+	if !rule.Registration.HasOperation(admissionreg.Create) && !rule.Registration.HasOperation(admissionreg.Update) {
+		mylog.Debug().Str("rule", rule.Registration.Name).Msg("rules that don't create or update objects are not applied to existing objects, skipping")
+		return
+	}
 	mylog.Debug().Str("rule", rule.Registration.Name).Msg("applying rule to existing objects")
-	for _, target := range rule.Registration.Targets {
-		applyToTargetttedAPIGroupsAndVersions(&rule, target)
+	for _, target := range rule.Registration.AllTargets() {
+		for _, r := range targettedResources(&rule, target) {
+			applyToAllResourcesOfType(&rule, r.gv, r.resource)
+		}
 	}
 }
 
-// applyToTargetttedAPIGroupsAndVersions starts evaluating a target by getting a list of APIGroups which are listed.
+// targettedResource is a discovered type of resource that is covered by one of a rule's targets.
+type targettedResource struct {
+	gv       string
+	resource metav1.APIResource
+}
+
+// targettedResources starts evaluating a target by getting a list of APIGroups which are listed, and returns the
+// discovered resources in them that the target covers.
 // If the target APIGroups is ["*"] then we will check through *all* discoverd apigroups.
-func applyToTargetttedAPIGroupsAndVersions(rule *config.Rule, target webhook.Target) {
-	mylog := log.ComponentLogger(componentName, "applyToTargetttedAPIGroupsAndVersions")
-	rlog := mylog.With().Str("rule", rule.Registration.Name).Str("target-apigroups", strings.Join(target.APIGroups, ",")).Str("target-versions", strings.Join(target.APIVersions, ",")).Str("target-resources", strings.Join(target.Resources, ",")).Logger()
+func targettedResources(rule *config.Rule, target webhook.Target) []targettedResource {
+	mylog := log.ComponentLogger(componentName, "targettedResources")
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("target-apigroups", strings.Join(target.APIGroups, ",")).Str("target-versions", strings.Join(target.APIVersions, ",")).Str("target-resources", strings.Join(target.Resources, ",")).Logger()
 	rlog.Debug().Msg("evaluating target")
 
 	// handle wildcard '*'
@@ -146,13 +282,15 @@ func applyToTargetttedAPIGroupsAndVersions(rule *config.Rule, target webhook.Tar
 	}
 
 	// check each group/version is targetted and check
+	var resources []targettedResource
 	for _, g := range targetGroups {
 		if isTargetted(discoveredAPIGroups[g].PreferredVersion.Version, target.APIVersions) {
-			applyToAllResourcesInAGroupVersion(rule, target, discoveredAPIGroups[g].PreferredVersion)
+			resources = append(resources, resourcesInAGroupVersion(rule, target, discoveredAPIGroups[g].PreferredVersion)...)
 		} else {
 			rlog.Warn().Str("group", g).Str("preffered-version", discoveredAPIGroups[g].PreferredVersion.Version).Msg("targetted APIVersions do not match either wildcard or the preferred api version - therefore we will not use this rule to update existing objects for this group")
 		}
 	}
+	return resources
 }
 
 // isTargetted checks that an element is present in a target list or matches a wildcard '*'
@@ -165,20 +303,21 @@ func isTargetted(element string, targets []string) bool {
 	return false
 }
 
-// applyToAllResourcesInAGroupVersion checks all the resources in an group/version that are targetted.
-// If the target is ["*"] then all resources are checked, otherwise each discovered resource is
+// resourcesInAGroupVersion returns all the resources in an group/version that are targetted.
+// If the target is ["*"] then all resources are returned, otherwise each discovered resource is
 // checked against the target list.
-func applyToAllResourcesInAGroupVersion(rule *config.Rule, target webhook.Target, gv metav1.GroupVersionForDiscovery) {
-	mylog := log.ComponentLogger(componentName, "applyToAllResourcesInAGroupVersion")
-	rlog := mylog.With().Str("rule", rule.Registration.Name).Str("group-version", gv.GroupVersion).Str("version", gv.Version).Logger()
+func resourcesInAGroupVersion(rule *config.Rule, target webhook.Target, gv metav1.GroupVersionForDiscovery) []targettedResource {
+	mylog := log.ComponentLogger(componentName, "resourcesInAGroupVersion")
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("group-version", gv.GroupVersion).Str("version", gv.Version).Logger()
 	rlog.Debug().Msg("evaluating group version")
 
+	var resources []targettedResource
 	if len(target.Resources) == 1 && (target.Resources[0] == "*" || target.Resources[0] == "*/*") {
 		rlog.Debug().Msg("found target with Resources * wildcard")
 		for _, r := range discoveredResources[gv.GroupVersion] {
-			applyToAllResourcesOfType(rule, gv.GroupVersion, r)
+			resources = append(resources, targettedResource{gv: gv.GroupVersion, resource: r})
 		}
-		return
+		return resources
 	}
 
 	// create a list of resources without any subtypes
@@ -198,11 +337,12 @@ func applyToAllResourcesInAGroupVersion(rule *config.Rule, target webhook.Target
 		rlog.Debug().Str("resource", resource.Name).Msg("calling isTargetted on resource")
 		if isTargetted(resource.Name, resourceTargets) {
 			rlog.Debug().Str("resource", resource.Name).Msg("resorce is targetted")
-			applyToAllResourcesOfType(rule, gv.GroupVersion, resource)
+			resources = append(resources, targettedResource{gv: gv.GroupVersion, resource: resource})
 		} else {
 			rlog.Debug().Str("resource", resource.Name).Msg("resource is not targetted")
 		}
 	}
+	return resources
 }
 
 func splitSlashedResourceString(s string) (first, second string) {
@@ -221,13 +361,37 @@ func splitGroupVersionString(s string) (group, version string) {
 	return "", parts[0]
 }
 
+// objectVisitor is applied to each of the listed objects by a rule, and is true when it patched the object.
+type objectVisitor func(rule *config.Rule, gv, resource string, object unstructured.Unstructured) (patched bool)
+
 // applyToAllResourcesOfType checks all of the resources of particular group/version type.
-// It lists the resources in batches of itemLimit in order to preserve memory when there are
-// many kubernetes objects of the type in the cluster.
 func applyToAllResourcesOfType(rule *config.Rule, gv string, resource metav1.APIResource) {
 	mylog := log.ComponentLogger(componentName, "applyToAllResourcesOfType")
-	rlog := mylog.With().Str("rule", rule.Registration.Name).Str("group-version", gv).Str("resource", resource.Name).Logger()
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("group-version", gv).Str("resource", resource.Name).Logger()
+
+	// let the apiserver do as much of the filtering as it can
+	labelSelector, fieldSelector := rule.Matchers.ListSelectors()
+	if labelSelector != "" || fieldSelector != "" {
+		rlog.Debug().Str("label-selector", labelSelector).Str("field-selector", fieldSelector).Msg("pushing selectors down into the list call")
+	}
+	visitAllResourcesOfType(rule, gv, resource, metav1.ListOptions{LabelSelector: labelSelector, FieldSelector: fieldSelector}, applyToObject)
+}
+
+// visitAllResourcesOfType visits all of the resources of particular group/version type that the list options select.
+// It lists the resources in batches of pageSize in order to preserve memory when there are
+// many kubernetes objects of the type in the cluster.
+func visitAllResourcesOfType(rule *config.Rule, gv string, resource metav1.APIResource, listOptions metav1.ListOptions, visit objectVisitor) {
+	mylog := log.ComponentLogger(componentName, "visitAllResourcesOfType")
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("group-version", gv).Str("resource", resource.Name).Logger()
 	rlog.Debug().Msg("looking at resources of type")
+	if !objectFilter.includesResource(resource) {
+		rlog.Debug().Msg("resources of type are filtered out")
+		return
+	}
+	if !supportsVerbs(resource, "list", "patch") {
+		rlog.Debug().Strs("verbs", resource.Verbs).Msg("resources of type can't be listed and patched")
+		return
+	}
 
 	g, v := splitGroupVersionString(gv)
 	// get a dynamic client resource interface
@@ -236,29 +400,59 @@ func applyToAllResourcesOfType(rule *config.Rule, gv string, resource metav1.API
 		Version:  v,
 		Resource: resource.Name,
 	}
-	ri := dynamicClient.Resource(grv)
+	listOptions.Limit = pageSize
+	for _, ri := range objectFilter.resourceInterfaces(dynamicClient.Resource(grv), resource) {
+		visitListedObjects(rule, gv, resource.Name, ri, listOptions, visit)
+	}
+}
 
-	// get first list of items up to our limit
-	list, err := ri.List(metav1.ListOptions{Limit: itemLimit})
-	if err != nil {
-		rlog.Error().Err(err).Msg("failed to list resources")
-		return
+// supportsVerbs is true when the discovered resource supports all of the verbs.  Some aggregated api servers don't
+// publish the verbs of their resources, so a resource without any is assumed to support them.
+func supportsVerbs(resource metav1.APIResource, verbs ...string) bool {
+	if len(resource.Verbs) == 0 {
+		return true
 	}
-	if list == nil {
-		rlog.Debug().Msg("no resources found")
-		return
+	supported := make(map[string]bool, len(resource.Verbs))
+	for _, verb := range resource.Verbs {
+		supported[verb] = true
 	}
-	rlog.Debug().Int("number-resources", len(list.Items)).Msg("processing batch of resources")
-	for _, item := range list.Items {
-		_ = applyToObject(rule, gv, resource.Name, item)
+	for _, verb := range verbs {
+		if !supported[verb] {
+			return false
+		}
 	}
+	return true
+}
+
+// applyUnsupported is true when a server-side apply failed because the resource's api server doesn't support it,
+// which aggregated api servers without openapi schemas (or that predate apply) don't.
+func applyUnsupported(err error) bool {
+	return errors.IsUnsupportedMediaType(err) || errors.IsMethodNotSupported(err) || errors.IsBadRequest(err)
+}
+
+// visitListedObjects lists the objects of a resource type in batches and visits each of them.  Outside of the
+// maintenance windows it pauses before the next batch, and carries on from there when a window opens.
+func visitListedObjects(rule *config.Rule, gv, resource string, ri dynamic.ResourceInterface, listOptions metav1.ListOptions, visit objectVisitor) {
+	mylog := log.ComponentLogger(componentName, "visitListedObjects")
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("group-version", gv).Str("resource", resource).Logger()
+	p := newProgress(rlog, rule.Registration.Name)
+	defer p.done()
 
-	// if we only got a partial list we need to continue until we have seen them all
-	meta := list.Object["metadata"].(map[string]interface{})
-	for cont, ok := meta["continue"]; ok; {
-		list, err = ri.List(metav1.ListOptions{Limit: itemLimit, Continue: cont.(string)})
+	for {
+		if !waitForWindow(rlog) {
+			return
+		}
+		list, err := ri.List(listOptions)
+		if errors.IsResourceExpired(err) && listOptions.Continue != "" {
+			// a pause can outlast the continue token, so the objects are listed again from the start, those that have
+			// already been done are left unchanged by a second look.
+			rlog.Info().Msg("the list expired while the check was paused, listing the resources again")
+			listOptions.Continue = ""
+			continue
+		}
 		if err != nil {
 			rlog.Error().Err(err).Msg("failed to list resources")
+			metrics.ExistingListErrors.WithLabelValues(rule.Registration.Name, resource).Inc()
 			return
 		}
 		if list == nil {
@@ -266,23 +460,41 @@ func applyToAllResourcesOfType(rule *config.Rule, gv string, resource metav1.API
 			return
 		}
 		rlog.Debug().Int("number-resources", len(list.Items)).Msg("processing batch of resources")
-		for _, item := range list.Items {
-			applyToObject(rule, gv, resource.Name, item)
+		metrics.ExistingObjectsListed.WithLabelValues(rule.Registration.Name, resource).Add(float64(len(list.Items)))
+		checked, patched := visitBatch(rule, gv, resource, list.Items, visit)
+		p.add(checked, patched, list.GetRemainingItemCount())
+
+		// if we only got a partial list we need to continue until we have seen them all
+		if listOptions.Continue = list.GetContinue(); listOptions.Continue == "" {
+			return
 		}
-		meta = list.Object["metadata"].(map[string]interface{})
-		cont, ok = meta["continue"]
 	}
 }
 
+// isTerminatingNamespace is true when the object is itself a namespace which is being deleted.
+func isTerminatingNamespace(object unstructured.Unstructured) bool {
+	if object.GetKind() != "Namespace" {
+		return false
+	}
+	phase, _, _ := unstructured.NestedString(object.Object, "status", "phase")
+	return phase == string(corev1.NamespaceTerminating) || object.GetDeletionTimestamp() != nil
+}
+
 // applyToObject takes a single kubernete object and decides whether to graffiti it or not.
 func applyToObject(rule *config.Rule, gv, resource string, object unstructured.Unstructured) (patched bool) {
 	mylog := log.ComponentLogger(componentName, "applyToObject")
 	kind := object.GetKind()
 	name := object.GetName()
 	namespace := object.GetNamespace()
-	rlog := mylog.With().Str("rule", rule.Registration.Name).Str("group-version", gv).Str("kind", kind).Str("name", name).Str("namespace", namespace).Logger()
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("group-version", gv).Str("kind", kind).Str("name", name).Str("namespace", namespace).Logger()
 	rlog.Debug().Msg("checking object")
 
+	if ignore.Ignores(kind, namespace, name) {
+		rlog.Debug().Msg("skipping object because it is ignored")
+		metrics.SkippedObjects.WithLabelValues(rule.Registration.Name, metrics.ReasonIgnored).Inc()
+		return false
+	}
+
 	// match against optional rule namespace selector
 	if rule.Registration.NamespaceSelector != "" {
 		match, err := objectsNamespaceMatchesProvidedSelector(object.Object, rule.Registration.NamespaceSelector, nsCache)
@@ -295,27 +507,58 @@ func applyToObject(rule *config.Rule, gv, resource string, object unstructured.U
 		}
 	}
 
-	rlog.Info().Msg("applying graffiti mutate rule to existing object")
-	gr := graffiti.Rule{
-		Name:     rule.Registration.Name,
-		Matchers: rule.Matchers,
-		Payload:  rule.Payload,
+	// don't write to objects in, or that are, terminating namespaces
+	if nsCache.IsTerminating(namespace) || isTerminatingNamespace(object) {
+		rlog.Info().Msg("skipping object because its namespace is terminating")
+		metrics.SkippedObjects.WithLabelValues(rule.Registration.Name, metrics.ReasonNamespaceTerminating).Inc()
+		return false
+	}
+
+	if stoppedBy, ok := stoppingRule(object); ok && stoppedBy != rule.Registration.Name {
+		rlog.Info().Str("stopped-by", stoppedBy).Msg("skipping object because a rule before this one matched it")
+		metrics.SkippedObjects.WithLabelValues(rule.Registration.Name, metrics.ReasonStopOnMatch).Inc()
+		return false
 	}
+
+	rlog.Info().Msg("applying graffiti mutate rule to existing object")
+	// the object's namespace, and its quotas, are in the cluster being checked, which needn't be the one that the
+	// webhook serves
+	gr := rule.GraffitiRule().WithNamespaceLookup(nsCache).WithQuotaLookup(clusterQuotas{})
 	raw, err := json.Marshal(object.Object)
 	if err != nil {
 		rlog.Error().Err(err).Msg("could not marshal object")
+		metrics.ExistingObjectsFailed.WithLabelValues(rule.Registration.Name).Inc()
 		return false
 	}
+	if rule.StopOnMatch && object.GetUID() != "" {
+		if match, err := gr.Matches(raw, nil); err == nil && match {
+			stopObject(object, rule.Registration.Name)
+		}
+	}
 	// call the graffiti package to evaluation the graffiti rule...
 	patch, err := gr.Mutate(raw)
 	if err != nil {
 		rlog.Error().Err(err).Msg("could not mutate object")
+		recorder.Existing(rule.Registration.Name, object, nil, err)
+		metrics.ExistingObjectsFailed.WithLabelValues(rule.Registration.Name).Inc()
 		return false
 	}
 	if patch == nil {
 		rlog.Info().Msg("mutate did not create a patch")
 		return false
 	}
+	metrics.ExistingObjectsMatched.WithLabelValues(rule.Registration.Name).Inc()
+	if dryRun != nil {
+		if err := reportChange(rule.Registration.Name, gv, resource, object, patch); err != nil {
+			rlog.Error().Err(err).Msg("could not work out the change to the object")
+		}
+		return false
+	}
+	if !reservePatch() {
+		rlog.Warn().Int64("max-patches", atomic.LoadInt64(&maxPatches)).Msg("not patching object because the most objects that can be patched have been")
+		metrics.SkippedObjects.WithLabelValues(rule.Registration.Name, metrics.ReasonMaxPatches).Inc()
+		return false
+	}
 
 	rlog.Debug().Str("patch", string(patch)).Msg("mutate produced a patch")
 	g, v := splitGroupVersionString(gv)
@@ -324,17 +567,58 @@ func applyToObject(rule *config.Rule, gv, resource string, object unstructured.U
 		Version:  v,
 		Resource: resource,
 	}
-	ri := dynamicClient.Resource(grv)
-	if namespace == "" {
-		rlog.Debug().Msg("patching cluster level object")
-		_, err = ri.Patch(name, types.JSONPatchType, patch, metav1.PatchOptions{FieldManager: "kube-graffiti"})
-	} else {
-		rlog.Debug().Msg("patching namespaced object")
-		nri := ri.Namespace(namespace)
-		_, err = nri.Patch(name, types.JSONPatchType, patch, metav1.PatchOptions{FieldManager: "kube-graffiti"})
+	client, err := patchingClient(rule)
+	if err != nil {
+		rlog.Error().Err(err).Msg("can't patch object")
+		metrics.ExistingObjectsFailed.WithLabelValues(rule.Registration.Name).Inc()
+		return false
+	}
+	if user := rule.ImpersonatedUser(); user != "" {
+		rlog = rlog.With().Str("impersonate", user).Logger()
 	}
+	nri := client.Resource(grv)
+	var ri dynamic.ResourceInterface = nri
+	if namespace != "" {
+		ri = nri.Namespace(namespace)
+	}
+
+	// labels and annotations are server-side applied, so that the apiserver tracks that we own them, anything else
+	// has to be patched.
+	config, apply, err := applyConfiguration(object, patch)
+	if err != nil {
+		rlog.Error().Err(err).Msg("could not create an apply configuration from the patch")
+		recorder.Existing(rule.Registration.Name, object, patch, err)
+		metrics.ExistingObjectsFailed.WithLabelValues(rule.Registration.Name).Inc()
+		return false
+	}
+	if apply {
+		rlog.Debug().Str("apply", string(config)).Msg("applying object")
+		_, err = ri.Patch(name, types.ApplyPatchType, config, metav1.PatchOptions{FieldManager: fieldManager})
+		if errors.IsConflict(err) {
+			rlog.Warn().Err(err).Msg("not applying to object because its labels or annotations are owned by another field manager")
+			metrics.SkippedObjects.WithLabelValues(rule.Registration.Name, metrics.ReasonApplyConflict).Inc()
+			return false
+		}
+		if err == nil {
+			rlog.Info().Str("apply", string(config)).Msg("successfully applied object")
+			recorder.Existing(rule.Registration.Name, object, patch, nil)
+			return true
+		}
+		if !applyUnsupported(err) {
+			rlog.Error().Err(err).Msg("failed to apply object")
+			recorder.Existing(rule.Registration.Name, object, patch, err)
+			metrics.ExistingObjectsFailed.WithLabelValues(rule.Registration.Name).Inc()
+			return false
+		}
+		rlog.Warn().Err(err).Msg("resource doesn't support server-side apply, patching object instead")
+	}
+
+	rlog.Debug().Msg("patch can't be server-side applied, patching object")
+	_, err = ri.Patch(name, types.JSONPatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	recorder.Existing(rule.Registration.Name, object, patch, err)
 	if err != nil {
 		rlog.Error().Err(err).Msg("failed to patch object")
+		metrics.ExistingObjectsFailed.WithLabelValues(rule.Registration.Name).Inc()
 		return false
 	}
 	rlog.Info().Str("patch", string(patch)).Msg("successfully patched object")
@@ -17,9 +17,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -30,6 +38,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
 )
 
 // mockDiscoveryClient implements our apiDiscoverer interface and so allows testing of methods which call kube discovery api.
@@ -396,7 +405,7 @@ payload:
 	nri := mockDynamicNamespaceableResourceInterface{}
 	// because both mockDynamicNamespaceableResourceInterface and the embedded type mockDynamicResourceInterface both have On methods, we need to
 	// make sure that we correctly set On for the embedded type otherwise we end up calling it on the parent and then getting an unexpected call error.
-	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
 	dc := mockDynamicInterface{}
 	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
 	// set the package to use the mocked client
@@ -586,7 +595,7 @@ payload:
 
 	// set up the mock dynamic client to receive the expected patch request
 	ri := mockDynamicResourceInterface{}
-	ri.On("Patch", "nginx", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	ri.On("Patch", "nginx", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
 
 	nri := mockDynamicNamespaceableResourceInterface{}
 	nri.On("Namespace", "test-namespace").Return(&ri)
@@ -725,7 +734,7 @@ payload:
 
 	// set up the mock dynamic client to receive the expected patch request
 	ri := mockDynamicResourceInterface{}
-	ri.On("Patch", "nginx", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	ri.On("Patch", "nginx", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
 
 	nri := mockDynamicNamespaceableResourceInterface{}
 	nri.On("Namespace", "test-namespace").Return(&ri)
@@ -1037,7 +1046,7 @@ func TestTraverseKubePatchingAllNamespaces(t *testing.T) {
 	// because both mockDynamicNamespaceableResourceInterface and the embedded type mockDynamicResourceInterface both have On methods, we need to
 	// make sure that we correctly set On for the embedded type otherwise we end up calling it on the parent and then getting an unexpected call error.
 	nri.mockDynamicResourceInterface.On("List", mock.AnythingOfType("v1.ListOptions")).Return(ulns, nil)
-	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
 	dc := mockDynamicInterface{}
 	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
 	// set the package to use the mocked client
@@ -1048,6 +1057,54 @@ func TestTraverseKubePatchingAllNamespaces(t *testing.T) {
 	dc.AssertExpectations(t)
 }
 
+func TestTraversePushesMatcherSelectorsDownIntoList(t *testing.T) {
+	var rulesYaml = `---
+- registration:
+    name: add-a-label
+    targets:
+    - api-groups:
+      - ""
+      api-versions:
+      - "*"
+      resources:
+      - namespaces
+    failure-policy: Ignore
+  matchers:
+    label-selectors:
+    - "fruit=apple"
+    field-selectors:
+    - "metadata.name!=kube-system"
+  payload:
+    additions:
+      labels:
+        added: 'by-graffiti'
+`
+	var rules []config.Rule
+	err := yaml.Unmarshal([]byte(rulesYaml), &rules)
+	require.NoError(t, err, "yaml unmarshalling of rules should not fail")
+
+	discoveryClient = defaultTestDiscoveryClient(t)
+	err = discoverAPIsAndResources()
+	require.NoError(t, err, "we should not get an error loading in canned resource groups and resources")
+	nsCache = defaultTestNamespaceCache(t)
+
+	ulns := new(unstructured.UnstructuredList)
+	err = json.Unmarshal([]byte(unstructuredNamespaceListJSON), ulns)
+	require.NoError(t, err, "we should be able to unmarshal our canned namespace list into an UnstructuedList")
+
+	nri := mockDynamicNamespaceableResourceInterface{}
+	expectedOptions := metav1.ListOptions{Limit: itemLimit, LabelSelector: "fruit=apple", FieldSelector: "metadata.name!=kube-system"}
+	nri.mockDynamicResourceInterface.On("List", expectedOptions).Return(ulns, nil)
+	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	ApplyRulesAgainstExistingObjects(rules)
+	nri.AssertExpectations(t)
+	dc.AssertExpectations(t)
+}
+
 var unstructuredDeployListJSON = `{
 	"apiVersion":"apps/v1",
 	"items":[
@@ -1194,7 +1251,7 @@ func TestTraverseKubePatchingAllNamespacesWildcardsInRegistration(t *testing.T)
 	// because both mockDynamicNamespaceableResourceInterface and the embedded type mockDynamicResourceInterface both have On methods, we need to
 	// make sure that we correctly set On for the embedded type otherwise we end up calling it on the parent and then getting an unexpected call error.
 	nri.mockDynamicResourceInterface.On("List", mock.AnythingOfType("v1.ListOptions")).Return(ulns, nil)
-	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
 
 	// unstructured list of deployments
 	dl := new(unstructured.UnstructuredList)
@@ -1203,7 +1260,7 @@ func TestTraverseKubePatchingAllNamespacesWildcardsInRegistration(t *testing.T)
 
 	// set up the mock dynamic client to receive the expected patch request
 	dri := mockDynamicResourceInterface{}
-	dri.On("Patch", "nginx", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	dri.On("Patch", "nginx", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
 	dnri := mockDynamicNamespaceableResourceInterface{}
 	// List is called on the namespaceable interface but patch is called on the namespaced interface!
 	dnri.mockDynamicResourceInterface.On("List", mock.AnythingOfType("v1.ListOptions")).Return(dl, nil)
@@ -1211,11 +1268,7 @@ func TestTraverseKubePatchingAllNamespacesWildcardsInRegistration(t *testing.T)
 
 	dc := mockDynamicInterface{}
 	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
-	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces/finalize"}).Return(&nri)
-	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces/status"}).Return(&nri)
 	dc.On("Resource", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}).Return(&dnri)
-	dc.On("Resource", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments/scale"}).Return(&dnri)
-	dc.On("Resource", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments/status"}).Return(&dnri)
 	// set the package to use the mocked client
 	dynamicClient = &dc
 
@@ -1225,3 +1278,202 @@ func TestTraverseKubePatchingAllNamespacesWildcardsInRegistration(t *testing.T)
 	dnri.AssertExpectations(t)
 	dc.AssertExpectations(t)
 }
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: eu
+  cluster:
+    server: https://eu.example.com
+- name: us
+  cluster:
+    server: https://us.example.com
+users:
+- name: graffiti
+  user:
+    token: abc123
+contexts:
+- name: prod-eu
+  context:
+    cluster: eu
+    user: graffiti
+- name: prod-us
+  context:
+    cluster: us
+    user: graffiti
+current-context: prod-eu
+`
+
+func writeTestKubeconfig(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testKubeconfig), 0600))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestRestConfigForContextSelectsTheContextsCluster(t *testing.T) {
+	path, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	rc, err := RestConfigForContext(path, "prod-us")
+	require.NoError(t, err)
+	assert.Equal(t, "https://us.example.com", rc.Host)
+	assert.Equal(t, "abc123", rc.BearerToken)
+
+	rc, err = RestConfigForContext(path, "prod-eu")
+	require.NoError(t, err)
+	assert.Equal(t, "https://eu.example.com", rc.Host)
+}
+
+func TestApplyRulesAgainstContextsReportsUnknownContexts(t *testing.T) {
+	path, cleanup := writeTestKubeconfig(t)
+	defer cleanup()
+
+	err := ApplyRulesAgainstContexts(path, []string{"staging", "dev"}, []config.Rule{})
+	assert.EqualError(t, err, "failed to check existing objects in contexts: staging, dev")
+}
+
+func TestCheckRuleSkipsTerminatingNamespaces(t *testing.T) {
+	var ruleYaml = `---
+registration:
+  name: add-a-label
+  targets:
+  - api-groups:
+    - ""
+    api-versions:
+    - v1
+    resources:
+    - namespaces
+  failure-policy: Ignore
+payload:
+  additions:
+    labels:
+      added: 'by-graffiti'
+`
+	var rule config.Rule
+	err := yaml.Unmarshal([]byte(ruleYaml), &rule)
+	require.NoError(t, err, "yaml unmarshalling of rule should not fail")
+
+	var resourceObject unstructured.Unstructured
+	err = json.Unmarshal([]byte(`{
+		"apiVersion": "v1",
+		"kind": "Namespace",
+		"metadata": {"name": "going-away"},
+		"status": {"phase": "Terminating"}
+	}`), &resourceObject.Object)
+	require.NoError(t, err)
+
+	// the dynamic client has no expectations and so would fail the test if we tried to patch
+	dc := mockDynamicInterface{}
+	dynamicClient = &dc
+
+	result := applyToObject(&rule, "v1", "namespaces", resourceObject)
+	assert.False(t, result, "a terminating namespace should not be patched")
+	dc.AssertExpectations(t)
+}
+
+func TestCheckRuleSkipsIgnoredObjects(t *testing.T) {
+	var ruleYaml = `---
+registration:
+  name: add-a-label
+  targets:
+  - api-groups:
+    - ""
+    api-versions:
+    - v1
+    resources:
+    - namespaces
+  failure-policy: Ignore
+payload:
+  additions:
+    labels:
+      added: 'by-graffiti'
+`
+	var rule config.Rule
+	err := yaml.Unmarshal([]byte(ruleYaml), &rule)
+	require.NoError(t, err, "yaml unmarshalling of rule should not fail")
+
+	var resourceObject unstructured.Unstructured
+	err = json.Unmarshal([]byte(`{
+		"apiVersion": "v1",
+		"kind": "Namespace",
+		"metadata": {"name": "kube-system"},
+		"status": {"phase": "Active"}
+	}`), &resourceObject.Object)
+	require.NoError(t, err)
+
+	SetIgnore(graffiti.Ignore{Namespaces: []string{"kube-system"}})
+	t.Cleanup(func() { SetIgnore(graffiti.Ignore{}) })
+
+	// the dynamic client has no expectations and so would fail the test if we tried to patch
+	dc := mockDynamicInterface{}
+	dynamicClient = &dc
+
+	result := applyToObject(&rule, "v1", "namespaces", resourceObject)
+	assert.False(t, result, "an ignored namespace should not be patched")
+	dc.AssertExpectations(t)
+}
+
+func TestRulesPatchAsTheirImpersonatedServiceAccount(t *testing.T) {
+	impersonated := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		impersonated <- r.Header.Get("Impersonate-User")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"settings","namespace":"default"}}`)
+	}))
+	defer srv.Close()
+	restConfig = &rest.Config{Host: srv.URL}
+	defer func() { restConfig = nil }()
+	impersonatingClients = make(map[string]dynamic.Interface)
+
+	var rule config.Rule
+	rule.Registration.Name = "add-a-label"
+	rule.Impersonate = "team-a/graffiti"
+	rule.Payload.Additions.Labels = map[string]string{"added": "by-graffiti"}
+	var object unstructured.Unstructured
+	require.NoError(t, json.Unmarshal([]byte(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"settings","namespace":"default"}}`), &object.Object))
+
+	assert.True(t, applyToObject(&rule, "v1", "configmaps", object))
+	assert.Equal(t, "system:serviceaccount:team-a:graffiti", <-impersonated)
+
+	client, err := patchingClient(&rule)
+	require.NoError(t, err)
+	assert.Equal(t, impersonatingClients["system:serviceaccount:team-a:graffiti"], client, "clients are reused for each service account")
+
+	rule.Impersonate = ""
+	client, err = patchingClient(&rule)
+	require.NoError(t, err)
+	assert.Equal(t, dynamicClient, client, "rules without a service account use kube-graffiti's own client")
+}
+
+func TestStopOnMatchRuleSkipsTheRulesAfterIt(t *testing.T) {
+	var resourceObject unstructured.Unstructured
+	err := json.Unmarshal([]byte(`{
+		"apiVersion": "v1",
+		"kind": "Namespace",
+		"metadata": {"name": "test-namespace", "uid": "b8337c4c-b4dc-11e8-990c-08002722bfc3", "labels": {"fruit": "apple"}}
+	}`), &resourceObject.Object)
+	require.NoError(t, err)
+
+	target := webhook.Target{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces"}}
+	first := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("apples").MatchLabels("fruit=apple").WithPriority(10).StoppingOnMatch().AddLabels(map[string]string{"apples": "true"}))
+	second := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("everything").AddLabels(map[string]string{"painted": "true"}))
+
+	// only the first rule is expected to patch the namespace
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil).Once()
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	stoppedObjects = make(map[types.UID]string)
+	rules := []config.Rule{second, first}
+	config.SortRules(rules)
+	require.Equal(t, "apples", rules[0].Registration.Name)
+	assert.True(t, applyToObject(&rules[0], "v1", "namespaces", resourceObject))
+	assert.False(t, applyToObject(&rules[1], "v1", "namespaces", resourceObject), "the rule after a matching stop-on-match rule should be skipped")
+	nri.AssertExpectations(t)
+}
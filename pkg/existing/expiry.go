@@ -0,0 +1,213 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// RunExpiryController deletes, or labels, the expired objects of the rules with an expire payload every interval
+// until stop is closed.  The kubernetes clients must have been set up with InitKubeClients.
+func RunExpiryController(rules []config.Rule, interval time.Duration, stop <-chan struct{}) {
+	mylog := log.ComponentLogger(componentName, "RunExpiryController")
+	var expiring []config.Rule
+	for _, rule := range rules {
+		if rule.Payload.Expire.TTL > 0 && !rule.Registration.IsValidating() {
+			expiring = append(expiring, rule)
+		}
+	}
+	if len(expiring) == 0 {
+		mylog.Info().Msg("no rules have an expire payload, not starting the expiry controller")
+		return
+	}
+
+	nsCache.StartNamespaceReflector(stop)
+	mylog.Info().Int("rules", len(expiring)).Dur("interval", interval).Msg("starting the expiry controller")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			mylog.Info().Msg("stopping the expiry controller")
+			return
+		case <-ticker.C:
+			ExpireObjects(expiring, time.Now())
+		}
+	}
+}
+
+// ExpireObjects deletes, or labels, the objects of the rules whose expires-at label has passed and that the rule still
+// matches.  Objects that no longer match a rule are left alone, even when they have expired.
+func ExpireObjects(rules []config.Rule, now time.Time) {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Payload.Expire.TTL == 0 {
+			continue
+		}
+		gr := rule.GraffitiRule().Compiled()
+		for _, target := range rule.Registration.AllTargets() {
+			for _, r := range targettedResources(rule, target) {
+				expireResourcesOfType(rule, gr, r, now)
+			}
+		}
+	}
+}
+
+// expireResourcesOfType lists the objects of a resource type which have an expiry time in batches and expires them.
+func expireResourcesOfType(rule *config.Rule, gr graffiti.Rule, r targettedResource, now time.Time) {
+	mylog := log.ComponentLogger(componentName, "expireResourcesOfType")
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("group-version", r.gv).Str("resource", r.resource.Name).Logger()
+	verb := "delete"
+	if gr.Payload.Expire.Action == graffiti.ExpireActionLabel {
+		verb = "patch"
+	}
+	if !supportsVerbs(r.resource, "list", verb) {
+		rlog.Debug().Strs("verbs", r.resource.Verbs).Str("verb", verb).Msg("resources of type can't be expired")
+		return
+	}
+	if !objectFilter.includesResource(r.resource) {
+		rlog.Debug().Msg("resources of type are outside of the watched namespaces")
+		return
+	}
+
+	g, v := splitGroupVersionString(r.gv)
+	ri := dynamicClient.Resource(schema.GroupVersionResource{Group: g, Version: v, Resource: r.resource.Name})
+	labelSelector, fieldSelector := rule.Matchers.ListSelectors()
+	if labelSelector != "" {
+		labelSelector = "," + labelSelector
+	}
+	listOptions := metav1.ListOptions{Limit: pageSize, LabelSelector: gr.Payload.ExpiryLabel() + labelSelector, FieldSelector: fieldSelector}
+	for _, ri := range objectFilter.resourceInterfaces(ri, r.resource) {
+		expireListedObjects(rule, gr, r, ri, listOptions, now, rlog)
+	}
+}
+
+// expireListedObjects lists the objects with an expiry time through a resource interface in batches and expires them.
+func expireListedObjects(rule *config.Rule, gr graffiti.Rule, r targettedResource, ri dynamic.ResourceInterface, listOptions metav1.ListOptions, now time.Time, rlog zerolog.Logger) {
+	for {
+		list, err := ri.List(listOptions)
+		if err != nil {
+			rlog.Error().Err(err).Msg("failed to list resources")
+			return
+		}
+		for _, item := range list.Items {
+			if objectFilter.includesObject(item) {
+				expireObject(rule, gr, r, item, now, rlog)
+			}
+		}
+		if list.GetContinue() == "" {
+			return
+		}
+		listOptions.Continue = list.GetContinue()
+	}
+}
+
+// expireObject deletes, or labels, a single object when it has expired and still matches the rule.
+func expireObject(rule *config.Rule, gr graffiti.Rule, r targettedResource, object unstructured.Unstructured, now time.Time, logger zerolog.Logger) {
+	rlog := logger.With().Str("name", object.GetName()).Str("namespace", object.GetNamespace()).Logger()
+	if !gr.Payload.Expired(object.GetLabels(), now) || object.GetDeletionTimestamp() != nil {
+		return
+	}
+	if ignore.Ignores(object.GetKind(), object.GetNamespace(), object.GetName()) {
+		rlog.Debug().Msg("expired object is ignored, leaving it alone")
+		return
+	}
+	if rule.Registration.NamespaceSelector != "" {
+		match, err := objectsNamespaceMatchesProvidedSelector(object.Object, rule.Registration.NamespaceSelector, nsCache)
+		if err != nil {
+			rlog.Error().Err(err).Msg("error checking object against namespace selector")
+		}
+		if !match {
+			return
+		}
+	}
+	raw, err := json.Marshal(object.Object)
+	if err != nil {
+		rlog.Error().Err(err).Msg("could not marshal object")
+		return
+	}
+	match, err := gr.Matches(raw, nil)
+	if err != nil {
+		rlog.Error().Err(err).Msg("could not match object")
+		return
+	}
+	if !match {
+		rlog.Debug().Msg("expired object no longer matches the rule, leaving it alone")
+		return
+	}
+
+	client, err := patchingClient(rule)
+	if err != nil {
+		rlog.Error().Err(err).Msg("can't expire object")
+		return
+	}
+	g, v := splitGroupVersionString(r.gv)
+	nri := client.Resource(schema.GroupVersionResource{Group: g, Version: v, Resource: r.resource.Name})
+	var ri dynamic.ResourceInterface = nri
+	if object.GetNamespace() != "" {
+		ri = nri.Namespace(object.GetNamespace())
+	}
+
+	expire := gr.Payload.Expire
+	if expire.Action != graffiti.ExpireActionLabel {
+		// only delete the object that we matched, not another that has since replaced it
+		uid := object.GetUID()
+		err = ri.Delete(object.GetName(), &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}})
+		if err != nil && !errors.IsNotFound(err) {
+			rlog.Error().Err(err).Msg("failed to delete expired object")
+			return
+		}
+		rlog.Info().Msg("deleted expired object")
+		metrics.ExpiredObjects.WithLabelValues(rule.Registration.Name, graffiti.ExpireActionDelete).Inc()
+		return
+	}
+
+	if hasLabels(object.GetLabels(), expire.Labels) {
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": map[string]interface{}{"labels": expire.Labels}})
+	if err != nil {
+		rlog.Error().Err(err).Msg("could not create the expired labels patch")
+		return
+	}
+	if _, err = ri.Patch(object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager}); err != nil {
+		rlog.Error().Err(err).Msg("failed to label expired object")
+		return
+	}
+	rlog.Info().Str("patch", string(patch)).Msg("labelled expired object")
+	metrics.ExpiredObjects.WithLabelValues(rule.Registration.Name, graffiti.ExpireActionLabel).Inc()
+}
+
+// hasLabels is true when all of the wanted labels are already set.
+func hasLabels(labels, wanted map[string]string) bool {
+	for k, v := range wanted {
+		if current, ok := labels[k]; !ok || current != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func expiringNamespace(t *testing.T, name string, expiresAt time.Time) unstructured.Unstructured {
+	var object unstructured.Unstructured
+	err := json.Unmarshal([]byte(fmt.Sprintf(`{
+		"apiVersion": "v1",
+		"kind": "Namespace",
+		"metadata": {"name": "%s", "uid": "%s-uid", "labels": {"purpose": "debug", "graffiti/expires-at": "%d"}}
+	}`, name, name, expiresAt.Unix())), &object.Object)
+	require.NoError(t, err)
+	return object
+}
+
+func expiringRule(expire graffiti.Expiry) config.Rule {
+	target := webhook.Target{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces"}}
+	rule := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("debug-namespaces").MatchLabels("purpose=debug"))
+	rule.Payload.Expire = expire
+	return rule
+}
+
+func TestExpiredObjectsThatStillMatchAreDeleted(t *testing.T) {
+	now := time.Now()
+	rule := expiringRule(graffiti.Expiry{TTL: time.Hour})
+	namespaces := targettedResource{gv: "v1", resource: metav1.APIResource{Name: "namespaces", Kind: "Namespace"}}
+
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}}
+	list.Items = []unstructured.Unstructured{expiringNamespace(t, "expired", now.Add(-time.Minute)), expiringNamespace(t, "alive", now.Add(time.Minute))}
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("List", metav1.ListOptions{Limit: itemLimit, LabelSelector: "graffiti/expires-at,purpose=debug"}).Return(list, nil)
+	nri.mockDynamicResourceInterface.On("Delete", "expired", mock.MatchedBy(func(o *metav1.DeleteOptions) bool {
+		return o.Preconditions != nil && *o.Preconditions.UID == "expired-uid"
+	}), mock.Anything).Return(nil).Once()
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	expireResourcesOfType(&rule, rule.GraffitiRule(), namespaces, now)
+	nri.AssertExpectations(t)
+}
+
+func TestExpiredObjectsCanBeLabelledInstead(t *testing.T) {
+	now := time.Now()
+	rule := expiringRule(graffiti.Expiry{TTL: time.Hour, Action: graffiti.ExpireActionLabel, Labels: map[string]string{"expired": "true"}})
+	namespaces := targettedResource{gv: "v1", resource: metav1.APIResource{Name: "namespaces", Kind: "Namespace"}}
+	expired := expiringNamespace(t, "expired", now.Add(-time.Minute))
+
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("Patch", "expired", types.MergePatchType, []byte(`{"metadata":{"labels":{"expired":"true"}}}`), mock.AnythingOfType("[]string")).Return(nil, nil).Once()
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	expireObject(&rule, rule.GraffitiRule(), namespaces, expired, now, zerolog.Nop())
+	expired.SetLabels(map[string]string{"purpose": "debug", "graffiti/expires-at": expired.GetLabels()["graffiti/expires-at"], "expired": "true"})
+	expireObject(&rule, rule.GraffitiRule(), namespaces, expired, now, zerolog.Nop())
+	nri.AssertExpectations(t)
+}
+
+func TestExpiredObjectsThatNoLongerMatchAreLeftAlone(t *testing.T) {
+	now := time.Now()
+	rule := expiringRule(graffiti.Expiry{TTL: time.Hour})
+	namespaces := targettedResource{gv: "v1", resource: metav1.APIResource{Name: "namespaces", Kind: "Namespace"}}
+	expired := expiringNamespace(t, "expired", now.Add(-time.Minute))
+	expired.SetLabels(map[string]string{"purpose": "production", "graffiti/expires-at": expired.GetLabels()["graffiti/expires-at"]})
+
+	require.True(t, rule.GraffitiRule().Payload.Expired(expired.GetLabels(), now))
+	assert.False(t, hasLabels(expired.GetLabels(), map[string]string{"purpose": "debug"}))
+
+	// the dynamic client has no expectations and so would fail the test if we tried to delete the namespace
+	dc := mockDynamicInterface{}
+	dynamicClient = &dc
+	expireObject(&rule, rule.GraffitiRule(), namespaces, expired, now, zerolog.Nop())
+	dc.AssertExpectations(t)
+}
+
+func TestExpiredObjectsThatAreIgnoredAreLeftAlone(t *testing.T) {
+	now := time.Now()
+	rule := expiringRule(graffiti.Expiry{TTL: time.Hour})
+	namespaces := targettedResource{gv: "v1", resource: metav1.APIResource{Name: "namespaces", Kind: "Namespace"}}
+	expired := expiringNamespace(t, "kube-system", now.Add(-time.Minute))
+
+	SetIgnore(graffiti.Ignore{Namespaces: []string{"kube-system"}})
+	t.Cleanup(func() { SetIgnore(graffiti.Ignore{}) })
+
+	// the dynamic client has no expectations and so would fail the test if we tried to delete the namespace
+	dc := mockDynamicInterface{}
+	dynamicClient = &dc
+	expireObject(&rule, rule.GraffitiRule(), namespaces, expired, now, zerolog.Nop())
+	dc.AssertExpectations(t)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// Filter narrows the existing objects that rules are applied to, for targeted backfills.  An empty filter includes
+// every object that the rules target.
+type Filter struct {
+	// Namespaces limits the objects to those in the namespaces, along with the namespaces themselves.
+	Namespaces []string
+	// Kinds limits the objects to those whose resource, singular name, short name or kind is listed, e.g. pods.
+	Kinds []string
+	// none is true when the filter can't include any objects, because none of its namespaces are watched.
+	none bool
+}
+
+var (
+	// objectFilter is the filter applied when checking existing objects, the requested filter within the watched
+	// namespaces.
+	objectFilter    Filter
+	requestedFilter Filter
+	watchNamespaces []string
+)
+
+// SetFilter sets the filter applied to existing objects by ApplyRulesAgainstExistingObjects.
+func SetFilter(f Filter) {
+	requestedFilter = f
+	objectFilter = f.within(watchNamespaces)
+}
+
+// SetWatchNamespaces limits the existing objects that rules are applied to, and that are expired, to those in the
+// namespaces and the namespaces themselves, whatever the filter.  Nil doesn't limit them.
+func SetWatchNamespaces(namespaces []string) {
+	watchNamespaces = namespaces
+	objectFilter = requestedFilter.within(namespaces)
+}
+
+// within narrows the filter's namespaces down to the watched namespaces, when there are any.
+func (f Filter) within(watched []string) Filter {
+	if len(watched) == 0 {
+		return f
+	}
+	if len(f.Namespaces) == 0 {
+		f.Namespaces = watched
+		return f
+	}
+	var namespaces []string
+	for _, ns := range f.Namespaces {
+		if isTargetted(ns, watched) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	f.Namespaces = namespaces
+	f.none = len(namespaces) == 0
+	return f
+}
+
+// ignore lists the namespaces and names of the objects that are never changed, whatever the rules match.
+var ignore graffiti.Ignore
+
+// SetIgnore sets the namespaces and names of the objects that ApplyRulesAgainstExistingObjects, and the expiry
+// controller, leave alone.  It must be called before either of them is started.
+func SetIgnore(i graffiti.Ignore) {
+	ignore = i
+}
+
+// includesResource is true when objects of the resource type can be included by the filter.
+func (f Filter) includesResource(resource metav1.APIResource) bool {
+	if f.none {
+		return false
+	}
+	if len(f.Namespaces) > 0 && !resource.Namespaced && resource.Name != "namespaces" {
+		return false
+	}
+	if len(f.Kinds) == 0 {
+		return true
+	}
+	names := append([]string{resource.Name, resource.SingularName, resource.Kind}, resource.ShortNames...)
+	for _, kind := range f.Kinds {
+		for _, name := range names {
+			if name != "" && strings.EqualFold(kind, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// includesObject is true when the filter includes a listed object.  Namespaced objects are listed per namespace, so
+// only the namespaces themselves need to be checked.
+func (f Filter) includesObject(object unstructured.Unstructured) bool {
+	if len(f.Namespaces) == 0 || object.GetKind() != "Namespace" {
+		return true
+	}
+	return isTargetted(object.GetName(), f.Namespaces)
+}
+
+// resourceInterfaces are the interfaces that the objects of a resource type are listed through, one for each of the
+// filter's namespaces when the resources are namespaced.
+func (f Filter) resourceInterfaces(ri dynamic.NamespaceableResourceInterface, resource metav1.APIResource) []dynamic.ResourceInterface {
+	if len(f.Namespaces) == 0 || !resource.Namespaced {
+		return []dynamic.ResourceInterface{ri}
+	}
+	var interfaces []dynamic.ResourceInterface
+	for _, ns := range f.Namespaces {
+		interfaces = append(interfaces, ri.Namespace(ns))
+	}
+	return interfaces
+}
@@ -0,0 +1,120 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestFilterIncludesResources(t *testing.T) {
+	pods := metav1.APIResource{Name: "pods", SingularName: "pod", Kind: "Pod", ShortNames: []string{"po"}, Namespaced: true}
+	namespaces := metav1.APIResource{Name: "namespaces", Kind: "Namespace"}
+	nodes := metav1.APIResource{Name: "nodes", Kind: "Node"}
+
+	assert.True(t, Filter{}.includesResource(nodes), "an empty filter includes everything")
+	for _, kind := range []string{"pods", "pod", "Pod", "po"} {
+		assert.True(t, Filter{Kinds: []string{"deployments", kind}}.includesResource(pods), kind)
+	}
+	assert.False(t, Filter{Kinds: []string{"deployments"}}.includesResource(pods))
+
+	inTeamA := Filter{Namespaces: []string{"team-a"}}
+	assert.True(t, inTeamA.includesResource(pods))
+	assert.True(t, inTeamA.includesResource(namespaces))
+	assert.False(t, inTeamA.includesResource(nodes), "cluster level objects are not in a namespace")
+
+	teamA := unstructured.Unstructured{Object: map[string]interface{}{"kind": "Namespace", "metadata": map[string]interface{}{"name": "team-a"}}}
+	teamB := unstructured.Unstructured{Object: map[string]interface{}{"kind": "Namespace", "metadata": map[string]interface{}{"name": "team-b"}}}
+	assert.True(t, inTeamA.includesObject(teamA))
+	assert.False(t, inTeamA.includesObject(teamB))
+}
+
+func TestTraverseOnlyListsFilteredNamespacesAndKinds(t *testing.T) {
+	var rulesYaml = `---
+- registration:
+    name: add-a-label
+    targets:
+    - api-groups:
+      - "*"
+      api-versions:
+      - "*"
+      resources:
+      - "*"
+    failure-policy: Ignore
+  matchers:
+    label-selectors:
+    - "fruit=apple"
+  payload:
+    additions:
+      labels:
+        added: 'by-graffiti'
+`
+	var rules []config.Rule
+	require.NoError(t, yaml.Unmarshal([]byte(rulesYaml), &rules))
+
+	discoveryClient = defaultTestDiscoveryClient(t)
+	require.NoError(t, discoverAPIsAndResources())
+	nsCache = defaultTestNamespaceCache(t)
+
+	dl := new(unstructured.UnstructuredList)
+	require.NoError(t, json.Unmarshal([]byte(unstructuredDeployListJSON), dl))
+
+	// deployments are only listed, and patched, in the filtered namespace and namespaces aren't looked at at all.
+	dri := mockDynamicResourceInterface{}
+	dri.On("List", mock.AnythingOfType("v1.ListOptions")).Return(dl, nil)
+	dri.On("Patch", "nginx", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	dnri := mockDynamicNamespaceableResourceInterface{}
+	dnri.On("Namespace", "test-namespace").Return(&dri)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}).Return(&dnri)
+	dynamicClient = &dc
+
+	SetFilter(Filter{Namespaces: []string{"test-namespace"}, Kinds: []string{"deployments"}})
+	defer SetFilter(Filter{})
+	ApplyRulesAgainstExistingObjects(rules)
+	dri.AssertExpectations(t)
+	dnri.AssertExpectations(t)
+	dc.AssertExpectations(t)
+}
+
+func TestFilterIsNarrowedToTheWatchedNamespaces(t *testing.T) {
+	pods := metav1.APIResource{Name: "pods", Namespaced: true}
+	nodes := metav1.APIResource{Name: "nodes", Kind: "Node"}
+
+	watched := Filter{Kinds: []string{"pods"}}.within([]string{"team-a", "team-b"})
+	assert.Equal(t, []string{"team-a", "team-b"}, watched.Namespaces)
+	assert.Equal(t, []string{"pods"}, watched.Kinds)
+	assert.False(t, Filter{}.within([]string{"team-a"}).includesResource(nodes), "cluster level objects are not watched")
+
+	assert.Equal(t, []string{"team-b"}, Filter{Namespaces: []string{"team-b", "team-c"}}.within([]string{"team-a", "team-b"}).Namespaces)
+	assert.False(t, Filter{Namespaces: []string{"team-c"}}.within([]string{"team-a"}).includesResource(pods), "a filter of only unwatched namespaces includes nothing")
+	assert.Equal(t, Filter{Namespaces: []string{"team-c"}}, Filter{Namespaces: []string{"team-c"}}.within(nil))
+
+	SetWatchNamespaces([]string{"team-a"})
+	SetFilter(Filter{Kinds: []string{"pods"}})
+	assert.Equal(t, []string{"team-a"}, objectFilter.Namespaces, "the filter is narrowed whichever is set first")
+	SetWatchNamespaces(nil)
+	SetFilter(Filter{})
+	assert.Equal(t, Filter{}, objectFilter)
+}
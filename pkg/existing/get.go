@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GetObject fetches a single existing object, so that the rules can be explained against it.  The kind is the
+// resource, singular name, short name or kind of a discovered resource, e.g. pods, pod, po or Pod, which is looked up
+// in the preferred version of each api group, core first.  The namespace is ignored for cluster scoped resources.
+// InitKubeClients must be called first.
+func GetObject(kind, namespace, name string) (*unstructured.Unstructured, error) {
+	gv, resource, err := findResource(kind)
+	if err != nil {
+		return nil, err
+	}
+	g, v := splitGroupVersionString(gv)
+	ri := dynamicClient.Resource(schema.GroupVersionResource{Group: g, Version: v, Resource: resource.Name})
+	if resource.Namespaced {
+		if namespace == "" {
+			namespace = "default"
+		}
+		return ri.Namespace(namespace).Get(name, metav1.GetOptions{})
+	}
+	return ri.Get(name, metav1.GetOptions{})
+}
+
+// findResource returns the group version and the discovered resource that a kind names.
+func findResource(kind string) (string, metav1.APIResource, error) {
+	var groups []string
+	for name := range discoveredAPIGroups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	byKind := Filter{Kinds: []string{kind}}
+	for _, group := range groups {
+		gv := discoveredAPIGroups[group].PreferredVersion.GroupVersion
+		for _, resource := range discoveredResources[gv] {
+			if strings.Contains(resource.Name, "/") || !supportsVerbs(resource, "get") {
+				continue
+			}
+			if byKind.includesResource(resource) {
+				return gv, resource, nil
+			}
+		}
+	}
+	return "", metav1.APIResource{}, fmt.Errorf("the server doesn't have a resource type '%s'", kind)
+}
@@ -0,0 +1,64 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestFindResourceLooksUpAnyNameOfTheResource(t *testing.T) {
+	discoveryClient = defaultTestDiscoveryClient(t)
+	require.NoError(t, discoverAPIsAndResources())
+
+	for _, kind := range []string{"deployments", "Deployment", "deploy"} {
+		gv, resource, err := findResource(kind)
+		require.NoError(t, err, kind)
+		assert.Equal(t, "apps/v1", gv, kind)
+		assert.Equal(t, "deployments", resource.Name, kind)
+	}
+	gv, resource, err := findResource("ns")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", gv)
+	assert.Equal(t, "namespaces", resource.Name, "subresources of the same kind are skipped")
+
+	_, _, err = findResource("widgets")
+	assert.EqualError(t, err, "the server doesn't have a resource type 'widgets'")
+}
+
+func TestGetObjectGetsNamespacedObjectsFromTheirNamespace(t *testing.T) {
+	discoveryClient = defaultTestDiscoveryClient(t)
+	require.NoError(t, discoverAPIsAndResources())
+
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{"kind": "Deployment"}}
+	ri := mockDynamicResourceInterface{}
+	ri.On("Get", "nginx", metav1.GetOptions{}, mock.Anything).Return(deployment, nil)
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.On("Namespace", "team-a").Return(&ri)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}).Return(&nri)
+	dynamicClient = &dc
+
+	object, err := GetObject("deploy", "team-a", "nginx")
+	require.NoError(t, err)
+	assert.Equal(t, deployment, object)
+	ri.AssertExpectations(t)
+	nri.AssertExpectations(t)
+}
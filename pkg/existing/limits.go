@@ -0,0 +1,169 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/rs/zerolog"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	// pageSize is the number of objects fetched by each list call, and workers the number checked at once.
+	pageSize int64 = itemLimit
+	workers        = 1
+	// qps and burst rate limit the calls of the dynamic clients, 0 leaves client-go's defaults.
+	qps   float32
+	burst int
+	// progressInterval is how often the progress through a resource's objects is logged.
+	progressInterval time.Duration
+	// maxPatches is the most objects that are patched, 0 is no limit, and patches counts those that have been, or
+	// have been tried.
+	maxPatches int64
+	patches    int64
+)
+
+// SetLimits sets the page size, number of workers, rate limits and progress interval of the checks of existing
+// objects.  Values that are 0 keep their defaults.  It must be called before InitKubeClients, which creates the
+// clients with its rate limits.
+func SetLimits(e config.Existing) {
+	pageSize = itemLimit
+	if e.PageSize > 0 {
+		pageSize = e.PageSize
+	}
+	workers = 1
+	if e.Workers > 0 {
+		workers = e.Workers
+	}
+	qps = e.QPS
+	burst = e.Burst
+	progressInterval = e.ProgressInterval
+}
+
+// SetMaxPatches stops the checks of existing objects from patching more than n objects from now on, whatever the
+// rules match, so that a bad matcher can't change a whole cluster.  0 is no limit.
+func SetMaxPatches(n int) {
+	atomic.StoreInt64(&maxPatches, int64(n))
+	atomic.StoreInt64(&patches, 0)
+}
+
+// reservePatch is true when another object can be patched, and counts it.
+func reservePatch() bool {
+	max := atomic.LoadInt64(&maxPatches)
+	return max == 0 || atomic.AddInt64(&patches, 1) <= max
+}
+
+// rateLimited returns a copy of the rest config with our rate limits.
+func rateLimited(rc *rest.Config) *rest.Config {
+	rc = rest.CopyConfig(rc)
+	if qps > 0 {
+		rc.QPS = qps
+	}
+	if burst > 0 {
+		rc.Burst = burst
+	}
+	return rc
+}
+
+// progress counts the objects of a resource type that a rule has checked and patched, and logs them every
+// progressInterval, so that a long backfill can be followed.
+type progress struct {
+	rlog     zerolog.Logger
+	rule     string
+	started  time.Time
+	reported time.Time
+	checked  int
+	patched  int
+}
+
+func newProgress(rlog zerolog.Logger, rule string) *progress {
+	now := time.Now()
+	return &progress{rlog: rlog, rule: rule, started: now, reported: now}
+}
+
+// add counts a batch of objects, and logs the progress when it is due.  remaining is the apiserver's estimate of the
+// objects left to list, when it gives one.
+func (p *progress) add(checked, patched int, remaining *int64) {
+	p.checked += checked
+	p.patched += patched
+	metrics.ExistingObjectsChecked.WithLabelValues(p.rule).Add(float64(checked))
+	metrics.ExistingObjectsPatched.WithLabelValues(p.rule).Add(float64(patched))
+	if progressInterval <= 0 || time.Since(p.reported) < progressInterval {
+		return
+	}
+	p.reported = time.Now()
+	event := p.rlog.Info().Int("checked", p.checked).Int("patched", p.patched).Dur("elapsed", time.Since(p.started))
+	if remaining != nil {
+		event = event.Int64("remaining", *remaining)
+	}
+	event.Msg("checking existing objects")
+}
+
+// done logs the totals once all of the objects have been checked.
+func (p *progress) done() {
+	p.rlog.Info().Int("checked", p.checked).Int("patched", p.patched).Dur("elapsed", time.Since(p.started)).Msg("finished checking existing objects")
+}
+
+// visitBatch visits a batch of listed objects, up to workers of them at once, and returns the number of objects that
+// it checked and patched.  It returns once the whole batch is done, so that only one batch
+// is held in memory and a stop-on-match rule has seen all of its objects before the next rule starts.
+func visitBatch(rule *config.Rule, gv, resource string, items []unstructured.Unstructured, visit objectVisitor) (checked, patched int) {
+	objects := make(chan *unstructured.Unstructured)
+	var done int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(items); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range objects {
+				if visit(rule, gv, resource, *object) {
+					atomic.AddInt64(&done, 1)
+				}
+			}
+		}()
+	}
+	for i := range items {
+		if objectFilter.includesObject(items[i]) {
+			checked++
+			objects <- &items[i]
+		}
+	}
+	close(objects)
+	wg.Wait()
+	return checked, int(done)
+}
+
+// stoppedObjectsMu guards stoppedObjects, which the workers of a batch share.
+var stoppedObjectsMu sync.Mutex
+
+// stoppingRule returns the stop-on-match rule that matched an object, if one has.
+func stoppingRule(object unstructured.Unstructured) (string, bool) {
+	stoppedObjectsMu.Lock()
+	defer stoppedObjectsMu.Unlock()
+	rule, ok := stoppedObjects[object.GetUID()]
+	return rule, ok
+}
+
+// stopObject records that a stop-on-match rule matched an object.
+func stopObject(object unstructured.Unstructured, rule string) {
+	stoppedObjectsMu.Lock()
+	defer stoppedObjectsMu.Unlock()
+	stoppedObjects[object.GetUID()] = rule
+}
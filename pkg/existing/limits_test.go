@@ -0,0 +1,188 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+)
+
+func TestSetLimitsKeepsTheDefaultsForZeroValues(t *testing.T) {
+	t.Cleanup(func() { SetLimits(config.Existing{}) })
+
+	SetLimits(config.Existing{})
+	assert.Equal(t, int64(itemLimit), pageSize)
+	assert.Equal(t, 1, workers)
+	rc := rateLimited(&rest.Config{QPS: 5, Burst: 10})
+	assert.Equal(t, float32(5), rc.QPS)
+	assert.Equal(t, 10, rc.Burst)
+
+	SetLimits(config.Existing{PageSize: 500, Workers: 8, QPS: 50, Burst: 100})
+	assert.Equal(t, int64(500), pageSize)
+	assert.Equal(t, 8, workers)
+	original := &rest.Config{QPS: 5, Burst: 10}
+	rc = rateLimited(original)
+	assert.Equal(t, float32(50), rc.QPS)
+	assert.Equal(t, 100, rc.Burst)
+	assert.Equal(t, float32(5), original.QPS, "the rest config that we were given is left alone")
+}
+
+func TestBatchesAreCheckedByTheWorkersInParallel(t *testing.T) {
+	t.Cleanup(func() { SetLimits(config.Existing{}) })
+	SetLimits(config.Existing{Workers: 3})
+	nsCache = defaultTestNamespaceCache(t)
+
+	target := webhook.Target{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces"}}
+	rule := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("label-namespaces").AddLabels(map[string]string{"added": "by-graffiti"}))
+	var items []unstructured.Unstructured
+	for i := 0; i < 9; i++ {
+		ns := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Namespace"}}
+		ns.SetName(fmt.Sprintf("ns-%d", i))
+		ns.SetUID(types.UID(fmt.Sprintf("uid-%d", i)))
+		items = append(items, ns)
+	}
+
+	var running, most int32
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("Patch", mock.AnythingOfType("string"), types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).
+		Run(func(mock.Arguments) {
+			now := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				m := atomic.LoadInt32(&most)
+				if now <= m || atomic.CompareAndSwapInt32(&most, m, now) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+		}).Return(nil, nil)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	checked, patched := visitBatch(&rule, "v1", "namespaces", items, applyToObject)
+	assert.Equal(t, 9, checked)
+	assert.Equal(t, 9, patched)
+	nri.mockDynamicResourceInterface.AssertNumberOfCalls(t, "Patch", 9)
+	assert.True(t, most > 1, "objects should be patched in parallel")
+	assert.True(t, most <= 3, "no more than the workers should patch at once")
+}
+
+func TestListingUsesThePageSize(t *testing.T) {
+	t.Cleanup(func() { SetLimits(config.Existing{}) })
+	SetLimits(config.Existing{PageSize: 2})
+
+	rule := expiringRule(graffiti.Expiry{})
+	first := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}}
+	first.SetContinue("page-2")
+	last := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}}
+
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("List", metav1.ListOptions{Limit: 2, LabelSelector: "purpose=debug"}).Return(first, nil).Once()
+	nri.mockDynamicResourceInterface.On("List", metav1.ListOptions{Limit: 2, LabelSelector: "purpose=debug", Continue: "page-2"}).Return(last, nil).Once()
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	applyToAllResourcesOfType(&rule, "v1", metav1.APIResource{Name: "namespaces", Kind: "Namespace"})
+	nri.mockDynamicResourceInterface.AssertExpectations(t)
+}
+
+func TestSweepMetricsCountListedMatchedPatchedAndFailedObjects(t *testing.T) {
+	target := webhook.Target{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces"}}
+	rule := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("sweep-metrics").AddLabels(map[string]string{"added": "by-graffiti"}))
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}}
+	for i := 0; i < 3; i++ {
+		ns := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Namespace"}}
+		ns.SetName(fmt.Sprintf("ns-%d", i))
+		list.Items = append(list.Items, ns)
+	}
+	list.Items[2].SetLabels(map[string]string{"added": "by-graffiti"})
+
+	ri := mockDynamicResourceInterface{}
+	ri.On("List", metav1.ListOptions{Limit: itemLimit}).Return(list, nil).Once()
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("Patch", "ns-0", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	nri.mockDynamicResourceInterface.On("Patch", "ns-1", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, fmt.Errorf("boom"))
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	visitListedObjects(&rule, "v1", "namespaces", &ri, metav1.ListOptions{Limit: itemLimit}, applyToObject)
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.ExistingObjectsListed.WithLabelValues("sweep-metrics", "namespaces")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.ExistingObjectsMatched.WithLabelValues("sweep-metrics")), "an object that already has the label isn't matched")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ExistingObjectsPatched.WithLabelValues("sweep-metrics")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ExistingObjectsFailed.WithLabelValues("sweep-metrics")))
+
+	ri.On("List", metav1.ListOptions{Limit: itemLimit}).Return((*unstructured.UnstructuredList)(nil), fmt.Errorf("forbidden")).Once()
+	visitListedObjects(&rule, "v1", "namespaces", &ri, metav1.ListOptions{Limit: itemLimit}, applyToObject)
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ExistingListErrors.WithLabelValues("sweep-metrics", "namespaces")))
+}
+
+func TestMaxPatchesStopsPatchingObjects(t *testing.T) {
+	SetMaxPatches(2)
+	t.Cleanup(func() { SetMaxPatches(0) })
+
+	target := webhook.Target{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces"}}
+	rule := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("max-patches").AddLabels(map[string]string{"added": "by-graffiti"}))
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}}
+	for i := 0; i < 4; i++ {
+		ns := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Namespace"}}
+		ns.SetName(fmt.Sprintf("ns-%d", i))
+		list.Items = append(list.Items, ns)
+	}
+
+	ri := mockDynamicResourceInterface{}
+	ri.On("List", metav1.ListOptions{Limit: itemLimit}).Return(list, nil).Once()
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("Patch", mock.AnythingOfType("string"), types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	visitListedObjects(&rule, "v1", "namespaces", &ri, metav1.ListOptions{Limit: itemLimit}, applyToObject)
+	nri.mockDynamicResourceInterface.AssertNumberOfCalls(t, "Patch", 2)
+	assert.Equal(t, 4.0, testutil.ToFloat64(metrics.ExistingObjectsMatched.WithLabelValues("max-patches")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.ExistingObjectsPatched.WithLabelValues("max-patches")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.SkippedObjects.WithLabelValues("max-patches", metrics.ReasonMaxPatches)))
+}
+
+func TestCompletedSweepsAreTimed(t *testing.T) {
+	nsCache = defaultTestNamespaceCache(t)
+	completed := testutil.ToFloat64(metrics.ExistingSweeps.WithLabelValues(metrics.ResultCompleted))
+	before := time.Now().Unix()
+
+	ApplyRulesAgainstExistingObjects(nil)
+	assert.Equal(t, completed+1, testutil.ToFloat64(metrics.ExistingSweeps.WithLabelValues(metrics.ResultCompleted)))
+	assert.True(t, testutil.ToFloat64(metrics.ExistingSweepLastCompleted) >= float64(before))
+	assert.True(t, testutil.ToFloat64(metrics.ExistingSweepDuration) >= 0)
+}
@@ -118,3 +118,20 @@ func (c namespaceCache) LookupNamespace(name string) (*corev1.Namespace, error)
 
 	return ns.(*corev1.Namespace), nil
 }
+
+// IsTerminating returns true when the namespace is being deleted.  Writing to objects in a terminating namespace
+// only produces conflict errors, so any namespace that we can't look up is treated as not terminating.
+func (c namespaceCache) IsTerminating(name string) bool {
+	if name == "" {
+		return false
+	}
+	ns, err := c.LookupNamespace(name)
+	if err != nil {
+		return false
+	}
+	return isTerminating(ns)
+}
+
+func isTerminating(ns *corev1.Namespace) bool {
+	return ns.Status.Phase == corev1.NamespaceTerminating || ns.DeletionTimestamp != nil
+}
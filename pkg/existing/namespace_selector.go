@@ -15,8 +15,10 @@ limitations under the License.
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 
+	"github.com/Telefonica/kube-graffiti/pkg/config"
 	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
 	"github.com/Telefonica/kube-graffiti/pkg/log"
 )
@@ -61,7 +63,7 @@ func objectsNamespaceMatchesProvidedSelector(obj map[string]interface{}, selecto
 		labels = ns.Labels
 	}
 	if err := graffiti.ValidateLabelSelector(selector); err != nil {
-		return false, errors.New("invalid label selector")
+		return false, fmt.Errorf("%w: invalid label selector: %v", config.ErrConfigInvalid, err)
 	}
 	return graffiti.MatchLabelSelector(selector, labels)
 }
@@ -0,0 +1,41 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var resourceQuotas = schema.GroupVersionResource{Version: "v1", Resource: "resourcequotas"}
+
+// clusterQuotas lists the resource quotas of the cluster being checked, which needn't be the one that the webhook
+// serves.
+type clusterQuotas struct{}
+
+func (clusterQuotas) LookupQuotas(namespace string) ([]corev1.ResourceQuota, error) {
+	list, err := dynamicClient.Resource(resourceQuotas).Namespace(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	quotas := make([]corev1.ResourceQuota, len(list.Items))
+	for i, item := range list.Items {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &quotas[i]); err != nil {
+			return nil, err
+		}
+	}
+	return quotas, nil
+}
@@ -0,0 +1,50 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestClusterQuotasListsTheNamespacesResourceQuotas(t *testing.T) {
+	compute := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ResourceQuota",
+		"metadata":   map[string]interface{}{"name": "compute", "namespace": "team-a"},
+		"spec":       map[string]interface{}{"hard": map[string]interface{}{"cpu": "20", "pods": "50"}},
+		"status":     map[string]interface{}{"used": map[string]interface{}{"cpu": "3500m"}},
+	}}
+	ri := mockDynamicResourceInterface{}
+	ri.On("List", mock.Anything).Return(&unstructured.UnstructuredList{Items: []unstructured.Unstructured{compute}}, nil)
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.On("Namespace", "team-a").Return(&ri)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", resourceQuotas).Return(&nri)
+	dynamicClient = &dc
+
+	quotas, err := clusterQuotas{}.LookupQuotas("team-a")
+	require.NoError(t, err)
+	require.Len(t, quotas, 1)
+	assert.Equal(t, "compute", quotas[0].Name)
+	hard := quotas[0].Spec.Hard[corev1.ResourceCPU]
+	used := quotas[0].Status.Used[corev1.ResourceCPU]
+	assert.Equal(t, "20", hard.String())
+	assert.Equal(t, "3500m", used.String())
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// windows are the maintenance windows that existing objects are only changed within, and stopWaiting abandons a
+	// check that is waiting for one to open.
+	windows     config.Windows
+	stopWaiting <-chan struct{}
+	// windowPoll is how often a paused check looks to see whether a window has opened, and clock is what the windows
+	// are checked against, they are variables so that tests can change them.
+	windowPoll = time.Minute
+	clock      = time.Now
+)
+
+// SetWindows restricts the checks of existing objects to the maintenance windows.  A check pauses where it is when
+// it finds itself outside of them, and resumes from there when one opens, or gives up when stop is closed.
+func SetWindows(ws config.Windows, stop <-chan struct{}) {
+	windows = ws
+	stopWaiting = stop
+}
+
+// waitForWindow waits until a maintenance window is open, it is false when the check has been stopped instead.
+func waitForWindow(rlog zerolog.Logger) bool {
+	select {
+	case <-stopWaiting:
+		return false
+	default:
+	}
+	if windows.Open(clock()) {
+		return true
+	}
+
+	rlog.Info().Msg("outside of the maintenance windows, pausing the check of existing objects")
+	metrics.ExistingCheckPaused.Set(1)
+	defer metrics.ExistingCheckPaused.Set(0)
+	ticker := time.NewTicker(windowPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopWaiting:
+			rlog.Info().Msg("stopped while waiting for a maintenance window")
+			return false
+		case <-ticker.C:
+			if windows.Open(clock()) {
+				rlog.Info().Msg("a maintenance window has opened, resuming the check of existing objects")
+				return true
+			}
+		}
+	}
+}
+
+// RunPeriodicChecks applies the rules to the existing objects straight away and then again interval after each check
+// finishes, until stop is closed.  Each check only applies the rules which are active when it starts.  The kubernetes clients must have been set up with InitKubeClients.
+func RunPeriodicChecks(rules []config.Rule, interval time.Duration, stop <-chan struct{}) {
+	mylog := log.ComponentLogger(componentName, "RunPeriodicChecks")
+	mylog.Info().Dur("interval", interval).Int("windows", len(windows)).Msg("starting periodic checks of existing objects")
+	for {
+		ApplyRulesAgainstExistingObjects(config.ActiveRules(rules, clock()))
+		select {
+		case <-stop:
+			mylog.Info().Msg("stopping periodic checks of existing objects")
+			return
+		case <-time.After(interval):
+		}
+	}
+}
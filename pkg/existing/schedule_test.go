@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// withWindows sets the maintenance windows and a clock that is inside them while open is 1, until the test ends.
+func withWindows(t *testing.T, stop <-chan struct{}, open *int32) {
+	poll, now := windowPoll, clock
+	t.Cleanup(func() {
+		windowPoll, clock = poll, now
+		SetWindows(nil, nil)
+	})
+	windowPoll = time.Millisecond
+	night := time.Date(2020, 3, 2, 1, 30, 0, 0, time.UTC)
+	clock = func() time.Time {
+		if atomic.LoadInt32(open) == 1 {
+			return night
+		}
+		return night.Add(12 * time.Hour)
+	}
+	SetWindows(config.Windows{{Start: "01:00", End: "05:00"}}, stop)
+}
+
+func TestWaitForWindowPausesUntilAWindowOpens(t *testing.T) {
+	var open int32
+	withWindows(t, nil, &open)
+
+	resumed := make(chan bool)
+	go func() { resumed <- waitForWindow(zerolog.Nop()) }()
+	select {
+	case <-resumed:
+		t.Fatal("the check should be paused outside of the windows")
+	case <-time.After(20 * time.Millisecond):
+	}
+	atomic.StoreInt32(&open, 1)
+	select {
+	case ok := <-resumed:
+		assert.True(t, ok, "the check resumes when a window opens")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the check should resume when a window opens")
+	}
+	assert.True(t, waitForWindow(zerolog.Nop()), "there is no waiting within a window")
+}
+
+func TestWaitForWindowGivesUpWhenStopped(t *testing.T) {
+	var open int32
+	stop := make(chan struct{})
+	withWindows(t, stop, &open)
+
+	resumed := make(chan bool)
+	go func() { resumed <- waitForWindow(zerolog.Nop()) }()
+	close(stop)
+	select {
+	case ok := <-resumed:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("a paused check should give up when it is stopped")
+	}
+	atomic.StoreInt32(&open, 1)
+	assert.False(t, waitForWindow(zerolog.Nop()), "a stopped check doesn't carry on even within a window")
+}
+
+func TestListingStartsAgainWhenTheContinueTokenExpires(t *testing.T) {
+	rule := expiringRule(graffiti.Expiry{})
+	first := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}}
+	first.SetContinue("page-2")
+	again := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}}
+
+	ri := mockDynamicResourceInterface{}
+	ri.On("List", metav1.ListOptions{Limit: itemLimit}).Return(first, nil).Once()
+	ri.On("List", metav1.ListOptions{Limit: itemLimit, Continue: "page-2"}).Return((*unstructured.UnstructuredList)(nil), errors.NewResourceExpired("too old")).Once()
+	ri.On("List", metav1.ListOptions{Limit: itemLimit}).Return(again, nil).Once()
+
+	visitListedObjects(&rule, "v1", "namespaces", &ri, metav1.ListOptions{Limit: itemLimit}, applyToObject)
+	ri.AssertExpectations(t)
+}
+
+func TestPeriodicChecksLeaveIgnoredObjectsAlone(t *testing.T) {
+	target := webhook.Target{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces"}}
+	rule := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("label-namespaces").AddLabels(map[string]string{"added": "by-graffiti"}))
+
+	discoveryClient = defaultTestDiscoveryClient(t)
+	require.NoError(t, discoverAPIsAndResources())
+	nsCache = defaultTestNamespaceCache(t)
+	namespaces := new(unstructured.UnstructuredList)
+	require.NoError(t, json.Unmarshal([]byte(unstructuredNamespaceListJSON), namespaces))
+
+	// only the namespaces which aren't ignored are expected to be patched
+	nri := mockDynamicNamespaceableResourceInterface{}
+	nri.mockDynamicResourceInterface.On("List", mock.AnythingOfType("v1.ListOptions")).Return(namespaces, nil)
+	nri.mockDynamicResourceInterface.On("Patch", "default", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil).Once()
+	nri.mockDynamicResourceInterface.On("Patch", "test-namespace", types.ApplyPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil).Once()
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	SetIgnore(graffiti.Ignore{Namespaces: []string{"kube-*"}})
+	t.Cleanup(func() { SetIgnore(graffiti.Ignore{}) })
+	stop := make(chan struct{})
+	close(stop)
+	RunPeriodicChecks([]config.Rule{rule}, time.Hour, stop)
+	nri.AssertExpectations(t)
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// UndoRule reverses the labels and annotations that a stamping rule painted onto the existing objects that it targets,
+// for rolling back a bad rule.  The objects are found by the rule's stamp rather than its matchers, which may have
+// changed since, and the dry run and filter apply as they do to ApplyRulesAgainstExistingObjects.  The kubernetes
+// clients must have been set up with InitKubeClients.
+func UndoRule(rule config.Rule) error {
+	mylog := log.ComponentLogger(componentName, "UndoRule")
+	if !rule.Payload.Stamp {
+		return fmt.Errorf("rule '%s' doesn't stamp the objects that it paints, so they can't be found to undo", rule.Registration.Name)
+	}
+
+	mylog.Info().Str("rule", rule.Registration.Name).Msg("undoing rule on existing objects")
+	for _, target := range rule.Registration.AllTargets() {
+		for _, r := range targettedResources(&rule, target) {
+			if !waitForWindow(mylog) {
+				mylog.Info().Msg("the undo was stopped before it finished")
+				return nil
+			}
+			visitAllResourcesOfType(&rule, r.gv, r.resource, metav1.ListOptions{}, undoObject)
+		}
+	}
+	return nil
+}
+
+// undoObject reverses the rule's changes to a single object, when the object has its stamp.
+func undoObject(rule *config.Rule, gv, resource string, object unstructured.Unstructured) (patched bool) {
+	mylog := log.ComponentLogger(componentName, "undoObject")
+	name := object.GetName()
+	namespace := object.GetNamespace()
+	rlog := log.RuleLogger(mylog, rule.Registration.Name).With().Str("group-version", gv).Str("kind", object.GetKind()).Str("name", name).Str("namespace", namespace).Logger()
+
+	raw, err := json.Marshal(object.Object)
+	if err != nil {
+		rlog.Error().Err(err).Msg("could not marshal object")
+		return false
+	}
+	patch, err := rule.GraffitiRule().Undo(raw)
+	if err != nil {
+		rlog.Error().Err(err).Msg("could not undo object")
+		return false
+	}
+	if patch == nil {
+		rlog.Debug().Msg("object was not stamped by the rule")
+		return false
+	}
+	if dryRun != nil {
+		if err := reportChange(rule.Registration.Name, gv, resource, object, patch); err != nil {
+			rlog.Error().Err(err).Msg("could not work out the change to the object")
+		}
+		return false
+	}
+
+	g, v := splitGroupVersionString(gv)
+	client, err := patchingClient(rule)
+	if err != nil {
+		rlog.Error().Err(err).Msg("can't patch object")
+		return false
+	}
+	nri := client.Resource(schema.GroupVersionResource{Group: g, Version: v, Resource: resource})
+	if namespace != "" {
+		_, err = nri.Namespace(namespace).Patch(name, types.JSONPatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	} else {
+		_, err = nri.Patch(name, types.JSONPatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	}
+	if err != nil {
+		rlog.Error().Err(err).Msg("failed to undo object")
+		return false
+	}
+	rlog.Info().Str("patch", string(patch)).Msg("successfully undid object")
+	return true
+}
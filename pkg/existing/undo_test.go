@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package existing
+
+import (
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/config"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestUndoRulePatchesOnlyTheObjectsItStamped(t *testing.T) {
+	discoveryClient = defaultTestDiscoveryClient(t)
+	assert.NoError(t, discoverAPIsAndResources())
+
+	target := webhook.Target{APIGroups: []string{""}, APIVersions: []string{"*"}, Resources: []string{"namespaces"}}
+	rule := config.NewRule(webhook.Registration{Targets: []webhook.Target{target}, FailurePolicy: "Ignore"},
+		graffiti.NewRule("label-namespaces").MatchLabels("fruit=apple").AddLabels(map[string]string{"added": "by-graffiti"}))
+	rule.Payload.Stamp = true
+
+	stamped := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Namespace"}}
+	stamped.SetName("painted")
+	stamped.SetLabels(map[string]string{"added": "by-graffiti"})
+	stamped.SetAnnotations(map[string]string{"graffiti/label-namespaces-applied": "2020-06-01T12:00:00Z", "graffiti/label-namespaces-hash": "abc"})
+	unstamped := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Namespace"}}
+	unstamped.SetName("untouched")
+	unstamped.SetLabels(map[string]string{"added": "by-hand"})
+	list := &unstructured.UnstructuredList{Object: map[string]interface{}{"metadata": map[string]interface{}{}}, Items: []unstructured.Unstructured{stamped, unstamped}}
+
+	nri := mockDynamicNamespaceableResourceInterface{}
+	// the objects are found by their stamp, so the rule's selectors aren't pushed down into the list
+	nri.mockDynamicResourceInterface.On("List", metav1.ListOptions{Limit: itemLimit}).Return(list, nil)
+	nri.mockDynamicResourceInterface.On("Patch", "painted", types.JSONPatchType, mock.AnythingOfType("[]uint8"), mock.AnythingOfType("[]string")).Return(nil, nil)
+	dc := mockDynamicInterface{}
+	dc.On("Resource", schema.GroupVersionResource{Group: "", Version: "v1", Resource: "namespaces"}).Return(&nri)
+	dynamicClient = &dc
+
+	assert.NoError(t, UndoRule(rule))
+	nri.mockDynamicResourceInterface.AssertExpectations(t)
+	nri.mockDynamicResourceInterface.AssertNumberOfCalls(t, "Patch", 1)
+	patch := nri.mockDynamicResourceInterface.Calls[1].Arguments.Get(2).([]byte)
+	assert.JSONEq(t, `[{"op":"remove","path":"/metadata/labels"},{"op":"remove","path":"/metadata/annotations"}]`, string(patch))
+}
+
+func TestUndoRuleNeedsAStampingRule(t *testing.T) {
+	rule := config.NewRule(webhook.Registration{}, graffiti.NewRule("label-namespaces").AddLabels(map[string]string{"added": "by-graffiti"}))
+	assert.EqualError(t, UndoRule(rule), "rule 'label-namespaces' doesn't stamp the objects that it paints, so they can't be found to undo")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The prefixes of the annotations that backup-previous writes the previous values of labels and annotations to, they
+// are followed by the key with any '/' replaced by '_' and prefixed with "graffiti.<company-domain>/".
+const (
+	PreviousLabelPrefix      = "prev."
+	PreviousAnnotationPrefix = "prev-annotation."
+)
+
+// PreviousValueAnnotation is the annotation that backup-previous keeps the previous value of a label, or annotation,
+// in.  It is empty when the key is too long to make a valid annotation.
+func (p Payload) PreviousValueAnnotation(key string, annotation bool) string {
+	name := PreviousLabelPrefix
+	if annotation {
+		name = PreviousAnnotationPrefix
+	}
+	name = name + strings.Replace(key, "/", "_", -1)
+	if len(utilvalidation.IsQualifiedName(name)) != 0 {
+		return ""
+	}
+	return p.annotationPrefix() + "/" + name
+}
+
+// previousValues returns the backup annotations of the labels and annotations, that already have a different value,
+// which the payload is about to overwrite or delete.  Keys that can't be backed up are logged and left out.
+func (p Payload) previousValues(obj metaObject, fm, copied, recorded map[string]string) (map[string]string, error) {
+	mylog := log.ComponentLogger(componentName, "previousValues")
+	labels, err := renderAdditions(p.Additions.Labels, obj.Meta.Labels, fm)
+	if err != nil {
+		return nil, err
+	}
+	labels = mergeMaps(labels, copied)
+	annotations, err := renderAdditions(p.Additions.Annotations, obj.Meta.Annotations, fm)
+	if err != nil {
+		return nil, err
+	}
+	annotations = mergeMaps(annotations, recorded)
+	dels := p.allDeletions()
+
+	backups := make(map[string]string)
+	for _, changes := range []struct {
+		current    map[string]string
+		added      map[string]string
+		deleted    []string
+		annotation bool
+	}{
+		{obj.Meta.Labels, labels, dels.Labels, false},
+		{obj.Meta.Annotations, annotations, dels.Annotations, true},
+	} {
+		var keys []string
+		for k, v := range changes.added {
+			if previous, ok := changes.current[k]; ok && previous != v {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range changes.deleted {
+			if _, ok := changes.current[k]; ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			backup := p.PreviousValueAnnotation(k, changes.annotation)
+			if backup == "" {
+				mylog.Warn().Str("key", k).Bool("annotation", changes.annotation).Msg("key is too long to back up its previous value")
+				continue
+			}
+			backups[backup] = changes.current[k]
+		}
+	}
+	return backups, nil
+}
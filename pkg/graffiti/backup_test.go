@@ -0,0 +1,57 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupPreviousAnnotatesOverwrittenAndDeletedValues(t *testing.T) {
+	rule := NewRule("backup").
+		AddLabels(map[string]string{"team": "platform", "app.kubernetes.io/part-of": "graffiti", "tier": "web"}).
+		DeleteAnnotations("owner")
+	rule.Payload = rule.Payload.WithCompanyDomain("acme.com")
+	rule.Payload.BackupPrevious = true
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web","labels":{"team":"web","tier":"web","app.kubernetes.io/part-of":"shop"},"annotations":{"owner":"alice"}}}`))
+	require.NoError(t, err)
+
+	var ops []struct {
+		Path  string            `json:"path"`
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 2)
+	assert.Equal(t, "/metadata/annotations", ops[1].Path)
+	assert.Equal(t, map[string]string{
+		"graffiti.acme.com/prev.team":                      "web",
+		"graffiti.acme.com/prev.app.kubernetes.io_part-of": "shop",
+		"graffiti.acme.com/prev-annotation.owner":          "alice",
+	}, ops[1].Value, "unchanged values aren't backed up and deleted annotations are replaced by their backups")
+}
+
+func TestBackupPreviousSkipsKeysThatAreTooLong(t *testing.T) {
+	p := Payload{}.WithCompanyDomain("acme.com")
+	assert.Equal(t, "graffiti.acme.com/prev.team", p.PreviousValueAnnotation("team", false))
+	assert.Equal(t, "", p.PreviousValueAnnotation(strings.Repeat("a", 60), false))
+
+	assert.EqualError(t, Payload{BackupPrevious: true, Block: true}.validate(), "a rule payload can only backup-previous values when it has additions or deletions")
+}
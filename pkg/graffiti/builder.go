@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+// NewRule starts building a mutating rule in Go, rather than loading it from configuration, e.g.
+//
+//	rule := graffiti.NewRule("label-web-pods").MatchLabels("app=web").AddLabels(map[string]string{"team": "web"})
+//
+// Each method returns a copy of the rule, so a partly built rule can be shared, and the rule should be checked with
+// Validate once it is built.
+func NewRule(name string) Rule {
+	return Rule{Name: name, Type: RuleTypeMutating}
+}
+
+// Validating makes the rule deny the objects that it matches instead of painting them.
+func (r Rule) Validating() Rule {
+	r.Type = RuleTypeValidating
+	return r
+}
+
+// WithPriority sets the rule's priority, rules with a higher priority are called first.
+func (r Rule) WithPriority(priority int) Rule {
+	r.Priority = priority
+	return r
+}
+
+// StoppingOnMatch makes the rule skip the rules with a lower priority for the objects that it matches.
+func (r Rule) StoppingOnMatch() Rule {
+	r.StopOnMatch = true
+	return r
+}
+
+// MatchLabels adds label selectors, any one of which selects an object.
+func (r Rule) MatchLabels(selectors ...string) Rule {
+	r.Matchers.LabelSelectors = append(append([]string{}, r.Matchers.LabelSelectors...), selectors...)
+	return r
+}
+
+// MatchFields adds field selectors, any one of which selects an object.
+func (r Rule) MatchFields(selectors ...string) Rule {
+	r.Matchers.FieldSelectors = append(append([]string{}, r.Matchers.FieldSelectors...), selectors...)
+	return r
+}
+
+// MatchNames adds object names, which can contain '*' wildcards.
+func (r Rule) MatchNames(names ...string) Rule {
+	r.Matchers.Names = append(append([]string{}, r.Matchers.Names...), names...)
+	return r
+}
+
+// MatchHasFields adds json paths of fields that an object must have.
+func (r Rule) MatchHasFields(paths ...string) Rule {
+	r.Matchers.HasField = append(append([]string{}, r.Matchers.HasField...), paths...)
+	return r
+}
+
+// MatchMissingFields adds json paths of fields that an object must not have.
+func (r Rule) MatchMissingFields(paths ...string) Rule {
+	r.Matchers.MissingField = append(append([]string{}, r.Matchers.MissingField...), paths...)
+	return r
+}
+
+// MatchHasLabels adds label keys that an object must have.
+func (r Rule) MatchHasLabels(keys ...string) Rule {
+	r.Matchers.HasLabel = append(append([]string{}, r.Matchers.HasLabel...), keys...)
+	return r
+}
+
+// MatchMissingLabels adds label keys that an object must not have.
+func (r Rule) MatchMissingLabels(keys ...string) Rule {
+	r.Matchers.MissingLabel = append(append([]string{}, r.Matchers.MissingLabel...), keys...)
+	return r
+}
+
+// CombineWith sets the boolean operator that combines the results of the label and field selectors.
+func (r Rule) CombineWith(operator BooleanOperator) Rule {
+	r.Matchers.BooleanOperator = operator
+	return r
+}
+
+// MatchCEL sets the cel expression which must also be true for an object to match.
+func (r Rule) MatchCEL(expression string) Rule {
+	r.Matchers.CEL = expression
+	return r
+}
+
+// MatchRego sets the rego policy which must also decide that an object matches, and can emit labels and annotations.
+func (r Rule) MatchRego(policy string) Rule {
+	r.Rego.Policy = policy
+	return r
+}
+
+// AddLabels adds labels to the objects that the rule matches.
+func (r Rule) AddLabels(labels map[string]string) Rule {
+	r.Payload.Additions.Labels = mergeMaps(r.Payload.Additions.Labels, labels)
+	return r
+}
+
+// AddAnnotations adds annotations to the objects that the rule matches.
+func (r Rule) AddAnnotations(annotations map[string]string) Rule {
+	r.Payload.Additions.Annotations = mergeMaps(r.Payload.Additions.Annotations, annotations)
+	return r
+}
+
+// AddLabelsFromFields adds labels whose values are copied from the fields at the json paths of the objects that the
+// rule matches.
+func (r Rule) AddLabelsFromFields(paths map[string]string) Rule {
+	r.Payload.LabelsFromFields = mergeMaps(r.Payload.LabelsFromFields, paths)
+	return r
+}
+
+// DeleteLabels removes labels from the objects that the rule matches.
+func (r Rule) DeleteLabels(keys ...string) Rule {
+	r.Payload.Deletions.Labels = append(append([]string{}, r.Payload.Deletions.Labels...), keys...)
+	return r
+}
+
+// DeleteAnnotations removes annotations from the objects that the rule matches.
+func (r Rule) DeleteAnnotations(keys ...string) Rule {
+	r.Payload.Deletions.Annotations = append(append([]string{}, r.Payload.Deletions.Annotations...), keys...)
+	return r
+}
+
+// Block makes the rule block the objects that it matches.
+func (r Rule) Block() Rule {
+	r.Payload.Block = true
+	return r
+}
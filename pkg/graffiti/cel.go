@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// celEnv declares the variables available to cel expressions: the object being admitted, and on updates
+// the oldObject that it is replacing (which is an empty map otherwise).
+var celEnv = func() *cel.Env {
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewVar("object", decls.NewMapType(decls.String, decls.Dyn)),
+		decls.NewVar("oldObject", decls.NewMapType(decls.String, decls.Dyn)),
+	))
+	if err != nil {
+		panic(fmt.Sprintf("failed to create cel environment: %v", err))
+	}
+	return env
+}()
+
+// celPrograms caches compiled expressions so that they are only compiled once rather than for every object.
+var celPrograms sync.Map
+
+// compileCEL parses and type checks a cel expression, which must evaluate to a bool.
+func compileCEL(expression string) (cel.Program, error) {
+	if prg, ok := celPrograms.Load(expression); ok {
+		return prg.(cel.Program), nil
+	}
+	ast, issues := celEnv.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if t := ast.ResultType(); t.GetPrimitive() != exprpb.Type_BOOL && t.GetDyn() == nil {
+		return nil, fmt.Errorf("expression must evaluate to a bool")
+	}
+	prg, err := celEnv.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	celPrograms.Store(expression, prg)
+	return prg, nil
+}
+
+// validateCEL checks that a cel expression compiles and is used when validating config
+func validateCEL(expression string) error {
+	_, err := compileCEL(expression)
+	return err
+}
+
+// evalCEL evaluates a compiled cel expression against an object and its old object.
+func evalCEL(prg cel.Program, object, oldObject map[string]interface{}) (bool, error) {
+	if object == nil {
+		object = map[string]interface{}{}
+	}
+	if oldObject == nil {
+		oldObject = map[string]interface{}{}
+	}
+	out, _, err := prg.Eval(map[string]interface{}{
+		"object":    object,
+		"oldObject": oldObject,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate cel expression: %v", err)
+	}
+	result, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("cel expression did not evaluate to a bool, got %v", out.Value())
+	}
+	return result, nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// compiledMatchers are a rule's selectors and cel expression parsed and compiled once, when the rule is loaded, so
+// that matching an object doesn't have to work anything out from the rule's configuration.
+type compiledMatchers struct {
+	labelSelectors []compiledSelector
+	fieldSelectors []compiledSelector
+	// cel is the rule's cel expression combined with its presets, it is nil when there are neither.
+	cel cel.Program
+	// exceptions are the compiled selectors of the rule's exceptions, they are nil when it has none.
+	exceptions *compiledMatchers
+}
+
+// compiledSelector is a label or field selector, as configured, with any negating '!' split from its parsed selector.
+type compiledSelector struct {
+	selector string
+	labels   labels.Selector
+	fields   fields.Selector
+	negated  bool
+}
+
+// matches is true when the selector, or its negation, matches the set of labels or fields.
+func (s compiledSelector) matches(set labels.Set) bool {
+	if s.labels != nil {
+		return s.labels.Matches(set) != s.negated
+	}
+	return s.fields.Matches(set) != s.negated
+}
+
+// Compiled returns the rule with its matchers compiled, which is done when a rule is loaded so that they aren't
+// parsed for every object.  A rule that fails to compile is returned as it is, and fails when it is matched instead.
+func (r Rule) Compiled() Rule {
+	if r.compiled != nil {
+		return r
+	}
+	if compiled, err := r.Matchers.compile(); err == nil {
+		r.compiled = compiled
+	}
+	return r
+}
+
+// compiledMatchers returns the rule's compiled matchers, compiling them now when the rule wasn't compiled on loading.
+func (r Rule) compiledMatchers() (*compiledMatchers, error) {
+	if r.compiled != nil {
+		return r.compiled, nil
+	}
+	return r.Matchers.compile()
+}
+
+// compile parses the selectors, and compiles the cel expression, using the caches shared by all of the rules.
+func (m Matchers) compile() (*compiledMatchers, error) {
+	c := &compiledMatchers{}
+	for _, selector := range m.LabelSelectors {
+		realSelector, negated := negatedSelector(selector, ValidateLabelSelector)
+		parsed, err := parseLabelSelector(realSelector)
+		if err != nil {
+			return nil, err
+		}
+		c.labelSelectors = append(c.labelSelectors, compiledSelector{selector: selector, labels: parsed, negated: negated})
+	}
+	for _, selector := range m.FieldSelectors {
+		realSelector, negated := negatedSelector(selector, nil)
+		parsed, err := parseFieldSelector(realSelector)
+		if err != nil {
+			return nil, err
+		}
+		c.fieldSelectors = append(c.fieldSelectors, compiledSelector{selector: selector, fields: parsed, negated: negated})
+	}
+	expression, err := m.celExpression()
+	if err != nil {
+		return nil, err
+	}
+	if expression != "" {
+		if c.cel, err = compileCEL(expression); err != nil {
+			return nil, err
+		}
+	}
+	if !m.Exceptions.empty() {
+		if c.exceptions, err = m.Exceptions.matchers().compile(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
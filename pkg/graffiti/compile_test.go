@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompiledRulesMatchLikeTheirConfiguration(t *testing.T) {
+	req := benchmarkRequest()
+	for i := 0; i < 8; i++ {
+		rule := benchmarkRule(i)
+		compiled := rule.Compiled()
+		require.NotNil(t, compiled.compiled, "rule %s should compile", rule.Name)
+		assert.Equal(t, compiled, compiled.Compiled(), "compiling a compiled rule changes nothing")
+
+		want, err := rule.MatchesAdmission(req)
+		require.NoError(t, err)
+		got, err := compiled.MatchesAdmission(req)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "rule %s", rule.Name)
+	}
+}
+
+func TestCompiledKeepsNegatedSelectors(t *testing.T) {
+	compiled, err := Matchers{LabelSelectors: []string{"!team = a", "!team"}}.compile()
+	require.NoError(t, err)
+	require.Len(t, compiled.labelSelectors, 2)
+	assert.True(t, compiled.labelSelectors[0].negated)
+	assert.False(t, compiled.labelSelectors[1].negated, "'!team' is a kubernetes selector in its own right")
+	assert.Nil(t, compiled.cel)
+}
+
+func TestRulesThatDoNotCompileFailWhenMatched(t *testing.T) {
+	rule := Rule{Name: "broken", Matchers: Matchers{LabelSelectors: []string{"this is not a selector"}}}.Compiled()
+	assert.Nil(t, rule.compiled)
+	_, err := rule.MatchesAdmission(benchmarkRequest())
+	assert.Error(t, err)
+}
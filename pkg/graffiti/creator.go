@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// The names of the annotations written by a record-creator payload, they are prefixed with "graffiti.<company-domain>/".
+const (
+	CreatedByAnnotation       = "created-by"
+	CreatedByGroupsAnnotation = "created-by-groups"
+	CreatedAtAnnotation       = "created-at"
+)
+
+// WithCompanyDomain returns a copy of the payload that knows the company domain, which prefixes the annotations
+// written by record-creator, backup-previous and inject-containers.
+func (p Payload) WithCompanyDomain(domain string) Payload {
+	p.companyDomain = domain
+	return p
+}
+
+// annotationPrefix is "graffiti.<company-domain>", or just "graffiti" when the company domain isn't known.
+func (p Payload) annotationPrefix() string {
+	if p.companyDomain == "" {
+		return "graffiti"
+	}
+	return "graffiti." + p.companyDomain
+}
+
+// creatorAnnotations are the annotations that record who created an object and when.
+func (p Payload) creatorAnnotations(creator *authenticationv1.UserInfo, at time.Time) map[string]string {
+	prefix := p.annotationPrefix()
+	return map[string]string{
+		prefix + "/" + CreatedByAnnotation:       creator.Username,
+		prefix + "/" + CreatedByGroupsAnnotation: strings.Join(creator.Groups, ","),
+		prefix + "/" + CreatedAtAnnotation:       at.UTC().Format(time.RFC3339),
+	}
+}
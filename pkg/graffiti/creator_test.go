@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRecordCreatorAnnotatesCreatedObjects(t *testing.T) {
+	rule := Rule{
+		Name: "record-creator",
+		Payload: Payload{
+			RecordCreator: true,
+			Additions:     Additions{Annotations: map[string]string{"owner": "web"}},
+		}.WithCompanyDomain("acme.com"),
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	req := &admission.AdmissionRequest{
+		Operation: admission.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default"}}`)},
+		UserInfo:  authenticationv1.UserInfo{Username: "ACME\\alice", Groups: []string{"developers", "system:authenticated"}},
+	}
+	resp := rule.MutateAdmission(req)
+	require.True(t, resp.Allowed)
+
+	var patch []struct {
+		Op    string            `json:"op"`
+		Path  string            `json:"path"`
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Patch, &patch), "usernames must be escaped in the patch")
+	require.Len(t, patch, 1)
+	annotations := patch[0].Value
+	assert.Equal(t, "web", annotations["owner"])
+	assert.Equal(t, "ACME\\alice", annotations["graffiti.acme.com/created-by"])
+	assert.Equal(t, "developers,system:authenticated", annotations["graffiti.acme.com/created-by-groups"])
+	created, err := time.Parse(time.RFC3339, annotations["graffiti.acme.com/created-at"])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), created, time.Minute)
+
+	req.Operation = admission.Update
+	req.OldObject = req.Object
+	resp = rule.MutateAdmission(req)
+	patch = nil
+	require.NoError(t, json.Unmarshal(resp.Patch, &patch))
+	assert.NotContains(t, patch[0].Value, "graffiti.acme.com/created-by", "updates don't change the recorded creator")
+}
+
+func TestRecordCreatorIsAPayloadOnItsOwn(t *testing.T) {
+	assert.NoError(t, Payload{RecordCreator: true}.validate())
+	assert.Error(t, Payload{RecordCreator: true, Block: true}.validate())
+
+	_, err := Rule{Payload: Payload{RecordCreator: true}}.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web"}}`))
+	assert.NoError(t, err, "existing objects have no creator to record")
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"errors"
+)
+
+// WithDefaults returns a copy of the matchers with each kind of matcher that they don't set taken from the defaults.
+// Negate and the boolean-operator are never defaulted, as a rule can't tell that it wants them left unset.
+func (m Matchers) WithDefaults(d Matchers) Matchers {
+	if len(m.Names) == 0 {
+		m.Names = d.Names
+	}
+	if len(m.LabelSelectors) == 0 {
+		m.LabelSelectors = d.LabelSelectors
+	}
+	if len(m.FieldSelectors) == 0 {
+		m.FieldSelectors = d.FieldSelectors
+	}
+	if m.emptyPresence() {
+		m.HasField, m.MissingField, m.HasLabel, m.MissingLabel = d.HasField, d.MissingField, d.HasLabel, d.MissingLabel
+	}
+	if m.CEL == "" {
+		m.CEL = d.CEL
+	}
+	if len(m.Presets) == 0 {
+		m.Presets = d.Presets
+	}
+	if m.RequestedBy.empty() {
+		m.RequestedBy = d.RequestedBy
+	}
+	if m.Ingress.empty() {
+		m.Ingress = d.Ingress
+	}
+	if m.External.empty() {
+		m.External = d.External
+	}
+	if m.Exceptions.empty() {
+		m.Exceptions = d.Exceptions
+	}
+	if !m.NormalizeVersions {
+		m.NormalizeVersions = d.NormalizeVersions
+	}
+	return m
+}
+
+// ValidateDefaults checks that matchers can be used as the defaults of other matchers.
+func (m Matchers) ValidateDefaults() error {
+	if m.Negate || m.BooleanOperator != AND {
+		return errors.New("default matchers can not set negate or boolean-operator, they must be set by each rule")
+	}
+	return nil
+}
+
+// WithDefaultAdditions returns a copy of the payload which also adds the default labels and annotations, apart from
+// those that it adds or deletes itself.  Payloads which block, patch or inject containers are left alone, as additions
+// can't be combined with them.
+func (p Payload) WithDefaultAdditions(d Additions) Payload {
+	if p.Block || p.JSONPatch != "" || !p.InjectContainers.isEmpty() {
+		return p
+	}
+	dels := p.allDeletions()
+	p.Additions.Labels = withDefaultValues(p.Additions.Labels, d.Labels, dels.Labels)
+	p.Additions.Annotations = withDefaultValues(p.Additions.Annotations, d.Annotations, dels.Annotations)
+	return p
+}
+
+func withDefaultValues(values, defaults map[string]string, deleted []string) map[string]string {
+	if len(defaults) == 0 {
+		return values
+	}
+	result := mergeMaps(defaults, values)
+	for _, key := range deleted {
+		if _, ok := values[key]; !ok {
+			delete(result, key)
+		}
+	}
+	return result
+}
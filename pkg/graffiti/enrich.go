@@ -0,0 +1,111 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// EnrichQuota is the enrichment of namespaces with the hard limits and usage of their resource quotas.
+const EnrichQuota = "quota"
+
+// enrichments are the enrichments that a payload can list.
+var enrichments = map[string]bool{
+	EnrichQuota: true,
+}
+
+// QuotaLookup lists the resource quotas of a namespace.
+type QuotaLookup interface {
+	LookupQuotas(namespace string) ([]corev1.ResourceQuota, error)
+}
+
+// quotaLookup finds the resource quotas of the namespaces in admission requests, rules can be given their own with
+// WithQuotaLookup.
+var quotaLookup QuotaLookup
+
+// SetQuotaLookup sets how the rules that enrich namespaces with their quotas find them, unless they have their own
+// lookup.
+func SetQuotaLookup(l QuotaLookup) {
+	quotaLookup = l
+}
+
+// WithQuotaLookup returns the rule with its own quota lookup, for when its namespaces are in another cluster from the
+// one whose admission requests are being served.
+func (r Rule) WithQuotaLookup(l QuotaLookup) Rule {
+	r.quotas = l
+	return r
+}
+
+// validateEnrich checks that only known enrichments are listed.
+func validateEnrich(enrich []string) error {
+	for _, e := range enrich {
+		if !enrichments[e] {
+			return fmt.Errorf("invalid enrich: unknown enrichment \"%s\", it must be %s", e, EnrichQuota)
+		}
+	}
+	return nil
+}
+
+func (p Payload) enriches(enrichment string) bool {
+	for _, e := range p.Enrich {
+		if e == enrichment {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaFields are the template fields of a namespace's resource quotas, 'quota.hard.<resource>' and
+// 'quota.used.<resource>'.  A namespace is held to the lowest hard limit of all of its quotas, which all count the
+// same usage, so the lowest hard limit and the highest usage of each resource is given.  Only Namespaces are
+// enriched, other kinds of object have no quota fields.
+func quotaFields(lookup QuotaLookup, object metaObject, fieldMap map[string]string) (map[string]string, error) {
+	if fieldMap["kind"] != "Namespace" || object.Meta.Name == "" {
+		return nil, nil
+	}
+	if lookup == nil {
+		return nil, fmt.Errorf("can't enrich namespace '%s' with its quotas, quotas are not being looked up", object.Meta.Name)
+	}
+	quotas, err := lookup.LookupQuotas(object.Meta.Name)
+	if err != nil {
+		return nil, fmt.Errorf("can't enrich namespace '%s' with its quotas: %v", object.Meta.Name, err)
+	}
+	// the quotas are ordered so that equal quantities written differently are always given in the same way
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Name < quotas[j].Name })
+	hard := make(corev1.ResourceList)
+	used := make(corev1.ResourceList)
+	for _, quota := range quotas {
+		for resource, q := range quota.Spec.Hard {
+			if current, ok := hard[resource]; !ok || q.Cmp(current) < 0 {
+				hard[resource] = q
+			}
+		}
+		for resource, q := range quota.Status.Used {
+			if current, ok := used[resource]; !ok || q.Cmp(current) > 0 {
+				used[resource] = q
+			}
+		}
+	}
+	fields := make(map[string]string)
+	for resource, q := range hard {
+		fields["quota.hard."+string(resource)] = q.String()
+	}
+	for resource, q := range used {
+		fields["quota.used."+string(resource)] = q.String()
+	}
+	return fields, nil
+}
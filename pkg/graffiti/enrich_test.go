@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// quotas is a QuotaLookup of the resource quotas of each namespace in a map.
+type quotas map[string][]corev1.ResourceQuota
+
+func (q quotas) LookupQuotas(namespace string) ([]corev1.ResourceQuota, error) {
+	if q == nil {
+		return nil, errors.New("forbidden")
+	}
+	return q[namespace], nil
+}
+
+func quota(name string, hard, used corev1.ResourceList) corev1.ResourceQuota {
+	return corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+		Status:     corev1.ResourceQuotaStatus{Hard: hard, Used: used},
+	}
+}
+
+func TestEnrichQuotaAnnotatesNamespacesWithTheirQuotas(t *testing.T) {
+	lookup := quotas{"team-a": {
+		quota("compute", corev1.ResourceList{
+			corev1.ResourceCPU:            resource.MustParse("20"),
+			corev1.ResourceRequestsMemory: resource.MustParse("64Gi"),
+		}, corev1.ResourceList{
+			corev1.ResourceCPU:            resource.MustParse("3500m"),
+			corev1.ResourceRequestsMemory: resource.MustParse("10Gi"),
+		}),
+		quota("team-limit", corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("16"),
+		}, corev1.ResourceList{
+			corev1.ResourceCPU: resource.MustParse("3"),
+		}),
+	}}
+	SetQuotaLookup(lookup)
+	defer SetQuotaLookup(nil)
+
+	rule := Rule{
+		Name: "quota-annotations",
+		Payload: Payload{
+			Additions: Additions{Annotations: map[string]string{
+				"graffiti/quota-cpu":             `{{ index . "quota.hard.cpu" }}`,
+				"graffiti/quota-cpu-used":        `{{ index . "quota.used.cpu" }}`,
+				"graffiti/quota-requests-memory": `{{ index . "quota.hard.requests.memory" }}`,
+			}},
+			Enrich: []string{EnrichQuota},
+		},
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"Namespace","metadata":{"name":"team-a"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/annotations","value":{"graffiti/quota-cpu":"16","graffiti/quota-cpu-used":"3500m","graffiti/quota-requests-memory":"64Gi"}}]`, string(patch),
+		"the lowest hard limit and the highest usage of each resource is given")
+}
+
+func TestEnrichQuotaOnlyEnrichesNamespaces(t *testing.T) {
+	fields, err := quotaFields(nil, metaObject{Meta: metav1.ObjectMeta{Name: "web", Namespace: "team-a"}}, map[string]string{"kind": "Pod"})
+	assert.NoError(t, err, "other kinds don't need a lookup")
+	assert.Empty(t, fields)
+
+	fields, err = quotaFields(quotas{}, metaObject{Meta: metav1.ObjectMeta{Name: "team-b"}}, map[string]string{"kind": "Namespace"})
+	assert.NoError(t, err)
+	assert.Empty(t, fields, "a namespace without quotas has no quota fields")
+}
+
+func TestEnrichQuotaUsesTheRulesQuotaLookup(t *testing.T) {
+	rule := Rule{Payload: Payload{
+		Additions: Additions{Labels: map[string]string{"quota-pods": `{{ index . "quota.hard.pods" }}`}},
+		Enrich:    []string{EnrichQuota},
+	}}
+	object := []byte(`{"kind":"Namespace","metadata":{"name":"team-a"}}`)
+
+	_, err := rule.Mutate(object)
+	assert.EqualError(t, err, "can't enrich namespace 'team-a' with its quotas, quotas are not being looked up")
+	_, err = rule.WithQuotaLookup(quotas(nil)).Mutate(object)
+	assert.EqualError(t, err, "can't enrich namespace 'team-a' with its quotas: forbidden")
+
+	lookup := quotas{"team-a": {quota("pods", corev1.ResourceList{corev1.ResourcePods: resource.MustParse("50")}, nil)}}
+	patch, err := rule.WithQuotaLookup(lookup).Mutate(object)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/labels","value":{"quota-pods":"50"}}]`, string(patch))
+}
+
+func TestEnrichValidation(t *testing.T) {
+	additions := Additions{Annotations: map[string]string{"quota-cpu": `{{ index . "quota.hard.cpu" }}`}}
+	assert.NoError(t, Payload{Additions: additions, Enrich: []string{"quota"}}.validate())
+	assert.EqualError(t, Payload{Additions: additions, Enrich: []string{"limits"}}.validate(), "invalid enrich: unknown enrichment \"limits\", it must be quota")
+	assert.EqualError(t, Payload{DeleteLabels: []string{"quota-cpu"}, Enrich: []string{"quota"}}.validate(), "a rule payload can only enrich objects when it has label or annotation additions")
+	assert.EqualError(t, Payload{JSONPatch: `[{"op": "remove", "path": "/metadata/labels/app"}]`, Enrich: []string{"limits"}}.validate(), "invalid enrich: unknown enrichment \"limits\", it must be quota", "enrich is validated whatever the type of payload")
+	assert.EqualError(t, Payload{Additions: additions, Enrich: []string{"quota"}, Stamp: true}.validate(), "a rule payload can not both enrich and stamp objects")
+}
@@ -0,0 +1,27 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrPatchBuild is returned, wrapped, when a rule matched an object but its payload could not be made into a patch.
+var ErrPatchBuild = errors.New("could not create json patch")
+
+// StatusReasonPatchBuild is the reason given in the admission response of a rule that failed with ErrPatchBuild, the
+// other failures are given metav1.StatusReasonInternalError.
+const StatusReasonPatchBuild metav1.StatusReason = "PatchBuild"
@@ -0,0 +1,54 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graffiti
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog"
+)
+
+// Exceptions carve objects out of a rule, e.g. to label all namespaces except those labelled 'graffiti/ignore=true'.
+// They have their own label and field selectors and boolean-operator, which are combined in the same way as the
+// rule's, and when they match the rule doesn't, whatever its other matchers say.
+type Exceptions struct {
+	LabelSelectors  []string        `mapstructure:"label-selectors" yaml:"label-selectors,omitempty"`
+	FieldSelectors  []string        `mapstructure:"field-selectors" yaml:"field-selectors,omitempty"`
+	BooleanOperator BooleanOperator `mapstructure:"boolean-operator" yaml:"boolean-operator,omitempty"`
+}
+
+func (e Exceptions) empty() bool {
+	return len(e.LabelSelectors) == 0 && len(e.FieldSelectors) == 0
+}
+
+// matchers are the exceptions as matchers, so that they are validated, compiled and matched like the rule's own
+// selectors.
+func (e Exceptions) matchers() Matchers {
+	return Matchers{LabelSelectors: e.LabelSelectors, FieldSelectors: e.FieldSelectors, BooleanOperator: e.BooleanOperator}
+}
+
+func (e Exceptions) validate(rulelog zerolog.Logger) error {
+	if err := e.matchers().validate(rulelog); err != nil {
+		return fmt.Errorf("invalid exceptions: %v", err)
+	}
+	return nil
+}
+
+// matches is true when the object is one of the exceptions, unlike the rule's selectors exceptions without any
+// selectors never match.
+func (e Exceptions) matches(c *compiledMatchers, obj metaObject, fm map[string]string, mylog zerolog.Logger) (bool, error) {
+	if e.empty() || c == nil {
+		return false, nil
+	}
+	return e.matchers().matchSelectors(c, obj, fm, mylog)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExceptionsCarveObjectsOutOfRules(t *testing.T) {
+	system := []byte(`{"kind":"Namespace","metadata":{"name":"kube-system"}}`)
+	ignored := []byte(`{"kind":"Namespace","metadata":{"name":"team-a","labels":{"graffiti/ignore":"true"}}}`)
+	team := []byte(`{"kind":"Namespace","metadata":{"name":"team-b"}}`)
+	except := Exceptions{LabelSelectors: []string{"name in (kube-system,kube-public)", "graffiti/ignore=true"}}
+
+	tests := []struct {
+		name     string
+		matchers Matchers
+		expected []bool
+	}{
+		{"all objects but the exceptions", Matchers{Exceptions: except}, []bool{false, false, true}},
+		{"exceptions win over negate", Matchers{LabelSelectors: []string{"team"}, Negate: true, Exceptions: except}, []bool{false, false, true}},
+		{"exceptions combined with AND", Matchers{Exceptions: Exceptions{LabelSelectors: []string{"graffiti/ignore=true"}, FieldSelectors: []string{"metadata.name=team-b"}}}, []bool{true, true, true}},
+		{"exceptions combined with OR", Matchers{Exceptions: Exceptions{LabelSelectors: []string{"graffiti/ignore=true"}, FieldSelectors: []string{"metadata.name=team-b"}, BooleanOperator: OR}}, []bool{true, false, false}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.matchers.validate(log.Logger))
+			rule := Rule{Name: "test", Matchers: tc.matchers}
+			for i, object := range [][]byte{system, ignored, team} {
+				match, err := rule.Matches(object, nil)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected[i], match, "object %d", i)
+
+				match, err = rule.Compiled().Matches(object, nil)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected[i], match, "compiled object %d", i)
+			}
+		})
+	}
+}
+
+func TestExceptionsAreValidated(t *testing.T) {
+	err := Matchers{Exceptions: Exceptions{LabelSelectors: []string{"team in ("}}}.validate(log.Logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid exceptions: matcher contains an invalid label selector 'team in ('")
+}
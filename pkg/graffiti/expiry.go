@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ExpiresAtLabel is the name of the label that an expire payload stamps objects with, it is prefixed with
+// "graffiti.<company-domain>/" and its value is the time that the object expires in seconds since the unix epoch.
+const ExpiresAtLabel = "expires-at"
+
+const (
+	// ExpireActionDelete deletes objects once they have expired, this is the default.
+	ExpireActionDelete = "delete"
+	// ExpireActionLabel adds the expire payload's labels to objects once they have expired.
+	ExpireActionLabel = "label"
+)
+
+// Expiry gives the objects that a rule matches a time to live.  They are stamped with their expiry time when the
+// rule first paints them and the expiry controller deletes, or labels, those that have expired and still match.
+type Expiry struct {
+	TTL time.Duration `mapstructure:"ttl" yaml:"ttl,omitempty"`
+	// Action is what the expiry controller does to expired objects, either delete or label.
+	Action string `mapstructure:"action" yaml:"action,omitempty"`
+	// Labels are added to expired objects by the label action.
+	Labels map[string]string `mapstructure:"labels" yaml:"labels,omitempty"`
+}
+
+func (e Expiry) isEmpty() bool {
+	return e.TTL == 0
+}
+
+func (e Expiry) validate() error {
+	if e.isEmpty() {
+		if e.Action != "" || len(e.Labels) > 0 {
+			return fmt.Errorf("invalid expire: a ttl is required")
+		}
+		return nil
+	}
+	if e.TTL < time.Second {
+		return fmt.Errorf("invalid expire: ttl must be at least 1s")
+	}
+	switch e.Action {
+	case "", ExpireActionDelete:
+		if len(e.Labels) > 0 {
+			return fmt.Errorf("invalid expire: labels can only be added by the %s action", ExpireActionLabel)
+		}
+		return nil
+	case ExpireActionLabel:
+		if len(e.Labels) == 0 {
+			return fmt.Errorf("invalid expire: the %s action needs labels to add", ExpireActionLabel)
+		}
+		if err := validateAdditionsLabels(e.Labels); err != nil {
+			return fmt.Errorf("invalid expire: %v", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid expire: action '%s' must be either %s or %s", e.Action, ExpireActionDelete, ExpireActionLabel)
+}
+
+// ExpiryLabel is the label that holds the time that an object expires.
+func (p Payload) ExpiryLabel() string {
+	return p.annotationPrefix() + "/" + ExpiresAtLabel
+}
+
+// expiryLabels stamps an object that doesn't have an expiry time yet with one, the expiry time of an object never
+// changes once it has been stamped.
+func (p Payload) expiryLabels(obj metaObject, now time.Time) map[string]string {
+	if p.Expire.isEmpty() {
+		return nil
+	}
+	if _, ok := obj.Meta.Labels[p.ExpiryLabel()]; ok {
+		return nil
+	}
+	return map[string]string{p.ExpiryLabel(): strconv.FormatInt(now.Add(p.Expire.TTL).Unix(), 10)}
+}
+
+// Expired is true when the labels hold an expiry time that has passed.  Objects without one, or with an invalid one,
+// never expire.
+func (p Payload) Expired(labels map[string]string, now time.Time) bool {
+	value, ok := labels[p.ExpiryLabel()]
+	if !ok {
+		return false
+	}
+	at, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false
+	}
+	return !now.Before(time.Unix(at, 0))
+}
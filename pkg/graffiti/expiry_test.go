@@ -0,0 +1,64 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpireStampsObjectsWithAnExpiryTimeOnce(t *testing.T) {
+	rule := Rule{Name: "debug-namespaces", Payload: Payload{Expire: Expiry{TTL: 24 * time.Hour}}.WithCompanyDomain("acme.com")}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"Namespace","metadata":{"name":"debug-1"}}`))
+	require.NoError(t, err)
+	require.NotNil(t, patch)
+	assert.Contains(t, string(patch), `"path": "/metadata/labels"`)
+	assert.Contains(t, string(patch), `"graffiti.acme.com/expires-at": "`)
+
+	patch, err = rule.Mutate([]byte(`{"kind":"Namespace","metadata":{"name":"debug-1","labels":{"graffiti.acme.com/expires-at":"1591012800"}}}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "the expiry time is never extended")
+}
+
+func TestExpired(t *testing.T) {
+	p := Payload{Expire: Expiry{TTL: time.Hour}}
+	now := time.Now()
+	stamped := p.expiryLabels(metaObject{}, now)
+	require.Len(t, stamped, 1)
+	at, err := strconv.ParseInt(stamped["graffiti/expires-at"], 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour).Unix(), at)
+
+	assert.False(t, p.Expired(stamped, now))
+	assert.True(t, p.Expired(stamped, now.Add(2*time.Hour)))
+	assert.False(t, p.Expired(nil, now), "objects without an expiry time never expire")
+	assert.False(t, p.Expired(map[string]string{"graffiti/expires-at": "tomorrow"}, now))
+}
+
+func TestValidateExpire(t *testing.T) {
+	assert.NoError(t, Payload{Expire: Expiry{TTL: time.Hour}}.validate())
+	assert.NoError(t, Payload{Expire: Expiry{TTL: time.Hour, Action: ExpireActionLabel, Labels: map[string]string{"expired": "true"}}}.validate())
+	assert.Error(t, Payload{Expire: Expiry{TTL: time.Hour, Action: ExpireActionLabel}}.validate(), "the label action needs labels")
+	assert.Error(t, Payload{Expire: Expiry{TTL: time.Hour, Labels: map[string]string{"expired": "true"}}}.validate(), "only the label action adds labels")
+	assert.Error(t, Payload{Expire: Expiry{TTL: time.Hour, Action: "archive"}}.validate())
+	assert.Error(t, Payload{Expire: Expiry{TTL: time.Millisecond}}.validate())
+	assert.Error(t, Payload{Expire: Expiry{TTL: time.Hour}, Block: true}.validate())
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	admission "k8s.io/api/admission/v1beta1"
+)
+
+// Explanation says whether a rule matches the object of an admission request and how each of its selectors fared,
+// so that engineers testing a rule by hand can see why it did or didn't fire.
+type Explanation struct {
+	Rule      string           `json:"rule"`
+	Matched   bool             `json:"matched"`
+	Selectors []SelectorResult `json:"selectors,omitempty"`
+	Error     string           `json:"error,omitempty"`
+}
+
+// SelectorResult is whether one of the rule's label or field selectors matches the object on its own.
+type SelectorResult struct {
+	Type     string `json:"type"`
+	Selector string `json:"selector"`
+	Matched  bool   `json:"matched"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ExplainAdmission evaluates the rule against the object of an admission request.  Unlike matching, every selector is
+// evaluated, so the result shows all of those that matched and not only the first.
+func (r Rule) ExplainAdmission(req *admission.AdmissionRequest) Explanation {
+	e := Explanation{Rule: r.Name}
+	object, oldObject, _, err := admissionObjects(req)
+	if err != nil {
+		e.Error = "failed to extract object from admission request: " + err.Error()
+		return e
+	}
+	in, err := r.matchInputs(object, oldObject, &req.UserInfo)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	obj, fieldMap := in.meta, in.fields
+
+	labels := selectorLabels(obj)
+	for _, selector := range r.Matchers.LabelSelectors {
+		realSelector, negated := negatedSelector(selector, ValidateLabelSelector)
+		match, err := MatchLabelSelector(realSelector, labels)
+		e.Selectors = append(e.Selectors, selectorResult("label", selector, match != negated, err))
+	}
+	for _, selector := range r.Matchers.FieldSelectors {
+		realSelector, negated := negatedSelector(selector, nil)
+		match, err := matchFieldSelector(realSelector, fieldMap)
+		e.Selectors = append(e.Selectors, selectorResult("field", selector, match != negated, err))
+	}
+	for _, key := range r.Matchers.HasLabel {
+		_, ok := obj.Meta.Labels[key]
+		e.Selectors = append(e.Selectors, selectorResult("has-label", key, ok, nil))
+	}
+	for _, key := range r.Matchers.MissingLabel {
+		_, ok := obj.Meta.Labels[key]
+		e.Selectors = append(e.Selectors, selectorResult("missing-label", key, !ok, nil))
+	}
+	for _, path := range r.Matchers.HasField {
+		match, err := (Matchers{HasField: []string{path}}).matchesPresence(obj, in.object)
+		e.Selectors = append(e.Selectors, selectorResult("has-field", path, match, err))
+	}
+	for _, path := range r.Matchers.MissingField {
+		match, err := (Matchers{MissingField: []string{path}}).matchesPresence(obj, in.object)
+		e.Selectors = append(e.Selectors, selectorResult("missing-field", path, match, err))
+	}
+	for _, selector := range r.Matchers.Exceptions.LabelSelectors {
+		realSelector, negated := negatedSelector(selector, ValidateLabelSelector)
+		match, err := MatchLabelSelector(realSelector, labels)
+		e.Selectors = append(e.Selectors, selectorResult("exception-label", selector, match != negated, err))
+	}
+	for _, selector := range r.Matchers.Exceptions.FieldSelectors {
+		realSelector, negated := negatedSelector(selector, nil)
+		match, err := matchFieldSelector(realSelector, fieldMap)
+		e.Selectors = append(e.Selectors, selectorResult("exception-field", selector, match != negated, err))
+	}
+
+	e.Matched, err = r.matchesRequest(object, oldObject, &req.UserInfo)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
+
+func selectorResult(kind, selector string, match bool, err error) SelectorResult {
+	if err != nil {
+		return SelectorResult{Type: kind, Selector: selector, Error: err.Error()}
+	}
+	return SelectorResult{Type: kind, Selector: selector, Matched: match}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admission "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestExplainAdmissionEvaluatesEverySelector(t *testing.T) {
+	rule := Rule{
+		Name: "label-web",
+		Matchers: Matchers{
+			LabelSelectors: []string{"app = web", "team = a", "!tier = cache"},
+			FieldSelectors: []string{"metadata.namespace=default"},
+		},
+		Payload: Payload{Additions: Additions{Labels: map[string]string{"owner": "web"}}},
+	}
+	req := &admission.AdmissionRequest{
+		Operation: admission.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind":"Pod","metadata":{"name":"web","namespace":"default","labels":{"app":"web","team":"a"}}}`)},
+	}
+
+	e := rule.ExplainAdmission(req)
+	assert.Equal(t, Explanation{
+		Rule:    "label-web",
+		Matched: true,
+		Selectors: []SelectorResult{
+			{Type: "label", Selector: "app = web", Matched: true},
+			{Type: "label", Selector: "team = a", Matched: true},
+			{Type: "label", Selector: "!tier = cache", Matched: true},
+			{Type: "field", Selector: "metadata.namespace=default", Matched: true},
+		},
+	}, e)
+
+	rule.Matchers.BooleanOperator = XOR
+	assert.False(t, rule.ExplainAdmission(req).Matched, "the result is that of the rule, not of any one selector")
+}
+
+func TestExplainAdmissionReportsErrors(t *testing.T) {
+	rule := Rule{Name: "broken", Matchers: Matchers{LabelSelectors: []string{"app = web"}}}
+	e := rule.ExplainAdmission(&admission.AdmissionRequest{Object: runtime.RawExtension{Raw: []byte(`not json`)}})
+	assert.False(t, e.Matched)
+	assert.NotEmpty(t, e.Error)
+	assert.Empty(t, e.Selectors)
+}
@@ -0,0 +1,234 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/egress"
+	"github.com/rs/zerolog"
+)
+
+// The defaults of the external matcher's settings, which are used when they are 0.
+const (
+	DefaultExternalTimeout          = 2 * time.Second
+	DefaultExternalCacheTTL         = time.Minute
+	DefaultExternalFailureThreshold = 5
+	DefaultExternalOpenFor          = 30 * time.Second
+
+	// maxCachedDecisions limits the decisions cached for each endpoint.
+	maxCachedDecisions = 10000
+	// maxExternalResponseSize limits how much of an endpoint's response is read.
+	maxExternalResponseSize = 64 << 10
+)
+
+// ExternalMatcher asks an http endpoint whether an object matches, e.g. to check a CMDB that a namespace's owner is
+// valid.  The object, and the old object of an update, are POSTed to the url as {"object": ..., "oldObject": ...}
+// and the endpoint answers with {"match": true} or {"match": false}.  Its decisions are cached, and a circuit
+// breaker stops calling an endpoint that keeps failing for a while, DefaultMatch is the decision while the endpoint
+// fails or its circuit is open.
+type ExternalMatcher struct {
+	URL     string        `mapstructure:"url" yaml:"url,omitempty"`
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	// CacheTTL is how long a decision about an object is reused for.
+	CacheTTL time.Duration `mapstructure:"cache-ttl" yaml:"cache-ttl,omitempty"`
+	// FailureThreshold is the number of failures in a row that open the endpoint's circuit, which stays open for
+	// OpenFor before the endpoint is tried again.
+	FailureThreshold int           `mapstructure:"failure-threshold" yaml:"failure-threshold,omitempty"`
+	OpenFor          time.Duration `mapstructure:"open-for" yaml:"open-for,omitempty"`
+	DefaultMatch     bool          `mapstructure:"default-match" yaml:"default-match,omitempty"`
+}
+
+func (e ExternalMatcher) empty() bool {
+	return e.URL == ""
+}
+
+func (e ExternalMatcher) validate() error {
+	if e.empty() {
+		return nil
+	}
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url '%s': %v", e.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url '%s', must be an absolute http or https url", e.URL)
+	}
+	if e.Timeout < 0 || e.CacheTTL < 0 || e.OpenFor < 0 || e.FailureThreshold < 0 {
+		return fmt.Errorf("timeout, cache-ttl, failure-threshold and open-for can not be negative")
+	}
+	return nil
+}
+
+// externalRequest is what is POSTed to an external matcher's endpoint.
+type externalRequest struct {
+	Object    json.RawMessage `json:"object"`
+	OldObject json.RawMessage `json:"oldObject,omitempty"`
+}
+
+// externalResponse is what an external matcher's endpoint answers with.
+type externalResponse struct {
+	Match *bool `json:"match"`
+}
+
+// externalEndpoint is the cache and circuit breaker of an external matcher's endpoint.  It is shared by every rule
+// that uses the same matcher settings, as rules are copied.
+type externalEndpoint struct {
+	client    *http.Client
+	mu        sync.Mutex
+	decisions map[[sha256.Size]byte]cachedDecision
+	failures  int
+	openUntil time.Time
+}
+
+type cachedDecision struct {
+	match   bool
+	expires time.Time
+}
+
+// externalEndpoints holds the externalEndpoint of each external matcher, keyed by its settings.
+var externalEndpoints sync.Map
+
+func (e ExternalMatcher) endpoint() *externalEndpoint {
+	if ep, ok := externalEndpoints.Load(e); ok {
+		return ep.(*externalEndpoint)
+	}
+	ep, _ := externalEndpoints.LoadOrStore(e, &externalEndpoint{
+		client:    egress.HTTPClient(e.timeout()),
+		decisions: make(map[[sha256.Size]byte]cachedDecision),
+	})
+	return ep.(*externalEndpoint)
+}
+
+func (e ExternalMatcher) timeout() time.Duration {
+	if e.Timeout == 0 {
+		return DefaultExternalTimeout
+	}
+	return e.Timeout
+}
+
+func (e ExternalMatcher) cacheTTL() time.Duration {
+	if e.CacheTTL == 0 {
+		return DefaultExternalCacheTTL
+	}
+	return e.CacheTTL
+}
+
+func (e ExternalMatcher) failureThreshold() int {
+	if e.FailureThreshold == 0 {
+		return DefaultExternalFailureThreshold
+	}
+	return e.FailureThreshold
+}
+
+func (e ExternalMatcher) openFor() time.Duration {
+	if e.OpenFor == 0 {
+		return DefaultExternalOpenFor
+	}
+	return e.OpenFor
+}
+
+// matches asks the endpoint whether the object matches, unless the decision is cached.  A failure to get a decision
+// is logged and the default decision is used instead, it is never an error.
+func (e ExternalMatcher) matches(object, oldObject []byte, mylog zerolog.Logger) bool {
+	rlog := mylog.With().Str("url", e.URL).Logger()
+	body, err := json.Marshal(externalRequest{Object: object, OldObject: oldObject})
+	if err != nil {
+		rlog.Warn().Err(err).Bool("default-match", e.DefaultMatch).Msg("can't create the external matcher request, using the default decision")
+		return e.DefaultMatch
+	}
+	key := sha256.Sum256(body)
+	ep := e.endpoint()
+	now := time.Now()
+
+	ep.mu.Lock()
+	if d, ok := ep.decisions[key]; ok && now.Before(d.expires) {
+		ep.mu.Unlock()
+		rlog.Debug().Bool("match", d.match).Msg("using the cached external matcher decision")
+		return d.match
+	}
+	if now.Before(ep.openUntil) {
+		ep.mu.Unlock()
+		rlog.Debug().Bool("default-match", e.DefaultMatch).Msg("external matcher's circuit is open, using the default decision")
+		return e.DefaultMatch
+	}
+	ep.mu.Unlock()
+
+	match, err := e.ask(ep.client, body)
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	if err != nil {
+		ep.failures++
+		if ep.failures >= e.failureThreshold() {
+			ep.openUntil = time.Now().Add(e.openFor())
+			rlog.Error().Err(err).Int("failures", ep.failures).Dur("open-for", e.openFor()).Msg("external matcher keeps failing, opening its circuit")
+		}
+		rlog.Warn().Err(err).Bool("default-match", e.DefaultMatch).Msg("external matcher failed, using the default decision")
+		return e.DefaultMatch
+	}
+	ep.failures = 0
+	ep.cache(key, cachedDecision{match: match, expires: now.Add(e.cacheTTL())}, now)
+	rlog.Debug().Bool("match", match).Msg("external matcher decided")
+	return match
+}
+
+// ask POSTs the request body to the endpoint and returns its decision.
+func (e ExternalMatcher) ask(client *http.Client, body []byte) (bool, error) {
+	resp, err := client.Post(e.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxExternalResponseSize))
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("external matcher responded with %s", resp.Status)
+	}
+	var decision externalResponse
+	if err := json.Unmarshal(data, &decision); err != nil {
+		return false, fmt.Errorf("invalid external matcher response: %v", err)
+	}
+	if decision.Match == nil {
+		return false, fmt.Errorf("invalid external matcher response: it has no match")
+	}
+	return *decision.Match, nil
+}
+
+// cache keeps a decision, making room by dropping the expired decisions, or all of them, when the cache is full.
+// It must be called with the endpoint locked.
+func (ep *externalEndpoint) cache(key [sha256.Size]byte, d cachedDecision, now time.Time) {
+	if len(ep.decisions) >= maxCachedDecisions {
+		for k, cached := range ep.decisions {
+			if !now.Before(cached.expires) {
+				delete(ep.decisions, k)
+			}
+		}
+		if len(ep.decisions) >= maxCachedDecisions {
+			ep.decisions = make(map[[sha256.Size]byte]cachedDecision)
+		}
+	}
+	ep.decisions[key] = d
+}
@@ -0,0 +1,112 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cmdb is a fake external matcher endpoint which matches namespaces owned by alice, and counts its calls.
+func cmdb(t *testing.T, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		var req struct {
+			Object struct {
+				Metadata struct {
+					Labels map[string]string `json:"labels"`
+				} `json:"metadata"`
+			} `json:"object"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		json.NewEncoder(w).Encode(map[string]bool{"match": req.Object.Metadata.Labels["owner"] == "alice"})
+	}))
+}
+
+func TestExternalMatcherDecidesAndIsCached(t *testing.T) {
+	var calls int32
+	server := cmdb(t, &calls)
+	defer server.Close()
+
+	rule := NewRule("valid-owners").MatchLabels("owner").AddLabels(map[string]string{"owner-valid": "true"})
+	rule.Matchers.External = ExternalMatcher{URL: server.URL}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	alice := []byte(`{"kind":"Namespace","metadata":{"name":"team-a","labels":{"owner":"alice"}}}`)
+	match, err := rule.Matches(alice, nil)
+	require.NoError(t, err)
+	assert.True(t, match)
+	match, err = rule.Matches([]byte(`{"kind":"Namespace","metadata":{"name":"team-b","labels":{"owner":"mallory"}}}`), nil)
+	require.NoError(t, err)
+	assert.False(t, match)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	match, err = rule.Matches(alice, nil)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "the decision about alice's namespace should be cached")
+
+	match, err = rule.Matches([]byte(`{"kind":"Namespace","metadata":{"name":"unowned"}}`), nil)
+	require.NoError(t, err)
+	assert.False(t, match)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "objects that the selectors don't match are never sent")
+}
+
+func TestExternalMatcherCircuitBreaker(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	e := ExternalMatcher{URL: server.URL, FailureThreshold: 2, OpenFor: 50 * time.Millisecond, DefaultMatch: true}
+	for i := 0; i < 5; i++ {
+		assert.True(t, e.matches([]byte(`{"kind":"Namespace"}`), nil, log.Logger), "failures use the default decision")
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "the circuit should open after two failures")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, e.matches([]byte(`{"kind":"Namespace"}`), nil, log.Logger))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "the endpoint is tried again once the circuit has been open for a while")
+}
+
+func TestExternalMatcherTimesOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"match":true}`))
+	}))
+	defer server.Close()
+
+	e := ExternalMatcher{URL: server.URL, Timeout: 20 * time.Millisecond}
+	start := time.Now()
+	assert.False(t, e.matches([]byte(`{"kind":"Namespace"}`), nil, log.Logger))
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+}
+
+func TestValidateExternalMatcher(t *testing.T) {
+	assert.NoError(t, ExternalMatcher{}.validate())
+	assert.NoError(t, ExternalMatcher{URL: "https://cmdb.acme.com/owners"}.validate())
+	assert.Error(t, ExternalMatcher{URL: "cmdb.acme.com/owners"}.validate())
+	assert.Error(t, ExternalMatcher{URL: "ftp://cmdb.acme.com"}.validate())
+	assert.Error(t, ExternalMatcher{URL: "https://cmdb.acme.com", Timeout: -time.Second}.validate())
+}
@@ -46,6 +46,21 @@ func makeFieldMapFromRawObject(raw []byte) (map[string]string, error) {
 	return fieldMap, nil
 }
 
+// addOldObjectFields adds the fields of the object that an update replaces, or a delete removes, to the field map
+// prefixed with "oldObject.", so that selectors and templates can refer to them.
+func addOldObjectFields(fm map[string]string, oldObject []byte) {
+	if len(oldObject) == 0 {
+		return
+	}
+	oldFields, err := makeFieldMapFromRawObject(oldObject)
+	if err != nil {
+		return
+	}
+	for k, v := range oldFields {
+		fm["oldObject."+k] = v
+	}
+}
+
 func addFieldRecursive(fm map[string]string, prefix, k string, v interface{}) {
 	mylog := log.ComponentLogger(componentName, "addFieldRecursive")
 
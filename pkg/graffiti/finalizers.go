@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Finalizers adds and removes entries of an object's metadata.finalizers, such as a finalizer that protects it from
+// being deleted until a controller has cleaned up after it.  Finalizers that the object already has aren't added again.
+type Finalizers struct {
+	Add    []string `mapstructure:"add" yaml:"add,omitempty"`
+	Remove []string `mapstructure:"remove" yaml:"remove,omitempty"`
+}
+
+func (f Finalizers) isEmpty() bool {
+	return len(f.Add) == 0 && len(f.Remove) == 0
+}
+
+func (f Finalizers) validate() error {
+	removed := make(map[string]bool)
+	for _, name := range f.Remove {
+		removed[name] = true
+	}
+	for _, name := range append(append([]string{}, f.Add...), f.Remove...) {
+		if errs := utilvalidation.IsQualifiedName(name); len(errs) > 0 {
+			return fmt.Errorf("invalid finalizer '%s': %s", name, strings.Join(errs, ", "))
+		}
+	}
+	for _, name := range f.Add {
+		if removed[name] {
+			return fmt.Errorf("finalizer '%s' can't be both added and removed", name)
+		}
+	}
+	return nil
+}
+
+// patchOperand returns the patch operation that changes the object's finalizers, or an empty string when they
+// already are what the payload asks for.
+func (f Finalizers) patchOperand(current []string) (string, error) {
+	removed := make(map[string]bool)
+	for _, name := range f.Remove {
+		removed[name] = true
+	}
+	present := make(map[string]bool)
+	modified := []string{}
+	for _, name := range current {
+		present[name] = true
+		if !removed[name] {
+			modified = append(modified, name)
+		}
+	}
+	for _, name := range f.Add {
+		if !present[name] {
+			present[name] = true
+			modified = append(modified, name)
+		}
+	}
+	if equalStrings(modified, current) {
+		return "", nil
+	}
+	return listPatchOperand("/metadata/finalizers", len(current) > 0, len(modified) == 0, modified)
+}
+
+// listPatchOperand adds, replaces or, when it is left empty, removes a list in the object's metadata.
+func listPatchOperand(path string, exists, empty bool, list interface{}) (string, error) {
+	op := patchOperation{Op: "add", Path: path, Value: list}
+	switch {
+	case exists && empty:
+		op = patchOperation{Op: "remove", Path: path}
+	case exists:
+		op.Op = "replace"
+	case empty:
+		return "", nil
+	}
+	b, err := json.Marshal(op)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFinalizersAreAddedAndRemovedIdempotently(t *testing.T) {
+	rule := Rule{Name: "protect", Payload: Payload{Finalizers: Finalizers{Add: []string{"acme.com/protect"}, Remove: []string{"acme.com/legacy"}}}}
+
+	patch, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"settings"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/finalizers","value":["acme.com/protect"]}]`, string(patch))
+
+	patch, err = rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"settings","finalizers":["kubernetes","acme.com/legacy"]}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"replace","path":"/metadata/finalizers","value":["kubernetes","acme.com/protect"]}]`, string(patch))
+
+	patch, err = rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"settings","finalizers":["acme.com/protect"]}}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "an object that already has the finalizer isn't patched")
+
+	remove := Rule{Name: "unprotect", Payload: Payload{Finalizers: Finalizers{Remove: []string{"acme.com/protect"}}}}
+	patch, err = remove.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"settings","finalizers":["acme.com/protect"]}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"remove","path":"/metadata/finalizers"}]`, string(patch))
+}
+
+func TestFinalizersValidation(t *testing.T) {
+	assert.NoError(t, Payload{Finalizers: Finalizers{Add: []string{"acme.com/protect"}}}.validate())
+	assert.Error(t, Payload{Finalizers: Finalizers{Add: []string{"not a finalizer"}}}.validate())
+	assert.EqualError(t, Payload{Finalizers: Finalizers{Add: []string{"acme.com/x"}, Remove: []string{"acme.com/x"}}}.validate(),
+		"finalizer 'acme.com/x' can't be both added and removed")
+	assert.Error(t, Payload{Finalizers: Finalizers{Add: []string{"acme.com/x"}}, Block: true}.validate())
+}
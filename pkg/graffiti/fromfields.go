@@ -0,0 +1,154 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// fieldPathKey converts a json path on the object, such as '.metadata.annotations["billing/cc"]' or
+// '.spec.containers[0].image', into its key in the object's field map, e.g. 'metadata.annotations.billing/cc'.  Fields
+// are either '.name' or quoted in brackets, '["name"]' or ['name'], which they must be when they contain dots or
+// brackets, and list items are '[index]'.
+func fieldPathKey(path string) (string, error) {
+	parts, err := fieldPath(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// fieldPath splits a json path on the object into the names of its fields and the indexes of its list items.
+func fieldPath(path string) ([]string, error) {
+	var parts []string
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid field path '%s': empty field name", path)
+			}
+			parts = append(parts, rest[1:end+1])
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path '%s': missing ]", path)
+			}
+			inner := rest[1:end]
+			switch {
+			case strings.HasPrefix(inner, "'"):
+				// a single quoted name can't contain a ], nor a quote, as it has no escapes
+				if len(inner) < 3 || !strings.HasSuffix(inner, "'") || strings.Contains(inner[1:len(inner)-1], "'") {
+					return nil, fmt.Errorf("invalid field path '%s': bad quoted field name %s", path, inner)
+				}
+				parts = append(parts, inner[1:len(inner)-1])
+			case strings.HasPrefix(inner, `"`):
+				// a double quoted name is a quoted string, which can contain a ], so its closing quote is found instead
+				closing := closingQuote(rest[1:])
+				if closing < 0 || !strings.HasPrefix(rest[closing+2:], "]") {
+					return nil, fmt.Errorf("invalid field path '%s': missing \"]", path)
+				}
+				end = closing + 2
+				name, err := strconv.Unquote(rest[1:end])
+				if err != nil || name == "" {
+					return nil, fmt.Errorf("invalid field path '%s': bad quoted field name %s", path, rest[1:end])
+				}
+				parts = append(parts, name)
+			default:
+				if _, err := strconv.ParseUint(inner, 10, 32); err != nil {
+					return nil, fmt.Errorf("invalid field path '%s': list index %s is not a number", path, inner)
+				}
+				parts = append(parts, inner)
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid field path '%s': expected . or [ at '%s'", path, rest)
+		}
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("invalid field path '%s': no fields", path)
+	}
+	return parts, nil
+}
+
+// closingQuote returns the index of the quote that closes the quoted string at the start of s, skipping escaped
+// characters, or -1 when it isn't closed.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// validateLabelsFromFields checks that the labels-from-fields keys are valid label keys and their paths can be parsed.
+func validateLabelsFromFields(labels map[string]string) error {
+	for k, path := range labels {
+		if errorList := utilvalidation.IsQualifiedName(k); len(errorList) != 0 {
+			return fmt.Errorf("invalid labels-from-fields: invalid label key \"%s\": %s", k, strings.Join(errorList, "; "))
+		}
+		if _, err := fieldPathKey(path); err != nil {
+			return fmt.Errorf("invalid labels-from-fields: %v", err)
+		}
+	}
+	return nil
+}
+
+// labelsFromFields copies the values of the object's fields into labels.  A field that the object doesn't have, or
+// whose value isn't a valid label value, is skipped so that the object keeps any label that it already has.
+func (p Payload) labelsFromFields(fm map[string]string, logger zerolog.Logger) map[string]string {
+	if len(p.LabelsFromFields) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(p.LabelsFromFields))
+	for k := range p.LabelsFromFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	labels := make(map[string]string)
+	for _, k := range keys {
+		field, err := fieldPathKey(p.LabelsFromFields[k])
+		if err != nil {
+			logger.Error().Err(err).Str("label", k).Msg("can't copy field into label")
+			continue
+		}
+		v, ok := fm[field]
+		if !ok {
+			logger.Debug().Str("label", k).Str("field", field).Msg("object doesn't have the field to copy into label")
+			continue
+		}
+		if errorList := utilvalidation.IsValidLabelValue(v); len(errorList) != 0 {
+			logger.Warn().Str("label", k).Str("field", field).Str("value", v).Str("reason", strings.Join(errorList, "; ")).Msg("field's value is not a valid label value, not copying it")
+			continue
+		}
+		labels[k] = v
+	}
+	return labels
+}
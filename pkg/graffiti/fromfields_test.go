@@ -0,0 +1,99 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldPathKeys(t *testing.T) {
+	for path, key := range map[string]string{
+		`.metadata.name`:                       "metadata.name",
+		`$.metadata.name`:                      "metadata.name",
+		`.metadata.annotations["billing/cc"]`:  "metadata.annotations.billing/cc",
+		`.metadata.annotations['billing/cc']`:  "metadata.annotations.billing/cc",
+		`.metadata.annotations["acme.com/cc"]`: "metadata.annotations.acme.com/cc",
+		`.metadata.annotations["odd]\"key"]`:   "metadata.annotations.odd]\"key",
+		`.spec.containers[0].image`:            "spec.containers.0.image",
+		`["metadata"]["labels"]["app"]`:        "metadata.labels.app",
+		` .metadata.labels.app `:               "metadata.labels.app",
+	} {
+		actual, err := fieldPathKey(path)
+		if assert.NoError(t, err, path) {
+			assert.Equal(t, key, actual, path)
+		}
+	}
+
+	for path, expected := range map[string]string{
+		``:                                   "invalid field path '': no fields",
+		`metadata.name`:                      "invalid field path 'metadata.name': expected . or [ at 'metadata.name'",
+		`.metadata..name`:                    "invalid field path '.metadata..name': empty field name",
+		`.metadata.annotations["billing/cc"`: "invalid field path '.metadata.annotations[\"billing/cc\"': missing ]",
+		`.metadata.annotations["billing/cc]`: "invalid field path '.metadata.annotations[\"billing/cc]': missing \"]",
+		`.metadata.annotations['']`:          "invalid field path '.metadata.annotations['']': bad quoted field name ''",
+		`.spec.containers[first]`:            "invalid field path '.spec.containers[first]': list index first is not a number",
+	} {
+		_, err := fieldPathKey(path)
+		assert.EqualError(t, err, expected, path)
+	}
+}
+
+func TestLabelsFromFieldsCopiesAnnotationsIntoLabels(t *testing.T) {
+	rule := NewRule("normalise").
+		AddLabels(map[string]string{"cost-center": "unknown", "managed": "true"}).
+		AddLabelsFromFields(map[string]string{
+			"cost-center": `.metadata.annotations["billing/cc"]`,
+			"image":       ".spec.containers[0].name",
+			"owner":       `.metadata.annotations["legacy/owner"]`,
+			"description": `.metadata.annotations["legacy/description"]`,
+		})
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"Pod","metadata":{"name":"web","labels":{"app":"web"},"annotations":{"billing/cc":"cc-1234","legacy/description":"not a label value!"}},"spec":{"containers":[{"name":"nginx"}]}}`))
+	require.NoError(t, err)
+
+	var ops []struct {
+		Path  string            `json:"path"`
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 1)
+	assert.Equal(t, "/metadata/labels", ops[0].Path)
+	assert.Equal(t, map[string]string{
+		"app":         "web",
+		"managed":     "true",
+		"cost-center": "cc-1234",
+		"image":       "nginx",
+	}, ops[0].Value, "copied fields replace additions, and missing fields or invalid values are skipped")
+
+	patch, err = rule.Mutate([]byte(`{"kind":"Pod","metadata":{"name":"web"}}`))
+	require.NoError(t, err)
+	assert.Contains(t, string(patch), `"cost-center": "unknown"`, "additions are the defaults for fields that the object doesn't have")
+}
+
+func TestLabelsFromFieldsAreValidated(t *testing.T) {
+	err := NewRule("bad").AddLabelsFromFields(map[string]string{"cost center": ".metadata.name"}).Validate(log.Logger)
+	assert.Contains(t, err.Error(), "invalid labels-from-fields: invalid label key \"cost center\"")
+
+	err = NewRule("bad").AddLabelsFromFields(map[string]string{"cost-center": "metadata.name"}).Validate(log.Logger)
+	assert.EqualError(t, err, "rule 'bad' failed validation: invalid labels-from-fields: invalid field path 'metadata.name': expected . or [ at 'metadata.name'")
+
+	p := Payload{PrefixKeys: true, LabelsFromFields: map[string]string{"cost-center": ".metadata.name"}}.WithKeyPrefix("acme.com")
+	assert.Equal(t, map[string]string{"acme.com/cost-center": ".metadata.name"}, p.LabelsFromFields)
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"text/template/parse"
+	"time"
+)
+
+// generators are the template functions whose values are different every time that they are called, our own and
+// sprig's.  An addition whose template calls one is only added to objects which don't already have its key, so that
+// the value is generated once rather than replaced on every update and every check of existing objects.
+var generators = map[string]bool{
+	"uuid": true, "uuidv4": true, "now": true,
+	"randAlphaNum": true, "randAlpha": true, "randNumeric": true, "randAscii": true, "randBytes": true, "randInt": true,
+}
+
+// now is the current time, or with a layout the current time in UTC formatted with the layout, e.g.
+// {{ now "2006-01-02" }}.  Without one it can be piped into sprig's date functions like sprig's own now.
+func now(layout ...string) (interface{}, error) {
+	switch len(layout) {
+	case 0:
+		return time.Now(), nil
+	case 1:
+		return time.Now().UTC().Format(layout[0]), nil
+	default:
+		return nil, fmt.Errorf("now takes at most one layout, not %d", len(layout))
+	}
+}
+
+// generatesValue is true when the template calls any of the generators.  A template that can't be parsed doesn't.
+func generatesValue(field string) bool {
+	tmpl, err := parseTemplate(field)
+	if err != nil || tmpl.Tree == nil {
+		return false
+	}
+	return callsGenerator(tmpl.Tree.Root)
+}
+
+// callsGenerator walks the parsed template looking for a call of one of the generators.
+func callsGenerator(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if callsGenerator(child) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return callsGenerator(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if callsGenerator(cmd) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if callsGenerator(arg) {
+				return true
+			}
+		}
+	case *parse.IdentifierNode:
+		return generators[n.Ident]
+	case *parse.IfNode:
+		return callsGenerator(n.Pipe) || callsGenerator(n.List) || callsGenerator(n.ElseList)
+	case *parse.RangeNode:
+		return callsGenerator(n.Pipe) || callsGenerator(n.List) || callsGenerator(n.ElseList)
+	case *parse.WithNode:
+		return callsGenerator(n.Pipe) || callsGenerator(n.List) || callsGenerator(n.ElseList)
+	case *parse.TemplateNode:
+		return callsGenerator(n.Pipe)
+	}
+	return false
+}
+
+// GeneratesValues is true when any of the rule's additions generate their values, so that its patches differ each
+// time even for the same object.
+func (r Rule) GeneratesValues() bool {
+	for _, values := range []map[string]string{r.Payload.Additions.Labels, r.Payload.Additions.Annotations} {
+		for _, v := range values {
+			if generatesValue(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Cacheable is false when the rule's patches depend on more than the object and who made the request, so that the
+// patch made for one request can't be given to another for the same object: when its additions generate their
+// values, or it records the request's uid or the time in the creator, provenance, stamp or expiry of the object.
+func (r Rule) Cacheable() bool {
+	p := r.Payload
+	return !r.GeneratesValues() && !p.RecordCreator && len(p.RecordProvenance) == 0 && !p.Stamp && p.Expire.isEmpty()
+}
+
+// renderAdditions renders the templates of the additions, keeping the current values of the keys whose templates
+// generate their values.
+func renderAdditions(add, current, fm map[string]string) (map[string]string, error) {
+	rendered, err := renderMapValues(add, fm)
+	if err != nil {
+		return rendered, err
+	}
+	for k, v := range add {
+		if previous, ok := current[k]; ok && generatesValue(v) {
+			rendered[k] = previous
+		}
+	}
+	return rendered, nil
+}
@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratorFunctions(t *testing.T) {
+	id, err := renderStringTemplate("{{ uuid }}", nil)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$`), id)
+	other, err := renderStringTemplate("{{ uuid }}", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, id, other)
+
+	day, err := renderStringTemplate(`{{ now "2006-01-02" }}`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Now().UTC().Format("2006-01-02"), day)
+	year, err := renderStringTemplate(`{{ now | date "2006" }}`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, time.Now().Format("2006"), year, "now without a layout still works with sprig's date")
+	_, err = renderStringTemplate(`{{ now "2006" "01" }}`, nil)
+	assert.Error(t, err)
+
+	random, err := renderStringTemplate("{{ randAlphaNum 8 }}", nil)
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-zA-Z0-9]{8}$`), random)
+}
+
+func TestGeneratesValue(t *testing.T) {
+	assert.True(t, generatesValue("{{ uuid }}"))
+	assert.True(t, generatesValue(`deploy-{{ now "20060102" }}`))
+	assert.True(t, generatesValue(`{{ if index . "metadata.name" }}{{ randAlphaNum 8 | lower }}{{ end }}`))
+	assert.False(t, generatesValue(`{{ index . "metadata.name" }}`))
+	assert.False(t, generatesValue(`{{ shard 4 (index . "metadata.name") }}`))
+	assert.False(t, generatesValue("plain"))
+	assert.False(t, generatesValue("{{ broken"))
+
+	assert.True(t, NewRule("ids").AddLabels(map[string]string{"id": "{{ randAlphaNum 8 }}"}).GeneratesValues())
+	assert.False(t, NewRule("teams").AddLabels(map[string]string{"team": "a"}).GeneratesValues())
+
+	assert.True(t, NewRule("teams").AddLabels(map[string]string{"team": "a"}).Cacheable())
+	assert.False(t, NewRule("ids").AddLabels(map[string]string{"id": "{{ randAlphaNum 8 }}"}).Cacheable())
+	for _, payload := range []Payload{{RecordCreator: true}, {RecordProvenance: []string{ProvenanceTimestamp}}, {Stamp: true}, {Expire: Expiry{TTL: time.Hour}}} {
+		assert.False(t, Rule{Payload: payload}.Cacheable(), "a rule that records the request or the time can't reuse its patches")
+	}
+}
+
+func TestGeneratedValuesAreOnlyAddedOnce(t *testing.T) {
+	rule := NewRule("ids").AddAnnotations(map[string]string{"acme.com/id": "{{ uuid }}", "acme.com/team": "platform"})
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web"}}`))
+	require.NoError(t, err)
+	var ops []struct {
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 1)
+	id := ops[0].Value["acme.com/id"]
+	assert.NotEmpty(t, id)
+
+	patch, err = rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web","annotations":{"acme.com/id":"` + id + `","acme.com/team":"platform"}}}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "an object that already has a generated value keeps it")
+
+	patch, err = rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web","annotations":{"acme.com/id":"` + id + `"}}}`))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	assert.Equal(t, map[string]string{"acme.com/id": id, "acme.com/team": "platform"}, ops[0].Value)
+}
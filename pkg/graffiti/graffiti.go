@@ -18,16 +18,24 @@ package graffiti
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/Telefonica/kube-graffiti/pkg/log"
 	"github.com/rs/zerolog"
 	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 const (
 	componentName = "grafitti"
+	// RuleTypeMutating rules patch (or block) the objects that they match, this is the default.
+	RuleTypeMutating = "mutating"
+	// RuleTypeValidating rules never patch, they deny any object that they match.
+	RuleTypeValidating = "validating"
 )
 
 // BooleanOperator defines the logical boolean operator applied to label and field selector results.
@@ -44,13 +52,31 @@ const (
 // It does not have mapstructure tags because it is not directly marshalled from config
 type Rule struct {
 	Name     string   `yaml:"name,omitempty"`
+	Type     string   `yaml:"type,omitempty"`
 	Matchers Matchers `yaml:"matchers,omitempty"`
 	Payload  Payload  `yaml:"payload,omitempty"`
+	// Priority orders the rules that can match the same object, rules with a higher priority come first.  It orders
+	// their webhook configurations, so that the apiserver calls them in the same order, as well as stop-on-match and
+	// the checks of existing objects.
+	Priority int `yaml:"priority,omitempty"`
+	// StopOnMatch skips the rules with a lower priority for the objects that this rule matches.
+	StopOnMatch bool `yaml:"stop-on-match,omitempty"`
+	// Rego is a policy which must also decide that the object matches, and can emit labels and annotations to add.
+	Rego Rego `yaml:"rego,omitempty"`
+	// compiled are the matchers compiled when the rule was loaded, see Compiled.
+	compiled *compiledMatchers
+	// namespaces looks up the namespaces that the payload inherits from, instead of the package's namespaceLookup.
+	namespaces NamespaceLookup
+	// quotas looks up the quotas that the payload enriches namespaces with, instead of the package's quotaLookup.
+	quotas QuotaLookup
 }
 
 // metaObject is used only for pulling out object metadata
 type metaObject struct {
 	Meta metav1.ObjectMeta `json:"metadata"`
+	// missing is true when the object has no metadata at all, as some objects served by aggregated api servers don't,
+	// so a patch has to add it before it can add any labels or annotations.
+	missing bool
 }
 
 // Validate - validates the matchers and payload of a graffiti rule
@@ -58,35 +84,128 @@ func (r Rule) Validate(rulelog zerolog.Logger) (err error) {
 	if err = r.Matchers.validate(rulelog); err != nil {
 		return fmt.Errorf("rule '%s' failed validation: %v", r.Name, err)
 	}
-	if err = r.Payload.validate(); err != nil {
-		return fmt.Errorf("rule '%s' failed validation: %v", r.Name, err)
+	if err = r.Rego.validate(); err != nil {
+		rulelog.Error().Err(err).Msg("rule contains an invalid rego policy")
+		return fmt.Errorf("rule '%s' failed validation: invalid rego policy: %v", r.Name, err)
+	}
+	switch r.Type {
+	case "", RuleTypeMutating:
+		if r.Payload.Stamp {
+			if err = validateStamp(r.Name); err != nil {
+				return fmt.Errorf("rule '%s' failed validation: %v", r.Name, err)
+			}
+		}
+		// a rego policy can emit all of the additions itself
+		if !r.Rego.empty() && r.Payload.isEmpty() {
+			return nil
+		}
+		if err = r.Payload.validate(); err != nil {
+			return fmt.Errorf("rule '%s' failed validation: %v", r.Name, err)
+		}
+	case RuleTypeValidating:
+		if !r.Payload.isEmpty() {
+			return fmt.Errorf("rule '%s' failed validation: a validating rule can not have a payload", r.Name)
+		}
+		if r.StopOnMatch {
+			return fmt.Errorf("rule '%s' failed validation: a validating rule can not stop-on-match", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule '%s' failed validation: invalid type '%s', must be either mutating or validating", r.Name, r.Type)
 	}
 	return nil
 }
 
+// IsValidating returns true for rules that only allow or deny objects.
+func (r Rule) IsValidating() bool {
+	return r.Type == RuleTypeValidating
+}
+
+// Precedes is true when the rule is evaluated before the other rule, which is when it has a higher priority or the
+// same priority and a name that sorts first, so that the order is always the same.
+func (r Rule) Precedes(other Rule) bool {
+	if r.Priority != other.Priority {
+		return r.Priority > other.Priority
+	}
+	return r.Name < other.Name
+}
+
+// SortRules sorts rules into the order that they are evaluated in, see Precedes.
+func SortRules(rules []Rule) {
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Precedes(rules[j]) })
+}
+
 // MutateAdmission takes an admission request and generates an admission response based on the response from Mutate.
 // It implements the graffitiMutator interface and so can be added to the webhook handler's tagmap
 func (r Rule) MutateAdmission(req *admission.AdmissionRequest) *admission.AdmissionResponse {
 	mylog := log.ComponentLogger(componentName, "MutateAdmission")
-	mylog = mylog.With().Str("rule", r.Name).Str("kind", req.Kind.String()).Str("name", req.Name).Str("namespace", req.Namespace).Logger()
+	mylog = log.RuleLogger(mylog, r.Name).With().Str("kind", req.Kind.String()).Str("name", req.Name).Str("namespace", req.Namespace).Logger()
 
-	object, err := extractObject(req)
+	object, oldObject, parent, err := admissionObjects(req)
 	if err != nil {
-		admissionResponseError(fmt.Errorf("failed to extract object from admission request: %v", err))
+		return admissionResponseError(fmt.Errorf("failed to extract object from admission request: %v", err))
 	}
 
-	patch, err := r.Mutate(object)
+	patch, err := r.mutate(object, oldObject, &req.UserInfo, newProvenance(req, time.Now()))
 	if err != nil {
-		return admissionResponseError(fmt.Errorf("failed to mutate object: %v", err))
+		return admissionResponseError(fmt.Errorf("failed to mutate object: %w", err))
+	}
+	// objects can only be patched when they are created or updated, but any operation can be blocked
+	if req.Operation != admission.Create && req.Operation != admission.Update && patch != nil && !bytes.Equal(patch, []byte("BLOCK")) {
+		mylog.Debug().Str("operation", string(req.Operation)).Msg("the object of this operation can't be patched, allowing it unchanged")
+		patch = nil
+	}
+	if parent && patch != nil && !bytes.Equal(patch, []byte("BLOCK")) {
+		mylog.Debug().Str("subresource", req.SubResource).Msg("the parent of this subresource can't be patched, allowing it unchanged")
+		patch = nil
 	}
 
 	return patchResult(patch, r.Name)
 }
 
+// ValidateAdmission takes an admission request and denies it if the object matches the rule's matchers, otherwise it is allowed.
+// It never patches the object.
+func (r Rule) ValidateAdmission(req *admission.AdmissionRequest) *admission.AdmissionResponse {
+	mylog := log.ComponentLogger(componentName, "ValidateAdmission")
+	mylog = log.RuleLogger(mylog, r.Name).With().Str("kind", req.Kind.String()).Str("name", req.Name).Str("namespace", req.Namespace).Logger()
+
+	object, oldObject, _, err := admissionObjects(req)
+	if err != nil {
+		return admissionResponseError(fmt.Errorf("failed to extract object from admission request: %v", err))
+	}
+
+	match, err := r.matchesRequest(object, oldObject, &req.UserInfo)
+	if err != nil {
+		return admissionResponseError(fmt.Errorf("failed to validate object: %v", err))
+	}
+	if !match {
+		mylog.Debug().Msg("rule didn't match - allowing object")
+		return &admission.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Message: "rule didn't match",
+			},
+		}
+	}
+
+	mylog.Info().Msg("rule matched - denying object")
+	return &admission.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Reason:  metav1.StatusReasonForbidden,
+			Message: fmt.Sprintf("denied by kube-graffiti rule: %s", r.Name),
+		},
+	}
+}
+
 func extractObject(req *admission.AdmissionRequest) (result []byte, err error) {
+	// delete requests only have the old object, which is the object being deleted
+	raw := req.Object.Raw
+	if len(raw) == 0 {
+		raw = req.OldObject.Raw
+	}
 	// make sure that name and namespace fields are populated in the metadata object
 	object := make(map[string]interface{})
-	if err = json.Unmarshal(req.Object.Raw, &object); err != nil {
+	if err = json.Unmarshal(raw, &object); err != nil {
 		return result, err
 	}
 	if req.Name != "" {
@@ -148,38 +267,201 @@ func addMetadata(obj map[string]interface{}, k, v string) {
 func admissionResponseError(err error) *admission.AdmissionResponse {
 	mylog := log.ComponentLogger(componentName, "admissionResponseError")
 	mylog.Error().Err(err).Msg("admission response error, skipping any modification")
+	reason := metav1.StatusReasonInternalError
+	if errors.Is(err, ErrPatchBuild) {
+		reason = StatusReasonPatchBuild
+	}
 	return &admission.AdmissionResponse{
 		Allowed: true,
 		Result: &metav1.Status{
+			Reason:  reason,
 			Message: err.Error(),
 		},
 	}
 }
 
+// MatchesAdmission returns true if the rule's matchers match the object of an admission request, or the parent of
+// its subresource.
+func (r Rule) MatchesAdmission(req *admission.AdmissionRequest) (bool, error) {
+	object, oldObject, _, err := admissionObjects(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to extract object from admission request: %v", err)
+	}
+	return r.matchesRequest(object, oldObject, &req.UserInfo)
+}
+
+// Matches takes a raw object, and the old object that it replaces if there is one, and returns true if the rule's matchers match it.
+func (r Rule) Matches(object, oldObject []byte) (bool, error) {
+	return r.matchesRequest(object, oldObject, nil)
+}
+
+// matchesRequest is Matches with the user making the admission request.
+func (r Rule) matchesRequest(object, oldObject []byte, user *authenticationv1.UserInfo) (bool, error) {
+	mylog := log.ComponentLogger(componentName, "Matches")
+	mylog = log.RuleLogger(mylog, r.Name)
+	in, err := r.matchInputs(object, oldObject, user)
+	if err != nil {
+		return false, err
+	}
+	compiled, err := r.compiledMatchers()
+	if err != nil {
+		return false, err
+	}
+	match, err := r.Matchers.matches(compiled, in.meta, in.fields, in.object, in.oldObject, user, mylog)
+	if err != nil || !match || r.Rego.empty() {
+		return match, err
+	}
+	decision, err := r.Rego.decide(in.object, in.oldObject)
+	return decision.Match, err
+}
+
+// matchInputs are what the matchers evaluate: the object's metadata and field map, the object and the old object.
+type matchInputs struct {
+	meta              metaObject
+	fields            map[string]string
+	object, oldObject []byte
+}
+
+// matchInputs unmarshals the object that the matchers evaluate, after converting it, and the old object, to the
+// preferred version of their kind when the matchers normalize versions.
+func (r Rule) matchInputs(object, oldObject []byte, user *authenticationv1.UserInfo) (in matchInputs, err error) {
+	if r.Matchers.NormalizeVersions {
+		if object, err = normalizeVersion(object); err != nil {
+			return in, err
+		}
+		if oldObject, err = normalizeVersion(oldObject); err != nil {
+			return in, err
+		}
+	}
+	meta, fields, err := unmarshalObject(object)
+	if err != nil {
+		return in, err
+	}
+	addUserFields(fields, user)
+	addOldObjectFields(fields, oldObject)
+	return matchInputs{meta: meta, fields: fields, object: object, oldObject: oldObject}, nil
+}
+
+// RenderedLabels returns the label additions of the rule's payload with any templated values rendered against the object.
+func (r Rule) RenderedLabels(object []byte) (map[string]string, error) {
+	_, fieldMap, err := unmarshalObject(object)
+	if err != nil {
+		return nil, err
+	}
+	return renderMapValues(r.Payload.Additions.Labels, fieldMap)
+}
+
+// unmarshalObject pulls out the metadata and creates the field map used by field matchers and addition templating.
+func unmarshalObject(object []byte) (metaObject, map[string]string, error) {
+	var mo metaObject
+	if err := json.Unmarshal(object, &mo); err != nil {
+		return mo, nil, fmt.Errorf("failed to unmarshal generic object metadata from the admission request: %v", err)
+	}
+	var present struct {
+		Meta *json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &present); err == nil {
+		mo.missing = present.Meta == nil
+	}
+	fieldMap, err := makeFieldMapFromRawObject(object)
+	if err != nil {
+		return mo, nil, err
+	}
+	return mo, fieldMap, nil
+}
+
 // Mutate takes a raw object and applies the graffiti rule against it, returning a JSON patch or an error.
 // It performs the logic between selectors and the boolean-operator.
 func (r Rule) Mutate(object []byte) (patch []byte, err error) {
+	return r.mutate(object, nil, nil, nil)
+}
+
+// mutate is Mutate with the old object from an update admission request, which cel matchers can refer to, the
+// user making the request and its provenance.
+func (r Rule) mutate(object, oldObject []byte, user *authenticationv1.UserInfo, request *provenance) (patch []byte, err error) {
 	mylog := log.ComponentLogger(componentName, "Mutate")
-	mylog = mylog.With().Str("rule", r.Name).Logger()
-	var metaObject metaObject
+	mylog = log.RuleLogger(mylog, r.Name)
 
-	if err := json.Unmarshal(object, &metaObject); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal generic object metadata from the admission request: %v", err)
+	in, err := r.matchInputs(object, oldObject, user)
+	if err != nil {
+		return nil, err
+	}
+	metaObject, fieldMap := in.meta, in.fields
+	if r.Matchers.NormalizeVersions {
+		// the payload is rendered against, and patches, the object as it was made rather than as it was matched
+		if metaObject, fieldMap, err = unmarshalObject(object); err != nil {
+			return nil, err
+		}
+		addUserFields(fieldMap, user)
+		addOldObjectFields(fieldMap, oldObject)
 	}
 
-	// create the field map for use with field matchers and addition templating.
-	fieldMap, err := makeFieldMapFromRawObject(object)
+	compiled, err := r.compiledMatchers()
 	if err != nil {
 		return nil, err
 	}
-
-	match, err := r.Matchers.matches(metaObject, fieldMap, mylog)
+	match, err := r.Matchers.matches(compiled, in.meta, in.fields, in.object, in.oldObject, user, mylog)
 	if err != nil {
 		return nil, err
 	}
+	payload := r.Payload
+	if match && !r.Rego.empty() {
+		decision, err := r.Rego.decide(in.object, in.oldObject)
+		if err != nil {
+			return nil, err
+		}
+		mylog.Debug().Bool("match", decision.Match).Msg("rego policy decided")
+		match = decision.Match
+		payload = payload.withEmitted(decision.validEmitted(mylog))
+	}
+	if match && !payload.InheritFromNamespace.isEmpty() {
+		lookup := r.namespaces
+		if lookup == nil {
+			lookup = namespaceLookup
+		}
+		// the namespace of a namespace's own admission request is its name, but it has nothing to inherit
+		namespace := metaObject.Meta.Namespace
+		if fieldMap["kind"] == "Namespace" {
+			namespace = ""
+		}
+		labels, annotations, err := payload.InheritFromNamespace.inherited(lookup, namespace)
+		if err != nil {
+			return nil, err
+		}
+		payload = payload.withInherited(labels, annotations)
+	}
+	if match && payload.enriches(EnrichQuota) {
+		lookup := r.quotas
+		if lookup == nil {
+			lookup = quotaLookup
+		}
+		fields, err := quotaFields(lookup, metaObject, fieldMap)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fields {
+			fieldMap[k] = v
+		}
+	}
+	if match && payload.Stamp {
+		annotations, applied, err := payload.stamp(r.Name, metaObject, fieldMap, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if applied {
+			mylog.Debug().Msg("rule matched but the object is stamped with the same payload - not painting object")
+			return nil, nil
+		}
+		payload = payload.withStamp(annotations)
+	}
 	if match {
 		mylog.Info().Msg("rule matched - painting object")
-		return r.Payload.paintObject(metaObject, fieldMap, mylog)
+		// only the user making a create request is the object's creator
+		var creator *authenticationv1.UserInfo
+		if len(oldObject) == 0 {
+			creator = user
+		}
+		return payload.paintObject(metaObject, fieldMap, object, creator, request, mylog)
 	}
 
 	mylog.Debug().Msg("rule didn't match - not painting object")
@@ -15,6 +15,8 @@ package graffiti
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"testing"
 
 	jsonpatch "github.com/cameront/go-jsonpatch"
@@ -23,6 +25,8 @@ import (
 	"github.com/stretchr/testify/require"
 	yaml "gopkg.in/yaml.v2"
 	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 const testReview = `{
@@ -245,7 +249,7 @@ matchers:
 	err := yaml.Unmarshal([]byte(source), &rule)
 	assert.NoError(t, err, "couldn't marshall a valid rule object")
 	err = rule.Validate(mylog)
-	assert.EqualError(t, err, "rule 'my-rule' failed validation: a rule payload must specify either additions/deletions, a json-patch, or a block")
+	assert.EqualError(t, err, "rule 'my-rule' failed validation: a rule payload must specify either additions/deletions, a json-patch, inject-containers or a block")
 }
 
 func TestWhenAdditionsAlreadyThereProducesNoPatch(t *testing.T) {
@@ -271,3 +275,164 @@ func TestWhenAdditionsAlreadyThereProducesNoPatch(t *testing.T) {
 	assert.Equal(t, true, resp.Allowed, "the request should be successful")
 	assert.Nil(t, resp.Patch)
 }
+
+func TestValidatingRuleDeniesMatchingObjects(t *testing.T) {
+	rule := Rule{Name: "no-david", Type: RuleTypeValidating, Matchers: Matchers{LabelSelectors: []string{"author=david"}}}
+
+	var review = admission.AdmissionReview{}
+	err := json.Unmarshal([]byte(testReview), &review)
+	require.NoError(t, err, "couldn't marshall a valid admission review object from test json")
+
+	resp := rule.ValidateAdmission(review.Request)
+	assert.False(t, resp.Allowed, "a matching object should be denied")
+	assert.Nil(t, resp.Patch, "a validating rule should never patch")
+	assert.Equal(t, "denied by kube-graffiti rule: no-david", resp.Result.Message)
+}
+
+func TestValidatingRuleAllowsObjectsThatDoNotMatch(t *testing.T) {
+	rule := Rule{Name: "no-fred", Type: RuleTypeValidating, Matchers: Matchers{LabelSelectors: []string{"author=fred"}}}
+
+	var review = admission.AdmissionReview{}
+	err := json.Unmarshal([]byte(testReview), &review)
+	require.NoError(t, err, "couldn't marshall a valid admission review object from test json")
+
+	resp := rule.ValidateAdmission(review.Request)
+	assert.True(t, resp.Allowed, "an object that does not match should be allowed")
+	assert.Nil(t, resp.Patch)
+}
+
+func TestValidatingRuleCanNotHaveAPayload(t *testing.T) {
+	rule := Rule{
+		Name:    "bad",
+		Type:    RuleTypeValidating,
+		Payload: Payload{Additions: Additions{Labels: map[string]string{"a": "b"}}},
+	}
+	err := rule.Validate(log.Logger)
+	assert.EqualError(t, err, "rule 'bad' failed validation: a validating rule can not have a payload")
+
+	rule = Rule{Name: "good", Type: RuleTypeValidating}
+	assert.NoError(t, rule.Validate(log.Logger))
+}
+
+func TestRuleTypeMustBeValid(t *testing.T) {
+	rule := Rule{Name: "bad", Type: "sometimes", Payload: Payload{Block: true}}
+	err := rule.Validate(log.Logger)
+	assert.EqualError(t, err, "rule 'bad' failed validation: invalid type 'sometimes', must be either mutating or validating")
+}
+
+func TestRulesCanBeBuiltInGo(t *testing.T) {
+	base := NewRule("label-web").MatchLabels("app=web").MatchNames("*-web")
+	rule := base.AddLabels(map[string]string{"team": "web"}).AddLabels(map[string]string{"tier": "front"}).DeleteAnnotations("old")
+	require.NoError(t, rule.Validate(log.Logger))
+
+	assert.Equal(t, Rule{
+		Name:     "label-web",
+		Type:     RuleTypeMutating,
+		Matchers: Matchers{LabelSelectors: []string{"app=web"}, Names: []string{"*-web"}},
+		Payload: Payload{
+			Additions: Additions{Labels: map[string]string{"team": "web", "tier": "front"}},
+			Deletions: Deletions{Annotations: []string{"old"}},
+		},
+	}, rule)
+	assert.True(t, base.Payload.isEmpty(), "building on a rule doesn't change it")
+
+	blocking := base.MatchFields("metadata.namespace=default").CombineWith(OR).Block()
+	assert.Equal(t, []string{"app=web"}, base.Matchers.LabelSelectors)
+	assert.Equal(t, OR, blocking.Matchers.BooleanOperator)
+	assert.True(t, blocking.Payload.Block)
+	assert.True(t, NewRule("deny-web").MatchCEL("object.metadata.name == 'web'").Validating().IsValidating())
+}
+
+func TestRulesAreSortedByPriorityThenName(t *testing.T) {
+	rules := []Rule{
+		NewRule("b"),
+		NewRule("low").WithPriority(-1),
+		NewRule("a"),
+		NewRule("high").WithPriority(10).StoppingOnMatch(),
+	}
+	SortRules(rules)
+
+	var names []string
+	for _, r := range rules {
+		names = append(names, r.Name)
+	}
+	assert.Equal(t, []string{"high", "a", "b", "low"}, names)
+	assert.True(t, rules[0].StopOnMatch)
+	assert.False(t, rules[1].Precedes(rules[0]))
+}
+
+func TestValidatingRuleCanNotStopOnMatch(t *testing.T) {
+	rule := NewRule("bad").Validating().StoppingOnMatch()
+	err := rule.Validate(log.Logger)
+	assert.EqualError(t, err, "rule 'bad' failed validation: a validating rule can not stop-on-match")
+}
+
+func TestRulesCanBlockDeletesButNotPatchThem(t *testing.T) {
+	req := &admission.AdmissionRequest{
+		Operation: admission.Delete,
+		Name:      "web",
+		Namespace: "default",
+		OldObject: runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default","labels":{"protected":"true"}}}`)},
+	}
+
+	deny := NewRule("protect").MatchLabels("protected=true").Validating()
+	resp := deny.ValidateAdmission(req)
+	assert.False(t, resp.Allowed, "the deleted object is matched from the old object")
+
+	block := NewRule("protect").MatchLabels("protected=true").Block()
+	assert.False(t, block.MutateAdmission(req).Allowed)
+
+	label := NewRule("label").AddLabels(map[string]string{"painted": "true"})
+	resp = label.MutateAdmission(req)
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Patch, "a deleted object can't be patched")
+}
+
+func TestMatchersCanReferToTheOldObject(t *testing.T) {
+	rule := NewRule("keep-team").MatchFields("oldObject.metadata.labels.team=web,metadata.labels.team!=web").Validating()
+	req := &admission.AdmissionRequest{
+		Operation: admission.Update,
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","metadata":{"name":"web","labels":{"team":"api"}}}`)},
+		OldObject: runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","metadata":{"name":"web","labels":{"team":"web"}}}`)},
+	}
+	assert.False(t, rule.ValidateAdmission(req).Allowed, "changing the team label should be denied")
+
+	req.Object.Raw = req.OldObject.Raw
+	assert.True(t, rule.ValidateAdmission(req).Allowed)
+}
+
+func TestObjectsWithoutMetadataHaveItAdded(t *testing.T) {
+	// some objects served by aggregated api servers, such as the sample-apiserver's, are sent without any metadata
+	req := &admission.AdmissionRequest{
+		Operation: admission.Create,
+		Kind:      metav1.GroupVersionKind{Group: "wardle.example.com", Version: "v1alpha1", Kind: "Fischer"},
+		Resource:  metav1.GroupVersionResource{Group: "wardle.example.com", Version: "v1alpha1", Resource: "fischers"},
+		Object:    runtime.RawExtension{Raw: []byte(`{"apiVersion":"wardle.example.com/v1alpha1","kind":"Fischer","disallowedFlunders":["bad"]}`)},
+	}
+	rule := NewRule("aggregated").AddLabels(map[string]string{"painted": "true"})
+	resp := rule.MutateAdmission(req)
+	require.True(t, resp.Allowed)
+	require.NotNil(t, resp.Patch)
+
+	patch, err := jsonpatch.FromString(string(resp.Patch))
+	require.NoError(t, err)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(req.Object.Raw, &doc))
+	require.NoError(t, patch.Apply(&doc), "the patch should apply to an object without metadata")
+	assert.Equal(t, map[string]interface{}{"labels": map[string]interface{}{"painted": "true"}}, doc["metadata"])
+
+	// an object with metadata doesn't have it replaced
+	req.Object.Raw = []byte(`{"apiVersion":"metrics.k8s.io/v1beta1","kind":"PodMetrics","metadata":{"name":"web"}}`)
+	resp = rule.MutateAdmission(req)
+	assert.NotContains(t, string(resp.Patch), `"path": "/metadata",`)
+}
+
+func TestPatchBuildErrorsHaveTheirOwnReason(t *testing.T) {
+	resp := admissionResponseError(fmt.Errorf("failed to mutate object: %w", fmt.Errorf("%w: bad ingress", ErrPatchBuild)))
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, StatusReasonPatchBuild, resp.Result.Reason)
+	assert.Equal(t, "failed to mutate object: could not create json patch: bad ingress", resp.Result.Message)
+
+	resp = admissionResponseError(errors.New("failed to extract object from admission request"))
+	assert.Equal(t, metav1.StatusReasonInternalError, resp.Result.Reason)
+}
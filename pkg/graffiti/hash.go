@@ -0,0 +1,75 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+)
+
+// templateFuncs are the functions available to addition templates, sprig's functions along with our own deterministic
+// value generators, and uuid and a now that formats the time for generating unique values.
+func templateFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["shard"] = shard
+	funcs["choose"] = choose
+	funcs["uuid"] = funcs["uuidv4"]
+	funcs["now"] = now
+	return funcs
+}
+
+// stableHash is the FNV-1a hash of the values, which must never change as labels derived from it would move.
+func stableHash(values []string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(values, "\x00")))
+	return h.Sum64()
+}
+
+// shard assigns the values to one of n shards, numbered from 0, e.g. {{ shard 16 (index . "metadata.namespace") }}.
+func shard(n int, values ...string) (int, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("shard needs a positive number of shards, not %d", n)
+	}
+	return int(stableHash(values) % uint64(n)), nil
+}
+
+// choose picks one of the choices, which is a list or a comma separated string, for the values so that the same
+// values always get the same choice, e.g. {{ choose "red,green,blue" (index . "metadata.name") }}.
+func choose(choices interface{}, values ...string) (string, error) {
+	var options []string
+	switch c := choices.(type) {
+	case string:
+		for _, option := range strings.Split(c, ",") {
+			if option = strings.TrimSpace(option); option != "" {
+				options = append(options, option)
+			}
+		}
+	case []string:
+		options = c
+	case []interface{}:
+		for _, option := range c {
+			options = append(options, fmt.Sprint(option))
+		}
+	default:
+		return "", fmt.Errorf("choose needs a list or comma separated string of choices, not %T", choices)
+	}
+	if len(options) == 0 {
+		return "", fmt.Errorf("choose needs at least one choice")
+	}
+	return options[stableHash(values)%uint64(len(options))], nil
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardIsDeterministic(t *testing.T) {
+	fm := map[string]string{"metadata.namespace": "team-a", "metadata.name": "web"}
+	first, err := renderStringTemplate(`{{ shard 16 (index . "metadata.namespace") }}`, fm)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		again, err := renderStringTemplate(`{{ index . "metadata.namespace" | shard 16 }}`, fm)
+		require.NoError(t, err)
+		assert.Equal(t, first, again)
+	}
+	assert.Equal(t, "6", first, "the hash must never change or objects would move shards")
+
+	counts := make(map[int]int)
+	for _, ns := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		n, err := shard(4, ns)
+		require.NoError(t, err)
+		require.True(t, n >= 0 && n < 4)
+		counts[n]++
+	}
+	assert.True(t, len(counts) > 1, "namespaces should be spread over the shards")
+
+	_, err = renderStringTemplate(`{{ shard 0 "a" }}`, fm)
+	assert.Error(t, err)
+}
+
+func TestChooseIsDeterministic(t *testing.T) {
+	fm := map[string]string{"metadata.namespace": "team-a", "metadata.name": "web"}
+	fromString, err := renderStringTemplate(`{{ choose "eu-1a, eu-1b, eu-1c" (index . "metadata.namespace") (index . "metadata.name") }}`, fm)
+	require.NoError(t, err)
+	fromList, err := renderStringTemplate(`{{ choose (list "eu-1a" "eu-1b" "eu-1c") (index . "metadata.namespace") (index . "metadata.name") }}`, fm)
+	require.NoError(t, err)
+	assert.Equal(t, fromString, fromList)
+	assert.Contains(t, []string{"eu-1a", "eu-1b", "eu-1c"}, fromString)
+
+	_, err = choose("", "web")
+	assert.EqualError(t, err, "choose needs at least one choice")
+	_, err = choose(3, "web")
+	assert.Error(t, err)
+}
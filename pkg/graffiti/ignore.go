@@ -0,0 +1,57 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graffiti
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultIgnoredNamespaces are the namespaces that are ignored when the configuration doesn't list any.
+var DefaultIgnoredNamespaces = []string{"kube-system"}
+
+// Ignore lists the namespaces and object names that are never changed, whatever the rules match, so that a broad or
+// broken rule can't touch the objects that keep the cluster running.  Both can contain '*' wildcards.
+type Ignore struct {
+	// Namespaces ignores the objects in the namespaces, and the namespaces themselves.
+	Namespaces []string `mapstructure:"namespaces" yaml:"namespaces"`
+	// Names ignores the objects with the names, in any namespace.
+	Names []string `mapstructure:"names" yaml:"names,omitempty"`
+}
+
+// Validate checks that none of the namespaces or names are empty.
+func (i Ignore) Validate() error {
+	for _, ns := range i.Namespaces {
+		if strings.TrimSpace(ns) == "" {
+			return fmt.Errorf("ignore.namespaces contains an empty namespace")
+		}
+	}
+	for _, name := range i.Names {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("ignore.names contains an empty name, use '*' to match any name")
+		}
+	}
+	return nil
+}
+
+// Ignores is true when an object of the kind, in the namespace and with the name, is never to be changed.  A
+// namespace's name is checked against the ignored namespaces too.
+func (i Ignore) Ignores(kind, namespace, name string) bool {
+	if kind == "Namespace" && namespace == "" {
+		namespace = name
+	}
+	if namespace != "" && matchAny(i.Namespaces, namespace) {
+		return true
+	}
+	return name != "" && matchAny(i.Names, name)
+}
@@ -0,0 +1,35 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreMatchesNamespacesAndNames(t *testing.T) {
+	i := Ignore{Namespaces: []string{"kube-system", "openshift-*"}, Names: []string{"*-generated"}}
+	assert.True(t, i.Ignores("Pod", "kube-system", "coredns"))
+	assert.True(t, i.Ignores("Pod", "openshift-dns", "dns"))
+	assert.True(t, i.Ignores("Namespace", "", "kube-system"), "the ignored namespaces themselves are ignored")
+	assert.True(t, i.Ignores("ConfigMap", "team-a", "app-generated"))
+	assert.True(t, i.Ignores("Namespace", "", "ns-generated"))
+	assert.False(t, i.Ignores("Pod", "team-a", "web"))
+	assert.False(t, i.Ignores("ClusterRole", "", "admin"))
+	assert.False(t, Ignore{}.Ignores("Pod", "kube-system", "coredns"))
+
+	assert.NoError(t, i.Validate())
+	assert.EqualError(t, Ignore{Namespaces: []string{" "}}.Validate(), "ignore.namespaces contains an empty namespace")
+	assert.EqualError(t, Ignore{Names: []string{""}}.Validate(), "ignore.names contains an empty name, use '*' to match any name")
+}
@@ -0,0 +1,176 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The annotations written by an ingress payload.
+const (
+	ExternalDNSHostnameAnnotation      = "external-dns.alpha.kubernetes.io/hostname"
+	CertManagerIssuerAnnotation        = "cert-manager.io/issuer"
+	CertManagerClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+	// ingressClassAnnotation is the class of Ingresses which predate spec.ingressClassName.
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+)
+
+// routingKinds are the kinds of object that have hostnames, Ingresses and the Gateway API's Gateways and routes.
+var routingKinds = map[string]bool{
+	"Ingress":   true,
+	"Gateway":   true,
+	"HTTPRoute": true,
+	"GRPCRoute": true,
+	"TLSRoute":  true,
+}
+
+// routingObject is the parts of an Ingress, Gateway or route that hold its class and hostnames.
+type routingObject struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		IngressClassName string `json:"ingressClassName"`
+		GatewayClassName string `json:"gatewayClassName"`
+		Rules            []struct {
+			Host string `json:"host"`
+		} `json:"rules"`
+		TLS []struct {
+			Hosts []string `json:"hosts"`
+		} `json:"tls"`
+		Listeners []struct {
+			Hostname string `json:"hostname"`
+		} `json:"listeners"`
+		Hostnames []string `json:"hostnames"`
+	} `json:"spec"`
+}
+
+// parseRoutingObject returns the routing parts of an object, and false if it isn't an Ingress, Gateway or route.
+func parseRoutingObject(raw []byte) (routingObject, bool, error) {
+	var obj routingObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return obj, false, fmt.Errorf("failed to unmarshal object for ingress matching: %v", err)
+	}
+	return obj, routingKinds[obj.Kind], nil
+}
+
+// class is the ingress class of an Ingress or the gateway class of a Gateway, routes don't have a class.
+func (o routingObject) class() string {
+	if o.Spec.IngressClassName != "" {
+		return o.Spec.IngressClassName
+	}
+	if o.Spec.GatewayClassName != "" {
+		return o.Spec.GatewayClassName
+	}
+	return o.Metadata.Annotations[ingressClassAnnotation]
+}
+
+// hosts are the unique hostnames that the object routes or terminates tls for, sorted.
+func (o routingObject) hosts() []string {
+	set := make(map[string]bool)
+	for _, rule := range o.Spec.Rules {
+		set[rule.Host] = true
+	}
+	for _, tls := range o.Spec.TLS {
+		for _, host := range tls.Hosts {
+			set[host] = true
+		}
+	}
+	for _, listener := range o.Spec.Listeners {
+		set[listener.Hostname] = true
+	}
+	for _, host := range o.Spec.Hostnames {
+		set[host] = true
+	}
+	delete(set, "")
+
+	hosts := make([]string, 0, len(set))
+	for host := range set {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// IngressMatcher matches Ingresses, Gateways and Gateway API routes by their class and hostnames, which may contain
+// '*' wildcards.  The object must have one of the classes and at least one of its hosts must match one of the hosts,
+// where they are specified.  Other kinds of object never match.
+type IngressMatcher struct {
+	Classes []string `mapstructure:"classes" yaml:"classes,omitempty"`
+	Hosts   []string `mapstructure:"hosts" yaml:"hosts,omitempty"`
+}
+
+func (i IngressMatcher) empty() bool {
+	return len(i.Classes) == 0 && len(i.Hosts) == 0
+}
+
+func (i IngressMatcher) matches(raw []byte) (bool, error) {
+	obj, ok, err := parseRoutingObject(raw)
+	if err != nil || !ok {
+		return false, err
+	}
+	if len(i.Classes) > 0 && !matchAny(i.Classes, obj.class()) {
+		return false, nil
+	}
+	if len(i.Hosts) > 0 && !matchAny(i.Hosts, obj.hosts()...) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// IngressAnnotations stamps Ingresses, Gateways and routes with the annotations used by external-dns and cert-manager.
+type IngressAnnotations struct {
+	// ExternalDNS sets the external-dns hostname annotation to the object's hostnames.
+	ExternalDNS bool `mapstructure:"external-dns" yaml:"external-dns,omitempty"`
+	// Issuer and ClusterIssuer name the cert-manager issuer of the object's certificates.
+	Issuer        string `mapstructure:"cert-manager-issuer" yaml:"cert-manager-issuer,omitempty"`
+	ClusterIssuer string `mapstructure:"cert-manager-cluster-issuer" yaml:"cert-manager-cluster-issuer,omitempty"`
+}
+
+func (i IngressAnnotations) isEmpty() bool {
+	return !i.ExternalDNS && i.Issuer == "" && i.ClusterIssuer == ""
+}
+
+func (i IngressAnnotations) validate() error {
+	if i.Issuer != "" && i.ClusterIssuer != "" {
+		return fmt.Errorf("an ingress payload can only specify one of cert-manager-issuer or cert-manager-cluster-issuer")
+	}
+	return nil
+}
+
+// annotations returns the annotations for a routing object, and none for other kinds of object.
+func (i IngressAnnotations) annotations(raw []byte) (map[string]string, error) {
+	if i.isEmpty() {
+		return nil, nil
+	}
+	obj, ok, err := parseRoutingObject(raw)
+	if err != nil || !ok {
+		return nil, err
+	}
+	result := make(map[string]string)
+	if hosts := obj.hosts(); i.ExternalDNS && len(hosts) > 0 {
+		result[ExternalDNSHostnameAnnotation] = strings.Join(hosts, ",")
+	}
+	if i.Issuer != "" {
+		result[CertManagerIssuerAnnotation] = i.Issuer
+	}
+	if i.ClusterIssuer != "" {
+		result[CertManagerClusterIssuerAnnotation] = i.ClusterIssuer
+	}
+	return result, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testIngress       = []byte(`{"kind":"Ingress","metadata":{"name":"web","namespace":"default"},"spec":{"ingressClassName":"nginx-public","rules":[{"host":"www.acme.com"},{"host":"api.acme.com"}],"tls":[{"hosts":["www.acme.com"]}]}}`)
+	testLegacyIngress = []byte(`{"kind":"Ingress","metadata":{"name":"old","annotations":{"kubernetes.io/ingress.class":"internal"}},"spec":{"rules":[{"host":"old.acme.internal"}]}}`)
+	testGateway       = []byte(`{"kind":"Gateway","metadata":{"name":"edge"},"spec":{"gatewayClassName":"istio","listeners":[{"hostname":"*.acme.com"}]}}`)
+	testRoute         = []byte(`{"kind":"HTTPRoute","metadata":{"name":"web"},"spec":{"hostnames":["shop.acme.com"]}}`)
+	testService       = []byte(`{"kind":"Service","metadata":{"name":"web"}}`)
+)
+
+func TestIngressMatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher IngressMatcher
+		matches []bool
+	}{
+		{"class", IngressMatcher{Classes: []string{"nginx-*", "istio"}}, []bool{true, false, true, false, false}},
+		{"annotated class", IngressMatcher{Classes: []string{"internal"}}, []bool{false, true, false, false, false}},
+		{"host", IngressMatcher{Hosts: []string{"*.acme.com"}}, []bool{true, false, true, true, false}},
+		{"class and host", IngressMatcher{Classes: []string{"nginx-public"}, Hosts: []string{"api.*"}}, []bool{true, false, false, false, false}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for i, object := range [][]byte{testIngress, testLegacyIngress, testGateway, testRoute, testService} {
+				match, err := tc.matcher.matches(object)
+				require.NoError(t, err)
+				assert.Equal(t, tc.matches[i], match, "object %d", i)
+			}
+		})
+	}
+}
+
+func TestIngressPayloadStampsExternalDNSAndCertManagerAnnotations(t *testing.T) {
+	rule := Rule{
+		Name:     "public-ingresses",
+		Matchers: Matchers{Ingress: IngressMatcher{Classes: []string{"nginx-public"}}},
+		Payload: Payload{
+			Ingress:   IngressAnnotations{ExternalDNS: true, ClusterIssuer: "letsencrypt"},
+			Additions: Additions{Annotations: map[string]string{"team": "web"}},
+		},
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate(testIngress)
+	require.NoError(t, err)
+	var ops []struct {
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 1)
+	assert.Equal(t, map[string]string{
+		"team":                             "web",
+		ExternalDNSHostnameAnnotation:      "api.acme.com,www.acme.com",
+		CertManagerClusterIssuerAnnotation: "letsencrypt",
+	}, ops[0].Value)
+
+	patch, err = Rule{Payload: Payload{Ingress: IngressAnnotations{ExternalDNS: true}}}.Mutate(testService)
+	require.NoError(t, err)
+	assert.Nil(t, patch, "only ingresses, gateways and routes are annotated")
+}
+
+func TestIngressPayloadValidation(t *testing.T) {
+	assert.NoError(t, Payload{Ingress: IngressAnnotations{Issuer: "ca"}}.validate())
+	assert.EqualError(t, Payload{Ingress: IngressAnnotations{Issuer: "ca", ClusterIssuer: "ca"}}.validate(), "an ingress payload can only specify one of cert-manager-issuer or cert-manager-cluster-issuer")
+	assert.Error(t, Payload{Ingress: IngressAnnotations{ExternalDNS: true}, Block: true}.validate())
+}
@@ -0,0 +1,89 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// InheritFromNamespace copies the listed labels and annotations of an object's namespace onto the object, so that
+// everything in a namespace carries its project labels.  Keys that the namespace doesn't have are left alone, and
+// cluster scoped objects have nothing to inherit.
+type InheritFromNamespace struct {
+	Labels      []string `mapstructure:"labels" yaml:"labels,omitempty"`
+	Annotations []string `mapstructure:"annotations" yaml:"annotations,omitempty"`
+}
+
+// NamespaceLookup finds a namespace by its name, normally from a cache of the cluster's namespaces.
+type NamespaceLookup interface {
+	LookupNamespace(name string) (*corev1.Namespace, error)
+}
+
+// namespaceLookup finds the namespaces of the objects in admission requests, rules can be given their own with
+// WithNamespaceLookup.
+var namespaceLookup NamespaceLookup
+
+// SetNamespaceLookup sets how the rules that inherit from namespaces find them, unless they have their own lookup.
+func SetNamespaceLookup(l NamespaceLookup) {
+	namespaceLookup = l
+}
+
+// WithNamespaceLookup returns the rule with its own namespace lookup, for when its objects are in another cluster
+// from the one whose admission requests are being served.
+func (r Rule) WithNamespaceLookup(l NamespaceLookup) Rule {
+	r.namespaces = l
+	return r
+}
+
+func (i InheritFromNamespace) isEmpty() bool {
+	return len(i.Labels) == 0 && len(i.Annotations) == 0
+}
+
+func (i InheritFromNamespace) validate() error {
+	for _, k := range append(append([]string{}, i.Labels...), i.Annotations...) {
+		if errs := utilvalidation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid inherit-from-namespace key '%s': %s", k, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// inherited returns the listed labels and annotations that the namespace has.
+func (i InheritFromNamespace) inherited(lookup NamespaceLookup, namespace string) (labels, annotations map[string]string, err error) {
+	if i.isEmpty() || namespace == "" {
+		return nil, nil, nil
+	}
+	if lookup == nil {
+		return nil, nil, fmt.Errorf("can't inherit from namespace '%s', namespaces are not being looked up", namespace)
+	}
+	ns, err := lookup.LookupNamespace(namespace)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't inherit from namespace '%s': %v", namespace, err)
+	}
+	return pickKeys(ns.Labels, i.Labels), pickKeys(ns.Annotations, i.Annotations), nil
+}
+
+func pickKeys(m map[string]string, keys []string) map[string]string {
+	picked := make(map[string]string)
+	for _, k := range keys {
+		if v, ok := m[k]; ok {
+			picked[k] = v
+		}
+	}
+	return picked
+}
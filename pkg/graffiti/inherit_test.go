@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// namespaces is a NamespaceLookup of the namespaces in a map.
+type namespaces map[string]*corev1.Namespace
+
+func (n namespaces) LookupNamespace(name string) (*corev1.Namespace, error) {
+	if ns, ok := n[name]; ok {
+		return ns, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func TestInheritFromNamespaceCopiesItsLabelsAndAnnotations(t *testing.T) {
+	lookup := namespaces{"team-a": &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "team-a",
+		Labels:      map[string]string{"project": "apollo", "cost-centre": "1234", "other": "x"},
+		Annotations: map[string]string{"owner": "team-a@acme.com"},
+	}}}
+	SetNamespaceLookup(lookup)
+	defer SetNamespaceLookup(nil)
+
+	rule := Rule{
+		Name: "project-labels",
+		Payload: Payload{InheritFromNamespace: InheritFromNamespace{
+			Labels:      []string{"project", "cost-centre", "missing"},
+			Annotations: []string{"owner"},
+		}},
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	// pods created by controllers can be sent without a namespace, so the request's namespace is used
+	req := &admission.AdmissionRequest{
+		Operation: admission.Create,
+		Namespace: "team-a",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind":"Pod","metadata":{"generateName":"web-","labels":{"app":"web"}}}`)},
+	}
+	resp := rule.MutateAdmission(req)
+	require.True(t, resp.Allowed)
+	var patch []struct {
+		Path  string            `json:"path"`
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Patch, &patch))
+	require.Len(t, patch, 2)
+	assert.Equal(t, map[string]string{"app": "web", "project": "apollo", "cost-centre": "1234"}, patch[0].Value, "keys that the namespace doesn't have are left out")
+	assert.Equal(t, map[string]string{"owner": "team-a@acme.com"}, patch[1].Value)
+
+	req.Namespace = "team-b"
+	resp = rule.MutateAdmission(req)
+	assert.Nil(t, resp.Patch)
+	assert.Equal(t, "failed to mutate object: can't inherit from namespace 'team-b': not found", resp.Result.Message, "a namespace that can't be looked up fails the rule")
+
+	// a namespace's own request has its name as the namespace, but it has nothing to inherit
+	req.Kind.Kind = "Namespace"
+	req.Namespace = "team-a"
+	req.Object.Raw = []byte(`{"kind":"Namespace","metadata":{"name":"team-a"}}`)
+	resp = rule.MutateAdmission(req)
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Patch)
+}
+
+func TestExistingObjectsInheritFromTheRulesNamespaceLookup(t *testing.T) {
+	rule := Rule{Payload: Payload{InheritFromNamespace: InheritFromNamespace{Labels: []string{"project"}}}}
+	object := []byte(`{"kind":"ConfigMap","metadata":{"name":"settings","namespace":"team-a"}}`)
+
+	_, err := rule.Mutate(object)
+	assert.EqualError(t, err, "can't inherit from namespace 'team-a', namespaces are not being looked up")
+
+	lookup := namespaces{"team-a": &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"project": "apollo"}}}}
+	patch, err := rule.WithNamespaceLookup(lookup).Mutate(object)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/labels","value":{"project":"apollo"}}]`, string(patch))
+}
+
+func TestInheritFromNamespaceValidation(t *testing.T) {
+	assert.NoError(t, Payload{InheritFromNamespace: InheritFromNamespace{Labels: []string{"acme.com/project"}}}.validate())
+	assert.EqualError(t, Payload{InheritFromNamespace: InheritFromNamespace{Annotations: []string{"not a key"}}}.validate(),
+		"invalid inherit-from-namespace key 'not a key': name part must consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character (e.g. 'MyName',  or 'my.name',  or '123-abc', regex used for validation is '([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]')")
+	assert.Error(t, Payload{InheritFromNamespace: InheritFromNamespace{Labels: []string{"project"}}, Block: true}.validate())
+}
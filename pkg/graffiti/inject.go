@@ -0,0 +1,216 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InjectedContainersAnnotation is the name of the annotation that lists the containers that have been injected into a
+// pod, so that they are only injected once, it is prefixed with "graffiti.<company-domain>/".
+const InjectedContainersAnnotation = "injected-containers"
+
+// InjectContainers appends containers, along with any volumes that they mount, to the spec of the pods that a rule matches.
+// The containers and volumes are written in the same way as in a pod spec.
+type InjectContainers struct {
+	Containers []map[string]interface{} `mapstructure:"containers" yaml:"containers,omitempty"`
+	Volumes    []map[string]interface{} `mapstructure:"volumes" yaml:"volumes,omitempty"`
+}
+
+// podObject is used for pulling out the parts of a pod that injection needs to know about.
+type podObject struct {
+	Kind string            `json:"kind"`
+	Meta metav1.ObjectMeta `json:"metadata"`
+	Spec struct {
+		Containers []struct {
+			Name string `json:"name"`
+		} `json:"containers"`
+		Volumes []struct {
+			Name string `json:"name"`
+		} `json:"volumes"`
+	} `json:"spec"`
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// InjectedAnnotation is the annotation that lists the containers that the payload has injected into a pod.
+func (p Payload) InjectedAnnotation() string {
+	return p.annotationPrefix() + "/" + InjectedContainersAnnotation
+}
+
+func (i InjectContainers) isEmpty() bool {
+	return len(i.Containers) == 0 && len(i.Volumes) == 0
+}
+
+// validate checks that the containers and volumes are valid pod spec fields.
+func (i InjectContainers) validate() error {
+	containers, volumes, err := i.decode()
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("inject-containers must contain at least one container")
+	}
+	for n, c := range containers {
+		if c.Name == "" || c.Image == "" {
+			return fmt.Errorf("inject-containers container %d must have a name and an image", n+1)
+		}
+	}
+	for n, v := range volumes {
+		if v.Name == "" {
+			return fmt.Errorf("inject-containers volume %d must have a name", n+1)
+		}
+	}
+	return nil
+}
+
+// decode converts the configured containers and volumes into their kubernetes types, rejecting unknown fields.
+func (i InjectContainers) decode() (containers []corev1.Container, volumes []corev1.Volume, err error) {
+	if err = decodeStrict(i.Containers, &containers); err != nil {
+		return nil, nil, fmt.Errorf("invalid inject-containers containers: %v", err)
+	}
+	if err = decodeStrict(i.Volumes, &volumes); err != nil {
+		return nil, nil, fmt.Errorf("invalid inject-containers volumes: %v", err)
+	}
+	return containers, volumes, nil
+}
+
+// patch creates the json patch operations that inject the containers and volumes that the pod doesn't already have,
+// recording them in the marker annotation.  Objects which are not pods are left alone.
+func (i InjectContainers) patch(raw []byte, marker string) (string, error) {
+	var pod podObject
+	if err := json.Unmarshal(raw, &pod); err != nil {
+		return "", fmt.Errorf("failed to unmarshal pod: %v", err)
+	}
+	if pod.Kind != "Pod" {
+		return "", nil
+	}
+
+	// the marker keeps a container from being injected again if it has since been removed from the pod
+	injected := make(map[string]bool)
+	for _, name := range strings.Split(pod.Meta.Annotations[marker], ",") {
+		if name != "" {
+			injected[name] = true
+		}
+	}
+	existingContainers := make(map[string]bool)
+	for _, c := range pod.Spec.Containers {
+		existingContainers[c.Name] = true
+	}
+	existingVolumes := make(map[string]bool)
+	for _, v := range pod.Spec.Volumes {
+		existingVolumes[v.Name] = true
+	}
+
+	var ops []patchOperation
+	for _, c := range i.Containers {
+		name, _ := c["name"].(string)
+		if injected[name] || existingContainers[name] {
+			continue
+		}
+		injected[name] = true
+		ops = append(ops, patchOperation{Op: "add", Path: "/spec/containers/-", Value: jsonCompatible(c)})
+	}
+	if len(ops) == 0 {
+		return "", nil
+	}
+
+	var newVolumes []interface{}
+	for _, v := range i.Volumes {
+		if name, _ := v["name"].(string); !existingVolumes[name] {
+			newVolumes = append(newVolumes, jsonCompatible(v))
+		}
+	}
+	if len(pod.Spec.Volumes) == 0 && len(newVolumes) > 0 {
+		ops = append(ops, patchOperation{Op: "add", Path: "/spec/volumes", Value: newVolumes})
+	} else {
+		for _, v := range newVolumes {
+			ops = append(ops, patchOperation{Op: "add", Path: "/spec/volumes/-", Value: v})
+		}
+	}
+
+	// record the injected containers by replacing the whole annotations map, see processMetadataAdditionsDeletions
+	var names []string
+	for name := range injected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	annotations := map[string]string{marker: strings.Join(names, ",")}
+	for k, v := range pod.Meta.Annotations {
+		if k != marker {
+			annotations[k] = v
+		}
+	}
+	ops = append(ops, patchOperation{Op: "add", Path: "/metadata/annotations", Value: annotations})
+
+	data, err := json.Marshal(ops)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeStrict converts configuration values into a kubernetes type by way of json.
+func decodeStrict(in, out interface{}) error {
+	data, err := json.Marshal(jsonCompatible(in))
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	return d.Decode(out)
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values produced by yaml into maps that json can marshal.
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = jsonCompatible(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = jsonCompatible(v)
+		}
+		return m
+	case []map[string]interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = jsonCompatible(v)
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = jsonCompatible(v)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const injectPayload = `
+inject-containers:
+  containers:
+  - name: log-shipper
+    image: acme/log-shipper:1.0
+    volumeMounts:
+    - name: logs
+      mountPath: /var/log/app
+  volumes:
+  - name: logs
+    emptyDir: {}
+`
+
+const testPod = `{
+  "kind": "Pod",
+  "metadata": {"name": "web", "namespace": "default", "annotations": {"owner": "dave"}},
+  "spec": {"containers": [{"name": "web", "image": "nginx"}]}
+}`
+
+func testInjectRule(t *testing.T) Rule {
+	var payload Payload
+	require.NoError(t, yaml.Unmarshal([]byte(injectPayload), &payload))
+	rule := Rule{Name: "inject-log-shipper", Payload: payload}
+	require.NoError(t, rule.Validate(log.Logger))
+	return rule
+}
+
+func TestInjectContainersIntoPod(t *testing.T) {
+	patch, err := testInjectRule(t).Mutate([]byte(testPod))
+	require.NoError(t, err)
+
+	var ops []map[string]interface{}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 3)
+	assert.Equal(t, "/spec/containers/-", ops[0]["path"])
+	assert.Equal(t, map[string]interface{}{
+		"name":         "log-shipper",
+		"image":        "acme/log-shipper:1.0",
+		"volumeMounts": []interface{}{map[string]interface{}{"name": "logs", "mountPath": "/var/log/app"}},
+	}, ops[0]["value"])
+	assert.Equal(t, "/spec/volumes", ops[1]["path"])
+	assert.Equal(t, "/metadata/annotations", ops[2]["path"])
+	assert.Equal(t, map[string]interface{}{"owner": "dave", "graffiti/injected-containers": "log-shipper"}, ops[2]["value"])
+}
+
+func TestInjectedContainersAreRecordedUnderTheCompanyDomain(t *testing.T) {
+	rule := testInjectRule(t)
+	rule.Payload = rule.Payload.WithCompanyDomain("acme.com")
+	assert.Equal(t, "graffiti.acme.com/injected-containers", rule.Payload.InjectedAnnotation())
+
+	patch, err := rule.Mutate([]byte(testPod))
+	require.NoError(t, err)
+	var ops []map[string]interface{}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 3)
+	assert.Equal(t, map[string]interface{}{"owner": "dave", "graffiti.acme.com/injected-containers": "log-shipper"}, ops[2]["value"])
+}
+
+func TestInjectContainersIsIdempotent(t *testing.T) {
+	rule := testInjectRule(t)
+	injected := `{"kind": "Pod", "metadata": {"name": "web", "annotations": {"graffiti/injected-containers": "log-shipper"}}, "spec": {"containers": [{"name": "web"}]}}`
+	patch, err := rule.Mutate([]byte(injected))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "containers listed in the marker annotation should not be injected again")
+
+	deployment := `{"kind": "Deployment", "metadata": {"name": "web"}, "spec": {}}`
+	patch, err = rule.Mutate([]byte(deployment))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "only pods should have containers injected")
+}
+
+func TestInjectContainersValidation(t *testing.T) {
+	p := Payload{InjectContainers: InjectContainers{Containers: []map[string]interface{}{{"name": "sidecar", "image": "busybox", "imagePullPolicee": "Always"}}}}
+	assert.EqualError(t, p.validate(), `invalid inject-containers containers: json: unknown field "imagePullPolicee"`)
+
+	p = Payload{InjectContainers: InjectContainers{Containers: []map[string]interface{}{{"name": "sidecar"}}}}
+	assert.EqualError(t, p.validate(), "inject-containers container 1 must have a name and an image")
+}
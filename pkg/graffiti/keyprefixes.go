@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KeyPrefixes restricts the prefixes of the label and annotation keys that rules add, so that a rule can't clobber
+// keys reserved by kubernetes or other tools, such as 'kubernetes.io/' or 'istio.io/'.  The Allowed prefixes can
+// contain '*' wildcards, such as '*.acme.com/', and their trailing '/' is optional.  Keys without a prefix are always
+// allowed, and so are all keys when there are no allowed prefixes.
+type KeyPrefixes struct {
+	Allowed []string `mapstructure:"allowed" yaml:"allowed,omitempty"`
+}
+
+// keyPrefixes are the key prefixes that rules are restricted to when their patches are made.
+var keyPrefixes KeyPrefixes
+
+// SetKeyPrefixes restricts the keys that rules add to objects to the allowed prefixes.
+func SetKeyPrefixes(k KeyPrefixes) {
+	keyPrefixes = k
+}
+
+// Validate checks that none of the allowed prefixes are empty or contain a '/' before their end.
+func (k KeyPrefixes) Validate() error {
+	for _, prefix := range k.Allowed {
+		trimmed := strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+		if trimmed == "" {
+			return fmt.Errorf("key-prefixes.allowed contains an empty prefix")
+		}
+		if strings.Contains(trimmed, "/") {
+			return fmt.Errorf("key-prefixes.allowed contains '%s', a prefix can only contain a '/' at its end", prefix)
+		}
+	}
+	return nil
+}
+
+// Allows is true when the label or annotation key has no prefix or has one of the allowed prefixes.
+func (k KeyPrefixes) Allows(key string) bool {
+	if len(k.Allowed) == 0 {
+		return true
+	}
+	i := strings.LastIndex(key, "/")
+	if i < 0 {
+		return true
+	}
+	for _, prefix := range k.Allowed {
+		if globMatch(strings.TrimSuffix(strings.TrimSpace(prefix), "/"), key[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns an error naming the first key that the payload adds without an allowed prefix.  It checks
+// the keys that a rule chooses: its additions, labels-from-fields, inherit-from-namespace and json-patch, and those
+// emitted by its rego policy.  The annotations that kube-graffiti writes itself aren't restricted.
+func (k KeyPrefixes) Check(p Payload) error {
+	if len(k.Allowed) == 0 {
+		return nil
+	}
+	labels := [][]string{mapKeys(p.Additions.Labels), mapKeys(p.LabelsFromFields), p.InheritFromNamespace.Labels, mapKeys(p.emittedLabels), mapKeys(p.inheritedLabels)}
+	annotations := [][]string{mapKeys(p.Additions.Annotations), p.InheritFromNamespace.Annotations, mapKeys(p.emittedAnnotations), mapKeys(p.inheritedAnnotations)}
+	patchLabels, patchAnnotations := jsonPatchKeys(p.JSONPatch)
+	labels = append(labels, patchLabels)
+	annotations = append(annotations, patchAnnotations)
+	for _, keys := range labels {
+		if err := k.check("label", keys); err != nil {
+			return err
+		}
+	}
+	for _, keys := range annotations {
+		if err := k.check("annotation", keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k KeyPrefixes) check(kind string, keys []string) error {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !k.Allows(key) {
+			return fmt.Errorf("%s key '%s' does not have one of the allowed key-prefixes: %s", kind, key, strings.Join(k.Allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// jsonPatchKeys returns the label and annotation keys that a json patch adds, either one at a time or by setting all
+// of an object's labels or annotations.  A patch that can't be parsed adds none, it is rejected by validation.
+func jsonPatchKeys(patch string) (labels, annotations []string) {
+	if patch == "" {
+		return nil, nil
+	}
+	var ops []struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(patch), &ops); err != nil {
+		return nil, nil
+	}
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for _, op := range ops {
+		if op.Op != "add" && op.Op != "replace" && op.Op != "copy" && op.Op != "move" {
+			continue
+		}
+		for field, keys := range map[string]*[]string{"/metadata/labels": &labels, "/metadata/annotations": &annotations} {
+			switch {
+			case op.Path == field:
+				if values, ok := op.Value.(map[string]interface{}); ok {
+					for key := range values {
+						*keys = append(*keys, key)
+					}
+				}
+			case strings.HasPrefix(op.Path, field+"/"):
+				*keys = append(*keys, unescape.Replace(strings.TrimPrefix(op.Path, field+"/")))
+			}
+		}
+	}
+	return labels, annotations
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPrefixesAllows(t *testing.T) {
+	k := KeyPrefixes{Allowed: []string{"*.acme.com/", "acme.com"}}
+	require.NoError(t, k.Validate())
+	assert.True(t, k.Allows("team"), "keys without a prefix are always allowed")
+	assert.True(t, k.Allows("acme.com/team"))
+	assert.True(t, k.Allows("billing.acme.com/cost-centre"))
+	assert.False(t, k.Allows("kubernetes.io/metadata.name"))
+	assert.False(t, k.Allows("sidecar.istio.io/inject"))
+	assert.False(t, k.Allows("notacme.com/team"))
+	assert.True(t, KeyPrefixes{}.Allows("kubernetes.io/metadata.name"), "everything is allowed without any prefixes")
+
+	assert.EqualError(t, KeyPrefixes{Allowed: []string{" / "}}.Validate(), "key-prefixes.allowed contains an empty prefix")
+	assert.Error(t, KeyPrefixes{Allowed: []string{"acme.com/team"}}.Validate())
+}
+
+func TestKeyPrefixesCheckTheKeysThatAPayloadAdds(t *testing.T) {
+	k := KeyPrefixes{Allowed: []string{"*.acme.com"}}
+	tests := []struct {
+		name    string
+		payload Payload
+		err     string
+	}{
+		{name: "allowed", payload: Payload{Additions: Additions{Labels: map[string]string{"team": "a", "ops.acme.com/tier": "1"}}}},
+		{name: "deletions aren't restricted", payload: Payload{Deletions: Deletions{Labels: []string{"kubernetes.io/x"}}}},
+		{
+			name:    "label addition",
+			payload: Payload{Additions: Additions{Labels: map[string]string{"app.kubernetes.io/name": "web"}}},
+			err:     "label key 'app.kubernetes.io/name' does not have one of the allowed key-prefixes: *.acme.com",
+		},
+		{
+			name:    "annotation addition",
+			payload: Payload{Additions: Additions{Annotations: map[string]string{"sidecar.istio.io/inject": "false"}}},
+			err:     "annotation key 'sidecar.istio.io/inject'",
+		},
+		{
+			name:    "labels from fields",
+			payload: Payload{LabelsFromFields: map[string]string{"istio.io/rev": ".metadata.name"}},
+			err:     "label key 'istio.io/rev'",
+		},
+		{
+			name:    "inherited from the namespace",
+			payload: Payload{InheritFromNamespace: InheritFromNamespace{Annotations: []string{"kubernetes.io/description"}}},
+			err:     "annotation key 'kubernetes.io/description'",
+		},
+		{
+			name:    "json-patch of a single key",
+			payload: Payload{JSONPatch: `[{"op": "add", "path": "/metadata/labels/istio.io~1rev", "value": "1"}]`},
+			err:     "label key 'istio.io/rev'",
+		},
+		{
+			name:    "json-patch of all of the annotations",
+			payload: Payload{JSONPatch: `[{"op": "replace", "path": "/metadata/annotations", "value": {"kubernetes.io/x": "1"}}]`},
+			err:     "annotation key 'kubernetes.io/x'",
+		},
+		{
+			name:    "json-patch of something else",
+			payload: Payload{JSONPatch: `[{"op": "add", "path": "/spec/replicas", "value": 1}]`},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := k.Check(tc.payload)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestKeyPrefixesAreEnforcedWhenPatching(t *testing.T) {
+	SetKeyPrefixes(KeyPrefixes{Allowed: []string{"*.acme.com"}})
+	defer SetKeyPrefixes(KeyPrefixes{})
+
+	rule := NewRule("rego-labels").MatchRego(`
+package graffiti
+match = true
+labels = {"istio.io/rev": "canary"}
+`)
+	_, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web"}}`))
+	require.Error(t, err, "the keys emitted by a rego policy are only known when patching")
+	assert.True(t, errors.Is(err, ErrPatchBuild))
+	assert.Contains(t, err.Error(), "label key 'istio.io/rev'")
+
+	patch, err := NewRule("allowed").AddLabels(map[string]string{"ops.acme.com/tier": "1"}).Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web"}}`))
+	require.NoError(t, err)
+	assert.Contains(t, string(patch), "ops.acme.com/tier")
+}
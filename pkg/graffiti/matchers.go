@@ -14,10 +14,14 @@ limitations under the License.
 package graffiti
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/google/cel-go/cel"
 	"github.com/rs/zerolog"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	labels "k8s.io/apimachinery/pkg/labels"
 )
@@ -25,16 +29,52 @@ import (
 // Matchers manages the rules of matching an object
 // This type is directly marshalled from config and so has mapstructure tags
 type Matchers struct {
+	// Names match the object's name, with '*' wildcards, and are AND'ed with the selectors.
+	Names           []string        `mapstructure:"names" yaml:"names,omitempty"`
 	LabelSelectors  []string        `mapstructure:"label-selectors" yaml:"label-selectors,omitempty"`
 	FieldSelectors  []string        `mapstructure:"field-selectors" yaml:"field-selectors,omitempty"`
 	BooleanOperator BooleanOperator `mapstructure:"boolean-operator" yaml:"boolean-operator,omitempty"`
+	// CEL is a Common Expression Language expression which must also be true for the object to match.
+	CEL string `mapstructure:"cel" yaml:"cel,omitempty"`
+	// Presets names preset matchers for security-relevant pod conditions, the object must match at least one of them.
+	Presets []string `mapstructure:"presets" yaml:"presets,omitempty"`
+	// RequestedBy matches the user making the admission request, and is AND'ed with the selectors.
+	RequestedBy UserMatcher `mapstructure:"requested-by" yaml:"requested-by,omitempty"`
+	// Ingress matches Ingresses, Gateways and routes by class and hostname, and is AND'ed with the selectors.
+	Ingress IngressMatcher `mapstructure:"ingress" yaml:"ingress,omitempty"`
+	// HasField and MissingField are json paths, like those of labels-from-fields, of fields that the object must
+	// have, or must not have, whatever their values.  HasLabel and MissingLabel are label keys that the object must,
+	// or must not, have.  They are all AND'ed with the selectors.
+	HasField     []string `mapstructure:"has-field" yaml:"has-field,omitempty"`
+	MissingField []string `mapstructure:"missing-field" yaml:"missing-field,omitempty"`
+	HasLabel     []string `mapstructure:"has-label" yaml:"has-label,omitempty"`
+	MissingLabel []string `mapstructure:"missing-label" yaml:"missing-label,omitempty"`
+	// External asks an http endpoint whether the object matches, and is AND'ed with the selectors.  It is only
+	// called for objects that all of the other matchers match.
+	External ExternalMatcher `mapstructure:"external" yaml:"external,omitempty"`
+	// Negate inverts the result of all of the matchers, so that the rule matches the objects which they don't.
+	Negate bool `mapstructure:"negate" yaml:"negate,omitempty"`
+	// Exceptions are objects that the rule never matches, even when the other matchers, negated or not, match them.
+	Exceptions Exceptions `mapstructure:"exceptions" yaml:"exceptions,omitempty"`
+	// NormalizeVersions converts the object to the preferred api version of its kind before it is matched, so that
+	// one set of matchers works whichever version it was made with, e.g. the serviceName of an extensions/v1beta1
+	// Ingress's backend is matched as service.name.  The object that is patched is not converted.
+	NormalizeVersions bool `mapstructure:"normalize-versions" yaml:"normalize-versions,omitempty"`
 }
 
 func (m Matchers) validate(rulelog zerolog.Logger) error {
+	// names can't be empty...
+	for _, name := range m.Names {
+		if strings.TrimSpace(name) == "" {
+			rulelog.Error().Strs("names", m.Names).Msg("matcher contains an empty name")
+			return fmt.Errorf("matcher contains an empty name, use '*' to match any name")
+		}
+	}
+
 	// all label selectors must be valid...
 	if len(m.LabelSelectors) > 0 {
 		for _, selector := range m.LabelSelectors {
-			if err := ValidateLabelSelector(selector); err != nil {
+			if err := ValidateLabelSelector(withoutNegation(selector, ValidateLabelSelector)); err != nil {
 				rulelog.Error().Str("label-selector", selector).Msg("matcher contains an invalid label selector")
 				return fmt.Errorf("matcher contains an invalid label selector '%s': %v", selector, err)
 			}
@@ -44,18 +84,155 @@ func (m Matchers) validate(rulelog zerolog.Logger) error {
 	// all field selectors must also be valid...
 	if len(m.FieldSelectors) > 0 {
 		for _, selector := range m.FieldSelectors {
-			if err := validateFieldSelector(selector); err != nil {
+			if err := validateFieldSelector(withoutNegation(selector, nil)); err != nil {
 				rulelog.Error().Str("field-selector", selector).Msg("matcher contains an invalid field selector")
 				return fmt.Errorf("matcher contains invalid field selector '%s': %v", selector, err)
 			}
 		}
 	}
+
+	// fields must be valid json paths and labels valid keys...
+	if err := m.validatePresence(); err != nil {
+		rulelog.Error().Err(err).Msg("matcher contains an invalid has or missing field or label")
+		return fmt.Errorf("matcher contains an invalid has or missing field or label: %v", err)
+	}
+
+	// and any cel expression must compile...
+	if m.CEL != "" {
+		if err := validateCEL(m.CEL); err != nil {
+			rulelog.Error().Str("cel", m.CEL).Msg("matcher contains an invalid cel expression")
+			return fmt.Errorf("matcher contains an invalid cel expression '%s': %v", m.CEL, err)
+		}
+	}
+
+	// and service accounts must be complete...
+	if err := m.RequestedBy.validate(); err != nil {
+		rulelog.Error().Err(err).Msg("matcher contains an invalid requested-by")
+		return fmt.Errorf("matcher contains an invalid requested-by: %v", err)
+	}
+
+	// and an external matcher needs a valid url...
+	if err := m.External.validate(); err != nil {
+		rulelog.Error().Err(err).Msg("matcher contains an invalid external matcher")
+		return fmt.Errorf("matcher contains an invalid external matcher: %v", err)
+	}
+
+	// and presets must exist...
+	if len(m.Presets) > 0 {
+		expression, err := presetsCEL(m.Presets)
+		if err == nil {
+			err = validateCEL(expression)
+		}
+		if err != nil {
+			rulelog.Error().Strs("presets", m.Presets).Msg("matcher contains an invalid preset")
+			return fmt.Errorf("matcher contains an invalid preset: %v", err)
+		}
+	}
+	if !m.Exceptions.empty() {
+		return m.Exceptions.validate(rulelog)
+	}
 	return nil
 }
 
+// ListSelectors converts the matchers into a label selector and a field selector that can be passed to a kubernetes
+// List call so that the apiserver filters objects for us instead of sending us every object of a type.
+// It only pushes down selectors when the objects selected are guaranteed to be a superset of those that the matchers
+// would match, so the matchers must still be evaluated against each object that is returned.
+func (m Matchers) ListSelectors() (labelSelector, fieldSelector string) {
+	// a negated rule matches the objects which the selectors don't select.
+	if m.Negate {
+		return "", ""
+	}
+	// only AND guarantees that an object must match each type of selector that has been specified,
+	// and multiple selectors of a type are OR'ed which can not be expressed in a single list selector.
+	if m.BooleanOperator == AND {
+		if len(m.LabelSelectors) == 1 && canPushDownLabelSelector(m.LabelSelectors[0]) {
+			labelSelector = m.LabelSelectors[0]
+		}
+		if len(m.FieldSelectors) == 1 && canPushDownFieldSelector(m.FieldSelectors[0]) {
+			fieldSelector = m.FieldSelectors[0]
+		}
+	}
+	// labels that must or must not be there are AND'ed with the selectors whatever the operator, so they can always
+	// be pushed down too.
+	if presence := m.presenceLabelSelector(); presence != "" {
+		if labelSelector != "" {
+			presence = labelSelector + "," + presence
+		}
+		labelSelector = presence
+	}
+	// names are AND'ed with the selectors whatever the operator, so a single name without wildcards can always be
+	// pushed down.
+	if len(m.Names) == 1 && !strings.Contains(m.Names[0], "*") {
+		nameSelector := "metadata.name=" + fields.EscapeValue(m.Names[0])
+		if fieldSelector != "" {
+			nameSelector = fieldSelector + "," + nameSelector
+		}
+		fieldSelector = nameSelector
+	}
+	return labelSelector, fieldSelector
+}
+
+// canPushDownLabelSelector is false when the selector uses the 'name' or 'namespace' pseudo labels because
+// the apiserver only knows about the labels that are really on the object.
+func canPushDownLabelSelector(selector string) bool {
+	realSelector, err := parseLabelSelector(selector)
+	if err != nil {
+		return false
+	}
+	reqs, selectable := realSelector.Requirements()
+	if !selectable {
+		return false
+	}
+	for _, req := range reqs {
+		if req.Key() == "name" || req.Key() == "namespace" {
+			return false
+		}
+	}
+	return true
+}
+
+// serverSupportedFields are the field selectors that the apiserver supports for every type of object.
+var serverSupportedFields = map[string]bool{
+	"metadata.name":      true,
+	"metadata.namespace": true,
+}
+
+// canPushDownFieldSelector is only true when all the fields in the selector are supported by the apiserver.
+func canPushDownFieldSelector(selector string) bool {
+	realSelector, err := parseFieldSelector(selector)
+	if err != nil || realSelector.Empty() {
+		return false
+	}
+	for _, req := range realSelector.Requirements() {
+		if !serverSupportedFields[req.Field] {
+			return false
+		}
+	}
+	return true
+}
+
+// negatedSelector splits a leading '!' from a selector, which negates the whole selector, e.g. '!team=platform'
+// matches the objects that are not labelled team=platform.  Label selectors which kubernetes can parse as they are,
+// such as '!team' (there is no team label), keep their kubernetes meaning, so validate is nil for field selectors
+// which have no '!' syntax of their own (although their parser happily accepts it as part of a field name).
+func negatedSelector(selector string, validate func(string) error) (string, bool) {
+	trimmed := strings.TrimSpace(selector)
+	if !strings.HasPrefix(trimmed, "!") || (validate != nil && validate(selector) == nil) {
+		return selector, false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(trimmed, "!")), true
+}
+
+// withoutNegation returns the selector without any negating '!'.
+func withoutNegation(selector string, validate func(string) error) string {
+	selector, _ = negatedSelector(selector, validate)
+	return selector
+}
+
 // validateLabelSelector checks that a label selector parses correctly and is used when validating config
 func ValidateLabelSelector(selector string) error {
-	if _, err := labels.Parse(selector); err != nil {
+	if _, err := parseLabelSelector(selector); err != nil {
 		return err
 	}
 	return nil
@@ -63,13 +240,99 @@ func ValidateLabelSelector(selector string) error {
 
 // validateFieldSelector checks that a field selector parses correctly and is used when validating config
 func validateFieldSelector(selector string) error {
-	if _, err := fields.ParseSelector(selector); err != nil {
+	if _, err := parseFieldSelector(selector); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m Matchers) matches(obj metaObject, fm map[string]string, mylog zerolog.Logger) (match bool, err error) {
+// matches decides whether the object matches the label and field selectors and, if it has one, the cel expression.
+// The requesting user and cel expression are always AND'ed with the result of the selectors, and negate inverts the
+// combined result.  Exceptions are checked first, so that the other matchers aren't evaluated for objects that they
+// carve out.  The user is nil when the object isn't part of an admission request.
+func (m Matchers) matches(c *compiledMatchers, obj metaObject, fm map[string]string, object, oldObject []byte, user *authenticationv1.UserInfo, mylog zerolog.Logger) (bool, error) {
+	excepted, err := m.Exceptions.matches(c.exceptions, obj, fm, mylog)
+	if err != nil {
+		return false, err
+	}
+	if excepted {
+		mylog.Debug().Msg("object matches the exceptions, so the rule doesn't match")
+		return false, nil
+	}
+	match, err := m.matchAll(c, obj, fm, object, oldObject, user, mylog)
+	if err != nil || !m.Negate {
+		return match, err
+	}
+	mylog.Debug().Bool("matched", match).Msg("negating the result of the matchers")
+	return !match, nil
+}
+
+func (m Matchers) matchAll(c *compiledMatchers, obj metaObject, fm map[string]string, object, oldObject []byte, user *authenticationv1.UserInfo, mylog zerolog.Logger) (bool, error) {
+	match, err := m.matchSelectors(c, obj, fm, mylog)
+	if err != nil || !match {
+		return match, err
+	}
+	if len(m.Names) > 0 && !matchAny(m.Names, obj.Meta.Name) {
+		mylog.Debug().Str("name", obj.Meta.Name).Msg("object name doesn't match")
+		return false, nil
+	}
+	if !m.emptyPresence() {
+		if match, err := m.matchesPresence(obj, object); err != nil || !match {
+			mylog.Debug().Msg("object doesn't have, or has, the fields or labels")
+			return false, err
+		}
+	}
+	if !m.RequestedBy.empty() && !m.RequestedBy.matches(user) {
+		mylog.Debug().Msg("requesting user doesn't match")
+		return false, nil
+	}
+	if !m.Ingress.empty() {
+		if match, err := m.Ingress.matches(object); err != nil || !match {
+			mylog.Debug().Msg("ingress class or hosts don't match")
+			return false, err
+		}
+	}
+	if c.cel != nil {
+		mylog.Debug().Str("cel", m.CEL).Strs("presets", m.Presets).Msg("matching against cel expression")
+		if match, err = matchCELObjects(c.cel, object, oldObject); err != nil || !match {
+			return match, err
+		}
+	}
+
+	// the external matcher is the slowest, so it is only asked about objects that everything else matches
+	if !m.External.empty() {
+		mylog.Debug().Str("url", m.External.URL).Msg("asking the external matcher")
+		return m.External.matches(object, oldObject, mylog), nil
+	}
+	return match, nil
+}
+
+// celExpression combines the cel expression and any presets into the single expression which must be true to match.
+func (m Matchers) celExpression() (string, error) {
+	if len(m.Presets) == 0 {
+		return m.CEL, nil
+	}
+	expression, err := presetsCEL(m.Presets)
+	if err != nil || m.CEL == "" {
+		return expression, err
+	}
+	return "(" + m.CEL + ") && (" + expression + ")", nil
+}
+
+func matchCELObjects(prg cel.Program, object, oldObject []byte) (bool, error) {
+	var obj, old map[string]interface{}
+	if err := json.Unmarshal(object, &obj); err != nil {
+		return false, fmt.Errorf("failed to unmarshal object for cel expression: %v", err)
+	}
+	if len(oldObject) > 0 {
+		if err := json.Unmarshal(oldObject, &old); err != nil {
+			return false, fmt.Errorf("failed to unmarshal old object for cel expression: %v", err)
+		}
+	}
+	return evalCEL(prg, obj, old)
+}
+
+func (m Matchers) matchSelectors(c *compiledMatchers, obj metaObject, fm map[string]string, mylog zerolog.Logger) (match bool, err error) {
 	var labelMatches, fieldMatches bool
 	if len(m.LabelSelectors) == 0 && len(m.FieldSelectors) == 0 {
 		mylog.Debug().Msg("rule does not contain any label or field selectors so it matches ALL")
@@ -78,17 +341,13 @@ func (m Matchers) matches(obj metaObject, fm map[string]string, mylog zerolog.Lo
 
 	// match against all of the label selectors
 	mylog.Debug().Int("count", len(m.LabelSelectors)).Msg("matching against label selectors")
-	labelMatches, err = m.matchLabelSelectors(obj)
-	if err != nil {
-		return false, err
+	if len(c.labelSelectors) != 0 {
+		labelMatches = matchAnySelector(c.labelSelectors, selectorLabels(obj), mylog)
 	}
 
 	// test if we match any field selectors
 	mylog.Debug().Int("count", len(m.FieldSelectors)).Msg("matching against field selectors")
-	fieldMatches, err = m.matchFieldSelectors(fm)
-	if err != nil {
-		return false, err
-	}
+	fieldMatches = matchAnySelector(c.fieldSelectors, fm, mylog)
 
 	// Combine selector booleans and decide to paint object or not
 	descisonLog := mylog.With().Int("label-selectors-length", len(m.LabelSelectors)).Bool("labels-matched", labelMatches).Int("field-selector-length", len(m.FieldSelectors)).Bool("fields-matched", fieldMatches).Logger()
@@ -108,31 +367,27 @@ func (m Matchers) matches(obj metaObject, fm map[string]string, mylog zerolog.Lo
 	}
 }
 
-func (m Matchers) matchLabelSelectors(object metaObject) (bool, error) {
-	mylog := log.ComponentLogger(componentName, "matchLabelSelectors")
-	// test if we matched any of the label selectors
-	if len(m.LabelSelectors) != 0 {
-		sourceLabels := make(map[string]string)
-		// make it so we can use name and namespace as label selectors
-		sourceLabels["name"] = object.Meta.Name
-		sourceLabels["namespace"] = object.Meta.Namespace
-		for k, v := range object.Meta.Labels {
-			sourceLabels[k] = v
+// matchAnySelector is true when any of the selectors matches the labels or fields.
+func matchAnySelector(selectors []compiledSelector, set labels.Set, mylog zerolog.Logger) bool {
+	for _, selector := range selectors {
+		if selector.matches(set) {
+			mylog.Debug().Str("selector", selector.selector).Msg("selector matches, will modify object")
+			return true
 		}
+	}
+	return false
+}
 
-		for _, selector := range m.LabelSelectors {
-			mylog.Debug().Str("label-selector", selector).Msg("testing label selector")
-			selectorMatch, err := MatchLabelSelector(selector, sourceLabels)
-			if err != nil {
-				return false, err
-			}
-			if selectorMatch {
-				mylog.Debug().Str("label-selector", selector).Msg("selector matches, will modify object")
-				return true, nil
-			}
-		}
+// selectorLabels are the labels that label selectors are matched against, the object's labels along with its name and
+// namespace so that they can be used as label selectors.
+func selectorLabels(object metaObject) map[string]string {
+	sourceLabels := make(map[string]string)
+	sourceLabels["name"] = object.Meta.Name
+	sourceLabels["namespace"] = object.Meta.Namespace
+	for k, v := range object.Meta.Labels {
+		sourceLabels[k] = v
 	}
-	return false, nil
+	return sourceLabels
 }
 
 // matchLabelSelector will apply a kubernetes labels.Selector to a map[string]string and return a matched bool and error.
@@ -141,7 +396,7 @@ func MatchLabelSelector(selector string, target map[string]string) (bool, error)
 	mylog := log.ComponentLogger(componentName, "MatchLabelSelector")
 	selLog := mylog.With().Str("selector", selector).Logger()
 
-	realSelector, err := labels.Parse(selector)
+	realSelector, err := parseLabelSelector(selector)
 	if err != nil {
 		selLog.Error().Err(err).Msg("could not parse selector")
 		return false, err
@@ -156,29 +411,11 @@ func MatchLabelSelector(selector string, target map[string]string) (bool, error)
 	return true, nil
 }
 
-func (m Matchers) matchFieldSelectors(fm map[string]string) (bool, error) {
-	mylog := log.ComponentLogger(componentName, "matchFieldSelectors")
-	if len(m.FieldSelectors) != 0 {
-		for _, selector := range m.FieldSelectors {
-			mylog.Debug().Str("field-selector", selector).Msg("testing field selector")
-			selectorMatch, err := matchFieldSelector(selector, fm)
-			if err != nil {
-				return false, err
-			}
-			if selectorMatch {
-				mylog.Debug().Str("field-selector", selector).Msg("selector matches, will modify object")
-				return true, nil
-			}
-		}
-	}
-	return false, nil
-}
-
 // matchSelector will apply a kubernetes labels.Selector to a map[string]string and return a matched bool and error.
 func matchFieldSelector(selector string, target map[string]string) (bool, error) {
 	mylog := log.ComponentLogger(componentName, "matchFieldSelector")
 	selLog := mylog.With().Str("selector", selector).Logger()
-	realSelector, err := fields.ParseSelector(selector)
+	realSelector, err := parseFieldSelector(selector)
 	if err != nil {
 		selLog.Error().Err(err).Msg("could not parse selector")
 		return false, err
@@ -15,15 +15,19 @@ package graffiti
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	jsonpatch "github.com/cameront/go-jsonpatch"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	yaml "gopkg.in/yaml.v2"
 	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 func TestRulesContainingInvalidLabelSelectorsFailValidation(t *testing.T) {
@@ -682,3 +686,263 @@ func TestLabelAndFieldSelectorsXORanEmptySelectorIsNotAMatch(t *testing.T) {
 	assert.Equal(t, true, resp.Allowed, "the request should be successful")
 	assert.Nil(t, resp.Patch)
 }
+
+func TestListSelectorsPushesDownSingleSelectors(t *testing.T) {
+	m := Matchers{
+		LabelSelectors: []string{"fruit = apple,colour in (red,green)"},
+		FieldSelectors: []string{"metadata.namespace!=kube-system"},
+	}
+	labelSelector, fieldSelector := m.ListSelectors()
+	assert.Equal(t, "fruit = apple,colour in (red,green)", labelSelector)
+	assert.Equal(t, "metadata.namespace!=kube-system", fieldSelector)
+}
+
+func TestListSelectorsDoesNotPushDownMultipleSelectors(t *testing.T) {
+	m := Matchers{
+		LabelSelectors: []string{"fruit = apple", "fruit = pear"},
+		FieldSelectors: []string{"metadata.name=a", "metadata.name=b"},
+	}
+	labelSelector, fieldSelector := m.ListSelectors()
+	assert.Equal(t, "", labelSelector, "OR'ed label selectors can't be pushed down")
+	assert.Equal(t, "", fieldSelector, "OR'ed field selectors can't be pushed down")
+}
+
+func TestListSelectorsDoesNotPushDownPseudoLabels(t *testing.T) {
+	m := Matchers{
+		LabelSelectors: []string{"name notin (kube-system,kube-public)"},
+	}
+	labelSelector, _ := m.ListSelectors()
+	assert.Equal(t, "", labelSelector, "the name pseudo label is not known to the apiserver")
+}
+
+func TestListSelectorsDoesNotPushDownUnsupportedFields(t *testing.T) {
+	m := Matchers{
+		FieldSelectors: []string{"metadata.name=nginx,spec.template.spec.containers.0.image=nginx"},
+	}
+	_, fieldSelector := m.ListSelectors()
+	assert.Equal(t, "", fieldSelector, "only metadata.name and metadata.namespace are supported by every object type")
+}
+
+func TestListSelectorsOnlyPushesDownWithAND(t *testing.T) {
+	m := Matchers{
+		LabelSelectors:  []string{"fruit = apple"},
+		FieldSelectors:  []string{"metadata.name=nginx"},
+		BooleanOperator: OR,
+	}
+	labelSelector, fieldSelector := m.ListSelectors()
+	assert.Equal(t, "", labelSelector)
+	assert.Equal(t, "", fieldSelector)
+}
+
+func TestRulesContainingInvalidCELFailValidation(t *testing.T) {
+	matchers := Matchers{CEL: "object.metadata.name =="}
+	err := matchers.validate(log.Logger)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "matcher contains an invalid cel expression 'object.metadata.name =='")
+
+	matchers = Matchers{CEL: "'not a bool'"}
+	err = matchers.validate(log.Logger)
+	assert.EqualError(t, err, "matcher contains an invalid cel expression ''not a bool'': expression must evaluate to a bool")
+}
+
+func TestMatchingCELExpression(t *testing.T) {
+	rule := Rule{
+		Matchers: Matchers{CEL: `object.metadata.labels.author == "david" && object.status.phase == "Active"`},
+		Payload:  Payload{Additions: Additions{Labels: map[string]string{"painted": "true"}}},
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	var review = admission.AdmissionReview{}
+	err := json.Unmarshal([]byte(testReview), &review)
+	require.NoError(t, err, "couldn't marshall a valid admission review object from test json")
+
+	resp := rule.MutateAdmission(review.Request)
+	assert.True(t, resp.Allowed)
+	assert.NotNil(t, resp.Patch, "the cel expression should have matched")
+}
+
+func TestCELExpressionIsANDedWithSelectors(t *testing.T) {
+	rule := Rule{
+		Matchers: Matchers{
+			LabelSelectors: []string{"author=david"},
+			CEL:            `object.metadata.labels.group == "frontend"`,
+		},
+		Payload: Payload{Additions: Additions{Labels: map[string]string{"painted": "true"}}},
+	}
+
+	var review = admission.AdmissionReview{}
+	err := json.Unmarshal([]byte(testReview), &review)
+	require.NoError(t, err, "couldn't marshall a valid admission review object from test json")
+
+	resp := rule.MutateAdmission(review.Request)
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Patch, "the label selector matches but the cel expression does not")
+}
+
+func TestCELExpressionCanUseOldObject(t *testing.T) {
+	rule := Rule{Matchers: Matchers{CEL: `has(oldObject.metadata) && oldObject.metadata.labels.author != object.metadata.labels.author`}}
+	object := []byte(`{"metadata":{"name":"test","labels":{"author":"david"}}}`)
+
+	match, err := rule.Matches(object, nil)
+	require.NoError(t, err)
+	assert.False(t, match, "there is no old object on a create")
+
+	match, err = rule.Matches(object, []byte(`{"metadata":{"name":"test","labels":{"author":"fred"}}}`))
+	require.NoError(t, err)
+	assert.True(t, match, "the author label has changed")
+}
+
+func TestNegatedSelectorsMatchObjectsTheSelectorDoesNot(t *testing.T) {
+	platform := []byte(`{"kind":"Pod","metadata":{"name":"a","namespace":"default","labels":{"team":"platform"}}}`)
+	web := []byte(`{"kind":"Pod","metadata":{"name":"b","namespace":"default","labels":{"team":"web"}}}`)
+	unlabelled := []byte(`{"kind":"Pod","metadata":{"name":"c","namespace":"kube-system"}}`)
+
+	tests := []struct {
+		name     string
+		matchers Matchers
+		expected []bool
+	}{
+		{"negated label selector", Matchers{LabelSelectors: []string{"!team=platform"}}, []bool{false, true, true}},
+		{"kubernetes does not exist selector", Matchers{LabelSelectors: []string{"!team"}}, []bool{false, false, true}},
+		{"negated field selector", Matchers{FieldSelectors: []string{"! metadata.namespace=default"}}, []bool{false, false, true}},
+		{"negated matchers", Matchers{LabelSelectors: []string{"team in (platform,web)"}, Negate: true}, []bool{false, false, true}},
+		{"negated cel", Matchers{CEL: "object.metadata.name == 'a'", Negate: true}, []bool{false, true, true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.matchers.validate(log.Logger))
+			rule := Rule{Name: "test", Matchers: tc.matchers}
+			for i, object := range [][]byte{platform, web, unlabelled} {
+				match, err := rule.Matches(object, nil)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected[i], match, "object %d", i)
+			}
+		})
+	}
+}
+
+func TestNegatedSelectorsAreValidated(t *testing.T) {
+	err := Matchers{LabelSelectors: []string{"!team in ("}}.validate(log.Logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "matcher contains an invalid label selector '!team in ('")
+}
+
+func TestListSelectorsDoesNotPushDownNegations(t *testing.T) {
+	labelSelector, _ := Matchers{LabelSelectors: []string{"!team=platform"}}.ListSelectors()
+	assert.Equal(t, "", labelSelector)
+	labelSelector, _ = Matchers{LabelSelectors: []string{"team=platform"}, Negate: true}.ListSelectors()
+	assert.Equal(t, "", labelSelector, "negated matchers select the objects which the selectors don't")
+}
+
+func TestNamesMatchObjectNamesWithWildcards(t *testing.T) {
+	generated := []byte(`{"kind":"ConfigMap","metadata":{"name":"app-generated","namespace":"default","labels":{"team":"web"}}}`)
+	other := []byte(`{"kind":"ConfigMap","metadata":{"name":"app-config","namespace":"default","labels":{"team":"web"}}}`)
+
+	tests := []struct {
+		name     string
+		matchers Matchers
+		expected []bool
+	}{
+		{"suffix", Matchers{Names: []string{"*-generated"}}, []bool{true, false}},
+		{"any of the names", Matchers{Names: []string{"*-generated", "app-config"}}, []bool{true, true}},
+		{"and'ed with selectors", Matchers{Names: []string{"app-*"}, LabelSelectors: []string{"team=platform"}}, []bool{false, false}},
+		{"and'ed even with or", Matchers{Names: []string{"*-config"}, LabelSelectors: []string{"team=web"}, BooleanOperator: OR}, []bool{false, true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.matchers.validate(log.Logger))
+			rule := Rule{Name: "test", Matchers: tc.matchers}
+			for i, object := range [][]byte{generated, other} {
+				match, err := rule.Matches(object, nil)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expected[i], match, "object %d", i)
+			}
+		})
+	}
+
+	assert.Error(t, Matchers{Names: []string{""}}.validate(log.Logger), "an empty name can never match")
+}
+
+func TestListSelectorsPushesDownExactNames(t *testing.T) {
+	_, fieldSelector := Matchers{Names: []string{"app-config"}, FieldSelectors: []string{"metadata.namespace=default"}}.ListSelectors()
+	assert.Equal(t, "metadata.namespace=default,metadata.name=app-config", fieldSelector)
+
+	_, fieldSelector = Matchers{Names: []string{"app-config"}, BooleanOperator: OR}.ListSelectors()
+	assert.Equal(t, "metadata.name=app-config", fieldSelector, "names are always AND'ed")
+
+	_, fieldSelector = Matchers{Names: []string{"*-generated"}}.ListSelectors()
+	assert.Equal(t, "", fieldSelector, "the apiserver can't match wildcards")
+
+	_, fieldSelector = Matchers{Names: []string{"a", "b"}}.ListSelectors()
+	assert.Equal(t, "", fieldSelector, "several names can't be expressed in a single field selector")
+}
+
+// benchmarkRequest is a pod with enough labels and fields to be typical of the objects that rules are matched against.
+func benchmarkRequest() *admission.AdmissionRequest {
+	return &admission.AdmissionRequest{
+		Operation: admission.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind":"Pod","metadata":{"name":"web-5d8f9","namespace":"prod","labels":{"app":"web","team":"a","tier":"frontend","release":"stable"},"annotations":{"owner":"web"}},"spec":{"containers":[{"name":"web","image":"nginx:1.19","resources":{"limits":{"cpu":"1","memory":"1Gi"}}}]},"status":{"phase":"Running"}}`)},
+		UserInfo:  authenticationv1.UserInfo{Username: "system:serviceaccount:prod:deployer", Groups: []string{"system:serviceaccounts"}},
+	}
+}
+
+// benchmarkRule is the i'th of a set of rules with a spread of matchers, most of which don't match benchmarkRequest.
+func benchmarkRule(i int) Rule {
+	team := fmt.Sprintf("team-%d", i)
+	r := Rule{Name: team, Payload: Payload{Additions: Additions{Labels: map[string]string{"cost-center": team}}}}
+	switch i % 4 {
+	case 0:
+		r.Matchers = Matchers{LabelSelectors: []string{"team = " + team, "app in (web, api), tier != backend"}}
+	case 1:
+		r.Matchers = Matchers{LabelSelectors: []string{"!team = " + team}, FieldSelectors: []string{"metadata.namespace=prod", "status.phase=Pending"}, BooleanOperator: OR}
+	case 2:
+		r.Matchers = Matchers{Names: []string{"web-*"}, Presets: []string{PresetRunAsRoot}, RequestedBy: UserMatcher{ServiceAccounts: []string{"prod/*"}}}
+	default:
+		r.Matchers = Matchers{FieldSelectors: []string{"metadata.namespace=" + team}, CEL: `object.metadata.labels.app == "web"`}
+	}
+	return r
+}
+
+// benchmarkMatches matches the rules against benchmarkRequest, after compiling them as loading them does, or compiling
+// them for each object when compiled is false.
+func benchmarkMatches(b *testing.B, rules []Rule, compiled bool) {
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(level)
+	req := benchmarkRequest()
+	for i, r := range rules {
+		require.NoError(b, r.Validate(log.Logger))
+		if compiled {
+			rules[i] = r.Compiled()
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, r := range rules {
+			if _, err := r.MatchesAdmission(req); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkMatchesAdmission(b *testing.B) {
+	for _, i := range []int{0, 1, 2, 3} {
+		b.Run(benchmarkRule(i).Name, func(b *testing.B) {
+			benchmarkMatches(b, []Rule{benchmarkRule(i)}, true)
+		})
+	}
+}
+
+func BenchmarkMatchesAdmissionHundredsOfRules(b *testing.B) {
+	for _, compiled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("compiled=%t", compiled), func(b *testing.B) {
+			var rules []Rule
+			for i := 0; i < 300; i++ {
+				rules = append(rules, benchmarkRule(i))
+			}
+			benchmarkMatches(b, rules, compiled)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// OwnerReferences adds and removes entries of an object's metadata.ownerReferences, linking it to the objects that own
+// it, such as a custom resource, so that it is garbage collected along with them.
+type OwnerReferences struct {
+	Add []OwnerReference `mapstructure:"add" yaml:"add,omitempty"`
+	// Remove removes the references to owners, given as '<kind>/<name>'.
+	Remove []string `mapstructure:"remove" yaml:"remove,omitempty"`
+}
+
+// OwnerReference is an owner of the object, its name and uid are templates rendered with the object's fields, in
+// the same way as additions, as the owner is usually found from the object.  An owner must be in the object's
+// namespace or be cluster scoped.
+type OwnerReference struct {
+	APIVersion         string `mapstructure:"api-version" yaml:"api-version"`
+	Kind               string `mapstructure:"kind" yaml:"kind"`
+	Name               string `mapstructure:"name" yaml:"name"`
+	UID                string `mapstructure:"uid" yaml:"uid"`
+	Controller         bool   `mapstructure:"controller" yaml:"controller,omitempty"`
+	BlockOwnerDeletion bool   `mapstructure:"block-owner-deletion" yaml:"block-owner-deletion,omitempty"`
+}
+
+func (o OwnerReferences) isEmpty() bool {
+	return len(o.Add) == 0 && len(o.Remove) == 0
+}
+
+func (o OwnerReferences) validate() error {
+	controllers := 0
+	for _, owner := range o.Add {
+		if owner.APIVersion == "" || owner.Kind == "" || owner.Name == "" || owner.UID == "" {
+			return errors.New("an owner reference needs an api-version, kind, name and uid")
+		}
+		for _, field := range []string{owner.Name, owner.UID} {
+			if _, err := parseTemplate(field); err != nil {
+				return fmt.Errorf("invalid owner reference template '%s': %v", field, err)
+			}
+		}
+		if owner.Controller {
+			controllers++
+		}
+	}
+	if controllers > 1 {
+		return errors.New("only one owner reference can be the controller")
+	}
+	for _, owner := range o.Remove {
+		if kind, name := splitOwner(owner); kind == "" || name == "" {
+			return fmt.Errorf("invalid owner reference to remove '%s', must be '<kind>/<name>'", owner)
+		}
+	}
+	return nil
+}
+
+// splitOwner splits a '<kind>/<name>' owner into its kind and name.
+func splitOwner(owner string) (kind, name string) {
+	parts := strings.SplitN(owner, "/", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// patchOperand returns the patch operation that changes the object's owner references, or an empty string when they
+// already are what the payload asks for.  An added owner replaces any reference to an owner with the same kind and name.
+func (o OwnerReferences) patchOperand(current []metav1.OwnerReference, fm map[string]string) (string, error) {
+	var added []metav1.OwnerReference
+	for _, owner := range o.Add {
+		name, err := renderStringTemplate(owner.Name, fm)
+		if err != nil {
+			return "", err
+		}
+		uid, err := renderStringTemplate(owner.UID, fm)
+		if err != nil {
+			return "", err
+		}
+		if name == "" || uid == "" {
+			return "", fmt.Errorf("owner reference to %s '%s' rendered an empty name or uid", owner.Kind, owner.Name)
+		}
+		ref := metav1.OwnerReference{APIVersion: owner.APIVersion, Kind: owner.Kind, Name: name, UID: types.UID(uid)}
+		if owner.Controller {
+			controller := true
+			ref.Controller = &controller
+		}
+		if owner.BlockOwnerDeletion {
+			block := true
+			ref.BlockOwnerDeletion = &block
+		}
+		added = append(added, ref)
+	}
+
+	replaced := make(map[string]bool)
+	for _, owner := range o.Remove {
+		replaced[owner] = true
+	}
+	for _, ref := range added {
+		replaced[ref.Kind+"/"+ref.Name] = true
+	}
+	modified := []metav1.OwnerReference{}
+	for _, ref := range current {
+		if !replaced[ref.Kind+"/"+ref.Name] {
+			modified = append(modified, ref)
+		}
+	}
+	modified = append(modified, added...)
+	if sameOwners(modified, current) {
+		return "", nil
+	}
+	return listPatchOperand("/metadata/ownerReferences", len(current) > 0, len(modified) == 0, modified)
+}
+
+// sameOwners is true when the lists reference the same owners, in any order.
+func sameOwners(a, b []metav1.OwnerReference) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	owners := make(map[string]metav1.OwnerReference, len(a))
+	for _, ref := range a {
+		owners[ref.Kind+"/"+ref.Name] = ref
+	}
+	for _, ref := range b {
+		other, ok := owners[ref.Kind+"/"+ref.Name]
+		if !ok || other.APIVersion != ref.APIVersion || other.UID != ref.UID || !equalBool(other.Controller, ref.Controller) || !equalBool(other.BlockOwnerDeletion, ref.BlockOwnerDeletion) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalBool compares optional booleans, where unset is false.
+func equalBool(a, b *bool) bool {
+	return (a != nil && *a) == (b != nil && *b)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwnerReferencesAreRenderedAndReplaced(t *testing.T) {
+	rule := Rule{Name: "owned-by-app", Payload: Payload{OwnerReferences: OwnerReferences{Add: []OwnerReference{{
+		APIVersion: "apps.acme.com/v1",
+		Kind:       "App",
+		Name:       `{{ index . "metadata.labels.app" }}`,
+		UID:        `{{ index . "metadata.annotations.acme.com/app-uid" }}`,
+		Controller: true,
+	}}}}}
+
+	object := `{"kind":"ConfigMap","metadata":{"name":"settings","labels":{"app":"web"},"annotations":{"acme.com/app-uid":"1234"}}}`
+	patch, err := rule.Mutate([]byte(object))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/ownerReferences","value":[{"apiVersion":"apps.acme.com/v1","kind":"App","name":"web","uid":"1234","controller":true}]}]`, string(patch))
+
+	owned := `{"kind":"ConfigMap","metadata":{"name":"settings","labels":{"app":"web"},"annotations":{"acme.com/app-uid":"1234"},
+		"ownerReferences":[{"apiVersion":"apps.acme.com/v1","kind":"App","name":"web","uid":"1234","controller":true}]}}`
+	patch, err = rule.Mutate([]byte(owned))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "an object that already has the owner isn't patched")
+
+	stale := `{"kind":"ConfigMap","metadata":{"name":"settings","labels":{"app":"web"},"annotations":{"acme.com/app-uid":"1234"},
+		"ownerReferences":[{"apiVersion":"v1","kind":"Namespace","name":"team-a","uid":"1"},{"apiVersion":"apps.acme.com/v1","kind":"App","name":"web","uid":"999"}]}}`
+	patch, err = rule.Mutate([]byte(stale))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"replace","path":"/metadata/ownerReferences","value":[{"apiVersion":"v1","kind":"Namespace","name":"team-a","uid":"1"},{"apiVersion":"apps.acme.com/v1","kind":"App","name":"web","uid":"1234","controller":true}]}]`, string(patch))
+
+	_, err = rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"settings"}}`))
+	assert.Error(t, err, "an owner without a uid can't be referenced")
+}
+
+func TestOwnerReferencesAreRemoved(t *testing.T) {
+	rule := Rule{Name: "disown", Payload: Payload{OwnerReferences: OwnerReferences{Remove: []string{"App/web"}}}}
+	patch, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"settings","ownerReferences":[{"apiVersion":"apps.acme.com/v1","kind":"App","name":"web","uid":"1"}]}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"remove","path":"/metadata/ownerReferences"}]`, string(patch))
+
+	patch, err = rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"settings"}}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch)
+}
+
+func TestOwnerReferencesValidation(t *testing.T) {
+	owner := OwnerReference{APIVersion: "apps.acme.com/v1", Kind: "App", Name: "web", UID: "1234", Controller: true}
+	assert.NoError(t, Payload{OwnerReferences: OwnerReferences{Add: []OwnerReference{owner}}}.validate())
+	assert.EqualError(t, Payload{OwnerReferences: OwnerReferences{Add: []OwnerReference{{Kind: "App", Name: "web"}}}}.validate(),
+		"an owner reference needs an api-version, kind, name and uid")
+	assert.EqualError(t, Payload{OwnerReferences: OwnerReferences{Add: []OwnerReference{owner, owner}}}.validate(),
+		"only one owner reference can be the controller")
+	assert.EqualError(t, Payload{OwnerReferences: OwnerReferences{Remove: []string{"web"}}}.validate(),
+		"invalid owner reference to remove 'web', must be '<kind>/<name>'")
+}
@@ -18,21 +18,21 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
-	"text/template"
-    "github.com/Masterminds/sprig"
 )
 
-func createPatchOperand(src, add, fm map[string]string, del []string, path string) (string, error) {
+func createPatchOperand(src, add, literal, fm map[string]string, del []string, path string) (string, error) {
 	modified := mergeMaps(src)
 
 	// first process any additions into modified map
 	if len(add) > 0 {
-		rendered, err := renderMapValues(add, fm)
+		rendered, err := renderAdditions(add, src, fm)
 		if err != nil {
 			return "", err
 		}
 		modified = mergeMaps(src, rendered)
 	}
+	// literal additions are never rendered as templates, as their values may come from the admission request
+	modified = mergeMaps(modified, literal)
 
 	// then process any deletions into modified map
 	if len(del) > 0 {
@@ -50,7 +50,7 @@ func createPatchOperand(src, add, fm map[string]string, del []string, path strin
 
 	// when we have deleted all labels or annotations then we need to remove the whole path.
 	if len(src) > 0 && len(modified) == 0 {
-		return `{ "op": "delete", "path": "` + path + `" }`, nil
+		return `{ "op": "remove", "path": "` + path + `" }`, nil
 	}
 	// we are left with new values, we need to either add a new path or replace it.
 	if len(src) == 0 {
@@ -75,6 +75,7 @@ func renderStringMapAsPatch(op, path string, m map[string]string) string {
 
 func escapeString(s string) string {
 	result := strings.Replace(s, "\n", "", -1)
+	result = strings.Replace(result, `\`, `\\`, -1)
 	return strings.Replace(result, `"`, `\"`, -1)
 }
 
@@ -105,7 +106,7 @@ func renderMapValues(src, data map[string]string) (map[string]string, error) {
 // renderStringTemplate will treat the input string as a template and render with data as its context
 // useful for allowing dynamically created values.
 func renderStringTemplate(field string, data interface{}) (string, error) {
-	tmpl, err := template.New("field").Funcs(sprig.TxtFuncMap()).Parse(field)
+	tmpl, err := parseTemplate(field)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse field template: %v", err)
 	}
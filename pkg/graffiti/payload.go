@@ -17,10 +17,12 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Telefonica/kube-graffiti/pkg/log"
 	jsonpatch "github.com/cameront/go-jsonpatch"
 	"github.com/rs/zerolog"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
@@ -31,8 +33,55 @@ import (
 type Payload struct {
 	Additions Additions `mapstructure:"additions" yaml:"additions,omitempty"`
 	Deletions Deletions `mapstructure:"deletions" yaml:"deletions,omitempty"`
-	Block     bool      `mapstructure:"block" yaml:"block,omitempty"`
-	JSONPatch string    `mapstructure:"json-patch" yaml:"json-patch,omitempty"`
+	// LabelsFromFields adds labels whose values are copied from the object's fields, given as json paths such as
+	// '.metadata.annotations["billing/cc"]'.  They replace any label additions with the same keys.
+	LabelsFromFields map[string]string `mapstructure:"labels-from-fields" yaml:"labels-from-fields,omitempty"`
+	// DeleteLabels and DeleteAnnotations are a flatter way of specifying deletions, they are merged with Deletions.
+	DeleteLabels      []string `mapstructure:"delete-labels" yaml:"delete-labels,omitempty"`
+	DeleteAnnotations []string `mapstructure:"delete-annotations" yaml:"delete-annotations,omitempty"`
+	Block             bool     `mapstructure:"block" yaml:"block,omitempty"`
+	JSONPatch         string   `mapstructure:"json-patch" yaml:"json-patch,omitempty"`
+	// LabelRelatedServices copies the label additions onto the Services which select the pods of an admitted workload.
+	LabelRelatedServices bool `mapstructure:"label-related-services" yaml:"label-related-services,omitempty"`
+	// PrefixKeys prefixes the keys of all label and annotation additions with "<company-domain>/", apart from
+	// those listed in PrefixExempt and those that already have a prefix.
+	PrefixKeys   bool     `mapstructure:"prefix-keys" yaml:"prefix-keys,omitempty"`
+	PrefixExempt []string `mapstructure:"prefix-exempt" yaml:"prefix-exempt,omitempty"`
+	// InjectContainers appends containers and volumes to pods, like a sidecar injector.
+	InjectContainers InjectContainers `mapstructure:"inject-containers" yaml:"inject-containers,omitempty"`
+	// RecordCreator annotates objects, when they are created, with the user and groups that created them and when.
+	RecordCreator bool `mapstructure:"record-creator" yaml:"record-creator,omitempty"`
+	// RecordProvenance annotates objects, when they are created or updated, with a JSON value holding the listed
+	// fields of the admission request: username, uid, timestamp and operation.
+	RecordProvenance []string `mapstructure:"record-provenance" yaml:"record-provenance,omitempty"`
+	// Ingress annotates Ingresses, Gateways and routes for external-dns and cert-manager.
+	Ingress IngressAnnotations `mapstructure:"ingress" yaml:"ingress,omitempty"`
+	// BackupPrevious keeps the previous value of each label or annotation that is overwritten or deleted in an
+	// annotation, so that the change can be reversed.
+	BackupPrevious bool `mapstructure:"backup-previous" yaml:"backup-previous,omitempty"`
+	// Expire stamps objects with an expires-at label, which the expiry controller acts on once it has passed.
+	Expire Expiry `mapstructure:"expire" yaml:"expire,omitempty"`
+	// InheritFromNamespace copies labels and annotations from the object's namespace.
+	InheritFromNamespace InheritFromNamespace `mapstructure:"inherit-from-namespace" yaml:"inherit-from-namespace,omitempty"`
+	// Finalizers and OwnerReferences add and remove entries of the object's finalizers and owner references.
+	Finalizers      Finalizers      `mapstructure:"finalizers" yaml:"finalizers,omitempty"`
+	OwnerReferences OwnerReferences `mapstructure:"owner-references" yaml:"owner-references,omitempty"`
+	// Stamp annotates objects with when the rule was applied and the hash of its payload, and skips objects that
+	// already have the same hash so that they aren't patched again.
+	Stamp bool `mapstructure:"stamp" yaml:"stamp,omitempty"`
+	// Enrich looks up more about the object for the addition templates, 'quota' gives Namespaces the hard limits
+	// and usage of their resource quotas.
+	Enrich []string `mapstructure:"enrich" yaml:"enrich,omitempty"`
+
+	companyDomain string
+	// emittedLabels and emittedAnnotations are added by a rule's rego policy, they are never rendered as templates.
+	emittedLabels      map[string]string
+	emittedAnnotations map[string]string
+	// inheritedLabels and inheritedAnnotations are those of the object's namespace, they are never rendered either.
+	inheritedLabels      map[string]string
+	inheritedAnnotations map[string]string
+	// stampAnnotations record that the payload was applied, they aren't rendered either.
+	stampAnnotations map[string]string
 }
 
 // Additions contains the additional fields that we want to insert into the object
@@ -48,7 +97,97 @@ type Deletions struct {
 	Labels      []string `mapstructure:"labels" yaml:"labels,omitempty"`
 }
 
-func (p Payload) paintObject(object metaObject, fm map[string]string, logger zerolog.Logger) (patch []byte, err error) {
+// WithKeyPrefix returns a copy of the payload with the prefix added to its addition keys when PrefixKeys is set.
+// It is applied when the configuration is loaded so that everything that uses the payload writes the prefixed keys.
+func (p Payload) WithKeyPrefix(prefix string) Payload {
+	if !p.PrefixKeys || prefix == "" {
+		return p
+	}
+	exempt := make(map[string]bool)
+	for _, k := range p.PrefixExempt {
+		exempt[k] = true
+	}
+	p.Additions.Labels = prefixKeys(p.Additions.Labels, prefix, exempt)
+	p.Additions.Annotations = prefixKeys(p.Additions.Annotations, prefix, exempt)
+	p.LabelsFromFields = prefixKeys(p.LabelsFromFields, prefix, exempt)
+	return p
+}
+
+func prefixKeys(m map[string]string, prefix string, exempt map[string]bool) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		if exempt[k] || strings.Contains(k, "/") {
+			result[k] = v
+			continue
+		}
+		result[prefix+"/"+k] = v
+	}
+	return result
+}
+
+// envVarRegex matches the ${ENV_VAR} references which WithEnv substitutes.
+var envVarRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// WithEnv returns a copy of the payload with any ${ENV_VAR} references in its label and annotation addition values
+// replaced by the value returned by lookup, which is normally os.LookupEnv.  It is an error to reference an unset variable.
+func (p Payload) WithEnv(lookup func(string) (string, bool)) (Payload, error) {
+	var err error
+	if p.Additions.Labels, err = expandEnvValues(p.Additions.Labels, lookup); err != nil {
+		return p, err
+	}
+	if p.Additions.Annotations, err = expandEnvValues(p.Additions.Annotations, lookup); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func expandEnvValues(m map[string]string, lookup func(string) (string, bool)) (map[string]string, error) {
+	if m == nil {
+		return nil, nil
+	}
+	var missing []string
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = envVarRegex.ReplaceAllStringFunc(v, func(ref string) string {
+			name := envVarRegex.FindStringSubmatch(ref)[1]
+			value, ok := lookup(name)
+			if !ok {
+				missing = append(missing, name)
+			}
+			return value
+		})
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variable(s) not set: %s", strings.Join(missing, ", "))
+	}
+	return result, nil
+}
+
+// withEmitted returns a copy of the payload which also adds the labels and annotations emitted by a rego policy.
+func (p Payload) withEmitted(labels, annotations map[string]string) Payload {
+	p.emittedLabels = labels
+	p.emittedAnnotations = annotations
+	return p
+}
+
+// withInherited returns a copy of the payload which also adds the labels and annotations inherited from a namespace.
+func (p Payload) withInherited(labels, annotations map[string]string) Payload {
+	p.inheritedLabels = labels
+	p.inheritedAnnotations = annotations
+	return p
+}
+
+// isEmpty returns true when the payload does not ask for any change at all.
+func (p Payload) isEmpty() bool {
+	return !p.Block && p.JSONPatch == "" && !p.containsAdditions() && !p.containsDeletions() && !p.LabelRelatedServices && p.InjectContainers.isEmpty() && !p.RecordCreator && len(p.RecordProvenance) == 0 && p.Ingress.isEmpty() && p.Expire.isEmpty() && p.InheritFromNamespace.isEmpty() && p.Finalizers.isEmpty() && p.OwnerReferences.isEmpty()
+}
+
+// paintObject creates the patch for an object, creator is the user creating the object, and is nil for updates and
+// existing objects.  request is the provenance of the admission request, and is nil for existing objects.
+func (p Payload) paintObject(object metaObject, fm map[string]string, raw []byte, creator *authenticationv1.UserInfo, request *provenance, logger zerolog.Logger) (patch []byte, err error) {
 	mylog := logger.With().Str("func", "paintObject").Logger()
 
 	// a block takes precedence over JSONPatch, Additions, Deletions...
@@ -57,6 +196,11 @@ func (p Payload) paintObject(object metaObject, fm map[string]string, logger zer
 		return []byte("BLOCK"), nil
 	}
 
+	// the keys that a rule adds are checked again here as those of rego policies and namespaces are only known now
+	if err := keyPrefixes.Check(p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPatchBuild, err)
+	}
+
 	// if the user provided a patch then just use that...
 	if p.JSONPatch != "" {
 		mylog.Debug().Str("patch", p.JSONPatch).Msg("payload contains user provided patch")
@@ -65,11 +209,33 @@ func (p Payload) paintObject(object metaObject, fm map[string]string, logger zer
 
 	// create a patch for additions + deletions
 	var patchString string
-	if p.containsAdditions() || p.containsDeletions() {
+	if !p.InjectContainers.isEmpty() {
+		mylog.Debug().Msg("payload contains containers to inject")
+		patchString, err = p.InjectContainers.patch(raw, p.InjectedAnnotation())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPatchBuild, err)
+		}
+	}
+	recorded, err := p.Ingress.annotations(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPatchBuild, err)
+	}
+	if p.RecordCreator && creator != nil {
+		recorded = mergeMaps(recorded, p.creatorAnnotations(creator, time.Now()))
+	}
+	if len(p.RecordProvenance) > 0 && request != nil {
+		annotations, err := p.provenanceAnnotations(request)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPatchBuild, err)
+		}
+		recorded = mergeMaps(recorded, annotations)
+	}
+	recorded = mergeMaps(p.inheritedAnnotations, recorded, p.emittedAnnotations, p.stampAnnotations)
+	if p.containsAdditions() || p.containsDeletions() || len(recorded) > 0 || !p.Expire.isEmpty() || len(p.emittedLabels) > 0 || len(p.inheritedLabels) > 0 || p.containsMetadataLists() {
 		mylog.Debug().Str("patch", p.JSONPatch).Msg("payload contains additions or deletions")
-		patchString, err = p.processMetadataAdditionsDeletions(object, fm)
+		patchString, err = p.processMetadataAdditionsDeletions(object, fm, recorded)
 		if err != nil {
-			return nil, fmt.Errorf("could not create json patch: %v", err)
+			return nil, fmt.Errorf("%w: %v", ErrPatchBuild, err)
 		}
 	}
 
@@ -83,27 +249,63 @@ func (p Payload) paintObject(object metaObject, fm map[string]string, logger zer
 }
 
 func (p Payload) containsAdditions() bool {
-	if len(p.Additions.Labels) == 0 && len(p.Additions.Annotations) == 0 {
+	if len(p.Additions.Labels) == 0 && len(p.Additions.Annotations) == 0 && len(p.LabelsFromFields) == 0 {
 		return false
 	}
 	return true
 }
 
 func (p Payload) containsDeletions() bool {
-	if len(p.Deletions.Labels) == 0 && len(p.Deletions.Annotations) == 0 {
+	dels := p.allDeletions()
+	if len(dels.Labels) == 0 && len(dels.Annotations) == 0 {
 		return false
 	}
 	return true
 }
 
+// containsMetadataLists is true when the payload changes the object's finalizers or owner references.
+func (p Payload) containsMetadataLists() bool {
+	return !p.Finalizers.isEmpty() || !p.OwnerReferences.isEmpty()
+}
+
+// allDeletions merges the delete-labels and delete-annotations lists into the deletions.
+func (p Payload) allDeletions() Deletions {
+	var dels Deletions
+	dels.Labels = append(dels.Labels, p.Deletions.Labels...)
+	dels.Labels = append(dels.Labels, p.DeleteLabels...)
+	dels.Annotations = append(dels.Annotations, p.Deletions.Annotations...)
+	dels.Annotations = append(dels.Annotations, p.DeleteAnnotations...)
+	return dels
+}
+
 // processMetadataAdditionsDeletions will generate a JSON patch for replacing an objects labels and/or annotations
 // It is designed to replace the whole path in order to work around a bug in kubernetes that does not correctly
 // unescape ~1 (/) in paths preventing annotation labels with slashes in them.
-func (p Payload) processMetadataAdditionsDeletions(obj metaObject, fm map[string]string) (string, error) {
+// The recorded annotations are added as they are rather than being rendered as templates.
+func (p Payload) processMetadataAdditionsDeletions(obj metaObject, fm, recorded map[string]string) (string, error) {
 	mylog := log.ComponentLogger(componentName, "processMetadataAdditionsDeletions")
 	var patches []string
+	dels := p.allDeletions()
 
-	op, err := createPatchOperand(obj.Meta.Labels, p.Additions.Labels, fm, p.Deletions.Labels, "/metadata/labels")
+	copied := mergeMaps(p.inheritedLabels, p.labelsFromFields(fm, mylog), p.emittedLabels, p.expiryLabels(obj, time.Now()))
+	if p.BackupPrevious {
+		backups, err := p.previousValues(obj, fm, copied, recorded)
+		if err != nil {
+			return "", err
+		}
+		recorded = mergeMaps(recorded, backups)
+	}
+
+	op, err := createPatchOperand(obj.Meta.Labels, p.Additions.Labels, copied, fm, dels.Labels, "/metadata/labels")
+	if err != nil {
+		return "", err
+	}
+	if op != "" {
+		mylog.Debug().Str("operand", op).Msg("created patch operand")
+		patches = append(patches, op)
+	}
+
+	op, err = createPatchOperand(obj.Meta.Annotations, p.Additions.Annotations, recorded, fm, dels.Annotations, "/metadata/annotations")
 	if err != nil {
 		return "", err
 	}
@@ -112,7 +314,16 @@ func (p Payload) processMetadataAdditionsDeletions(obj metaObject, fm map[string
 		patches = append(patches, op)
 	}
 
-	op, err = createPatchOperand(obj.Meta.Annotations, p.Additions.Annotations, fm, p.Deletions.Annotations, "/metadata/annotations")
+	op, err = p.Finalizers.patchOperand(obj.Meta.Finalizers)
+	if err != nil {
+		return "", err
+	}
+	if op != "" {
+		mylog.Debug().Str("operand", op).Msg("created patch operand")
+		patches = append(patches, op)
+	}
+
+	op, err = p.OwnerReferences.patchOperand(obj.Meta.OwnerReferences, fm)
 	if err != nil {
 		return "", err
 	}
@@ -124,6 +335,10 @@ func (p Payload) processMetadataAdditionsDeletions(obj metaObject, fm map[string
 	if len(patches) == 0 {
 		return "", nil
 	}
+	if obj.missing {
+		mylog.Debug().Msg("object has no metadata, adding it first")
+		patches = append([]string{`{ "op": "add", "path": "/metadata", "value": {} }`}, patches...)
+	}
 	return `[ ` + strings.Join(patches, ", ") + ` ]`, nil
 }
 
@@ -140,22 +355,68 @@ func (p Payload) validate() error {
 		hasJSONPatch = true
 		payloadTypes++
 	}
-	if len(p.Additions.Labels) != 0 || len(p.Additions.Annotations) != 0 || len(p.Deletions.Labels) != 0 || len(p.Deletions.Annotations) != 0 {
+	if p.containsAdditions() || p.containsDeletions() || p.RecordCreator || len(p.RecordProvenance) > 0 || !p.Ingress.isEmpty() || !p.Expire.isEmpty() || !p.InheritFromNamespace.isEmpty() || p.containsMetadataLists() {
 		hasAdditionsDeletions = true
 		payloadTypes++
 	}
+	if !p.InjectContainers.isEmpty() {
+		payloadTypes++
+	}
 	if payloadTypes == 0 {
-		return fmt.Errorf("a rule payload must specify either additions/deletions, a json-patch, or a block")
+		return fmt.Errorf("a rule payload must specify either additions/deletions, a json-patch, inject-containers or a block")
 	}
 	if payloadTypes > 1 {
-		return fmt.Errorf("a rule payload can only specify additions/deletions, or a json-patch or a block, but not a combination of them")
+		return fmt.Errorf("a rule payload can only specify additions/deletions, or a json-patch, or inject-containers or a block, but not a combination of them")
+	}
+	if p.BackupPrevious && !hasAdditionsDeletions {
+		return fmt.Errorf("a rule payload can only backup-previous values when it has additions or deletions")
+	}
+	if p.Stamp && !hasAdditionsDeletions {
+		return fmt.Errorf("a rule payload can only stamp objects when it has additions or deletions")
+	}
+	if p.LabelRelatedServices && len(p.Additions.Labels) == 0 {
+		return fmt.Errorf("a rule payload can only label-related-services when it has label additions")
+	}
+	if err := validateEnrich(p.Enrich); err != nil {
+		return err
+	}
+	if len(p.Enrich) > 0 && len(p.Additions.Labels) == 0 && len(p.Additions.Annotations) == 0 {
+		return fmt.Errorf("a rule payload can only enrich objects when it has label or annotation additions")
+	}
+	if len(p.Enrich) > 0 && p.Stamp {
+		// the stamp's hash is of the payload rather than of what it rendered, so new values would never be applied
+		return fmt.Errorf("a rule payload can not both enrich and stamp objects")
 	}
 
 	if hasJSONPatch {
 		return validateJSONPatch(p.JSONPatch)
 	}
 	if hasAdditionsDeletions {
-		return validateAdditionsDeletions(p.Additions, p.Deletions)
+		if err := p.Ingress.validate(); err != nil {
+			return err
+		}
+		if err := validateLabelsFromFields(p.LabelsFromFields); err != nil {
+			return err
+		}
+		if err := validateRecordProvenance(p.RecordProvenance); err != nil {
+			return err
+		}
+		if err := p.Expire.validate(); err != nil {
+			return err
+		}
+		if err := p.InheritFromNamespace.validate(); err != nil {
+			return err
+		}
+		if err := p.Finalizers.validate(); err != nil {
+			return err
+		}
+		if err := p.OwnerReferences.validate(); err != nil {
+			return err
+		}
+		return validateAdditionsDeletions(p.Additions, p.allDeletions())
+	}
+	if !p.InjectContainers.isEmpty() {
+		return p.InjectContainers.validate()
 	}
 
 	return nil
@@ -211,7 +211,7 @@ json-patch: "[ { \"op\": \"delete\", \"path\": \"/metadata/labels\" } ]"
 	err := yaml.Unmarshal([]byte(source), &payload)
 	require.NoError(t, err, "the test payload should unmarshal")
 	err = payload.validate()
-	assert.EqualError(t, err, "a rule payload can only specify additions/deletions, or a json-patch or a block, but not a combination of them")
+	assert.EqualError(t, err, "a rule payload can only specify additions/deletions, or a json-patch, or inject-containers or a block, but not a combination of them")
 }
 
 func TestBlockPlusAdditionsDeletionsNotAllowed(t *testing.T) {
@@ -225,7 +225,7 @@ additions:
 	err := yaml.Unmarshal([]byte(source), &payload)
 	require.NoError(t, err, "the test payload should unmarshal")
 	err = payload.validate()
-	assert.EqualError(t, err, "a rule payload can only specify additions/deletions, or a json-patch or a block, but not a combination of them")
+	assert.EqualError(t, err, "a rule payload can only specify additions/deletions, or a json-patch, or inject-containers or a block, but not a combination of them")
 }
 
 func TestJSONPatchPlusAdditionsDeletionsNotAllowed(t *testing.T) {
@@ -241,7 +241,7 @@ additions:
 	spew.Dump(payload)
 	require.NoError(t, err, "the test payload should unmarshal")
 	err = payload.validate()
-	assert.EqualError(t, err, "a rule payload can only specify additions/deletions, or a json-patch or a block, but not a combination of them")
+	assert.EqualError(t, err, "a rule payload can only specify additions/deletions, or a json-patch, or inject-containers or a block, but not a combination of them")
 }
 
 func TestDeleteALabel(t *testing.T) {
@@ -296,7 +296,7 @@ func TestDeleteAllLabels(t *testing.T) {
 	assert.Equal(t, true, resp.Allowed, "the request should be successful")
 	assert.NotNil(t, resp.Patch)
 	// we have to test the patch objects because they have multiple values and can be ordered either way round preventing a simple string match.
-	desired, _ := jsonpatch.FromString(`[ { "op": "delete", "path": "/metadata/labels" } ]`)
+	desired, _ := jsonpatch.FromString(`[ { "op": "remove", "path": "/metadata/labels" } ]`)
 	actual, err := jsonpatch.FromString(string(resp.Patch))
 	assert.NoError(t, err)
 	assert.ElementsMatch(t, desired.Operations, actual.Operations, "the whole /metadata/labels path should be removed")
@@ -381,7 +381,7 @@ func TestDeleteAllAnnotations(t *testing.T) {
 	assert.Equal(t, true, resp.Allowed, "the request should be successful")
 	assert.NotNil(t, resp.Patch)
 	// we have to test the patch objects because they have multiple values and can be ordered either way round preventing a simple string match.
-	desired, _ := jsonpatch.FromString(`[ { "op": "delete", "path": "/metadata/annotations" } ]`)
+	desired, _ := jsonpatch.FromString(`[ { "op": "remove", "path": "/metadata/annotations" } ]`)
 	actual, err := jsonpatch.FromString(string(resp.Patch))
 	assert.NoError(t, err)
 	assert.ElementsMatch(t, desired.Operations, actual.Operations, "the whole /metadata/annotations path should be removed")
@@ -468,3 +468,111 @@ func TestRuleBlocksObject(t *testing.T) {
 	assert.Equal(t, metav1.StatusReasonForbidden, resp.Result.Reason, "the graffiti rule should forbid the create/update of the object")
 	assert.Equal(t, "blocked by kube-graffiti rule: I-dont-like-david", resp.Result.Message, "we should be able to see why the request has been blocked and by which rule")
 }
+
+func TestValidDeleteLabelsAndAnnotations(t *testing.T) {
+	var source = `---
+delete-labels:
+- "delete-me"
+delete-annotations:
+- "acme.com/deprecated"
+`
+	var payload Payload
+	err := yaml.Unmarshal([]byte(source), &payload)
+	require.NoError(t, err, "the test payload should unmarshal")
+	err = payload.validate()
+	assert.NoError(t, err)
+}
+
+func TestInvalidDeleteAnnotationsKey(t *testing.T) {
+	var source = `---
+delete-annotations:
+- "dave.com/multiple/slashes"
+`
+	var payload Payload
+	err := yaml.Unmarshal([]byte(source), &payload)
+	require.NoError(t, err, "the test payload should unmarshal")
+	err = payload.validate()
+	assert.EqualError(t, err, "invalid deletions: invalid key: a qualified name must consist of alphanumeric characters, '-', '_' or '.', and must start and end with an alphanumeric character (e.g. 'MyName',  or 'my.name',  or '123-abc', regex used for validation is '([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]') with an optional DNS subdomain prefix and '/' (e.g. 'example.com/MyName')")
+}
+
+func TestDeleteLabelsAndDeleteAnnotations(t *testing.T) {
+	// create a Rule
+	rule := Rule{
+		Matchers: Matchers{
+			LabelSelectors: []string{"author = david"},
+		},
+		Payload: Payload{
+			DeleteLabels:      []string{"author"},
+			DeleteAnnotations: []string{"level", "prometheus.io/path"},
+		},
+	}
+
+	// create a review request
+	var review = admission.AdmissionReview{}
+	err := json.Unmarshal([]byte(testReview), &review)
+	assert.NoError(t, err, "couldn't marshall a valid admission review object from test json")
+
+	// call Mutate
+	resp := rule.MutateAdmission(review.Request)
+	assert.Equal(t, true, resp.Allowed, "the request should be successful")
+	assert.NotNil(t, resp.Patch)
+	desired, _ := jsonpatch.FromString(`[ { "op": "replace", "path": "/metadata/labels", "value": { "group": "runtime" }}, { "op": "remove", "path": "/metadata/annotations" } ]`)
+	actual, err := jsonpatch.FromString(string(resp.Patch))
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, desired.Operations, actual.Operations, "the author label and all annotations should have been removed")
+}
+
+func TestDeletingKeysThatDoNotExistProducesNoPatch(t *testing.T) {
+	// create a Rule
+	rule := Rule{
+		Matchers: Matchers{
+			LabelSelectors: []string{"author = david"},
+		},
+		Payload: Payload{
+			DeleteLabels:      []string{"not-there"},
+			DeleteAnnotations: []string{"acme.com/not-there-either"},
+		},
+	}
+
+	// create a review request
+	var review = admission.AdmissionReview{}
+	err := json.Unmarshal([]byte(testReview), &review)
+	assert.NoError(t, err, "couldn't marshall a valid admission review object from test json")
+
+	// call Mutate
+	resp := rule.MutateAdmission(review.Request)
+	assert.Equal(t, true, resp.Allowed, "the request should be successful")
+	assert.Nil(t, resp.Patch, "deleting keys which are not present should not produce a patch")
+}
+
+func TestLabelRelatedServicesNeedsLabelAdditions(t *testing.T) {
+	p := Payload{
+		Additions:            Additions{Annotations: map[string]string{"a": "b"}},
+		LabelRelatedServices: true,
+	}
+	assert.EqualError(t, p.validate(), "a rule payload can only label-related-services when it has label additions")
+
+	p.Additions.Labels = map[string]string{"owner": "team-a"}
+	assert.NoError(t, p.validate())
+}
+
+func TestWithEnvSubstitutesAdditionValues(t *testing.T) {
+	env := map[string]string{"CLUSTER_ENV": "production", "REGION": "eu-west-1"}
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+	p := Payload{Additions: Additions{
+		Labels:      map[string]string{"environment": "${CLUSTER_ENV}", "name": `{{ index . "metadata.name" }}`},
+		Annotations: map[string]string{"location": "${CLUSTER_ENV} in ${REGION}"},
+	}}
+
+	result, err := p.WithEnv(lookup)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"environment": "production", "name": `{{ index . "metadata.name" }}`}, result.Additions.Labels)
+	assert.Equal(t, map[string]string{"location": "production in eu-west-1"}, result.Additions.Annotations)
+	assert.Equal(t, "${CLUSTER_ENV}", p.Additions.Labels["environment"], "the original payload should not be changed")
+
+	_, err = Payload{Additions: Additions{Labels: map[string]string{"owner": "${OWNER}"}}}.WithEnv(lookup)
+	assert.EqualError(t, err, "environment variable(s) not set: OWNER")
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// validatePresence checks that the has-field and missing-field paths can be parsed, that the has-label and
+// missing-label keys are valid, and that nothing is both required and forbidden.
+func (m Matchers) validatePresence() error {
+	for _, path := range append(append([]string{}, m.HasField...), m.MissingField...) {
+		if _, err := fieldPath(path); err != nil {
+			return err
+		}
+	}
+	for _, key := range append(append([]string{}, m.HasLabel...), m.MissingLabel...) {
+		if errs := utilvalidation.IsQualifiedName(key); len(errs) != 0 {
+			return fmt.Errorf("invalid label key '%s': %s", key, strings.Join(errs, "; "))
+		}
+	}
+	for _, path := range m.HasField {
+		for _, missing := range m.MissingField {
+			if fieldPathsEqual(path, missing) {
+				return fmt.Errorf("field '%s' can't be in both has-field and missing-field", path)
+			}
+		}
+	}
+	for _, key := range m.HasLabel {
+		if isIn(key, m.MissingLabel) {
+			return fmt.Errorf("label '%s' can't be in both has-label and missing-label", key)
+		}
+	}
+	return nil
+}
+
+// emptyPresence is true when the matchers don't check for any fields or labels.
+func (m Matchers) emptyPresence() bool {
+	return len(m.HasField) == 0 && len(m.MissingField) == 0 && len(m.HasLabel) == 0 && len(m.MissingLabel) == 0
+}
+
+// matchesPresence is true when the object has all of the has-field fields and has-label labels, and none of the
+// missing-field fields or missing-label labels.
+func (m Matchers) matchesPresence(obj metaObject, object []byte) (bool, error) {
+	for _, key := range m.HasLabel {
+		if _, ok := obj.Meta.Labels[key]; !ok {
+			return false, nil
+		}
+	}
+	for _, key := range m.MissingLabel {
+		if _, ok := obj.Meta.Labels[key]; ok {
+			return false, nil
+		}
+	}
+	if len(m.HasField) == 0 && len(m.MissingField) == 0 {
+		return true, nil
+	}
+
+	var fields interface{}
+	if err := json.Unmarshal(object, &fields); err != nil {
+		return false, fmt.Errorf("failed to unmarshal object for has-field and missing-field: %v", err)
+	}
+	for _, path := range m.HasField {
+		has, err := hasField(fields, path)
+		if err != nil || !has {
+			return false, err
+		}
+	}
+	for _, path := range m.MissingField {
+		has, err := hasField(fields, path)
+		if err != nil || has {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// presenceLabelSelector is the label selector that requires the has-label labels and forbids the missing-label
+// labels, which can be pushed down into a list call.
+func (m Matchers) presenceLabelSelector() string {
+	requirements := append([]string{}, m.HasLabel...)
+	for _, key := range m.MissingLabel {
+		requirements = append(requirements, "!"+key)
+	}
+	return strings.Join(requirements, ",")
+}
+
+// hasField is true when the object has a field at the json path, whatever its value, apart from null which is the
+// same as not having the field.
+func hasField(object interface{}, path string) (bool, error) {
+	parts, err := fieldPath(path)
+	if err != nil {
+		return false, err
+	}
+	current := object
+	for _, part := range parts {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			current = v[part]
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return false, nil
+			}
+			current = v[i]
+		default:
+			return false, nil
+		}
+		if current == nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// fieldPathsEqual is true when two json paths refer to the same field, however they are quoted.
+func fieldPathsEqual(a, b string) bool {
+	ka, err := fieldPath(a)
+	if err != nil {
+		return false
+	}
+	kb, err := fieldPath(b)
+	if err != nil || len(ka) != len(kb) {
+		return false
+	}
+	for i := range ka {
+		if ka[i] != kb[i] {
+			return false
+		}
+	}
+	return true
+}
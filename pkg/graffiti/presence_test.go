@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const presenceIngress = `{"apiVersion":"networking.k8s.io/v1","kind":"Ingress","metadata":{"name":"web","namespace":"team-a",
+	"labels":{"app":"web"},"annotations":{"cert-manager.io/issuer":"letsencrypt"}},
+	"spec":{"tls":[{"hosts":["web.acme.com"],"secretName":"web-tls"}],"defaultBackend":null}}`
+
+func TestHasAndMissingFieldsMatchWhateverTheValue(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		matchers Matchers
+		match    bool
+	}{
+		{"has a field", Matchers{HasField: []string{".spec.tls"}}, true},
+		{"has a list item's field", Matchers{HasField: []string{".spec.tls[0].secretName"}}, true},
+		{"has a dotted annotation", Matchers{HasField: []string{`.metadata.annotations["cert-manager.io/issuer"]`}}, true},
+		{"doesn't have a field", Matchers{HasField: []string{".spec.rules"}}, false},
+		{"a null field is missing", Matchers{MissingField: []string{".spec.defaultBackend"}}, true},
+		{"a list item past the end is missing", Matchers{MissingField: []string{".spec.tls[1]"}}, true},
+		{"a prefix of an annotation isn't the annotation", Matchers{MissingField: []string{`.metadata.annotations["cert-manager"]`}}, true},
+		{"has a field that must be missing", Matchers{MissingField: []string{`.metadata.annotations["cert-manager.io/issuer"]`}}, false},
+		{"has a label", Matchers{HasLabel: []string{"app"}}, true},
+		{"doesn't have a label", Matchers{HasLabel: []string{"team"}}, false},
+		{"has a label that must be missing", Matchers{MissingLabel: []string{"app"}}, false},
+		{"all of them must hold", Matchers{HasField: []string{".spec.tls"}, MissingLabel: []string{"team"}, HasLabel: []string{"owner"}}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule := Rule{Name: "presence", Matchers: tc.matchers}
+			require.NoError(t, rule.Matchers.validate(log.Logger))
+			match, err := rule.Matches([]byte(presenceIngress), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tc.match, match)
+		})
+	}
+}
+
+func TestMissingFieldAnnotatesIngressesWithoutAnIssuer(t *testing.T) {
+	rule := NewRule("default-issuer").
+		MatchMissingFields(`.metadata.annotations["cert-manager.io/issuer"]`).
+		AddAnnotations(map[string]string{"cert-manager.io/issuer": "letsencrypt"})
+
+	patch, err := rule.Mutate([]byte(presenceIngress))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "an ingress that has an issuer is left alone")
+
+	patch, err = rule.Mutate([]byte(`{"kind":"Ingress","metadata":{"name":"web","annotations":{"other":"x"}}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"replace","path":"/metadata/annotations","value":{"other":"x","cert-manager.io/issuer":"letsencrypt"}}]`, string(patch))
+}
+
+func TestPresenceMatchersValidation(t *testing.T) {
+	assert.EqualError(t, Matchers{HasField: []string{"spec"}}.validatePresence(), "invalid field path 'spec': expected . or [ at 'spec'")
+	assert.Error(t, Matchers{MissingLabel: []string{"not a key"}}.validatePresence())
+	assert.EqualError(t, Matchers{HasField: []string{".metadata.labels.app"}, MissingField: []string{`.metadata.labels["app"]`}}.validatePresence(),
+		"field '.metadata.labels.app' can't be in both has-field and missing-field")
+	assert.EqualError(t, Matchers{HasLabel: []string{"app"}, MissingLabel: []string{"app"}}.validatePresence(),
+		"label 'app' can't be in both has-label and missing-label")
+}
+
+func TestPresenceLabelsArePushedDownIntoListSelectors(t *testing.T) {
+	labelSelector, _ := Matchers{LabelSelectors: []string{"tier=web"}, HasLabel: []string{"app"}, MissingLabel: []string{"team"}}.ListSelectors()
+	assert.Equal(t, "tier=web,app,!team", labelSelector)
+	labelSelector, _ = Matchers{HasLabel: []string{"app"}, Negate: true}.ListSelectors()
+	assert.Equal(t, "", labelSelector, "negated matchers aren't pushed down")
+}
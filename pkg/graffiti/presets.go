@@ -0,0 +1,76 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the preset matchers for security-relevant pod conditions.
+const (
+	PresetPrivileged  = "privileged"
+	PresetHostNetwork = "host-network"
+	PresetHostPath    = "host-path"
+	PresetRunAsRoot   = "run-as-root"
+)
+
+// In the preset expressions CONTAINERS stands in for all of the containers and init containers of SPEC, which in turn
+// stands in for the pod spec of a pod, or the pod template of a workload or cronjob.
+var (
+	containersReplacer = strings.NewReplacer(
+		"CONTAINERS", `((has(SPEC.containers) ? SPEC.containers : []) + (has(SPEC.initContainers) ? SPEC.initContainers : []))`,
+	)
+	specReplacer = strings.NewReplacer(
+		"SPEC", `(has(object.spec.template) ? object.spec.template.spec : (has(object.spec.jobTemplate) ? object.spec.jobTemplate.spec.template.spec : object.spec))`,
+	)
+)
+
+// presets are cel expressions, matched against pods and the pod templates of workloads, for common risky conditions.
+var presets = map[string]string{
+	PresetPrivileged:  `CONTAINERS.exists(c, has(c.securityContext) && has(c.securityContext.privileged) && c.securityContext.privileged == true)`,
+	PresetHostNetwork: `has(SPEC.hostNetwork) && SPEC.hostNetwork == true`,
+	PresetHostPath:    `has(SPEC.volumes) && SPEC.volumes.exists(v, has(v.hostPath))`,
+	// a container runs as root when its (or else its pod's) runAsUser is 0, or when neither sets runAsUser or runAsNonRoot.
+	PresetRunAsRoot: `CONTAINERS.exists(c,
+		has(c.securityContext) && has(c.securityContext.runAsUser) ? c.securityContext.runAsUser == 0.0 :
+		has(SPEC.securityContext) && has(SPEC.securityContext.runAsUser) ? SPEC.securityContext.runAsUser == 0.0 :
+		has(c.securityContext) && has(c.securityContext.runAsNonRoot) ? c.securityContext.runAsNonRoot != true :
+		!(has(SPEC.securityContext) && has(SPEC.securityContext.runAsNonRoot) && SPEC.securityContext.runAsNonRoot == true))`,
+}
+
+// presetNames returns the names of all of the presets, sorted.
+func presetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presetsCEL converts a list of presets into a single cel expression which is true when any of them match.
+// Objects without a spec, or whose spec is not a pod spec, never match.
+func presetsCEL(names []string) (string, error) {
+	var expressions []string
+	for _, name := range names {
+		expression, ok := presets[name]
+		if !ok {
+			return "", fmt.Errorf("unknown preset '%s', must be one of %s", name, strings.Join(presetNames(), ", "))
+		}
+		expressions = append(expressions, "("+specReplacer.Replace(containersReplacer.Replace(expression))+")")
+	}
+	return "has(object.spec) && (" + strings.Join(expressions, " || ") + ")", nil
+}
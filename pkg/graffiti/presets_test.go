@@ -0,0 +1,67 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func matchesPresets(t *testing.T, object string, presets ...string) bool {
+	rule := Rule{Name: "risky", Type: RuleTypeValidating, Matchers: Matchers{Presets: presets}}
+	require.NoError(t, rule.Validate(log.Logger))
+	match, err := rule.Matches([]byte(object), nil)
+	require.NoError(t, err)
+	return match
+}
+
+func TestPresetsMatchPods(t *testing.T) {
+	safe := `{"kind": "Pod", "metadata": {"name": "safe"}, "spec": {"securityContext": {"runAsNonRoot": true}, "containers": [{"name": "web"}]}}`
+	for _, preset := range presetNames() {
+		assert.False(t, matchesPresets(t, safe, preset), "preset %s should not match a safe pod", preset)
+	}
+
+	privileged := `{"kind": "Pod", "metadata": {"name": "p"}, "spec": {"securityContext": {"runAsUser": 1000}, "initContainers": [{"name": "init", "securityContext": {"privileged": true}}], "containers": [{"name": "web"}]}}`
+	assert.True(t, matchesPresets(t, privileged, PresetPrivileged))
+	assert.False(t, matchesPresets(t, privileged, PresetRunAsRoot))
+
+	hostNetwork := `{"kind": "Pod", "metadata": {"name": "p"}, "spec": {"hostNetwork": true, "containers": [{"name": "web"}]}}`
+	assert.True(t, matchesPresets(t, hostNetwork, PresetHostNetwork))
+	assert.True(t, matchesPresets(t, hostNetwork, PresetPrivileged, PresetHostNetwork), "presets should be OR'ed")
+
+	hostPath := `{"kind": "Pod", "metadata": {"name": "p"}, "spec": {"volumes": [{"name": "docker", "hostPath": {"path": "/var/run/docker.sock"}}], "containers": [{"name": "web"}]}}`
+	assert.True(t, matchesPresets(t, hostPath, PresetHostPath))
+}
+
+func TestPresetRunAsRoot(t *testing.T) {
+	assert.True(t, matchesPresets(t, `{"kind": "Pod", "metadata": {"name": "p"}, "spec": {"containers": [{"name": "web"}]}}`, PresetRunAsRoot), "no security context runs as root")
+	assert.True(t, matchesPresets(t, `{"kind": "Pod", "metadata": {"name": "p"}, "spec": {"securityContext": {"runAsUser": 1000}, "containers": [{"name": "web", "securityContext": {"runAsUser": 0}}]}}`, PresetRunAsRoot), "the container's runAsUser overrides the pod's")
+	assert.False(t, matchesPresets(t, `{"kind": "Pod", "metadata": {"name": "p"}, "spec": {"containers": [{"name": "web", "securityContext": {"runAsNonRoot": true}}]}}`, PresetRunAsRoot))
+}
+
+func TestPresetsMatchWorkloadPodTemplates(t *testing.T) {
+	deployment := `{"kind": "Deployment", "metadata": {"name": "d"}, "spec": {"template": {"spec": {"hostNetwork": true, "containers": [{"name": "web"}]}}}}`
+	assert.True(t, matchesPresets(t, deployment, PresetHostNetwork))
+	cronjob := `{"kind": "CronJob", "metadata": {"name": "c"}, "spec": {"jobTemplate": {"spec": {"template": {"spec": {"containers": [{"name": "job", "securityContext": {"privileged": true}}]}}}}}}`
+	assert.True(t, matchesPresets(t, cronjob, PresetPrivileged))
+	assert.False(t, matchesPresets(t, `{"kind": "ConfigMap", "metadata": {"name": "c"}, "data": {}}`, PresetRunAsRoot), "objects without a spec never match")
+}
+
+func TestUnknownPresetFailsValidation(t *testing.T) {
+	rule := Rule{Name: "risky", Type: RuleTypeValidating, Matchers: Matchers{Presets: []string{"sudo"}}}
+	assert.EqualError(t, rule.Validate(log.Logger), "rule 'risky' failed validation: matcher contains an invalid preset: unknown preset 'sudo', must be one of host-network, host-path, privileged, run-as-root")
+}
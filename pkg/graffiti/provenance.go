@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	admission "k8s.io/api/admission/v1beta1"
+)
+
+// ProvenanceAnnotation is the name of the annotation written by a record-provenance payload, it is prefixed with
+// "graffiti.<company-domain>/".
+const ProvenanceAnnotation = "provenance"
+
+// ProvenanceVersion is the version of the schema of the provenance annotation's JSON value.  It changes whenever a
+// field is renamed or changes its meaning, so that anything reading the annotation can tell which schema it has.
+const ProvenanceVersion = "v1"
+
+// The request fields that can be recorded by record-provenance.
+const (
+	ProvenanceUsername  = "username"
+	ProvenanceUID       = "uid"
+	ProvenanceTimestamp = "timestamp"
+	ProvenanceOperation = "operation"
+)
+
+var provenanceFields = map[string]bool{
+	ProvenanceUsername:  true,
+	ProvenanceUID:       true,
+	ProvenanceTimestamp: true,
+	ProvenanceOperation: true,
+}
+
+// provenance is the JSON value of the provenance annotation, the version is always present and the other fields only
+// when the payload records them.
+type provenance struct {
+	Version   string `json:"version"`
+	Username  string `json:"username,omitempty"`
+	UID       string `json:"uid,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+}
+
+// newProvenance captures everything that record-provenance can record about an admission request made at a time.
+func newProvenance(req *admission.AdmissionRequest, at time.Time) *provenance {
+	return &provenance{
+		Version:   ProvenanceVersion,
+		Username:  req.UserInfo.Username,
+		UID:       string(req.UID),
+		Operation: string(req.Operation),
+		Timestamp: at.UTC().Format(time.RFC3339),
+	}
+}
+
+// validateRecordProvenance checks that only known request fields are recorded.
+func validateRecordProvenance(fields []string) error {
+	for _, f := range fields {
+		if !provenanceFields[f] {
+			return fmt.Errorf("invalid record-provenance: unknown field \"%s\", it must be one of %s, %s, %s or %s", f, ProvenanceUsername, ProvenanceUID, ProvenanceTimestamp, ProvenanceOperation)
+		}
+	}
+	return nil
+}
+
+// provenanceAnnotations returns the provenance annotation with the request fields that the payload records.
+func (p Payload) provenanceAnnotations(request *provenance) (map[string]string, error) {
+	recorded := provenance{Version: request.Version}
+	for _, f := range p.RecordProvenance {
+		switch f {
+		case ProvenanceUsername:
+			recorded.Username = request.Username
+		case ProvenanceUID:
+			recorded.UID = request.UID
+		case ProvenanceTimestamp:
+			recorded.Timestamp = request.Timestamp
+		case ProvenanceOperation:
+			recorded.Operation = request.Operation
+		}
+	}
+	value, err := json.Marshal(recorded)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{p.annotationPrefix() + "/" + ProvenanceAnnotation: string(value)}, nil
+}
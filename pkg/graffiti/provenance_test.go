@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestRecordProvenanceAnnotatesObjectsWithTheRequest(t *testing.T) {
+	rule := Rule{
+		Name: "record-provenance",
+		Payload: Payload{
+			RecordProvenance: []string{"username", "uid", "timestamp", "operation"},
+		}.WithCompanyDomain("acme.com"),
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	req := &admission.AdmissionRequest{
+		UID:       "705ab4f5-6393-11e8-b7cc-42010a800002",
+		Operation: admission.Update,
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default"}}`)},
+		OldObject: runtime.RawExtension{Raw: []byte(`{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default"}}`)},
+		UserInfo:  authenticationv1.UserInfo{Username: "ACME\\alice"},
+	}
+	resp := rule.MutateAdmission(req)
+	require.True(t, resp.Allowed)
+
+	var patch []struct {
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Patch, &patch))
+	require.Len(t, patch, 1)
+
+	var recorded map[string]string
+	require.NoError(t, json.Unmarshal([]byte(patch[0].Value["graffiti.acme.com/provenance"]), &recorded))
+	assert.Equal(t, ProvenanceVersion, recorded["version"])
+	assert.Equal(t, "ACME\\alice", recorded["username"])
+	assert.Equal(t, "705ab4f5-6393-11e8-b7cc-42010a800002", recorded["uid"])
+	assert.Equal(t, "UPDATE", recorded["operation"])
+	at, err := time.Parse(time.RFC3339, recorded["timestamp"])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), at, time.Minute)
+}
+
+func TestRecordProvenanceOnlyRecordsTheListedFields(t *testing.T) {
+	p := Payload{RecordProvenance: []string{"operation"}}
+	at := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	annotations, err := p.provenanceAnnotations(newProvenance(&admission.AdmissionRequest{UID: "1", Operation: admission.Create, UserInfo: authenticationv1.UserInfo{Username: "bob"}}, at))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"graffiti/provenance": `{"version":"v1","operation":"CREATE"}`}, annotations)
+}
+
+func TestValidateRecordProvenance(t *testing.T) {
+	assert.NoError(t, Payload{RecordProvenance: []string{"username", "timestamp"}}.validate())
+	assert.Error(t, Payload{RecordProvenance: []string{"groups"}}.validate(), "unknown fields are rejected")
+	assert.Error(t, Payload{RecordProvenance: []string{"username"}, Block: true}.validate())
+
+	patch, err := Rule{Payload: Payload{RecordProvenance: []string{"username"}}}.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web"}}`))
+	assert.NoError(t, err)
+	assert.Nil(t, patch, "existing objects have no request to record")
+}
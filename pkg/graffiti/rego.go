@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/rs/zerolog"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// regoTimeout limits how long a rego policy can take to decide about an object.
+const regoTimeout = time.Second
+
+// Rego is a rego policy, evaluated by an embedded OPA engine, which decides whether a rule matches an object and can
+// emit the labels and annotations to add to it.  The policy is given the object, and the old object of an update, as
+// input.object and input.oldObject, and the rule only matches when its 'match' rule is true.  Its optional 'labels'
+// and 'annotations' rules are objects of strings which are added to the object along with the payload's additions.
+type Rego struct {
+	Policy string `mapstructure:"policy" yaml:"policy,omitempty"`
+}
+
+// regoDecision is what a policy decided about an object.
+type regoDecision struct {
+	Match       bool              `json:"match"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// regoQueries caches prepared policies so that they are only compiled once rather than for every object.
+var regoQueries sync.Map
+
+func (r Rego) empty() bool {
+	return strings.TrimSpace(r.Policy) == ""
+}
+
+func (r Rego) validate() error {
+	if r.empty() {
+		return nil
+	}
+	_, err := prepareRego(r.Policy)
+	return err
+}
+
+// prepareRego parses and compiles a policy into a query for its package's document, once.
+func prepareRego(policy string) (rego.PreparedEvalQuery, error) {
+	if pq, ok := regoQueries.Load(policy); ok {
+		return pq.(rego.PreparedEvalQuery), nil
+	}
+	module, err := ast.ParseModule("policy.rego", policy)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+	if module == nil {
+		return rego.PreparedEvalQuery{}, fmt.Errorf("policy is empty")
+	}
+	pq, err := rego.New(
+		rego.Query("decision = "+module.Package.Path.String()),
+		rego.ParsedModule(module),
+	).PrepareForEval(context.Background())
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+	regoQueries.Store(policy, pq)
+	return pq, nil
+}
+
+// decide evaluates the policy against an object and its old object.
+func (r Rego) decide(object, oldObject []byte) (regoDecision, error) {
+	var decision regoDecision
+	pq, err := prepareRego(r.Policy)
+	if err != nil {
+		return decision, err
+	}
+	var obj, old interface{}
+	if err := json.Unmarshal(object, &obj); err != nil {
+		return decision, fmt.Errorf("failed to unmarshal object for rego policy: %v", err)
+	}
+	if len(oldObject) > 0 {
+		if err := json.Unmarshal(oldObject, &old); err != nil {
+			return decision, fmt.Errorf("failed to unmarshal old object for rego policy: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), regoTimeout)
+	defer cancel()
+	rs, err := pq.Eval(ctx, rego.EvalInput(map[string]interface{}{"object": obj, "oldObject": old}))
+	if err != nil {
+		return decision, fmt.Errorf("failed to evaluate rego policy: %v", err)
+	}
+	if len(rs) == 0 {
+		return decision, nil
+	}
+	// round trip the policy's document through json, so that rules it doesn't define are simply left empty
+	raw, err := json.Marshal(rs[0].Bindings["decision"])
+	if err != nil {
+		return decision, err
+	}
+	if err := json.Unmarshal(raw, &decision); err != nil {
+		return decision, fmt.Errorf("rego policy's match must be a bool and its labels and annotations objects of strings: %v", err)
+	}
+	return decision, nil
+}
+
+// validEmitted drops the labels and annotations emitted by a policy that aren't valid, logging them, so that a policy
+// bug can't make the patch fail.
+func (d regoDecision) validEmitted(mylog zerolog.Logger) (labels, annotations map[string]string) {
+	for k, v := range d.Labels {
+		errs := append(utilvalidation.IsQualifiedName(k), utilvalidation.IsValidLabelValue(v)...)
+		if len(errs) > 0 {
+			mylog.Warn().Str("label", k).Str("value", v).Strs("errors", errs).Msg("rego policy emitted an invalid label, skipping it")
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[k] = v
+	}
+	for k, v := range d.Annotations {
+		if errs := apivalidation.ValidateAnnotations(map[string]string{k: v}, field.NewPath("metadata.annotations")); len(errs) > 0 {
+			mylog.Warn().Str("annotation", k).Err(errs.ToAggregate()).Msg("rego policy emitted an invalid annotation, skipping it")
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[k] = v
+	}
+	return labels, annotations
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const ownerPolicy = `
+package graffiti.owners
+
+default match = false
+
+match {
+	input.object.metadata.labels.owner != ""
+	not startswith(input.object.metadata.name, "kube-")
+}
+
+labels = {"team": team} {
+	team := split(input.object.metadata.labels.owner, "@")[0]
+}
+
+annotations = {"owner-email": input.object.metadata.labels.owner}
+`
+
+func TestRegoPolicyDecidesAndEmitsAdditions(t *testing.T) {
+	rule := NewRule("owners").MatchRego(ownerPolicy)
+	require.NoError(t, rule.Validate(log.Logger), "a rego policy can emit all of a rule's additions")
+
+	patch, err := rule.Mutate([]byte(`{"kind":"Namespace","metadata":{"name":"payments","labels":{"owner":"platform@acme.com"}}}`))
+	require.NoError(t, err)
+	var ops []struct {
+		Path  string            `json:"path"`
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 2)
+	values := map[string]map[string]string{ops[0].Path: ops[0].Value, ops[1].Path: ops[1].Value}
+	assert.Equal(t, map[string]string{"owner": "platform@acme.com", "team": "platform"}, values["/metadata/labels"], "the emitted label is added")
+	assert.Equal(t, map[string]string{"owner-email": "platform@acme.com"}, values["/metadata/annotations"])
+
+	patch, err = rule.Mutate([]byte(`{"kind":"Namespace","metadata":{"name":"kube-system","labels":{"owner":"platform@acme.com"}}}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "the policy decides that kube- namespaces don't match")
+
+	match, err := rule.Matches([]byte(`{"kind":"Namespace","metadata":{"name":"kube-public","labels":{"owner":"platform@acme.com"}}}`), nil)
+	require.NoError(t, err)
+	assert.False(t, match)
+}
+
+func TestRegoPolicyIsAndedWithTheMatchersAndPayload(t *testing.T) {
+	rule := NewRule("owners").MatchLabels("env=prod").MatchRego(ownerPolicy).AddLabels(map[string]string{"painted": "true"})
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"Namespace","metadata":{"name":"payments","labels":{"owner":"platform@acme.com"}}}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "the label selector doesn't match")
+
+	patch, err = rule.Mutate([]byte(`{"kind":"Namespace","metadata":{"name":"payments","labels":{"env":"prod","owner":"a@acme.com"}}}`))
+	require.NoError(t, err)
+	assert.Contains(t, string(patch), `"painted": "true"`)
+	assert.Contains(t, string(patch), `"team": "a"`)
+}
+
+func TestRegoPolicyInvalidEmittedLabelsAreSkipped(t *testing.T) {
+	rule := NewRule("bad-labels").MatchRego(`
+package graffiti
+match = true
+labels = {"ok": "yes", "bad key!": "x", "long": "contains spaces"}
+`)
+	require.NoError(t, rule.Validate(log.Logger))
+	patch, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/labels","value":{"ok":"yes"}}]`, string(patch))
+}
+
+func TestValidateRego(t *testing.T) {
+	assert.NoError(t, Rego{}.validate())
+	assert.Error(t, Rego{Policy: "package graffiti\nmatch {"}.validate())
+	assert.Error(t, NewRule("no-payload").Validate(log.Logger), "a rule without a policy still needs a payload")
+	assert.Error(t, NewRule("bad").MatchRego("not rego").AddLabels(map[string]string{"a": "b"}).Validate(log.Logger))
+}
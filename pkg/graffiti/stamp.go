@@ -0,0 +1,115 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// The suffixes of the annotations written by a stamp payload, they follow the rule's name and are prefixed with
+// "graffiti.<company-domain>/".
+const (
+	AppliedAnnotationSuffix = "-applied"
+	HashAnnotationSuffix    = "-hash"
+)
+
+// StampAnnotations are the annotations that record when a rule was applied to an object, and the hash of the payload
+// it applied.
+func (p Payload) StampAnnotations(rule string) (applied, hash string) {
+	prefix := p.annotationPrefix()
+	return prefix + "/" + rule + AppliedAnnotationSuffix, prefix + "/" + rule + HashAnnotationSuffix
+}
+
+// validateStamp checks that the rule's name makes valid stamp annotations.
+func validateStamp(rule string) error {
+	for _, suffix := range []string{AppliedAnnotationSuffix, HashAnnotationSuffix} {
+		if errs := utilvalidation.IsQualifiedName(rule + suffix); len(errs) != 0 {
+			return fmt.Errorf("rule name '%s' is not valid in the stamp annotation '%s': %v", rule, rule+suffix, errs)
+		}
+	}
+	return nil
+}
+
+// hash is the sha256 of everything the payload would apply to the object: its settings along with the labels and
+// annotations it would add, rendered against the object's fields, those copied from its fields and those emitted by a
+// rego policy or inherited from the namespace, so that it changes whenever any of them do.  The additions that
+// generate their values are hashed as their templates, as they are only generated once.
+func (p Payload) hash(obj metaObject, fm map[string]string) (string, error) {
+	labels, err := p.renderedForHash(p.Additions.Labels, obj.Meta.Labels, fm)
+	if err != nil {
+		return "", err
+	}
+	annotations, err := p.renderedForHash(p.Additions.Annotations, obj.Meta.Annotations, fm)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(struct {
+		Payload              Payload
+		RenderedLabels       map[string]string
+		RenderedAnnotations  map[string]string
+		FieldLabels          map[string]string
+		EmittedLabels        map[string]string
+		EmittedAnnotations   map[string]string
+		InheritedLabels      map[string]string
+		InheritedAnnotations map[string]string
+	}{p, labels, annotations, p.labelsFromFields(fm, log.ComponentLogger(componentName, "hash")), p.emittedLabels, p.emittedAnnotations, p.inheritedLabels, p.inheritedAnnotations})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// renderedForHash renders the additions against the object's fields, leaving the templates of those that generate
+// their values as they are.
+func (p Payload) renderedForHash(add, current, fm map[string]string) (map[string]string, error) {
+	rendered, err := renderAdditions(add, current, fm)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range add {
+		if generatesValue(v) {
+			rendered[k] = v
+		}
+	}
+	return rendered, nil
+}
+
+// stamp returns the stamp annotations of applying the payload to the object now, and true when the object already has
+// the same hash and so the payload has already been applied to it.
+func (p Payload) stamp(rule string, obj metaObject, fm map[string]string, at time.Time) (map[string]string, bool, error) {
+	hash, err := p.hash(obj, fm)
+	if err != nil {
+		return nil, false, err
+	}
+	appliedKey, hashKey := p.StampAnnotations(rule)
+	if obj.Meta.Annotations[hashKey] == hash {
+		return nil, true, nil
+	}
+	return map[string]string{
+		appliedKey: at.UTC().Format(time.RFC3339),
+		hashKey:    hash,
+	}, false, nil
+}
+
+// withStamp returns a copy of the payload which also adds the stamp annotations.
+func (p Payload) withStamp(annotations map[string]string) Payload {
+	p.stampAnnotations = annotations
+	return p
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStampRecordsTheAppliedPayloadAndSkipsStampedObjects(t *testing.T) {
+	rule := Rule{
+		Name: "label-team",
+		Payload: Payload{
+			Stamp:     true,
+			Additions: Additions{Labels: map[string]string{"team": "web"}},
+		}.WithCompanyDomain("acme.com"),
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default"}}`))
+	require.NoError(t, err)
+	var ops []struct {
+		Op    string            `json:"op"`
+		Path  string            `json:"path"`
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 2)
+	assert.Equal(t, "web", ops[0].Value["team"])
+	annotations := ops[1].Value
+	applied, err := time.Parse(time.RFC3339, annotations["graffiti.acme.com/label-team-applied"])
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), applied, time.Minute)
+	hash := annotations["graffiti.acme.com/label-team-hash"]
+	assert.Len(t, hash, 64)
+
+	stamped := `{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default","labels":{"team":"web"},"annotations":{"graffiti.acme.com/label-team-hash":"` + hash + `"}}}`
+	patch, err = rule.Mutate([]byte(stamped))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "an object stamped with the same payload isn't patched again")
+
+	rule.Payload.Additions.Labels["team"] = "payments"
+	patch, err = rule.Mutate([]byte(stamped))
+	require.NoError(t, err)
+	assert.NotNil(t, patch, "a changed payload is applied again")
+	assert.NotContains(t, string(patch), hash)
+}
+
+func TestStampedObjectsArePatchedAgainWhenTheirRenderedValuesChange(t *testing.T) {
+	rule := Rule{
+		Name: "label-owner",
+		Payload: Payload{
+			Stamp:     true,
+			Additions: Additions{Labels: map[string]string{"owner": `{{ index . "data.owner" }}`}},
+		}.WithCompanyDomain("acme.com"),
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	patch, err := rule.Mutate([]byte(`{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default"},"data":{"owner":"dave"}}`))
+	require.NoError(t, err)
+	var ops []struct {
+		Value map[string]string `json:"value"`
+	}
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	require.Len(t, ops, 2)
+	hash := ops[1].Value["graffiti.acme.com/label-owner-hash"]
+
+	stamped := `{"kind":"ConfigMap","metadata":{"name":"web","namespace":"default","labels":{"owner":"dave"},"annotations":{"graffiti.acme.com/label-owner-hash":"` + hash + `"}},"data":{"owner":%q}}`
+	patch, err = rule.Mutate([]byte(fmt.Sprintf(stamped, "dave")))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "an object stamped with the same rendered values isn't patched again")
+
+	patch, err = rule.Mutate([]byte(fmt.Sprintf(stamped, "sue")))
+	require.NoError(t, err)
+	require.NotNil(t, patch, "a change of the field a template renders is applied again")
+	require.NoError(t, json.Unmarshal(patch, &ops))
+	assert.Equal(t, "sue", ops[0].Value["owner"])
+	assert.NotEqual(t, hash, ops[1].Value["graffiti.acme.com/label-owner-hash"])
+}
+
+func TestStampHashIgnoresGeneratedValues(t *testing.T) {
+	p := Payload{Stamp: true, Additions: Additions{Labels: map[string]string{"id": "{{ uuid }}"}}}
+	a, err := p.hash(metaObject{}, nil)
+	require.NoError(t, err)
+	b, err := p.hash(metaObject{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, a, b, "a generated value is only added once so it mustn't change the hash")
+}
+
+func TestStampHashIncludesInheritedValues(t *testing.T) {
+	p := Payload{Stamp: true, InheritFromNamespace: InheritFromNamespace{Labels: []string{"team"}}}
+	a, err := p.withInherited(map[string]string{"team": "web"}, nil).hash(metaObject{}, nil)
+	require.NoError(t, err)
+	b, err := p.withInherited(map[string]string{"team": "payments"}, nil).hash(metaObject{}, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+}
+
+func TestStampValidation(t *testing.T) {
+	assert.Error(t, Payload{Stamp: true, JSONPatch: `[]`}.validate(), "only additions and deletions can be stamped")
+	assert.Error(t, Payload{Stamp: true}.validate())
+
+	long := Rule{Name: "a-rule-with-a-name-that-is-far-too-long-to-be-part-of-an-annotation", Payload: Payload{
+		Stamp:     true,
+		Additions: Additions{Labels: map[string]string{"team": "web"}},
+	}}
+	assert.Error(t, long.Validate(log.Logger))
+}
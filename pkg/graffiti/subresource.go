@@ -0,0 +1,61 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+
+	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ParentLookup gets the object that a subresource belongs to, e.g. the deployment of a 'deployments/scale' request.
+type ParentLookup interface {
+	LookupParent(resource metav1.GroupVersionResource, namespace, name string) ([]byte, error)
+}
+
+// parentLookup finds the parents of the subresources in admission requests.  Without one, rules are evaluated against
+// the subresource's own object.
+var parentLookup ParentLookup
+
+// SetParentLookup sets how the parent objects of subresources are found.
+func SetParentLookup(l ParentLookup) {
+	parentLookup = l
+}
+
+// fullObjectSubresources are the subresources whose admission requests carry the whole of the parent object, so that
+// rules can match and paint it as they would the object itself.
+var fullObjectSubresources = map[string]bool{
+	"status":              true,
+	"ephemeralcontainers": true,
+	"finalize":            true,
+	"approval":            true,
+	"resize":              true,
+}
+
+// admissionObjects returns the object, and the old object, that a rule is evaluated against for an admission request.
+// The requests of subresources that don't carry their parent, such as 'deployments/scale' or 'pods/eviction', are
+// evaluated against the parent instead, which is true when it was looked up.  A parent can be matched and blocked but
+// can't be patched, as the object of the request is the subresource.
+func admissionObjects(req *admission.AdmissionRequest) (object, oldObject []byte, parent bool, err error) {
+	if req.SubResource == "" || fullObjectSubresources[req.SubResource] || parentLookup == nil || req.Name == "" {
+		object, err = extractObject(req)
+		return object, req.OldObject.Raw, false, err
+	}
+	object, err = parentLookup.LookupParent(req.Resource, req.Namespace, req.Name)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("can't get the %s of %s subresource '%s': %v", req.Resource.Resource, req.SubResource, req.Name, err)
+	}
+	return object, nil, true, nil
+}
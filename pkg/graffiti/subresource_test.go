@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// parents is a ParentLookup of objects by their resource and name.
+type parents map[string]string
+
+func (p parents) LookupParent(resource metav1.GroupVersionResource, namespace, name string) ([]byte, error) {
+	if object, ok := p[resource.Resource+"/"+namespace+"/"+name]; ok {
+		return []byte(object), nil
+	}
+	return nil, errors.New("not found")
+}
+
+func scaleRequest(name string) *admission.AdmissionRequest {
+	return &admission.AdmissionRequest{
+		Operation:   admission.Update,
+		Name:        name,
+		Namespace:   "team-a",
+		Kind:        metav1.GroupVersionKind{Group: "autoscaling", Version: "v1", Kind: "Scale"},
+		Resource:    metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		SubResource: "scale",
+		Object:      runtime.RawExtension{Raw: []byte(`{"kind":"Scale","apiVersion":"autoscaling/v1","metadata":{"name":"` + name + `"},"spec":{"replicas":10}}`)},
+		OldObject:   runtime.RawExtension{Raw: []byte(`{"kind":"Scale","apiVersion":"autoscaling/v1","metadata":{"name":"` + name + `"},"spec":{"replicas":2}}`)},
+	}
+}
+
+func TestSubresourcesAreMatchedAgainstTheirParent(t *testing.T) {
+	SetParentLookup(parents{"deployments/team-a/web": `{"kind":"Deployment","metadata":{"name":"web","namespace":"team-a","labels":{"scaling":"frozen"}}}`})
+	defer SetParentLookup(nil)
+
+	rule := Rule{Name: "frozen-scale", Matchers: Matchers{LabelSelectors: []string{"scaling = frozen"}}, Payload: Payload{Block: true}}
+	require.NoError(t, rule.Validate(log.Logger))
+	resp := rule.MutateAdmission(scaleRequest("web"))
+	assert.False(t, resp.Allowed, "the deployment's labels should be matched for its scale subresource")
+
+	resp = rule.MutateAdmission(scaleRequest("api"))
+	assert.Equal(t, metav1.StatusReasonInternalError, resp.Result.Reason)
+	assert.Contains(t, resp.Result.Message, "can't get the deployments of scale subresource 'api': not found")
+
+	label := Rule{Name: "label-scaled", Matchers: Matchers{LabelSelectors: []string{"scaling = frozen"}}, Payload: Payload{Additions: Additions{Labels: map[string]string{"scaled": "true"}}}}
+	require.NoError(t, label.Validate(log.Logger))
+	resp = label.MutateAdmission(scaleRequest("web"))
+	assert.True(t, resp.Allowed)
+	assert.Nil(t, resp.Patch, "the parent of a subresource can't be patched")
+}
+
+func TestStatusIsMatchedAndPaintedAsTheObject(t *testing.T) {
+	SetParentLookup(parents{})
+	defer SetParentLookup(nil)
+
+	rule := Rule{
+		Name:     "label-failed-pods",
+		Matchers: Matchers{FieldSelectors: []string{"status.phase=Failed"}},
+		Payload:  Payload{Additions: Additions{Labels: map[string]string{"failed": "true"}}},
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+	req := &admission.AdmissionRequest{
+		Operation:   admission.Update,
+		Name:        "web-1",
+		Namespace:   "team-a",
+		Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		SubResource: "status",
+		Object:      runtime.RawExtension{Raw: []byte(`{"kind":"Pod","metadata":{"name":"web-1"},"status":{"phase":"Failed"}}`)},
+		OldObject:   runtime.RawExtension{Raw: []byte(`{"kind":"Pod","metadata":{"name":"web-1"},"status":{"phase":"Running"}}`)},
+	}
+	resp := rule.MutateAdmission(req)
+	assert.True(t, resp.Allowed)
+	assert.Contains(t, string(resp.Patch), `"failed": "true"`, "a status request carries the whole pod, which can be patched")
+}
+
+func TestSubresourcesAreMatchedThemselvesWithoutAParentLookup(t *testing.T) {
+	rule := Rule{Name: "block-scale", Payload: Payload{Block: true}}
+	require.NoError(t, rule.Validate(log.Logger))
+	match, err := rule.MatchesAdmission(scaleRequest("web"))
+	require.NoError(t, err)
+	assert.True(t, match)
+}
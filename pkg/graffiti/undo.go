@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Undo returns a JSON patch that reverses the labels and annotations which the rule painted onto an object, using
+// the annotations of its stamp to find the objects that it painted.  The keys that it adds are removed, or restored
+// from backup-previous annotations, the keys that it deleted are restored when they were backed up, and the backup
+// and stamp annotations are removed.  It is nil when the object doesn't have the rule's stamp.
+func (r Rule) Undo(object []byte) ([]byte, error) {
+	if !r.Payload.Stamp {
+		return nil, fmt.Errorf("rule '%s' doesn't stamp the objects that it paints, so they can't be found to undo", r.Name)
+	}
+	obj, _, err := unmarshalObject(object)
+	if err != nil {
+		return nil, err
+	}
+	applied, hash := r.Payload.StampAnnotations(r.Name)
+	if _, ok := obj.Meta.Annotations[applied]; !ok {
+		return nil, nil
+	}
+
+	p := r.Payload
+	dels := p.allDeletions()
+	labels := append(mapKeys(p.Additions.Labels), mapKeys(p.LabelsFromFields)...)
+	labels = append(labels, p.InheritFromNamespace.Labels...)
+	if !p.Expire.isEmpty() {
+		labels = append(labels, p.ExpiryLabel())
+	}
+	annotations := append(mapKeys(p.Additions.Annotations), p.InheritFromNamespace.Annotations...)
+
+	restoredLabels, removedLabels, usedBackups := p.undoKeys(obj.Meta.Labels, obj.Meta.Annotations, labels, dels.Labels, false)
+	restoredAnnotations, removedAnnotations, usedAnnotationBackups := p.undoKeys(obj.Meta.Annotations, obj.Meta.Annotations, annotations, dels.Annotations, true)
+	removedAnnotations = append(removedAnnotations, applied, hash)
+	removedAnnotations = append(removedAnnotations, usedBackups...)
+	removedAnnotations = append(removedAnnotations, usedAnnotationBackups...)
+
+	var patches []string
+	op, err := createPatchOperand(obj.Meta.Labels, nil, restoredLabels, nil, removedLabels, "/metadata/labels")
+	if err != nil {
+		return nil, err
+	}
+	if op != "" {
+		patches = append(patches, op)
+	}
+	op, err = createPatchOperand(obj.Meta.Annotations, nil, restoredAnnotations, nil, removedAnnotations, "/metadata/annotations")
+	if err != nil {
+		return nil, err
+	}
+	if op != "" {
+		patches = append(patches, op)
+	}
+	if len(patches) == 0 {
+		return nil, nil
+	}
+	return []byte(`[ ` + strings.Join(patches, ", ") + ` ]`), nil
+}
+
+// undoKeys works out how to reverse the keys that a payload added to, and deleted from, the current labels or
+// annotations.  Keys with a backup-previous annotation are restored to their previous value, the other added keys are
+// removed, and the backup annotations that were used are returned so that they can be removed too.
+func (p Payload) undoKeys(current, annotations map[string]string, added, deleted []string, annotation bool) (restored map[string]string, removed, backups []string) {
+	restored = make(map[string]string)
+	for _, k := range append(added, deleted...) {
+		backup := p.PreviousValueAnnotation(k, annotation)
+		if previous, ok := annotations[backup]; ok && backup != "" {
+			restored[k] = previous
+			backups = append(backups, backup)
+			continue
+		}
+		if _, ok := current[k]; ok && !isIn(k, deleted) {
+			removed = append(removed, k)
+		}
+	}
+	return restored, removed, backups
+}
+
+func mapKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func isIn(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoRemovesTheAdditionsOfStampedObjects(t *testing.T) {
+	rule := Rule{Name: "label-team", Payload: Payload{
+		Additions: Additions{Labels: map[string]string{"team": "mobile"}, Annotations: map[string]string{"owner": "alice"}},
+		Stamp:     true,
+	}.WithCompanyDomain("acme.com")}
+
+	stamped := `{"kind":"Pod","metadata":{"name":"web","labels":{"team":"mobile","app":"web"},
+		"annotations":{"owner":"alice","graffiti.acme.com/label-team-applied":"2020-06-01T12:00:00Z","graffiti.acme.com/label-team-hash":"abc"}}}`
+	patch, err := rule.Undo([]byte(stamped))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"replace","path":"/metadata/labels","value":{"app":"web"}},{"op":"remove","path":"/metadata/annotations"}]`, string(patch))
+
+	patch, err = rule.Undo([]byte(`{"kind":"Pod","metadata":{"name":"web","labels":{"team":"mobile"}}}`))
+	require.NoError(t, err)
+	assert.Nil(t, patch, "objects without the rule's stamp are left alone")
+}
+
+func TestUndoRestoresBackedUpValues(t *testing.T) {
+	rule := Rule{Name: "relabel", Payload: Payload{
+		Additions:      Additions{Labels: map[string]string{"team": "mobile"}},
+		Deletions:      Deletions{Labels: []string{"legacy"}},
+		BackupPrevious: true,
+		Stamp:          true,
+	}.WithCompanyDomain("acme.com")}
+
+	stamped := `{"kind":"Pod","metadata":{"name":"web","labels":{"team":"mobile"},"annotations":{"keep":"me",
+		"graffiti.acme.com/prev.team":"web","graffiti.acme.com/prev.legacy":"yes",
+		"graffiti.acme.com/relabel-applied":"2020-06-01T12:00:00Z","graffiti.acme.com/relabel-hash":"abc"}}}`
+	patch, err := rule.Undo([]byte(stamped))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"op":"replace","path":"/metadata/labels","value":{"team":"web","legacy":"yes"}},{"op":"replace","path":"/metadata/annotations","value":{"keep":"me"}}]`, string(patch))
+}
+
+func TestUndoNeedsAStamp(t *testing.T) {
+	rule := Rule{Name: "label-team", Payload: Payload{Additions: Additions{Labels: map[string]string{"team": "mobile"}}}}
+	_, err := rule.Undo([]byte(`{"kind":"Pod","metadata":{"name":"web"}}`))
+	assert.EqualError(t, err, "rule 'label-team' doesn't stamp the objects that it paints, so they can't be found to undo")
+}
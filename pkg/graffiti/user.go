@@ -0,0 +1,123 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"fmt"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// serviceAccountPrefix is how kubernetes prefixes the username of a service account.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// UserMatcher matches the identity of the user making an admission request.  Each list may contain '*' wildcards,
+// which match any characters, and the user must match at least one entry of each of the settings that are specified.
+// Objects checked by check-existing have no requesting user and never match it.
+type UserMatcher struct {
+	Usernames []string `mapstructure:"usernames" yaml:"usernames,omitempty"`
+	// ServiceAccounts are '<namespace>/<name>' shorthands for the usernames of service accounts.
+	ServiceAccounts []string            `mapstructure:"service-accounts" yaml:"service-accounts,omitempty"`
+	Groups          []string            `mapstructure:"groups" yaml:"groups,omitempty"`
+	Extra           map[string][]string `mapstructure:"extra" yaml:"extra,omitempty"`
+}
+
+func (u UserMatcher) empty() bool {
+	return len(u.Usernames) == 0 && len(u.ServiceAccounts) == 0 && len(u.Groups) == 0 && len(u.Extra) == 0
+}
+
+func (u UserMatcher) validate() error {
+	_, err := u.allUsernames()
+	return err
+}
+
+// allUsernames returns the usernames along with the usernames of the service accounts.
+func (u UserMatcher) allUsernames() ([]string, error) {
+	usernames := append([]string{}, u.Usernames...)
+	for _, sa := range u.ServiceAccounts {
+		parts := strings.Split(sa, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid service account '%s', must be '<namespace>/<name>'", sa)
+		}
+		usernames = append(usernames, serviceAccountPrefix+parts[0]+":"+parts[1])
+	}
+	return usernames, nil
+}
+
+// matches decides whether the user matches, a nil user (when there isn't an admission request) never does.
+func (u UserMatcher) matches(user *authenticationv1.UserInfo) bool {
+	if user == nil {
+		return false
+	}
+	usernames, err := u.allUsernames()
+	if err != nil {
+		return false
+	}
+	if len(usernames) > 0 && !matchAny(usernames, user.Username) {
+		return false
+	}
+	if len(u.Groups) > 0 && !matchAny(u.Groups, user.Groups...) {
+		return false
+	}
+	for key, patterns := range u.Extra {
+		if !matchAny(patterns, user.Extra[key]...) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny is true when any of the values matches any of the patterns.
+func matchAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		for _, value := range values {
+			if globMatch(pattern, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globMatch matches a value against a pattern in which '*' matches any characters.
+func globMatch(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == value
+	}
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return strings.HasSuffix(value, parts[len(parts)-1])
+}
+
+// addUserFields adds the requesting user to the field map, so that field selectors and addition templates can refer
+// to who made the request, e.g. '{{ index . "request.userInfo.username" }}'.
+func addUserFields(fm map[string]string, user *authenticationv1.UserInfo) {
+	if user == nil {
+		return
+	}
+	fm["request.userInfo.username"] = user.Username
+	fm["request.userInfo.uid"] = user.UID
+	fm["request.userInfo.groups"] = strings.Join(user.Groups, ",")
+}
@@ -0,0 +1,88 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestUserMatcherMatchesRequestingUser(t *testing.T) {
+	deployer := &authenticationv1.UserInfo{
+		Username: "system:serviceaccount:ci:deployer",
+		Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:ci"},
+	}
+	alice := &authenticationv1.UserInfo{
+		Username: "https://login.acme.com#alice",
+		Groups:   []string{"developers"},
+		Extra:    map[string]authenticationv1.ExtraValue{"team": {"web"}},
+	}
+
+	tests := []struct {
+		name     string
+		matcher  UserMatcher
+		deployer bool
+		alice    bool
+	}{
+		{"username", UserMatcher{Usernames: []string{"https://login.acme.com#alice"}}, false, true},
+		{"wildcard username", UserMatcher{Usernames: []string{"https://*#*"}}, false, true},
+		{"service account", UserMatcher{ServiceAccounts: []string{"ci/deployer"}}, true, false},
+		{"any service account in namespace", UserMatcher{ServiceAccounts: []string{"ci/*"}}, true, false},
+		{"group", UserMatcher{Groups: []string{"system:serviceaccounts:*"}}, true, false},
+		{"extra", UserMatcher{Extra: map[string][]string{"team": {"web", "platform"}}}, false, true},
+		{"all settings must match", UserMatcher{Usernames: []string{"*alice"}, Groups: []string{"admins"}}, false, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.matcher.validate())
+			assert.Equal(t, tc.deployer, tc.matcher.matches(deployer))
+			assert.Equal(t, tc.alice, tc.matcher.matches(alice))
+			assert.False(t, tc.matcher.matches(nil), "there is no requesting user of existing objects")
+		})
+	}
+
+	assert.EqualError(t, UserMatcher{ServiceAccounts: []string{"deployer"}}.validate(), "invalid service account 'deployer', must be '<namespace>/<name>'")
+}
+
+func TestRulesCanAnnotateObjectsWithTheRequestingUser(t *testing.T) {
+	rule := Rule{
+		Name: "created-by-ci",
+		Matchers: Matchers{
+			RequestedBy: UserMatcher{ServiceAccounts: []string{"ci/*"}},
+		},
+		Payload: Payload{
+			Additions: Additions{Annotations: map[string]string{"created-by": `{{ index . "request.userInfo.username" }}`}},
+		},
+	}
+	require.NoError(t, rule.Validate(log.Logger))
+
+	req := &admission.AdmissionRequest{
+		Operation: admission.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind":"Pod","metadata":{"name":"web","namespace":"default"}}`)},
+		UserInfo:  authenticationv1.UserInfo{Username: "system:serviceaccount:ci:deployer"},
+	}
+	resp := rule.MutateAdmission(req)
+	require.True(t, resp.Allowed)
+	assert.JSONEq(t, `[{"op":"add","path":"/metadata/annotations","value":{"created-by":"system:serviceaccount:ci:deployer"}}]`, string(resp.Patch))
+
+	req.UserInfo.Username = "alice"
+	resp = rule.MutateAdmission(req)
+	assert.Nil(t, resp.Patch, "objects created by other users should not be annotated")
+}
@@ -0,0 +1,157 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// versionConversion converts the objects of a kind from its older api versions to its preferred one.
+type versionConversion struct {
+	preferred string
+	// from maps each older api version to the changes to its fields, which are none when only the version changed.
+	from map[string]func(map[string]interface{})
+}
+
+// sameFields converts an object whose fields are the same in both versions.
+func sameFields(map[string]interface{}) {}
+
+// versionConversions are the kinds whose objects can be normalized, by kind.
+var versionConversions = map[string]versionConversion{
+	"Ingress": {preferred: "networking.k8s.io/v1", from: map[string]func(map[string]interface{}){
+		"extensions/v1beta1":        convertIngressV1beta1,
+		"networking.k8s.io/v1beta1": convertIngressV1beta1,
+	}},
+	"IngressClass": {preferred: "networking.k8s.io/v1", from: map[string]func(map[string]interface{}){
+		"networking.k8s.io/v1beta1": sameFields,
+	}},
+	"NetworkPolicy": {preferred: "networking.k8s.io/v1", from: map[string]func(map[string]interface{}){
+		"extensions/v1beta1": sameFields,
+	}},
+	"Deployment":  appsConversion("extensions/v1beta1", "apps/v1beta1", "apps/v1beta2"),
+	"DaemonSet":   appsConversion("extensions/v1beta1", "apps/v1beta2"),
+	"ReplicaSet":  appsConversion("extensions/v1beta1", "apps/v1beta2"),
+	"StatefulSet": appsConversion("apps/v1beta1", "apps/v1beta2"),
+	"CronJob": {preferred: "batch/v1", from: map[string]func(map[string]interface{}){
+		"batch/v1beta1": sameFields,
+	}},
+	"PodDisruptionBudget": {preferred: "policy/v1", from: map[string]func(map[string]interface{}){
+		"policy/v1beta1": sameFields,
+	}},
+	"HorizontalPodAutoscaler": {preferred: "autoscaling/v2", from: map[string]func(map[string]interface{}){
+		"autoscaling/v2beta2": sameFields,
+	}},
+	"PriorityClass": {preferred: "scheduling.k8s.io/v1", from: map[string]func(map[string]interface{}){
+		"scheduling.k8s.io/v1beta1": sameFields,
+	}},
+	"Role":               rbacConversion(),
+	"ClusterRole":        rbacConversion(),
+	"RoleBinding":        rbacConversion(),
+	"ClusterRoleBinding": rbacConversion(),
+}
+
+func appsConversion(versions ...string) versionConversion {
+	c := versionConversion{preferred: "apps/v1", from: make(map[string]func(map[string]interface{}))}
+	for _, version := range versions {
+		c.from[version] = sameFields
+	}
+	return c
+}
+
+func rbacConversion() versionConversion {
+	return versionConversion{preferred: "rbac.authorization.k8s.io/v1", from: map[string]func(map[string]interface{}){
+		"rbac.authorization.k8s.io/v1beta1": sameFields,
+	}}
+}
+
+// convertIngressV1beta1 moves the default backend to spec.defaultBackend, the service and port of each backend into
+// a service, and defaults the type of each path, as the apiserver does.
+func convertIngressV1beta1(object map[string]interface{}) {
+	spec, ok := object["spec"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if backend, ok := spec["backend"].(map[string]interface{}); ok {
+		convertIngressBackend(backend)
+		spec["defaultBackend"] = backend
+		delete(spec, "backend")
+	}
+	rules, _ := spec["rules"].([]interface{})
+	for _, rule := range rules {
+		rule, _ := rule.(map[string]interface{})
+		http, _ := rule["http"].(map[string]interface{})
+		paths, _ := http["paths"].([]interface{})
+		for _, path := range paths {
+			path, ok := path.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := path["pathType"]; !ok {
+				path["pathType"] = "ImplementationSpecific"
+			}
+			if backend, ok := path["backend"].(map[string]interface{}); ok {
+				convertIngressBackend(backend)
+			}
+		}
+	}
+}
+
+// convertIngressBackend replaces the serviceName and servicePort of a backend with a service, whose port is a number
+// or a name.
+func convertIngressBackend(backend map[string]interface{}) {
+	name, ok := backend["serviceName"]
+	if !ok {
+		return
+	}
+	service := map[string]interface{}{"name": name}
+	switch port := backend["servicePort"].(type) {
+	case string:
+		service["port"] = map[string]interface{}{"name": port}
+	case json.Number:
+		service["port"] = map[string]interface{}{"number": port}
+	}
+	backend["service"] = service
+	delete(backend, "serviceName")
+	delete(backend, "servicePort")
+}
+
+// normalizeVersion converts an object to the preferred api version of its kind, so that the same matchers match it
+// whichever version it was made with.  Objects of other kinds and versions are returned unchanged.
+func normalizeVersion(object []byte) ([]byte, error) {
+	if len(object) == 0 {
+		return object, nil
+	}
+	var typeMeta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := json.Unmarshal(object, &typeMeta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the object's api version and kind: %v", err)
+	}
+	convert, ok := versionConversions[typeMeta.Kind].from[typeMeta.APIVersion]
+	if !ok {
+		return object, nil
+	}
+	var fields map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(object))
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the object to convert it: %v", err)
+	}
+	convert(fields)
+	fields["apiVersion"] = versionConversions[typeMeta.Kind].preferred
+	return json.Marshal(fields)
+}
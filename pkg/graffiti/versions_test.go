@@ -0,0 +1,82 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	ingressV1beta1 = `{"apiVersion":"extensions/v1beta1","kind":"Ingress","metadata":{"name":"web","namespace":"default"},"spec":{"backend":{"serviceName":"default-web","servicePort":"http"},"rules":[{"host":"web.example.com","http":{"paths":[{"path":"/","backend":{"serviceName":"web","servicePort":8080}}]}}]}}`
+	ingressV1      = `{"apiVersion":"networking.k8s.io/v1","kind":"Ingress","metadata":{"name":"web","namespace":"default"},"spec":{"defaultBackend":{"service":{"name":"default-web","port":{"name":"http"}}},"rules":[{"host":"web.example.com","http":{"paths":[{"path":"/","pathType":"Prefix","backend":{"service":{"name":"web","port":{"number":8080}}}}]}}]}}`
+)
+
+func TestNormalizeVersionConvertsOlderIngresses(t *testing.T) {
+	converted, err := normalizeVersion([]byte(ingressV1beta1))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"apiVersion":"networking.k8s.io/v1","kind":"Ingress","metadata":{"name":"web","namespace":"default"},"spec":{"defaultBackend":{"service":{"name":"default-web","port":{"name":"http"}}},"rules":[{"host":"web.example.com","http":{"paths":[{"path":"/","pathType":"ImplementationSpecific","backend":{"service":{"name":"web","port":{"number":8080}}}}]}}]}}`, string(converted))
+
+	converted, err = normalizeVersion([]byte(ingressV1))
+	require.NoError(t, err)
+	assert.Equal(t, ingressV1, string(converted), "the preferred version is left alone")
+}
+
+func TestNormalizeVersionOnlyChangesTheVersionOfSameFieldKinds(t *testing.T) {
+	converted, err := normalizeVersion([]byte(`{"apiVersion":"batch/v1beta1","kind":"CronJob","metadata":{"name":"backup"},"spec":{"schedule":"0 1 * * *","successfulJobsHistoryLimit":3}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"apiVersion":"batch/v1","kind":"CronJob","metadata":{"name":"backup"},"spec":{"schedule":"0 1 * * *","successfulJobsHistoryLimit":3}}`, string(converted))
+
+	converted, err = normalizeVersion([]byte(`{"apiVersion":"apps/v1beta2","kind":"Deployment","metadata":{"name":"web"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"web"}}`, string(converted))
+
+	for _, object := range []string{
+		`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"nginx"}}`,
+		`{"apiVersion":"example.com/v1beta1","kind":"Ingress","metadata":{"name":"custom"}}`,
+	} {
+		converted, err := normalizeVersion([]byte(object))
+		require.NoError(t, err)
+		assert.Equal(t, object, string(converted), "other kinds and versions are unchanged")
+	}
+}
+
+func TestNormalizedVersionsAreMatchedWithOneSetOfSelectors(t *testing.T) {
+	rule := Rule{
+		Name:     "web-ingresses",
+		Matchers: Matchers{FieldSelectors: []string{"apiVersion=networking.k8s.io/v1,spec.defaultBackend.service.name=default-web"}, NormalizeVersions: true},
+		Payload:  Payload{Additions: Additions{Labels: map[string]string{"backend": "{{ index . \"spec.backend.serviceName\" }}"}}},
+	}
+	for _, object := range []string{ingressV1beta1, ingressV1} {
+		match, err := rule.Matches([]byte(object), nil)
+		require.NoError(t, err)
+		assert.True(t, match, object)
+	}
+
+	patch, err := rule.Mutate([]byte(ingressV1beta1))
+	require.NoError(t, err)
+	assert.Contains(t, string(patch), `"backend": "default-web"`, "the payload is rendered against the object as it was made")
+
+	rule.Matchers.NormalizeVersions = false
+	match, err := rule.Matches([]byte(ingressV1beta1), nil)
+	require.NoError(t, err)
+	assert.False(t, match, "older versions aren't converted unless the matchers normalize them")
+}
+
+func TestNormalizeVersionsCanBeADefault(t *testing.T) {
+	assert.True(t, Matchers{}.WithDefaults(Matchers{NormalizeVersions: true}).NormalizeVersions)
+	assert.True(t, Matchers{NormalizeVersions: true}.WithDefaults(Matchers{}).NormalizeVersions)
+}
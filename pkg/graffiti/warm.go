@@ -0,0 +1,126 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// parsedTemplates, labelSelectors and fieldSelectors cache what rules would otherwise parse for every object.  They
+// are keyed by the strings from the rules' configuration, and parsed templates and selectors are safe to share.
+var (
+	parsedTemplates sync.Map
+	labelSelectors  sync.Map
+	fieldSelectors  sync.Map
+)
+
+// parsedSelector caches parse failures as well, as negated selectors are first tried as plain kubernetes selectors.
+type parsedSelector struct {
+	labels labels.Selector
+	fields fields.Selector
+	err    error
+}
+
+// parseTemplate parses an addition's value as a template, once.
+func parseTemplate(field string) (*template.Template, error) {
+	if tmpl, ok := parsedTemplates.Load(field); ok {
+		return tmpl.(*template.Template), nil
+	}
+	tmpl, err := template.New("field").Funcs(templateFuncs()).Parse(field)
+	if err != nil {
+		return nil, err
+	}
+	parsedTemplates.Store(field, tmpl)
+	return tmpl, nil
+}
+
+// parseLabelSelector parses a kubernetes label selector, once.
+func parseLabelSelector(selector string) (labels.Selector, error) {
+	if parsed, ok := labelSelectors.Load(selector); ok {
+		return parsed.(parsedSelector).labels, parsed.(parsedSelector).err
+	}
+	s, err := labels.Parse(selector)
+	labelSelectors.Store(selector, parsedSelector{labels: s, err: err})
+	return s, err
+}
+
+// parseFieldSelector parses a kubernetes field selector, once.
+func parseFieldSelector(selector string) (fields.Selector, error) {
+	if parsed, ok := fieldSelectors.Load(selector); ok {
+		return parsed.(parsedSelector).fields, parsed.(parsedSelector).err
+	}
+	s, err := fields.ParseSelector(selector)
+	fieldSelectors.Store(selector, parsedSelector{fields: s, err: err})
+	return s, err
+}
+
+// Warm parses and compiles the rule's selectors, cel expression and addition templates into their caches, so that
+// the first objects that the rule is evaluated against aren't slowed down by doing it.
+func (r Rule) Warm() error {
+	for _, selector := range r.Matchers.LabelSelectors {
+		if _, err := parseLabelSelector(withoutNegation(selector, ValidateLabelSelector)); err != nil {
+			return err
+		}
+	}
+	for _, selector := range r.Matchers.FieldSelectors {
+		if _, err := parseFieldSelector(withoutNegation(selector, nil)); err != nil {
+			return err
+		}
+	}
+	if r.Matchers.CEL != "" {
+		if _, err := compileCEL(r.Matchers.CEL); err != nil {
+			return err
+		}
+	}
+	if !r.Rego.empty() {
+		if _, err := prepareRego(r.Rego.Policy); err != nil {
+			return err
+		}
+	}
+	for _, values := range []map[string]string{r.Payload.Additions.Labels, r.Payload.Additions.Annotations} {
+		for _, v := range values {
+			if _, err := parseTemplate(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WarmRules warms each of the rules in turn, until the budget is used up when it isn't 0, and logs how long it took.
+// Rules which aren't warmed, or fail to warm, still work but are warmed by the first objects that they see.
+func WarmRules(rules []Rule, budget time.Duration) (warmed int, took time.Duration) {
+	mylog := log.ComponentLogger(componentName, "WarmRules")
+	start := time.Now()
+	for _, r := range rules {
+		if budget > 0 && time.Since(start) > budget {
+			mylog.Warn().Dur("budget", budget).Int("warmed", warmed).Int("rules", len(rules)).Msg("ran out of time warming the rules, the rest are warmed by their first objects")
+			break
+		}
+		if err := r.Warm(); err != nil {
+			mylog.Error().Err(err).Str("rule", r.Name).Msg("failed to warm rule")
+			continue
+		}
+		warmed++
+	}
+	took = time.Since(start)
+	mylog.Info().Int("warmed", warmed).Int("rules", len(rules)).Dur("took", took).Msg("warmed rules")
+	return warmed, took
+}
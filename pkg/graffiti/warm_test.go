@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graffiti
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmCachesSelectorsCelAndTemplates(t *testing.T) {
+	rule := NewRule("warm").
+		MatchLabels("!team=platform", "app in (web,api)").
+		MatchFields("metadata.namespace=warm").
+		MatchCEL("object.metadata.name == 'warm-cel'").
+		AddLabels(map[string]string{"shard": `{{ shard 4 (index . "metadata.namespace") }}`})
+	require.NoError(t, rule.Warm())
+
+	_, ok := labelSelectors.Load("team=platform")
+	assert.True(t, ok, "the negated label selector should be cached without its '!'")
+	_, ok = fieldSelectors.Load("metadata.namespace=warm")
+	assert.True(t, ok)
+	_, ok = celPrograms.Load("object.metadata.name == 'warm-cel'")
+	assert.True(t, ok)
+	_, ok = parsedTemplates.Load(`{{ shard 4 (index . "metadata.namespace") }}`)
+	assert.True(t, ok)
+
+	_, err := parseLabelSelector("app in (web,api")
+	assert.Error(t, err)
+	cached, ok := labelSelectors.Load("app in (web,api")
+	require.True(t, ok, "parse failures should be cached too")
+	assert.Error(t, cached.(parsedSelector).err)
+}
+
+func TestWarmRulesStopsWhenTheBudgetIsUsedUp(t *testing.T) {
+	rules := []Rule{
+		NewRule("good").AddLabels(map[string]string{"a": "{{ .b }}"}),
+		NewRule("bad").AddLabels(map[string]string{"a": "{{ .b"}),
+	}
+	warmed, _ := WarmRules(rules, 0)
+	assert.Equal(t, 1, warmed, "a rule that fails to warm isn't counted")
+
+	warmed, _ = WarmRules(rules, time.Nanosecond)
+	assert.True(t, warmed < len(rules), "warming should stop once the budget is used up")
+}
@@ -0,0 +1,38 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import "sync"
+
+// FakeChecker is a Checker for use in tests, it returns Err and counts how many times it has been called.
+type FakeChecker struct {
+	sync.Mutex
+	Err   error
+	calls int
+}
+
+// Check records the call and returns the fake's error.
+func (f *FakeChecker) Check() error {
+	f.Lock()
+	defer f.Unlock()
+	f.calls++
+	return f.Err
+}
+
+// Calls returns the number of times that Check has been called.
+func (f *FakeChecker) Calls() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.calls
+}
@@ -14,54 +14,69 @@ limitations under the License.
 package healthcheck
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/Telefonica/kube-graffiti/pkg/log"
-	corev1 "k8s.io/api/core/v1"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
-const componentName = "healthcheck"
+const (
+	componentName = "healthcheck"
+	// LivenessPath only checks that we are running and able to answer http requests.
+	LivenessPath = "/livez"
+	// ReadinessPath checks that we are ready to serve admission requests, using the checks and readiness checks.
+	ReadinessPath = "/readyz"
+)
 
-// HealthChecker is a http server that responds to http requests on http://0.0.0.0:port/path and returns 200 if it can read kubernetes api (list namespaces)
+// HealthChecker is a http server that responds to http requests on http://0.0.0.0:port/path and returns 200 if all of its checks pass,
+// by default that it can read kubernetes api (list namespaces).
 type HealthChecker struct {
-	Port   int    `mapstructure:"port"`
-	Path   string `mapstructure:"path"`
-	client kubernetesClient
-	server *http.Server
+	Port      int    `mapstructure:"port"`
+	Path      string `mapstructure:"path"`
+	TLS       TLS    `mapstructure:"tls" yaml:"tls,omitempty"`
+	checks    []Checker
+	readiness []Checker
+	server    *http.Server
 }
 
-// Abstract kubernetes client to cut down amount to mock, we only need to list namespaces.
-type kubernetesClient interface {
-	namespaces() kubernetesNamespaceAccessor
+// Checker is a single health check, we are healthy when none of our checkers return an error.
+// Users embedding this package can implement their own to probe any other dependencies.
+type Checker interface {
+	Check() error
 }
 
-type kubernetesNamespaceAccessor interface {
-	List(options metav1.ListOptions) (*corev1.NamespaceList, error)
+// CheckerFunc allows an ordinary function to be used as a Checker.
+type CheckerFunc func() error
+
+// Check calls f()
+func (f CheckerFunc) Check() error {
+	return f()
 }
 
-// CutDownKubernetesClient wraps a real kubernetes client and implements the kubernetesNamespaceAccessor interface
-type cutDownKubernetesClient struct {
-	client *kubernetes.Clientset
+// namespaceChecker checks that we can list namespaces via the kubernetes api.
+type namespaceChecker struct {
+	client kubernetes.Interface
 }
 
-// In our kubernetes client type we know how to get namespaces without needing to know that
-// namespaces are in fact in CoreV1.
-func (real cutDownKubernetesClient) namespaces() kubernetesNamespaceAccessor {
-	return real.client.CoreV1().Namespaces()
+// NewNamespaceChecker creates a Checker that is healthy when it can list namespaces using the kubernetes client.
+func NewNamespaceChecker(k kubernetes.Interface) Checker {
+	return namespaceChecker{client: k}
 }
 
-// NewCutDownNamespaceClient converts a full blown kubernetes clientset into a cut down one that implements the kubernetesNamespaceAccessor interface
-func NewCutDownNamespaceClient(k *kubernetes.Clientset) cutDownKubernetesClient {
-	return cutDownKubernetesClient{client: k}
+func (n namespaceChecker) Check() error {
+	_, err := n.client.CoreV1().Namespaces().List(metav1.ListOptions{})
+	return err
 }
 
-func NewHealthChecker(k kubernetesClient, port int, path string) HealthChecker {
+// NewHealthChecker creates a health-checker http server which runs each of the checks whenever it is called.
+func NewHealthChecker(port int, path string, checks ...Checker) HealthChecker {
 	mylog := log.ComponentLogger(componentName, "NewHealthChecker")
-	mylog.Debug().Int("port", port).Msg("creating a new health-checker http server")
+	mylog.Debug().Int("port", port).Int("checks", len(checks)).Msg("creating a new health-checker http server")
 
 	mux := http.NewServeMux()
 	server := &http.Server{
@@ -71,11 +86,30 @@ func NewHealthChecker(k kubernetesClient, port int, path string) HealthChecker {
 	return HealthChecker{
 		Port:   port,
 		Path:   path,
-		client: k,
+		checks: checks,
 		server: server,
 	}
 }
 
+// WithReadinessChecks returns a copy of the health-checker that also runs the checks when asked whether we are ready.
+func (h HealthChecker) WithReadinessChecks(checks ...Checker) HealthChecker {
+	h.readiness = append(append([]Checker{}, h.readiness...), checks...)
+	return h
+}
+
+// WithTLS returns a copy of the health-checker that serves https, and verifies client certificates when the tls has
+// a client CA.
+func (h HealthChecker) WithTLS(t TLS) HealthChecker {
+	h.TLS = t
+	return h
+}
+
+// Handle serves another handler, such as an admin endpoint, on the health-checker's port.  It must be called after
+// WithTLS, so that it requires client certificates, and before StartHealthChecker.
+func (h HealthChecker) Handle(path string, handler http.Handler) {
+	h.server.Handler.(*http.ServeMux).Handle(path, h.TLS.requireClientCert(handler))
+}
+
 // StartHealthChecker starts the health-checker http server in a go-routine.
 func (h HealthChecker) StartHealthChecker() {
 	mylog := log.ComponentLogger(componentName, "StartHealthChecker")
@@ -83,15 +117,34 @@ func (h HealthChecker) StartHealthChecker() {
 
 	// add ourselves as the handler for http requests
 	// rather than using HandleFunc we use Handle so that the Handler can use the health-checker
-	// object as context and therefore have access to its embedded kubernetesClient.
+	// object as context and therefore have access to its checks.
 	mux := h.server.Handler.(*http.ServeMux)
 	mux.Handle(h.Path, h)
+	mux.HandleFunc(LivenessPath, h.serveLiveness)
+	mux.HandleFunc(ReadinessPath, h.serveReadiness)
+	mux.Handle(metrics.Path, h.TLS.requireClientCert(metrics.Handler()))
+	mux.Handle(metrics.RulesStatusPath, h.TLS.requireClientCert(metrics.Rules))
+	mux.Handle(metrics.SourcesStatusPath, h.TLS.requireClientCert(metrics.Sources))
+
+	if !h.TLS.Enabled() {
+		go func() {
+			if err := h.server.ListenAndServe(); err != nil {
+				mylog.Fatal().Err(err).Msg("failed to start the health-checker server")
+			}
+		}()
+		return
+	}
 
-	// start the health-checker handler http server
-	var err error
+	// start the health-checker handler https server
+	config, err := h.TLS.config()
+	if err != nil {
+		mylog.Fatal().Err(err).Msg("failed to configure tls for the health-checker server")
+	}
+	h.server.TLSConfig = config
+	mylog.Info().Bool("client-certificates", h.TLS.ClientCAPath != "").Msg("serving the health-checker over https")
 	go func() {
-		if err = h.server.ListenAndServe(); err != nil {
-			mylog.Fatal().Err(err).Msg("failed to start the webhook server")
+		if err := h.server.ListenAndServeTLS(h.TLS.CertPath, h.TLS.KeyPath); err != nil {
+			mylog.Fatal().Err(err).Msg("failed to start the health-checker server")
 		}
 	}()
 
@@ -102,9 +155,8 @@ func (h HealthChecker) StartHealthChecker() {
 func (h HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	mylog := log.ComponentLogger(componentName, "healthCheckHandler")
 	reqLog := mylog.With().Str("url", r.URL.String()).Str("host", r.Host).Str("method", r.Method).Str("ua", r.UserAgent()).Str("remote", r.RemoteAddr).Logger()
-	reqLog.Debug().Msg("health check triggered, listing namespaces via kubernetes api")
-	_, err := h.client.namespaces().List(metav1.ListOptions{})
-	if err != nil {
+	reqLog.Debug().Int("checks", len(h.checks)).Msg("health check triggered, running checks")
+	if err := h.check(); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Header().Set("Content-Type", "application/json")
 		io.WriteString(w, `{"healthy": false}`)
@@ -117,3 +169,51 @@ func (h HealthChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, `{"healthy": true}`)
 	reqLog.Debug().Int("status", http.StatusOK).Msg("returning ok")
 }
+
+// serveLiveness always succeeds, a health-checker that can answer means that the process is alive.
+func (h HealthChecker) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"alive": true}`)
+}
+
+// serveReadiness runs the checks and readiness checks, and reports the first failure.
+func (h HealthChecker) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	mylog := log.ComponentLogger(componentName, "serveReadiness")
+	w.Header().Set("Content-Type", "application/json")
+	if err := h.checkReady(); err != nil {
+		mylog.Warn().Err(err).Msg("not ready")
+		data, _ := json.Marshal(struct {
+			Ready  bool   `json:"ready"`
+			Reason string `json:"reason"`
+		}{false, err.Error()})
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(data)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"ready": true}`)
+}
+
+// checkReady runs the checks and then the readiness checks and returns the first error
+func (h HealthChecker) checkReady() error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	for _, c := range h.readiness {
+		if err := c.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check runs each of the checks in turn and returns the first error
+func (h HealthChecker) check() error {
+	for _, c := range h.checks {
+		if err := c.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
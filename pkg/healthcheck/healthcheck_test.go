@@ -20,36 +20,14 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
-	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
-// Allowing us to mock out our calls to list kubernetes namespaces
-type kubernetesClientMock struct {
-	mock.Mock
-}
-
-func (k *kubernetesClientMock) namespaces() kubernetesNamespaceAccessor {
-	args := k.Called()
-	return args.Get(0).(*kubernetesNamespaceAccessorMock)
-}
-
-type kubernetesNamespaceAccessorMock struct {
-	mock.Mock
-}
-
-func (r *kubernetesNamespaceAccessorMock) List(options metav1.ListOptions) (*corev1.NamespaceList, error) {
-	args := r.Called(options)
-	return args.Get(0).(*corev1.NamespaceList), args.Error(1)
-}
-
 func TestHealthlyCheck(t *testing.T) {
-	// set up the mocks
-	lister := new(kubernetesNamespaceAccessorMock)
-	lister.On("List", mock.AnythingOfType("v1.ListOptions")).Return(&corev1.NamespaceList{}, nil)
-	kclient := new(kubernetesClientMock)
-	kclient.On("namespaces").Return(lister)
+	// set up the fake kubernetes api
+	clientset := fake.NewSimpleClientset()
 
 	// Create a request to pass to our handler. We don't have any query parameters for now, so we'll
 	// pass 'nil' as the third parameter.
@@ -58,15 +36,13 @@ func TestHealthlyCheck(t *testing.T) {
 
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr := httptest.NewRecorder()
-	checker := NewHealthChecker(kclient, 80, "/healthz")
+	checker := NewHealthChecker(80, "/healthz", NewNamespaceChecker(clientset))
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
 	checker.ServeHTTP(rr, req)
 
 	assert.Equal(t, rr.Code, http.StatusOK)
-	kclient.AssertExpectations(t)
-	lister.AssertExpectations(t)
 
 	// Check the response body is what we expect.
 	expected := `{"healthy": true}`
@@ -74,11 +50,11 @@ func TestHealthlyCheck(t *testing.T) {
 }
 
 func TestUnHealthlyCheck(t *testing.T) {
-	// set up the mocks
-	lister := new(kubernetesNamespaceAccessorMock)
-	lister.On("List", mock.AnythingOfType("v1.ListOptions")).Return(&corev1.NamespaceList{}, fmt.Errorf("test error"))
-	kclient := new(kubernetesClientMock)
-	kclient.On("namespaces").Return(lister)
+	// set up the fake kubernetes api to fail listing namespaces
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("list", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("test error")
+	})
 
 	// Create a request to pass to our handler. We don't have any query parameters for now, so we'll
 	// pass 'nil' as the third parameter.
@@ -87,17 +63,47 @@ func TestUnHealthlyCheck(t *testing.T) {
 
 	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
 	rr := httptest.NewRecorder()
-	checker := NewHealthChecker(kclient, 80, "/healthz")
+	checker := NewHealthChecker(80, "/healthz", NewNamespaceChecker(clientset))
 
 	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
 	// directly and pass in our Request and ResponseRecorder.
 	checker.ServeHTTP(rr, req)
 
 	assert.Equal(t, rr.Code, http.StatusInternalServerError)
-	kclient.AssertExpectations(t)
-	lister.AssertExpectations(t)
 
 	// Check the response body is what we expect.
 	expected := `{"healthy": false}`
 	assert.Equal(t, rr.Body.String(), expected)
 }
+
+func TestEveryCheckMustPass(t *testing.T) {
+	healthy := &FakeChecker{}
+	unhealthy := &FakeChecker{Err: fmt.Errorf("dependency is down")}
+
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	assert.Nil(t, err, "We created a valid http request")
+	rr := httptest.NewRecorder()
+	checker := NewHealthChecker(80, "/healthz", healthy, unhealthy)
+	checker.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, 1, healthy.Calls())
+	assert.Equal(t, 1, unhealthy.Calls())
+}
+
+func TestCheckerFuncCanBePluggedIn(t *testing.T) {
+	called := false
+	check := CheckerFunc(func() error {
+		called = true
+		return nil
+	})
+
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	assert.Nil(t, err, "We created a valid http request")
+	rr := httptest.NewRecorder()
+	checker := NewHealthChecker(80, "/healthz", check)
+	checker.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, called, "our check function should have been called")
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+)
+
+// certificateChecker checks that the serving certificate and key can be loaded and that the certificate is valid now.
+type certificateChecker struct {
+	certPath, keyPath string
+	now               func() time.Time
+}
+
+// NewCertificateChecker creates a Checker that is healthy when the certificate and key at the paths form a pair, and
+// the certificate has not expired.
+func NewCertificateChecker(certPath, keyPath string) Checker {
+	return certificateChecker{certPath: certPath, keyPath: keyPath, now: time.Now}
+}
+
+func (c certificateChecker) Check() error {
+	pair, err := tls.LoadX509KeyPair(c.certPath, c.keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load the serving certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse the serving certificate: %v", err)
+	}
+	now := c.now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("the serving certificate is only valid from %s until %s", cert.NotBefore.UTC().Format(time.RFC3339), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// rulesRegisteredChecker checks that at least one of the active rules is registered with the apiserver.
+type rulesRegisteredChecker struct {
+	rules *metrics.RuleTracker
+}
+
+// NewRulesRegisteredChecker creates a Checker that is healthy once any of the active rules has been registered with
+// the apiserver, or when there are none.  A rule that fails to register, such as a bad rule from a ConfigMap, only
+// shows in the rules' status, as failing readiness would take every replica out of the service and so fail the
+// calls to all of the other rules' webhooks too.
+func NewRulesRegisteredChecker(rules *metrics.RuleTracker) Checker {
+	return rulesRegisteredChecker{rules: rules}
+}
+
+func (c rulesRegisteredChecker) Check() error {
+	var unregistered []string
+	for _, status := range c.rules.Statuses() {
+		if !status.Active {
+			continue
+		}
+		if status.Registered {
+			return nil
+		}
+		if status.RegistrationError != "" {
+			unregistered = append(unregistered, fmt.Sprintf("%s (%s)", status.Name, status.RegistrationError))
+			continue
+		}
+		unregistered = append(unregistered, status.Name)
+	}
+	if len(unregistered) > 0 {
+		return fmt.Errorf("none of the rules are registered with the apiserver: %s", strings.Join(unregistered, ", "))
+	}
+	return nil
+}
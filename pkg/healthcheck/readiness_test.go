@@ -0,0 +1,132 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLivenessAlwaysSucceeds(t *testing.T) {
+	checker := NewHealthChecker(80, "/healthz", &FakeChecker{Err: errors.New("apiserver is down")})
+	rr := httptest.NewRecorder()
+	checker.serveLiveness(rr, httptest.NewRequest("GET", LivenessPath, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"alive": true}`, rr.Body.String())
+}
+
+func TestReadinessRunsChecksAndReadinessChecks(t *testing.T) {
+	healthy := &FakeChecker{}
+	notReady := &FakeChecker{Err: errors.New("none of the rules are registered with the apiserver: label-pods")}
+	checker := NewHealthChecker(80, "/healthz", healthy).WithReadinessChecks(notReady)
+
+	rr := httptest.NewRecorder()
+	checker.serveReadiness(rr, httptest.NewRequest("GET", ReadinessPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.JSONEq(t, `{"ready": false, "reason": "none of the rules are registered with the apiserver: label-pods"}`, rr.Body.String())
+	assert.Equal(t, 1, healthy.Calls())
+
+	rr = httptest.NewRecorder()
+	checker.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "readiness checks are not health checks")
+	assert.Equal(t, 1, notReady.Calls())
+
+	notReady.Err = nil
+	rr = httptest.NewRecorder()
+	checker.serveReadiness(rr, httptest.NewRequest("GET", ReadinessPath, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRulesRegisteredChecker(t *testing.T) {
+	rules := metrics.NewRuleTracker()
+	rules.Add("label-pods", "mutating")
+	rules.SetActive("label-pods", nil)
+	rules.Add("broken", "mutating")
+	rules.SetActive("broken", errors.New("invalid"))
+
+	checker := NewRulesRegisteredChecker(rules)
+	assert.EqualError(t, checker.Check(), "none of the rules are registered with the apiserver: label-pods", "invalid rules are never registered")
+
+	rules.SetRegistered("label-pods", errors.New("webhook registration failed"))
+	assert.EqualError(t, checker.Check(), "none of the rules are registered with the apiserver: label-pods (webhook registration failed)", "the reason a registration failed is given")
+
+	rules.SetRegistered("label-pods", nil)
+	assert.NoError(t, checker.Check())
+
+	rules.Add("tenant-rule", "mutating")
+	rules.SetActive("tenant-rule", nil)
+	rules.SetRegistered("tenant-rule", errors.New("admission webhook denied the request"))
+	assert.NoError(t, checker.Check(), "one rule that fails to register doesn't make us unready")
+}
+
+func TestRulesRegisteredCheckerWithoutActiveRules(t *testing.T) {
+	rules := metrics.NewRuleTracker()
+	rules.Add("scheduled", "mutating")
+	rules.SetActive("scheduled", errors.New("outside of its schedule"))
+	assert.NoError(t, NewRulesRegisteredChecker(rules).Check(), "there is nothing to register")
+}
+
+func TestCertificateChecker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "healthcheck")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certPath, keyPath := writeTestCert(t, dir)
+
+	checker := NewCertificateChecker(certPath, keyPath).(certificateChecker)
+	assert.NoError(t, checker.Check())
+
+	checker.now = func() time.Time { return time.Now().Add(48 * time.Hour) }
+	assert.Contains(t, checker.Check().Error(), "the serving certificate is only valid from")
+
+	assert.Contains(t, NewCertificateChecker(filepath.Join(dir, "missing"), keyPath).Check().Error(), "failed to load the serving certificate")
+}
+
+// writeTestCert writes a self-signed certificate, valid for a day, and its key.
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "kube-graffiti.kube-graffiti.svc"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath := filepath.Join(dir, "server-cert")
+	keyPath := filepath.Join(dir, "server-key")
+	require.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certPath, keyPath
+}
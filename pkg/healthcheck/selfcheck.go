@@ -0,0 +1,215 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// SelfTestPath is the path that our webhook server answers the self-check's admission reviews on, allowing them
+// without evaluating any rules.
+const SelfTestPath = "/graffiti-self-test"
+
+// SelfCheck configures a readiness check that dials our own webhook over tls, and verifies its certificate in the
+// same way that the apiserver does, so we aren't ready until the apiserver would be able to call us.
+type SelfCheck struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled,omitempty"`
+	// Address is the host:port that is dialed, by default our webhook port through the self-check's Service.
+	Address string `mapstructure:"address" yaml:"address,omitempty"`
+	// Service is a headless service that selects our pods with publishNotReadyAddresses, so that it has endpoints
+	// for them before they are ready, by default '<service>-self-check'.  Our own service isn't dialed as it has no
+	// endpoints until we are ready.
+	Service string `mapstructure:"service" yaml:"service,omitempty"`
+	// ServerName is sent as the SNI and is the name that the certificate must be valid for, by default our service's
+	// dns name whatever the address is.
+	ServerName string `mapstructure:"server-name" yaml:"server-name,omitempty"`
+	// InsecureSkipVerifyLocalhost skips verifying the certificate, which is only allowed when dialing a loopback address.
+	InsecureSkipVerifyLocalhost bool          `mapstructure:"insecure-skip-verify-localhost" yaml:"insecure-skip-verify-localhost,omitempty"`
+	Timeout                     time.Duration `mapstructure:"timeout" yaml:"timeout,omitempty"`
+	// Review sends a synthetic admission review to the webhook, rather than only making a tls connection, and
+	// verifies its response, so we aren't ready until our webhook answers reviews made through the service.  Once it has
+	// passed the check goes back to making a tls connection.
+	Review bool `mapstructure:"review" yaml:"review,omitempty"`
+	// ClientCertPath and ClientKeyPath are a client certificate that is presented to the webhook, which is needed
+	// for the review when the webhook requires client certificates.
+	ClientCertPath string `mapstructure:"client-cert-path" yaml:"client-cert-path,omitempty"`
+	ClientKeyPath  string `mapstructure:"client-key-path" yaml:"client-key-path,omitempty"`
+}
+
+// Validate checks that the self-check is usable.
+func (s SelfCheck) Validate() error {
+	if s.Timeout < 0 {
+		return fmt.Errorf("server.self-check.timeout can not be negative")
+	}
+	if s.Address != "" {
+		if _, _, err := net.SplitHostPort(s.Address); err != nil {
+			return fmt.Errorf("invalid server.self-check.address '%s': %v", s.Address, err)
+		}
+	}
+	if s.InsecureSkipVerifyLocalhost && !isLoopback(s.Address) {
+		return fmt.Errorf("server.self-check.insecure-skip-verify-localhost can only be used with a localhost address, not '%s'", s.Address)
+	}
+	if (s.ClientCertPath == "") != (s.ClientKeyPath == "") {
+		return fmt.Errorf("server.self-check.client-cert-path and server.self-check.client-key-path must be set together")
+	}
+	if s.Review && !s.Enabled {
+		return fmt.Errorf("server.self-check.review needs server.self-check.enabled")
+	}
+	return nil
+}
+
+// isLoopback is true when the host of the address is localhost or a loopback ip address, ipv4 or ipv6.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// selfChecker dials our webhook with a tls handshake, or sends it an admission review until one has been answered.
+type selfChecker struct {
+	check      SelfCheck
+	caCertPath string
+	// reviewed is set once the webhook has answered an admission review.
+	reviewed *int32
+}
+
+// NewSelfChecker creates a Checker that is healthy when a tls connection can be made to the self-check's address and
+// the certificate that is served is valid for its server name and is signed by the ca at caCertPath.  When the
+// self-check reviews, it is only healthy once the webhook has also answered an admission review correctly.  The ca
+// is read on each check so that it can be rotated.
+func NewSelfChecker(check SelfCheck, caCertPath string) Checker {
+	return selfChecker{check: check, caCertPath: caCertPath, reviewed: new(int32)}
+}
+
+func (c selfChecker) Check() error {
+	config, err := c.tlsConfig()
+	if err != nil {
+		return err
+	}
+	if c.check.Review && atomic.LoadInt32(c.reviewed) == 0 {
+		if err := c.review(config); err != nil {
+			return fmt.Errorf("failed the self-test admission review of our webhook at %s as %s: %v", c.check.Address, c.check.ServerName, err)
+		}
+		atomic.StoreInt32(c.reviewed, 1)
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: c.check.Timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", c.check.Address, config)
+	if err != nil {
+		return fmt.Errorf("failed to make a tls connection to our webhook at %s as %s: %v", c.check.Address, c.check.ServerName, err)
+	}
+	return conn.Close()
+}
+
+// tlsConfig verifies the webhook's certificate against the ca, and presents the client certificate when there is one.
+func (c selfChecker) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.check.ServerName}
+	if c.check.InsecureSkipVerifyLocalhost && isLoopback(c.check.Address) {
+		config.InsecureSkipVerify = true
+	} else {
+		pem, err := ioutil.ReadFile(c.caCertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the ca certificate: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the ca certificate %s", c.caCertPath)
+		}
+	}
+	if c.check.ClientCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(c.check.ClientCertPath, c.check.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the self-check's client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// review sends the webhook an admission review of the creation of a config map, as the apiserver would, and checks
+// that it is allowed in a response to the same review.
+func (c selfChecker) review(config *tls.Config) error {
+	uid := types.UID(uuid.New().String())
+	object, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "kube-graffiti-self-test"},
+	})
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(admission.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admission.AdmissionRequest{
+			UID:       uid,
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+			Name:      "kube-graffiti-self-test",
+			Operation: admission.Create,
+			Object:    runtime.RawExtension{Raw: object},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: c.check.Timeout, Transport: &http.Transport{TLSClientConfig: config}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Post("https://"+c.check.Address+SelfTestPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the webhook responded with %s", resp.Status)
+	}
+	var review admission.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return fmt.Errorf("the webhook's response is not an admission review: %v", err)
+	}
+	switch {
+	case review.APIVersion != "admission.k8s.io/v1":
+		return fmt.Errorf("the webhook responded with an admission review of version '%s'", review.APIVersion)
+	case review.Response == nil:
+		return fmt.Errorf("the webhook's admission review has no response")
+	case review.Response.UID != uid:
+		return fmt.Errorf("the webhook responded to review '%s' rather than '%s'", review.Response.UID, uid)
+	case !review.Response.Allowed:
+		return fmt.Errorf("the webhook did not allow the review")
+	}
+	return nil
+}
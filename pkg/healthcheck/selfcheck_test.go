@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfCheckServer serves tls with httptest's certificate, which is valid for example.com and 127.0.0.1, and writes it
+// out as the ca.
+func selfCheckServer(t *testing.T) (address, caPath string) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	caPath = filepath.Join(t.TempDir(), "ca-cert")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, ioutil.WriteFile(caPath, ca, 0600))
+	return strings.TrimPrefix(server.URL, "https://"), caPath
+}
+
+func TestSelfCheckVerifiesTheServerName(t *testing.T) {
+	address, caPath := selfCheckServer(t)
+
+	check := SelfCheck{Address: address, ServerName: "example.com", Timeout: time.Second}
+	assert.NoError(t, NewSelfChecker(check, caPath).Check(), "the server name is verified rather than the address")
+
+	check.ServerName = "graffiti-service.test-namespace.svc"
+	err := NewSelfChecker(check, caPath).Check()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to make a tls connection to our webhook at "+address+" as graffiti-service.test-namespace.svc")
+}
+
+func TestSelfCheckCanSkipVerifyingLocalhost(t *testing.T) {
+	address, _ := selfCheckServer(t)
+
+	check := SelfCheck{Address: address, ServerName: "graffiti-service.test-namespace.svc", InsecureSkipVerifyLocalhost: true, Timeout: time.Second}
+	assert.NoError(t, check.Validate())
+	assert.NoError(t, NewSelfChecker(check, "/no-such-ca").Check(), "the ca isn't needed when skipping verification")
+}
+
+func TestSelfCheckOnlySkipsVerifyingLoopbackAddresses(t *testing.T) {
+	for _, address := range []string{"localhost:8443", "LOCALHOST:8443", "127.0.0.1:8443", "[::1]:8443"} {
+		assert.NoError(t, SelfCheck{Address: address, InsecureSkipVerifyLocalhost: true}.Validate(), address)
+	}
+	for _, address := range []string{"", "graffiti.default.svc:443", "10.0.0.1:8443", "[fd00::1]:8443"} {
+		assert.Error(t, SelfCheck{Address: address, InsecureSkipVerifyLocalhost: true}.Validate(), address)
+	}
+	assert.EqualError(t, SelfCheck{Address: "::1"}.Validate(), "invalid server.self-check.address '::1': address ::1: too many colons in address")
+	assert.EqualError(t, SelfCheck{Timeout: -time.Second}.Validate(), "server.self-check.timeout can not be negative")
+}
+
+// reviewingServer answers admission reviews on the self-test path with the response that answer makes, counting them.
+func reviewingServer(t *testing.T, answer func(review map[string]interface{}) interface{}) (address, caPath string, reviews *int) {
+	reviews = new(int)
+	mux := http.NewServeMux()
+	mux.HandleFunc(SelfTestPath, func(w http.ResponseWriter, r *http.Request) {
+		*reviews++
+		var review map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&review))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(answer(review))
+	})
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	caPath = filepath.Join(t.TempDir(), "ca-cert")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, ioutil.WriteFile(caPath, ca, 0600))
+	return strings.TrimPrefix(server.URL, "https://"), caPath, reviews
+}
+
+// allow answers a review in the same way as our webhook does when no rule is evaluated.
+func allow(review map[string]interface{}) interface{} {
+	request := review["request"].(map[string]interface{})
+	return map[string]interface{}{
+		"apiVersion": review["apiVersion"],
+		"kind":       "AdmissionReview",
+		"response":   map[string]interface{}{"uid": request["uid"], "allowed": true},
+	}
+}
+
+func TestSelfCheckReviewsUntilTheWebhookHasAnswered(t *testing.T) {
+	address, caPath, reviews := reviewingServer(t, allow)
+	check := SelfCheck{Enabled: true, Review: true, Address: address, ServerName: "example.com", Timeout: time.Second}
+	require.NoError(t, check.Validate())
+
+	checker := NewSelfChecker(check, caPath)
+	assert.NoError(t, checker.Check())
+	assert.Equal(t, 1, *reviews)
+	assert.NoError(t, checker.Check(), "it goes back to making a tls connection")
+	assert.Equal(t, 1, *reviews)
+	assert.NoError(t, NewSelfChecker(check, caPath).Check(), "a new checker reviews again")
+	assert.Equal(t, 2, *reviews)
+}
+
+func TestSelfCheckReviewMustBeAnsweredCorrectly(t *testing.T) {
+	answers := map[string]func(map[string]interface{}) interface{}{
+		"the webhook did not allow the review": func(review map[string]interface{}) interface{} {
+			answer := allow(review).(map[string]interface{})
+			answer["response"].(map[string]interface{})["allowed"] = false
+			return answer
+		},
+		"the webhook responded to review 'another-uid'": func(review map[string]interface{}) interface{} {
+			answer := allow(review).(map[string]interface{})
+			answer["response"].(map[string]interface{})["uid"] = "another-uid"
+			return answer
+		},
+		"the webhook's admission review has no response": func(review map[string]interface{}) interface{} {
+			return map[string]interface{}{"apiVersion": review["apiVersion"], "kind": "AdmissionReview"}
+		},
+		"the webhook responded with an admission review of version 'admission.k8s.io/v1beta1'": func(review map[string]interface{}) interface{} {
+			answer := allow(review).(map[string]interface{})
+			answer["apiVersion"] = "admission.k8s.io/v1beta1"
+			return answer
+		},
+	}
+	for message, answer := range answers {
+		address, caPath, _ := reviewingServer(t, answer)
+		check := SelfCheck{Enabled: true, Review: true, Address: address, ServerName: "example.com", Timeout: time.Second}
+		err := NewSelfChecker(check, caPath).Check()
+		require.Error(t, err, message)
+		assert.Contains(t, err.Error(), "failed the self-test admission review of our webhook at "+address+" as example.com: "+message)
+	}
+
+	address, caPath := selfCheckServer(t)
+	check := SelfCheck{Enabled: true, Review: true, Address: address, ServerName: "example.com", Timeout: time.Second}
+	err := NewSelfChecker(check, caPath).Check()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the webhook responded with 404 Not Found", "a tls connection alone is not enough")
+}
+
+func TestSelfCheckReviewAndClientCertificateAreValidated(t *testing.T) {
+	assert.EqualError(t, SelfCheck{Review: true}.Validate(), "server.self-check.review needs server.self-check.enabled")
+	assert.EqualError(t, SelfCheck{ClientCertPath: "/certs/client.pem"}.Validate(), "server.self-check.client-cert-path and server.self-check.client-key-path must be set together")
+	assert.NoError(t, SelfCheck{Enabled: true, Review: true, ClientCertPath: "/certs/client.pem", ClientKeyPath: "/certs/client-key.pem"}.Validate())
+
+	check := SelfCheck{Address: "localhost:8443", InsecureSkipVerifyLocalhost: true, ClientCertPath: "/no-such-cert", ClientKeyPath: "/no-such-key"}
+	err := NewSelfChecker(check, "").Check()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to load the self-check's client certificate")
+}
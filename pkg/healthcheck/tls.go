@@ -0,0 +1,85 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLS serves the health-checker over https instead of http, with the certificate and key at CertPath and KeyPath.
+// Setting ClientCAPath also verifies client certificates against the CA (mTLS), so that the health and metrics
+// endpoints can be exposed through service meshes that require it.  The kubelet can't present a client certificate,
+// so the liveness, readiness and health endpoints still answer clients without one.
+type TLS struct {
+	CertPath     string `mapstructure:"cert-path" yaml:"cert-path,omitempty"`
+	KeyPath      string `mapstructure:"key-path" yaml:"key-path,omitempty"`
+	ClientCAPath string `mapstructure:"client-ca-path" yaml:"client-ca-path,omitempty"`
+}
+
+// Enabled is true when the health-checker should serve https.
+func (t TLS) Enabled() bool {
+	return t.CertPath != "" || t.KeyPath != ""
+}
+
+// Validate checks that the certificate and key are given together, and that client certificates are only verified
+// over https.
+func (t TLS) Validate() error {
+	if (t.CertPath == "") != (t.KeyPath == "") {
+		return errors.New("health-checker.tls needs both a cert-path and a key-path")
+	}
+	if t.ClientCAPath != "" && !t.Enabled() {
+		return errors.New("health-checker.tls.client-ca-path needs a cert-path and key-path to serve https")
+	}
+	return nil
+}
+
+// config returns the tls config of the health-checker server, which verifies client certificates when there is a
+// client CA.
+func (t TLS) config() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.ClientCAPath == "" {
+		return config, nil
+	}
+	pem, err := ioutil.ReadFile(t.ClientCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the health-checker client ca: %v", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in the health-checker client ca %s", t.ClientCAPath)
+	}
+	// the certificates that clients do present are always verified, but only the probes may go without one
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	return config, nil
+}
+
+// requireClientCert only lets requests with a verified client certificate through to the handler, when the
+// health-checker verifies client certificates.
+func (t TLS) requireClientCert(handler http.Handler) http.Handler {
+	if t.ClientCAPath == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			http.Error(w, "a client certificate is required", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
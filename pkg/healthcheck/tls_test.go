@@ -0,0 +1,80 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthcheck
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSValidate(t *testing.T) {
+	assert.NoError(t, TLS{}.Validate(), "the health-checker serves http by default")
+	assert.NoError(t, TLS{CertPath: "/tls/cert", KeyPath: "/tls/key", ClientCAPath: "/tls/ca"}.Validate())
+	assert.EqualError(t, TLS{CertPath: "/tls/cert"}.Validate(), "health-checker.tls needs both a cert-path and a key-path")
+	assert.EqualError(t, TLS{ClientCAPath: "/tls/ca"}.Validate(), "health-checker.tls.client-ca-path needs a cert-path and key-path to serve https")
+}
+
+func TestClientCertificatesAreRequiredApartFromTheProbes(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeTestCert(t, dir)
+	// the self-signed client certificate is its own ca
+	settings := TLS{CertPath: certPath, KeyPath: keyPath, ClientCAPath: certPath}
+	config, err := settings.config()
+	require.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, config.ClientAuth)
+
+	checker := NewHealthChecker(80, "/healthz").WithTLS(settings)
+	mux := http.NewServeMux()
+	mux.HandleFunc(LivenessPath, checker.serveLiveness)
+	mux.Handle("/metrics", settings.requireClientCert(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {})))
+	server := httptest.NewUnstartedServer(mux)
+	server.TLS = config
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	anonymous := server.Client()
+	resp, err := anonymous.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, err = anonymous.Get(server.URL + LivenessPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "the kubelet's probes don't need a client certificate")
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	require.NoError(t, err)
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{pair}
+	authenticated := &http.Client{Transport: transport}
+	resp, err = authenticated.Get(server.URL + "/metrics")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestClientCAMustHoldCertificates(t *testing.T) {
+	dir := t.TempDir()
+	_, keyPath := writeTestCert(t, dir)
+	_, err := TLS{ClientCAPath: keyPath}.config()
+	assert.EqualError(t, err, "no certificates found in the health-checker client ca "+keyPath)
+	_, err = TLS{ClientCAPath: filepath.Join(dir, "missing")}.config()
+	assert.Error(t, err)
+}
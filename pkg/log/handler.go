@@ -0,0 +1,109 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	componentName = "log"
+	// LevelsPath is where the LevelsHandler is served.
+	LevelsPath = "/log-levels"
+	// TokenHeader is the request header that must carry the token, the same header as debug admission requests.
+	TokenHeader = "X-Graffiti-Debug"
+)
+
+// levelsHandler lets operators override the level of a component or rule at runtime.
+type levelsHandler struct {
+	token string
+}
+
+// levels is the response of the levels handler, the configured levels and the current overrides.
+type levels struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components,omitempty"`
+	Overrides  []Override        `json:"overrides"`
+}
+
+// NewLevelsHandler creates a handler which lists the log levels on GET, and takes the query parameters 'component' or
+// 'rule', and 'level' and 'duration', to override a level on PUT or to remove an override on DELETE (all of them
+// without a component or rule).  Requests must carry the token in the X-Graffiti-Debug header.
+func NewLevelsHandler(token string) http.Handler {
+	return levelsHandler{token: token}
+}
+
+func (h levelsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mylog := ComponentLogger(componentName, "levelsHandler")
+	if h.token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(TokenHeader)), []byte(h.token)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	q := r.URL.Query()
+	kind, name := OverrideComponent, q.Get(OverrideComponent)
+	if rule := q.Get(OverrideRule); rule != "" {
+		kind, name = OverrideRule, rule
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var d time.Duration
+		if s := q.Get("duration"); s != "" {
+			var err error
+			if d, err = time.ParseDuration(s); err != nil {
+				http.Error(w, fmt.Sprintf("invalid duration '%s'", s), http.StatusBadRequest)
+				return
+			}
+		}
+		o, err := SetOverride(kind, name, q.Get("level"), d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mylog.Warn().Str("kind", o.Kind).Str("name", o.Name).Str("level", o.Level).Time("expires", o.Expires).Msg("overriding the log level")
+	case http.MethodDelete:
+		if name == "" {
+			ClearOverrides()
+			mylog.Warn().Msg("cleared all log level overrides")
+		} else if ClearOverride(kind, name) {
+			mylog.Warn().Str("kind", kind).Str("name", name).Msg("cleared log level override")
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentLevels())
+}
+
+func currentLevels() levels {
+	overridden := Overrides()
+	mu.RLock()
+	defer mu.RUnlock()
+	l := levels{Level: level.String(), Overrides: overridden}
+	if len(components) > 0 {
+		l.Components = make(map[string]string)
+		for component, cl := range components {
+			l.Components[component] = cl.String()
+		}
+	}
+	return l
+}
@@ -37,19 +37,23 @@ func InitLogger(level string) {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	// set level width if PR https://github.com/rs/zerolog/pull/87 is accepted
 	// zerolog.LevelWidth = 5
-	zerolog.SetGlobalLevel(LogLevels[level])
+	ChangeLogLevel(level)
 }
 
-// ChangeLogLevel allows the changing of the global log level
-func ChangeLogLevel(level string) {
+// ChangeLogLevel allows the changing of the global log level, components given their own level keep it
+func ChangeLogLevel(l string) {
 	// set level width if PR https://github.com/rs/zerolog/pull/87 is accepted
 	// zerolog.LevelWidth = 5
-	zerolog.SetGlobalLevel(LogLevels[level])
+	mu.Lock()
+	defer mu.Unlock()
+	level = LogLevels[l]
+	applyLevels()
 }
 
 func ComponentLogger(component, funcname string) zerolog.Logger {
-	logger := log.Logger.With().Str("component", component).Logger()
-	if zerolog.GlobalLevel() == zerolog.DebugLevel {
+	l := componentLevel(component)
+	logger := log.Logger.Level(l).With().Str("component", component).Logger()
+	if l == zerolog.DebugLevel {
 		logger = logger.With().Str("func", funcname).Logger()
 	}
 	return logger
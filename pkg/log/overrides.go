@@ -0,0 +1,153 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// OverrideComponent and OverrideRule are the kinds of Override, for everything logged by a component or about a rule.
+	OverrideComponent = "component"
+	OverrideRule      = "rule"
+
+	// DefaultOverrideDuration is how long an override lasts when no duration is given, and MaxOverrideDuration is the
+	// longest one can last so that a forgotten override doesn't flood the log.
+	DefaultOverrideDuration = 15 * time.Minute
+	MaxOverrideDuration     = 24 * time.Hour
+)
+
+// Override is a level set at runtime for a component or a rule, until it expires.
+type Override struct {
+	Kind    string    `json:"kind"`
+	Name    string    `json:"name"`
+	Level   string    `json:"level"`
+	Expires time.Time `json:"expires"`
+
+	level zerolog.Level
+	timer *time.Timer
+}
+
+type overrideKey struct {
+	kind, name string
+}
+
+// overrides are guarded by mu, along with the configured levels.
+var overrides = map[overrideKey]*Override{}
+
+// SetOverride sets the level of a component or a rule for a while, replacing any override it already has.  The
+// override is removed, and the level returns to the configured one, once the duration has passed.
+func SetOverride(kind, name, l string, d time.Duration) (Override, error) {
+	if kind != OverrideComponent && kind != OverrideRule {
+		return Override{}, fmt.Errorf("invalid override kind '%s', must be %s or %s", kind, OverrideComponent, OverrideRule)
+	}
+	if name == "" {
+		return Override{}, fmt.Errorf("a %s override needs a name", kind)
+	}
+	zl, ok := LogLevels[l]
+	if !ok {
+		return Override{}, fmt.Errorf("invalid level '%s'", l)
+	}
+	if d <= 0 {
+		d = DefaultOverrideDuration
+	}
+	if d > MaxOverrideDuration {
+		return Override{}, fmt.Errorf("an override can not last longer than %s", MaxOverrideDuration)
+	}
+
+	key := overrideKey{kind: kind, name: name}
+	o := &Override{Kind: kind, Name: name, Level: l, Expires: time.Now().Add(d), level: zl}
+	mu.Lock()
+	defer mu.Unlock()
+	if old, ok := overrides[key]; ok {
+		old.timer.Stop()
+	}
+	o.timer = time.AfterFunc(d, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if overrides[key] == o {
+			delete(overrides, key)
+			applyLevels()
+		}
+	})
+	overrides[key] = o
+	applyLevels()
+	return *o, nil
+}
+
+// ClearOverride removes the override of a component or a rule, it returns false when there wasn't one.
+func ClearOverride(kind, name string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	key := overrideKey{kind: kind, name: name}
+	o, ok := overrides[key]
+	if !ok {
+		return false
+	}
+	o.timer.Stop()
+	delete(overrides, key)
+	applyLevels()
+	return true
+}
+
+// ClearOverrides removes all of the overrides.
+func ClearOverrides() {
+	mu.Lock()
+	defer mu.Unlock()
+	for key, o := range overrides {
+		o.timer.Stop()
+		delete(overrides, key)
+	}
+	applyLevels()
+}
+
+// Overrides lists the current overrides, sorted by kind and name.
+func Overrides() []Override {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]Override, 0, len(overrides))
+	for _, o := range overrides {
+		list = append(list, *o)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind < list[j].Kind
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// overrideLevel returns the level a component or rule is overridden to, it must be called with mu held.
+func overrideLevel(kind, name string) (zerolog.Level, bool) {
+	if o, ok := overrides[overrideKey{kind: kind, name: name}]; ok {
+		return o.level, true
+	}
+	return zerolog.NoLevel, false
+}
+
+// RuleLogger adds the rule to a logger, which logs at the rule's level when it has been overridden.
+func RuleLogger(logger zerolog.Logger, rule string) zerolog.Logger {
+	mu.RLock()
+	l, ok := overrideLevel(OverrideRule, rule)
+	mu.RUnlock()
+	if ok {
+		logger = logger.Level(l)
+	}
+	return logger.With().Str("rule", rule).Logger()
+}
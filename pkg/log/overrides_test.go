@@ -0,0 +1,138 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLog sends the log to a buffer at the info level until the end of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := zlog.Logger
+	zlog.Logger = zerolog.New(&buf)
+	ChangeLogLevel("info")
+	t.Cleanup(func() {
+		ClearOverrides()
+		zlog.Logger = previous
+	})
+	return &buf
+}
+
+func TestComponentOverride(t *testing.T) {
+	buf := captureLog(t)
+	o, err := SetOverride(OverrideComponent, "webhook", "debug", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "debug", o.Level)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), o.Expires, time.Second)
+
+	webhook := ComponentLogger("webhook", "handler")
+	webhook.Debug().Msg("webhook debug")
+	other := ComponentLogger("existing", "check")
+	other.Debug().Msg("existing debug")
+	assert.Contains(t, buf.String(), "webhook debug")
+	assert.NotContains(t, buf.String(), "existing debug", "other components keep their level")
+
+	assert.True(t, ClearOverride(OverrideComponent, "webhook"))
+	assert.False(t, ClearOverride(OverrideComponent, "webhook"))
+	buf.Reset()
+	webhook = ComponentLogger("webhook", "handler")
+	webhook.Debug().Msg("webhook debug")
+	assert.Empty(t, buf.String())
+	assert.Equal(t, zerolog.InfoLevel, zerolog.GlobalLevel(), "the global level is restored")
+}
+
+func TestRuleOverride(t *testing.T) {
+	buf := captureLog(t)
+	_, err := SetOverride(OverrideRule, "label-pods", "debug", time.Minute)
+	require.NoError(t, err)
+
+	graffiti := ComponentLogger("graffiti", "Mutate")
+	overridden := RuleLogger(graffiti, "label-pods")
+	overridden.Debug().Msg("label-pods debug")
+	other := RuleLogger(graffiti, "label-deployments")
+	other.Debug().Msg("label-deployments debug")
+	assert.Contains(t, buf.String(), `"rule":"label-pods"`)
+	assert.NotContains(t, buf.String(), "label-deployments debug")
+}
+
+func TestOverridesExpire(t *testing.T) {
+	captureLog(t)
+	_, err := SetOverride(OverrideComponent, "webhook", "debug", 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Len(t, Overrides(), 1)
+	assert.Eventually(t, func() bool { return len(Overrides()) == 0 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, zerolog.InfoLevel, componentLevel("webhook"))
+	assert.Equal(t, zerolog.InfoLevel, zerolog.GlobalLevel())
+}
+
+func TestSetOverrideErrors(t *testing.T) {
+	captureLog(t)
+	for name, o := range map[string]Override{
+		"kind":     {Kind: "package", Name: "webhook", Level: "debug"},
+		"name":     {Kind: OverrideComponent, Level: "debug"},
+		"level":    {Kind: OverrideComponent, Name: "webhook", Level: "verbose"},
+		"duration": {Kind: OverrideRule, Name: "label-pods", Level: "debug", Expires: time.Now().Add(MaxOverrideDuration + time.Hour)},
+	} {
+		_, err := SetOverride(o.Kind, o.Name, o.Level, time.Until(o.Expires))
+		assert.Error(t, err, name)
+	}
+	assert.Empty(t, Overrides())
+}
+
+func TestLevelsHandler(t *testing.T) {
+	captureLog(t)
+	h := NewLevelsHandler("secret")
+	serve := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set(TokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, LevelsPath, "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodGet, LevelsPath, "guess").Code)
+	disabled := httptest.NewRecorder()
+	NewLevelsHandler("").ServeHTTP(disabled, httptest.NewRequest(http.MethodGet, LevelsPath, nil))
+	assert.Equal(t, http.StatusForbidden, disabled.Code, "nothing can be changed without a token")
+
+	w := serve(http.MethodPut, LevelsPath+"?rule=label-pods&level=debug&duration=5m", "secret")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var got levels
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+	assert.Equal(t, "info", got.Level)
+	require.Len(t, got.Overrides, 1)
+	assert.Equal(t, OverrideRule, got.Overrides[0].Kind)
+	assert.Equal(t, "label-pods", got.Overrides[0].Name)
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, LevelsPath+"?component=webhook&level=loud", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodPut, LevelsPath+"?component=webhook&level=debug&duration=soon", "secret").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPatch, LevelsPath, "secret").Code)
+
+	require.Equal(t, http.StatusOK, serve(http.MethodDelete, LevelsPath+"?rule=label-pods", "secret").Code)
+	assert.Empty(t, Overrides())
+}
@@ -0,0 +1,241 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	// SinkConsole writes human readable lines to stderr, it is used when no sinks are configured.
+	SinkConsole = "console"
+	// SinkJSON writes each entry as a line of json to stderr.
+	SinkJSON = "json"
+	// SinkFile writes each entry as a line of json to a file which is rotated when it gets too big.
+	SinkFile = "file"
+	// SinkSyslog sends each entry as json to the local or a remote syslog, at the entry's severity.
+	SinkSyslog = "syslog"
+	// SinkTCP sends each entry as a line of json over tcp, e.g. to a fluentd or fluent-bit tcp input.
+	SinkTCP = "tcp"
+
+	defaultFileMaxSize = 100
+	defaultSyslogTag   = "kube-graffiti"
+)
+
+// Config models the logging section of our configuration and so has mapstructure tags.  The log-level is the level of
+// all components except those given their own level in Components.
+type Config struct {
+	Sinks      []SinkConfig      `mapstructure:"sinks" yaml:"sinks,omitempty"`
+	Components map[string]string `mapstructure:"components" yaml:"components,omitempty"`
+}
+
+// SinkConfig selects where log entries are written with its Type, and has the settings of each type.
+type SinkConfig struct {
+	Type   string       `mapstructure:"type" yaml:"type"`
+	File   FileConfig   `mapstructure:"file" yaml:"file,omitempty"`
+	Syslog SyslogConfig `mapstructure:"syslog" yaml:"syslog,omitempty"`
+	TCP    TCPConfig    `mapstructure:"tcp" yaml:"tcp,omitempty"`
+}
+
+// FileConfig configures writing to a file which is rotated once it reaches max-size megabytes.
+type FileConfig struct {
+	Path       string `mapstructure:"path" yaml:"path"`
+	MaxSize    int    `mapstructure:"max-size" yaml:"max-size,omitempty"`
+	MaxBackups int    `mapstructure:"max-backups" yaml:"max-backups,omitempty"`
+	MaxAge     int    `mapstructure:"max-age" yaml:"max-age,omitempty"`
+	Compress   bool   `mapstructure:"compress" yaml:"compress,omitempty"`
+}
+
+// SyslogConfig configures sending entries to syslog, the local one when no address is given, otherwise over network
+// ('udp', 'tcp' or 'unix').
+type SyslogConfig struct {
+	Network string `mapstructure:"network" yaml:"network,omitempty"`
+	Address string `mapstructure:"address" yaml:"address,omitempty"`
+	Tag     string `mapstructure:"tag" yaml:"tag,omitempty"`
+}
+
+func (c SyslogConfig) validate() error {
+	switch c.Network {
+	case "":
+		if c.Address != "" {
+			return errors.New("syslog.network is required with a syslog.address")
+		}
+	case "udp", "tcp", "unix":
+		if c.Address == "" {
+			return errors.New("syslog.address is required with a syslog.network")
+		}
+	default:
+		return fmt.Errorf("invalid syslog.network '%s', must be one of udp, tcp or unix", c.Network)
+	}
+	return nil
+}
+
+func (c SyslogConfig) tag() string {
+	if c.Tag == "" {
+		return defaultSyslogTag
+	}
+	return c.Tag
+}
+
+var (
+	mu sync.RWMutex
+	// level is the level of components without their own, and closers are the sinks to close when replaced.
+	level      = zerolog.InfoLevel
+	components = map[string]zerolog.Level{}
+	closers    []io.Closer
+)
+
+// Validate checks that the sinks can be created and that the component levels are valid.
+func (c Config) Validate() error {
+	for i, s := range c.Sinks {
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("invalid logging.sinks[%d]: %v", i, err)
+		}
+	}
+	for component, l := range c.Components {
+		if _, ok := LogLevels[l]; !ok {
+			return fmt.Errorf("invalid logging.components level '%s' for '%s'", l, component)
+		}
+	}
+	return nil
+}
+
+func (s SinkConfig) validate() error {
+	switch s.Type {
+	case SinkConsole, SinkJSON:
+		return nil
+	case SinkFile:
+		if s.File.Path == "" {
+			return errors.New("file.path is required for a file sink")
+		}
+		if s.File.MaxSize < 0 || s.File.MaxBackups < 0 || s.File.MaxAge < 0 {
+			return errors.New("file max-size, max-backups and max-age can not be negative")
+		}
+		return nil
+	case SinkSyslog:
+		return s.Syslog.validate()
+	case SinkTCP:
+		return s.TCP.validate()
+	default:
+		return fmt.Errorf("unknown type '%s'", s.Type)
+	}
+}
+
+func (s SinkConfig) writer() (io.Writer, error) {
+	switch s.Type {
+	case SinkConsole:
+		return zerolog.ConsoleWriter{Out: os.Stderr}, nil
+	case SinkJSON:
+		return os.Stderr, nil
+	case SinkFile:
+		maxSize := s.File.MaxSize
+		if maxSize == 0 {
+			maxSize = defaultFileMaxSize
+		}
+		return &lumberjack.Logger{
+			Filename:   s.File.Path,
+			MaxSize:    maxSize,
+			MaxBackups: s.File.MaxBackups,
+			MaxAge:     s.File.MaxAge,
+			Compress:   s.File.Compress,
+		}, nil
+	case SinkSyslog:
+		return newSyslogWriter(s.Syslog)
+	case SinkTCP:
+		return newTCPWriter(s.TCP), nil
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", s.Type)
+	}
+}
+
+// Configure sends the log to the configured sinks, replacing and closing any configured before, and sets the levels
+// of the components.  Without any sinks the log is written to the console, as it is by InitLogger.
+func Configure(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	var writers []io.Writer
+	var opened []io.Closer
+	for i, s := range c.Sinks {
+		w, err := s.writer()
+		if err != nil {
+			closeAll(opened)
+			return fmt.Errorf("failed to create logging.sinks[%d]: %v", i, err)
+		}
+		writers = append(writers, w)
+		if closer, ok := w.(io.Closer); ok && s.Type != SinkJSON {
+			opened = append(opened, closer)
+		}
+	}
+	if len(writers) == 0 {
+		writers = append(writers, zerolog.ConsoleWriter{Out: os.Stderr})
+	}
+	levels := make(map[string]zerolog.Level)
+	for component, l := range c.Components {
+		levels[component] = LogLevels[l]
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	log.Logger = zerolog.New(zerolog.MultiLevelWriter(writers...)).With().Timestamp().Logger()
+	closeAll(closers)
+	closers = opened
+	components = levels
+	applyLevels()
+	return nil
+}
+
+func closeAll(cs []io.Closer) {
+	for _, c := range cs {
+		c.Close()
+	}
+}
+
+// applyLevels lowers the global level far enough for the most verbose component, the components' loggers then filter
+// at their own level.  It must be called with mu held.
+func applyLevels() {
+	global := level
+	for _, l := range components {
+		if l < global {
+			global = l
+		}
+	}
+	for _, o := range overrides {
+		if o.level < global {
+			global = o.level
+		}
+	}
+	zerolog.SetGlobalLevel(global)
+}
+
+// componentLevel returns the level of a component, its override, its own or the log-level.
+func componentLevel(component string) zerolog.Level {
+	mu.RLock()
+	defer mu.RUnlock()
+	if l, ok := overrideLevel(OverrideComponent, component); ok {
+		return l
+	}
+	if l, ok := components[component]; ok {
+		return l
+	}
+	return level
+}
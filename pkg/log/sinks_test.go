@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetLogging(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, Configure(Config{}))
+		ChangeLogLevel("info")
+	})
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{
+		Sinks: []SinkConfig{
+			{Type: SinkConsole},
+			{Type: SinkFile, File: FileConfig{Path: "/var/log/kube-graffiti.log"}},
+			{Type: SinkSyslog, Syslog: SyslogConfig{Network: "udp", Address: "syslog:514"}},
+			{Type: SinkTCP, TCP: TCPConfig{Address: "fluentd:5170"}},
+		},
+		Components: map[string]string{"webhook": "debug"},
+	}
+	assert.NoError(t, valid.Validate())
+
+	for name, c := range map[string]Config{
+		"unknown sink":        {Sinks: []SinkConfig{{Type: "kafka"}}},
+		"file without path":   {Sinks: []SinkConfig{{Type: SinkFile}}},
+		"negative max-size":   {Sinks: []SinkConfig{{Type: SinkFile, File: FileConfig{Path: "x.log", MaxSize: -1}}}},
+		"syslog network":      {Sinks: []SinkConfig{{Type: SinkSyslog, Syslog: SyslogConfig{Network: "http", Address: "x:514"}}}},
+		"syslog address":      {Sinks: []SinkConfig{{Type: SinkSyslog, Syslog: SyslogConfig{Network: "udp"}}}},
+		"tcp without address": {Sinks: []SinkConfig{{Type: SinkTCP}}},
+		"tcp without port":    {Sinks: []SinkConfig{{Type: SinkTCP, TCP: TCPConfig{Address: "fluentd"}}}},
+		"component level":     {Components: map[string]string{"webhook": "loud"}},
+	} {
+		assert.Error(t, c.Validate(), name)
+	}
+}
+
+func TestFileSinkAndComponentLevels(t *testing.T) {
+	resetLogging(t)
+	dir, err := ioutil.TempDir("", "log")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "graffiti.log")
+
+	require.NoError(t, Configure(Config{
+		Sinks:      []SinkConfig{{Type: SinkFile, File: FileConfig{Path: file}}},
+		Components: map[string]string{"webhook": "debug", "existing": "error"},
+	}))
+	ChangeLogLevel("info")
+
+	webhook := ComponentLogger("webhook", "handler")
+	webhook.Debug().Msg("webhook debug")
+	existing := ComponentLogger("existing", "check")
+	existing.Warn().Msg("existing warn")
+	existing.Error().Msg("existing error")
+	config := ComponentLogger("config", "load")
+	config.Debug().Msg("config debug")
+	config.Info().Msg("config info")
+
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry), "each entry is a line of json")
+		messages = append(messages, entry["message"].(string))
+	}
+	assert.Equal(t, []string{"webhook debug", "existing error", "config info"}, messages)
+}
+
+func TestTCPSink(t *testing.T) {
+	resetLogging(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		lines <- line
+	}()
+
+	require.NoError(t, Configure(Config{Sinks: []SinkConfig{{Type: SinkTCP, TCP: TCPConfig{Address: l.Addr().String()}}}}))
+	mylog := ComponentLogger("webhook", "handler")
+	mylog.Info().Msg("over tcp")
+	select {
+	case line := <-lines:
+		assert.Contains(t, line, `"message":"over tcp"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the entry wasn't received")
+	}
+}
+
+func TestTCPWriterDropsEntriesWhenUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := l.Addr().String()
+	l.Close()
+
+	w := newTCPWriter(TCPConfig{Address: address, Timeout: time.Second})
+	n, err := w.Write([]byte("{}\n"))
+	assert.NoError(t, err, "a missing collector doesn't stop the other sinks")
+	assert.Equal(t, 3, n)
+	assert.NoError(t, w.Close())
+}
@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"io"
+	"log/syslog"
+
+	"github.com/rs/zerolog"
+)
+
+func newSyslogWriter(c SyslogConfig) (io.Writer, error) {
+	w, err := syslog.Dial(c.Network, c.Address, syslog.LOG_DAEMON|syslog.LOG_INFO, c.tag())
+	if err != nil {
+		return nil, err
+	}
+	return syslogLevelWriter{LevelWriter: zerolog.SyslogLevelWriter(w), Closer: w}, nil
+}
+
+// syslogLevelWriter keeps the syslog connection's Close, which zerolog's writer hides.
+type syslogLevelWriter struct {
+	zerolog.LevelWriter
+	io.Closer
+}
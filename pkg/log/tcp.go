@@ -0,0 +1,92 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package log
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const defaultTCPTimeout = 5 * time.Second
+
+// TCPConfig configures sending each entry as a line of json to address.  The connection is made again after it fails,
+// and entries are dropped while it can't be, so that a missing collector never blocks kube-graffiti.
+type TCPConfig struct {
+	Address string        `mapstructure:"address" yaml:"address"`
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout,omitempty"`
+}
+
+func (c TCPConfig) validate() error {
+	if c.Address == "" {
+		return errors.New("tcp.address is required for a tcp sink")
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return errors.New("tcp.address must be a host:port")
+	}
+	if c.Timeout < 0 {
+		return errors.New("tcp.timeout can not be negative")
+	}
+	return nil
+}
+
+type tcpWriter struct {
+	sync.Mutex
+	address string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func newTCPWriter(c TCPConfig) *tcpWriter {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultTCPTimeout
+	}
+	return &tcpWriter{address: c.Address, timeout: timeout}
+}
+
+// Write never returns an error, as zerolog stops writing to the rest of the sinks after one fails.
+func (w *tcpWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	// a connection that has failed is only noticed by a write, so the entry is tried once more on a new one
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			conn, err := net.DialTimeout("tcp", w.address, w.timeout)
+			if err != nil {
+				return len(p), nil
+			}
+			w.conn = conn
+		}
+		w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+		if _, err := w.conn.Write(p); err == nil {
+			return len(p), nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return len(p), nil
+}
+
+func (w *tcpWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics holds the prometheus metrics that kube-graffiti exposes on the health-checker server.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	componentName = "metrics"
+	namespace     = "kube_graffiti"
+	// Path is where the metrics are served on the health-checker server.
+	Path = "/metrics"
+
+	// ReasonNamespaceTerminating is used when an object is skipped because its namespace is being deleted.
+	ReasonNamespaceTerminating = "namespace-terminating"
+	// ReasonIgnored is used when an object is skipped because its namespace or name is in the ignore list.
+	ReasonIgnored = "ignored"
+	// ReasonApplyConflict is used when an object is skipped because another field manager owns the labels or
+	// annotations that a rule would apply.
+	ReasonApplyConflict = "apply-conflict"
+	// ReasonStopOnMatch is used when an object is skipped because a stop-on-match rule before the rule matched it.
+	ReasonStopOnMatch = "stop-on-match"
+	// ReasonMaxPatches is used when an object is skipped because the most objects that can be patched have been.
+	ReasonMaxPatches = "max-patches"
+	// ReasonPatchBuild is used when a rule fails because its payload could not be made into a patch for the object.
+	ReasonPatchBuild = "patch-build"
+	// ReasonInternalError is used when a rule fails for any other reason.
+	ReasonInternalError = "internal-error"
+
+	// ResultSucceeded, ResultRetried and ResultDeadLettered are the results of attempting a queued action.
+	ResultSucceeded    = "succeeded"
+	ResultRetried      = "retried"
+	ResultDeadLettered = "dead-lettered"
+
+	// ResultHit and ResultMiss are whether a rule's decision was found in the decision cache.
+	ResultHit  = "hit"
+	ResultMiss = "miss"
+
+	// ResultCompleted and ResultStopped are whether a sweep of existing objects checked all of the rules or was
+	// stopped part way through.
+	ResultCompleted = "completed"
+	ResultStopped   = "stopped"
+)
+
+var (
+	// SkippedObjects counts the objects that a rule matched but that we deliberately did not write to.
+	SkippedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "skipped_objects_total",
+		Help:      "The number of objects that were not written to, by rule and reason.",
+	}, []string{"rule", "reason"})
+	// Actions counts the attempts at queued payload actions, by kind and result.
+	Actions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_total",
+		Help:      "The number of attempts at queued payload actions, by kind and result.",
+	}, []string{"kind", "result"})
+	// ExpiredObjects counts the expired objects that were deleted or labelled, by rule and action.
+	ExpiredObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "expired_objects_total",
+		Help:      "The number of expired objects that were deleted or labelled, by rule and action.",
+	}, []string{"rule", "action"})
+	// LastKnownGoodConfig is 1 when the configuration was broken and the last-known-good configuration is being used.
+	LastKnownGoodConfig = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_known_good_config",
+		Help:      "Whether the configuration was broken and the last-known-good configuration is being used (1) or not (0).",
+	})
+	// ExistingObjectsChecked counts the existing objects that each rule has been checked against.
+	ExistingObjectsChecked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "existing_objects_checked_total",
+		Help:      "The number of existing objects that were checked against a rule, by rule.",
+	}, []string{"rule"})
+	// ExistingObjectsListed counts the existing objects that were listed for each rule, by rule and resource.
+	ExistingObjectsListed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "existing_objects_listed_total",
+		Help:      "The number of existing objects that were listed for a rule, by rule and resource.",
+	}, []string{"rule", "resource"})
+	// ExistingObjectsMatched counts the existing objects that a rule would change.
+	ExistingObjectsMatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "existing_objects_matched_total",
+		Help:      "The number of existing objects that a rule matched and would change, by rule.",
+	}, []string{"rule"})
+	// ExistingObjectsPatched counts the existing objects that a rule changed.
+	ExistingObjectsPatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "existing_objects_patched_total",
+		Help:      "The number of existing objects that a rule changed, by rule.",
+	}, []string{"rule"})
+	// ExistingObjectsFailed counts the existing objects that a rule failed to check or change.
+	ExistingObjectsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "existing_objects_failed_total",
+		Help:      "The number of existing objects that a rule failed to check or change, by rule.",
+	}, []string{"rule"})
+	// ExistingListErrors counts the failed list calls of the checks of existing objects, which leave the rest of the
+	// resource's objects unchecked.
+	ExistingListErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "existing_list_errors_total",
+		Help:      "The number of list calls that failed while checking existing objects, by rule and resource.",
+	}, []string{"rule", "resource"})
+	// ExistingSweeps counts the sweeps of the rules over existing objects, by whether they completed or were stopped.
+	ExistingSweeps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "existing_sweeps_total",
+		Help:      "The number of sweeps of the rules over existing objects, by result (completed or stopped).",
+	}, []string{"result"})
+	// ExistingSweepDuration is how long the last completed sweep of existing objects took, including any pauses.
+	ExistingSweepDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "existing_sweep_duration_seconds",
+		Help:      "How long the last completed sweep of the rules over existing objects took, in seconds.",
+	})
+	// ExistingSweepLastCompleted is when the last sweep of existing objects completed, as a unix timestamp.
+	ExistingSweepLastCompleted = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "existing_sweep_last_completed_timestamp_seconds",
+		Help:      "When the last sweep of the rules over existing objects completed, as a unix timestamp.",
+	})
+	// ExistingCheckPaused is 1 while the check of existing objects waits for a maintenance window to open.
+	ExistingCheckPaused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "existing_check_paused",
+		Help:      "Whether the check of existing objects is paused until a maintenance window opens (1) or not (0).",
+	})
+	// QueuedActions is the number of payload actions waiting to be done.
+	QueuedActions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queued_actions",
+		Help:      "The number of payload actions waiting to be done.",
+	})
+	// CachedDecisions counts the lookups of rules' decisions in the decision cache, by rule and result.
+	CachedDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cached_decisions_total",
+		Help:      "The number of lookups of rules' decisions in the decision cache, by rule and result (hit or miss).",
+	}, []string{"rule", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(SkippedObjects, Actions, ExpiredObjects, LastKnownGoodConfig, ExistingCheckPaused, ExistingObjectsChecked, QueuedActions, CachedDecisions)
+	prometheus.MustRegister(ExistingObjectsListed, ExistingObjectsMatched, ExistingObjectsPatched, ExistingObjectsFailed, ExistingListErrors, ExistingSweeps, ExistingSweepDuration, ExistingSweepLastCompleted)
+}
+
+// Handler serves the metrics in the prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
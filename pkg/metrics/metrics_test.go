@@ -0,0 +1,34 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerServesSkippedObjects(t *testing.T) {
+	SkippedObjects.WithLabelValues("test-rule", ReasonNamespaceTerminating).Inc()
+
+	req, err := http.NewRequest("GET", Path, nil)
+	assert.NoError(t, err)
+	rr := httptest.NewRecorder()
+	Handler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `kube_graffiti_skipped_objects_total{reason="namespace-terminating",rule="test-rule"} 1`)
+}
@@ -0,0 +1,237 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RulesStatusPath is where the status of the loaded rules is served on the health-checker server.
+const RulesStatusPath = "/rules/status"
+
+var (
+	// RuleRequests counts the admission requests that each rule has been asked to review.
+	RuleRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_requests_total",
+		Help:      "The number of admission requests reviewed, by rule.",
+	}, []string{"rule"})
+	// RuleHits counts the admission requests where a rule matched and patched or blocked the object.
+	RuleHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_hits_total",
+		Help:      "The number of admission requests where a rule patched or blocked the object, by rule.",
+	}, []string{"rule"})
+	// RuleErrors counts the admission requests where a rule failed, by rule and reason.
+	RuleErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rule_errors_total",
+		Help:      "The number of admission requests where a rule failed, by rule and reason.",
+	}, []string{"rule", "reason"})
+
+	// RuleActive is 1 for each rule which was loaded and 0 for rules which failed validation or are outside of their
+	// schedules.
+	RuleActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rule_active",
+		Help:      "Whether each rule was loaded (1) or is invalid or outside of its schedule (0), by rule.",
+	}, []string{"rule"})
+
+	// Rules tracks the status of every loaded rule.
+	Rules = NewRuleTracker()
+)
+
+func init() {
+	prometheus.MustRegister(RuleRequests, RuleHits, RuleErrors, RuleActive)
+}
+
+// RuleStatus is what we know about a single rule, it helps to answer "why didn't my rule fire?"
+type RuleStatus struct {
+	Name              string     `json:"name"`
+	Type              string     `json:"type,omitempty"`
+	Active            bool       `json:"active"`
+	ActivationError   string     `json:"activationError,omitempty"`
+	Registered        bool       `json:"registered"`
+	RegistrationError string     `json:"registrationError,omitempty"`
+	Requests          int64      `json:"requests"`
+	LastRequest       *time.Time `json:"lastRequest,omitempty"`
+	Hits              int64      `json:"hits"`
+	LastMatch         *time.Time `json:"lastMatch,omitempty"`
+	MatchCount24h     int64      `json:"matchCount24h"`
+	Errors            []string   `json:"errors,omitempty"`
+	// Replicas is how many replicas the status is summed over, when it is shared between them.
+	Replicas int `json:"replicas,omitempty"`
+}
+
+// maxRuleErrors is how many of a rule's most recent errors are kept in its status.
+const maxRuleErrors = 5
+
+// ruleState is a RuleStatus along with the hourly match counts that its MatchCount24h is summed from.
+type ruleState struct {
+	RuleStatus
+	hours  [24]int64
+	counts [24]int64
+}
+
+// RuleTracker records the status of each rule and serves them as json, along with the status shared by the other
+// replicas.
+type RuleTracker struct {
+	sync.Mutex
+	rules map[string]*ruleState
+	peers map[string]replicaStatus
+	now   func() time.Time
+}
+
+// NewRuleTracker creates an empty RuleTracker.
+func NewRuleTracker() *RuleTracker {
+	return &RuleTracker{rules: make(map[string]*ruleState), now: time.Now}
+}
+
+// Add starts tracking a rule.
+func (t *RuleTracker) Add(name, ruleType string) {
+	t.Lock()
+	defer t.Unlock()
+	t.status(name).Type = ruleType
+}
+
+// Remove stops tracking a rule that is no longer loaded.
+func (t *RuleTracker) Remove(name string) {
+	RuleActive.DeleteLabelValues(name)
+
+	t.Lock()
+	defer t.Unlock()
+	delete(t.rules, name)
+}
+
+// SetActive records whether a rule was loaded or, if it was invalid, why not.
+func (t *RuleTracker) SetActive(name string, err error) {
+	active := 0.0
+	if err == nil {
+		active = 1
+	}
+	RuleActive.WithLabelValues(name).Set(active)
+
+	t.Lock()
+	defer t.Unlock()
+	s := t.status(name)
+	s.Active = err == nil
+	s.ActivationError = ""
+	if err != nil {
+		s.ActivationError = err.Error()
+	}
+}
+
+// SetRegistered records the result of registering a rule with the apiserver.
+func (t *RuleTracker) SetRegistered(name string, err error) {
+	t.Lock()
+	defer t.Unlock()
+	s := t.status(name)
+	s.Registered = err == nil
+	s.RegistrationError = ""
+	if err != nil {
+		s.RegistrationError = err.Error()
+	}
+}
+
+// Reviewed records that a rule reviewed an admission request and whether it matched (patched or blocked) the object.
+func (t *RuleTracker) Reviewed(name string, matched bool) {
+	RuleRequests.WithLabelValues(name).Inc()
+	if matched {
+		RuleHits.WithLabelValues(name).Inc()
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	now := t.now().UTC()
+	s := t.status(name)
+	s.Requests++
+	s.LastRequest = &now
+	if matched {
+		s.Hits++
+		s.LastMatch = &now
+		hour := now.Unix() / 3600
+		if s.hours[hour%24] != hour {
+			s.hours[hour%24] = hour
+			s.counts[hour%24] = 0
+		}
+		s.counts[hour%24]++
+	}
+}
+
+// Failed records an error that a rule hit while reviewing an admission request, only the most recent errors are kept.
+// The reason is one of the Reason constants and is only counted.
+func (t *RuleTracker) Failed(name, reason, message string) {
+	RuleErrors.WithLabelValues(name, reason).Inc()
+
+	t.Lock()
+	defer t.Unlock()
+	s := t.status(name)
+	s.Errors = append(s.Errors, message)
+	if len(s.Errors) > maxRuleErrors {
+		s.Errors = s.Errors[len(s.Errors)-maxRuleErrors:]
+	}
+}
+
+// Statuses returns a copy of the status of every rule, sorted by name.
+func (t *RuleTracker) Statuses() []RuleStatus {
+	t.Lock()
+	defer t.Unlock()
+	result := make([]RuleStatus, 0, len(t.rules))
+	since := t.now().Unix()/3600 - 23
+	for _, s := range t.rules {
+		status := s.RuleStatus
+		status.Errors = append([]string(nil), s.Errors...)
+		status.MatchCount24h = 0
+		for i, hour := range s.hours {
+			if hour >= since {
+				status.MatchCount24h += s.counts[i]
+			}
+		}
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// ServeHTTP serves the status of every rule as json, summed over the replicas that share their status.
+func (t *RuleTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := json.Marshal(t.AggregatedStatuses())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// status must be called with the lock held
+func (t *RuleTracker) status(name string) *ruleState {
+	s, ok := t.rules[name]
+	if !ok {
+		s = &ruleState{RuleStatus: RuleStatus{Name: name}}
+		t.rules[name] = s
+	}
+	return s
+}
@@ -0,0 +1,103 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleTrackerServesRuleStatus(t *testing.T) {
+	tracker := NewRuleTracker()
+	tracker.Add("label-pods", "mutating")
+	tracker.Add("deny-pods", "validating")
+	tracker.SetRegistered("label-pods", nil)
+	tracker.SetRegistered("deny-pods", errors.New("webhook registration failed"))
+	tracker.Reviewed("label-pods", true)
+	tracker.Reviewed("label-pods", false)
+
+	req, err := http.NewRequest("GET", RulesStatusPath, nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	tracker.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var statuses []RuleStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 2)
+
+	assert.Equal(t, "deny-pods", statuses[0].Name)
+	assert.False(t, statuses[0].Registered)
+	assert.Equal(t, "webhook registration failed", statuses[0].RegistrationError)
+	assert.Nil(t, statuses[0].LastMatch, "a rule that never matched has no last match time")
+
+	assert.Equal(t, "label-pods", statuses[1].Name)
+	assert.True(t, statuses[1].Registered)
+	assert.Equal(t, int64(2), statuses[1].Requests)
+	assert.Equal(t, int64(1), statuses[1].Hits)
+	assert.NotNil(t, statuses[1].LastMatch)
+}
+
+func TestRuleTrackerCountsMatchesInTheLastDay(t *testing.T) {
+	tracker := NewRuleTracker()
+	now := time.Date(2018, 10, 2, 10, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	tracker.Reviewed("label-pods", true)
+	now = now.Add(5 * time.Hour)
+	tracker.Reviewed("label-pods", true)
+	tracker.Reviewed("label-pods", true)
+	tracker.Reviewed("label-pods", false)
+	assert.Equal(t, int64(3), tracker.Statuses()[0].MatchCount24h)
+
+	now = now.Add(20 * time.Hour)
+	assert.Equal(t, int64(2), tracker.Statuses()[0].MatchCount24h, "matches older than a day should not be counted")
+
+	now = now.Add(24 * time.Hour)
+	tracker.Reviewed("label-pods", true)
+	status := tracker.Statuses()[0]
+	assert.Equal(t, int64(1), status.MatchCount24h)
+	assert.Equal(t, int64(4), status.Hits, "hits are counted since start up")
+}
+
+func TestRuleTrackerKeepsTheMostRecentErrors(t *testing.T) {
+	tracker := NewRuleTracker()
+	for i := 0; i < maxRuleErrors+2; i++ {
+		tracker.Failed("label-pods", ReasonInternalError, fmt.Sprintf("error %d", i))
+	}
+	errs := tracker.Statuses()[0].Errors
+	require.Len(t, errs, maxRuleErrors)
+	assert.Equal(t, "error 2", errs[0])
+	assert.Equal(t, fmt.Sprintf("error %d", maxRuleErrors+1), errs[maxRuleErrors-1])
+}
+
+func TestRuleTrackerRecordsActivation(t *testing.T) {
+	tracker := NewRuleTracker()
+	tracker.SetActive("label-pods", nil)
+	tracker.SetActive("broken", errors.New("matcher contains an invalid label selector"))
+
+	statuses := tracker.Statuses()
+	assert.False(t, statuses[0].Active)
+	assert.Equal(t, "matcher contains an invalid label selector", statuses[0].ActivationError)
+	assert.True(t, statuses[1].Active)
+	assert.Empty(t, statuses[1].ActivationError)
+}
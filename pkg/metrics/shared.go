@@ -0,0 +1,223 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// DefaultRuleStatusInterval is how often each replica shares the status of its rules when sharing is enabled.
+const DefaultRuleStatusInterval = 30 * time.Second
+
+// staleIntervals is how many intervals a replica can go without sharing its status before it is left out, as it has
+// most likely gone away.
+const staleIntervals = 3
+
+// RuleStatusSharing shares the status of the rules between the replicas through a ConfigMap in our namespace, so that
+// the status served by any replica covers the admission requests that every replica reviewed.
+type RuleStatusSharing struct {
+	// ConfigMap is the name of the ConfigMap that each replica writes its status into, sharing is disabled without one.
+	ConfigMap string        `mapstructure:"configmap" yaml:"configmap,omitempty"`
+	Interval  time.Duration `mapstructure:"interval" yaml:"interval,omitempty"`
+}
+
+// Enabled is true when the status of the rules is shared between replicas.
+func (s RuleStatusSharing) Enabled() bool {
+	return s.ConfigMap != ""
+}
+
+// Validate checks the sharing of the rules' status.
+func (s RuleStatusSharing) Validate() error {
+	if s.Interval < 0 {
+		return fmt.Errorf("server.rule-status.interval can not be negative")
+	}
+	return nil
+}
+
+func (s RuleStatusSharing) withDefaults() RuleStatusSharing {
+	if s.Interval == 0 {
+		s.Interval = DefaultRuleStatusInterval
+	}
+	return s
+}
+
+// replicaStatus is the status of the rules of one replica, as it is shared in the ConfigMap under the replica's name.
+type replicaStatus struct {
+	Updated time.Time    `json:"updated"`
+	Rules   []sharedRule `json:"rules"`
+}
+
+// sharedRule is a rule's status along with the hourly match counts, so that its MatchCount24h can be summed with
+// those of the other replicas.
+type sharedRule struct {
+	RuleStatus
+	Hours  [24]int64 `json:"hours"`
+	Counts [24]int64 `json:"counts"`
+}
+
+// Share writes the status of our rules into the ConfigMap every interval, under the name of our replica, and reads
+// the status of the other replicas back, until stop is closed.
+func (t *RuleTracker) Share(k kubernetes.Interface, namespace, replica string, s RuleStatusSharing, stop <-chan struct{}) {
+	mylog := log.ComponentLogger(componentName, "RuleTracker.Share")
+	s = s.withDefaults()
+	configMaps := k.CoreV1().ConfigMaps(namespace)
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if err := t.share(configMaps, replica, s); err != nil {
+			mylog.Error().Err(err).Str("configmap", s.ConfigMap).Msg("failed to share the status of the rules")
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// share saves our status into the ConfigMap, dropping the replicas that have stopped sharing theirs, and keeps the
+// status of the others.  Updates are made against the ConfigMap's resourceVersion and retried on conflicts.
+func (t *RuleTracker) share(configMaps typedcorev1.ConfigMapInterface, replica string, s RuleStatusSharing) error {
+	now := t.now().UTC()
+	data, err := json.Marshal(replicaStatus{Updated: now, Rules: t.snapshot()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the status of the rules: %v", err)
+	}
+
+	var peers map[string]replicaStatus
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(s.ConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			peers = nil
+			cm = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: s.ConfigMap}, Data: map[string]string{replica: string(data)}}
+			_, err = configMaps.Create(cm)
+			if apierrors.IsAlreadyExists(err) {
+				// another replica created it first, so update it instead
+				return apierrors.NewConflict(corev1.Resource("configmaps"), s.ConfigMap, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		peers = make(map[string]replicaStatus)
+		current := map[string]string{replica: string(data)}
+		for name, value := range cm.Data {
+			var status replicaStatus
+			if name == replica || json.Unmarshal([]byte(value), &status) != nil || now.Sub(status.Updated) > staleIntervals*s.Interval {
+				continue
+			}
+			peers[name] = status
+			current[name] = value
+		}
+		cm.Data = current
+		_, err = configMaps.Update(cm)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save the status of the rules to configmap %s: %v", s.ConfigMap, err)
+	}
+
+	t.Lock()
+	t.peers = peers
+	t.Unlock()
+	return nil
+}
+
+// snapshot returns the status of each of our rules with its hourly match counts.
+func (t *RuleTracker) snapshot() []sharedRule {
+	t.Lock()
+	defer t.Unlock()
+	rules := make([]sharedRule, 0, len(t.rules))
+	for _, s := range t.rules {
+		status := s.RuleStatus
+		status.Errors = append([]string(nil), s.Errors...)
+		rules = append(rules, sharedRule{RuleStatus: status, Hours: s.hours, Counts: s.counts})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
+
+// AggregatedStatuses returns the status of every rule, summed over our replica and the others that share theirs.
+// Requests, hits and matches are summed, the last request and match are the latest of any replica, and the errors of
+// the other replicas are prefixed with their names.  Whether a rule is active and registered is our own view.
+func (t *RuleTracker) AggregatedStatuses() []RuleStatus {
+	statuses := t.Statuses()
+	t.Lock()
+	peers := t.peers
+	t.Unlock()
+	if len(peers) == 0 {
+		return statuses
+	}
+
+	byName := make(map[string]*RuleStatus, len(statuses))
+	for i := range statuses {
+		statuses[i].Replicas = 1
+		byName[statuses[i].Name] = &statuses[i]
+	}
+	var added []*RuleStatus
+	names := make([]string, 0, len(peers))
+	for name := range peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	since := t.now().Unix()/3600 - 23
+	for _, replica := range names {
+		for _, rule := range peers[replica].Rules {
+			status, ok := byName[rule.Name]
+			if !ok {
+				// a rule that only the other replicas serve, e.g. while the configuration is rolled out
+				status = &RuleStatus{Name: rule.Name, Type: rule.Type, Active: rule.Active, ActivationError: rule.ActivationError, Registered: rule.Registered, RegistrationError: rule.RegistrationError}
+				added = append(added, status)
+				byName[rule.Name] = status
+			}
+			status.Replicas++
+			status.Requests += rule.Requests
+			status.Hits += rule.Hits
+			status.LastRequest = latest(status.LastRequest, rule.LastRequest)
+			status.LastMatch = latest(status.LastMatch, rule.LastMatch)
+			for i, hour := range rule.Hours {
+				if hour >= since {
+					status.MatchCount24h += rule.Counts[i]
+				}
+			}
+			for _, e := range rule.Errors {
+				status.Errors = append(status.Errors, replica+": "+e)
+			}
+		}
+	}
+	for _, status := range added {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
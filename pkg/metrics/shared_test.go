@@ -0,0 +1,99 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRuleStatusIsSummedOverTheReplicas(t *testing.T) {
+	now := time.Now()
+	sharing := RuleStatusSharing{ConfigMap: "kube-graffiti-rule-status", Interval: time.Minute}
+	configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("kube-graffiti")
+	replica := func() *RuleTracker {
+		tracker := NewRuleTracker()
+		tracker.now = func() time.Time { return now }
+		tracker.Add("label-pods", "mutating")
+		tracker.SetActive("label-pods", nil)
+		tracker.SetRegistered("label-pods", nil)
+		return tracker
+	}
+
+	first, second := replica(), replica()
+	first.Reviewed("label-pods", true)
+	second.Reviewed("label-pods", true)
+	second.Reviewed("label-pods", false)
+	second.Failed("label-pods", ReasonPatchBuild, "can't render template")
+	second.Add("deny-pods", "validating")
+	require.NoError(t, second.share(configMaps, "kube-graffiti-1", sharing))
+	require.NoError(t, first.share(configMaps, "kube-graffiti-0", sharing))
+
+	cm, err := configMaps.Get(sharing.ConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, cm.Data, 2, "each replica shares its status under its own name")
+
+	req, err := http.NewRequest("GET", RulesStatusPath, nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	first.ServeHTTP(rr, req)
+	var statuses []RuleStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 2, "rules that only another replica serves are included")
+	assert.Equal(t, "deny-pods", statuses[0].Name)
+	label := statuses[1]
+	assert.Equal(t, 2, label.Replicas)
+	assert.Equal(t, int64(3), label.Requests)
+	assert.Equal(t, int64(2), label.Hits)
+	assert.Equal(t, int64(2), label.MatchCount24h)
+	assert.Equal(t, []string{"kube-graffiti-1: can't render template"}, label.Errors)
+	assert.Len(t, first.Statuses(), 1, "our own view, e.g. for readiness, isn't summed")
+}
+
+func TestReplicasThatStopSharingAreLeftOut(t *testing.T) {
+	now := time.Now()
+	sharing := RuleStatusSharing{ConfigMap: "kube-graffiti-rule-status", Interval: time.Minute}
+	configMaps := fake.NewSimpleClientset().CoreV1().ConfigMaps("kube-graffiti")
+	gone := NewRuleTracker()
+	gone.now = func() time.Time { return now.Add(-time.Hour) }
+	gone.Reviewed("label-pods", true)
+	require.NoError(t, gone.share(configMaps, "kube-graffiti-old", sharing))
+
+	tracker := NewRuleTracker()
+	tracker.now = func() time.Time { return now }
+	tracker.SetRegistered("label-pods", errors.New("not registered"))
+	require.NoError(t, tracker.share(configMaps, "kube-graffiti-0", sharing))
+	statuses := tracker.AggregatedStatuses()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, int64(0), statuses[0].Requests)
+
+	cm, err := configMaps.Get(sharing.ConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, cm.Data, "kube-graffiti-old", "the stale replica's status is removed")
+}
+
+func TestRuleStatusSharingValidation(t *testing.T) {
+	assert.NoError(t, RuleStatusSharing{}.Validate())
+	assert.False(t, RuleStatusSharing{}.Enabled())
+	assert.EqualError(t, RuleStatusSharing{ConfigMap: "status", Interval: -time.Second}.Validate(), "server.rule-status.interval can not be negative")
+}
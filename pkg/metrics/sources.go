@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// SourcesStatusPath is where the admission requests made by each source are served on the health-checker server.
+	SourcesStatusPath = "/sources/status"
+	// maxSources bounds the number of sources that are tracked, the least recently seen are forgotten first.
+	maxSources = 1000
+)
+
+var (
+	// LimitedRequests counts the admission requests from sources that exceeded their request rate, by the action taken.
+	// Sources are deliberately not a label, as anyone who can reach the webhook port could create them.
+	LimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limited_requests_total",
+		Help:      "The number of admission requests from sources that exceeded their request rate, by action.",
+	}, []string{"action"})
+
+	// Sources tracks the admission requests made by each source.
+	Sources = NewSourceTracker()
+)
+
+func init() {
+	prometheus.MustRegister(LimitedRequests)
+}
+
+// SourceStatus is what we know about the admission requests from a single source.
+type SourceStatus struct {
+	Source      string     `json:"source"`
+	Requests    int64      `json:"requests"`
+	Limited     int64      `json:"limited"`
+	LastRequest time.Time  `json:"lastRequest"`
+	LastLimited *time.Time `json:"lastLimited,omitempty"`
+}
+
+// SourceTracker records the admission requests made by each source and serves them as json.
+type SourceTracker struct {
+	sync.Mutex
+	sources map[string]*SourceStatus
+	now     func() time.Time
+}
+
+// NewSourceTracker creates an empty SourceTracker.
+func NewSourceTracker() *SourceTracker {
+	return &SourceTracker{sources: make(map[string]*SourceStatus), now: time.Now}
+}
+
+// Record records a request from a source, and the action taken if it exceeded its request rate.
+func (t *SourceTracker) Record(source string, limited bool, action string) {
+	if limited {
+		LimitedRequests.WithLabelValues(action).Inc()
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	now := t.now().UTC()
+	s, ok := t.sources[source]
+	if !ok {
+		t.forgetOldest()
+		s = &SourceStatus{Source: source}
+		t.sources[source] = s
+	}
+	s.Requests++
+	s.LastRequest = now
+	if limited {
+		s.Limited++
+		s.LastLimited = &now
+	}
+}
+
+// Statuses returns a copy of the status of every source, busiest first.
+func (t *SourceTracker) Statuses() []SourceStatus {
+	t.Lock()
+	defer t.Unlock()
+	result := make([]SourceStatus, 0, len(t.sources))
+	for _, s := range t.sources {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Requests != result[j].Requests {
+			return result[i].Requests > result[j].Requests
+		}
+		return result[i].Source < result[j].Source
+	})
+	return result
+}
+
+// ServeHTTP serves the status of every source as json.
+func (t *SourceTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := json.Marshal(t.Statuses())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// forgetOldest makes room for a new source, it must be called with the lock held
+func (t *SourceTracker) forgetOldest() {
+	if len(t.sources) < maxSources {
+		return
+	}
+	var oldest *SourceStatus
+	for _, s := range t.sources {
+		if oldest == nil || s.LastRequest.Before(oldest.LastRequest) {
+			oldest = s
+		}
+	}
+	delete(t.sources, oldest.Source)
+}
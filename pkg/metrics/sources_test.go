@@ -0,0 +1,62 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceTrackerServesBusiestSourcesFirst(t *testing.T) {
+	tracker := NewSourceTracker()
+	tracker.Record("10.0.0.1", false, "")
+	tracker.Record("10.0.0.2", false, "")
+	tracker.Record("10.0.0.2", true, "throttle")
+
+	req, err := http.NewRequest("GET", SourcesStatusPath, nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	tracker.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var statuses []SourceStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "10.0.0.2", statuses[0].Source)
+	assert.Equal(t, int64(2), statuses[0].Requests)
+	assert.Equal(t, int64(1), statuses[0].Limited)
+	assert.Nil(t, statuses[1].LastLimited)
+}
+
+func TestSourceTrackerForgetsTheLeastRecentSources(t *testing.T) {
+	tracker := NewSourceTracker()
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	for i := 0; i <= maxSources; i++ {
+		now = now.Add(time.Second)
+		tracker.Record(fmt.Sprintf("source-%d", i), false, "")
+	}
+	statuses := tracker.Statuses()
+	assert.Len(t, statuses, maxSources)
+	for _, s := range statuses {
+		assert.NotEqual(t, "source-0", s.Source)
+	}
+}
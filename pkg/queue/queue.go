@@ -0,0 +1,512 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package queue is a small persistent work queue for the side effects of rule payloads, such as labelling related
+// objects, so that they are retried when they fail and are never done in the admission request's fast path.
+// Pending and dead-lettered actions are saved in a ConfigMap so that they survive a restart, each replica under its
+// own keys so that replicas never overwrite each other's actions, along with a heartbeat so that the actions of a
+// replica that has gone away are adopted by another.
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	componentName = "queue"
+
+	// DefaultConfigMap is the name of the ConfigMap, in the server namespace, that actions are saved in.
+	DefaultConfigMap = "kube-graffiti-actions"
+	// DefaultMaxAttempts is how many times an action is tried before it is dead-lettered.
+	DefaultMaxAttempts = 10
+	// DefaultMinBackoff is the delay before the first retry, it doubles with each failure.
+	DefaultMinBackoff = time.Second
+	// DefaultMaxBackoff caps the delay between retries.
+	DefaultMaxBackoff = 5 * time.Minute
+
+	// pendingKey, deadLetterKey and heartbeatKey are prefixed with the name of the replica that saves them.
+	pendingKey    = "pending"
+	deadLetterKey = "dead-letter"
+	heartbeatKey  = "heartbeat"
+	// maxPending and maxDeadLetters keep each replica's actions well within the 1MiB limit of a ConfigMap, the
+	// oldest dead letters are dropped first and new actions are refused while the queue is full.
+	maxPending     = 250
+	maxDeadLetters = 100
+	pollInterval   = time.Second
+	// heartbeatInterval is how often a replica saves its heartbeat, and looks for the actions of replicas which have
+	// gone staleIntervals without saving theirs, which it adopts.
+	heartbeatInterval = 30 * time.Second
+	staleIntervals    = 3
+)
+
+// Config controls the retries of the action queue and where it is saved.
+type Config struct {
+	ConfigMap   string        `mapstructure:"configmap" yaml:"configmap,omitempty"`
+	MaxAttempts int           `mapstructure:"max-attempts" yaml:"max-attempts,omitempty"`
+	MinBackoff  time.Duration `mapstructure:"min-backoff" yaml:"min-backoff,omitempty"`
+	MaxBackoff  time.Duration `mapstructure:"max-backoff" yaml:"max-backoff,omitempty"`
+}
+
+// Validate checks the queue configuration.
+func (c Config) Validate() error {
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("invalid actions.max-attempts %d, must not be negative", c.MaxAttempts)
+	}
+	if c.MinBackoff < 0 || c.MaxBackoff < 0 || (c.MaxBackoff > 0 && c.MinBackoff > c.MaxBackoff) {
+		return fmt.Errorf("invalid actions backoff, min-backoff %v must be positive and no more than max-backoff %v", c.MinBackoff, c.MaxBackoff)
+	}
+	return nil
+}
+
+func (c Config) withDefaults() Config {
+	if c.ConfigMap == "" {
+		c.ConfigMap = DefaultConfigMap
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = DefaultMaxAttempts
+	}
+	if c.MinBackoff == 0 {
+		c.MinBackoff = DefaultMinBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	return c
+}
+
+// Action is a single side effect waiting to be done.  Data is specific to the kind of action.
+type Action struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Rule        string          `json:"rule"`
+	Data        json.RawMessage `json:"data"`
+	Created     time.Time       `json:"created"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// Handler does an action of a kind, returning an error if it should be retried.
+type Handler func(Action) error
+
+// Queue holds the pending actions and a worker that does them, retrying failures with exponential backoff and jitter.
+type Queue struct {
+	sync.Mutex
+	config     Config
+	configMaps typedcorev1.ConfigMapInterface
+	replica    string
+	handlers   map[string]Handler
+	pending    []Action
+	dead       []Action
+	dirty      bool
+	// heartbeat is when our heartbeat is next due to be saved.
+	heartbeat time.Time
+	wake      chan struct{}
+	stop      chan struct{}
+	stopped   chan struct{}
+	now       func() time.Time
+}
+
+// New creates a queue which saves its actions in a ConfigMap in the namespace, under keys named after our pod, which is
+// our hostname.
+func New(k kubernetes.Interface, namespace string, c Config) *Queue {
+	replica, _ := os.Hostname()
+	return &Queue{
+		config:     c.withDefaults(),
+		configMaps: k.CoreV1().ConfigMaps(namespace),
+		replica:    replica,
+		handlers:   make(map[string]Handler),
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// Handle sets the handler for a kind of action.  It must be called before the queue is started.
+func (q *Queue) Handle(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+// Enqueue adds an action to the queue, it is saved and done by the worker in the background.
+func (q *Queue) Enqueue(kind, rule string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s action: %v", kind, err)
+	}
+	now := q.now().UTC()
+	q.Lock()
+	if len(q.pending) >= maxPending {
+		q.Unlock()
+		return fmt.Errorf("the action queue is full with %d pending actions, the %s action of rule %s is dropped", maxPending, kind, rule)
+	}
+	q.pending = append(q.pending, Action{ID: uuid.New().String(), Kind: kind, Rule: rule, Data: raw, Created: now, NextAttempt: now})
+	q.dirty = true
+	metrics.QueuedActions.Set(float64(len(q.pending)))
+	q.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start loads any actions saved by a previous run and starts the worker.
+func (q *Queue) Start() error {
+	if err := q.load(); err != nil {
+		return err
+	}
+	q.stop = make(chan struct{})
+	q.stopped = make(chan struct{})
+	go q.run()
+	return nil
+}
+
+// Stop stops the worker and saves the actions that are still pending.  It is safe to call on a nil queue.
+func (q *Queue) Stop() {
+	if q == nil || q.stop == nil {
+		return
+	}
+	close(q.stop)
+	<-q.stopped
+}
+
+// Pending returns the actions waiting to be done.
+func (q *Queue) Pending() []Action {
+	q.Lock()
+	defer q.Unlock()
+	return append([]Action{}, q.pending...)
+}
+
+// DeadLetters returns the actions which failed every attempt.
+func (q *Queue) DeadLetters() []Action {
+	q.Lock()
+	defer q.Unlock()
+	return append([]Action{}, q.dead...)
+}
+
+func (q *Queue) run() {
+	mylog := log.ComponentLogger(componentName, "run")
+	defer close(q.stopped)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		q.processDue()
+		if err := q.save(); err != nil {
+			mylog.Error().Err(err).Msg("failed to save the action queue, will try again")
+		}
+		select {
+		case <-q.stop:
+			if err := q.save(); err != nil {
+				mylog.Error().Err(err).Msg("failed to save the action queue on shutdown")
+			}
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processDue does each of the actions whose next attempt is due.
+func (q *Queue) processDue() {
+	now := q.now().UTC()
+	q.Lock()
+	var due, later []Action
+	for _, a := range q.pending {
+		if a.NextAttempt.After(now) {
+			later = append(later, a)
+		} else {
+			due = append(due, a)
+		}
+	}
+	q.pending = later
+	q.Unlock()
+
+	for _, a := range due {
+		q.attempt(a)
+	}
+
+	q.Lock()
+	if len(due) > 0 {
+		q.dirty = true
+	}
+	metrics.QueuedActions.Set(float64(len(q.pending)))
+	q.Unlock()
+}
+
+func (q *Queue) attempt(a Action) {
+	mylog := log.ComponentLogger(componentName, "attempt")
+	alog := mylog.With().Str("id", a.ID).Str("kind", a.Kind).Str("rule", a.Rule).Logger()
+
+	a.Attempts++
+	var err error
+	if handler, ok := q.handlers[a.Kind]; ok {
+		err = handler(a)
+	} else {
+		err = fmt.Errorf("no handler for %s actions", a.Kind)
+		a.Attempts = q.config.MaxAttempts
+	}
+	if err == nil {
+		alog.Debug().Int("attempts", a.Attempts).Msg("action done")
+		metrics.Actions.WithLabelValues(a.Kind, metrics.ResultSucceeded).Inc()
+		return
+	}
+
+	a.LastError = err.Error()
+	q.Lock()
+	defer q.Unlock()
+	if a.Attempts >= q.config.MaxAttempts {
+		alog.Error().Err(err).Int("attempts", a.Attempts).Msg("action failed every attempt, moving it to the dead-letter list")
+		metrics.Actions.WithLabelValues(a.Kind, metrics.ResultDeadLettered).Inc()
+		q.dead = capDeadLetters(append(q.dead, a))
+		return
+	}
+	a.NextAttempt = q.now().UTC().Add(q.backoff(a.Attempts))
+	alog.Warn().Err(err).Int("attempts", a.Attempts).Time("next-attempt", a.NextAttempt).Msg("action failed, it will be retried")
+	metrics.Actions.WithLabelValues(a.Kind, metrics.ResultRetried).Inc()
+	q.pending = append(q.pending, a)
+}
+
+// backoff doubles the delay for each attempt, up to the maximum, and picks a random delay in its upper half so that
+// actions that failed together are not all retried together.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.config.MinBackoff
+	for i := 1; i < attempts && d < q.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.config.MaxBackoff {
+		d = q.config.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// key is the key of the ConfigMap that our replica saves its actions of a kind under.
+func (q *Queue) key(kind string) string {
+	return replicaKey(q.replica, kind)
+}
+
+// replicaKey is the key of the ConfigMap that a replica saves its actions of a kind under.
+func replicaKey(replica, kind string) string {
+	if replica == "" {
+		return kind
+	}
+	return replica + "." + kind
+}
+
+// save writes the pending and dead-lettered actions, and our heartbeat, to our keys of the ConfigMap when they have
+// changed or the heartbeat is due, leaving those of the other replicas alone.  The actions of replicas whose heartbeat
+// is stale are adopted in the same update, so that they are done by us rather than lost.  Updates are made against
+// the ConfigMap's resourceVersion and retried when another replica has changed it in the meantime, so only one
+// replica adopts each action.
+func (q *Queue) save() error {
+	mylog := log.ComponentLogger(componentName, "save")
+	now := q.now().UTC()
+	q.Lock()
+	if !q.dirty && now.Before(q.heartbeat) {
+		q.Unlock()
+		return nil
+	}
+	pending := append([]Action{}, q.pending...)
+	dead := append([]Action{}, q.dead...)
+	q.dirty = false
+	q.Unlock()
+
+	var adopted, adoptedDead []Action
+	var adoptedFrom []string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := q.configMaps.Get(q.config.ConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			adopted, adoptedDead, adoptedFrom = nil, nil, nil
+			data, err := q.data(pending, dead, now)
+			if err != nil {
+				return err
+			}
+			_, err = q.configMaps.Create(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: q.config.ConfigMap}, Data: data})
+			if apierrors.IsAlreadyExists(err) {
+				// another replica created it first, so update it instead
+				return apierrors.NewConflict(corev1.Resource("configmaps"), q.config.ConfigMap, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		adopted, adoptedDead, adoptedFrom = q.adopt(cm.Data, len(pending), now)
+		data, err := q.data(append(append([]Action{}, pending...), adopted...), capDeadLetters(append(append([]Action{}, dead...), adoptedDead...)), now)
+		if err != nil {
+			return err
+		}
+		for k, v := range data {
+			cm.Data[k] = v
+		}
+		_, err = q.configMaps.Update(cm)
+		return err
+	})
+	if err != nil {
+		q.Lock()
+		q.dirty = true
+		q.Unlock()
+		return fmt.Errorf("failed to save actions to configmap %s: %v", q.config.ConfigMap, err)
+	}
+
+	q.Lock()
+	q.heartbeat = now.Add(heartbeatInterval)
+	q.pending = append(q.pending, adopted...)
+	q.dead = capDeadLetters(append(q.dead, adoptedDead...))
+	metrics.QueuedActions.Set(float64(len(q.pending)))
+	q.Unlock()
+	if len(adoptedFrom) > 0 {
+		mylog.Info().Strs("replicas", adoptedFrom).Int("pending", len(adopted)).Int("dead-lettered", len(adoptedDead)).Msg("adopted the actions of replicas that have gone away")
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// data is our keys of the ConfigMap for the actions, with our heartbeat.
+func (q *Queue) data(pending, dead []Action, now time.Time) (map[string]string, error) {
+	p, err := json.Marshal(pending)
+	if err != nil {
+		return nil, err
+	}
+	d, err := json.Marshal(dead)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{q.key(pendingKey): string(p), q.key(deadLetterKey): string(d), q.key(heartbeatKey): now.Format(time.RFC3339)}, nil
+}
+
+// adopt takes the actions of the replicas in the ConfigMap's data whose heartbeat is stale, or missing as it is for
+// the keys saved before replicas had heartbeats, removing their keys from the data.  Only as many pending actions are
+// adopted as our queue has room for, the rest are left under the replica's keys to be adopted later.
+func (q *Queue) adopt(data map[string]string, queued int, now time.Time) (pending, dead []Action, replicas []string) {
+	mylog := log.ComponentLogger(componentName, "adopt")
+	for _, replica := range savedReplicas(data) {
+		if replica == q.replica || !stale(data[replicaKey(replica, heartbeatKey)], now) {
+			continue
+		}
+		var theirs, theirDead []Action
+		if err := json.Unmarshal([]byte(data[replicaKey(replica, pendingKey)]), &theirs); err != nil && data[replicaKey(replica, pendingKey)] != "" {
+			mylog.Warn().Err(err).Str("replica", replica).Msg("dropping the invalid pending actions of a replica that has gone away")
+		}
+		if err := json.Unmarshal([]byte(data[replicaKey(replica, deadLetterKey)]), &theirDead); err != nil && data[replicaKey(replica, deadLetterKey)] != "" {
+			mylog.Warn().Err(err).Str("replica", replica).Msg("dropping the invalid dead-lettered actions of a replica that has gone away")
+		}
+		room := maxPending - queued - len(pending)
+		if room < 0 {
+			room = 0
+		}
+		if len(theirs) > room {
+			// leave the rest for when we have room for them
+			rest, _ := json.Marshal(theirs[room:])
+			data[replicaKey(replica, pendingKey)] = string(rest)
+			theirs = theirs[:room]
+		} else {
+			delete(data, replicaKey(replica, pendingKey))
+			delete(data, replicaKey(replica, heartbeatKey))
+		}
+		delete(data, replicaKey(replica, deadLetterKey))
+		pending = append(pending, theirs...)
+		dead = append(dead, theirDead...)
+		replicas = append(replicas, replica)
+	}
+	return pending, dead, replicas
+}
+
+// savedReplicas are the replicas which have actions saved in the ConfigMap's data.  Keys saved before replicas had
+// their own keys belong to the replica with no name.
+func savedReplicas(data map[string]string) []string {
+	seen := make(map[string]bool)
+	var replicas []string
+	for k := range data {
+		for _, kind := range []string{pendingKey, deadLetterKey} {
+			if k != kind && !strings.HasSuffix(k, "."+kind) {
+				continue
+			}
+			replica := strings.TrimSuffix(strings.TrimSuffix(k, kind), ".")
+			if !seen[replica] {
+				seen[replica] = true
+				replicas = append(replicas, replica)
+			}
+		}
+	}
+	sort.Strings(replicas)
+	return replicas
+}
+
+// stale is true when a replica's heartbeat is missing, invalid or older than staleIntervals heartbeats.
+func stale(heartbeat string, now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, heartbeat)
+	return err != nil || now.Sub(t) > staleIntervals*heartbeatInterval
+}
+
+// capDeadLetters drops the oldest dead letters beyond maxDeadLetters.
+func capDeadLetters(dead []Action) []Action {
+	if len(dead) > maxDeadLetters {
+		return dead[len(dead)-maxDeadLetters:]
+	}
+	return dead
+}
+
+// load reads the actions saved by a previous run of our replica, there is nothing to load when the ConfigMap doesn't
+// exist.
+func (q *Queue) load() error {
+	mylog := log.ComponentLogger(componentName, "load")
+	cm, err := q.configMaps.Get(q.config.ConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read actions from configmap %s: %v", q.config.ConfigMap, err)
+	}
+
+	var pending, dead []Action
+	if s := cm.Data[q.key(pendingKey)]; s != "" {
+		if err := json.Unmarshal([]byte(s), &pending); err != nil {
+			return fmt.Errorf("invalid pending actions in configmap %s: %v", q.config.ConfigMap, err)
+		}
+	}
+	if s := cm.Data[q.key(deadLetterKey)]; s != "" {
+		if err := json.Unmarshal([]byte(s), &dead); err != nil {
+			return fmt.Errorf("invalid dead-lettered actions in configmap %s: %v", q.config.ConfigMap, err)
+		}
+	}
+	q.Lock()
+	q.pending = append(pending, q.pending...)
+	q.dead = dead
+	metrics.QueuedActions.Set(float64(len(q.pending)))
+	q.Unlock()
+	mylog.Info().Int("pending", len(pending)).Int("dead-lettered", len(dead)).Msg("loaded saved actions")
+	return nil
+}
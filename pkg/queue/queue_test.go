@@ -0,0 +1,213 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testQueue(k *fake.Clientset, now *time.Time) *Queue {
+	return testReplicaQueue(k, now, "kube-graffiti-0")
+}
+
+func testReplicaQueue(k *fake.Clientset, now *time.Time, replica string) *Queue {
+	q := New(k, "kube-graffiti", Config{MaxAttempts: 3, MinBackoff: time.Second, MaxBackoff: 4 * time.Second})
+	q.replica = replica
+	q.now = func() time.Time { return *now }
+	return q
+}
+
+func TestActionsAreDoneAndSaved(t *testing.T) {
+	now := time.Now()
+	k := fake.NewSimpleClientset()
+	q := testQueue(k, &now)
+	var done []string
+	q.Handle("greet", func(a Action) error {
+		var name string
+		require.NoError(t, json.Unmarshal(a.Data, &name))
+		done = append(done, a.Rule+":"+name)
+		return nil
+	})
+
+	require.NoError(t, q.Enqueue("greet", "my-rule", "dave"))
+	require.NoError(t, q.save())
+	cm, err := k.CoreV1().ConfigMaps("kube-graffiti").Get(DefaultConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, cm.Data["kube-graffiti-0.pending"], "dave", "the pending action should be saved")
+
+	q.processDue()
+	require.NoError(t, q.save())
+	assert.Equal(t, []string{"my-rule:dave"}, done)
+	assert.Empty(t, q.Pending())
+	cm, err = k.CoreV1().ConfigMaps("kube-graffiti").Get(DefaultConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "[]", cm.Data["kube-graffiti-0.pending"])
+}
+
+func TestReplicasSaveTheirOwnActions(t *testing.T) {
+	now := time.Now()
+	k := fake.NewSimpleClientset()
+	first := testReplicaQueue(k, &now, "kube-graffiti-0")
+	second := testReplicaQueue(k, &now, "kube-graffiti-1")
+	require.NoError(t, first.Enqueue("greet", "my-rule", "dave"))
+	require.NoError(t, first.save())
+	require.NoError(t, second.Enqueue("greet", "my-rule", "sue"))
+	require.NoError(t, second.save())
+
+	cm, err := k.CoreV1().ConfigMaps("kube-graffiti").Get(DefaultConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, cm.Data["kube-graffiti-0.pending"], "dave", "the second replica mustn't overwrite the first's actions")
+	assert.Contains(t, cm.Data["kube-graffiti-1.pending"], "sue")
+
+	restarted := testReplicaQueue(k, &now, "kube-graffiti-1")
+	require.NoError(t, restarted.load())
+	require.Len(t, restarted.Pending(), 1, "a replica only loads its own actions")
+	assert.JSONEq(t, `"sue"`, string(restarted.Pending()[0].Data))
+}
+
+func TestActionsOfReplicasThatStopHeartbeatingAreAdopted(t *testing.T) {
+	now := time.Now()
+	k := fake.NewSimpleClientset()
+	gone := testReplicaQueue(k, &now, "kube-graffiti-0")
+	running := testReplicaQueue(k, &now, "kube-graffiti-1")
+	require.NoError(t, gone.Enqueue("greet", "my-rule", "dave"))
+	require.NoError(t, gone.save())
+	require.NoError(t, running.save())
+	assert.Empty(t, running.Pending(), "the actions of a replica that is still heartbeating mustn't be adopted")
+
+	now = now.Add(staleIntervals*heartbeatInterval + time.Second)
+	require.NoError(t, running.save())
+	require.Len(t, running.Pending(), 1)
+	assert.JSONEq(t, `"dave"`, string(running.Pending()[0].Data))
+
+	cm, err := k.CoreV1().ConfigMaps("kube-graffiti").Get(DefaultConfigMap, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, cm.Data, "kube-graffiti-0.pending", "the keys of an adopted replica should be removed")
+	assert.NotContains(t, cm.Data, "kube-graffiti-0.heartbeat")
+	assert.Contains(t, cm.Data["kube-graffiti-1.pending"], "dave")
+}
+
+func TestActionsSavedWithoutAHeartbeatAreAdopted(t *testing.T) {
+	now := time.Now()
+	k := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultConfigMap, Namespace: "kube-graffiti"},
+		Data: map[string]string{
+			"pending":     `[{"kind":"greet","rule":"my-rule","data":"dave"}]`,
+			"dead-letter": `[{"kind":"greet","rule":"my-rule","data":"sue"}]`,
+		},
+	})
+	q := testQueue(k, &now)
+	require.NoError(t, q.save())
+	require.Len(t, q.Pending(), 1)
+	assert.JSONEq(t, `"dave"`, string(q.Pending()[0].Data))
+	require.Len(t, q.DeadLetters(), 1)
+	assert.JSONEq(t, `"sue"`, string(q.DeadLetters()[0].Data))
+}
+
+func TestFullQueuesRefuseActions(t *testing.T) {
+	now := time.Now()
+	q := testQueue(fake.NewSimpleClientset(), &now)
+	for i := 0; i < maxPending; i++ {
+		require.NoError(t, q.Enqueue("greet", "my-rule", i))
+	}
+	assert.Error(t, q.Enqueue("greet", "my-rule", "dave"))
+	assert.Len(t, q.Pending(), maxPending)
+}
+
+func TestFailedActionsAreRetriedWithBackoffThenDeadLettered(t *testing.T) {
+	now := time.Now()
+	q := testQueue(fake.NewSimpleClientset(), &now)
+	attempts := 0
+	q.Handle("fail", func(Action) error {
+		attempts++
+		return errors.New("apiserver unavailable")
+	})
+	require.NoError(t, q.Enqueue("fail", "my-rule", nil))
+
+	q.processDue()
+	require.Len(t, q.Pending(), 1)
+	retry := q.Pending()[0]
+	assert.Equal(t, 1, retry.Attempts)
+	assert.Equal(t, "apiserver unavailable", retry.LastError)
+	assert.True(t, retry.NextAttempt.After(now), "the retry should be delayed")
+
+	q.processDue()
+	assert.Equal(t, 1, attempts, "the action should not be retried before its backoff has passed")
+
+	for i := 0; i < 2; i++ {
+		now = now.Add(time.Minute)
+		q.processDue()
+	}
+	assert.Equal(t, 3, attempts)
+	assert.Empty(t, q.Pending())
+	require.Len(t, q.DeadLetters(), 1)
+	assert.Equal(t, "my-rule", q.DeadLetters()[0].Rule)
+}
+
+func TestActionsWithoutAHandlerAreDeadLettered(t *testing.T) {
+	now := time.Now()
+	q := testQueue(fake.NewSimpleClientset(), &now)
+	require.NoError(t, q.Enqueue("unknown", "my-rule", nil))
+	q.processDue()
+	require.Len(t, q.DeadLetters(), 1)
+	assert.Equal(t, "no handler for unknown actions", q.DeadLetters()[0].LastError)
+}
+
+func TestBackoffDoublesUpToTheMaximum(t *testing.T) {
+	now := time.Now()
+	q := testQueue(fake.NewSimpleClientset(), &now)
+	for attempts, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 4 * time.Second} {
+		d := q.backoff(attempts)
+		assert.True(t, d >= max/2 && d <= max, "backoff after %d attempts was %v", attempts, d)
+	}
+}
+
+func TestSavedActionsAreLoadedOnStart(t *testing.T) {
+	now := time.Now()
+	k := fake.NewSimpleClientset()
+	first := testQueue(k, &now)
+	require.NoError(t, first.Enqueue("greet", "my-rule", "dave"))
+	require.NoError(t, first.save())
+
+	second := testQueue(k, &now)
+	done := make(chan Action, 1)
+	second.Handle("greet", func(a Action) error {
+		done <- a
+		return nil
+	})
+	require.NoError(t, second.Start())
+	select {
+	case a := <-done:
+		assert.Equal(t, "my-rule", a.Rule)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the saved action was not done")
+	}
+	second.Stop()
+	assert.Empty(t, second.Pending())
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.Error(t, Config{MaxAttempts: -1}.Validate())
+	assert.Error(t, Config{MinBackoff: time.Minute, MaxBackoff: time.Second}.Validate())
+}
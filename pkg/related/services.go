@@ -0,0 +1,201 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package related keeps objects that are related to an admitted object, such as the Services that select a
+// Deployment's pods, consistent with the labels that a graffiti rule paints onto it.
+package related
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/queue"
+	admission "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	componentName = "related"
+	// ActionLabelServices is the kind of queued action that labels related services.
+	ActionLabelServices = "label-related-services"
+)
+
+// ServiceLabeller labels the Services which select the pods of an admitted workload.
+// The labelling happens in the background so that it never delays an admission request.
+type ServiceLabeller struct {
+	clientset kubernetes.Interface
+	running   sync.WaitGroup
+	queue     *queue.Queue
+}
+
+// NewServiceLabeller creates a ServiceLabeller using the given kubernetes client.
+func NewServiceLabeller(k kubernetes.Interface) *ServiceLabeller {
+	return &ServiceLabeller{clientset: k}
+}
+
+// UseQueue makes the labeller queue its labelling so that failures are retried, rather than only being logged.
+// It must be called before the queue is started.
+func (l *ServiceLabeller) UseQueue(q *queue.Queue) {
+	l.queue = q
+	q.Handle(ActionLabelServices, func(a queue.Action) error {
+		var s servicesAction
+		if err := json.Unmarshal(a.Data, &s); err != nil {
+			return fmt.Errorf("invalid %s action: %v", ActionLabelServices, err)
+		}
+		return l.labelSelectingServices(a.Rule, s)
+	})
+}
+
+// servicesAction is the labelling of the services in a namespace which select the pod labels.
+type servicesAction struct {
+	Namespace string            `json:"namespace"`
+	PodLabels map[string]string `json:"podLabels"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// workload is just enough of a Deployment, StatefulSet, DaemonSet or ReplicaSet to find its pod labels.
+type workload struct {
+	Spec struct {
+		Template struct {
+			Metadata metav1.ObjectMeta `json:"metadata"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// LabelServices starts labelling the services related to the object in an admission request with the rule's label additions.
+// It is safe to call on a nil ServiceLabeller and does nothing for rules that don't ask for related services to be labelled.
+func (l *ServiceLabeller) LabelServices(rule graffiti.Rule, req *admission.AdmissionRequest) {
+	if l == nil || req == nil || !rule.Payload.LabelRelatedServices {
+		return
+	}
+	l.running.Add(1)
+	go func() {
+		defer l.running.Done()
+		mylog := log.ComponentLogger(componentName, "LabelServices")
+		rlog := log.RuleLogger(mylog, rule.Name).With().Str("kind", req.Kind.Kind).Str("namespace", req.Namespace).Str("name", req.Name).Logger()
+		if err := l.labelServices(rule, req.Namespace, req.Object.Raw); err != nil {
+			rlog.Error().Err(err).Msg("failed to label related services")
+		}
+	}()
+}
+
+// Wait blocks until any background labelling has finished.
+func (l *ServiceLabeller) Wait() {
+	if l == nil {
+		return
+	}
+	l.running.Wait()
+}
+
+func (l *ServiceLabeller) labelServices(rule graffiti.Rule, namespace string, object []byte) error {
+	mylog := log.ComponentLogger(componentName, "labelServices")
+	rlog := log.RuleLogger(mylog, rule.Name).With().Str("namespace", namespace).Logger()
+
+	// we are called for every admitted object, even when painting it produced no patch because it already had
+	// the labels, so we need to check the rule for ourselves.
+	match, err := rule.Matches(object, nil)
+	if err != nil {
+		return fmt.Errorf("failed to match object: %v", err)
+	}
+	if !match {
+		return nil
+	}
+
+	var w workload
+	if err := json.Unmarshal(object, &w); err != nil {
+		return fmt.Errorf("failed to unmarshal workload: %v", err)
+	}
+	podLabels := w.Spec.Template.Metadata.Labels
+	if len(podLabels) == 0 {
+		rlog.Debug().Msg("object does not have a pod template with labels, so no services can select it")
+		return nil
+	}
+
+	add, err := rule.RenderedLabels(object)
+	if err != nil {
+		return fmt.Errorf("failed to render labels: %v", err)
+	}
+
+	action := servicesAction{Namespace: namespace, PodLabels: podLabels, Labels: add}
+	if l.queue != nil {
+		return l.queue.Enqueue(ActionLabelServices, rule.Name, action)
+	}
+	return l.labelSelectingServices(rule.Name, action)
+}
+
+// labelSelectingServices adds the labels to the services which select the pod labels.
+func (l *ServiceLabeller) labelSelectingServices(rule string, s servicesAction) error {
+	mylog := log.ComponentLogger(componentName, "labelSelectingServices")
+	rlog := log.RuleLogger(mylog, rule).With().Str("namespace", s.Namespace).Logger()
+
+	// patching services in a namespace that is being deleted only produces conflict errors
+	ns, err := l.clientset.CoreV1().Namespaces().Get(s.Namespace, metav1.GetOptions{})
+	if err == nil && (ns.Status.Phase == corev1.NamespaceTerminating || ns.DeletionTimestamp != nil) {
+		rlog.Info().Msg("skipping related services because the namespace is terminating")
+		metrics.SkippedObjects.WithLabelValues(rule, metrics.ReasonNamespaceTerminating).Inc()
+		return nil
+	}
+
+	services, err := l.clientset.CoreV1().Services(s.Namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, svc := range services.Items {
+		if !selectsPods(svc, s.PodLabels) {
+			continue
+		}
+		patch := missingLabelsPatch(svc.Labels, s.Labels)
+		if patch == nil {
+			rlog.Debug().Str("service", svc.Name).Msg("related service already has the labels")
+			continue
+		}
+		rlog.Info().Str("service", svc.Name).Msg("labelling related service")
+		if _, err := l.clientset.CoreV1().Services(s.Namespace).Patch(svc.Name, types.MergePatchType, patch); err != nil {
+			return fmt.Errorf("failed to patch service %s: %v", svc.Name, err)
+		}
+	}
+	return nil
+}
+
+// selectsPods is true when the service has a selector and it matches the pod labels.
+func selectsPods(svc corev1.Service, podLabels map[string]string) bool {
+	if len(svc.Spec.Selector) == 0 {
+		return false
+	}
+	return labels.SelectorFromSet(svc.Spec.Selector).Matches(labels.Set(podLabels))
+}
+
+// missingLabelsPatch creates a merge patch for any labels that are not already set to the desired value, or nil if there are none.
+func missingLabelsPatch(existing, add map[string]string) []byte {
+	missing := make(map[string]string)
+	for k, v := range add {
+		if current, ok := existing[k]; !ok || current != v {
+			missing[k] = v
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": missing},
+	})
+	return patch
+}
@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package related
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/queue"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testDeployment = `{
+	"metadata": {"name": "web", "namespace": "shop", "labels": {"app": "web"}},
+	"spec": {
+		"template": {
+			"metadata": {"labels": {"app": "web", "tier": "frontend"}}
+		}
+	}
+}`
+
+func testService(name string, selector, labels map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Labels: labels},
+		Spec:       corev1.ServiceSpec{Selector: selector},
+	}
+}
+
+func testRule() graffiti.Rule {
+	return graffiti.Rule{
+		Name: "team-ownership",
+		Payload: graffiti.Payload{
+			Additions:            graffiti.Additions{Labels: map[string]string{"owner": "team-{{ index . \"metadata.labels.app\" }}"}},
+			LabelRelatedServices: true,
+		},
+	}
+}
+
+func testRequest() *admission.AdmissionRequest {
+	return &admission.AdmissionRequest{
+		Kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Namespace: "shop",
+		Name:      "web",
+		Object:    runtime.RawExtension{Raw: []byte(testDeployment)},
+	}
+}
+
+func TestLabelsServicesThatSelectTheWorkloadsPods(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		testService("web", map[string]string{"app": "web"}, nil),
+		testService("web-frontend", map[string]string{"app": "web", "tier": "frontend"}, map[string]string{"existing": "label"}),
+		testService("api", map[string]string{"app": "api"}, nil),
+		testService("external", nil, nil),
+	)
+	l := NewServiceLabeller(clientset)
+	l.LabelServices(testRule(), testRequest())
+	l.Wait()
+
+	svc, err := clientset.CoreV1().Services("shop").Get("web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "team-web", svc.Labels["owner"])
+
+	svc, err = clientset.CoreV1().Services("shop").Get("web-frontend", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"existing": "label", "owner": "team-web"}, svc.Labels)
+
+	svc, err = clientset.CoreV1().Services("shop").Get("api", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, svc.Labels, "a service selecting other pods should not be labelled")
+
+	svc, err = clientset.CoreV1().Services("shop").Get("external", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, svc.Labels, "a service without a selector should not be labelled")
+}
+
+func TestDoesNothingWhenRuleDoesNotMatch(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testService("web", map[string]string{"app": "web"}, nil))
+	rule := testRule()
+	rule.Matchers = graffiti.Matchers{LabelSelectors: []string{"app=api"}}
+
+	l := NewServiceLabeller(clientset)
+	l.LabelServices(rule, testRequest())
+	l.Wait()
+
+	svc, err := clientset.CoreV1().Services("shop").Get("web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, svc.Labels)
+}
+
+func TestMissingLabelsPatchIsNilWhenLabelsArePresent(t *testing.T) {
+	assert.Nil(t, missingLabelsPatch(map[string]string{"a": "b", "c": "d"}, map[string]string{"a": "b"}))
+	assert.JSONEq(t, `{"metadata":{"labels":{"a":"b"}}}`, string(missingLabelsPatch(map[string]string{"a": "x"}, map[string]string{"a": "b"})))
+}
+
+func TestSkipsServicesInTerminatingNamespaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "shop"},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+		},
+		testService("web", map[string]string{"app": "web"}, nil),
+	)
+	l := NewServiceLabeller(clientset)
+	l.LabelServices(testRule(), testRequest())
+	l.Wait()
+
+	svc, err := clientset.CoreV1().Services("shop").Get("web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, svc.Labels, "services in a terminating namespace should not be labelled")
+}
+
+func TestLabellingIsRetriedThroughTheQueue(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testService("web", map[string]string{"app": "web"}, nil))
+	failures := 1
+	clientset.PrependReactor("list", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, errors.New("apiserver unavailable")
+		}
+		return false, nil, nil
+	})
+
+	q := queue.New(clientset, "kube-graffiti", queue.Config{MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond})
+	l := NewServiceLabeller(clientset)
+	l.UseQueue(q)
+	require.NoError(t, q.Start())
+	defer q.Stop()
+	l.LabelServices(testRule(), testRequest())
+	l.Wait()
+
+	assert.Eventually(t, func() bool {
+		svc, err := clientset.CoreV1().Services("shop").Get("web", metav1.GetOptions{})
+		return err == nil && svc.Labels["owner"] == "team-web"
+	}, 5*time.Second, 50*time.Millisecond, "the service should be labelled once the failed list is retried")
+}
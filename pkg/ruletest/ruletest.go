@@ -0,0 +1,298 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ruletest runs test cases written as yaml fixtures against the rules of a configuration in-process, so that
+// rule authors can check which objects their rules match and how they are patched without a cluster.  Each case is
+// an object, and optionally the old object and user of the admission request, with the match, patch or admission
+// result that the rule is expected to give it.
+package ruletest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"gopkg.in/yaml.v2"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Fixture is a file of test cases.
+type Fixture struct {
+	Cases []Case `yaml:"cases"`
+}
+
+// Case is an object that a rule is evaluated against and what the rule is expected to do with it.
+type Case struct {
+	Name string `yaml:"name"`
+	// Rule is the name of the rule that the case tests.
+	Rule string `yaml:"rule"`
+	// Operation is the admission operation, CREATE when it isn't set.
+	Operation string                 `yaml:"operation,omitempty"`
+	User      User                   `yaml:"user,omitempty"`
+	Object    map[string]interface{} `yaml:"object"`
+	OldObject map[string]interface{} `yaml:"old-object,omitempty"`
+	Expect    Expectation            `yaml:"expect"`
+	// File is the fixture that the case was read from.
+	File string `yaml:"-"`
+}
+
+// User is the user that makes the admission request.
+type User struct {
+	Username string   `yaml:"username,omitempty"`
+	Groups   []string `yaml:"groups,omitempty"`
+}
+
+// Expectation is what a rule should do with an object, only the expectations that are set are checked.
+type Expectation struct {
+	// Match is whether the rule's matchers match the object.
+	Match *bool `yaml:"match,omitempty"`
+	// Allowed is whether the admission request is allowed, it is false when a rule blocks or denies the object.
+	Allowed *bool `yaml:"allowed,omitempty"`
+	// Patch is the json patch that the rule makes, its operations can be in any order and an empty list expects no
+	// patch at all.
+	Patch []map[string]interface{} `yaml:"patch,omitempty"`
+}
+
+// Result is the outcome of a case, Err is nil when the rule did what the case expected.
+type Result struct {
+	File string
+	Case string
+	Rule string
+	Err  error
+}
+
+// LoadFixtures reads the cases from every yaml file in a directory, or from a single file, in the order of the file names.
+func LoadFixtures(path string) ([]Case, error) {
+	files := []string{path}
+	matches, err := filepath.Glob(filepath.Join(path, "*.y*ml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) > 0 {
+		files = matches
+		sort.Strings(files)
+	}
+
+	var cases []Case
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fixtures: %v", err)
+		}
+		var fixture Fixture
+		if err := yaml.UnmarshalStrict(data, &fixture); err != nil {
+			return nil, fmt.Errorf("failed to parse fixtures %s: %v", file, err)
+		}
+		for i, c := range fixture.Cases {
+			if c.Name == "" {
+				c.Name = fmt.Sprintf("#%d", i)
+			}
+			if c.Rule == "" {
+				return nil, fmt.Errorf("case %s in %s doesn't name the rule that it tests", c.Name, file)
+			}
+			if c.Object == nil {
+				return nil, fmt.Errorf("case %s in %s doesn't have an object", c.Name, file)
+			}
+			c.File = file
+			cases = append(cases, c)
+		}
+	}
+	if len(cases) == 0 {
+		return nil, errors.New("no test cases found in " + path)
+	}
+	return cases, nil
+}
+
+// Run evaluates each case against the rule that it names and returns their results in order.
+func Run(rules []graffiti.Rule, cases []Case) []Result {
+	byName := make(map[string]graffiti.Rule, len(rules))
+	for _, rule := range rules {
+		byName[rule.Name] = rule.Compiled()
+	}
+	var results []Result
+	for _, c := range cases {
+		result := Result{File: c.File, Case: c.Name, Rule: c.Rule}
+		rule, ok := byName[c.Rule]
+		if !ok {
+			result.Err = fmt.Errorf("there is no valid rule named %s", c.Rule)
+		} else {
+			result.Err = check(rule, c)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// check returns why the rule didn't do what the case expected, or nil when it did.
+func check(rule graffiti.Rule, c Case) error {
+	req, err := request(c)
+	if err != nil {
+		return err
+	}
+	var problems []string
+	if c.Expect.Match != nil {
+		explanation := rule.ExplainAdmission(req)
+		if explanation.Error != "" {
+			return errors.New(explanation.Error)
+		}
+		if explanation.Matched != *c.Expect.Match {
+			problems = append(problems, fmt.Sprintf("expected match to be %t but it was %t", *c.Expect.Match, explanation.Matched))
+		}
+	}
+
+	var resp *admission.AdmissionResponse
+	if rule.IsValidating() {
+		resp = rule.ValidateAdmission(req)
+	} else {
+		resp = rule.MutateAdmission(req)
+	}
+	if resp.Result != nil && resp.Result.Status == metav1.StatusFailure {
+		return errors.New(resp.Result.Message)
+	}
+	if c.Expect.Allowed != nil && resp.Allowed != *c.Expect.Allowed {
+		problems = append(problems, fmt.Sprintf("expected allowed to be %t but it was %t", *c.Expect.Allowed, resp.Allowed))
+	}
+	if c.Expect.Patch != nil {
+		if err := comparePatch(c.Expect.Patch, resp.Patch); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// request builds the admission request of a case.
+func request(c Case) (*admission.AdmissionRequest, error) {
+	object, err := json.Marshal(jsonCompatible(c.Object))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the object: %v", err)
+	}
+	var meta struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(object, &meta); err != nil {
+		return nil, fmt.Errorf("failed to read the object's metadata: %v", err)
+	}
+	operation := admission.Operation(strings.ToUpper(c.Operation))
+	if operation == "" {
+		operation = admission.Create
+	}
+	req := &admission.AdmissionRequest{
+		UID:       "kube-graffiti-test",
+		Kind:      metav1.GroupVersionKind{Kind: meta.Kind},
+		Name:      meta.Metadata.Name,
+		Namespace: meta.Metadata.Namespace,
+		Operation: operation,
+		UserInfo:  authenticationv1.UserInfo{Username: c.User.Username, Groups: c.User.Groups},
+		Object:    runtime.RawExtension{Raw: object},
+	}
+	if c.OldObject != nil {
+		old, err := json.Marshal(jsonCompatible(c.OldObject))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the old object: %v", err)
+		}
+		req.OldObject = runtime.RawExtension{Raw: old}
+	}
+	return req, nil
+}
+
+// comparePatch compares the expected patch operations with the patch that the rule made, ignoring their order.
+func comparePatch(expected []map[string]interface{}, patch []byte) error {
+	var actual []interface{}
+	if len(patch) > 0 {
+		if err := json.Unmarshal(patch, &actual); err != nil {
+			return fmt.Errorf("failed to parse the rule's patch: %v", err)
+		}
+	}
+	want, err := canonicalOperations(jsonCompatible(expected))
+	if err != nil {
+		return err
+	}
+	got, err := canonicalOperations(actual)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(want, got) {
+		return fmt.Errorf("expected patch [%s] but it was [%s]", strings.Join(want, ", "), strings.Join(got, ", "))
+	}
+	return nil
+}
+
+// canonicalOperations returns each patch operation as json, which has its keys sorted, in sorted order.
+func canonicalOperations(operations interface{}) ([]string, error) {
+	data, err := json.Marshal(operations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the patch: %v", err)
+	}
+	var ops []interface{}
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return nil, fmt.Errorf("failed to parse the patch: %v", err)
+	}
+	result := []string{}
+	for _, op := range ops {
+		data, err := json.Marshal(op)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal the patch: %v", err)
+		}
+		result = append(result, string(data))
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values produced by yaml into maps that json can marshal.
+func jsonCompatible(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = jsonCompatible(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = jsonCompatible(v)
+		}
+		return m
+	case []map[string]interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = jsonCompatible(v)
+		}
+		return l
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, v := range t {
+			l[i] = jsonCompatible(v)
+		}
+		return l
+	default:
+		return v
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ruletest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFixture = `cases:
+- name: labels web pods
+  rule: label-web-pods
+  object:
+    kind: Pod
+    metadata:
+      name: web-1
+      namespace: default
+      labels:
+        app: web
+  expect:
+    match: true
+    patch:
+    - op: replace
+      path: /metadata/labels
+      value:
+        app: web
+        team: web
+- name: leaves other pods alone
+  rule: label-web-pods
+  object:
+    kind: Pod
+    metadata:
+      name: db-1
+      labels:
+        app: db
+  expect:
+    match: false
+    patch: []
+- name: denies privileged pods
+  rule: deny-privileged
+  object:
+    kind: Pod
+    metadata:
+      name: evil
+      labels:
+        privileged: "true"
+  expect:
+    allowed: false
+`
+
+func testRules() []graffiti.Rule {
+	return []graffiti.Rule{
+		graffiti.NewRule("label-web-pods").MatchLabels("app=web").AddLabels(map[string]string{"team": "web"}),
+		graffiti.NewRule("deny-privileged").Validating().MatchLabels("privileged=true"),
+	}
+}
+
+func writeFixture(t *testing.T, dir, name, content string) {
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
+
+func TestRunPassesCasesThatDoWhatTheyExpect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ruletest")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	writeFixture(t, dir, "pods.yaml", testFixture)
+
+	cases, err := LoadFixtures(dir)
+	require.NoError(t, err)
+	require.Len(t, cases, 3)
+	for _, result := range Run(testRules(), cases) {
+		assert.NoError(t, result.Err, result.Case)
+	}
+}
+
+func TestRunFailsCasesThatDontDoWhatTheyExpect(t *testing.T) {
+	match := true
+	cases := []Case{
+		{Name: "wrong match", Rule: "label-web-pods", Object: map[string]interface{}{"kind": "Pod", "metadata": map[string]interface{}{"name": "db"}}, Expect: Expectation{Match: &match}},
+		{Name: "wrong patch", Rule: "label-web-pods", Object: map[string]interface{}{"kind": "Pod", "metadata": map[string]interface{}{"name": "web", "labels": map[string]interface{}{"app": "web"}}},
+			Expect: Expectation{Patch: []map[string]interface{}{{"op": "add", "path": "/metadata/labels/team", "value": "db"}}}},
+		{Name: "missing rule", Rule: "nonexistent", Object: map[string]interface{}{"kind": "Pod"}},
+	}
+
+	results := Run(testRules(), cases)
+	require.Len(t, results, 3)
+	assert.EqualError(t, results[0].Err, "expected match to be true but it was false")
+	assert.Contains(t, results[1].Err.Error(), "expected patch")
+	assert.EqualError(t, results[2].Err, "there is no valid rule named nonexistent")
+}
+
+func TestPatchesAreComparedInAnyOrder(t *testing.T) {
+	patch := []byte(`[{"op":"add","path":"/a","value":1},{"op":"add","path":"/b","value":{"c":"d"}}]`)
+	expected := []map[string]interface{}{
+		{"path": "/b", "op": "add", "value": map[interface{}]interface{}{"c": "d"}},
+		{"op": "add", "path": "/a", "value": 1},
+	}
+	assert.NoError(t, comparePatch(expected, patch))
+	assert.Error(t, comparePatch(expected[:1], patch))
+	assert.NoError(t, comparePatch([]map[string]interface{}{}, nil))
+}
+
+func TestLoadFixturesRejectsIncompleteCases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ruletest")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	writeFixture(t, dir, "norule.yaml", "cases:\n- name: orphan\n  object:\n    kind: Pod\n")
+	_, err = LoadFixtures(filepath.Join(dir, "norule.yaml"))
+	assert.EqualError(t, err, "case orphan in "+filepath.Join(dir, "norule.yaml")+" doesn't name the rule that it tests")
+
+	writeFixture(t, dir, "norule.yaml", "cases:\n- name: typo\n  rule: r\n  objcet: {}\n")
+	_, err = LoadFixtures(filepath.Join(dir, "norule.yaml"))
+	assert.Error(t, err)
+}
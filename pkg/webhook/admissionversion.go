@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"fmt"
+
+	admissionreg "k8s.io/api/admissionregistration/v1"
+)
+
+const (
+	// AdmissionVersionV1 registers our webhooks with the admissionregistration.k8s.io/v1 api, which kubernetes 1.16
+	// and later serve, and asks for v1 AdmissionReviews.
+	AdmissionVersionV1 = "v1"
+	// AdmissionVersionV1beta1 registers our webhooks with the admissionregistration.k8s.io/v1beta1 api, for clusters
+	// that are older than kubernetes 1.16, which only send v1beta1 AdmissionReviews.
+	AdmissionVersionV1beta1 = "v1beta1"
+)
+
+// v1DefaultTimeoutSeconds is how long the apiserver waits for a webhook registered with the v1 api when its
+// registration doesn't say, the v1beta1 api waits for maxTimeoutSeconds.
+const v1DefaultTimeoutSeconds = 10
+
+// ValidateAdmissionVersion checks that the admission version is either v1 or v1beta1.
+func ValidateAdmissionVersion(version string) error {
+	switch version {
+	case "", AdmissionVersionV1, AdmissionVersionV1beta1:
+		return nil
+	}
+	return fmt.Errorf("invalid server.admission-version '%s', must be either %s or %s", version, AdmissionVersionV1, AdmissionVersionV1beta1)
+}
+
+// SetAdmissionVersion sets the version of the admissionregistration api that our webhooks are registered with, v1
+// when it is empty.  It must be called before any rules are registered.
+func (s *Server) SetAdmissionVersion(version string) {
+	s.admissionVersion = version
+}
+
+// legacyRegistrations is true when our webhooks are registered with the v1beta1 api.
+func (s Server) legacyRegistrations() bool {
+	return s.admissionVersion == AdmissionVersionV1beta1
+}
+
+// admissionReviewVersions are the versions of AdmissionReview, in order of preference, that the apiserver can send us.
+// The handler understands both, but only v1 apiservers can send v1 reviews.
+func admissionReviewVersions(version string) []string {
+	if version == AdmissionVersionV1beta1 {
+		return []string{AdmissionVersionV1beta1}
+	}
+	return []string{AdmissionVersionV1, AdmissionVersionV1beta1}
+}
+
+// ValidateForAdmissionVersion checks that the registration's settings are allowed by the version of the
+// admissionregistration api, the v1 api only allows webhooks without side effects, or without them on dry runs.
+func (r Registration) ValidateForAdmissionVersion(version string) error {
+	if version == AdmissionVersionV1beta1 {
+		return nil
+	}
+	sideEffects, err := r.sideEffects()
+	if err != nil || sideEffects == nil {
+		return err
+	}
+	if *sideEffects != admissionreg.SideEffectClassNone && *sideEffects != admissionreg.SideEffectClassNoneOnDryRun {
+		return fmt.Errorf("rule '%s' has side-effects '%s', which is only allowed with server.admission-version %s, must be either None or NoneOnDryRun", r.Name, *sideEffects, AdmissionVersionV1beta1)
+	}
+	return nil
+}
+
+// defaultSettings fills in the settings that the registration left to the apiserver.  They are written into our
+// webhooks, rather than left for the apiserver to default, as its defaults depend on the api version, and the v1 api
+// requires side effects.  Side effects default to NoneOnDryRun, as rules can label related services, which is skipped
+// for dry runs.
+func (w *registeredWebhook) defaultSettings(version string) {
+	if version == AdmissionVersionV1beta1 {
+		return
+	}
+	if w.TimeoutSeconds == nil {
+		timeout := int32(v1DefaultTimeoutSeconds)
+		w.TimeoutSeconds = &timeout
+	}
+	if w.SideEffects == nil {
+		noneOnDryRun := admissionreg.SideEffectClassNoneOnDryRun
+		w.SideEffects = &noneOnDryRun
+	}
+}
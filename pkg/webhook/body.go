@@ -0,0 +1,151 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog"
+	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// OversizedAllow allows the objects in admission requests that are too large to read without changing them.
+	OversizedAllow = "allow"
+	// OversizedDeny denies the objects in admission requests that are too large to read.
+	OversizedDeny = "deny"
+
+	// uidPrefixSize is how much of an oversized request is kept to find its uid, which comes before the objects.
+	uidPrefixSize = 4096
+)
+
+// ValidateOversizedAction checks that the action for oversized admission requests is either allow or deny.
+func ValidateOversizedAction(action string) error {
+	switch action {
+	case "", OversizedAllow, OversizedDeny:
+		return nil
+	}
+	return fmt.Errorf("invalid server.oversized-requests '%s', must be either %s or %s", action, OversizedAllow, OversizedDeny)
+}
+
+// prefixWriter keeps the first limit bytes written to it and discards the rest.
+type prefixWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if room := p.limit - p.Len(); room > 0 {
+		if len(b) > room {
+			p.Buffer.Write(b[:room])
+		} else {
+			p.Buffer.Write(b)
+		}
+	}
+	return len(b), nil
+}
+
+// uidFromPrefix finds the request uid in the start of an AdmissionReview, without needing the rest of it.
+func uidFromPrefix(prefix []byte) (types.UID, bool) {
+	d := json.NewDecoder(bytes.NewReader(prefix))
+	if !enterObject(d) {
+		return "", false
+	}
+	if !findKey(d, "request") || !enterObject(d) || !findKey(d, "uid") {
+		return "", false
+	}
+	var uid string
+	if err := d.Decode(&uid); err != nil || uid == "" {
+		return "", false
+	}
+	return types.UID(uid), true
+}
+
+// apiVersionFromPrefix finds the apiVersion of an AdmissionReview when it comes before the request, as it does in the
+// reviews sent by the apiserver, it is empty when it can't be found or isn't supported.
+func apiVersionFromPrefix(prefix []byte) string {
+	d := json.NewDecoder(bytes.NewReader(prefix))
+	if !enterObject(d) || !findKey(d, "apiVersion") {
+		return ""
+	}
+	var apiVersion string
+	if err := d.Decode(&apiVersion); err != nil || !supportedReviewVersion(apiVersion) {
+		return ""
+	}
+	return apiVersion
+}
+
+// enterObject reads the opening brace of a json object.
+func enterObject(d *json.Decoder) bool {
+	t, err := d.Token()
+	return err == nil && t == json.Delim('{')
+}
+
+// findKey skips the members of the current json object until the key, leaving the decoder at its value.
+func findKey(d *json.Decoder, key string) bool {
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return false
+		}
+		if t == key {
+			return true
+		}
+		var skip json.RawMessage
+		if err := d.Decode(&skip); err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// writeOversized answers an admission request that is too large to read, allowing or denying its object as
+// configured.  The response needs the request's uid, without it the request is rejected with a 413.
+func (h graffitiHandler) writeOversized(w http.ResponseWriter, prefix []byte, reqLog zerolog.Logger) {
+	uid, ok := uidFromPrefix(prefix)
+	if !ok {
+		reqLog.Warn().Int64("max-request-size", h.maxBodySize).Msg("admission request is too large and has no uid that can be answered")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, `admission request too large`)
+		return
+	}
+
+	allowed := h.oversized != OversizedDeny
+	reqLog.Warn().Int64("max-request-size", h.maxBodySize).Str("uid", string(uid)).Bool("allowed", allowed).Msg("admission request is too large, answering without reading it")
+	response := newAdmissionReview(apiVersionFromPrefix(prefix))
+	response.Response = &admissionResponse{AdmissionResponse: &admission.AdmissionResponse{
+		UID:     uid,
+		Allowed: allowed,
+		Result: &metav1.Status{
+			Reason:  metav1.StatusReasonRequestEntityTooLarge,
+			Message: fmt.Sprintf("admission request is larger than the %d byte limit", h.maxBodySize),
+		},
+	}}
+	resp, err := json.Marshal(response)
+	if err != nil {
+		reqLog.Error().Err(err).Msg("failed to marshal AdmissionReview response")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(resp); err != nil {
+		reqLog.Error().Err(err).Msg("failed to write the http response")
+	}
+}
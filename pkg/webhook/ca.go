@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+)
+
+// LoadCABundle parses a ca file, which may contain several CAs and intermediate certificates as a series of PEM blocks,
+// and returns just the certificates re-encoded as a clean bundle suitable for a webhook registration's caBundle.
+func LoadCABundle(data []byte) ([]byte, error) {
+	certs, err := parseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ca bundle: %v", err)
+	}
+	var bundle bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return bundle.Bytes(), nil
+}
+
+// ServiceDNSName is the name that the apiserver expects our serving certificate to be valid for.
+func ServiceDNSName(service, namespace string) string {
+	return service + "." + namespace + ".svc"
+}
+
+// VerifyServingCert checks that the serving certificate at certPath, along with any intermediates that follow it in the
+// file, is valid for dnsName and is trusted by the ca bundle that we register, in the same way that the apiserver will
+// verify it when calling our webhooks.  A mismatch is otherwise only seen as failed calls in the apiserver's logs.
+func VerifyServingCert(caBundle []byte, certPath, dnsName string) error {
+	mylog := log.ComponentLogger(componentName, "VerifyServingCert")
+	data, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("failed to read serving certificate: %v", err)
+	}
+	chain, err := parseCertificates(data)
+	if err != nil {
+		return fmt.Errorf("invalid serving certificate %s: %v", certPath, err)
+	}
+	cas, err := parseCertificates(caBundle)
+	if err != nil {
+		return fmt.Errorf("invalid ca bundle: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	for _, ca := range cas {
+		roots.AddCert(ca)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	leaf := chain[0]
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       dnsName,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		mylog.Error().Err(err).Str("subject", leaf.Subject.String()).Str("issuer", leaf.Issuer.String()).Strs("cas", subjects(cas)).Msg("serving certificate failed verification")
+		return fmt.Errorf("serving certificate '%s' issued by '%s' failed verification for %s against the ca bundle containing [%s]: %v",
+			leaf.Subject, leaf.Issuer, dnsName, strings.Join(subjects(cas), "; "), err)
+	}
+	mylog.Info().Str("subject", leaf.Subject.String()).Str("dns-name", dnsName).Msg("serving certificate is trusted by the ca bundle")
+	return nil
+}
+
+// parseCertificates returns all of the certificates in a series of PEM blocks, in order.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for i := 1; ; i++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("pem block %d is a %s, not a CERTIFICATE", i, block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("pem block %d: %v", i, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM encoded certificates found")
+	}
+	return certs, nil
+}
+
+func subjects(certs []*x509.Certificate) []string {
+	var result []string
+	for _, cert := range certs {
+		result = append(result, cert.Subject.String())
+	}
+	return result
+}
@@ -0,0 +1,124 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func (c testCert) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed one when parent is nil.
+func newTestCert(t *testing.T, cn string, isCA bool, parent *testCert, dnsNames ...string) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testCert{cert: cert, key: key}
+}
+
+func writeTestFile(t *testing.T, data []byte) string {
+	dir, err := ioutil.TempDir("", "graffiti-ca")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "cert.pem")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestLoadCABundleKeepsEveryCertificate(t *testing.T) {
+	root := newTestCert(t, "root", true, nil)
+	intermediate := newTestCert(t, "intermediate", true, &root)
+	data := append([]byte("some leading comment\n"), intermediate.pem()...)
+	data = append(data, root.pem()...)
+
+	bundle, err := LoadCABundle(data)
+	require.NoError(t, err)
+	assert.Equal(t, append(intermediate.pem(), root.pem()...), bundle)
+
+	_, err = LoadCABundle([]byte("not a certificate"))
+	assert.EqualError(t, err, "invalid ca bundle: no PEM encoded certificates found")
+	_, err = LoadCABundle(append(root.pem(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte("key")})...))
+	assert.EqualError(t, err, "invalid ca bundle: pem block 2 is a EC PRIVATE KEY, not a CERTIFICATE")
+}
+
+func TestVerifyServingCertWithIntermediates(t *testing.T) {
+	dnsName := ServiceDNSName("kube-graffiti", "kube-graffiti")
+	root := newTestCert(t, "root", true, nil)
+	intermediate := newTestCert(t, "intermediate", true, &root)
+	leaf := newTestCert(t, "kube-graffiti", false, &intermediate, dnsName)
+
+	// the intermediate can be served after the leaf...
+	chainPath := writeTestFile(t, append(leaf.pem(), intermediate.pem()...))
+	assert.NoError(t, VerifyServingCert(root.pem(), chainPath, dnsName))
+
+	// ...or be included in the ca bundle
+	leafPath := writeTestFile(t, leaf.pem())
+	assert.NoError(t, VerifyServingCert(append(intermediate.pem(), root.pem()...), leafPath, dnsName))
+	assert.Error(t, VerifyServingCert(root.pem(), leafPath, dnsName), "the chain is incomplete without the intermediate")
+}
+
+func TestVerifyServingCertReportsMismatches(t *testing.T) {
+	dnsName := ServiceDNSName("kube-graffiti", "kube-graffiti")
+	root := newTestCert(t, "root", true, nil)
+	other := newTestCert(t, "other-root", true, nil)
+	leaf := newTestCert(t, "kube-graffiti", false, &root, dnsName)
+	leafPath := writeTestFile(t, leaf.pem())
+
+	err := VerifyServingCert(other.pem(), leafPath, dnsName)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "serving certificate 'CN=kube-graffiti' issued by 'CN=root' failed verification for kube-graffiti.kube-graffiti.svc against the ca bundle containing [CN=other-root]")
+
+	err = VerifyServingCert(root.pem(), leafPath, ServiceDNSName("graffiti", "default"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "graffiti.default.svc")
+}
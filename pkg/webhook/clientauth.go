@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ClientAuth requires the callers of our webhooks to present a client certificate signed by the CA at CAPath, which the
+// apiserver does when its admission configuration gives it one for our webhooks, so that nothing else in the cluster
+// can spoof its admission requests.  AllowedNames also restricts the common or dns names of the certificates that are
+// accepted, any certificate signed by the CA is accepted when it is empty.
+type ClientAuth struct {
+	CAPath       string   `mapstructure:"ca-path" yaml:"ca-path,omitempty"`
+	AllowedNames []string `mapstructure:"allowed-names" yaml:"allowed-names,omitempty"`
+}
+
+// Enabled is true when callers must present a client certificate.
+func (a ClientAuth) Enabled() bool {
+	return a.CAPath != ""
+}
+
+// Validate checks that the allowed names are only given along with the CA.
+func (a ClientAuth) Validate() error {
+	if len(a.AllowedNames) > 0 && !a.Enabled() {
+		return errors.New("server.client-auth.allowed-names needs a ca-path to verify client certificates against")
+	}
+	return nil
+}
+
+// Apply makes a tls config require and verify client certificates, when client authentication is enabled.
+func (a ClientAuth) Apply(config *tls.Config) error {
+	if !a.Enabled() {
+		return nil
+	}
+	pem, err := ioutil.ReadFile(a.CAPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the client-auth ca: %v", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in the client-auth ca %s", a.CAPath)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if len(a.AllowedNames) > 0 {
+		config.VerifyPeerCertificate = a.verifyName
+	}
+	return nil
+}
+
+// verifyName rejects a verified client certificate whose common name and dns names aren't any of the allowed names.
+func (a ClientAuth) verifyName(_ [][]byte, chains [][]*x509.Certificate) error {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return errors.New("a verified client certificate is required")
+	}
+	cert := chains[0][0]
+	for _, allowed := range a.AllowedNames {
+		if cert.Subject.CommonName == allowed {
+			return nil
+		}
+		for _, name := range cert.DNSNames {
+			if name == allowed {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate '%s' is not one of the allowed names", cert.Subject.CommonName)
+}
+
+// SetClientAuth requires the callers of the webhook server, and of its listeners, to present a client certificate.
+// It must be called before the server is started.
+func (s *Server) SetClientAuth(a ClientAuth) error {
+	if err := a.Apply(s.httpServer.TLSConfig); err != nil {
+		return err
+	}
+	for _, l := range s.listeners {
+		if err := a.Apply(l.httpServer.TLSConfig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
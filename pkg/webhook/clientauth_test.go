@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientAuthRejectsCallersWithoutAnAllowedCertificate(t *testing.T) {
+	ca := newTestCert(t, "apiserver-ca", true, nil)
+	apiserver := newTestCert(t, "kube-apiserver", false, &ca)
+	intruder := newTestCert(t, "intruder", false, &ca)
+	stranger := newTestCert(t, "kube-apiserver", true, nil)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{}
+	require.NoError(t, ClientAuth{CAPath: writeTestFile(t, ca.pem()), AllowedNames: []string{"kube-apiserver"}}.Apply(server.TLS))
+	server.StartTLS()
+	defer server.Close()
+
+	call := func(cert *testCert) error {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}}
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	assert.NoError(t, call(&apiserver))
+	assert.Error(t, call(nil), "callers must present a certificate")
+	assert.Error(t, call(&intruder), "the certificate must have an allowed name")
+	assert.Error(t, call(&stranger), "the certificate must be signed by the ca")
+}
+
+func TestClientAuthIsValidated(t *testing.T) {
+	assert.NoError(t, ClientAuth{}.Validate())
+	assert.False(t, ClientAuth{}.Enabled())
+	assert.EqualError(t, ClientAuth{AllowedNames: []string{"kube-apiserver"}}.Validate(), "server.client-auth.allowed-names needs a ca-path to verify client certificates against")
+
+	config := &tls.Config{}
+	require.NoError(t, ClientAuth{}.Apply(config))
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth, "client certificates aren't required by default")
+	notACert := writeTestFile(t, []byte("not a certificate"))
+	assert.EqualError(t, ClientAuth{CAPath: notACert}.Apply(config), "no certificates found in the client-auth ca "+notACert)
+}
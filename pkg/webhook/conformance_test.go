@@ -0,0 +1,36 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/conformance"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+)
+
+func TestHandlerConforms(t *testing.T) {
+	handler := newGraffitiHandler()
+	handler.addRule("/graffiti/add-label", graffiti.NewRule("add-label").AddLabels(map[string]string{"painted": "true"}).Compiled())
+	handler.addRule("/graffiti-validate/deny-apps", validatingRule{graffiti.NewRule("deny-apps").MatchLabels("app").Validating()})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	for _, path := range []string{"/graffiti/add-label", "/graffiti-validate/deny-apps", "/graffiti/not-a-rule"} {
+		t.Run(path, func(t *testing.T) {
+			conformance.Test(t, srv.Client(), srv.URL+path)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	admission "k8s.io/api/admission/v1beta1"
+)
+
+const (
+	// DebugHeader is the request header that asks for the evaluation of the rule to be explained, its value must be
+	// the server's debug token.
+	DebugHeader = "X-Graffiti-Debug"
+	// RuleHeader, MatchedHeader, StoppedByHeader and ExplainHeader are the response headers of a debug request: the
+	// rule served on the path, whether it matched the object, the stop-on-match rule that skipped it, and the
+	// evaluation of each of its selectors as json.
+	RuleHeader      = "X-Graffiti-Rule"
+	MatchedHeader   = "X-Graffiti-Matched"
+	StoppedByHeader = "X-Graffiti-Stopped-By"
+	ExplainHeader   = "X-Graffiti-Explain"
+)
+
+// debugging is true when the request carries the debug token, debugging is disabled when there is no token.
+func (h graffitiHandler) debugging(r *http.Request) bool {
+	token := r.Header.Get(DebugHeader)
+	return h.debugToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.debugToken)) == 1
+}
+
+// explain sets the debug response headers for a request reviewed by the mutator, or skipped because a stop-on-match
+// rule matched first.  They must be set before the response is written.
+func explain(w http.ResponseWriter, path string, mutator graffitiMutator, req *admission.AdmissionRequest, stoppedBy string) {
+	w.Header().Set(RuleHeader, nameFromPath(path))
+	if stoppedBy != "" {
+		w.Header().Set(StoppedByHeader, stoppedBy)
+	}
+	var rule graffiti.Rule
+	switch m := mutator.(type) {
+	case graffiti.Rule:
+		rule = m
+	case validatingRule:
+		rule = m.rule
+	default:
+		return
+	}
+	if req == nil {
+		return
+	}
+	e := rule.ExplainAdmission(req)
+	w.Header().Set(MatchedHeader, strconv.FormatBool(e.Matched))
+	if explanation, err := json.Marshal(e); err == nil {
+		w.Header().Set(ExplainHeader, string(explanation))
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	admission "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultDecisionCacheTTL is how long a cached decision is used for when the decision cache doesn't set a ttl.
+const DefaultDecisionCacheTTL = 30 * time.Second
+
+// DecisionCache caches the responses of rules to admission requests, so that reinvocations and storms of updates that
+// don't change an object skip evaluating the rules' matchers and payloads again.
+type DecisionCache struct {
+	// Size is the most decisions that are cached, the least recently used are dropped first, 0 disables the cache.
+	Size int `mapstructure:"size" yaml:"size,omitempty"`
+	// TTL is how long a decision is used for, so that decisions that depend on the object's namespace, an external
+	// matcher or the time are made again.
+	TTL time.Duration `mapstructure:"ttl" yaml:"ttl,omitempty"`
+}
+
+// Validate checks that the size and ttl of the decision cache are usable.
+func (c DecisionCache) Validate() error {
+	if c.Size < 0 || c.TTL < 0 {
+		return fmt.Errorf("server.decision-cache size and ttl can not be negative")
+	}
+	return nil
+}
+
+// decisionCache is a least recently used cache of the responses of rules, keyed by the hash of the rule's path and
+// everything in the admission request that a rule could decide on.  A nil cache never caches anything.
+type decisionCache struct {
+	sync.Mutex
+	size      int
+	ttl       time.Duration
+	order     *list.List
+	decisions map[[sha256.Size]byte]*list.Element
+}
+
+type cachedResponse struct {
+	key      [sha256.Size]byte
+	response admission.AdmissionResponse
+	expires  time.Time
+}
+
+// decisionKey is what a decision is keyed by.  The whole of the object, and old object, is used rather than just its
+// resource version, as the apiserver hasn't given a new resource version to the objects of create and update requests.
+type decisionKey struct {
+	Path        string                      `json:"path"`
+	Operation   admission.Operation         `json:"operation"`
+	Kind        metav1.GroupVersionKind     `json:"kind"`
+	Resource    metav1.GroupVersionResource `json:"resource"`
+	SubResource string                      `json:"subResource"`
+	Namespace   string                      `json:"namespace"`
+	Name        string                      `json:"name"`
+	UserInfo    authenticationv1.UserInfo   `json:"userInfo"`
+	DryRun      bool                        `json:"dryRun"`
+	Object      []byte                      `json:"object"`
+	OldObject   []byte                      `json:"oldObject"`
+}
+
+func newDecisionCache(c DecisionCache) *decisionCache {
+	if c.Size == 0 {
+		return nil
+	}
+	if c.TTL == 0 {
+		c.TTL = DefaultDecisionCacheTTL
+	}
+	return &decisionCache{size: c.Size, ttl: c.TTL, order: list.New(), decisions: make(map[[sha256.Size]byte]*list.Element)}
+}
+
+// decide returns the cached response of the rule on the path to the request, or asks the mutator for it and caches it.
+// Responses that failed aren't cached, so that the rule is asked again.
+func (c *decisionCache) decide(path string, mutator graffitiMutator, req *admission.AdmissionRequest) *admission.AdmissionResponse {
+	if c == nil {
+		return mutator.MutateAdmission(req)
+	}
+	if rule, ok := mutator.(graffiti.Rule); ok && !rule.Cacheable() {
+		// every request must be given its own generated values, uid and times, even when its object is the same
+		return mutator.MutateAdmission(req)
+	}
+	key, err := requestKey(path, req)
+	if err != nil {
+		return mutator.MutateAdmission(req)
+	}
+	if response, ok := c.get(key, time.Now()); ok {
+		metrics.CachedDecisions.WithLabelValues(nameFromPath(path), metrics.ResultHit).Inc()
+		return response
+	}
+	metrics.CachedDecisions.WithLabelValues(nameFromPath(path), metrics.ResultMiss).Inc()
+	response := mutator.MutateAdmission(req)
+	if response != nil && !failed(response) {
+		c.put(key, *response, time.Now())
+	}
+	return response
+}
+
+// requestKey is the sha256 of the rule's path and the parts of the request that a rule could decide on.
+func requestKey(path string, req *admission.AdmissionRequest) ([sha256.Size]byte, error) {
+	b, err := json.Marshal(decisionKey{
+		Path:        path,
+		Operation:   req.Operation,
+		Kind:        req.Kind,
+		Resource:    req.Resource,
+		SubResource: req.SubResource,
+		Namespace:   req.Namespace,
+		Name:        req.Name,
+		UserInfo:    req.UserInfo,
+		DryRun:      isDryRun(req),
+		Object:      req.Object.Raw,
+		OldObject:   req.OldObject.Raw,
+	})
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+// get returns a copy of the cached response, as the handler sets the uid of the request on the response it writes.
+func (c *decisionCache) get(key [sha256.Size]byte, now time.Time) (*admission.AdmissionResponse, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := c.decisions[key]
+	if !ok {
+		return nil, false
+	}
+	cached := e.Value.(*cachedResponse)
+	if !now.Before(cached.expires) {
+		c.order.Remove(e)
+		delete(c.decisions, key)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	response := cached.response
+	return &response, true
+}
+
+// put caches a response, dropping the least recently used response when the cache is full.
+func (c *decisionCache) put(key [sha256.Size]byte, response admission.AdmissionResponse, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.decisions[key]; ok {
+		e.Value = &cachedResponse{key: key, response: response, expires: now.Add(c.ttl)}
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.decisions, oldest.Value.(*cachedResponse).key)
+	}
+	c.decisions[key] = c.order.PushFront(&cachedResponse{key: key, response: response, expires: now.Add(c.ttl)})
+}
+
+// reset forgets every cached decision, which must be done whenever the rules change.
+func (c *decisionCache) reset() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.order.Init()
+	c.decisions = make(map[[sha256.Size]byte]*list.Element)
+}
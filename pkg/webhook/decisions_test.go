@@ -0,0 +1,134 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func decisionReview(uid, team string) string {
+	return fmt.Sprintf(`{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","request":{"uid":"%s","kind":{"group":"","version":"v1","kind":"Namespace"},"resource":{"group":"","version":"v1","resource":"namespaces"},"name":"test-namespace","operation":"UPDATE","userInfo":{"username":"minikube-user"},"object":{"metadata":{"name":"test-namespace","labels":{"team":"%s"}}},"oldObject":null}}`, uid, team)
+}
+
+func serveDecision(t *testing.T, h graffitiHandler, body string) admissionReview {
+	req, err := http.NewRequest("POST", "/graffiti/test-rule", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var review admissionReview
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &review))
+	return review
+}
+
+func TestDecisionsAreCachedUntilTheObjectOrRulesChange(t *testing.T) {
+	fake := new(mockMutator)
+	fake.On("MutateAdmission", mock.AnythingOfType("*v1beta1.AdmissionRequest")).Return(&admission.AdmissionResponse{
+		Allowed: true,
+		Patch:   []byte(`[ { "op": "add", "path": "/metadata/annotations", "value": { "a": "b" }} ]`),
+	})
+	handler := newGraffitiHandler()
+	handler.decisions = newDecisionCache(DecisionCache{Size: 10})
+	handler.addRule("/graffiti/test-rule", fake)
+
+	first := serveDecision(t, handler, decisionReview("uid-1", "a"))
+	second := serveDecision(t, handler, decisionReview("uid-2", "a"))
+	fake.AssertNumberOfCalls(t, "MutateAdmission", 1)
+	assert.Equal(t, first.Response.Patch, second.Response.Patch, "the cached patch should be reused")
+	assert.Equal(t, "uid-2", string(second.Response.UID), "each response should carry the uid of its own request")
+
+	serveDecision(t, handler, decisionReview("uid-3", "b"))
+	fake.AssertNumberOfCalls(t, "MutateAdmission", 2)
+
+	handler.addRule("/graffiti/test-rule", fake)
+	serveDecision(t, handler, decisionReview("uid-4", "a"))
+	fake.AssertNumberOfCalls(t, "MutateAdmission", 3)
+}
+
+func TestFailedDecisionsAreNotCached(t *testing.T) {
+	fake := new(mockMutator)
+	fake.On("MutateAdmission", mock.AnythingOfType("*v1beta1.AdmissionRequest")).Return(&admission.AdmissionResponse{
+		Allowed: false,
+		Result:  &metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonInternalError, Message: "failed"},
+	})
+	c := newDecisionCache(DecisionCache{Size: 10})
+	req := &admission.AdmissionRequest{UID: "uid", Operation: admission.Create}
+	c.decide("/graffiti/test-rule", fake, req)
+	c.decide("/graffiti/test-rule", fake, req)
+	fake.AssertNumberOfCalls(t, "MutateAdmission", 2)
+}
+
+func TestDecisionsOfRulesThatGenerateValuesAreNotCached(t *testing.T) {
+	handler := newGraffitiHandler()
+	handler.decisions = newDecisionCache(DecisionCache{Size: 10})
+	handler.addRule("/graffiti/test-rule", graffiti.NewRule("test-rule").AddAnnotations(map[string]string{"id": "{{ uuid }}"}))
+
+	first := serveDecision(t, handler, decisionReview("uid-1", "a"))
+	second := serveDecision(t, handler, decisionReview("uid-2", "a"))
+	require.NotEmpty(t, first.Response.Patch)
+	assert.NotEqual(t, first.Response.Patch, second.Response.Patch, "each object should get its own generated value")
+}
+
+func TestDecisionsOfRulesThatRecordTheRequestAreNotCached(t *testing.T) {
+	handler := newGraffitiHandler()
+	handler.decisions = newDecisionCache(DecisionCache{Size: 10})
+	rule := graffiti.NewRule("test-rule").AddAnnotations(map[string]string{"painted": "true"})
+	rule.Payload.RecordProvenance = []string{graffiti.ProvenanceUID}
+	handler.addRule("/graffiti/test-rule", rule)
+
+	first := serveDecision(t, handler, decisionReview("uid-1", "a"))
+	second := serveDecision(t, handler, decisionReview("uid-2", "a"))
+	assert.Contains(t, string(first.Response.Patch), "uid-1")
+	assert.Contains(t, string(second.Response.Patch), "uid-2", "each object should record the uid of its own request")
+}
+
+func TestDecisionCacheDropsTheLeastRecentlyUsedAndExpired(t *testing.T) {
+	now := time.Now()
+	c := newDecisionCache(DecisionCache{Size: 2, TTL: time.Minute})
+	a, b, d := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b")), sha256.Sum256([]byte("d"))
+	c.put(a, admission.AdmissionResponse{UID: "a"}, now)
+	c.put(b, admission.AdmissionResponse{UID: "b"}, now)
+	_, ok := c.get(a, now)
+	require.True(t, ok)
+	c.put(d, admission.AdmissionResponse{UID: "d"}, now)
+
+	_, ok = c.get(b, now)
+	assert.False(t, ok, "b was the least recently used")
+	response, ok := c.get(a, now)
+	require.True(t, ok)
+	assert.Equal(t, "a", string(response.UID))
+	_, ok = c.get(d, now.Add(time.Minute))
+	assert.False(t, ok, "decisions expire after the ttl")
+}
+
+func TestDecisionCacheIsOptional(t *testing.T) {
+	assert.Nil(t, newDecisionCache(DecisionCache{}), "a size of 0 disables the cache")
+	assert.NoError(t, DecisionCache{Size: 1000, TTL: time.Minute}.Validate())
+	assert.EqualError(t, DecisionCache{Size: -1}.Validate(), "server.decision-cache size and ttl can not be negative")
+}
@@ -0,0 +1,149 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// ErrTargetsNotServed is returned when registrations target api groups, versions or resources that the apiserver
+// doesn't serve.
+var ErrTargetsNotServed = errors.New("the apiserver doesn't serve what these registrations target")
+
+// ResourceDiscoverer is the part of the kubernetes discovery client that lists the resources the apiserver serves.
+type ResourceDiscoverer interface {
+	ServerResources() ([]*metav1.APIResourceList, error)
+}
+
+// servedResources are the resources, including subresources such as 'pods/status', that the apiserver serves along with
+// the group versions that couldn't be discovered, which might serve anything.
+type servedResources struct {
+	resources []metav1.GroupVersionResource
+	unknown   []schema.GroupVersion
+}
+
+// CheckTargetsServed uses discovery to check that the apiserver serves the api groups, versions and resources that the
+// registrations target, so that webhooks aren't registered for kinds that don't exist, e.g. because of a typo or a
+// missing CRD.  Wildcards only need to match something, and group versions that fail discovery are given the benefit
+// of the doubt.
+func CheckTargetsServed(d ResourceDiscoverer, registrations []Registration) error {
+	lists, err := d.ServerResources()
+	var served servedResources
+	if err != nil {
+		var failed *discovery.ErrGroupDiscoveryFailed
+		if !errors.As(err, &failed) {
+			return fmt.Errorf("failed to discover the resources served by the apiserver: %v", err)
+		}
+		for gv := range failed.Groups {
+			served.unknown = append(served.unknown, gv)
+		}
+	}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, resource := range list.APIResources {
+			served.resources = append(served.resources, metav1.GroupVersionResource{Group: gv.Group, Version: gv.Version, Resource: resource.Name})
+		}
+	}
+
+	var problems []string
+	for _, r := range registrations {
+		if missing := served.missing(r); len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("rule '%s' targets %s", r.Name, strings.Join(missing, ", ")))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrTargetsNotServed, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// missing describes each of the registration's api groups, versions and resources that the apiserver doesn't serve.
+// The versions of a missing group, and the resources of a missing version, aren't described as well.
+func (s servedResources) missing(r Registration) []string {
+	var missing []string
+	for _, t := range r.AllTargets() {
+		var groups, versions, resources []string
+		for _, group := range t.APIGroups {
+			if group != "*" && !s.serves(Target{APIGroups: []string{group}, APIVersions: []string{"*"}}, "*/*") {
+				groups = append(groups, fmt.Sprintf("api group '%s'", group))
+			}
+		}
+		for _, version := range t.APIVersions {
+			if version != "*" && !s.serves(Target{APIGroups: t.APIGroups, APIVersions: []string{version}}, "*/*") {
+				versions = append(versions, fmt.Sprintf("version '%s' of api groups %v", version, t.APIGroups))
+			}
+		}
+		for _, resource := range t.Resources {
+			if !s.serves(t, resource) {
+				resources = append(resources, fmt.Sprintf("resource '%s' in api groups %v versions %v", resource, t.APIGroups, t.APIVersions))
+			}
+		}
+		switch {
+		case len(groups) > 0:
+			missing = append(missing, groups...)
+		case len(versions) > 0:
+			missing = append(missing, versions...)
+		default:
+			missing = append(missing, resources...)
+		}
+	}
+	return missing
+}
+
+// serves is true when a resource within the target's groups and versions is covered by the target resource, or when
+// one of the target's group versions couldn't be discovered.
+func (s servedResources) serves(t Target, resource string) bool {
+	for _, gv := range s.unknown {
+		if targetListCovers(t.APIGroups, gv.Group) && targetListCovers(t.APIVersions, gv.Version) {
+			return true
+		}
+	}
+	for _, res := range s.resources {
+		if targetListCovers(t.APIGroups, res.Group) && targetListCovers(t.APIVersions, res.Version) && resourceCovers(resource, res.Resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceCovers is true when a target's resource matches a served resource the way the apiserver matches them: '*'
+// is all resources but not their subresources, '*/*' is everything, and 'pods/*' or '*/status' match subresources.
+func resourceCovers(target, served string) bool {
+	if target == "*/*" {
+		return true
+	}
+	tResource, tSub := splitSubresource(target)
+	sResource, sSub := splitSubresource(served)
+	if tResource != "*" && tResource != sResource {
+		return false
+	}
+	return tSub == sSub || (tSub == "*" && sSub != "")
+}
+
+func splitSubresource(resource string) (string, string) {
+	parts := strings.SplitN(resource, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
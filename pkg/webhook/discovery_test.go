@@ -0,0 +1,78 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+type fakeDiscoverer struct {
+	lists []*metav1.APIResourceList
+	err   error
+}
+
+func (f fakeDiscoverer) ServerResources() ([]*metav1.APIResourceList, error) {
+	return f.lists, f.err
+}
+
+var servedLists = []*metav1.APIResourceList{
+	{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods"}, {Name: "pods/status"}, {Name: "services"}, {Name: "namespaces"}}},
+	{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{{Name: "deployments"}, {Name: "deployments/scale"}}},
+}
+
+func TestCheckTargetsServed(t *testing.T) {
+	d := fakeDiscoverer{lists: servedLists}
+	for _, r := range []Registration{
+		{Name: "shorthand", Resources: []string{"pods", "deployments.apps", "deployments.v1.apps"}},
+		{Name: "wildcards", Targets: []Target{{APIGroups: []string{"*"}, APIVersions: []string{"*"}, Resources: []string{"*"}}}},
+		{Name: "subresources", Targets: []Target{{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"pods/status", "*/status", "pods/*"}}}},
+		{Name: "everything", Targets: []Target{{APIGroups: []string{"apps"}, APIVersions: []string{"v1"}, Resources: []string{"*/*"}}}},
+		{Name: "mixed groups", Targets: []Target{{APIGroups: []string{"", "apps"}, APIVersions: []string{"v1"}, Resources: []string{"pods", "deployments"}}}},
+	} {
+		assert.NoError(t, CheckTargetsServed(d, []Registration{r}), r.Name)
+	}
+
+	err := CheckTargetsServed(d, []Registration{
+		{Name: "typo", Resources: []string{"deployment.apps"}},
+		{Name: "missing-crd", Resources: []string{"certificates.cert-manager.io"}},
+		{Name: "old-version", Resources: []string{"deployments.v1beta2.apps"}},
+		{Name: "fine", Resources: []string{"services"}},
+	})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrTargetsNotServed))
+	assert.Contains(t, err.Error(), "rule 'typo' targets resource 'deployment' in api groups [apps] versions [*]")
+	assert.Contains(t, err.Error(), "rule 'missing-crd' targets api group 'cert-manager.io'")
+	assert.Contains(t, err.Error(), "rule 'old-version' targets version 'v1beta2' of api groups [apps]")
+	assert.NotContains(t, err.Error(), "'fine'")
+}
+
+func TestCheckTargetsServedTrustsGroupsThatFailDiscovery(t *testing.T) {
+	d := fakeDiscoverer{
+		lists: servedLists,
+		err:   &discovery.ErrGroupDiscoveryFailed{Groups: map[schema.GroupVersion]error{{Group: "metrics.k8s.io", Version: "v1beta1"}: errors.New("unavailable")}},
+	}
+	assert.NoError(t, CheckTargetsServed(d, []Registration{{Name: "metrics", Resources: []string{"pods.metrics.k8s.io"}}}))
+	assert.Error(t, CheckTargetsServed(d, []Registration{{Name: "missing-crd", Resources: []string{"certificates.cert-manager.io"}}}))
+
+	err := CheckTargetsServed(fakeDiscoverer{err: errors.New("connection refused")}, nil)
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, ErrTargetsNotServed), "failing to reach the apiserver isn't a configuration error")
+}
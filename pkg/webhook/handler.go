@@ -15,20 +15,70 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
-	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/Telefonica/kube-graffiti/pkg/audit"
+	"github.com/Telefonica/kube-graffiti/pkg/events"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/healthcheck"
 	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/Telefonica/kube-graffiti/pkg/queue"
+	"github.com/Telefonica/kube-graffiti/pkg/related"
 	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // graffitHandler contains the context needed within our http handler without using global variables
 // It satisfies the http.Handler interface
 type graffitiHandler struct {
-	tagmap map[string]graffitiMutator
+	tagmap   map[string]graffitiMutator
+	auditor  *audit.Auditor
+	events   *events.Recorder
+	services *related.ServiceLabeller
+	actions  *queue.Queue
+	limiter  *sourceLimiter
+	// slots limits the number of requests served at once when it is not nil, a request waits up to timeout for one.
+	slots   chan struct{}
+	timeout time.Duration
+	// maxBodySize limits the size of the admission requests that are read, 0 is unlimited, and oversized is whether
+	// the objects in larger requests are allowed or denied.
+	maxBodySize int64
+	oversized   string
+	// ruleErrors is what happens to the objects of requests that a rule fails to evaluate or patch.
+	ruleErrors string
+	// debugToken is the value of the debug header that asks for the evaluation of a rule to be explained in the
+	// response headers, there is no explaining when it is empty.
+	debugToken string
+	// ignore lists the namespaces and names of the objects that are allowed unchanged, without asking any rule.
+	ignore graffiti.Ignore
+	// watchNamespaces are the only namespaces whose objects, and the namespaces themselves, the rules are asked about
+	// when it is set, the objects of other namespaces and cluster scoped objects are allowed unchanged.
+	watchNamespaces []string
+	// stoppers are the rules with stop-on-match, which skip the rules after them for the objects that they match.
+	stoppers map[string]stopper
+	// rules guards tagmap, stoppers and served, which change when rules are added or removed while serving.
+	rules *sync.RWMutex
+	// served are the paths that the mux hands to us, the mux can only be given each path once.
+	served map[string]bool
+	// decisions caches the responses of the rules, it is nil when decisions aren't cached.
+	decisions *decisionCache
+}
+
+// stopper is a mutating rule with stop-on-match and the targets that it is registered for, all targets when it has none.
+type stopper struct {
+	rule    graffiti.Rule
+	targets []Target
 }
 
 // graffitiMutator interface allows us to mock out for testing.
@@ -36,31 +86,93 @@ type graffitiMutator interface {
 	MutateAdmission(req *admission.AdmissionRequest) *admission.AdmissionResponse
 }
 
+// validatingRule adapts a validating graffiti rule so that it can be added to the handler's tagmap.
+type validatingRule struct {
+	rule graffiti.Rule
+}
+
+// MutateAdmission only ever allows or denies the object, it never patches it.
+func (v validatingRule) MutateAdmission(req *admission.AdmissionRequest) *admission.AdmissionResponse {
+	return v.rule.ValidateAdmission(req)
+}
+
 func newGraffitiHandler() graffitiHandler {
 	return graffitiHandler{
-		tagmap: make(map[string]graffitiMutator),
+		tagmap:   make(map[string]graffitiMutator),
+		stoppers: make(map[string]stopper),
+		rules:    &sync.RWMutex{},
+		served:   make(map[string]bool),
 	}
 }
 
 // addRule allows us to add rules to a handler without relying on its implementation
 func (h graffitiHandler) addRule(path string, rule graffitiMutator) {
+	h.rules.Lock()
+	defer h.rules.Unlock()
 	h.tagmap[path] = rule
+	h.decisions.reset()
+}
+
+// removeRule stops serving the named rule, on either of its paths, and forgets it if it stops on match.
+func (h graffitiHandler) removeRule(name string) {
+	h.rules.Lock()
+	defer h.rules.Unlock()
+	delete(h.tagmap, pathFromName(name))
+	delete(h.tagmap, validatingPathFromName(name))
+	delete(h.stoppers, name)
+	h.decisions.reset()
+}
+
+// rule returns the rule served on the path.
+func (h graffitiHandler) rule(path string) (graffitiMutator, bool) {
+	h.rules.RLock()
+	defer h.rules.RUnlock()
+	mutator, ok := h.tagmap[path]
+	return mutator, ok
+}
+
+// setStopper records a stop-on-match rule, replacing any with the same name.
+func (h graffitiHandler) setStopper(name string, s stopper) {
+	h.rules.Lock()
+	defer h.rules.Unlock()
+	h.stoppers[name] = s
+}
+
+// serve is true the first time that it is called for a path, when the path needs to be given to the mux.
+func (h graffitiHandler) serve(path string) bool {
+	h.rules.Lock()
+	defer h.rules.Unlock()
+	if h.served[path] {
+		return false
+	}
+	h.served[path] = true
+	return true
 }
 
 // ServeHTTP performs the basic validation that we received a valid AdmissionReview request.
 // It looks up the graffiti tag associated with a given webhook path (the URL) and calls its 'mutate' method to
 func (h graffitiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	url := r.URL.Path
+	path := r.URL.Path
 	mylog := log.ComponentLogger(componentName, "graffitiHandler-ServeHTTP")
-	reqLog := mylog.With().Str("url", url).Str("host", r.Host).Str("method", r.Method).Str("ua", r.UserAgent()).Str("remote", r.RemoteAddr).Logger()
+	reqLog := mylog.With().Str("url", path).Str("host", r.Host).Str("method", r.Method).Str("ua", r.UserAgent()).Str("remote", r.RemoteAddr).Logger()
 	reqLog.Debug().Msg("webhook triggered, performing the mutating admission review")
 
-	var body []byte
-	if r.Body != nil {
-		if data, err := ioutil.ReadAll(r.Body); err == nil {
-			body = data
-		}
+	// account for the request's source before reading it, throttled sources are rejected without any further work
+	if !h.limiter.allow(r) {
+		reqLog.Debug().Msg("source is being throttled")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		io.WriteString(w, `too many requests from this source`)
+		return
+	}
+	if !h.acquire(r) {
+		reqLog.Warn().Int("max-concurrent-requests", cap(h.slots)).Msg("no free slot for the admission request")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `too many concurrent admission requests`)
+		return
 	}
+	defer h.release()
 
 	// verify the http method is a POST
 	if r.Method != "POST" {
@@ -73,7 +185,7 @@ func (h graffitiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// verify the content type is accurate
 	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
 		reqLog.Error().Str("content-type", contentType).Msg("bad content-type - not application/json")
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusBadRequest)
@@ -81,34 +193,117 @@ func (h graffitiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reqLog.Debug().Str("request-body", string(body)).Msg("request json received")
+	// the request is decoded as it is read, rather than being read into memory first, and reading stops at the limit
+	var body io.Reader = http.NoBody
+	if r.Body != nil {
+		body = r.Body
+	}
+	var limited *io.LimitedReader
+	prefix := &prefixWriter{limit: uidPrefixSize}
+	if h.maxBodySize > 0 {
+		limited = &io.LimitedReader{R: body, N: h.maxBodySize + 1}
+		body = io.TeeReader(limited, prefix)
+	}
+	var logged bytes.Buffer
+	if reqLog.Debug().Enabled() {
+		body = io.TeeReader(body, &logged)
+	}
 
 	ar := admission.AdmissionReview{}
-	d := json.NewDecoder(bytes.NewReader(body))
+	d := json.NewDecoder(body)
 	d.DisallowUnknownFields()
-	if err := d.Decode(&ar); err != nil {
+	err := d.Decode(&ar)
+	reqLog.Debug().Str("request-body", logged.String()).Msg("request json received")
+	if limited != nil && limited.N == 0 {
+		h.writeOversized(w, prefix.Bytes(), reqLog)
+		return
+	}
+	if err != nil {
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusBadRequest)
 		io.WriteString(w, `The request does not contain a valid AdmissionReview object`)
 		reqLog.Error().Err(err).Msg("failed to decode AdmissionReview request")
 		return
 	}
+	if !supportedReviewVersion(ar.APIVersion) || ar.Request == nil {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, `The request does not contain a valid AdmissionReview object`)
+		reqLog.Error().Str("api-version", ar.APIVersion).Bool("request", ar.Request != nil).Msg("unsupported AdmissionReview request")
+		return
+	}
 	reqLog.Debug().Msg("unmarshalled request")
 
 	reviewResponse := &admission.AdmissionResponse{}
+	var warnings []string
 	// check that we have a Graffiti matching this URL path...
-	if mutator, ok := h.tagmap[url]; !ok {
-		reqLog.Warn().Str("path", url).Msg("can't find a grafitti rule for path")
+	if path == healthcheck.SelfTestPath {
+		reqLog.Debug().Msg("allowing our own self-test admission review")
+		reviewResponse.Allowed = true
+	} else if mutator, ok := h.rule(path); !ok {
+		reqLog.Warn().Str("path", path).Msg("can't find a grafitti rule for path")
 		reviewResponse.Allowed = true
+	} else if h.ignores(mutator, ar.Request) {
+		reqLog.Debug().Str("path", path).Str("namespace", ar.Request.Namespace).Str("name", ar.Request.Name).Msg("skipping graffiti rule because the object is ignored")
+		reviewResponse = &admission.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Message: "rule skipped, the object is ignored",
+			},
+		}
+	} else if h.outOfScope(ar.Request) {
+		reqLog.Debug().Str("path", path).Str("namespace", ar.Request.Namespace).Str("name", ar.Request.Name).Msg("skipping graffiti rule because the object is outside of the watched namespaces")
+		reviewResponse = &admission.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Message: "rule skipped, the object is outside of the watched namespaces",
+			},
+		}
+	} else if stoppedBy := h.stoppedBy(mutator, ar.Request); stoppedBy != "" {
+		reqLog.Debug().Str("path", path).Str("stopped-by", stoppedBy).Msg("skipping graffiti rule because a rule before it matched the object")
+		reviewResponse = &admission.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("rule skipped, rule %s matched first", stoppedBy),
+			},
+		}
+		if h.debugging(r) {
+			explain(w, path, mutator, ar.Request, stoppedBy)
+		} else {
+			metrics.Rules.Reviewed(nameFromPath(path), false)
+		}
 	} else {
-		reqLog.Debug().Str("path", url).Msg("found a graffiti rule for path")
-		// call the Mutate method associated with this rule
-		reviewResponse = mutator.MutateAdmission(ar.Request)
+		reqLog.Debug().Str("path", path).Msg("found a graffiti rule for path")
+		// call the Mutate method associated with this rule, unless its decision for the same request is cached
+		reviewResponse = h.decisions.decide(path, mutator, ar.Request)
+		// debug requests only explain the rule, they aren't real admissions so they are neither audited nor counted
+		debugging := h.debugging(r)
+		record, matched := audit.NewRecord(nameFromPath(path), ar.Request, reviewResponse)
+		if !debugging {
+			if matched {
+				h.auditor.Record(record)
+			}
+			h.events.Admission(nameFromPath(path), ar.Request, reviewResponse)
+			metrics.Rules.Reviewed(nameFromPath(path), matched)
+		}
+		if failed(reviewResponse) {
+			if !debugging {
+				metrics.Rules.Failed(nameFromPath(path), failureReason(reviewResponse), reviewResponse.Result.Message)
+			}
+			reviewResponse, warnings = ruleErrorResponse(h.ruleErrors, nameFromPath(path), reviewResponse)
+		}
+		// dry runs mustn't have side effects, our webhooks are registered as having none on dry runs
+		if rule, ok := mutator.(graffiti.Rule); ok && reviewResponse != nil && reviewResponse.Allowed && !isDryRun(ar.Request) && !debugging {
+			h.services.LabelServices(rule, ar.Request)
+		}
+		if debugging {
+			explain(w, path, mutator, ar.Request, "")
+		}
 	}
 
-	response := admission.AdmissionReview{}
+	response := newAdmissionReview(ar.APIVersion)
 	if reviewResponse != nil {
-		response.Response = reviewResponse
+		response.Response = &admissionResponse{AdmissionResponse: reviewResponse, Warnings: warnings}
 		response.Response.UID = ar.Request.UID
 	}
 	// reset the Object and OldObject, they are not needed in a response.
@@ -127,3 +322,138 @@ func (h graffitiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	reqLog.Debug().Str("json", string(resp)).Msg("webhook response")
 }
+
+// isDryRun is true when the request won't be persisted.
+func isDryRun(req *admission.AdmissionRequest) bool {
+	return req.DryRun != nil && *req.DryRun
+}
+
+// ignores is true when the rule would change the object of the request and the object is in the ignore list.  Rules
+// that only allow or deny objects are always enforced, so that ignoring kube-system can't open it up to what they deny.
+func (h graffitiHandler) ignores(mutator graffitiMutator, req *admission.AdmissionRequest) bool {
+	rule, ok := mutator.(graffiti.Rule)
+	if !ok || rule.IsValidating() || rule.Payload.Block {
+		return false
+	}
+	return h.ignore.Ignores(req.Kind.Kind, req.Namespace, requestName(req))
+}
+
+// outOfScope is true when only the objects of some namespaces are watched and the object of the request isn't in one
+// of them, isn't one of those namespaces itself, or is cluster scoped.
+func (h graffitiHandler) outOfScope(req *admission.AdmissionRequest) bool {
+	if len(h.watchNamespaces) == 0 {
+		return false
+	}
+	namespace := req.Namespace
+	if req.Kind.Group == "" && req.Kind.Kind == "Namespace" {
+		namespace = requestName(req)
+	}
+	for _, ns := range h.watchNamespaces {
+		if ns == namespace {
+			return false
+		}
+	}
+	return true
+}
+
+// requestName is the name of the request, or the name in the object when the request doesn't have one.
+func requestName(req *admission.AdmissionRequest) string {
+	if req.Name != "" || len(req.Object.Raw) == 0 {
+		return req.Name
+	}
+	var object struct {
+		Meta metav1.ObjectMeta `json:"metadata"`
+	}
+	if err := json.Unmarshal(req.Object.Raw, &object); err != nil {
+		return ""
+	}
+	return object.Meta.Name
+}
+
+// stoppedBy returns the name of the first stop-on-match rule, which is evaluated before the mutator's rule and is
+// registered for the request's resource, that matches the request's object.  It is empty when the rule isn't stopped.
+func (h graffitiHandler) stoppedBy(mutator graffitiMutator, req *admission.AdmissionRequest) string {
+	rule, ok := mutator.(graffiti.Rule)
+	if !ok || req == nil {
+		return ""
+	}
+	var earlier []graffiti.Rule
+	h.rules.RLock()
+	for _, s := range h.stoppers {
+		if s.rule.Precedes(rule) && s.covers(req.Resource, req.SubResource) {
+			earlier = append(earlier, s.rule)
+		}
+	}
+	h.rules.RUnlock()
+	graffiti.SortRules(earlier)
+	for _, r := range earlier {
+		match, err := r.MatchesAdmission(req)
+		if err != nil {
+			mylog := log.ComponentLogger(componentName, "stoppedBy")
+			mylog.Error().Err(err).Str("rule", r.Name).Msg("failed to check a stop-on-match rule, ignoring it")
+			continue
+		}
+		if match {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// covers is true when the stopper's rule is registered for the resource, or its subresource.
+func (s stopper) covers(resource metav1.GroupVersionResource, subResource string) bool {
+	if len(s.targets) == 0 {
+		return true
+	}
+	for _, t := range s.targets {
+		if t.covers(resource, subResource) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits for a free slot to serve a request, until the request's timeout.  It always succeeds when the number
+// of concurrent requests isn't limited.
+func (h graffitiHandler) acquire(r *http.Request) bool {
+	if h.slots == nil {
+		return true
+	}
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	ctx := r.Context()
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	select {
+	case h.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (h graffitiHandler) release() {
+	if h.slots != nil {
+		<-h.slots
+	}
+}
+
+// nameFromPath is the reverse of pathFromName (and validatingPathFromName) and returns the rule name for a webhook path.
+func nameFromPath(path string) string {
+	trimmed := strings.TrimPrefix(path, validatingPathPrefix)
+	if trimmed == path {
+		trimmed = strings.TrimPrefix(path, pathPrefix)
+	}
+	name, err := url.PathUnescape(trimmed)
+	if err != nil {
+		return path
+	}
+	return name
+}
@@ -14,16 +14,25 @@ limitations under the License.
 package webhook
 
 import (
+	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Telefonica/kube-graffiti/pkg/audit"
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/healthcheck"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type mockMutator struct {
@@ -101,7 +110,7 @@ func TestCallsMutateWhenPathIsHandled(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
 	respBody, _ := ioutil.ReadAll(resp.Body)
-	assert.Equal(t, "{\"response\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"allowed\":false}}", string(respBody))
+	assert.Equal(t, "{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"response\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"allowed\":false}}", string(respBody))
 }
 
 func TestHandlerAllowsRequestWithMissingHandler(t *testing.T) {
@@ -118,5 +127,341 @@ func TestHandlerAllowsRequestWithMissingHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
 	respBody, _ := ioutil.ReadAll(resp.Body)
-	assert.Equal(t, "{\"response\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"allowed\":true}}", string(respBody))
+	assert.Equal(t, "{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"response\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"allowed\":true}}", string(respBody))
+}
+
+// recordingSink captures the audit records sent by the handler.
+type recordingSink struct {
+	records []audit.Record
+}
+
+func (s *recordingSink) Send(r audit.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func TestHandlerAuditsPatchedObjects(t *testing.T) {
+	fake := new(mockMutator)
+	fake.On("MutateAdmission", mock.AnythingOfType("*v1beta1.AdmissionRequest")).Return(&admission.AdmissionResponse{
+		Allowed: true,
+		Patch:   []byte(`[ { "op": "add", "path": "/metadata/labels", "value": { "a": "b" }} ]`),
+	})
+	sink := &recordingSink{}
+	auditor := audit.NewAuditor(sink, 10)
+
+	rr := httptest.NewRecorder()
+	handler := newGraffitiHandler()
+	handler.auditor = auditor
+	handler.addRule("/graffiti/test-rule", fake)
+
+	reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"Namespace\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"namespaces\"},\"name\":\"test-namespace\",\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\",\"groups\":[\"system:masters\",\"system:authenticated\"]},\"object\":{\"metadata\":{\"name\":\"test-namespace\",\"creationTimestamp\":null},\"spec\":{},\"status\":{\"phase\":\"Active\"}},\"oldObject\":null}}\n")
+	req, err := http.NewRequest("POST", "/graffiti/test-rule", reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	assert.NoError(t, err, "We created a valid http request")
+	handler.ServeHTTP(rr, req)
+	auditor.Stop()
+
+	assert.Equal(t, http.StatusOK, rr.Result().StatusCode)
+	require.Len(t, sink.records, 1, "the patched object should have been audited")
+	assert.Equal(t, "test-rule", sink.records[0].Rule)
+	assert.Equal(t, audit.DecisionPatched, sink.records[0].Decision)
+	assert.Equal(t, "test-namespace", sink.records[0].Name)
+}
+
+func TestRequestsWaitForAFreeSlot(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	s.SetRequestLimits(1, 50*time.Millisecond)
+	assert.Equal(t, 50*time.Millisecond, s.httpServer.ReadTimeout)
+
+	// take the only slot, as a request that is being served would
+	s.handler.slots <- struct{}{}
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, requestFrom(t, "10.1.2.5:1"))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code, "no slot became free within the timeout")
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.handler.release()
+	}()
+	rr = httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, requestFrom(t, "10.1.2.5:2"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "the request should be served once the slot is released")
+	assert.Len(t, s.handler.slots, 0, "the slot is released when the request has been served")
+}
+
+func TestRequestsAreNotLimitedByDefault(t *testing.T) {
+	handler := newGraffitiHandler()
+	for i := 0; i < 3; i++ {
+		assert.True(t, handler.acquire(requestFrom(t, "10.1.2.6:1")))
+	}
+	handler.release()
+}
+
+func TestStopOnMatchRuleSkipsTheRulesAfterIt(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	namespaces := Registration{Resources: []string{"namespaces"}}
+	s.AddRegisteredRule(namespaces, graffiti.NewRule("first").WithPriority(10).StoppingOnMatch().MatchNames("test-*").AddLabels(map[string]string{"first": "true"}))
+	s.AddRegisteredRule(Registration{Resources: []string{"pods"}}, graffiti.NewRule("pods-only").WithPriority(20).StoppingOnMatch().AddLabels(map[string]string{"pod": "true"}))
+	s.AddRegisteredRule(namespaces, graffiti.NewRule("second").AddLabels(map[string]string{"second": "true"}))
+
+	review := func(path string) string {
+		reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"Namespace\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"namespaces\"},\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"test-namespace\",\"creationTimestamp\":null},\"spec\":{},\"status\":{\"phase\":\"Active\"}},\"oldObject\":null}}\n")
+		req, err := http.NewRequest("POST", path, reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		s.handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Result().Body)
+		return string(body)
+	}
+
+	assert.Contains(t, review("/graffiti/first"), `"patch"`, "the stop-on-match rule should paint the object")
+	second := review("/graffiti/second")
+	assert.NotContains(t, second, `"patch"`)
+	assert.Contains(t, second, "rule skipped, rule first matched first", "the pods-only rule isn't registered for namespaces")
+}
+
+func TestOversizedRequestsAreAnsweredWithoutBeingRead(t *testing.T) {
+	fake := new(mockMutator)
+	handler := newGraffitiHandler()
+	handler.addRule("/graffiti/test-rule", fake)
+	handler.maxBodySize = 1024
+
+	big := strings.Repeat("x", 4096)
+	post := func(body string) *http.Response {
+		req, err := http.NewRequest("POST", "/graffiti/test-rule", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Result()
+	}
+	review := "{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"ConfigMap\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"configmaps\"},\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"giant\"},\"data\":{\"big\":\"" + big + "\"}},\"oldObject\":null}}"
+
+	resp := post(review)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"response\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"allowed\":true,\"status\":{\"metadata\":{},\"message\":\"admission request is larger than the 1024 byte limit\",\"reason\":\"RequestEntityTooLarge\"}}}", string(respBody))
+	fake.AssertNotCalled(t, "MutateAdmission", mock.Anything)
+
+	handler.oversized = OversizedDeny
+	respBody, _ = ioutil.ReadAll(post(review).Body)
+	assert.Contains(t, string(respBody), "\"allowed\":false")
+
+	// without a uid near the start there's nothing to answer
+	resp = post("{\"padding\":\"" + big + "\"}")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestRuleErrorsWarnDenyOrAreIgnored(t *testing.T) {
+	fake := new(mockMutator)
+	fake.On("MutateAdmission", mock.AnythingOfType("*v1beta1.AdmissionRequest")).Return(&admission.AdmissionResponse{
+		Allowed: true,
+		Result:  &metav1.Status{Reason: metav1.StatusReasonInternalError, Message: "failed to mutate object: bad template"},
+	})
+	handler := newGraffitiHandler()
+	handler.addRule("/graffiti/test-rule", fake)
+
+	review := func() string {
+		reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"Namespace\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"namespaces\"},\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"test-namespace\"}},\"oldObject\":null}}\n")
+		req, err := http.NewRequest("POST", "/graffiti/test-rule", reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Result().Body)
+		return string(body)
+	}
+
+	handler.ruleErrors = RuleErrorsWarn
+	warned := review()
+	assert.Contains(t, warned, "\"allowed\":true")
+	assert.Contains(t, warned, "\"warnings\":[\"kube-graffiti rule test-rule failed and did not change the object: failed to mutate object: bad template\"]")
+
+	handler.ruleErrors = RuleErrorsDeny
+	denied := review()
+	assert.Contains(t, denied, "\"allowed\":false")
+	assert.Contains(t, denied, "kube-graffiti rule test-rule failed: failed to mutate object: bad template")
+	assert.NotContains(t, denied, "warnings")
+
+	handler.ruleErrors = RuleErrorsIgnore
+	ignored := review()
+	assert.Contains(t, ignored, "\"allowed\":true")
+	assert.NotContains(t, ignored, "warnings")
+}
+
+func TestValidateRuleErrorsAction(t *testing.T) {
+	for _, action := range []string{"", RuleErrorsWarn, RuleErrorsDeny, RuleErrorsIgnore} {
+		assert.NoError(t, ValidateRuleErrorsAction(action))
+	}
+	assert.Error(t, ValidateRuleErrorsAction("allow"))
+}
+
+func TestRuleFailuresAreCountedByReason(t *testing.T) {
+	assert.False(t, failed(&admission.AdmissionResponse{Allowed: true}))
+
+	internal := &admission.AdmissionResponse{Allowed: true, Result: &metav1.Status{Reason: metav1.StatusReasonInternalError}}
+	assert.True(t, failed(internal))
+	assert.Equal(t, metrics.ReasonInternalError, failureReason(internal))
+
+	patchBuild := &admission.AdmissionResponse{Allowed: true, Result: &metav1.Status{Reason: graffiti.StatusReasonPatchBuild}}
+	assert.True(t, failed(patchBuild))
+	assert.Equal(t, metrics.ReasonPatchBuild, failureReason(patchBuild))
+}
+
+func TestDebugRequestsExplainTheRule(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	s.SetDebugToken("s3cret")
+	namespaces := Registration{Resources: []string{"namespaces"}}
+	s.AddRegisteredRule(namespaces, graffiti.NewRule("first").WithPriority(10).StoppingOnMatch().MatchNames("test-*").AddLabels(map[string]string{"first": "true"}))
+	s.AddRegisteredRule(namespaces, graffiti.NewRule("active").MatchFields("status.phase=Active", "status.phase=Terminating").AddLabels(map[string]string{"active": "true"}))
+
+	review := func(path, token string) http.Header {
+		reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"Namespace\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"namespaces\"},\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"prod-namespace\",\"creationTimestamp\":null},\"spec\":{},\"status\":{\"phase\":\"Active\"}},\"oldObject\":null}}\n")
+		req, err := http.NewRequest("POST", path, reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set(DebugHeader, token)
+		}
+		rr := httptest.NewRecorder()
+		s.handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return rr.Result().Header
+	}
+
+	headers := review("/graffiti/active", "s3cret")
+	assert.Equal(t, "active", headers.Get(RuleHeader))
+	assert.Equal(t, "true", headers.Get(MatchedHeader))
+	var e graffiti.Explanation
+	require.NoError(t, json.Unmarshal([]byte(headers.Get(ExplainHeader)), &e))
+	assert.Equal(t, []graffiti.SelectorResult{
+		{Type: "field", Selector: "status.phase=Active", Matched: true},
+		{Type: "field", Selector: "status.phase=Terminating", Matched: false},
+	}, e.Selectors)
+
+	headers = review("/graffiti/first", "s3cret")
+	assert.Equal(t, "false", headers.Get(MatchedHeader), "rules that don't match are explained too")
+	assert.Empty(t, headers.Get(StoppedByHeader))
+
+	for _, token := range []string{"", "guess"} {
+		headers = review("/graffiti/active", token)
+		assert.Empty(t, headers.Get(RuleHeader), "only requests with the debug token are explained")
+		assert.Empty(t, headers.Get(ExplainHeader))
+	}
+
+	s.SetDebugToken("")
+	assert.Empty(t, review("/graffiti/active", "").Get(RuleHeader), "debugging is disabled without a token")
+}
+
+func TestIgnoredObjectsAreAllowedUnchanged(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	s.SetIgnore(graffiti.Ignore{Namespaces: []string{"kube-*"}, Names: []string{"*-generated"}})
+	s.AddRegisteredRule(Registration{Resources: []string{"namespaces"}}, graffiti.NewRule("label").AddLabels(map[string]string{"team": "platform"}))
+
+	review := func(name string) string {
+		reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"Namespace\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"namespaces\"},\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"" + name + "\",\"creationTimestamp\":null},\"spec\":{},\"status\":{\"phase\":\"Active\"}},\"oldObject\":null}}\n")
+		req, err := http.NewRequest("POST", "/graffiti/label", reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		s.handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Result().Body)
+		return string(body)
+	}
+
+	assert.Contains(t, review("team-a"), `"patch"`)
+	for _, name := range []string{"kube-public", "app-generated"} {
+		body := review(name)
+		assert.NotContains(t, body, `"patch"`, name)
+		assert.Contains(t, body, "rule skipped, the object is ignored", name)
+	}
+}
+
+func TestIgnoredObjectsAreStillDeniedByBlockingRules(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	s.SetIgnore(graffiti.Ignore{Namespaces: []string{"kube-*"}})
+	s.AddRegisteredRule(Registration{Resources: []string{"namespaces"}}, graffiti.NewRule("deny").Block())
+
+	reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"Namespace\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"namespaces\"},\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"kube-public\",\"creationTimestamp\":null},\"spec\":{},\"status\":{\"phase\":\"Active\"}},\"oldObject\":null}}\n")
+	req, err := http.NewRequest("POST", "/graffiti/deny", reqBody)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	s.handler.ServeHTTP(rr, req)
+	body, _ := ioutil.ReadAll(rr.Result().Body)
+	assert.Contains(t, string(body), `"allowed":false`)
+	assert.NotContains(t, string(body), "rule skipped, the object is ignored")
+}
+
+func TestObjectsOutsideOfTheWatchedNamespacesAreAllowedUnchanged(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	s.SetWatchNamespaces([]string{"team-a"})
+	s.AddRegisteredRule(Registration{Resources: []string{"*"}}, graffiti.NewRule("label").AddLabels(map[string]string{"team": "platform"}))
+
+	review := func(kind, namespace, name string) string {
+		reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"" + kind + "\"},\"namespace\":\"" + namespace + "\",\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"" + name + "\",\"namespace\":\"" + namespace + "\"}},\"oldObject\":null}}\n")
+		req, err := http.NewRequest("POST", "/graffiti/label", reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		s.handler.ServeHTTP(rr, req)
+		body, _ := ioutil.ReadAll(rr.Result().Body)
+		return string(body)
+	}
+
+	assert.Contains(t, review("Pod", "team-a", "nginx"), `"patch"`)
+	assert.Contains(t, review("Namespace", "", "team-a"), `"patch"`, "the watched namespaces themselves are in scope")
+	for _, object := range [][]string{{"Pod", "team-b", "nginx"}, {"Namespace", "", "team-b"}, {"Node", "", "worker-1"}} {
+		body := review(object[0], object[1], object[2])
+		assert.NotContains(t, body, `"patch"`, object)
+		assert.Contains(t, body, "rule skipped, the object is outside of the watched namespaces", object)
+	}
+}
+
+func TestSelfTestReviewsAreAllowedWithoutEvaluatingRules(t *testing.T) {
+	fake := new(mockMutator)
+	handler := newGraffitiHandler()
+	handler.addRule("/graffiti/test-rule", fake)
+	mux := http.NewServeMux()
+	mux.Handle(healthcheck.SelfTestPath, handler)
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+	caPath := filepath.Join(t.TempDir(), "ca-cert")
+	require.NoError(t, ioutil.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	check := healthcheck.SelfCheck{Enabled: true, Review: true, Address: strings.TrimPrefix(server.URL, "https://"), ServerName: "example.com", Timeout: time.Second}
+	assert.NoError(t, healthcheck.NewSelfChecker(check, caPath).Check())
+	fake.AssertNotCalled(t, "MutateAdmission", mock.Anything)
+}
+
+func TestDebugRequestsHaveNoSideEffects(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	s.SetDebugToken("s3cret")
+	s.AddRegisteredRule(Registration{Resources: []string{"namespaces"}}, graffiti.NewRule("debug-side-effects").AddLabels(map[string]string{"team": "platform"}))
+
+	review := func(token string) {
+		reqBody := strings.NewReader("{\"kind\":\"AdmissionReview\",\"apiVersion\":\"admission.k8s.io/v1beta1\",\"request\":{\"uid\":\"69f7d25a-963e-11e8-a77c-08002753edac\",\"kind\":{\"group\":\"\",\"version\":\"v1\",\"kind\":\"Namespace\"},\"resource\":{\"group\":\"\",\"version\":\"v1\",\"resource\":\"namespaces\"},\"operation\":\"CREATE\",\"userInfo\":{\"username\":\"minikube-user\"},\"object\":{\"metadata\":{\"name\":\"prod-namespace\",\"creationTimestamp\":null},\"spec\":{},\"status\":{\"phase\":\"Active\"}},\"oldObject\":null}}\n")
+		req, err := http.NewRequest("POST", "/graffiti/debug-side-effects", reqBody)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(DebugHeader, token)
+		rr := httptest.NewRecorder()
+		s.handler.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code)
+	}
+	requests := func() int64 {
+		for _, status := range metrics.Rules.Statuses() {
+			if status.Name == "debug-side-effects" {
+				return status.Requests
+			}
+		}
+		return 0
+	}
+
+	before := requests()
+	review("s3cret")
+	assert.Equal(t, before, requests(), "debug requests aren't counted as reviews")
+	review("")
+	assert.Equal(t, before+1, requests())
 }
@@ -0,0 +1,163 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Listener is an extra https listener, with its own port and certificate, which serves the rules whose registrations
+// name it.  Rules that must not fail, with a failure-policy of Fail, can be split from best-effort rules so that a
+// flood of requests for one group can't hold up the other.
+type Listener struct {
+	Name string `mapstructure:"name" yaml:"name"`
+	Port int    `mapstructure:"port" yaml:"port"`
+	// Service is the service that the apiserver calls the listener through, server.service when it isn't set, and
+	// ServicePort is the port of the service that forwards to the listener, the listener's port when it isn't set.
+	Service     string `mapstructure:"service" yaml:"service,omitempty"`
+	ServicePort int32  `mapstructure:"service-port" yaml:"service-port,omitempty"`
+	CACertPath  string `mapstructure:"ca-cert-path" yaml:"ca-cert-path"`
+	CertPath    string `mapstructure:"cert-path" yaml:"cert-path"`
+	KeyPath     string `mapstructure:"key-path" yaml:"key-path"`
+}
+
+// Validate checks that the listener has a name, a port and a certificate.
+func (l Listener) Validate() error {
+	if errs := utilvalidation.IsDNS1123Label(l.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid server.listeners name '%s': %s", l.Name, strings.Join(errs, ", "))
+	}
+	if l.Port < 1 || l.Port > 65535 {
+		return fmt.Errorf("server listener '%s' has an invalid port %d", l.Name, l.Port)
+	}
+	if l.ServicePort < 0 || l.ServicePort > 65535 {
+		return fmt.Errorf("server listener '%s' has an invalid service-port %d", l.Name, l.ServicePort)
+	}
+	if l.CACertPath == "" || l.CertPath == "" || l.KeyPath == "" {
+		return fmt.Errorf("server listener '%s' needs a ca-cert-path, cert-path and key-path", l.Name)
+	}
+	return nil
+}
+
+// listener is an extra https server and the rules that it serves.
+type listener struct {
+	config     Listener
+	caCert     []byte
+	httpServer *http.Server
+	// handler shares the rules of the server's handler but has its own request slots and mux paths, it is created
+	// when the first rule is added, once the server's handler has been set up.
+	handler *graffitiHandler
+	once    sync.Once
+}
+
+// AddListener adds an extra https listener, whose certificate is signed by the ca bundle, for the rules whose
+// registrations name it.  It must be called before any rules are added with AddRegisteredRule.
+func (s *Server) AddListener(l Listener, ca []byte) {
+	mylog := log.ComponentLogger(componentName, "AddListener")
+	mylog.Info().Str("listener", l.Name).Int("port", l.Port).Msg("adding a webhook listener")
+	if s.listeners == nil {
+		s.listeners = make(map[string]*listener)
+	}
+	s.listeners[l.Name] = &listener{
+		config: l,
+		caCert: ca,
+		httpServer: &http.Server{
+			Addr:      fmt.Sprintf(":%d", l.Port),
+			Handler:   http.NewServeMux(),
+			TLSConfig: s.httpServer.TLSConfig.Clone(),
+		},
+	}
+}
+
+// serving returns the mux and handler that serve the rules of the named listener, those of the server itself when
+// the name is empty or isn't one of our listeners.
+func (s Server) serving(name string) (*http.ServeMux, graffitiHandler) {
+	if name == "" {
+		return s.httpServer.Handler.(*http.ServeMux), s.handler
+	}
+	l, ok := s.listeners[name]
+	if !ok {
+		mylog := log.ComponentLogger(componentName, "serving")
+		mylog.Error().Str("listener", name).Msg("there is no such listener, serving the rule from the main server")
+		return s.httpServer.Handler.(*http.ServeMux), s.handler
+	}
+	l.once.Do(func() {
+		h := s.handler
+		if s.handler.slots != nil {
+			h.slots = make(chan struct{}, cap(s.handler.slots))
+		}
+		h.served = make(map[string]bool)
+		l.handler = &h
+		l.httpServer.ReadTimeout = s.httpServer.ReadTimeout
+	})
+	return l.httpServer.Handler.(*http.ServeMux), *l.handler
+}
+
+// listenerClientConfig points a client config at the listener that serves the registration's rule.
+func (s Server) listenerClientConfig(r Registration, config admissionreg.WebhookClientConfig) (admissionreg.WebhookClientConfig, error) {
+	if r.Listener == "" {
+		return config, nil
+	}
+	l, ok := s.listeners[r.Listener]
+	if !ok {
+		return config, fmt.Errorf("rule %s is served by listener '%s', which isn't one of server.listeners", r.Name, r.Listener)
+	}
+	service := *config.Service
+	if l.config.Service != "" {
+		service.Name = l.config.Service
+	}
+	port := l.config.ServicePort
+	if port == 0 {
+		port = int32(l.config.Port)
+	}
+	service.Port = &port
+	config.Service = &service
+	config.CABundle = l.caCert
+	return config, nil
+}
+
+// startListeners starts each of the extra listeners with its own certificate.
+func (s Server) startListeners() {
+	mylog := log.ComponentLogger(componentName, "startListeners")
+	for name, l := range s.listeners {
+		name, l := name, l
+		mylog.Info().Str("listener", name).Str("addr", l.httpServer.Addr).Msg("starting webhook listener")
+		go func() {
+			if err := l.httpServer.ListenAndServeTLS(l.config.CertPath, l.config.KeyPath); err != nil && err != http.ErrServerClosed {
+				mylog.Fatal().Err(err).Str("listener", name).Msg("failed to start the webhook listener")
+			}
+		}()
+	}
+}
+
+// shutdownListeners stops each of the extra listeners, draining their in-flight requests.
+func (s Server) shutdownListeners(ctx context.Context) error {
+	var failed []string
+	for name, l := range s.listeners {
+		if err := l.httpServer.Shutdown(ctx); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("webhook listeners did not shut down cleanly: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testListener() Listener {
+	return Listener{Name: "critical", Port: 8444, ServicePort: 444, CACertPath: "ca", CertPath: "cert", KeyPath: "key"}
+}
+
+func TestListenerValidation(t *testing.T) {
+	assert.NoError(t, testListener().Validate())
+
+	l := testListener()
+	l.Name = "Not_A_Label"
+	assert.Error(t, l.Validate())
+	l = testListener()
+	l.Port = 0
+	assert.Error(t, l.Validate())
+	l = testListener()
+	l.KeyPath = ""
+	assert.EqualError(t, l.Validate(), "server listener 'critical' needs a ca-cert-path, cert-path and key-path")
+}
+
+func TestRulesAreServedByTheirListener(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	s.SetRequestLimits(2, 0)
+	s.AddListener(testListener(), []byte("critical-ca"))
+	s.AddRegisteredRule(Registration{Name: "must-label", Listener: "critical"}, graffiti.NewRule("must-label"))
+	s.AddRegisteredRule(Registration{Name: "best-effort"}, graffiti.NewRule("best-effort"))
+
+	served := func(h http.Handler, path string) bool {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}")))
+		return w.Code != http.StatusNotFound
+	}
+	critical := s.listeners["critical"]
+	assert.True(t, served(critical.httpServer.Handler, pathFromName("must-label")))
+	assert.False(t, served(critical.httpServer.Handler, pathFromName("best-effort")))
+	assert.True(t, served(s.httpServer.Handler, pathFromName("best-effort")))
+	assert.False(t, served(s.httpServer.Handler, pathFromName("must-label")))
+
+	require.NotNil(t, critical.handler)
+	assert.Equal(t, 2, cap(critical.handler.slots), "the listener has as many request slots as the server")
+	assert.NotEqual(t, s.handler.slots, critical.handler.slots, "but doesn't share them")
+}
+
+func TestRegistrationsPointAtTheirListener(t *testing.T) {
+	s := testRegistrationServer()
+	s.httpServer = &http.Server{}
+	s.AddListener(testListener(), []byte("critical-ca"))
+
+	r := testRegistration()
+	r.Listener = "critical"
+	desired, err := s.desiredWebhook(r)
+	require.NoError(t, err)
+	require.NotNil(t, desired.ClientConfig.Service.Port)
+	assert.Equal(t, int32(444), *desired.ClientConfig.Service.Port)
+	assert.Equal(t, "kube-graffiti", desired.ClientConfig.Service.Name)
+	assert.Equal(t, []byte("critical-ca"), desired.ClientConfig.CABundle)
+
+	desired, err = s.desiredWebhook(testRegistration())
+	require.NoError(t, err)
+	assert.Nil(t, desired.ClientConfig.Service.Port, "rules without a listener use the service's default port")
+	assert.Equal(t, []byte("ca"), desired.ClientConfig.CABundle)
+
+	r.Listener = "missing"
+	_, err = s.desiredWebhook(r)
+	assert.EqualError(t, err, "rule label-pods is served by listener 'missing', which isn't one of server.listeners")
+}
+
+func TestMovingAWebhookToAListenerUpdatesItsPort(t *testing.T) {
+	s := testRegistrationServer()
+	s.httpServer = &http.Server{}
+	s.AddListener(testListener(), []byte("ca"))
+	current, err := s.desiredWebhook(testRegistration())
+	require.NoError(t, err)
+
+	r := testRegistration()
+	r.Listener = "critical"
+	desired, err := s.desiredWebhook(r)
+	require.NoError(t, err)
+	assert.Contains(t, desired.diff(current), "clientConfig")
+}
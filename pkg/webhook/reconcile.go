@@ -0,0 +1,336 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/rs/zerolog"
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// registrationBackoff is used when registering a webhook fails, it retries for about a minute.
+var registrationBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    6,
+}
+
+// RegisterHookWithRetry is RegisterHook retried with exponential backoff and jitter, so that a single failed call
+// to the apiserver doesn't stop us from starting.
+func (s Server) RegisterHookWithRetry(r Registration, clientset kubernetes.Interface) error {
+	mylog := log.ComponentLogger(componentName, "RegisterHookWithRetry")
+	var lastErr error
+	attempts := 0
+	err := wait.ExponentialBackoff(registrationBackoff, func() (bool, error) {
+		attempts++
+		if lastErr = s.RegisterHook(r, clientset); lastErr != nil {
+			mylog.Warn().Err(lastErr).Str("name", r.Name).Int("attempt", attempts).Msg("failed to register webhook, retrying")
+			return false, nil
+		}
+		return true, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("failed to register webhook after %d attempts: %w", attempts, lastErr)
+	}
+	return err
+}
+
+// registrar periodically checks that our webhook registrations are still in place and as we registered them.
+type registrar struct {
+	stop chan struct{}
+	done sync.WaitGroup
+	// registrations change when rules are added or removed while serving.
+	sync.Mutex
+	registrations []Registration
+}
+
+// KeepRegistered starts checking the registrations every interval (with jitter), re-registering any webhook that has
+// been deleted or whose settings have drifted from ours.  It is stopped when the server is shut down, before any
+// registrations are removed, so that it doesn't put them back.
+func (s *Server) KeepRegistered(registrations []Registration, clientset kubernetes.Interface, interval time.Duration) {
+	mylog := log.ComponentLogger(componentName, "KeepRegistered")
+	if interval <= 0 {
+		mylog.Info().Msg("checking of webhook registrations is disabled")
+		return
+	}
+	r := &registrar{stop: make(chan struct{}), registrations: registrations}
+	s.registrar = r
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		wait.JitterUntil(func() {
+			r.Lock()
+			registrations := append([]Registration(nil), r.registrations...)
+			r.Unlock()
+			for _, reg := range registrations {
+				if _, err := s.ReconcileHook(reg, clientset); err != nil {
+					mylog.Error().Err(err).Str("name", reg.Name).Msg("failed to check webhook registration")
+				}
+			}
+		}, interval, 0.1, false, r.stop)
+	}()
+}
+
+// KeepRegistration adds a registration to those that are checked, replacing any with the same name.  It does nothing
+// when KeepRegistered was never called or checking is disabled.
+func (s Server) KeepRegistration(reg Registration) {
+	if s.registrar == nil {
+		return
+	}
+	s.registrar.Lock()
+	defer s.registrar.Unlock()
+	for i := range s.registrar.registrations {
+		if s.registrar.registrations[i].Name == reg.Name {
+			s.registrar.registrations[i] = reg
+			return
+		}
+	}
+	s.registrar.registrations = append(s.registrar.registrations, reg)
+}
+
+// ForgetRegistration stops checking the named registration, so that it can be removed.
+func (s Server) ForgetRegistration(name string) {
+	if s.registrar == nil {
+		return
+	}
+	s.registrar.Lock()
+	defer s.registrar.Unlock()
+	var kept []Registration
+	for _, reg := range s.registrar.registrations {
+		if reg.Name != name {
+			kept = append(kept, reg)
+		}
+	}
+	s.registrar.registrations = kept
+}
+
+// stopRegistrar stops checking the registrations and waits for any check in progress, it is safe to call when
+// KeepRegistered was never called.
+func (s Server) stopRegistrar() {
+	if s.registrar == nil {
+		return
+	}
+	close(s.registrar.stop)
+	s.registrar.done.Wait()
+}
+
+// ReconcileHook re-registers a webhook when its configuration is missing or differs from our registration.
+// It returns true when the webhook had to be re-registered.
+func (s Server) ReconcileHook(r Registration, clientset kubernetes.Interface) (bool, error) {
+	mylog := log.ComponentLogger(componentName, "ReconcileHook")
+	rlog := mylog.With().Str("name", r.Name).Bool("validating", r.IsValidating()).Logger()
+
+	desired, err := s.desiredWebhook(r)
+	if err != nil {
+		return false, err
+	}
+
+	getRegistered := getRegisteredWebhooks
+	if s.legacyRegistrations() {
+		getRegistered = getRegisteredWebhooksV1beta1
+	}
+	actual, err := getRegistered(r, clientset)
+	switch {
+	case apierrors.IsNotFound(err):
+		rlog.Warn().Msg("webhook registration has been deleted, re-registering it")
+	case err != nil:
+		return false, fmt.Errorf("failed to get the webhook: %v", err)
+	case len(actual) != 1 || !desired.equal(actual[0]):
+		rlog.Warn().Msg("webhook registration has drifted from our rule, re-registering it")
+	default:
+		rlog.Debug().Msg("webhook registration is in place")
+		return false, nil
+	}
+
+	err = s.RegisterHook(r, clientset)
+	metrics.Rules.SetRegistered(r.Name, err)
+	return err == nil, err
+}
+
+// registeredWebhook holds the settings that we control of either a mutating or a validating webhook.
+type registeredWebhook struct {
+	Name              string
+	ClientConfig      admissionreg.WebhookClientConfig
+	Rules             []admissionreg.RuleWithOperations
+	FailurePolicy     *admissionreg.FailurePolicyType
+	NamespaceSelector *metav1.LabelSelector
+	TimeoutSeconds    *int32
+	SideEffects       *admissionreg.SideEffectClass
+	// ReinvocationPolicy is always nil for validating webhooks.
+	ReinvocationPolicy      *admissionreg.ReinvocationPolicyType
+	AdmissionReviewVersions []string
+}
+
+func registeredMutatingWebhook(w admissionreg.MutatingWebhook) registeredWebhook {
+	return registeredWebhook{w.Name, w.ClientConfig, w.Rules, w.FailurePolicy, w.NamespaceSelector, w.TimeoutSeconds, w.SideEffects, w.ReinvocationPolicy, w.AdmissionReviewVersions}
+}
+
+func registeredValidatingWebhook(w admissionreg.ValidatingWebhook) registeredWebhook {
+	return registeredWebhook{w.Name, w.ClientConfig, w.Rules, w.FailurePolicy, w.NamespaceSelector, w.TimeoutSeconds, w.SideEffects, nil, w.AdmissionReviewVersions}
+}
+
+// mutatingWebhook is the webhook as a mutating webhook.
+func (w registeredWebhook) mutatingWebhook() admissionreg.MutatingWebhook {
+	return admissionreg.MutatingWebhook{
+		Name:                    w.Name,
+		FailurePolicy:           w.FailurePolicy,
+		NamespaceSelector:       w.NamespaceSelector,
+		Rules:                   w.Rules,
+		ClientConfig:            w.ClientConfig,
+		TimeoutSeconds:          w.TimeoutSeconds,
+		SideEffects:             w.SideEffects,
+		ReinvocationPolicy:      w.ReinvocationPolicy,
+		AdmissionReviewVersions: w.AdmissionReviewVersions,
+	}
+}
+
+// validatingWebhook is the webhook as a validating webhook, which has no reinvocation policy.
+func (w registeredWebhook) validatingWebhook() admissionreg.ValidatingWebhook {
+	return admissionreg.ValidatingWebhook{
+		Name:                    w.Name,
+		FailurePolicy:           w.FailurePolicy,
+		NamespaceSelector:       w.NamespaceSelector,
+		Rules:                   w.Rules,
+		ClientConfig:            w.ClientConfig,
+		TimeoutSeconds:          w.TimeoutSeconds,
+		SideEffects:             w.SideEffects,
+		AdmissionReviewVersions: w.AdmissionReviewVersions,
+	}
+}
+
+func getRegisteredWebhooks(r Registration, clientset kubernetes.Interface) ([]registeredWebhook, error) {
+	var result []registeredWebhook
+	if r.IsValidating() {
+		config, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(r.ConfigurationName(), metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range config.Webhooks {
+			result = append(result, registeredValidatingWebhook(w))
+		}
+		return result, nil
+	}
+	config, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(r.ConfigurationName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range config.Webhooks {
+		result = append(result, registeredMutatingWebhook(w))
+	}
+	return result, nil
+}
+
+// equal compares the webhooks, ignoring the fields that the apiserver fills in with defaults.
+func (w registeredWebhook) equal(other registeredWebhook) bool {
+	return equality.Semantic.DeepEqual(w.normalize(), other.normalize())
+}
+
+// needsUpdate compares the webhook with the registered webhooks, and logs what has changed when they differ.
+func (w registeredWebhook) needsUpdate(registered []registeredWebhook, rlog zerolog.Logger) bool {
+	if len(registered) == 1 && w.equal(registered[0]) {
+		rlog.Debug().Msg("webhook registration is unchanged, not updating it")
+		return false
+	}
+	var current registeredWebhook
+	if len(registered) > 0 {
+		current = registered[0]
+	}
+	diff := w.diff(current)
+	if len(registered) > 1 {
+		diff["webhooks"] = webhookChange{Current: len(registered), Desired: 1}
+	}
+	rlog.Info().Interface("diff", diff).Msg("updating webhook registration")
+	return true
+}
+
+// webhookChange is a setting's current and desired value.
+type webhookChange struct {
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+// diff returns the settings of the webhook that differ from the current webhook's, keyed by setting.
+func (w registeredWebhook) diff(current registeredWebhook) map[string]webhookChange {
+	desired, actual := w.normalize(), current.normalize()
+	diff := make(map[string]webhookChange)
+	for _, setting := range []struct {
+		name             string
+		desired, current interface{}
+	}{
+		{"name", desired.Name, actual.Name},
+		{"clientConfig", desired.ClientConfig, actual.ClientConfig},
+		{"rules", desired.Rules, actual.Rules},
+		{"failurePolicy", desired.FailurePolicy, actual.FailurePolicy},
+		{"namespaceSelector", desired.NamespaceSelector, actual.NamespaceSelector},
+		{"timeoutSeconds", desired.TimeoutSeconds, actual.TimeoutSeconds},
+		{"sideEffects", desired.SideEffects, actual.SideEffects},
+		{"reinvocationPolicy", desired.ReinvocationPolicy, actual.ReinvocationPolicy},
+		{"admissionReviewVersions", desired.AdmissionReviewVersions, actual.AdmissionReviewVersions},
+	} {
+		if !equality.Semantic.DeepEqual(setting.desired, setting.current) {
+			diff[setting.name] = webhookChange{Current: setting.current, Desired: setting.desired}
+		}
+	}
+	return diff
+}
+
+func (w registeredWebhook) normalize() registeredWebhook {
+	selector := metav1.LabelSelector{}
+	if w.NamespaceSelector != nil {
+		selector = *w.NamespaceSelector
+	}
+	if len(selector.MatchLabels) == 0 {
+		selector.MatchLabels = nil
+	}
+	w.NamespaceSelector = &selector
+	rules := make([]admissionreg.RuleWithOperations, len(w.Rules))
+	for i, rule := range w.Rules {
+		rule.Scope = nil
+		rules[i] = rule
+	}
+	w.Rules = rules
+	if w.ClientConfig.Service != nil && w.ClientConfig.Service.Port == nil {
+		service := *w.ClientConfig.Service
+		port := int32(defaultServicePort)
+		service.Port = &port
+		w.ClientConfig.Service = &service
+	}
+	// settings that aren't set are given the apiserver's defaults, so that they compare equal once registered
+	if w.TimeoutSeconds == nil {
+		timeout := int32(maxTimeoutSeconds)
+		w.TimeoutSeconds = &timeout
+	}
+	if w.SideEffects == nil {
+		unknown := admissionreg.SideEffectClassUnknown
+		w.SideEffects = &unknown
+	}
+	if w.ReinvocationPolicy != nil && *w.ReinvocationPolicy == admissionreg.NeverReinvocationPolicy {
+		w.ReinvocationPolicy = nil
+	}
+	if len(w.AdmissionReviewVersions) == 0 {
+		w.AdmissionReviewVersions = []string{AdmissionVersionV1beta1}
+	}
+	return w
+}
@@ -0,0 +1,212 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testRegistrationServer() Server {
+	return Server{CompanyDomain: "acme.com", Namespace: "kube-graffiti", Service: "kube-graffiti", CACert: []byte("ca")}
+}
+
+func testRegistration() Registration {
+	return Registration{
+		Name:              "label-pods",
+		Resources:         []string{"pods"},
+		NamespaceSelector: "team=web",
+		FailurePolicy:     "Fail",
+	}
+}
+
+func TestRegisterHookWithRetryRetriesFailures(t *testing.T) {
+	defer func(b wait.Backoff) { registrationBackoff = b }(registrationBackoff)
+	registrationBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+
+	clientset := fake.NewSimpleClientset()
+	failures := 2
+	clientset.PrependReactor("create", "mutatingwebhookconfigurations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			failures--
+			return true, nil, errors.New("apiserver unavailable")
+		}
+		return false, nil, nil
+	})
+	s := testRegistrationServer()
+	require.NoError(t, s.RegisterHookWithRetry(testRegistration(), clientset))
+	_, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-label-pods", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	failures = 10
+	err = s.RegisterHookWithRetry(Registration{Name: "other", Resources: []string{"pods"}, FailurePolicy: "Ignore"}, clientset)
+	assert.EqualError(t, err, "failed to register webhook after 3 attempts: failed to create the webhook configuration: apiserver unavailable")
+	assert.False(t, errors.Is(err, ErrRegistrationConflict), "only conflicts with someone else's change are registration conflicts")
+}
+
+func TestRegistrationConflictsAreWrapped(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "mutatingwebhookconfigurations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewAlreadyExists(schema.GroupResource{Group: "admissionregistration.k8s.io", Resource: "mutatingwebhookconfigurations"}, "label-pods")
+	})
+	err := testRegistrationServer().RegisterHook(testRegistration(), clientset)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrRegistrationConflict))
+	assert.Contains(t, err.Error(), `mutatingwebhookconfigurations.admissionregistration.k8s.io "label-pods" already exists`, "the apiserver's error is kept")
+}
+
+func TestReconcileHookHealsDeletedAndDriftedRegistrations(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := testRegistrationServer()
+	r := testRegistration()
+	client := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	changed, err := s.ReconcileHook(r, clientset)
+	require.NoError(t, err)
+	assert.True(t, changed, "a missing registration should be registered")
+
+	changed, err = s.ReconcileHook(r, clientset)
+	require.NoError(t, err)
+	assert.False(t, changed, "a registration that is in place should be left alone")
+
+	// the apiserver fills in defaults, which are not drift
+	config, err := client.Get("09999-label-pods", metav1.GetOptions{})
+	require.NoError(t, err)
+	scope := admissionreg.AllScopes
+	port := int32(443)
+	config.Webhooks[0].Rules[0].Scope = &scope
+	config.Webhooks[0].ClientConfig.Service.Port = &port
+	_, err = client.Update(config)
+	require.NoError(t, err)
+	changed, err = s.ReconcileHook(r, clientset)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	ignore := admissionreg.Ignore
+	config.Webhooks[0].FailurePolicy = &ignore
+	_, err = client.Update(config)
+	require.NoError(t, err)
+	changed, err = s.ReconcileHook(r, clientset)
+	require.NoError(t, err)
+	assert.True(t, changed, "a changed failure policy is drift")
+	config, err = client.Get("09999-label-pods", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, admissionreg.Fail, *config.Webhooks[0].FailurePolicy)
+}
+
+func TestRegisterHookOnlyUpdatesChangedRegistrations(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := testRegistrationServer()
+	r := testRegistration()
+
+	require.NoError(t, s.RegisterHook(r, clientset))
+	clientset.ClearActions()
+	require.NoError(t, s.RegisterHook(r, clientset))
+	for _, action := range clientset.Actions() {
+		assert.Equal(t, "get", action.GetVerb(), "an unchanged registration should not be written")
+	}
+
+	r.FailurePolicy = "Ignore"
+	clientset.ClearActions()
+	require.NoError(t, s.RegisterHook(r, clientset))
+	var verbs []string
+	for _, action := range clientset.Actions() {
+		verbs = append(verbs, action.GetVerb())
+	}
+	assert.Equal(t, []string{"get", "update"}, verbs, "a changed registration is updated in place rather than recreated")
+
+	diff := desiredWebhook(t, r).diff(desiredWebhook(t, testRegistration()))
+	assert.Len(t, diff, 1)
+	assert.Equal(t, webhookChange{Current: failurePolicy(admissionreg.Fail), Desired: failurePolicy(admissionreg.Ignore)}, diff["failurePolicy"])
+}
+
+func desiredWebhook(t *testing.T, r Registration) registeredWebhook {
+	w, err := testRegistrationServer().desiredWebhook(r)
+	require.NoError(t, err)
+	return w
+}
+
+func failurePolicy(p admissionreg.FailurePolicyType) *admissionreg.FailurePolicyType {
+	return &p
+}
+
+func TestKeepRegisteredStopsOnShutdown(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := testRegistrationServer()
+	r := testRegistration()
+	r.Type = "validating"
+	client := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+
+	s.KeepRegistered([]Registration{r}, clientset, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, err := client.Get("09999-label-pods", metav1.GetOptions{})
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "the deleted registration should be healed")
+
+	s.stopRegistrar()
+	require.NoError(t, client.Delete("09999-label-pods", nil))
+	time.Sleep(50 * time.Millisecond)
+	_, err := client.Get("09999-label-pods", metav1.GetOptions{})
+	assert.Error(t, err, "registrations should not be healed once stopped")
+}
+
+func TestUnsetWebhookSettingsMatchTheApiserverDefaults(t *testing.T) {
+	s := testRegistrationServer()
+	s.SetAdmissionVersion(AdmissionVersionV1beta1)
+	desired, err := s.desiredWebhook(testRegistration())
+	require.NoError(t, err)
+	assert.Nil(t, desired.TimeoutSeconds, "unset settings are left for the v1beta1 apiserver to default")
+
+	// the apiserver fills in its defaults, which shouldn't make the registration look changed
+	registered := desired.mutatingWebhook()
+	timeout := int32(30)
+	unknown := admissionreg.SideEffectClassUnknown
+	never := admissionreg.NeverReinvocationPolicy
+	registered.TimeoutSeconds, registered.SideEffects, registered.ReinvocationPolicy = &timeout, &unknown, &never
+	assert.True(t, desired.equal(registeredMutatingWebhook(registered)))
+
+	r := testRegistration()
+	r.TimeoutSeconds = 10
+	changed, err := s.desiredWebhook(r)
+	require.NoError(t, err)
+	diff := changed.diff(registeredMutatingWebhook(registered))
+	assert.Len(t, diff, 1)
+	assert.Contains(t, diff, "timeoutSeconds")
+}
+
+func TestV1WebhooksSetTheSettingsThatTheApiRequires(t *testing.T) {
+	desired := desiredWebhook(t, testRegistration())
+	require.NotNil(t, desired.TimeoutSeconds)
+	assert.Equal(t, int32(10), *desired.TimeoutSeconds)
+	require.NotNil(t, desired.SideEffects)
+	assert.Equal(t, admissionreg.SideEffectClassNoneOnDryRun, *desired.SideEffects)
+	assert.Equal(t, []string{"v1", "v1beta1"}, desired.AdmissionReviewVersions)
+
+	r := testRegistration()
+	r.SideEffects = "Some"
+	_, err := testRegistrationServer().desiredWebhook(r)
+	assert.EqualError(t, err, "rule 'label-pods' has side-effects 'Some', which is only allowed with server.admission-version v1beta1, must be either None or NoneOnDryRun")
+}
@@ -18,17 +18,150 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
 	"github.com/Telefonica/kube-graffiti/pkg/log"
-	admissionreg "k8s.io/api/admissionregistration/v1beta1"
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
+const (
+	// ShutdownActionNone leaves webhook registrations in place when kube-graffiti shuts down.
+	ShutdownActionNone = "none"
+	// ShutdownActionDelete removes our webhook registrations when kube-graffiti shuts down.
+	ShutdownActionDelete = "delete"
+	// ShutdownActionIgnore sets the failure policy of our webhook registrations to Ignore when kube-graffiti shuts down.
+	ShutdownActionIgnore = "ignore"
+)
+
+// ErrRegistrationConflict is returned, wrapped, when the webhook configuration of a rule could not be created or
+// brought in line with the rule because it was created or changed by someone else at the same time.
+var ErrRegistrationConflict = errors.New("webhook registration conflict")
+
+// registrationError wraps an error of the apiserver from an action on a rule's webhook configuration, as an
+// ErrRegistrationConflict when it is a conflict with someone else's change.
+func registrationError(action string, err error) error {
+	if apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("%w: %v", ErrRegistrationConflict, err)
+	}
+	return fmt.Errorf("failed to %s the webhook configuration: %w", action, err)
+}
+
+// Registration contains the settings needed to register a rule as a webhook with the kubernetes api.
+// Type selects whether the rule is registered as a mutating (the default) or a validating webhook.
+// Resources is a shorthand for Targets, see AllTargets.
 type Registration struct {
 	Name              string   `mapstructure:"name" yaml:"name"`
+	Type              string   `mapstructure:"type" yaml:"type,omitempty"`
 	Targets           []Target `mapstructure:"targets" yaml:"targets"`
+	Resources         []string `mapstructure:"resources" yaml:"resources,omitempty"`
 	NamespaceSelector string   `mapstructure:"namespace-selector" yaml:"namespace-selector,omitempty"`
 	FailurePolicy     string   `mapstructure:"failure-policy" yaml:"failure-policy"`
+	// Operations are the operations that the webhook is called for, any of CREATE, UPDATE, DELETE and CONNECT or '*'.
+	// They are CREATE and UPDATE by default.
+	Operations []string `mapstructure:"operations" yaml:"operations,omitempty"`
+	// TimeoutSeconds is how long the apiserver waits for the webhook, from 1 to 30 seconds, before applying the
+	// failure policy.  SideEffects is one of None, NoneOnDryRun, Some or Unknown, and ReinvocationPolicy, which only
+	// mutating rules have, is either Never or IfNeeded.  The apiserver's defaults are used when they aren't set.
+	TimeoutSeconds     int32  `mapstructure:"timeout-seconds" yaml:"timeout-seconds,omitempty"`
+	SideEffects        string `mapstructure:"side-effects" yaml:"side-effects,omitempty"`
+	ReinvocationPolicy string `mapstructure:"reinvocation-policy" yaml:"reinvocation-policy,omitempty"`
+	// Listener is the name of the server.listeners entry that serves the rule, the main webhook server when it is
+	// empty.
+	Listener string `mapstructure:"listener" yaml:"listener,omitempty"`
+	// Priority is the priority of the rule, which orders its webhook configuration, see ConfigurationName.  It is
+	// configured on the rule rather than its registration.
+	Priority int `mapstructure:"-" yaml:"-"`
+}
+
+const (
+	// MaxPriority and MinPriority bound the priorities of rules, so that the names of their webhook configurations
+	// sort by priority.
+	MaxPriority = 9999
+	MinPriority = -9999
+)
+
+// ConfigurationName is the name of the registration's Mutating/ValidatingWebhookConfiguration.  The apiserver calls
+// the webhooks of different configurations in the order of their names, so the name starts with the priority counted
+// down from MaxPriority, which calls the rules with a higher priority first and those with the same priority in the
+// order of their names.
+func (r Registration) ConfigurationName() string {
+	return fmt.Sprintf("%05d-%s", MaxPriority-r.Priority, r.Name)
+}
+
+const (
+	// maxTimeoutSeconds is the longest that the apiserver waits for a webhook, which is also its default.
+	maxTimeoutSeconds = 30
+	// defaultServicePort is the port of the service that the apiserver calls when a webhook doesn't give one.
+	defaultServicePort = 443
+)
+
+var (
+	sideEffectClasses    = []admissionreg.SideEffectClass{admissionreg.SideEffectClassNone, admissionreg.SideEffectClassNoneOnDryRun, admissionreg.SideEffectClassSome, admissionreg.SideEffectClassUnknown}
+	reinvocationPolicies = []admissionreg.ReinvocationPolicyType{admissionreg.NeverReinvocationPolicy, admissionreg.IfNeededReinvocationPolicy}
+)
+
+// timeoutSeconds is the registration's timeout, or nil for the apiserver's default.
+func (r Registration) timeoutSeconds() *int32 {
+	if r.TimeoutSeconds == 0 {
+		return nil
+	}
+	timeout := r.TimeoutSeconds
+	return &timeout
+}
+
+// sideEffects is the registration's side effect class, matched regardless of case, or nil for the apiserver's default.
+func (r Registration) sideEffects() (*admissionreg.SideEffectClass, error) {
+	if r.SideEffects == "" {
+		return nil, nil
+	}
+	for _, class := range sideEffectClasses {
+		if strings.EqualFold(r.SideEffects, string(class)) {
+			return &class, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid side-effects '%s', must be one of None, NoneOnDryRun, Some or Unknown", r.SideEffects)
+}
+
+// reinvocationPolicy is the registration's reinvocation policy, matched regardless of case, or nil for the
+// apiserver's default.
+func (r Registration) reinvocationPolicy() (*admissionreg.ReinvocationPolicyType, error) {
+	if r.ReinvocationPolicy == "" {
+		return nil, nil
+	}
+	for _, policy := range reinvocationPolicies {
+		if strings.EqualFold(r.ReinvocationPolicy, string(policy)) {
+			return &policy, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid reinvocation-policy '%s', must be either Never or IfNeeded", r.ReinvocationPolicy)
+}
+
+// defaultOperations are the operations that a registration without any is called for.
+var defaultOperations = []admissionreg.OperationType{admissionreg.Create, admissionreg.Update}
+
+// AllOperations returns the operations that the webhook is called for.
+func (r Registration) AllOperations() []admissionreg.OperationType {
+	if len(r.Operations) == 0 {
+		return defaultOperations
+	}
+	var operations []admissionreg.OperationType
+	for _, op := range r.Operations {
+		operations = append(operations, admissionreg.OperationType(strings.ToUpper(op)))
+	}
+	return operations
+}
+
+// HasOperation is true when the webhook is called for the operation.
+func (r Registration) HasOperation(operation admissionreg.OperationType) bool {
+	for _, op := range r.AllOperations() {
+		if op == operation || op == admissionreg.OperationAll {
+			return true
+		}
+	}
+	return false
 }
 
 // Target defines a kubernetes compatible admissionreg.Rule but with mapstructure tags so that we can
@@ -39,36 +172,179 @@ type Target struct {
 	Resources   []string `mapstructure:"resources" yaml:"resources"`
 }
 
-// RegisterHook registers our webhook as MutatingWebhook with the kubernetes api.
-func (s Server) RegisterHook(r Registration, clientset *kubernetes.Clientset) error {
-	mylog := log.ComponentLogger(componentName, "RegisterHook")
+// IsValidating returns true when the registration is for a validating rather than a mutating webhook.
+func (r Registration) IsValidating() bool {
+	return r.Type == graffiti.RuleTypeValidating
+}
+
+// RegisterHook registers our webhook as a MutatingWebhook, or a ValidatingWebhook for validating rules, with the kubernetes api.
+// An existing registration is only updated when it differs from ours, so that its resourceVersion doesn't churn.
+func (s Server) RegisterHook(r Registration, clientset kubernetes.Interface) error {
+	desired, err := s.desiredWebhook(r)
+	if err != nil {
+		return err
+	}
+
+	var created bool
+	if r.IsValidating() {
+		created, err = s.registerValidatingHook(r, desired, clientset)
+	} else {
+		created, err = s.registerMutatingHook(r, desired, clientset)
+	}
+	if err != nil || !created {
+		return err
+	}
+	return s.removeStaleConfigurations(r, desired.Name, clientset)
+}
+
+// removeStaleConfigurations deletes the configurations that hold the registration's webhook under another name, once
+// its configuration has been created, because the priority of its rule has changed or it was registered before the
+// names had priorities, so that the apiserver doesn't call the rule twice.
+func (s Server) removeStaleConfigurations(r Registration, webhook string, clientset kubernetes.Interface) error {
+	mylog := log.ComponentLogger(componentName, "removeStaleConfigurations")
+	listWebhooks := listConfigurationWebhooks
+	if s.legacyRegistrations() {
+		listWebhooks = listConfigurationWebhooksV1beta1
+	}
+	configurations, err := listWebhooks(r, clientset)
+	if err != nil {
+		return fmt.Errorf("failed to list the webhook configurations: %v", err)
+	}
+	for name, webhooks := range configurations {
+		if name == r.ConfigurationName() || len(webhooks) != 1 || webhooks[0] != webhook {
+			continue
+		}
+		mylog.Info().Str("name", r.Name).Str("configuration", name).Msg("deleting the webhook configuration that the rule was registered with before")
+		if err := s.deleteConfiguration(r, name, clientset); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete the stale webhook configuration %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// listConfigurationWebhooks returns the names of the webhooks of each of the configurations of the registration's
+// type, keyed by the configuration's name.
+func listConfigurationWebhooks(r Registration, clientset kubernetes.Interface) (map[string][]string, error) {
+	configurations := make(map[string][]string)
+	if r.IsValidating() {
+		list, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range list.Items {
+			configurations[config.Name] = nil
+			for _, w := range config.Webhooks {
+				configurations[config.Name] = append(configurations[config.Name], w.Name)
+			}
+		}
+		return configurations, nil
+	}
+	list, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range list.Items {
+		configurations[config.Name] = nil
+		for _, w := range config.Webhooks {
+			configurations[config.Name] = append(configurations[config.Name], w.Name)
+		}
+	}
+	return configurations, nil
+}
+
+// deleteConfiguration deletes the named configuration of the registration's type.
+func (s Server) deleteConfiguration(r Registration, name string, clientset kubernetes.Interface) error {
+	switch {
+	case s.legacyRegistrations():
+		return deleteHookV1beta1(r, name, clientset)
+	case r.IsValidating():
+		return clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(name, nil)
+	default:
+		return clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(name, nil)
+	}
+}
+
+// desiredWebhook is the webhook that we register for the registration.
+func (s Server) desiredWebhook(r Registration) (registeredWebhook, error) {
+	selector, failurePolicy, rules, err := r.webhookSettings()
+	if err != nil {
+		return registeredWebhook{}, err
+	}
+	sideEffects, err := r.sideEffects()
+	if err != nil {
+		return registeredWebhook{}, err
+	}
+	reinvocationPolicy, err := r.reinvocationPolicy()
+	if err != nil {
+		return registeredWebhook{}, err
+	}
+	if err := r.ValidateForAdmissionVersion(s.admissionVersion); err != nil {
+		return registeredWebhook{}, err
+	}
+	desired := registeredWebhook{
+		Name:                    r.Name + "." + s.CompanyDomain,
+		FailurePolicy:           &failurePolicy,
+		NamespaceSelector:       watchedSelector(selector, s.watchNamespaces),
+		Rules:                   rules,
+		TimeoutSeconds:          r.timeoutSeconds(),
+		SideEffects:             sideEffects,
+		ReinvocationPolicy:      reinvocationPolicy,
+		AdmissionReviewVersions: admissionReviewVersions(s.admissionVersion),
+	}
+	desired.defaultSettings(s.admissionVersion)
+	if r.IsValidating() {
+		desired.ClientConfig = s.clientConfig(validatingPathFromName(r.Name))
+	} else {
+		desired.ClientConfig = s.clientConfig(pathFromName(r.Name))
+	}
+	if desired.ClientConfig, err = s.listenerClientConfig(r, desired.ClientConfig); err != nil {
+		return registeredWebhook{}, err
+	}
+	return desired, nil
+}
+
+// namespaceNameLabel is set by kubernetes on every namespace, to the namespace's name.
+const namespaceNameLabel = "kubernetes.io/metadata.name"
+
+// watchedSelector adds a requirement that the namespace is one of the watched namespaces to a namespace selector, when
+// there are any.
+func watchedSelector(selector *metav1.LabelSelector, namespaces []string) *metav1.LabelSelector {
+	if len(namespaces) == 0 {
+		return selector
+	}
+	watched := &metav1.LabelSelector{}
+	if selector != nil {
+		watched = selector.DeepCopy()
+	}
+	watched.MatchExpressions = append(watched.MatchExpressions, metav1.LabelSelectorRequirement{
+		Key:      namespaceNameLabel,
+		Operator: metav1.LabelSelectorOpIn,
+		Values:   namespaces,
+	})
+	return watched
+}
+
+// webhookSettings converts the registration into the settings of its webhook.
+func (r Registration) webhookSettings() (*metav1.LabelSelector, admissionreg.FailurePolicyType, []admissionreg.RuleWithOperations, error) {
+	mylog := log.ComponentLogger(componentName, "webhookSettings")
 
 	selector, err := metav1.ParseToLabelSelector(r.NamespaceSelector)
 	if err != nil {
 		mylog.Error().Err(err).Str("namespace-selector", r.NamespaceSelector).Msg("could not parse the namespace selector")
-		return fmt.Errorf("could not parse the namespace selector: %v", err)
+		return nil, "", nil, fmt.Errorf("could not parse the namespace selector: %v", err)
 	}
 
 	var failurePolicy admissionreg.FailurePolicyType
 	failurePolicy = admissionreg.FailurePolicyType(strings.Title(r.FailurePolicy))
 	if failurePolicy != admissionreg.Ignore && failurePolicy != admissionreg.Fail {
 		mylog.Error().Err(err).Str("policy", strings.Title(r.FailurePolicy)).Msg("invalid admission registration failure policy type, must be 'Ignore' or 'Fail'")
-		return fmt.Errorf("invalid admission registration failure policy type")
-	}
-
-	client := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
-	_, err = client.Get(r.Name, metav1.GetOptions{})
-	if err == nil {
-		if err := client.Delete(r.Name, nil); err != nil {
-			mylog.Error().Err(err).Str("name", r.Name).Msg("failed to delete the webhook")
-			return errors.New("failed to delete the webhook")
-		}
+		return nil, "", nil, fmt.Errorf("invalid admission registration failure policy type")
 	}
 
 	var rules []admissionreg.RuleWithOperations
-	for _, target := range r.Targets {
+	for _, target := range r.AllTargets() {
 		rules = append(rules, admissionreg.RuleWithOperations{
-			Operations: []admissionreg.OperationType{admissionreg.Create, admissionreg.Update},
+			Operations: r.AllOperations(),
 			Rule: admissionreg.Rule{
 				APIGroups:   target.APIGroups,
 				APIVersions: target.APIVersions,
@@ -76,33 +352,199 @@ func (s Server) RegisterHook(r Registration, clientset *kubernetes.Clientset) er
 			},
 		})
 	}
+	return selector, failurePolicy, rules, nil
+}
 
-	path := pathFromName(r.Name)
-	webhookConfig := &admissionreg.MutatingWebhookConfiguration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: r.Name,
-		},
-		Webhooks: []admissionreg.MutatingWebhook{
-			{
-				Name:              r.Name + "." + s.CompanyDomain,
-				FailurePolicy:     &failurePolicy,
-				NamespaceSelector: selector,
-				Rules:             rules,
-				ClientConfig: admissionreg.WebhookClientConfig{
-					Service: &admissionreg.ServiceReference{
-						Namespace: s.Namespace,
-						Name:      s.Service,
-						Path:      &path,
-					},
-					CABundle: s.CACert,
-				},
+// registerMutatingHook creates or updates the registration's MutatingWebhookConfiguration, it returns true when the
+// configuration was created.
+func (s Server) registerMutatingHook(r Registration, desired registeredWebhook, clientset kubernetes.Interface) (bool, error) {
+	mylog := log.ComponentLogger(componentName, "registerMutatingHook")
+	rlog := mylog.With().Str("name", r.Name).Logger()
+
+	if s.legacyRegistrations() {
+		return registerMutatingHookV1beta1(r, desired, clientset, rlog)
+	}
+	webhook := desired.mutatingWebhook()
+	client := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	current, err := client.Get(r.ConfigurationName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		webhookConfig := &admissionreg.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: r.ConfigurationName(),
 			},
+			Webhooks: []admissionreg.MutatingWebhook{webhook},
+		}
+		if _, err := client.Create(webhookConfig); err != nil {
+			rlog.Error().Err(err).Msg("webhook registration failed")
+			return false, registrationError("create", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		rlog.Error().Err(err).Msg("failed to get the webhook")
+		return false, registrationError("get", err)
+	}
+
+	var registered []registeredWebhook
+	for _, w := range current.Webhooks {
+		registered = append(registered, registeredMutatingWebhook(w))
+	}
+	if !desired.needsUpdate(registered, rlog) {
+		return false, nil
+	}
+	current.Webhooks = []admissionreg.MutatingWebhook{webhook}
+	if _, err := client.Update(current); err != nil {
+		rlog.Error().Err(err).Msg("webhook registration failed")
+		return false, registrationError("update", err)
+	}
+	return false, nil
+}
+
+// registerValidatingHook creates or updates the registration's ValidatingWebhookConfiguration, it returns true when the
+// configuration was created.
+func (s Server) registerValidatingHook(r Registration, desired registeredWebhook, clientset kubernetes.Interface) (bool, error) {
+	mylog := log.ComponentLogger(componentName, "registerValidatingHook")
+	rlog := mylog.With().Str("name", r.Name).Logger()
+
+	if s.legacyRegistrations() {
+		return registerValidatingHookV1beta1(r, desired, clientset, rlog)
+	}
+	webhook := desired.validatingWebhook()
+	client := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	current, err := client.Get(r.ConfigurationName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		webhookConfig := &admissionreg.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: r.ConfigurationName(),
+			},
+			Webhooks: []admissionreg.ValidatingWebhook{webhook},
+		}
+		if _, err := client.Create(webhookConfig); err != nil {
+			rlog.Error().Err(err).Msg("webhook registration failed")
+			return false, registrationError("create", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		rlog.Error().Err(err).Msg("failed to get the webhook")
+		return false, registrationError("get", err)
+	}
+
+	var registered []registeredWebhook
+	for _, w := range current.Webhooks {
+		registered = append(registered, registeredValidatingWebhook(w))
+	}
+	if !desired.needsUpdate(registered, rlog) {
+		return false, nil
+	}
+	current.Webhooks = []admissionreg.ValidatingWebhook{webhook}
+	if _, err := client.Update(current); err != nil {
+		rlog.Error().Err(err).Msg("webhook registration failed")
+		return false, registrationError("update", err)
+	}
+	return false, nil
+}
+
+// clientConfig tells the apiserver how to call us on the given path
+func (s Server) clientConfig(path string) admissionreg.WebhookClientConfig {
+	return admissionreg.WebhookClientConfig{
+		Service: &admissionreg.ServiceReference{
+			Namespace: s.Namespace,
+			Name:      s.Service,
+			Path:      &path,
 		},
+		CABundle: s.CACert,
 	}
-	if _, err := client.Create(webhookConfig); err != nil {
-		mylog.Error().Err(err).Str("name", r.Name).Msg("webhook registration failed")
-		return errors.New("webhook registration failed")
+}
+
+// DeregisterHook is used when shutting down to make sure that a stopped kube-graffiti can not block the creation of objects.
+// Depending on the action it will either delete the Mutating/ValidatingWebhookConfiguration or set its failure policy to Ignore.
+func (s Server) DeregisterHook(r Registration, action string, clientset kubernetes.Interface) error {
+	mylog := log.ComponentLogger(componentName, "DeregisterHook")
+	rlog := mylog.With().Str("name", r.Name).Str("action", action).Bool("validating", r.IsValidating()).Logger()
+
+	switch action {
+	case ShutdownActionNone, "":
+		rlog.Debug().Msg("leaving webhook registration in place")
+		return nil
+	case ShutdownActionDelete:
+		rlog.Info().Msg("deleting webhook registration")
+		if err := s.deleteConfiguration(r, r.ConfigurationName(), clientset); err != nil {
+			rlog.Error().Err(err).Msg("failed to delete the webhook")
+			return fmt.Errorf("failed to delete the webhook: %v", err)
+		}
+		return nil
+	case ShutdownActionIgnore:
+		rlog.Info().Msg("setting webhook registration failure policy to Ignore")
+		var err error
+		switch {
+		case s.legacyRegistrations():
+			err = ignoreHookV1beta1(r, clientset)
+		case r.IsValidating():
+			err = ignoreValidatingHook(r.ConfigurationName(), clientset)
+		default:
+			err = ignoreMutatingHook(r.ConfigurationName(), clientset)
+		}
+		if err != nil {
+			rlog.Error().Err(err).Msg("failed to set the webhook failure policy")
+		}
+		return err
+	default:
+		rlog.Error().Msg("invalid shutdown action, must be one of 'none', 'delete' or 'ignore'")
+		return fmt.Errorf("invalid shutdown action '%s'", action)
 	}
+}
 
+// ignoreFailures sets the failure policy of a webhook configuration's webhooks to Ignore, for each version and kind of
+// configuration: get fetches the configuration, ignore sets the failure policies and update writes it back.  The
+// configuration is fetched again when another update has changed it in the meantime.
+func ignoreFailures(get func() error, ignore func(), update func() error) error {
+	action := "get"
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		action = "get"
+		if err := get(); err != nil {
+			return err
+		}
+		ignore()
+		action = "update"
+		return update()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to %s the webhook: %v", action, err)
+	}
 	return nil
 }
+
+func ignoreMutatingHook(name string, clientset kubernetes.Interface) error {
+	client := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	var webhookConfig *admissionreg.MutatingWebhookConfiguration
+	ignore := admissionreg.Ignore
+	return ignoreFailures(func() (err error) {
+		webhookConfig, err = client.Get(name, metav1.GetOptions{})
+		return err
+	}, func() {
+		for i := range webhookConfig.Webhooks {
+			webhookConfig.Webhooks[i].FailurePolicy = &ignore
+		}
+	}, func() error {
+		_, err := client.Update(webhookConfig)
+		return err
+	})
+}
+
+func ignoreValidatingHook(name string, clientset kubernetes.Interface) error {
+	client := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	var webhookConfig *admissionreg.ValidatingWebhookConfiguration
+	ignore := admissionreg.Ignore
+	return ignoreFailures(func() (err error) {
+		webhookConfig, err = client.Get(name, metav1.GetOptions{})
+		return err
+	}, func() {
+		for i := range webhookConfig.Webhooks {
+			webhookConfig.Webhooks[i].FailurePolicy = &ignore
+		}
+	}, func() error {
+		_, err := client.Update(webhookConfig)
+		return err
+	})
+}
@@ -0,0 +1,281 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testWebhookConfiguration(name string) *admissionreg.MutatingWebhookConfiguration {
+	fail := admissionreg.Fail
+	return &admissionreg.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: Registration{Name: name}.ConfigurationName(),
+		},
+		Webhooks: []admissionreg.MutatingWebhook{
+			{
+				Name:          name + ".acme.com",
+				FailurePolicy: &fail,
+			},
+		},
+	}
+}
+
+func TestDeregisterHookDeletesRegistration(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testWebhookConfiguration("test-rule"))
+	s := Server{CompanyDomain: "acme.com"}
+
+	err := s.DeregisterHook(Registration{Name: "test-rule"}, ShutdownActionDelete, clientset)
+	require.NoError(t, err)
+
+	_, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-test-rule", metav1.GetOptions{})
+	assert.Error(t, err, "the webhook configuration should have been deleted")
+}
+
+func TestDeregisterHookSetsFailurePolicyToIgnore(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testWebhookConfiguration("test-rule"))
+	s := Server{CompanyDomain: "acme.com"}
+
+	err := s.DeregisterHook(Registration{Name: "test-rule"}, ShutdownActionIgnore, clientset)
+	require.NoError(t, err)
+
+	wc, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-test-rule", metav1.GetOptions{})
+	require.NoError(t, err, "the webhook configuration should still exist")
+	assert.Equal(t, admissionreg.Ignore, *wc.Webhooks[0].FailurePolicy)
+}
+
+func TestDeregisterHookRetriesConflictsWhenSettingTheFailurePolicy(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&admissionreg.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "09999-test-rule"},
+		Webhooks:   []admissionreg.ValidatingWebhook{{Name: "test-rule.acme.com"}},
+	})
+	conflicts := 1
+	clientset.PrependReactor("update", "validatingwebhookconfigurations", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Group: "admissionregistration.k8s.io", Resource: "validatingwebhookconfigurations"}, "09999-test-rule", errors.New("modified"))
+		}
+		return false, nil, nil
+	})
+	s := Server{CompanyDomain: "acme.com"}
+
+	require.NoError(t, s.DeregisterHook(Registration{Name: "test-rule", Type: "validating"}, ShutdownActionIgnore, clientset))
+	wc, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get("09999-test-rule", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, admissionreg.Ignore, *wc.Webhooks[0].FailurePolicy)
+}
+
+func TestDeregisterHookNoneLeavesRegistrationAlone(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testWebhookConfiguration("test-rule"))
+	s := Server{CompanyDomain: "acme.com"}
+
+	err := s.DeregisterHook(Registration{Name: "test-rule"}, ShutdownActionNone, clientset)
+	require.NoError(t, err)
+
+	wc, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-test-rule", metav1.GetOptions{})
+	require.NoError(t, err, "the webhook configuration should still exist")
+	assert.Equal(t, admissionreg.Fail, *wc.Webhooks[0].FailurePolicy)
+}
+
+func TestDeregisterHookInvalidAction(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testWebhookConfiguration("test-rule"))
+	s := Server{CompanyDomain: "acme.com"}
+
+	err := s.DeregisterHook(Registration{Name: "test-rule"}, "explode", clientset)
+	assert.EqualError(t, err, "invalid shutdown action 'explode'")
+}
+
+func TestRegisterValidatingHook(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := Server{CompanyDomain: "acme.com", Namespace: "kube-graffiti", Service: "kube-graffiti"}
+	r := Registration{
+		Name:          "test-rule",
+		Type:          "validating",
+		Targets:       []Target{{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"namespaces"}}},
+		FailurePolicy: "ignore",
+	}
+
+	err := s.RegisterHook(r, clientset)
+	require.NoError(t, err)
+
+	wc, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get("09999-test-rule", metav1.GetOptions{})
+	require.NoError(t, err, "a validating webhook configuration should have been created")
+	require.Len(t, wc.Webhooks, 1)
+	assert.Equal(t, "test-rule.acme.com", wc.Webhooks[0].Name)
+	assert.Equal(t, "/graffiti-validate/test-rule", *wc.Webhooks[0].ClientConfig.Service.Path)
+
+	_, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-test-rule", metav1.GetOptions{})
+	assert.Error(t, err, "a mutating webhook configuration should not have been created")
+}
+
+func TestDeregisterValidatingHookDeletesRegistration(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&admissionreg.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "09999-test-rule"},
+	})
+	s := Server{CompanyDomain: "acme.com"}
+
+	err := s.DeregisterHook(Registration{Name: "test-rule", Type: "validating"}, ShutdownActionDelete, clientset)
+	require.NoError(t, err)
+
+	_, err = clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get("09999-test-rule", metav1.GetOptions{})
+	assert.Error(t, err, "the webhook configuration should have been deleted")
+}
+
+func TestRegisterHookWithOperations(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := Server{CompanyDomain: "acme.com", Namespace: "kube-graffiti", Service: "kube-graffiti"}
+	r := Registration{
+		Name:          "protect-labels",
+		Type:          "validating",
+		Resources:     []string{"namespaces"},
+		FailurePolicy: "Fail",
+		Operations:    []string{"update", "DELETE"},
+	}
+	require.NoError(t, r.Validate())
+	require.NoError(t, s.RegisterHook(r, clientset))
+
+	wc, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get("09999-protect-labels", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []admissionreg.OperationType{admissionreg.Update, admissionreg.Delete}, wc.Webhooks[0].Rules[0].Operations)
+	assert.True(t, r.HasOperation(admissionreg.Delete))
+	assert.False(t, r.HasOperation(admissionreg.Create))
+	assert.True(t, Registration{}.HasOperation(admissionreg.Create), "rules are called for creates and updates by default")
+
+	r.Operations = []string{"PATCH"}
+	assert.EqualError(t, r.Validate(), "rule 'protect-labels' has an invalid registration: invalid operation 'PATCH', must be one of CREATE, UPDATE, DELETE, CONNECT or '*'")
+	r.Operations = []string{"*", "DELETE"}
+	assert.EqualError(t, r.Validate(), "rule 'protect-labels' has an invalid registration: operations can not mix the '*' wildcard with other entries")
+}
+
+func TestRegisterHookWithWebhookSettings(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := Server{CompanyDomain: "acme.com", Namespace: "kube-graffiti", Service: "kube-graffiti"}
+	r := Registration{
+		Name:               "label-pods",
+		Resources:          []string{"pods"},
+		FailurePolicy:      "Ignore",
+		TimeoutSeconds:     5,
+		SideEffects:        "noneondryrun",
+		ReinvocationPolicy: "IfNeeded",
+	}
+	require.NoError(t, r.Validate())
+	require.NoError(t, s.RegisterHook(r, clientset))
+
+	wc, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-label-pods", metav1.GetOptions{})
+	require.NoError(t, err)
+	w := wc.Webhooks[0]
+	require.NotNil(t, w.TimeoutSeconds)
+	assert.Equal(t, int32(5), *w.TimeoutSeconds)
+	require.NotNil(t, w.SideEffects)
+	assert.Equal(t, admissionreg.SideEffectClassNoneOnDryRun, *w.SideEffects)
+	require.NotNil(t, w.ReinvocationPolicy)
+	assert.Equal(t, admissionreg.IfNeededReinvocationPolicy, *w.ReinvocationPolicy)
+
+	r.TimeoutSeconds = 31
+	assert.EqualError(t, r.Validate(), "rule 'label-pods' has an invalid registration: timeout-seconds must be between 1 and 30")
+	r.TimeoutSeconds = 0
+	r.SideEffects = "Sometimes"
+	assert.EqualError(t, r.Validate(), "rule 'label-pods' has an invalid registration: invalid side-effects 'Sometimes', must be one of None, NoneOnDryRun, Some or Unknown")
+	r.SideEffects = ""
+	r.ReinvocationPolicy = "Always"
+	assert.EqualError(t, r.Validate(), "rule 'label-pods' has an invalid registration: invalid reinvocation-policy 'Always', must be either Never or IfNeeded")
+	r.ReinvocationPolicy = "Never"
+	r.Type = "validating"
+	assert.EqualError(t, r.Validate(), "rule 'label-pods' has an invalid registration: a validating rule can not have a reinvocation-policy")
+}
+
+func TestRegisterHookLimitsTheNamespacesToTheWatchedNamespaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := Server{CompanyDomain: "acme.com", Namespace: "kube-graffiti", Service: "kube-graffiti"}
+	s.SetWatchNamespaces([]string{"team-a", "team-b"})
+	r := Registration{Name: "label-pods", Resources: []string{"pods"}, FailurePolicy: "Ignore", NamespaceSelector: "tier=web"}
+	require.NoError(t, r.Validate())
+	require.NoError(t, s.RegisterHook(r, clientset))
+
+	wc, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-label-pods", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, &metav1.LabelSelector{
+		MatchLabels: map[string]string{"tier": "web"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "kubernetes.io/metadata.name", Operator: metav1.LabelSelectorOpIn, Values: []string{"team-a", "team-b"}},
+		},
+	}, wc.Webhooks[0].NamespaceSelector, "the rule's own namespace selector is kept")
+}
+
+func TestRegisteredConfigurationsAreCalledInPriorityOrder(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := testRegistrationServer()
+	for _, r := range []Registration{
+		{Name: "a-default", Priority: 0},
+		{Name: "z-first", Priority: 100},
+		{Name: "m-last", Priority: -5},
+		{Name: "b-second", Priority: 10},
+		{Name: "a-second", Priority: 10},
+	} {
+		r.Resources, r.FailurePolicy = []string{"pods"}, "Ignore"
+		require.NoError(t, s.RegisterHook(r, clientset))
+	}
+
+	list, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().List(metav1.ListOptions{})
+	require.NoError(t, err)
+	// the apiserver calls the webhooks of the configurations in the order of their names
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+	var called []string
+	for _, config := range list.Items {
+		for _, w := range config.Webhooks {
+			called = append(called, w.Name)
+		}
+	}
+	assert.Equal(t, []string{"z-first.acme.com", "a-second.acme.com", "b-second.acme.com", "a-default.acme.com", "m-last.acme.com"}, called)
+	assert.True(t, Registration{Name: "r", Priority: MaxPriority}.ConfigurationName() < Registration{Name: "r", Priority: MinPriority}.ConfigurationName())
+}
+
+func TestRegisterHookRemovesStaleConfigurations(t *testing.T) {
+	unrelated := testWebhookConfiguration("other")
+	unrelated.Webhooks = append(unrelated.Webhooks, admissionreg.MutatingWebhook{Name: "label-pods.acme.com"})
+	legacy := testWebhookConfiguration("label-pods")
+	legacy.Name = "label-pods"
+	clientset := fake.NewSimpleClientset(unrelated, legacy)
+	client := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	s := testRegistrationServer()
+	r := testRegistration()
+
+	r.Priority = 10
+	require.NoError(t, s.RegisterHook(r, clientset))
+	_, err := client.Get("label-pods", metav1.GetOptions{})
+	assert.Error(t, err, "the configuration registered before names had priorities should be deleted")
+	_, err = client.Get("09989-label-pods", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	r.Priority = 20
+	require.NoError(t, s.RegisterHook(r, clientset))
+	_, err = client.Get("09989-label-pods", metav1.GetOptions{})
+	assert.Error(t, err, "the configuration of the rule's previous priority should be deleted")
+	_, err = client.Get("09979-label-pods", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = client.Get(unrelated.Name, metav1.GetOptions{})
+	assert.NoError(t, err, "configurations with other webhooks are left alone")
+}
@@ -14,21 +14,29 @@ limitations under the License.
 package webhook
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
+	"github.com/Telefonica/kube-graffiti/pkg/audit"
+	"github.com/Telefonica/kube-graffiti/pkg/events"
 	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/healthcheck"
 	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/queue"
+	"github.com/Telefonica/kube-graffiti/pkg/related"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 )
 
 const (
-	componentName = "webhook"
-	pathPrefix    = "/graffiti/"
+	componentName        = "webhook"
+	pathPrefix           = "/graffiti/"
+	validatingPathPrefix = "/graffiti-validate/"
 )
 
 type Server struct {
@@ -38,6 +46,13 @@ type Server struct {
 	CACert        []byte
 	httpServer    *http.Server
 	handler       graffitiHandler
+	registrar     *registrar
+	// admissionVersion is the version of the admissionregistration api that our webhooks are registered with.
+	admissionVersion string
+	// listeners are the extra https listeners, by name, which serve the rules whose registrations name them.
+	listeners map[string]*listener
+	// watchNamespaces limits our webhooks to the objects in these namespaces, and the namespaces, when it is set.
+	watchNamespaces []string
 }
 
 // NewServer creates a new webhook server and sets up the initial graffiti handler.
@@ -62,32 +77,190 @@ func NewServer(cd, ns, svc string, ca []byte, k *kubernetes.Clientset, port int)
 		CACert:        ca,
 		httpServer:    server,
 		handler:       newGraffitiHandler(),
+		listeners:     make(map[string]*listener),
 	}
 }
 
-// AddGraffitiRule provides a way of adding new rules into the http mux and corresponding handler context map
+// SetAuditor sets the auditor that records the decisions made by our rules.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetAuditor(a *audit.Auditor) {
+	s.handler.auditor = a
+}
+
+// SetEventRecorder sets the recorder of kubernetes Events about the objects that our rules patch, block or fail on.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetEventRecorder(r *events.Recorder) {
+	s.handler.events = r
+}
+
+// SetServiceLabeller sets the labeller used by rules that label the services related to the objects they paint.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetServiceLabeller(l *related.ServiceLabeller) {
+	s.handler.services = l
+}
+
+// SetActionQueue sets the queue of payload side effects, which is stopped once the server has shut down.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetActionQueue(q *queue.Queue) {
+	s.handler.actions = q
+}
+
+// SetIgnore sets the namespaces and names of the objects that are always allowed unchanged, whatever the rules match.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetIgnore(i graffiti.Ignore) {
+	s.handler.ignore = i
+}
+
+// SetWatchNamespaces limits the webhooks that we register, and the requests that the rules are asked about, to the
+// objects in the namespaces and those namespaces, so that the objects of other tenants are never changed.  Nil
+// doesn't limit them.  It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetWatchNamespaces(namespaces []string) {
+	s.watchNamespaces = namespaces
+	s.handler.watchNamespaces = namespaces
+}
+
+// SetSourceLimits sets the rate of admission requests accepted from each source.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetSourceLimits(l SourceLimits) {
+	s.handler.limiter = newSourceLimiter(l)
+}
+
+// SetDecisionCache caches the responses of the rules to admission requests, a size of 0 doesn't cache them.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetDecisionCache(c DecisionCache) {
+	s.handler.decisions = newDecisionCache(c)
+}
+
+// SetRequestLimits limits the number of admission requests that are served at once, 0 is unlimited, and how long each
+// request can take, including waiting for a free slot, 0 is no limit.
+// It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetRequestLimits(maxConcurrent int, timeout time.Duration) {
+	s.handler.slots = nil
+	if maxConcurrent > 0 {
+		s.handler.slots = make(chan struct{}, maxConcurrent)
+	}
+	s.handler.timeout = timeout
+	s.httpServer.ReadTimeout = timeout
+}
+
+// SetRequestSizeLimit limits the size of the admission requests that are read, 0 is unlimited, and whether the objects
+// in larger requests are allowed unchanged or denied.  It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetRequestSizeLimit(maxBytes int64, action string) {
+	s.handler.maxBodySize = maxBytes
+	s.handler.oversized = action
+}
+
+// SetRuleErrors sets what happens to the objects of the admission requests that a rule fails to evaluate or patch,
+// one of warn, deny or ignore.  It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetRuleErrors(action string) {
+	s.handler.ruleErrors = action
+}
+
+// SetDebugToken enables explaining the evaluation of a rule in the response headers of the admission requests that
+// carry the token in their debug header.  It must be called before any rules are added with AddGraffitiRule.
+func (s *Server) SetDebugToken(token string) {
+	s.handler.debugToken = token
+}
+
+// AddGraffitiRule provides a way of adding new rules into the http mux and corresponding handler context map.
+// Validating rules are served from their own path so that they can never patch an object.  The rule's matchers are
+// compiled as it is added.
 func (s Server) AddGraffitiRule(rule graffiti.Rule) {
+	s.addGraffitiRule("", rule)
+}
+
+// addGraffitiRule adds a rule to the mux of the named listener, the server's own when the name is empty.
+func (s Server) addGraffitiRule(listener string, rule graffiti.Rule) {
+	rule = rule.Compiled()
+	mux, h := s.serving(listener)
+	var handler http.Handler = h
+	if h.timeout > 0 {
+		handler = http.TimeoutHandler(h, h.timeout, "admission request timed out")
+	}
+	if rule.IsValidating() {
+		path := validatingPathFromName(rule.Name)
+		if h.serve(path) {
+			mux.Handle(path, handler)
+		}
+		s.handler.addRule(path, validatingRule{rule})
+		return
+	}
 	path := pathFromName(rule.Name)
-	mux := s.httpServer.Handler.(*http.ServeMux)
-	mux.Handle(path, s.handler)
+	if h.serve(path) {
+		mux.Handle(path, handler)
+	}
 	s.handler.addRule(path, rule)
+	if rule.StopOnMatch {
+		s.handler.setStopper(rule.Name, stopper{rule: rule})
+	}
+}
+
+// AddRegisteredRule adds a rule in the same way as AddGraffitiRule along with its registration, so that a
+// stop-on-match rule only skips the rules after it for the resources that it is registered for, and the rule is
+// served by the listener that its registration names.
+func (s Server) AddRegisteredRule(r Registration, rule graffiti.Rule) {
+	rule = rule.Compiled()
+	s.addGraffitiRule(r.Listener, rule)
+	if rule.IsValidating() || !rule.StopOnMatch {
+		return
+	}
+	s.handler.setStopper(rule.Name, stopper{rule: rule, targets: r.AllTargets()})
+}
+
+// ReplaceRule serves a new version of a rule, or a new rule, while the server is running.
+func (s Server) ReplaceRule(r Registration, rule graffiti.Rule) {
+	s.handler.removeRule(rule.Name)
+	s.AddRegisteredRule(r, rule)
+}
+
+// RemoveRule stops serving a rule while the server is running, the apiserver's requests for it are then allowed
+// unchanged until its registration is removed.
+func (s Server) RemoveRule(name string) {
+	s.handler.removeRule(name)
 }
 
 // StartWebhookServer starts the webhook server with TLS encryption
 func (s Server) StartWebhookServer(certPath, keyPath string) {
 	mylog := log.ComponentLogger(componentName, "StartWebhookSecureServer")
 	mylog.Debug().Str("certPath", certPath).Str("keyPath", keyPath).Msg("starting the secure webhook http server...")
+	// the self-check's admission reviews are answered by the main server, with the handler as configured by now
+	s.httpServer.Handler.(*http.ServeMux).Handle(healthcheck.SelfTestPath, s.handler)
 
 	// start the webhook server in a new routine
 	go func() {
-		if err := s.httpServer.ListenAndServeTLS(certPath, keyPath); err != nil {
+		if err := s.httpServer.ListenAndServeTLS(certPath, keyPath); err != nil && err != http.ErrServerClosed {
 			mylog.Fatal().Err(err).Msg("failed to start the webhook server")
 		}
 	}()
+	s.startListeners()
 
 	return
 }
 
+// Shutdown gracefully stops the webhook server.  It stops accepting new admission requests and then waits for any
+// in-flight requests to complete, or for the context to expire, whichever happens first.
+func (s Server) Shutdown(ctx context.Context) error {
+	mylog := log.ComponentLogger(componentName, "Shutdown")
+	mylog.Info().Msg("shutting down the webhook server and draining in-flight requests")
+	// stop healing our registrations first, so that they can be removed after we return
+	s.stopRegistrar()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		mylog.Error().Err(err).Msg("webhook server did not shut down cleanly")
+		return err
+	}
+	if err := s.shutdownListeners(ctx); err != nil {
+		mylog.Error().Err(err).Msg("webhook listeners did not shut down cleanly")
+		return err
+	}
+	// now that there are no more requests we can finish any background work and flush any outstanding audit records.
+	s.handler.services.Wait()
+	s.handler.actions.Stop()
+	s.handler.auditor.Stop()
+	s.handler.events.Stop()
+	mylog.Info().Msg("webhook server shut down")
+	return nil
+}
+
 func configTLS(clientset *kubernetes.Clientset) *tls.Config {
 	mylog := log.ComponentLogger(componentName, "configTLS")
 	mylog.Debug().Msg("calling kubernetes api to retrieve the CA certificate")
@@ -127,3 +300,10 @@ func pathFromName(name string) string {
 	mylog.Debug().Str("path", path).Msg("Generated webhook path")
 	return path
 }
+
+func validatingPathFromName(name string) string {
+	mylog := log.ComponentLogger(componentName, "validatingPathFromName")
+	path := validatingPathPrefix + url.PathEscape(name)
+	mylog.Debug().Str("path", path).Msg("Generated validating webhook path")
+	return path
+}
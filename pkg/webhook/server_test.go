@@ -14,8 +14,10 @@ limitations under the License.
 package webhook
 
 import (
+	"net/http"
 	"testing"
 
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,3 +36,33 @@ func TestPathWithSymbols(t *testing.T) {
 func TestPathWithSlashes(t *testing.T) {
 	assert.Equal(t, pathPrefix+"%2Ftest%2Fpath%2Fwith%2Fslashes", pathFromName("/test/path/with/slashes"), "should escape illegal url characters and add prefix")
 }
+
+func TestValidatingPathHasItsOwnPrefix(t *testing.T) {
+	assert.Equal(t, validatingPathPrefix+"testing123", validatingPathFromName("testing123"))
+	assert.Equal(t, "testing123", nameFromPath(validatingPathFromName("testing123")), "should be able to recover the rule name")
+}
+
+func TestRulesCanBeReplacedAndRemovedWhileServing(t *testing.T) {
+	s := Server{httpServer: &http.Server{Handler: http.NewServeMux()}, handler: newGraffitiHandler()}
+	pods := Registration{Resources: []string{"pods"}}
+	s.AddRegisteredRule(pods, graffiti.NewRule("team-a").AddLabels(map[string]string{"team": "a"}).StoppingOnMatch())
+
+	s.ReplaceRule(pods, graffiti.NewRule("team-a").Block())
+	rule, ok := s.handler.rule(pathFromName("team-a"))
+	assert.True(t, ok)
+	assert.True(t, rule.(graffiti.Rule).Payload.Block)
+	assert.Empty(t, s.handler.stoppers, "the replaced rule no longer stops on match")
+
+	s.ReplaceRule(pods, graffiti.NewRule("team-a").Validating())
+	_, ok = s.handler.rule(pathFromName("team-a"))
+	assert.False(t, ok)
+	_, ok = s.handler.rule(validatingPathFromName("team-a"))
+	assert.True(t, ok)
+
+	s.RemoveRule("team-a")
+	_, ok = s.handler.rule(validatingPathFromName("team-a"))
+	assert.False(t, ok)
+	s.AddGraffitiRule(graffiti.NewRule("team-a").Validating())
+	_, ok = s.handler.rule(validatingPathFromName("team-a"))
+	assert.True(t, ok, "a removed rule can be added again")
+}
@@ -0,0 +1,138 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Telefonica/kube-graffiti/pkg/log"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// SourceLimitAlert logs and counts the requests from a source that exceeds its request rate, but still serves them.
+	SourceLimitAlert = "alert"
+	// SourceLimitThrottle rejects the requests from a source that exceeds its request rate.
+	SourceLimitThrottle = "throttle"
+
+	// maxLimitedSources bounds the number of rate limiters, the least recently used are forgotten first.
+	maxLimitedSources = 1000
+	// alertInterval is the least time between warnings about the same source.
+	alertInterval = time.Minute
+)
+
+// SourceLimits controls the rate of admission requests accepted from each source ip address.  The apiserver is
+// normally our only caller, but the webhook port is often reachable from the pod network.
+type SourceLimits struct {
+	// Rate is the number of requests per second allowed from each source, 0 disables the limits.
+	Rate   float64 `mapstructure:"rate" yaml:"rate,omitempty"`
+	Burst  int     `mapstructure:"burst" yaml:"burst,omitempty"`
+	Action string  `mapstructure:"action" yaml:"action,omitempty"`
+}
+
+// Validate checks that the source limits are usable.
+func (l SourceLimits) Validate() error {
+	if l.Rate < 0 || l.Burst < 0 {
+		return fmt.Errorf("server.source-limits rate and burst can not be negative")
+	}
+	switch l.Action {
+	case "", SourceLimitAlert, SourceLimitThrottle:
+	default:
+		return fmt.Errorf("invalid server.source-limits.action '%s', must be one of %s or %s", l.Action, SourceLimitAlert, SourceLimitThrottle)
+	}
+	return nil
+}
+
+// sourceLimiter keeps a rate limiter for each source of admission requests.
+type sourceLimiter struct {
+	sync.Mutex
+	limits  SourceLimits
+	sources map[string]*limitedSource
+}
+
+type limitedSource struct {
+	limiter   *rate.Limiter
+	lastSeen  time.Time
+	lastAlert time.Time
+}
+
+func newSourceLimiter(l SourceLimits) *sourceLimiter {
+	if l.Action == "" {
+		l.Action = SourceLimitAlert
+	}
+	if l.Burst == 0 {
+		l.Burst = 1
+	}
+	return &sourceLimiter{limits: l, sources: make(map[string]*limitedSource)}
+}
+
+// allow accounts for a request and decides whether it should be served.  A nil limiter only accounts for requests.
+func (l *sourceLimiter) allow(r *http.Request) bool {
+	source := requestSource(r)
+	if l == nil || l.limits.Rate == 0 {
+		metrics.Sources.Record(source, false, "")
+		return true
+	}
+
+	now := time.Now()
+	l.Lock()
+	s, ok := l.sources[source]
+	if !ok {
+		l.forgetOldest()
+		s = &limitedSource{limiter: rate.NewLimiter(rate.Limit(l.limits.Rate), l.limits.Burst)}
+		l.sources[source] = s
+	}
+	s.lastSeen = now
+	limited := !s.limiter.AllowN(now, 1)
+	alert := limited && now.Sub(s.lastAlert) >= alertInterval
+	if alert {
+		s.lastAlert = now
+	}
+	l.Unlock()
+
+	metrics.Sources.Record(source, limited, l.limits.Action)
+	if alert {
+		mylog := log.ComponentLogger(componentName, "allow")
+		mylog.Warn().Str("source", source).Float64("rate", l.limits.Rate).Str("action", l.limits.Action).Msg("source is exceeding its admission request rate")
+	}
+	return !limited || l.limits.Action != SourceLimitThrottle
+}
+
+// forgetOldest makes room for a new source, it must be called with the lock held
+func (l *sourceLimiter) forgetOldest() {
+	if len(l.sources) < maxLimitedSources {
+		return
+	}
+	var oldest string
+	for source, s := range l.sources {
+		if oldest == "" || s.lastSeen.Before(l.sources[oldest].lastSeen) {
+			oldest = source
+		}
+	}
+	delete(l.sources, oldest)
+}
+
+// requestSource is the ip address of the caller.
+func requestSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requestFrom(t *testing.T, remote string) *http.Request {
+	req, err := http.NewRequest("POST", "/graffiti/test-rule", nil)
+	require.NoError(t, err)
+	req.RemoteAddr = remote
+	return req
+}
+
+func TestThrottledSourcesAreRejected(t *testing.T) {
+	handler := newGraffitiHandler()
+	handler.limiter = newSourceLimiter(SourceLimits{Rate: 0.001, Burst: 2, Action: SourceLimitThrottle})
+
+	codes := []int{}
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, requestFrom(t, "10.1.2.3:43210"))
+		codes = append(codes, rr.Code)
+	}
+	assert.Equal(t, []int{http.StatusBadRequest, http.StatusBadRequest, http.StatusTooManyRequests}, codes, "only the burst should get past the limiter")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, requestFrom(t, "10.9.9.9:43210"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "other sources have their own limits")
+
+	for _, status := range metrics.Sources.Statuses() {
+		if status.Source == "10.1.2.3" {
+			assert.Equal(t, int64(3), status.Requests)
+			assert.Equal(t, int64(1), status.Limited)
+			assert.NotNil(t, status.LastLimited)
+		}
+	}
+}
+
+func TestAlertingSourcesAreStillServed(t *testing.T) {
+	limiter := newSourceLimiter(SourceLimits{Rate: 0.001, Burst: 1})
+	assert.True(t, limiter.allow(requestFrom(t, "10.1.2.4:1")))
+	assert.True(t, limiter.allow(requestFrom(t, "10.1.2.4:2")), "alert is the default action and never rejects requests")
+
+	var nilLimiter *sourceLimiter
+	assert.True(t, nilLimiter.allow(requestFrom(t, "10.1.2.4:3")), "without limits requests are only accounted for")
+}
+
+func TestSourceLimitsValidation(t *testing.T) {
+	assert.NoError(t, SourceLimits{}.Validate())
+	assert.NoError(t, SourceLimits{Rate: 10, Burst: 20, Action: SourceLimitThrottle}.Validate())
+	assert.EqualError(t, SourceLimits{Action: "drop"}.Validate(), "invalid server.source-limits.action 'drop', must be one of alert or throttle")
+	assert.Error(t, SourceLimits{Rate: -1}.Validate())
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// versionRegex matches kubernetes api versions such as v1, v2beta1 or v1alpha1.
+var versionRegex = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]+)?$`)
+
+// AllTargets returns the registration's targets followed by the targets expanded from its resources shorthand.
+// Shorthand resources are written like kubectl's 'resource.version.group' or 'resource.group', e.g. 'pods',
+// 'deployments.v1.apps' or '*.apps', and those sharing a group and version are gathered into a single target.
+func (r Registration) AllTargets() []Target {
+	targets := append([]Target{}, r.Targets...)
+	index := make(map[string]int)
+	for _, shorthand := range r.Resources {
+		group, version, resource, err := parseResource(shorthand)
+		if err != nil {
+			continue
+		}
+		key := group + "/" + version
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(targets)
+			targets = append(targets, Target{APIGroups: []string{group}, APIVersions: []string{version}})
+			i = len(targets) - 1
+		}
+		switch {
+		case resource == "*":
+			targets[i].Resources = []string{"*"}
+		case len(targets[i].Resources) == 1 && targets[i].Resources[0] == "*":
+		default:
+			targets[i].Resources = append(targets[i].Resources, resource)
+		}
+	}
+	return targets
+}
+
+// Validate checks that the registration's targets are complete and that wildcards are used the way the apiserver allows.
+func (r Registration) Validate() error {
+	for _, shorthand := range r.Resources {
+		if _, _, _, err := parseResource(shorthand); err != nil {
+			return fmt.Errorf("rule '%s' has an invalid registration: %v", r.Name, err)
+		}
+	}
+	var operations []string
+	for _, op := range r.AllOperations() {
+		switch op {
+		case admissionreg.Create, admissionreg.Update, admissionreg.Delete, admissionreg.Connect, admissionreg.OperationAll:
+		default:
+			return fmt.Errorf("rule '%s' has an invalid registration: invalid operation '%s', must be one of CREATE, UPDATE, DELETE, CONNECT or '*'", r.Name, op)
+		}
+		operations = append(operations, string(op))
+	}
+	if err := validateTargetList(operations); err != nil {
+		return fmt.Errorf("rule '%s' has an invalid registration: operations %v", r.Name, err)
+	}
+	if r.TimeoutSeconds < 0 || r.TimeoutSeconds > maxTimeoutSeconds {
+		return fmt.Errorf("rule '%s' has an invalid registration: timeout-seconds must be between 1 and %d", r.Name, maxTimeoutSeconds)
+	}
+	if _, err := r.sideEffects(); err != nil {
+		return fmt.Errorf("rule '%s' has an invalid registration: %v", r.Name, err)
+	}
+	if _, err := r.reinvocationPolicy(); err != nil {
+		return fmt.Errorf("rule '%s' has an invalid registration: %v", r.Name, err)
+	}
+	if r.ReinvocationPolicy != "" && r.IsValidating() {
+		return fmt.Errorf("rule '%s' has an invalid registration: a validating rule can not have a reinvocation-policy", r.Name)
+	}
+	for i, t := range r.AllTargets() {
+		for field, values := range map[string][]string{"api-groups": t.APIGroups, "api-versions": t.APIVersions, "resources": t.Resources} {
+			if err := validateTargetList(values); err != nil {
+				return fmt.Errorf("rule '%s' has an invalid registration: target %d %s %v", r.Name, i+1, field, err)
+			}
+		}
+		for _, resource := range t.Resources {
+			if err := validateSubresource(resource); err != nil {
+				return fmt.Errorf("rule '%s' has an invalid registration: target %d %v", r.Name, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Overlaps is true when the registrations share any resource, ignoring their namespace selectors.
+func (r Registration) Overlaps(other Registration) bool {
+	for _, t := range r.AllTargets() {
+		for _, o := range other.AllTargets() {
+			if targetListsOverlap(t.APIGroups, o.APIGroups) && targetListsOverlap(t.APIVersions, o.APIVersions) && targetListsOverlap(t.Resources, o.Resources) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func targetListsOverlap(a, b []string) bool {
+	for _, v := range b {
+		if targetListCovers(a, v) || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// covers is true when the target includes the resource, or its subresource such as 'pods/status', in the same way as
+// the apiserver: '*' is every resource but none of their subresources, while 'pods/*' and '*/status' are subresources.
+func (t Target) covers(resource metav1.GroupVersionResource, subResource string) bool {
+	if !targetListCovers(t.APIGroups, resource.Group) || !targetListCovers(t.APIVersions, resource.Version) {
+		return false
+	}
+	if subResource == "" {
+		return targetListCovers(t.Resources, resource.Resource)
+	}
+	for _, r := range t.Resources {
+		parts := strings.SplitN(r, "/", 2)
+		if r == "*/*" || (len(parts) == 2 && (parts[0] == "*" || parts[0] == resource.Resource) && (parts[1] == "*" || parts[1] == subResource)) {
+			return true
+		}
+	}
+	return false
+}
+
+func targetListCovers(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTargetList checks a target list in the same way as the apiserver, a wildcard must be the only entry.
+func validateTargetList(values []string) error {
+	if len(values) == 0 {
+		return fmt.Errorf("must not be empty")
+	}
+	if len(values) > 1 {
+		for _, v := range values {
+			if v == "*" {
+				return fmt.Errorf("can not mix the '*' wildcard with other entries")
+			}
+		}
+	}
+	return nil
+}
+
+// validateSubresource checks that a resource is either a resource or a single subresource of one, e.g. 'pods/status'.
+func validateSubresource(resource string) error {
+	parts := strings.Split(resource, "/")
+	if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		return fmt.Errorf("invalid resource '%s', must be a resource or resource/subresource", resource)
+	}
+	return nil
+}
+
+// parseResource splits a shorthand resource into its group, version and resource.  The core group is assumed when
+// there is no group, and all versions when there is no version.
+func parseResource(shorthand string) (group, version, resource string, err error) {
+	parts := strings.SplitN(shorthand, ".", 2)
+	resource = parts[0]
+	if resource == "" {
+		return "", "", "", fmt.Errorf("invalid resource '%s', must be resource, resource.group or resource.version.group", shorthand)
+	}
+	version = "*"
+	if len(parts) == 1 {
+		return "", version, resource, nil
+	}
+
+	rest := strings.SplitN(parts[1], ".", 2)
+	if rest[0] == "*" || versionRegex.MatchString(rest[0]) {
+		version = rest[0]
+		if len(rest) == 1 {
+			return "", version, resource, nil
+		}
+		group = rest[1]
+	} else {
+		group = parts[1]
+	}
+	if group == "" {
+		return "", "", "", fmt.Errorf("invalid resource '%s', must be resource, resource.group or resource.version.group", shorthand)
+	}
+	return group, version, resource, nil
+}
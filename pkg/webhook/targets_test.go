@@ -0,0 +1,83 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAllTargetsExpandsResourceShorthand(t *testing.T) {
+	r := Registration{
+		Name:      "label-workloads",
+		Targets:   []Target{{APIGroups: []string{"batch"}, APIVersions: []string{"v1"}, Resources: []string{"jobs"}}},
+		Resources: []string{"pods", "deployments.v1.apps", "statefulsets.v1.apps", "ingresses.networking.k8s.io", "*.v1beta1.extensions", "daemonsets.v1beta1.extensions"},
+	}
+	assert.Equal(t, []Target{
+		{APIGroups: []string{"batch"}, APIVersions: []string{"v1"}, Resources: []string{"jobs"}},
+		{APIGroups: []string{""}, APIVersions: []string{"*"}, Resources: []string{"pods"}},
+		{APIGroups: []string{"apps"}, APIVersions: []string{"v1"}, Resources: []string{"deployments", "statefulsets"}},
+		{APIGroups: []string{"networking.k8s.io"}, APIVersions: []string{"*"}, Resources: []string{"ingresses"}},
+		{APIGroups: []string{"extensions"}, APIVersions: []string{"v1beta1"}, Resources: []string{"*"}},
+	}, r.AllTargets())
+	assert.NoError(t, r.Validate())
+
+	assert.Equal(t, []Target{{APIGroups: []string{""}, APIVersions: []string{"*"}, Resources: []string{"*"}}}, Registration{Resources: []string{"*"}}.AllTargets())
+}
+
+func TestValidateRegistrationTargets(t *testing.T) {
+	assert.EqualError(t, Registration{Name: "r", Resources: []string{"deployments."}}.Validate(),
+		"rule 'r' has an invalid registration: invalid resource 'deployments.', must be resource, resource.group or resource.version.group")
+	assert.EqualError(t, Registration{Name: "r", Targets: []Target{{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: []string{"*", "pods"}}}}.Validate(),
+		"rule 'r' has an invalid registration: target 1 resources can not mix the '*' wildcard with other entries")
+	assert.EqualError(t, Registration{Name: "r", Targets: []Target{{APIGroups: []string{""}, Resources: []string{"pods"}}}}.Validate(),
+		"rule 'r' has an invalid registration: target 1 api-versions must not be empty")
+	assert.NoError(t, Registration{Name: "r", Resources: []string{"pods/status", "deployments/scale.apps", "pods/*"}}.Validate())
+	assert.EqualError(t, Registration{Name: "r", Resources: []string{"pods/"}}.Validate(),
+		"rule 'r' has an invalid registration: target 1 invalid resource 'pods/', must be a resource or resource/subresource")
+}
+
+func TestTargetsCoverSubresourcesLikeTheApiserver(t *testing.T) {
+	pods := metav1.GroupVersionResource{Version: "v1", Resource: "pods"}
+	target := func(resources ...string) Target {
+		return Target{APIGroups: []string{""}, APIVersions: []string{"v1"}, Resources: resources}
+	}
+	assert.True(t, target("pods").covers(pods, ""))
+	assert.False(t, target("pods").covers(pods, "status"), "a resource doesn't cover its subresources")
+	assert.False(t, target("*").covers(pods, "status"), "'*' is every resource but none of their subresources")
+	assert.True(t, target("pods/status").covers(pods, "status"))
+	assert.False(t, target("pods/status").covers(pods, ""))
+	assert.True(t, target("pods/*").covers(pods, "ephemeralcontainers"))
+	assert.True(t, target("*/status").covers(pods, "status"))
+	assert.True(t, target("*/*").covers(pods, "status"))
+	assert.False(t, target("deployments/status").covers(pods, "status"))
+}
+
+func TestRegisterHookWithResourceShorthand(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := Server{CompanyDomain: "acme.com", Namespace: "kube-graffiti", Service: "kube-graffiti"}
+	r := Registration{Name: "label-workloads", Resources: []string{"pods", "deployments.v1.apps", "statefulsets.v1.apps"}, FailurePolicy: "ignore"}
+	require.NoError(t, s.RegisterHook(r, clientset))
+
+	hook, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-label-workloads", metav1.GetOptions{})
+	require.NoError(t, err)
+	rules := hook.Webhooks[0].Rules
+	require.Len(t, rules, 2)
+	assert.Equal(t, []string{"apps"}, rules[1].APIGroups)
+	assert.Equal(t, []string{"deployments", "statefulsets"}, rules[1].Resources)
+}
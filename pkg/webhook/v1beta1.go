@@ -0,0 +1,226 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	admissionreg "k8s.io/api/admissionregistration/v1"
+	admissionregv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Our webhooks are registered with the admissionregistration v1beta1 api when server.admission-version is v1beta1,
+// for clusters older than kubernetes 1.16.  Its webhooks have the same fields as those of the v1 api, which are the
+// ones that we build, so they are converted through their json.
+
+// convertWebhooks converts webhooks, or lists of them, between the versions of the admissionregistration api.
+func convertWebhooks(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to convert webhook: %v", err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to convert webhook: %v", err)
+	}
+	return nil
+}
+
+func registerMutatingHookV1beta1(r Registration, desired registeredWebhook, clientset kubernetes.Interface, rlog zerolog.Logger) (bool, error) {
+	var webhook admissionregv1beta1.MutatingWebhook
+	if err := convertWebhooks(desired.mutatingWebhook(), &webhook); err != nil {
+		return false, err
+	}
+	client := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	current, err := client.Get(r.ConfigurationName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		webhookConfig := &admissionregv1beta1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: r.ConfigurationName(),
+			},
+			Webhooks: []admissionregv1beta1.MutatingWebhook{webhook},
+		}
+		if _, err := client.Create(webhookConfig); err != nil {
+			rlog.Error().Err(err).Msg("webhook registration failed")
+			return false, registrationError("create", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		rlog.Error().Err(err).Msg("failed to get the webhook")
+		return false, registrationError("get", err)
+	}
+
+	var webhooks []admissionreg.MutatingWebhook
+	if err := convertWebhooks(current.Webhooks, &webhooks); err != nil {
+		return false, err
+	}
+	var registered []registeredWebhook
+	for _, w := range webhooks {
+		registered = append(registered, registeredMutatingWebhook(w))
+	}
+	if !desired.needsUpdate(registered, rlog) {
+		return false, nil
+	}
+	current.Webhooks = []admissionregv1beta1.MutatingWebhook{webhook}
+	if _, err := client.Update(current); err != nil {
+		rlog.Error().Err(err).Msg("webhook registration failed")
+		return false, registrationError("update", err)
+	}
+	return false, nil
+}
+
+func registerValidatingHookV1beta1(r Registration, desired registeredWebhook, clientset kubernetes.Interface, rlog zerolog.Logger) (bool, error) {
+	var webhook admissionregv1beta1.ValidatingWebhook
+	if err := convertWebhooks(desired.validatingWebhook(), &webhook); err != nil {
+		return false, err
+	}
+	client := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+	current, err := client.Get(r.ConfigurationName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		webhookConfig := &admissionregv1beta1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: r.ConfigurationName(),
+			},
+			Webhooks: []admissionregv1beta1.ValidatingWebhook{webhook},
+		}
+		if _, err := client.Create(webhookConfig); err != nil {
+			rlog.Error().Err(err).Msg("webhook registration failed")
+			return false, registrationError("create", err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		rlog.Error().Err(err).Msg("failed to get the webhook")
+		return false, registrationError("get", err)
+	}
+
+	var webhooks []admissionreg.ValidatingWebhook
+	if err := convertWebhooks(current.Webhooks, &webhooks); err != nil {
+		return false, err
+	}
+	var registered []registeredWebhook
+	for _, w := range webhooks {
+		registered = append(registered, registeredValidatingWebhook(w))
+	}
+	if !desired.needsUpdate(registered, rlog) {
+		return false, nil
+	}
+	current.Webhooks = []admissionregv1beta1.ValidatingWebhook{webhook}
+	if _, err := client.Update(current); err != nil {
+		rlog.Error().Err(err).Msg("webhook registration failed")
+		return false, registrationError("update", err)
+	}
+	return false, nil
+}
+
+func getRegisteredWebhooksV1beta1(r Registration, clientset kubernetes.Interface) ([]registeredWebhook, error) {
+	var result []registeredWebhook
+	if r.IsValidating() {
+		config, err := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Get(r.ConfigurationName(), metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		var webhooks []admissionreg.ValidatingWebhook
+		if err := convertWebhooks(config.Webhooks, &webhooks); err != nil {
+			return nil, err
+		}
+		for _, w := range webhooks {
+			result = append(result, registeredValidatingWebhook(w))
+		}
+		return result, nil
+	}
+	config, err := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Get(r.ConfigurationName(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var webhooks []admissionreg.MutatingWebhook
+	if err := convertWebhooks(config.Webhooks, &webhooks); err != nil {
+		return nil, err
+	}
+	for _, w := range webhooks {
+		result = append(result, registeredMutatingWebhook(w))
+	}
+	return result, nil
+}
+
+func deleteHookV1beta1(r Registration, name string, clientset kubernetes.Interface) error {
+	if r.IsValidating() {
+		return clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().Delete(name, nil)
+	}
+	return clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().Delete(name, nil)
+}
+
+func listConfigurationWebhooksV1beta1(r Registration, clientset kubernetes.Interface) (map[string][]string, error) {
+	configurations := make(map[string][]string)
+	if r.IsValidating() {
+		list, err := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations().List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range list.Items {
+			configurations[config.Name] = nil
+			for _, w := range config.Webhooks {
+				configurations[config.Name] = append(configurations[config.Name], w.Name)
+			}
+		}
+		return configurations, nil
+	}
+	list, err := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, config := range list.Items {
+		configurations[config.Name] = nil
+		for _, w := range config.Webhooks {
+			configurations[config.Name] = append(configurations[config.Name], w.Name)
+		}
+	}
+	return configurations, nil
+}
+
+func ignoreHookV1beta1(r Registration, clientset kubernetes.Interface) error {
+	ignore := admissionregv1beta1.Ignore
+	if r.IsValidating() {
+		client := clientset.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+		var webhookConfig *admissionregv1beta1.ValidatingWebhookConfiguration
+		return ignoreFailures(func() (err error) {
+			webhookConfig, err = client.Get(r.ConfigurationName(), metav1.GetOptions{})
+			return err
+		}, func() {
+			for i := range webhookConfig.Webhooks {
+				webhookConfig.Webhooks[i].FailurePolicy = &ignore
+			}
+		}, func() error {
+			_, err := client.Update(webhookConfig)
+			return err
+		})
+	}
+	client := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	var webhookConfig *admissionregv1beta1.MutatingWebhookConfiguration
+	return ignoreFailures(func() (err error) {
+		webhookConfig, err = client.Get(r.ConfigurationName(), metav1.GetOptions{})
+		return err
+	}, func() {
+		for i := range webhookConfig.Webhooks {
+			webhookConfig.Webhooks[i].FailurePolicy = &ignore
+		}
+	}, func() error {
+		_, err := client.Update(webhookConfig)
+		return err
+	})
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package webhook
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregv1beta1 "k8s.io/api/admissionregistration/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWebhooksAreRegisteredWithTheV1beta1Api(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	s := testRegistrationServer()
+	s.SetAdmissionVersion(AdmissionVersionV1beta1)
+	r := testRegistration()
+	r.SideEffects = "Unknown"
+
+	require.NoError(t, s.RegisterHook(r, clientset))
+	client := clientset.AdmissionregistrationV1beta1().MutatingWebhookConfigurations()
+	wc, err := client.Get("09999-label-pods", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, wc.Webhooks, 1)
+	assert.Equal(t, "label-pods.acme.com", wc.Webhooks[0].Name)
+	assert.Equal(t, []string{"v1beta1"}, wc.Webhooks[0].AdmissionReviewVersions)
+	assert.Equal(t, admissionregv1beta1.SideEffectClassUnknown, *wc.Webhooks[0].SideEffects)
+	_, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get("09999-label-pods", metav1.GetOptions{})
+	assert.Error(t, err, "nothing should be registered with the v1 api")
+
+	healed, err := s.ReconcileHook(r, clientset)
+	require.NoError(t, err)
+	assert.False(t, healed, "an unchanged v1beta1 registration is in place")
+
+	ignore := admissionregv1beta1.Ignore
+	wc.Webhooks[0].FailurePolicy = &ignore
+	_, err = client.Update(wc)
+	require.NoError(t, err)
+	healed, err = s.ReconcileHook(r, clientset)
+	require.NoError(t, err)
+	assert.True(t, healed)
+	wc, err = client.Get("09999-label-pods", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, admissionregv1beta1.Fail, *wc.Webhooks[0].FailurePolicy)
+
+	require.NoError(t, s.DeregisterHook(r, ShutdownActionIgnore, clientset))
+	wc, err = client.Get("09999-label-pods", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, admissionregv1beta1.Ignore, *wc.Webhooks[0].FailurePolicy)
+	require.NoError(t, s.DeregisterHook(r, ShutdownActionDelete, clientset))
+	_, err = client.Get("09999-label-pods", metav1.GetOptions{})
+	assert.Error(t, err)
+}
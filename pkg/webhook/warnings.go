@@ -0,0 +1,109 @@
+/*
+Copyright (C) 2018 Expedia Group.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"fmt"
+
+	"github.com/Telefonica/kube-graffiti/pkg/graffiti"
+	"github.com/Telefonica/kube-graffiti/pkg/metrics"
+	admission "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RuleErrorsWarn allows the objects of the admission requests that a rule fails to evaluate or patch unchanged,
+	// and warns the user making the request.
+	RuleErrorsWarn = "warn"
+	// RuleErrorsDeny denies the objects of the admission requests that a rule fails to evaluate or patch.
+	RuleErrorsDeny = "deny"
+	// RuleErrorsIgnore allows the objects of the admission requests that a rule fails to evaluate or patch unchanged,
+	// the failure is only logged and counted.
+	RuleErrorsIgnore = "ignore"
+)
+
+// ValidateRuleErrorsAction checks that the action for rule errors is one of warn, deny or ignore.
+func ValidateRuleErrorsAction(action string) error {
+	switch action {
+	case "", RuleErrorsWarn, RuleErrorsDeny, RuleErrorsIgnore:
+		return nil
+	}
+	return fmt.Errorf("invalid server.rule-errors '%s', must be one of %s, %s or %s", action, RuleErrorsWarn, RuleErrorsDeny, RuleErrorsIgnore)
+}
+
+// The versions of AdmissionReview that the handler understands, their requests and responses are the same apart from
+// the apiVersion, which the response has to repeat.
+const (
+	admissionV1beta1 = "admission.k8s.io/v1beta1"
+	admissionV1      = "admission.k8s.io/v1"
+)
+
+// admissionReview is the AdmissionReview that the handler responds with.  Its response can carry warnings, which
+// kubernetes 1.19 and later show to the user making the request, but the admission api that we build against
+// predates them.
+type admissionReview struct {
+	metav1.TypeMeta `json:",inline"`
+	Response        *admissionResponse `json:"response,omitempty"`
+}
+
+// newAdmissionReview is the response to a review of the apiVersion, which is v1beta1 when the request didn't say.
+func newAdmissionReview(apiVersion string) admissionReview {
+	if apiVersion == "" {
+		apiVersion = admissionV1beta1
+	}
+	return admissionReview{TypeMeta: metav1.TypeMeta{APIVersion: apiVersion, Kind: "AdmissionReview"}}
+}
+
+func supportedReviewVersion(apiVersion string) bool {
+	return apiVersion == "" || apiVersion == admissionV1beta1 || apiVersion == admissionV1
+}
+
+type admissionResponse struct {
+	*admission.AdmissionResponse
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// failed is true when the rule couldn't evaluate or patch the object of the request.
+func failed(resp *admission.AdmissionResponse) bool {
+	if resp == nil || resp.Result == nil {
+		return false
+	}
+	return resp.Result.Reason == metav1.StatusReasonInternalError || resp.Result.Reason == graffiti.StatusReasonPatchBuild
+}
+
+// failureReason is the metrics reason for the response of a rule that failed.
+func failureReason(resp *admission.AdmissionResponse) string {
+	if resp.Result.Reason == graffiti.StatusReasonPatchBuild {
+		return metrics.ReasonPatchBuild
+	}
+	return metrics.ReasonInternalError
+}
+
+// ruleErrorResponse applies the action for rule errors to the response of a rule that failed, it returns the
+// response and any warnings for the user making the request.
+func ruleErrorResponse(action, rule string, resp *admission.AdmissionResponse) (*admission.AdmissionResponse, []string) {
+	switch action {
+	case RuleErrorsIgnore:
+		return resp, nil
+	case RuleErrorsDeny:
+		return &admission.AdmissionResponse{
+			Allowed: false,
+			Result: &metav1.Status{
+				Reason:  metav1.StatusReasonInternalError,
+				Message: fmt.Sprintf("kube-graffiti rule %s failed: %s", rule, resp.Result.Message),
+			},
+		}, nil
+	}
+	return resp, []string{fmt.Sprintf("kube-graffiti rule %s failed and did not change the object: %s", rule, resp.Result.Message)}
+}